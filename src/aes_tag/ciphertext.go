@@ -0,0 +1,105 @@
+package aes_tag
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// The encodings of ciphertexts in the tag verification API
+const (
+	// ENCODING_DECIMAL is a JSON array with a decimal string per byte. It is
+	// deprecated and only accepted from clients which don't negotiate a
+	// binary encoding.
+	ENCODING_DECIMAL = "decimal"
+	// ENCODING_BASE64 is a JSON string in standard padded base64
+	ENCODING_BASE64 = "base64"
+	// ENCODING_HEX is a JSON string of lowercase or uppercase hex
+	ENCODING_HEX = "hex"
+)
+
+// MAX_CIPHERTEXT_SIZE is the max size of a record's ciphertext including
+// its tag: the max TLSCiphertext length of TLS 1.3 (2^14 + 256), which is
+// above the one of AES-GCM in TLS 1.2
+const MAX_CIPHERTEXT_SIZE = 1<<14 + 256
+
+// DecodeCiphertext decodes the JSON value raw as a record's ciphertext
+// (followed by its tag) in encoding. Malformed values, unknown encodings and
+// ciphertexts without a tag or longer than a TLS record are rejected.
+func DecodeCiphertext(raw json.RawMessage, encoding string) ([]byte, error) {
+	var ciphertext []byte
+	switch encoding {
+	case ENCODING_DECIMAL:
+		var byteStrings []string
+		if err := json.Unmarshal(raw, &byteStrings); err != nil {
+			return nil, errors.New("the decimal cipher text must be an array of strings")
+		}
+		var err error
+		if ciphertext, err = CiphertextBytes(byteStrings); err != nil {
+			return nil, fmt.Errorf("unexpected value in cipher text array in tag verification: %w", err)
+		}
+	case ENCODING_BASE64, ENCODING_HEX:
+		var encoded string
+		if err := json.Unmarshal(raw, &encoded); err != nil {
+			return nil, fmt.Errorf("the %s cipher text must be a string", encoding)
+		}
+		var err error
+		if encoding == ENCODING_BASE64 {
+			ciphertext, err = base64.StdEncoding.Strict().DecodeString(encoded)
+		} else {
+			ciphertext, err = hex.DecodeString(encoded)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in cipher text", encoding)
+		}
+	default:
+		return nil, fmt.Errorf("unknown cipher text encoding %q", encoding)
+	}
+	if len(ciphertext) < 16 {
+		return nil, errors.New("the cipher text in tag verification has no tag")
+	}
+	if len(ciphertext) > MAX_CIPHERTEXT_SIZE {
+		return nil, fmt.Errorf("the cipher text in tag verification exceeds %d bytes", MAX_CIPHERTEXT_SIZE)
+	}
+	return ciphertext, nil
+}
+
+// InvalidByteError is returned for the first entry of a ciphertext in
+// ENCODING_DECIMAL which is not a byte
+type InvalidByteError struct {
+	Index int
+	Value string
+}
+
+func (e *InvalidByteError) Error() string {
+	return fmt.Sprintf("invalid ciphertext byte %q at index %d", e.Value, e.Index)
+}
+
+// CiphertextBytes converts a ciphertext given as strings of decimal bytes.
+// Only the canonical form of a byte is accepted: no sign, no whitespace and
+// no leading zeros. It fails with an *InvalidByteError on the first other
+// entry.
+func CiphertextBytes(ciphertext []string) ([]byte, error) {
+	ciphertextBytes := make([]byte, len(ciphertext))
+	for i, byteString := range ciphertext {
+		byteNum, err := strconv.ParseUint(byteString, 10, 8)
+		if err != nil || strconv.FormatUint(byteNum, 10) != byteString {
+			return nil, &InvalidByteError{Index: i, Value: byteString}
+		}
+		ciphertextBytes[i] = byte(byteNum)
+	}
+	return ciphertextBytes, nil
+}
+
+// DecimalCiphertext converts ciphertext into strings of decimal bytes, the
+// form of ENCODING_DECIMAL
+func DecimalCiphertext(ciphertext []byte) []string {
+	byteStrings := make([]string, len(ciphertext))
+	for i, b := range ciphertext {
+		byteStrings[i] = strconv.Itoa(int(b))
+	}
+	return byteStrings
+}
@@ -0,0 +1,46 @@
+package aes_tag
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDecodeCiphertext(t *testing.T) {
+	want := make([]byte, 17)
+	want[16] = 255
+	decimal, _ := json.Marshal(DecimalCiphertext(want))
+	for encoding, raw := range map[string]string{
+		ENCODING_DECIMAL: string(decimal),
+		ENCODING_BASE64:  `"AAAAAAAAAAAAAAAAAAAAAP8="`,
+		ENCODING_HEX:     `"00000000000000000000000000000000FF"`,
+	} {
+		got, err := DecodeCiphertext(json.RawMessage(raw), encoding)
+		if err != nil {
+			t.Fatalf("%s: %s", encoding, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%s: unexpected cipher text %x", encoding, got)
+		}
+	}
+}
+
+func TestDecodeCiphertextRejects(t *testing.T) {
+	long := `"` + strings.Repeat("00", MAX_CIPHERTEXT_SIZE+1) + `"`
+	for _, c := range []struct{ encoding, raw string }{
+		{ENCODING_DECIMAL, `["0","0","0","0","0","0","0","0","0","0","0","0","0","0","0","256"]`},
+		{ENCODING_DECIMAL, `["0","0","0","0","0","0","0","0","0","0","0","0","0","0","0","x"]`},
+		{ENCODING_DECIMAL, `"AAAAAAAAAAAAAAAAAAAAAA=="`},
+		{ENCODING_BASE64, `"AAAAAAAAAAAAAAAAAAAAAA"`},
+		{ENCODING_BASE64, `["0"]`},
+		{ENCODING_HEX, `"0000000000000000000000000000000"`},
+		{ENCODING_HEX, `"000000000000000000000000000000"`},
+		{ENCODING_HEX, long},
+		{"utf8", `"AAAAAAAAAAAAAAAAAAAAAA=="`},
+	} {
+		if _, err := DecodeCiphertext(json.RawMessage(c.raw), c.encoding); err == nil {
+			t.Fatalf("%s %.40s must be rejected", c.encoding, c.raw)
+		}
+	}
+}
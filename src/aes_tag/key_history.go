@@ -0,0 +1,101 @@
+package aes_tag
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"notary/utils"
+	"os"
+	"time"
+)
+
+// SigningKeyInfo is a tag signing key of the key history
+type SigningKeyInfo struct {
+	// Kid is the hex-encoded first 8 bytes of the sha256 of PEM, as the id of
+	// the tag signing key in the key bundle of /getPubKey
+	Kid string `json:"kid"`
+	PEM string `json:"pem"`
+	// ValidFrom and ValidUntil are unix timestamps. ValidUntil is 0 for the
+	// active key.
+	ValidFrom  int64 `json:"validFrom"`
+	ValidUntil int64 `json:"validUntil,omitempty"`
+}
+
+// KeyId returns the kid of a PEM public key
+func KeyId(pubKeyPEM []byte) string {
+	return hex.EncodeToString(utils.Sha256(pubKeyPEM)[:8])
+}
+
+// LoadKeyHistory reads the history of tag signing keys from path and adds
+// the loaded key if it differs from the latest key of the history: the
+// latest key is valid until now and the loaded key from now on. Operators
+// rotate the key by replacing the key file and restarting the notary.
+func (t *TagSigningManager) LoadKeyHistory(path string) error {
+	pubKeyPEM, err := t.PublicKeyPEM()
+	if err != nil {
+		return err
+	}
+	var history []SigningKeyInfo
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, &history)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	kid := KeyId(pubKeyPEM)
+	if len(history) == 0 || history[len(history)-1].Kid != kid {
+		now := time.Now().Unix()
+		if len(history) > 0 {
+			history[len(history)-1].ValidUntil = now
+		}
+		history = append(history, SigningKeyInfo{Kid: kid, PEM: string(pubKeyPEM), ValidFrom: now})
+		data, err = json.Marshal(history)
+		if err != nil {
+			return err
+		}
+		// write to a temp file first so that a crash doesn't leave a
+		// truncated history
+		if err = os.WriteFile(path+".tmp", data, 0644); err != nil {
+			return err
+		}
+		if err = os.Rename(path+".tmp", path); err != nil {
+			return err
+		}
+	}
+	t.history = history
+	return nil
+}
+
+// KeyId returns the kid of the active key
+func (t *TagSigningManager) KeyId() string {
+	pubKeyPEM, err := t.PublicKeyPEM()
+	if err != nil {
+		return ""
+	}
+	return KeyId(pubKeyPEM)
+}
+
+// Keys returns the key history, the active key last. Without a history it
+// only has the active key.
+func (t *TagSigningManager) Keys() []SigningKeyInfo {
+	if len(t.history) > 0 {
+		return t.history
+	}
+	pubKeyPEM, err := t.PublicKeyPEM()
+	if err != nil {
+		return nil
+	}
+	return []SigningKeyInfo{{Kid: KeyId(pubKeyPEM), PEM: string(pubKeyPEM), ValidFrom: t.lastModified.Unix()}}
+}
+
+// keyByKid returns the PEM of the key kid of the history
+func (t *TagSigningManager) keyByKid(kid string) ([]byte, bool) {
+	for _, key := range t.Keys() {
+		if key.Kid == kid {
+			return []byte(key.PEM), true
+		}
+	}
+	return nil, false
+}
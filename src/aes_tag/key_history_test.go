@@ -0,0 +1,60 @@
+package aes_tag
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func newTestSigner(t *testing.T) *TagSigningManager {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &TagSigningManager{signingKey: key}
+}
+
+func TestKeyHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signing-keys.json")
+	old := newTestSigner(t)
+	if err := old.LoadKeyHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	// restarting with the same key doesn't change the history
+	if err := old.LoadKeyHistory(path); err != nil || len(old.Keys()) != 1 {
+		t.Fatal("unexpected history", old.Keys(), err)
+	}
+
+	rotated := newTestSigner(t)
+	if err := rotated.LoadKeyHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	keys := rotated.Keys()
+	if len(keys) != 2 || keys[0].Kid != old.KeyId() || keys[1].Kid != rotated.KeyId() {
+		t.Fatal("unexpected history", keys)
+	}
+	if keys[0].ValidUntil == 0 || keys[1].ValidUntil != 0 {
+		t.Fatal("only the old key must have an end of validity", keys)
+	}
+
+	oldPEM, _ := old.PublicKeyPEM()
+	body, _, err := rotated.PublicKeyResponse(httptest.NewRequest("GET", "/signing-key.pem?kid="+old.KeyId(), nil))
+	if err != nil || string(body) != string(oldPEM) {
+		t.Fatal("the old key must be served by kid", err)
+	}
+	if _, _, err = rotated.PublicKeyResponse(httptest.NewRequest("GET", "/signing-key.pem?kid=00", nil)); !errors.Is(err, ErrUnknownKid) {
+		t.Fatal("an unknown kid must be rejected", err)
+	}
+	body, contentType, err := rotated.PublicKeyResponse(httptest.NewRequest("GET", "/signing-key.pem?format=json", nil))
+	var list struct {
+		Keys []SigningKeyInfo `json:"keys"`
+	}
+	if err != nil || contentType != "application/json" || json.Unmarshal(body, &list) != nil || len(list.Keys) != 2 {
+		t.Fatal("unexpected key list", string(body), err)
+	}
+}
@@ -0,0 +1,81 @@
+package aes_tag
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// MAX_TAG_RECORDS is the max amount of records whose tags are verified in
+// one session. The IV MPC runs once per record.
+const MAX_TAG_RECORDS = 16
+
+// TagRecord is a TLS record whose tag is verified, in the format of the tag
+// verification API
+type TagRecord struct {
+	// Ciphertext is the record's ciphertext followed by its 16 byte tag, see
+	// DecodeCiphertext
+	Ciphertext []byte
+	// AAD is the record's additional data in hex. With TLS 1.2 it is the 13
+	// byte pseudo-header, with TLS 1.3 the 5 byte record header.
+	AAD string
+	// TagShare is the client's share of the tag as a decimal number
+	TagShare string
+	// EncryptedIvShare is the notary's output of the IV MPC for the
+	// record's nonce
+	EncryptedIvShare string
+}
+
+// LengthBlock returns the last block of the GHASH input of a record:
+// len(AAD) | len(ciphertext) in bits as big-endian 64-bit numbers. The
+// ciphertext length excludes the tag.
+func LengthBlock(aadLen int, ciphertextLen int) []byte {
+	block := make([]byte, 16)
+	binary.BigEndian.PutUint64(block[:8], uint64(aadLen)*8)
+	binary.BigEndian.PutUint64(block[8:], uint64(ciphertextLen)*8)
+	return block
+}
+
+// ghashBlocks returns the amount of GHASH input blocks of a record with the
+// given AAD and ciphertext (without tag) lengths, i.e. the powers of H
+// needed to verify its tag
+func ghashBlocks(aadLen int, ciphertextLen int) int {
+	return (aadLen+15)/16 + (ciphertextLen+15)/16 + 1
+}
+
+// checkRecord validates the formats of a record and returns its AAD and
+// the length of its ciphertext without the tag
+func checkRecord(r *TagRecord) ([]byte, int, error) {
+	if len(r.Ciphertext) < 16 {
+		return nil, 0, errors.New("the cipher text in tag verification has no tag")
+	}
+	if len(r.Ciphertext) > MAX_CIPHERTEXT_SIZE {
+		return nil, 0, fmt.Errorf("the cipher text in tag verification exceeds %d bytes", MAX_CIPHERTEXT_SIZE)
+	}
+	aad, err := hex.DecodeString(r.AAD)
+	if err != nil {
+		return nil, 0, errors.New("unexpected AAD format in tag verification")
+	}
+	if err := big.NewInt(0).UnmarshalText([]byte(r.TagShare)); err != nil {
+		return nil, 0, errors.New("unexpected tag share format in tag verification")
+	}
+	if !tagMaskRE.MatchString(r.EncryptedIvShare) {
+		return nil, 0, errors.New("unexpected IV tag mask format in tag verification")
+	}
+	return aad, len(r.Ciphertext) - 16, nil
+}
+
+// checkPowersOfH checks that the powers of H mask covers the GHASH input
+// of every record
+func checkPowersOfH(pohMask string, aadLens []int, ciphertextLens []int) error {
+	powers := strings.Count(pohMask, "\n") + 1
+	for i := range aadLens {
+		if needed := ghashBlocks(aadLens[i], ciphertextLens[i]); needed > powers {
+			return fmt.Errorf("record %d needs %d powers of H, the MPC computed %d", i, needed, powers)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package aes_tag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestLengthBlock(t *testing.T) {
+	block := LengthBlock(13, 100)
+	if binary.BigEndian.Uint64(block[:8]) != 104 || binary.BigEndian.Uint64(block[8:]) != 800 {
+		t.Fatalf("unexpected length block %x", block)
+	}
+}
+
+func TestCheckPowersOfH(t *testing.T) {
+	// 13 byte AAD (1 block) + 32 bytes ciphertext (2 blocks) + length block
+	pohMask := strings.Repeat("01\n", 3) + "01"
+	if err := checkPowersOfH(pohMask, []int{13}, []int{32}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkPowersOfH(pohMask, []int{13, 17}, []int{32, 32}); err == nil {
+		t.Fatal("a record with more blocks than powers of H must be rejected")
+	}
+}
+
+func TestTranscriptVersions(t *testing.T) {
+	record := TranscriptRecord{Ciphertext: make([]byte, 20), AAD: make([]byte, 5), RecordIv: make([]byte, 8)}
+	hash := make([]byte, 32)
+	v1 := (&TagTranscript{Version: TRANSCRIPT_VERSION, Records: []TranscriptRecord{record}, MpcOutputsHash: hash}).Marshal()
+	if len(v1) != 1+4+20+4+5+8+32 || v1[0] != TRANSCRIPT_VERSION {
+		t.Fatalf("unexpected version 1 transcript %x", v1)
+	}
+	v2 := (&TagTranscript{Version: TRANSCRIPT_VERSION_RECORDS, Records: []TranscriptRecord{record, record}, MpcOutputsHash: hash}).Marshal()
+	recordLen := 4 + 20 + 4 + 5 + 8 + 16
+	if len(v2) != 1+2+2*recordLen+32 || v2[0] != TRANSCRIPT_VERSION_RECORDS || binary.BigEndian.Uint16(v2[1:3]) != 2 {
+		t.Fatalf("unexpected version 2 transcript %x", v2)
+	}
+	if !bytes.Equal(v2[3+recordLen-16:3+recordLen], LengthBlock(5, 4)) {
+		t.Fatal("the length block must exclude the tag")
+	}
+	if bytes.Equal(HashMpcOutputsRecords("poh", []string{"a", "b"}), HashMpcOutputsRecords("poh", []string{"ab"})) {
+		t.Fatal("the tag masks must be length-prefixed")
+	}
+}
@@ -2,22 +2,29 @@ package aes_tag
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"log"
 	"net/http"
+	"notary/api_error"
+	"notary/rng_health"
 	"notary/utils"
 	"os"
-	"strconv"
 	"time"
 )
 
 type TagSigningManager struct {
 	signingKey   *ecdsa.PrivateKey
 	lastModified time.Time
+	// Deterministic makes Sign use RFC 6979 nonces instead of random ones
+	Deterministic bool
+	// history are the keys of LoadKeyHistory
+	history []SigningKeyInfo
 }
 
 func NewTagSigningManager(signingKeyPath string) (*TagSigningManager, error) {
@@ -44,41 +51,92 @@ func NewTagSigningManager(signingKeyPath string) (*TagSigningManager, error) {
 
 // Sign returns an ASN.1-encoded ECDSA-SHA256 signature over ciphertext
 func (t *TagSigningManager) Sign(ciphertext []string) ([]byte, error) {
-	ciphertextBytes := make([]byte, 0)
-	// convert strings of decimal bytes into actual bytes for hashing
-	for _, byteString := range ciphertext {
-		byteNum, err := strconv.Atoi(byteString)
-		if err != nil || byteNum < 0 || byteNum > 255 {
-			continue
-		}
-		ciphertextBytes = append(ciphertextBytes, byte(byteNum))
+	// convert strings of decimal bytes into actual bytes for hashing. Any
+	// invalid entry fails the signature, a byte must never be skipped.
+	ciphertextBytes, err := CiphertextBytes(ciphertext)
+	if err != nil {
+		return nil, err
 	}
-	if len(ciphertextBytes) != len(ciphertext) {
-		return nil, errors.New("signing invalid ciphertext failed")
+	return t.SignData(ciphertextBytes)
+}
+
+// SignData returns an ASN.1-encoded ECDSA-SHA256 signature over data. It
+// refuses to sign once the random number generator failed a health test.
+func (t *TagSigningManager) SignData(data []byte) ([]byte, error) {
+	if err := rng_health.Err(); err != nil {
+		return nil, err
 	}
-	digest := utils.Sha256(ciphertextBytes)
+	digest := utils.Sha256(data)
 
+	if t.Deterministic {
+		// RFC 6979, see utils.ECDSASignDeterministic
+		return t.signingKey.Sign(nil, digest, crypto.SHA256)
+	}
 	return ecdsa.SignASN1(rand.Reader, t.signingKey, digest)
 }
 
-func (t *TagSigningManager) ServePublicKey(w http.ResponseWriter, req *http.Request) {
+// PublicKeyPEM returns the public signing key in PEM format
+func (t *TagSigningManager) PublicKeyPEM() ([]byte, error) {
 	if t.signingKey == nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		panic("TagSigningManager: no signing key found")
+		return nil, errors.New("TagSigningManager: no signing key found")
 	}
-
 	derBytes, err := x509.MarshalPKIXPublicKey(&t.signingKey.PublicKey)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		return nil, err
 	}
-	block := &pem.Block{
+	return pem.EncodeToMemory(&pem.Block{
 		Type:  "PUBLIC KEY",
 		Bytes: derBytes,
+	}), nil
+}
+
+// ErrUnknownKid is returned for a kid which is not in the key history
+var ErrUnknownKid = errors.New("unknown signing key id")
+
+// PublicKeyResponse returns the body of /signing-key.pem and its content
+// type: the PEM of the active key, with ?kid= the PEM of that key of the
+// history and with ?format=json the whole history (see Keys)
+func (t *TagSigningManager) PublicKeyResponse(req *http.Request) ([]byte, string, error) {
+	query := req.URL.Query()
+	if query.Get("format") == "json" {
+		body, err := json.Marshal(struct {
+			Keys []SigningKeyInfo `json:"keys"`
+		}{t.Keys()})
+		return body, "application/json", err
+	}
+	if kid := query.Get("kid"); kid != "" {
+		pubKeyPEM, ok := t.keyByKid(kid)
+		if !ok {
+			return nil, "", ErrUnknownKid
+		}
+		return pubKeyPEM, "application/x-pem-file", nil
+	}
+	pubKeyPEM, err := t.PublicKeyPEM()
+	return pubKeyPEM, "application/x-pem-file", err
+}
+
+func (t *TagSigningManager) ServePublicKey(w http.ResponseWriter, req *http.Request) {
+	if t.signingKey == nil {
+		api_error.Write(w, http.StatusInternalServerError, api_error.INTERNAL, "")
+		panic("TagSigningManager: no signing key found")
+	}
+
+	body, contentType, err := t.PublicKeyResponse(req)
+	if errors.Is(err, ErrUnknownKid) {
+		api_error.Write(w, http.StatusNotFound, api_error.NOT_FOUND, err.Error())
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		api_error.Write(w, http.StatusInternalServerError, api_error.INTERNAL, "")
+		return
 	}
-	pubKeyPEM := pem.EncodeToMemory(block)
-	w.Header().Set("Content-Type", "application/x-pem-file")
-	reader := bytes.NewReader(pubKeyPEM)
+	w.Header().Set("Content-Type", contentType)
+	reader := bytes.NewReader(body)
+	// clients must always revalidate the key they trust, but don't need to
+	// download it again if it didn't change
+	w.Header().Set("ETag", utils.ETag(body))
+	w.Header().Set("Cache-Control", "no-cache")
 
 	http.ServeContent(w, req, "signing-key.pem", t.lastModified, reader)
 }
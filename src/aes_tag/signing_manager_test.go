@@ -0,0 +1,40 @@
+package aes_tag
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"notary/utils"
+	"testing"
+)
+
+func TestSign(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := &TagSigningManager{signingKey: key}
+	signature, err := ts.Sign([]string{"0", "1", "255"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(&key.PublicKey, utils.Sha256([]byte{0, 1, 255}), signature) {
+		t.Fatal("the signature must be over the decoded bytes")
+	}
+
+	for _, invalid := range []string{"256", "-1", "+1", " 1", "1 ", "", "01", "0x1", "1e2", "1_0", "٣"} {
+		_, err := ts.Sign([]string{"7", "8", invalid, "9"})
+		var byteErr *InvalidByteError
+		if !errors.As(err, &byteErr) || byteErr.Index != 2 || byteErr.Value != invalid {
+			t.Fatalf("%q must be rejected at index 2, got %v", invalid, err)
+		}
+	}
+
+	// the first invalid entry is reported
+	_, err = ts.Sign([]string{"x", "y"})
+	var byteErr *InvalidByteError
+	if !errors.As(err, &byteErr) || byteErr.Index != 0 {
+		t.Fatal("unexpected error", err)
+	}
+}
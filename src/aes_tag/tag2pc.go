@@ -5,15 +5,18 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"notary/chaos"
 	"time"
 
 	"github.com/summitto/aesmpc"
 )
 
-func checkPortMpcRange(port int) bool {
-	ports := [4]int{port, port + 1, port + 2, port + 3}
+// MPC_PORT_COUNT is the amount of consecutive ports used by each of the tag
+// verification MPCs, starting with its first port
+const MPC_PORT_COUNT = 4
 
-	for _, p := range ports {
+func checkPortMpcRange(port int) bool {
+	for p := port; p < port+MPC_PORT_COUNT; p++ {
 		conn, err := net.DialTimeout("tcp", fmt.Sprintf("0.0.0.0:%d", p), time.Second)
 		if err == nil {
 			conn.Close()
@@ -24,18 +27,31 @@ func checkPortMpcRange(port int) bool {
 	return true
 }
 
-func (t *TagVerificationManager) runEncryptedIvMpc(doneCh chan string, port int, serverKeyShare string, iv string) {
-	tagMask, err := aesmpc.RunGcmEncryptedIvServer(port, t.circuitDir, serverKeyShare, iv)
-	if err != nil {
-		log.Println("MPC IV:", err)
-		doneCh <- ""
-		return
+// runEncryptedIvMpc runs the IV MPC for each of ivs, one after another on
+// the same port, and sends their tag masks to doneCh. It sends nil if one
+// of them fails.
+func (t *TagVerificationManager) runEncryptedIvMpc(doneCh chan []string, port int, serverKeyShare string, ivs []string) {
+	tagMasks := make([]string, len(ivs))
+	for i, iv := range ivs {
+		tagMask, err := aesmpc.RunGcmEncryptedIvServer(port, t.circuitDir, serverKeyShare, iv)
+		if err == nil && chaos.Fail(chaos.MpcKill) {
+			err = errors.New("chaos: MPC killed")
+		}
+		if err != nil {
+			log.Println("MPC IV of record", i, err)
+			doneCh <- nil
+			return
+		}
+		tagMasks[i] = tagMask
 	}
-	doneCh <- tagMask
+	doneCh <- tagMasks
 }
 
 func (t *TagVerificationManager) runPowersOfHMpc(doneCh chan string, port int, serverKeyShare string) {
 	maskedPowersOfH, err := aesmpc.RunGcmPowersOfHServer(port, t.circuitDir, serverKeyShare)
+	if err == nil && chaos.Fail(chaos.MpcKill) {
+		err = errors.New("chaos: MPC killed")
+	}
 	if err != nil {
 		log.Println("MPC PoH:", err)
 		doneCh <- ""
@@ -44,14 +60,14 @@ func (t *TagVerificationManager) runPowersOfHMpc(doneCh chan string, port int, s
 	doneCh <- maskedPowersOfH
 }
 
-func (t *TagVerificationManager) runTagVerificationMpcAsync(serverKeyShare string, iv string, tagMaskResultCh chan string, pohMaskResultCh chan string, startNotifyCh chan bool, errCh chan error) {
+func (t *TagVerificationManager) runTagVerificationMpcAsync(serverKeyShare string, ivs []string, tagMaskResultCh chan []string, pohMaskResultCh chan string, startNotifyCh chan bool, errCh chan error) {
 	errBusy := errors.New("tag verification mpc is busy")
 	if !checkPortMpcRange(t.portIv) || !checkPortMpcRange(t.portPoH) {
 		startNotifyCh <- false
 		errCh <- errBusy
 	}
 
-	go t.runEncryptedIvMpc(tagMaskResultCh, t.portIv, serverKeyShare, iv)
+	go t.runEncryptedIvMpc(tagMaskResultCh, t.portIv, serverKeyShare, ivs)
 	go t.runPowersOfHMpc(pohMaskResultCh, t.portPoH, serverKeyShare)
 
 	startNotifyCh <- true
@@ -1,87 +1,74 @@
 package aes_tag
 
 import (
+	"bytes"
 	"context"
-	"crypto/sha1"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
-	"math/big"
+	"notary/jail"
 	"os"
 	"os/exec"
 	"path"
 	"regexp"
-	"strconv"
 	"time"
 )
 
-func VerifyTag(id string, pohMask string, tagMask string, cipherText []string, aad string, tagShare string) (bool, error) {
-	pohMaskRE := regexp.MustCompilePOSIX("^([01]+\n)+[01]+$")
-	tagMaskRE := regexp.MustCompilePOSIX("^[01]+$")
+var (
+	// pohMaskRE matches the Powers of H mask: a string of 0 and 1 per power
+	pohMaskRE = regexp.MustCompilePOSIX("^([01]+\n)+[01]+$")
+	// tagMaskRE matches the IV tag mask: a string of 0 and 1
+	tagMaskRE = regexp.MustCompilePOSIX("^[01]+$")
+)
 
-	// Verify Powers of H mask as a string of 0 and 1 with line breaks
+// VerifyTag checks the tags of records. It returns true only if the tag of
+// every record is valid. The error is set if the inputs are malformed.
+func VerifyTag(pohMask string, records []TagRecord) (bool, error) {
 	if !pohMaskRE.MatchString(pohMask) {
 		return false, errors.New("unexpected Powers of H mask format in tag verification")
 	}
-
-	// Verify IV tag mask as a string of 0 and 1
-	if !tagMaskRE.MatchString(tagMask) {
-		return false, errors.New("unexpected IV tag mask format in tag verification")
+	if len(records) == 0 || len(records) > MAX_TAG_RECORDS {
+		return false, fmt.Errorf("tag verification needs 1 to %d records", MAX_TAG_RECORDS)
 	}
 
-	// Verify cipher text as a list of strings, where each element is a decimal byte
-	for _, s := range cipherText {
-		if _, err := strconv.ParseUint(s, 10, 8); err != nil {
-			return false, errors.New("unexpected value in cipher text array in tag verification")
+	aadLens := make([]int, len(records))
+	ciphertextLens := make([]int, len(records))
+	inputRecords := make([]verifierRecord, len(records))
+	for i := range records {
+		aad, ciphertextLen, err := checkRecord(&records[i])
+		if err != nil {
+			return false, err
+		}
+		aadLens[i] = len(aad)
+		ciphertextLens[i] = ciphertextLen
+		inputRecords[i] = verifierRecord{
+			EncryptedIvShare: records[i].EncryptedIvShare,
+			Ciphertext:       hex.EncodeToString(records[i].Ciphertext),
+			Aad:              records[i].AAD,
+			LengthBlock:      hex.EncodeToString(LengthBlock(len(aad), ciphertextLen)),
+			TagShare:         records[i].TagShare,
 		}
 	}
-
-	// Verify AAD as a hex string
-	decodedAad, err := hex.DecodeString(aad)
-	if err != nil || len(decodedAad) != hex.DecodedLen(len(aad)) {
-		return false, errors.New("unexpected AAD format in tag verification")
-	}
-
-	// Verify tag share as a big integer
-	if err := big.NewInt(0).UnmarshalText([]byte(tagShare)); err != nil {
-		return false, errors.New("unexpected tag share format in tag verification")
+	if err := checkPowersOfH(pohMask, aadLens, ciphertextLens); err != nil {
+		return false, err
 	}
 
-	// generate a name for temporary storage
-	nameHash := sha1.New()
-	nameHash.Write([]byte(id))
-	name := hex.EncodeToString(nameHash.Sum(nil))
-
 	errInternal := errors.New("internal error in tag verification")
 
-	err = os.MkdirAll(name, 0777)
+	// the inputs are passed on stdin, the dir only serves the sandbox
+	dir, err := os.MkdirTemp("", "verify_tag")
 	if err != nil {
 		log.Println(err)
 		return false, errInternal
 	}
-	defer os.RemoveAll(name)
-
-	pohFilePath := path.Join(name, "poh")
-	eivFilePath := path.Join(name, "eiv")
-	ciphertextFilePath := path.Join(name, "ciphertext")
+	defer os.RemoveAll(dir)
 
-	err = os.WriteFile(pohFilePath, []byte(pohMask), 0666)
-	if err != nil {
-		log.Println(err)
-		return false, errInternal
-	}
-	err = os.WriteFile(eivFilePath, []byte(tagMask), 0666)
-	if err != nil {
-		log.Println(err)
-		return false, errInternal
-	}
-	ciphertextContent, err := json.Marshal(cipherText)
-	if err != nil {
-		log.Println(err)
-		return false, errInternal
-	}
-	err = os.WriteFile(ciphertextFilePath, []byte(ciphertextContent), 0666)
+	input, err := json.Marshal(verifierInput{
+		PowersOfHShare: pohMask,
+		Records:        inputRecords,
+	})
 	if err != nil {
 		log.Println(err)
 		return false, errInternal
@@ -96,10 +83,43 @@ func VerifyTag(id string, pohMask string, tagMask string, cipherText []string, a
 		return false, errInternal
 	}
 
-	cmd := exec.CommandContext(ctx, "python3", path.Join(wd, "src", "verify_tag.py"), pohFilePath, eivFilePath, ciphertextFilePath, aad, tagShare)
-	output, err := cmd.CombinedOutput()
+	cmd := jail.CommandContext(ctx, dir, "python3", path.Join(wd, "src", "verify_tag.py"))
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
 	if err != nil {
-		log.Println("Tag verification error:", string(output), err)
+		log.Println("Tag verification error:", stderr.String(), err)
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return false, nil
+		}
+		return false, errInternal
 	}
-	return err == nil, nil
+	var verdict verifierOutput
+	if err = json.Unmarshal(output, &verdict); err != nil {
+		log.Println("unexpected output of the tag verifier:", string(output), err)
+		return false, errInternal
+	}
+	return verdict.Verified, nil
+}
+
+// verifierInput is written as JSON to the stdin of verify_tag.py
+type verifierInput struct {
+	PowersOfHShare string           `json:"powersOfHShare"`
+	Records        []verifierRecord `json:"records"`
+}
+
+// verifierRecord is a TagRecord with the length block of its GHASH input
+type verifierRecord struct {
+	EncryptedIvShare string `json:"encryptedIvShare"`
+	Ciphertext       string `json:"ciphertext"`
+	Aad              string `json:"aad"`
+	LengthBlock      string `json:"lengthBlock"`
+	TagShare         string `json:"tagShare"`
+}
+
+// verifierOutput is the verdict of verify_tag.py on its stdout
+type verifierOutput struct {
+	Verified bool `json:"verified"`
 }
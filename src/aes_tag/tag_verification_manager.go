@@ -22,7 +22,8 @@ type TagVerificationManager struct {
 	owner     string
 	startTime time.Time
 	pohChan   chan string
-	ivChan    chan string
+	// ivChan receives the tag masks of the records, in order
+	ivChan chan []string
 }
 
 func NewTagVerificationManager(circuitDir string, portIvBegin int, portPoHBegin int) *TagVerificationManager {
@@ -31,11 +32,14 @@ func NewTagVerificationManager(circuitDir string, portIvBegin int, portPoHBegin
 		portIv:     portIvBegin,
 		portPoH:    portPoHBegin,
 		pohChan:    make(chan string, 1),
-		ivChan:     make(chan string, 1),
+		ivChan:     make(chan []string, 1),
 	}
 }
 
-func (t *TagVerificationManager) HandlePrepTagVerification(sessionId string, serverIvShare []byte, serverWriteKeyShare []byte, clientIvShare []byte, recordIv []byte) error {
+// HandlePrepTagVerification starts the MPCs of the tag verification of
+// records with the explicit nonces recordIvs. The powers of H MPC runs once,
+// the IV MPC once per record in the order of recordIvs.
+func (t *TagVerificationManager) HandlePrepTagVerification(sessionId string, serverIvShare []byte, serverWriteKeyShare []byte, clientIvShare []byte, recordIvs [][]byte) error {
 	t.mutex.RLock()
 	busy := t.busy
 	t.mutex.RUnlock()
@@ -69,13 +73,16 @@ func (t *TagVerificationManager) HandlePrepTagVerification(sessionId string, ser
 	}
 
 	// append first 8 bytes of the record to IV to get record nonce
-	nonce := append(recordIV, recordIv...)
-	mpcIV := hex.EncodeToString(nonce) + "00000001"
+	mpcIVs := make([]string, len(recordIvs))
+	for i, recordIv := range recordIvs {
+		nonce := append(append([]byte{}, recordIV...), recordIv...)
+		mpcIVs[i] = hex.EncodeToString(nonce) + "00000001"
+	}
 
 	startNotifyCh := make(chan bool)
 	mpcErrCh := make(chan error)
 
-	go t.runTagVerificationMpcAsync(hex.EncodeToString(serverWriteKeyShare), mpcIV, t.ivChan, t.pohChan, startNotifyCh, mpcErrCh)
+	go t.runTagVerificationMpcAsync(hex.EncodeToString(serverWriteKeyShare), mpcIVs, t.ivChan, t.pohChan, startNotifyCh, mpcErrCh)
 	mpcStarted := <-startNotifyCh
 
 	if !mpcStarted {
@@ -91,7 +98,10 @@ func (t *TagVerificationManager) HandlePrepTagVerification(sessionId string, ser
 	return nil
 }
 
-func (t *TagVerificationManager) HandlePollTagVerificationStatus(sessionId string) (bool, string, string, error) {
+// HandlePollTagVerificationStatus returns whether the MPCs still run and,
+// once they finished, the tag masks of the records and the powers of H
+// mask
+func (t *TagVerificationManager) HandlePollTagVerificationStatus(sessionId string) (bool, []string, string, error) {
 	t.mutex.RLock()
 	busy := t.busy
 	owner := t.owner == sessionId
@@ -101,27 +111,27 @@ func (t *TagVerificationManager) HandlePollTagVerificationStatus(sessionId strin
 	t.mutex.RUnlock()
 
 	if systemOwned {
-		return true, "", "", errors.New("tag verification MPC cannot be started due to misconfiguration")
+		return true, nil, "", errors.New("tag verification MPC cannot be started due to misconfiguration")
 	}
 
 	// only MPC owner can check results
 	if !owner {
-		return busy, "", "", nil
+		return busy, nil, "", nil
 	}
 
 	if !hasIv || !hasPoh {
-		return true, "", "", nil
+		return true, nil, "", nil
 	}
 
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	tagMask := <-t.ivChan
+	tagMasks := <-t.ivChan
 	pohMask := <-t.pohChan
 	t.busy = false
 	t.owner = ""
 
 	log.Println("Tag verification MPC result obtained after", time.Since(t.startTime).String())
 
-	return false, tagMask, pohMask, nil
+	return false, tagMasks, pohMask, nil
 }
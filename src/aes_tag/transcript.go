@@ -0,0 +1,88 @@
+package aes_tag
+
+import (
+	"encoding/binary"
+	"notary/utils"
+)
+
+const (
+	// TRANSCRIPT_VERSION is the first byte of a marshaled TagTranscript of
+	// a single record
+	TRANSCRIPT_VERSION = 1
+	// TRANSCRIPT_VERSION_RECORDS is the first byte of a marshaled
+	// TagTranscript of any amount of records
+	TRANSCRIPT_VERSION_RECORDS = 2
+)
+
+// TagTranscript describes a tag verification. Its signature attests to the
+// exact verification the notary performed, not only to the ciphertext.
+type TagTranscript struct {
+	// Version is TRANSCRIPT_VERSION or TRANSCRIPT_VERSION_RECORDS
+	Version byte
+	Records []TranscriptRecord
+	// MpcOutputsHash is the hash of the notary's outputs of the IV and the
+	// powers of H MPCs, see HashMpcOutputs and HashMpcOutputsRecords
+	MpcOutputsHash []byte
+}
+
+// TranscriptRecord is a record of a TagTranscript
+type TranscriptRecord struct {
+	// Ciphertext includes the tag
+	Ciphertext []byte
+	AAD        []byte
+	// RecordIv is the explicit nonce of the record
+	RecordIv []byte
+}
+
+// HashMpcOutputs returns the SHA-256 of the notary's MPC outputs as
+// len(pohMask)(4) | pohMask | tagMask
+func HashMpcOutputs(pohMask string, tagMask string) []byte {
+	return utils.Sha256(utils.Concat(lengthPrefix(len(pohMask)), []byte(pohMask), []byte(tagMask)))
+}
+
+// HashMpcOutputsRecords is HashMpcOutputs for any amount of records: the
+// SHA-256 of len(pohMask)(4) | pohMask | (len(tagMask)(4) | tagMask)...
+func HashMpcOutputsRecords(pohMask string, tagMasks []string) []byte {
+	parts := [][]byte{lengthPrefix(len(pohMask)), []byte(pohMask)}
+	for _, tagMask := range tagMasks {
+		parts = append(parts, lengthPrefix(len(tagMask)), []byte(tagMask))
+	}
+	return utils.Sha256(utils.Concat(parts...))
+}
+
+// Marshal returns the signed form of the transcript. Version 1 has one
+// record:
+//
+//	version(1) | ciphertextLen(4) | ciphertext | aadLen(4) | aad |
+//	recordIv(8) | mpcOutputsHash(32)
+//
+// version 2 any amount of records, each with the length block of its GHASH
+// input (see LengthBlock):
+//
+//	version(1) | recordCount(2) | (ciphertextLen(4) | ciphertext |
+//	aadLen(4) | aad | recordIv(8) | lengthBlock(16))... | mpcOutputsHash(32)
+//
+// with big-endian lengths
+func (t *TagTranscript) Marshal() []byte {
+	if t.Version == TRANSCRIPT_VERSION {
+		r := t.Records[0]
+		return utils.Concat([]byte{TRANSCRIPT_VERSION}, lengthPrefix(len(r.Ciphertext)), r.Ciphertext,
+			lengthPrefix(len(r.AAD)), r.AAD, r.RecordIv, t.MpcOutputsHash)
+	}
+	recordCount := make([]byte, 2)
+	binary.BigEndian.PutUint16(recordCount, uint16(len(t.Records)))
+	parts := [][]byte{{TRANSCRIPT_VERSION_RECORDS}, recordCount}
+	for _, r := range t.Records {
+		parts = append(parts, lengthPrefix(len(r.Ciphertext)), r.Ciphertext, lengthPrefix(len(r.AAD)), r.AAD,
+			r.RecordIv, LengthBlock(len(r.AAD), len(r.Ciphertext)-16))
+	}
+	parts = append(parts, t.MpcOutputsHash)
+	return utils.Concat(parts...)
+}
+
+// lengthPrefix returns n as 4 big-endian bytes
+func lengthPrefix(n int) []byte {
+	prefix := make([]byte, 4)
+	binary.BigEndian.PutUint32(prefix, uint32(n))
+	return prefix
+}
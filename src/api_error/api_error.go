@@ -0,0 +1,241 @@
+// Package api_error gives the errors of the public API stable codes. The
+// code is sent in the X-Error-Code header next to the HTTP status, while the
+// body keeps its English message (if any) for older clients. Clients map the
+// codes to messages in the user's language with the catalog served at
+// /errors, so the notary never has to guess the language.
+package api_error
+
+import (
+	"encoding/json"
+	"net/http"
+	"notary/utils"
+	"sort"
+	"strings"
+)
+
+// HEADER is the response header with the error code
+const HEADER = "X-Error-Code"
+
+// DEFAULT_LANGUAGE is the language every code has a description in
+const DEFAULT_LANGUAGE = "en"
+
+// Code identifies an error. Codes are never renamed or reused.
+type Code string
+
+const (
+	UNKNOWN_COMMAND              Code = "UNKNOWN_COMMAND"
+	MISSING_SESSION_ID           Code = "MISSING_SESSION_ID"
+	CLIENT_BANNED                Code = "CLIENT_BANNED"
+	RATE_LIMITED                 Code = "RATE_LIMITED"
+	PROTOCOL_VERSION_UNSUPPORTED Code = "PROTOCOL_VERSION_UNSUPPORTED"
+	OT_BUSY                      Code = "OT_BUSY"
+	QUEUED                       Code = "QUEUED"
+	SESSION_NOT_FOUND            Code = "SESSION_NOT_FOUND"
+	SESSION_FAILED               Code = "SESSION_FAILED"
+	OUT_OF_ORDER                 Code = "OUT_OF_ORDER"
+	SLA_EXCEEDED                 Code = "SLA_EXCEEDED"
+	CHEATING_DETECTED            Code = "CHEATING_DETECTED"
+	HANDOFF_FAILED               Code = "HANDOFF_FAILED"
+	METHOD_NOT_ALLOWED           Code = "METHOD_NOT_ALLOWED"
+	INVALID_REQUEST              Code = "INVALID_REQUEST"
+	BLOB_TOO_LARGE               Code = "BLOB_TOO_LARGE"
+	BLOB_INCOMPLETE              Code = "BLOB_INCOMPLETE"
+	BLOB_CHUNK_REJECTED          Code = "BLOB_CHUNK_REJECTED"
+	INVALID_PROOF                Code = "INVALID_PROOF"
+	NOT_FOUND                    Code = "NOT_FOUND"
+	UNAVAILABLE                  Code = "UNAVAILABLE"
+	INTERNAL                     Code = "INTERNAL"
+)
+
+// descriptions are the human-readable descriptions of the codes by language.
+// Every code must have a DEFAULT_LANGUAGE description.
+var descriptions = map[Code]map[string]string{
+	UNKNOWN_COMMAND: {
+		"en": "The notary doesn't know this protocol step.",
+		"de": "Der Notar kennt diesen Protokollschritt nicht.",
+	},
+	MISSING_SESSION_ID: {
+		"en": "The request has no session id.",
+		"de": "Der Anfrage fehlt die Sitzungs-ID.",
+	},
+	CLIENT_BANNED: {
+		"en": "This client was banned by the notary, e.g. after cheating was detected.",
+		"de": "Dieser Client wurde vom Notar gesperrt, z. B. nach einem erkannten Betrugsversuch.",
+	},
+	RATE_LIMITED: {
+		"en": "Too many requests. Try again later.",
+		"de": "Zu viele Anfragen. Bitte später erneut versuchen.",
+	},
+	PROTOCOL_VERSION_UNSUPPORTED: {
+		"en": "The notary doesn't support the protocol version of this client. Update the client.",
+		"de": "Der Notar unterstützt die Protokollversion dieses Clients nicht. Bitte den Client aktualisieren.",
+	},
+	OT_BUSY: {
+		"en": "The notary is busy with other sessions. Try again later.",
+		"de": "Der Notar ist mit anderen Sitzungen ausgelastet. Bitte später erneut versuchen.",
+	},
+	QUEUED: {
+		"en": "The notary is busy with other sessions. You are in the queue and your session starts soon.",
+		"de": "Der Notar ist mit anderen Sitzungen ausgelastet. Sie sind in der Warteschlange und Ihre Sitzung beginnt in Kürze.",
+	},
+	SESSION_NOT_FOUND: {
+		"en": "The session doesn't exist or has expired. Start a new session.",
+		"de": "Die Sitzung existiert nicht oder ist abgelaufen. Bitte eine neue Sitzung starten.",
+	},
+	SESSION_FAILED: {
+		"en": "The session failed and was closed. Start a new session.",
+		"de": "Die Sitzung ist fehlgeschlagen und wurde beendet. Bitte eine neue Sitzung starten.",
+	},
+	OUT_OF_ORDER: {
+		"en": "The client sent a protocol step twice or out of order. The session was closed. Start a new session.",
+		"de": "Der Client hat einen Protokollschritt doppelt oder in falscher Reihenfolge gesendet. Die Sitzung wurde beendet. Bitte eine neue Sitzung starten.",
+	},
+	SLA_EXCEEDED: {
+		"en": "The session took too long and was closed. Start a new session, preferably on a faster connection.",
+		"de": "Die Sitzung hat zu lange gedauert und wurde beendet. Bitte eine neue Sitzung starten, möglichst über eine schnellere Verbindung.",
+	},
+	CHEATING_DETECTED: {
+		"en": "The notary detected invalid data from the client. The session was closed and the client banned.",
+		"de": "Der Notar hat ungültige Daten vom Client erkannt. Die Sitzung wurde beendet und der Client gesperrt.",
+	},
+	HANDOFF_FAILED: {
+		"en": "The session can't be exported or resumed.",
+		"de": "Die Sitzung kann nicht exportiert oder fortgesetzt werden.",
+	},
+	METHOD_NOT_ALLOWED: {
+		"en": "The HTTP method is not allowed for this endpoint.",
+		"de": "Die HTTP-Methode ist für diesen Endpunkt nicht erlaubt.",
+	},
+	INVALID_REQUEST: {
+		"en": "The request is malformed.",
+		"de": "Die Anfrage ist fehlerhaft.",
+	},
+	BLOB_TOO_LARGE: {
+		"en": "The garbled circuits of the session are larger than the notary accepts. The session was closed. Start a new session with a smaller request.",
+		"de": "Die Garbled Circuits der Sitzung sind größer, als der Notar akzeptiert. Die Sitzung wurde beendet. Bitte eine neue Sitzung mit einer kleineren Anfrage starten.",
+	},
+	BLOB_INCOMPLETE: {
+		"en": "The garbled circuits of the session were not uploaded completely. The session was closed. Start a new session and upload them again.",
+		"de": "Die Garbled Circuits der Sitzung wurden nicht vollständig hochgeladen. Die Sitzung wurde beendet. Bitte eine neue Sitzung starten und sie erneut hochladen.",
+	},
+	BLOB_CHUNK_REJECTED: {
+		"en": "The chunk of the garbled circuits was not stored. Send it again at the offset in the X-Blob-Offset header.",
+		"de": "Der Teil der Garbled Circuits wurde nicht gespeichert. Bitte ihn erneut ab dem Offset im Header X-Blob-Offset senden.",
+	},
+	INVALID_PROOF: {
+		"en": "The proof of the claim is invalid.",
+		"de": "Der Beweis der Behauptung ist ungültig.",
+	},
+	NOT_FOUND: {
+		"en": "The requested resource doesn't exist.",
+		"de": "Die angeforderte Ressource existiert nicht.",
+	},
+	UNAVAILABLE: {
+		"en": "The notary is not ready. Try again later.",
+		"de": "Der Notar ist noch nicht bereit. Bitte später erneut versuchen.",
+	},
+	INTERNAL: {
+		"en": "Internal error of the notary.",
+		"de": "Interner Fehler des Notars.",
+	},
+}
+
+// Write writes an error response with the code in the HEADER header.
+// message is the body, which may be empty.
+func Write(w http.ResponseWriter, status int, code Code, message string) {
+	SetCode(w, code)
+	w.WriteHeader(status)
+	if message != "" {
+		w.Write([]byte(message))
+	}
+}
+
+// WriteJSON is Write with a JSON body {"code": code, "error": message}, for
+// clients which read the code from the body
+func WriteJSON(w http.ResponseWriter, status int, code Code, message string) {
+	SetCode(w, code)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Code  Code   `json:"code"`
+		Error string `json:"error,omitempty"`
+	}{code, message})
+}
+
+// SetCode sets the HEADER header, for handlers which write the status
+// themselves
+func SetCode(w http.ResponseWriter, code Code) {
+	w.Header().Set(HEADER, string(code))
+	w.Header().Add("Access-Control-Expose-Headers", HEADER)
+}
+
+// CatalogEntry is one code in the catalog
+type CatalogEntry struct {
+	Code        Code   `json:"code"`
+	Description string `json:"description"`
+	Language    string `json:"language"`
+}
+
+// Catalog returns the descriptions of all codes in the given language,
+// sorted by code. Codes without a description in that language get the
+// DEFAULT_LANGUAGE one.
+func Catalog(language string) []CatalogEntry {
+	language = strings.ToLower(language)
+	entries := make([]CatalogEntry, 0, len(descriptions))
+	for code, byLanguage := range descriptions {
+		entry := CatalogEntry{code, byLanguage[DEFAULT_LANGUAGE], DEFAULT_LANGUAGE}
+		if description, ok := byLanguage[language]; ok {
+			entry.Description = description
+			entry.Language = language
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Code < entries[j].Code
+	})
+	return entries
+}
+
+// Languages returns the languages with at least one description
+func Languages() []string {
+	seen := make(map[string]bool)
+	for _, byLanguage := range descriptions {
+		for language := range byLanguage {
+			seen[language] = true
+		}
+	}
+	languages := make([]string, 0, len(seen))
+	for language := range seen {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// ServeCatalog serves the catalog in the language of the lang query
+// parameter, DEFAULT_LANGUAGE if not given. The Accept-Language header is
+// ignored on purpose: the client decides the language.
+func ServeCatalog(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		Write(w, http.StatusMethodNotAllowed, METHOD_NOT_ALLOWED, "")
+		return
+	}
+	language := req.URL.Query().Get("lang")
+	if language == "" {
+		language = DEFAULT_LANGUAGE
+	}
+	body, err := json.Marshal(struct {
+		Languages []string       `json:"languages"`
+		Errors    []CatalogEntry `json:"errors"`
+	}{Languages(), Catalog(language)})
+	if err != nil {
+		Write(w, http.StatusInternalServerError, INTERNAL, "")
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	if utils.CheckNotModified(w, req, utils.ETag(body), "public, max-age=3600") {
+		return
+	}
+	w.Write(body)
+}
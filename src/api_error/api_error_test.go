@@ -0,0 +1,79 @@
+package api_error
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEveryCodeHasADefaultDescription(t *testing.T) {
+	for code, byLanguage := range descriptions {
+		if byLanguage[DEFAULT_LANGUAGE] == "" {
+			t.Errorf("%s has no %s description", code, DEFAULT_LANGUAGE)
+		}
+	}
+}
+
+func TestCatalogFallback(t *testing.T) {
+	for _, entry := range Catalog("xx") {
+		if entry.Language != DEFAULT_LANGUAGE || entry.Description != descriptions[entry.Code][DEFAULT_LANGUAGE] {
+			t.Fatal("unknown languages must fall back to the default", entry)
+		}
+	}
+	entries := Catalog("DE")
+	if len(entries) != len(descriptions) || entries[0].Language != "de" {
+		t.Fatal("unexpected catalog", entries)
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i-1].Code >= entries[i].Code {
+			t.Fatal("the catalog must be sorted by code")
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Write(rec, http.StatusConflict, OT_BUSY, "OT busy")
+	if rec.Code != http.StatusConflict || rec.Header().Get(HEADER) != "OT_BUSY" || rec.Body.String() != "OT busy" {
+		t.Fatal("unexpected response", rec.Code, rec.Header(), rec.Body.String())
+	}
+	if rec.Header().Get("Access-Control-Expose-Headers") != HEADER {
+		t.Fatal("browsers must be able to read the code")
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	rec := httptest.NewRecorder()
+	WriteJSON(rec, http.StatusBadRequest, INVALID_REQUEST, "init: invalid message")
+	var body struct {
+		Code  Code   `json:"code"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusBadRequest || rec.Header().Get(HEADER) != "INVALID_REQUEST" || body.Code != INVALID_REQUEST || body.Error != "init: invalid message" {
+		t.Fatal("unexpected response", rec.Code, rec.Header(), rec.Body.String())
+	}
+}
+
+func TestServeCatalog(t *testing.T) {
+	rec := httptest.NewRecorder()
+	ServeCatalog(rec, httptest.NewRequest(http.MethodGet, "/errors?lang=de", nil))
+	var catalog struct {
+		Languages []string       `json:"languages"`
+		Errors    []CatalogEntry `json:"errors"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &catalog); err != nil {
+		t.Fatal(err)
+	}
+	if len(catalog.Languages) != 2 || catalog.Errors[0].Language != "de" {
+		t.Fatal("unexpected catalog", catalog)
+	}
+	rec = httptest.NewRecorder()
+	ServeCatalog(rec, httptest.NewRequest(http.MethodPost, "/errors", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get(HEADER) != string(METHOD_NOT_ALLOWED) {
+		t.Fatal("expected METHOD_NOT_ALLOWED")
+	}
+}
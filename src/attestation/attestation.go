@@ -0,0 +1,270 @@
+// Package attestation verifies the attestations which the notary signs in
+// commitHash for clients with protocol version 16 and later. It only uses
+// the standard library so that it can be imported as
+// github.com/summitto/tlsnotaryserver/attestation or copied.
+//
+// An attestation is the JSON {"document", "signature", "notaryKeyData"}
+// which follows the key shares in the commitHash response.
+// Verify checks the signature of the ephemeral key over the document, the
+// certificate of that key by the master key, that the key was valid at the
+// time of the document and that the fields of the document are well-formed.
+// The signatures of tags are verified with package tag_signature.
+package attestation
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+const (
+	// VERSION is the version of Document which this package verifies
+	VERSION = 1
+	// MIN_PROTOCOL_VERSION is the first protocol version with attestation
+	// documents
+	MIN_PROTOCOL_VERSION = 16
+)
+
+// KEY_DATA_SIZE is the size of validFrom(4) | validUntil(4) | pubkey(65) |
+// signature(64) of an ephemeral key
+const KEY_DATA_SIZE = 137
+
+var (
+	// ErrInvalidSignature is returned when a signature doesn't match
+	ErrInvalidSignature = errors.New("invalid attestation signature")
+	// ErrKeyNotValid is returned when the ephemeral key wasn't valid at the
+	// time of the document
+	ErrKeyNotValid = errors.New("the ephemeral key wasn't valid at the time of the attestation")
+	// ErrInvalidDocument is returned for malformed attestations
+	ErrInvalidDocument = errors.New("invalid attestation document")
+)
+
+// Signed is an attestation as sent by the notary
+type Signed struct {
+	// Document is the JSON of a Document, as signed
+	Document json.RawMessage `json:"document"`
+	// Signature is r | s of the ephemeral key over the SHA-256 of Document,
+	// in hex
+	Signature string `json:"signature"`
+	// NotaryKeyData is the ephemeral key certified by the master key, in hex
+	// (see ParseKeyData)
+	NotaryKeyData string `json:"notaryKeyData"`
+}
+
+// Document is the signed attestation document. Binary values are
+// hex-encoded.
+type Document struct {
+	Version         int `json:"version"`
+	ProtocolVersion int `json:"protocolVersion"`
+	// NotaryKeyId is the id of the ephemeral key, see KeyData.Id
+	NotaryKeyId string `json:"notaryKeyId"`
+	// Timestamp is the unix time of the signature
+	Timestamp int64 `json:"timestamp"`
+	// ServerPubkey is the server's ECDHE public key
+	ServerPubkey string `json:"serverPubkey"`
+	// CommitHash is the client's commitment to the server's response
+	CommitHash     string         `json:"commitHash"`
+	KeyShareHashes KeyShareHashes `json:"keyShareHashes"`
+	// GhashInputsHash is the SHA-256 of the GHASH inputs of the request
+	GhashInputsHash string `json:"ghashInputsHash"`
+	// OtStatsDigest is the digest of the OT traffic of the session
+	OtStatsDigest string `json:"otStatsDigest"`
+	// SignatureScheme is "rfc6979" or "randomized"
+	SignatureScheme    string       `json:"signatureScheme"`
+	AttestationCounter uint64       `json:"attestationCounter"`
+	Metrics            *Metrics     `json:"metrics,omitempty"`
+	Commitments        []Commitment `json:"commitments,omitempty"`
+}
+
+// KeyShareHashes are the hashes of the client's shares of the TLS keys
+type KeyShareHashes struct {
+	ClientWriteKey string `json:"clientWriteKey"`
+	ClientWriteIv  string `json:"clientWriteIv"`
+	ServerWriteKey string `json:"serverWriteKey"`
+	ServerWriteIv  string `json:"serverWriteIv"`
+}
+
+// Metrics are the optional session metrics of a Document
+type Metrics struct {
+	ProtocolVersion int    `json:"protocolVersion"`
+	GarblingScheme  string `json:"garblingScheme"`
+	DurationSeconds int64  `json:"durationSeconds"`
+	RequestBlocks   int    `json:"requestBlocks"`
+	GhashBlocks     int    `json:"ghashBlocks"`
+	Ot              *struct {
+		Rounds        int   `json:"rounds"`
+		Requests      int   `json:"requests"`
+		RequestBytes  int64 `json:"requestBytes"`
+		Responses     int   `json:"responses"`
+		ResponseBytes int64 `json:"responseBytes"`
+	} `json:"ot,omitempty"`
+}
+
+// Commitment is a typed commitment of the client
+type Commitment struct {
+	Purpose   byte   `json:"purpose"`
+	Algorithm byte   `json:"algorithm"`
+	Value     string `json:"value"`
+}
+
+// KeyData is an ephemeral key certified by the master key
+type KeyData struct {
+	ValidFrom  time.Time
+	ValidUntil time.Time
+	PublicKey  *ecdsa.PublicKey
+	// Id is the hex-encoded first 8 bytes of the sha256 of the uncompressed
+	// public key, as in the key bundle of /getPubKey
+	Id string
+}
+
+// ParsePublicKeyPEM parses a PEM public key, e.g. the master key of the key
+// bundle of /getPubKey
+func ParsePublicKeyPEM(data []byte) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("no PEM public key found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ecdsaKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("the key is not an ECDSA key")
+	}
+	return ecdsaKey, nil
+}
+
+// ParseKeyData parses validFrom(4) | validUntil(4) | pubkey(65) |
+// signature(64) and checks the signature of masterKey over the first 73
+// bytes
+func ParseKeyData(b []byte, masterKey *ecdsa.PublicKey) (*KeyData, error) {
+	if len(b) != KEY_DATA_SIZE {
+		return nil, fmt.Errorf("%w: key data must have %d bytes", ErrInvalidDocument, KEY_DATA_SIZE)
+	}
+	if !verify(masterKey, b[73:], b[:73]) {
+		return nil, fmt.Errorf("%w: the ephemeral key isn't signed by the master key", ErrInvalidSignature)
+	}
+	pubkey := b[8:73]
+	if pubkey[0] != 0x04 {
+		return nil, fmt.Errorf("%w: the ephemeral key is not uncompressed", ErrInvalidDocument)
+	}
+	x := new(big.Int).SetBytes(pubkey[1:33])
+	y := new(big.Int).SetBytes(pubkey[33:])
+	curve := elliptic.P256()
+	if x.Cmp(curve.Params().P) >= 0 || y.Cmp(curve.Params().P) >= 0 || !curve.IsOnCurve(x, y) {
+		return nil, fmt.Errorf("%w: the ephemeral key is not a P-256 point", ErrInvalidDocument)
+	}
+	id := sha256.Sum256(pubkey)
+	return &KeyData{
+		ValidFrom:  time.Unix(int64(binary.BigEndian.Uint32(b[0:4])), 0),
+		ValidUntil: time.Unix(int64(binary.BigEndian.Uint32(b[4:8])), 0),
+		PublicKey:  &ecdsa.PublicKey{Curve: curve, X: x, Y: y},
+		Id:         hex.EncodeToString(id[:8]),
+	}, nil
+}
+
+// Verify verifies the JSON attestation signed with an ephemeral key of
+// masterKey and returns its document.
+func Verify(signedJSON []byte, masterKey *ecdsa.PublicKey) (*Document, error) {
+	signed := new(Signed)
+	if err := json.Unmarshal(signedJSON, signed); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidDocument, err)
+	}
+	return signed.Verify(masterKey)
+}
+
+// Verify verifies the attestation signed with an ephemeral key of masterKey
+// and returns its document.
+func (s *Signed) Verify(masterKey *ecdsa.PublicKey) (*Document, error) {
+	keyDataBytes, err := hex.DecodeString(s.NotaryKeyData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: notaryKeyData is not hex", ErrInvalidDocument)
+	}
+	keyData, err := ParseKeyData(keyDataBytes, masterKey)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := hex.DecodeString(s.Signature)
+	if err != nil || !verify(keyData.PublicKey, signature, s.Document) {
+		return nil, ErrInvalidSignature
+	}
+
+	// the signature is checked before the document is parsed
+	doc := new(Document)
+	if err = json.Unmarshal(s.Document, doc); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidDocument, err)
+	}
+	if err = doc.check(keyData); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// check checks the fields of doc signed with the key of keyData
+func (doc *Document) check(keyData *KeyData) error {
+	if doc.Version != VERSION {
+		return fmt.Errorf("%w: unknown version %d", ErrInvalidDocument, doc.Version)
+	}
+	if doc.ProtocolVersion < MIN_PROTOCOL_VERSION {
+		return fmt.Errorf("%w: protocol version %d has no attestation documents", ErrInvalidDocument, doc.ProtocolVersion)
+	}
+	if doc.NotaryKeyId != keyData.Id {
+		return fmt.Errorf("%w: notaryKeyId %q is not the id of the signing key", ErrInvalidDocument, doc.NotaryKeyId)
+	}
+	timestamp := time.Unix(doc.Timestamp, 0)
+	if timestamp.Before(keyData.ValidFrom) || timestamp.After(keyData.ValidUntil) {
+		return ErrKeyNotValid
+	}
+	if doc.SignatureScheme != "randomized" && doc.SignatureScheme != "rfc6979" {
+		return fmt.Errorf("%w: unknown signature scheme %q", ErrInvalidDocument, doc.SignatureScheme)
+	}
+	hashes := []struct {
+		name  string
+		value string
+	}{
+		{"commitHash", doc.CommitHash},
+		{"keyShareHashes.clientWriteKey", doc.KeyShareHashes.ClientWriteKey},
+		{"keyShareHashes.clientWriteIv", doc.KeyShareHashes.ClientWriteIv},
+		{"keyShareHashes.serverWriteKey", doc.KeyShareHashes.ServerWriteKey},
+		{"keyShareHashes.serverWriteIv", doc.KeyShareHashes.ServerWriteIv},
+		{"ghashInputsHash", doc.GhashInputsHash},
+		{"otStatsDigest", doc.OtStatsDigest},
+	}
+	for _, hash := range hashes {
+		if b, err := hex.DecodeString(hash.value); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("%w: %s must be 32 bytes in hex", ErrInvalidDocument, hash.name)
+		}
+	}
+	if b, err := hex.DecodeString(doc.ServerPubkey); err != nil || len(b) == 0 {
+		return fmt.Errorf("%w: serverPubkey must be hex", ErrInvalidDocument)
+	}
+	for i, c := range doc.Commitments {
+		if _, err := hex.DecodeString(c.Value); err != nil {
+			return fmt.Errorf("%w: the value of commitment %d must be hex", ErrInvalidDocument, i)
+		}
+	}
+	return nil
+}
+
+// verify checks the r | s signature of key over the SHA-256 of the
+// concatenation of parts
+func verify(key *ecdsa.PublicKey, signature []byte, parts ...[]byte) bool {
+	if len(signature) != 64 {
+		return false
+	}
+	digest := sha256.Sum256(bytes.Join(parts, nil))
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	return ecdsa.Verify(key, digest[:], r, s)
+}
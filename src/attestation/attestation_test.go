@@ -0,0 +1,162 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func sign(t *testing.T, key *ecdsa.PrivateKey, parts ...[]byte) []byte {
+	digest := sha256.Sum256(bytes.Join(parts, nil))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signature
+}
+
+func pubkeyBytes(key *ecdsa.PrivateKey) []byte {
+	b := make([]byte, 65)
+	b[0] = 0x04
+	key.X.FillBytes(b[1:33])
+	key.Y.FillBytes(b[33:])
+	return b
+}
+
+func pemKey(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// notary signs attestations like the notary with an ephemeral key certified
+// by master
+type notary struct {
+	master, ephemeral *ecdsa.PrivateKey
+	keyData           []byte
+}
+
+func newNotary(t *testing.T, validFrom time.Time) *notary {
+	n := &notary{master: newKey(t), ephemeral: newKey(t)}
+	validity := make([]byte, 8)
+	binary.BigEndian.PutUint32(validity[:4], uint32(validFrom.Unix()))
+	binary.BigEndian.PutUint32(validity[4:], uint32(validFrom.Add(20*time.Minute).Unix()))
+	certified := append(validity, pubkeyBytes(n.ephemeral)...)
+	n.keyData = append(certified, sign(t, n.master, certified)...)
+	return n
+}
+
+func (n *notary) document(timestamp time.Time) *Document {
+	hash := strings.Repeat("ab", 32)
+	id := sha256.Sum256(pubkeyBytes(n.ephemeral))
+	return &Document{
+		Version:         VERSION,
+		ProtocolVersion: MIN_PROTOCOL_VERSION,
+		NotaryKeyId:     hex.EncodeToString(id[:8]),
+		Timestamp:       timestamp.Unix(),
+		ServerPubkey:    "04" + hash + hash,
+		CommitHash:      hash,
+		KeyShareHashes:  KeyShareHashes{hash, hash, hash, hash},
+		GhashInputsHash: hash,
+		OtStatsDigest:   hash,
+		SignatureScheme: "randomized",
+		Commitments:     []Commitment{{Purpose: 1, Algorithm: 1, Value: "00"}},
+	}
+}
+
+func (n *notary) sign(t *testing.T, doc *Document) *Signed {
+	document, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Signed{
+		Document:      document,
+		Signature:     hex.EncodeToString(sign(t, n.ephemeral, document)),
+		NotaryKeyData: hex.EncodeToString(n.keyData),
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	n := newNotary(t, now.Add(-time.Minute))
+	masterKey, err := ParsePublicKeyPEM(pemKey(t, n.master))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedJSON, err := json.Marshal(n.sign(t, n.document(now)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := Verify(signedJSON, masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Timestamp != now.Unix() || len(doc.Commitments) != 1 {
+		t.Fatalf("unexpected document %+v", doc)
+	}
+
+	if _, err = Verify(signedJSON, &newKey(t).PublicKey); !errors.Is(err, ErrInvalidSignature) {
+		t.Error("a key of another master key must be rejected:", err)
+	}
+	tampered := n.sign(t, n.document(now))
+	tampered.Document = bytes.Replace(tampered.Document, []byte(`"version":1`), []byte(`"version":2`), 1)
+	if _, err = tampered.Verify(masterKey); !errors.Is(err, ErrInvalidSignature) {
+		t.Error("a modified document must be rejected:", err)
+	}
+	if _, err = n.sign(t, n.document(now.Add(time.Hour))).Verify(masterKey); !errors.Is(err, ErrKeyNotValid) {
+		t.Error("a document after the validity of the key must be rejected:", err)
+	}
+	doc = n.document(now)
+	doc.NotaryKeyId = "0000000000000000"
+	if _, err = n.sign(t, doc).Verify(masterKey); !errors.Is(err, ErrInvalidDocument) {
+		t.Error("a wrong notaryKeyId must be rejected:", err)
+	}
+	doc = n.document(now)
+	doc.CommitHash = "ab"
+	if _, err = n.sign(t, doc).Verify(masterKey); !errors.Is(err, ErrInvalidDocument) {
+		t.Error("a short commitHash must be rejected:", err)
+	}
+}
+
+func TestParseKeyData(t *testing.T) {
+	n := newNotary(t, time.Unix(1700000000, 0))
+	keyData, err := ParseKeyData(n.keyData, &n.master.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyData.ValidFrom.Unix() != 1700000000 || keyData.PublicKey.X.Cmp(n.ephemeral.X) != 0 {
+		t.Fatalf("unexpected key data %+v", keyData)
+	}
+	// a point which is not on the curve, with a valid signature
+	bad := append([]byte{}, n.keyData[:73]...)
+	new(big.Int).Add(n.ephemeral.Y, big.NewInt(1)).FillBytes(bad[41:73])
+	bad = append(bad, sign(t, n.master, bad)...)
+	if _, err = ParseKeyData(bad, &n.master.PublicKey); !errors.Is(err, ErrInvalidDocument) {
+		t.Error("an invalid point must be rejected:", err)
+	}
+}
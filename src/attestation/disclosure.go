@@ -0,0 +1,203 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// The request commitment lets a client reveal some blocks of its request
+// and hide the others, e.g. an Authorization header. The client commits to
+// a Merkle tree of its request, cut into 16-byte blocks (the last one may be
+// shorter), in the commitment with purpose COMMITMENT_REQUEST_BLOCKS. A leaf
+// is SHA-256(0x00 | index(4, big-endian) | salt(16) | block) with a random
+// salt per block, so that the hidden blocks can't be guessed from their
+// hashes, and an inner node is SHA-256(0x01 | left | right). The tree has
+// the shape of RFC 6962. The value of the commitment is blockCount(4,
+// big-endian) | root(32).
+const (
+	// COMMITMENT_REQUEST_BLOCKS is the purpose of the request commitment
+	COMMITMENT_REQUEST_BLOCKS = 4
+	// COMMITMENT_ALG_BLOCK_MERKLE_SHA256 is the algorithm of the request
+	// commitment
+	COMMITMENT_ALG_BLOCK_MERKLE_SHA256 = 2
+	// BLOCK_SIZE is the size of the blocks of the request
+	BLOCK_SIZE = 16
+	// SALT_SIZE is the size of the salt of a block
+	SALT_SIZE = 16
+)
+
+// ErrInvalidDisclosure is returned when a disclosed block isn't part of the
+// committed request
+var ErrInvalidDisclosure = errors.New("invalid disclosure")
+
+// DisclosedBlock is a revealed block of the request with its inclusion
+// proof. The binary values are hex-encoded.
+type DisclosedBlock struct {
+	Index uint32 `json:"index"`
+	Block string `json:"block"`
+	Salt  string `json:"salt"`
+	// Proof is the audit path from the leaf up
+	Proof []string `json:"proof"`
+}
+
+// BlockLeafHash returns the hash of the leaf of a block
+func BlockLeafHash(index uint32, salt, block []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	binary.Write(h, binary.BigEndian, index)
+	h.Write(salt)
+	h.Write(block)
+	return h.Sum(nil)
+}
+
+func blockNodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// BlockTree is the Merkle tree of a request, which a client builds to
+// commit to its request and to prove blocks of it
+type BlockTree struct {
+	leaves [][]byte
+}
+
+// NewBlockTree returns the tree of the blocks of request with a salt for
+// each block
+func NewBlockTree(request []byte, salts [][]byte) (*BlockTree, error) {
+	count := (len(request) + BLOCK_SIZE - 1) / BLOCK_SIZE
+	if count == 0 || len(salts) != count {
+		return nil, errors.New("one salt per block of a non-empty request is needed")
+	}
+	t := &BlockTree{}
+	for i := 0; i < count; i++ {
+		if len(salts[i]) != SALT_SIZE {
+			return nil, errors.New("salts must be 16 bytes")
+		}
+		end := (i + 1) * BLOCK_SIZE
+		if end > len(request) {
+			end = len(request)
+		}
+		t.leaves = append(t.leaves, BlockLeafHash(uint32(i), salts[i], request[i*BLOCK_SIZE:end]))
+	}
+	return t, nil
+}
+
+// Commitment returns the value of the request commitment,
+// blockCount(4) | root(32)
+func (t *BlockTree) Commitment() []byte {
+	value := make([]byte, 4, 4+sha256.Size)
+	binary.BigEndian.PutUint32(value, uint32(len(t.leaves)))
+	return append(value, subtreeHash(t.leaves)...)
+}
+
+// Proof returns the audit path of block index
+func (t *BlockTree) Proof(index uint32) [][]byte {
+	var path [][]byte
+	leaves, m := t.leaves, int(index)
+	for len(leaves) > 1 {
+		k := splitSize(len(leaves))
+		if m < k {
+			path = append([][]byte{subtreeHash(leaves[k:])}, path...)
+			leaves = leaves[:k]
+		} else {
+			path = append([][]byte{subtreeHash(leaves[:k])}, path...)
+			leaves = leaves[k:]
+			m -= k
+		}
+	}
+	return path
+}
+
+// splitSize returns the largest power of two below n, for n > 1
+func splitSize(n int) int {
+	k := 1
+	for k*2 < n {
+		k *= 2
+	}
+	return k
+}
+
+func subtreeHash(leaves [][]byte) []byte {
+	if len(leaves) == 1 {
+		return leaves[0]
+	}
+	k := splitSize(len(leaves))
+	return blockNodeHash(subtreeHash(leaves[:k]), subtreeHash(leaves[k:]))
+}
+
+// RequestCommitment returns the block count and the root of the request
+// commitment of the document
+func (doc *Document) RequestCommitment() (uint32, []byte, error) {
+	for _, c := range doc.Commitments {
+		if c.Purpose != COMMITMENT_REQUEST_BLOCKS {
+			continue
+		}
+		value, err := hex.DecodeString(c.Value)
+		if err != nil || c.Algorithm != COMMITMENT_ALG_BLOCK_MERKLE_SHA256 || len(value) != 4+sha256.Size {
+			return 0, nil, fmt.Errorf("%w: malformed request commitment", ErrInvalidDocument)
+		}
+		return binary.BigEndian.Uint32(value[:4]), value[4:], nil
+	}
+	return 0, nil, fmt.Errorf("%w: the document has no request commitment", ErrInvalidDisclosure)
+}
+
+// VerifyDisclosure checks that the disclosed blocks are blocks of the
+// request committed to in the document. The document must have been
+// verified.
+func (doc *Document) VerifyDisclosure(blocks []DisclosedBlock) error {
+	count, root, err := doc.RequestCommitment()
+	if err != nil {
+		return err
+	}
+	for _, b := range blocks {
+		block, err1 := hex.DecodeString(b.Block)
+		salt, err2 := hex.DecodeString(b.Salt)
+		if err1 != nil || err2 != nil || len(salt) != SALT_SIZE || b.Index >= count {
+			return fmt.Errorf("%w: malformed block %d", ErrInvalidDisclosure, b.Index)
+		}
+		// only the last block may be shorter
+		if len(block) == 0 || len(block) > BLOCK_SIZE || (len(block) < BLOCK_SIZE && b.Index != count-1) {
+			return fmt.Errorf("%w: block %d has a wrong size", ErrInvalidDisclosure, b.Index)
+		}
+		proof := make([][]byte, len(b.Proof))
+		for i, p := range b.Proof {
+			if proof[i], err = hex.DecodeString(p); err != nil || len(proof[i]) != sha256.Size {
+				return fmt.Errorf("%w: malformed proof of block %d", ErrInvalidDisclosure, b.Index)
+			}
+		}
+		if !verifyBlockInclusion(BlockLeafHash(b.Index, salt, block), b.Index, count, proof, root) {
+			return fmt.Errorf("%w: block %d is not in the committed request", ErrInvalidDisclosure, b.Index)
+		}
+	}
+	return nil
+}
+
+// verifyBlockInclusion checks an audit path as in RFC 9162, 2.1.3.2
+func verifyBlockInclusion(leafHash []byte, index, size uint32, proof [][]byte, root []byte) bool {
+	fn, sn := index, size-1
+	r := leafHash
+	for _, p := range proof {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = blockNodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = blockNodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(r, root)
+}
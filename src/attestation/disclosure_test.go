@@ -0,0 +1,67 @@
+package attestation
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func disclose(t *testing.T, tree *BlockTree, request []byte, salts [][]byte, index uint32) DisclosedBlock {
+	end := int(index+1) * BLOCK_SIZE
+	if end > len(request) {
+		end = len(request)
+	}
+	b := DisclosedBlock{
+		Index: index,
+		Block: hex.EncodeToString(request[int(index)*BLOCK_SIZE : end]),
+		Salt:  hex.EncodeToString(salts[index]),
+	}
+	for _, p := range tree.Proof(index) {
+		b.Proof = append(b.Proof, hex.EncodeToString(p))
+	}
+	return b
+}
+
+func TestVerifyDisclosure(t *testing.T) {
+	for size := 1; size <= 20*BLOCK_SIZE; size += 7 {
+		request := make([]byte, size)
+		rand.Read(request)
+		count := (size + BLOCK_SIZE - 1) / BLOCK_SIZE
+		salts := make([][]byte, count)
+		for i := range salts {
+			salts[i] = make([]byte, SALT_SIZE)
+			rand.Read(salts[i])
+		}
+		tree, err := NewBlockTree(request, salts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc := &Document{Commitments: []Commitment{{COMMITMENT_REQUEST_BLOCKS, COMMITMENT_ALG_BLOCK_MERKLE_SHA256, hex.EncodeToString(tree.Commitment())}}}
+		for i := 0; i < count; i++ {
+			if err = doc.VerifyDisclosure([]DisclosedBlock{disclose(t, tree, request, salts, uint32(i))}); err != nil {
+				t.Fatalf("size %d, block %d: %v", size, i, err)
+			}
+		}
+
+		b := disclose(t, tree, request, salts, 0)
+		b.Block = hex.EncodeToString(make([]byte, len(b.Block)/2))
+		if err = doc.VerifyDisclosure([]DisclosedBlock{b}); !errors.Is(err, ErrInvalidDisclosure) {
+			t.Errorf("size %d: a modified block must be rejected: %v", size, err)
+		}
+		if count > 1 {
+			b = disclose(t, tree, request, salts, 0)
+			b.Index = 1
+			if err = doc.VerifyDisclosure([]DisclosedBlock{b}); !errors.Is(err, ErrInvalidDisclosure) {
+				t.Errorf("size %d: a block at another index must be rejected: %v", size, err)
+			}
+		}
+	}
+}
+
+func TestRequestCommitmentMissing(t *testing.T) {
+	doc := &Document{Commitments: []Commitment{{Purpose: 2, Algorithm: 1, Value: "00"}}}
+	if err := doc.VerifyDisclosure(nil); !errors.Is(err, ErrInvalidDisclosure) {
+		t.Error("a document without request commitment must be rejected:", err)
+	}
+}
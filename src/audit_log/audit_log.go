@@ -0,0 +1,138 @@
+package audit_log
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// record is one line of the audit log
+type record struct {
+	Time  time.Time   `json:"time"`
+	Event string      `json:"event"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// AuditLog is an append-only log of security relevant events (e.g. detected
+// cheating, bans) in the JSON lines format. Unlike the regular log, it is meant
+// to be kept and reviewed by the operator.
+type AuditLog struct {
+	sync.Mutex
+	path string
+	file *os.File
+}
+
+func NewAuditLog(path string) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{path: path, file: file}, nil
+}
+
+// Record appends an event with its data to the log. data must be
+// serializable to JSON.
+func (a *AuditLog) Record(event string, data interface{}) error {
+	line, err := json.Marshal(record{Time: time.Now().UTC(), Event: event, Data: data})
+	if err != nil {
+		return err
+	}
+	a.Lock()
+	defer a.Unlock()
+	_, err = a.file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+// storedRecord is a record as read back from the log
+type storedRecord struct {
+	Time time.Time `json:"time"`
+	Data struct {
+		Sid string `json:"sid"`
+	} `json:"data"`
+}
+
+// purge rewrites the log without the records for which drop returns true.
+// Lines which can't be parsed are kept. It returns the amount of dropped
+// records.
+func (a *AuditLog) purge(drop func(r *storedRecord) bool) (int, error) {
+	a.Lock()
+	defer a.Unlock()
+	content, err := os.ReadFile(a.path)
+	if err != nil {
+		return 0, err
+	}
+	var kept []byte
+	dropped := 0
+	for _, line := range bytes.SplitAfter(content, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var r storedRecord
+		if json.Unmarshal(line, &r) == nil && drop(&r) {
+			dropped += 1
+			continue
+		}
+		kept = append(kept, line...)
+	}
+	if dropped == 0 {
+		return 0, nil
+	}
+
+	tmpPath := a.path + ".tmp"
+	err = os.WriteFile(tmpPath, kept, 0600)
+	if err != nil {
+		return 0, err
+	}
+	err = os.Rename(tmpPath, a.path)
+	if err != nil {
+		return 0, err
+	}
+	// the old file was replaced, continue appending to the new one
+	file, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	a.file.Close()
+	a.file = file
+	return dropped, nil
+}
+
+// PurgeOlderThan removes all records older than cutoff
+func (a *AuditLog) PurgeOlderThan(cutoff time.Time) (int, error) {
+	return a.purge(func(r *storedRecord) bool {
+		return r.Time.Before(cutoff)
+	})
+}
+
+// PurgeSession removes all records related to the session sid
+func (a *AuditLog) PurgeSession(sid string) (int, error) {
+	return a.purge(func(r *storedRecord) bool {
+		return r.Data.Sid == sid
+	})
+}
+
+// EnforceRetention purges records older than maxAge once an hour. It never
+// returns.
+func (a *AuditLog) EnforceRetention(maxAge time.Duration) {
+	for {
+		dropped, err := a.PurgeOlderThan(time.Now().Add(-maxAge))
+		if err != nil {
+			log.Println("audit log retention:", err)
+		} else if dropped > 0 {
+			log.Println("audit log retention: purged", dropped, "records")
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+func (a *AuditLog) Close() error {
+	a.Lock()
+	defer a.Unlock()
+	return a.file.Close()
+}
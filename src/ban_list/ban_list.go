@@ -0,0 +1,256 @@
+package ban_list
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// KIND_IP marks an entry which bans a client's IP address
+	KIND_IP = "ip"
+	// KIND_API_KEY marks an entry which bans a client's API key
+	KIND_API_KEY = "apikey"
+)
+
+// Entry is a single ban. Entries are persisted to disk as JSON.
+type Entry struct {
+	Kind    string    `json:"kind"`
+	Value   string    `json:"value"`
+	Reason  string    `json:"reason"`
+	Created time.Time `json:"created"`
+	// Expires is the time after which the ban is lifted
+	Expires time.Time `json:"expires"`
+}
+
+// BanList keeps track of banned IP addresses and API keys. Entries are added
+// automatically when a session is caught cheating (e.g. its commitment did
+// not match its decommitment) or manually via the admin API.
+type BanList struct {
+	sync.Mutex
+	// path is the file to which the ban list is persisted
+	path string
+	// defaultTTL is used when a ban is added without an explicit TTL
+	defaultTTL time.Duration
+	// entries are keyed by kind:value
+	entries map[string]*Entry
+}
+
+func NewBanList(path string, defaultTTL time.Duration) (*BanList, error) {
+	b := &BanList{
+		path:       path,
+		defaultTTL: defaultTTL,
+		entries:    make(map[string]*Entry),
+	}
+
+	file, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return b, nil
+		}
+		return nil, err
+	}
+
+	var entries []*Entry
+	err = json.Unmarshal(file, &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, e := range entries {
+		if now.After(e.Expires) {
+			continue
+		}
+		b.entries[entryKey(e.Kind, e.Value)] = e
+	}
+	log.Printf("Loaded %d ban list entries\n", len(b.entries))
+
+	return b, nil
+}
+
+func entryKey(kind string, value string) string {
+	return kind + ":" + value
+}
+
+// Ban adds value to the ban list for ttl. When ttl is 0, the default TTL
+// is used. Banning an already banned value extends the ban.
+func (b *BanList) Ban(kind string, value string, ttl time.Duration, reason string) error {
+	if kind != KIND_IP && kind != KIND_API_KEY {
+		return errors.New("unknown ban kind")
+	}
+	if value == "" {
+		return errors.New("empty ban value")
+	}
+	if ttl <= 0 {
+		ttl = b.defaultTTL
+	}
+
+	now := time.Now()
+	b.Lock()
+	defer b.Unlock()
+	b.entries[entryKey(kind, value)] = &Entry{
+		Kind:    kind,
+		Value:   value,
+		Reason:  reason,
+		Created: now,
+		Expires: now.Add(ttl),
+	}
+	log.Println("banned", kind, value, "for", ttl.String(), "reason:", reason)
+	return b.save()
+}
+
+// Unban removes value from the ban list
+func (b *BanList) Unban(kind string, value string) error {
+	b.Lock()
+	defer b.Unlock()
+	key := entryKey(kind, value)
+	if _, ok := b.entries[key]; !ok {
+		return errors.New("entry not found")
+	}
+	delete(b.entries, key)
+	return b.save()
+}
+
+// IsBanned checks if value of the given kind is currently banned
+func (b *BanList) IsBanned(kind string, value string) bool {
+	if value == "" {
+		return false
+	}
+	b.Lock()
+	defer b.Unlock()
+	e, ok := b.entries[entryKey(kind, value)]
+	if !ok {
+		return false
+	}
+	if time.Now().After(e.Expires) {
+		delete(b.entries, entryKey(kind, value))
+		return false
+	}
+	return true
+}
+
+// IsRequestBanned checks both the IP address and the API key (if any) of
+// the request
+func (b *BanList) IsRequestBanned(req *http.Request) bool {
+	return b.IsBanned(KIND_IP, RequestIP(req)) ||
+		b.IsBanned(KIND_API_KEY, req.Header.Get("X-Api-Key"))
+}
+
+// Entries returns a copy of all active entries
+func (b *BanList) Entries() []Entry {
+	b.Lock()
+	defer b.Unlock()
+	now := time.Now()
+	entries := make([]Entry, 0, len(b.entries))
+	for _, e := range b.entries {
+		if now.After(e.Expires) {
+			continue
+		}
+		entries = append(entries, *e)
+	}
+	return entries
+}
+
+// save writes all entries to disk. Must be called with the lock held.
+func (b *BanList) save() error {
+	entries := make([]*Entry, 0, len(b.entries))
+	for _, e := range b.entries {
+		entries = append(entries, e)
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	// write to a temp file first so that a crash doesn't leave a truncated list
+	tmpPath := b.path + ".tmp"
+	err = os.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, b.path)
+}
+
+// RequestIP strips the port from the request's remote address
+func RequestIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+type banRequest struct {
+	Kind   string `json:"kind"`
+	Value  string `json:"value"`
+	TTL    string `json:"ttl,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ServeAdmin is the admin API handler for the ban list:
+// GET lists all active bans, POST adds a ban, DELETE removes a ban. POST and
+// DELETE expect a JSON body, e.g.
+// {"kind": "ip", "value": "1.2.3.4", "ttl": "48h", "reason": "abuse"}
+func (b *BanList) ServeAdmin(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if req.Method == http.MethodGet {
+		body, err := json.Marshal(b.Entries())
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(body)
+		return
+	}
+
+	if req.Method != http.MethodPost && req.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	br := new(banRequest)
+	err := json.NewDecoder(req.Body).Decode(br)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	br.Kind = strings.ToLower(br.Kind)
+
+	if req.Method == http.MethodDelete {
+		err = b.Unban(br.Kind, br.Value)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var ttl time.Duration
+	if br.TTL != "" {
+		ttl, err = time.ParseDuration(br.TTL)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	reason := br.Reason
+	if reason == "" {
+		reason = "admin"
+	}
+	err = b.Ban(br.Kind, br.Value, ttl, reason)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
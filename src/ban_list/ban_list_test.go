@@ -0,0 +1,145 @@
+package ban_list
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestBanList(t *testing.T) *BanList {
+	b, err := NewBanList(filepath.Join(t.TempDir(), "banlist.json"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestBanExpires(t *testing.T) {
+	b := newTestBanList(t)
+	if err := b.Ban(KIND_IP, "1.2.3.4", 0, "test"); err != nil {
+		t.Fatal(err)
+	}
+	e := b.entries[entryKey(KIND_IP, "1.2.3.4")]
+	if d := e.Expires.Sub(e.Created); d != time.Hour {
+		t.Fatal("a ban without TTL must get the default TTL", d)
+	}
+	if !b.IsBanned(KIND_IP, "1.2.3.4") || b.IsBanned(KIND_API_KEY, "1.2.3.4") {
+		t.Fatal("the ban must only apply to its kind")
+	}
+
+	e.Expires = time.Now().Add(-time.Second)
+	if len(b.Entries()) != 0 {
+		t.Error("an expired ban must not be listed")
+	}
+	if b.IsBanned(KIND_IP, "1.2.3.4") {
+		t.Fatal("an expired ban must be lifted")
+	}
+	if _, ok := b.entries[entryKey(KIND_IP, "1.2.3.4")]; ok {
+		t.Error("an expired ban must be removed when it is checked")
+	}
+}
+
+func TestBanListReload(t *testing.T) {
+	b := newTestBanList(t)
+	if err := b.Ban(KIND_API_KEY, "key", 48*time.Hour, "cheating detected"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Ban(KIND_IP, "1.2.3.4", 0, "abuse"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Unban(KIND_IP, "1.2.3.4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Unban(KIND_IP, "1.2.3.4"); err == nil {
+		t.Error("unbanning twice must fail")
+	}
+
+	loaded, err := NewBanList(b.path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := loaded.Entries()
+	if len(entries) != 1 || entries[0].Value != "key" || entries[0].Reason != "cheating detected" ||
+		!entries[0].Expires.Equal(b.entries[entryKey(KIND_API_KEY, "key")].Expires) {
+		t.Fatal("unexpected entries", entries)
+	}
+	if _, err = os.Stat(b.path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("the temp file must be renamed")
+	}
+
+	// expired entries are dropped when the list is loaded
+	expired := []Entry{{Kind: KIND_IP, Value: "5.6.7.8", Expires: time.Now().Add(-time.Minute)}}
+	data, _ := json.Marshal(expired)
+	if err = os.WriteFile(b.path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err = NewBanList(b.path, time.Hour); err != nil || len(loaded.entries) != 0 {
+		t.Fatal("expired entries must not be loaded", err)
+	}
+
+	if err = os.WriteFile(b.path, []byte("not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewBanList(b.path, time.Hour); err == nil {
+		t.Error("a corrupted ban list must not be loaded")
+	}
+}
+
+func TestServeAdmin(t *testing.T) {
+	b := newTestBanList(t)
+	serve := func(method string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		b.ServeAdmin(w, httptest.NewRequest(method, "/ban", strings.NewReader(body)))
+		return w
+	}
+
+	// only listing, banning and unbanning are allowed
+	for _, method := range []string{http.MethodPut, http.MethodPatch} {
+		if w := serve(method, `{"kind": "ip", "value": "1.2.3.4"}`); w.Code != http.StatusMethodNotAllowed {
+			t.Error(method, "must not be allowed", w.Code)
+		}
+	}
+	if b.IsBanned(KIND_IP, "1.2.3.4") {
+		t.Fatal("a rejected request must not ban")
+	}
+
+	for _, body := range []string{
+		`not json`,
+		`{"kind": "email", "value": "a@example.com"}`,
+		`{"kind": "ip", "value": ""}`,
+		`{"kind": "ip", "value": "1.2.3.4", "ttl": "two days"}`,
+	} {
+		if w := serve(http.MethodPost, body); w.Code != http.StatusBadRequest {
+			t.Error("expected 400 for", body, w.Code)
+		}
+	}
+	if len(b.Entries()) != 0 {
+		t.Fatal("invalid requests must not ban", b.Entries())
+	}
+
+	if w := serve(http.MethodPost, `{"kind": "IP", "value": "1.2.3.4", "ttl": "48h"}`); w.Code != http.StatusCreated {
+		t.Fatal("unexpected status", w.Code)
+	}
+	e := b.entries[entryKey(KIND_IP, "1.2.3.4")]
+	if e == nil || e.Reason != "admin" || e.Expires.Sub(e.Created) != 48*time.Hour {
+		t.Fatal("unexpected entry", e)
+	}
+
+	w := serve(http.MethodGet, "")
+	var listed []Entry
+	if err := json.Unmarshal(w.Body.Bytes(), &listed); err != nil || len(listed) != 1 || listed[0].Value != "1.2.3.4" {
+		t.Fatal("unexpected list", w.Body.String(), err)
+	}
+
+	if w := serve(http.MethodDelete, `{"kind": "ip", "value": "1.2.3.4"}`); w.Code != http.StatusNoContent {
+		t.Fatal("unexpected status", w.Code)
+	}
+	if w := serve(http.MethodDelete, `{"kind": "ip", "value": "1.2.3.4"}`); w.Code != http.StatusNotFound {
+		t.Error("unbanning a value which isn't banned must get 404", w.Code)
+	}
+}
@@ -0,0 +1,25 @@
+// Package chaos contains fault injection points for resilience testing. The
+// injection points are no-ops unless the notary is built with the chaos tag:
+//
+//	go build -tags chaos
+//
+// and faults are then enabled with the CHAOS environment variable, a comma
+// separated list of <point>=<value>, e.g.
+//
+//	CHAOS=ot_drop=0.1,step_delay=2s,blob_corrupt=0.01,mpc_kill=0.5
+//
+// For probabilities the value is the chance (0..1) that the fault is
+// injected each time the point is reached, for delays it is a duration.
+package chaos
+
+// The injection points
+const (
+	// OtDrop fails an OT request or response as if the connection was lost
+	OtDrop = "ot_drop"
+	// StepDelay delays each protocol step before it is processed
+	StepDelay = "step_delay"
+	// BlobCorrupt flips a bit in a chunk of the uploaded blob
+	BlobCorrupt = "blob_corrupt"
+	// MpcKill aborts a tag verification MPC as if the process was killed
+	MpcKill = "mpc_kill"
+)
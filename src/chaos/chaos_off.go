@@ -0,0 +1,21 @@
+//go:build !chaos
+
+package chaos
+
+import "io"
+
+// Enabled is true if the notary was built with the chaos tag
+const Enabled = false
+
+// Fail reports whether a fault should be injected at point
+func Fail(point string) bool {
+	return false
+}
+
+// Delay sleeps for the delay configured for point
+func Delay(point string) {}
+
+// Reader returns r, with chunks corrupted at point
+func Reader(point string, r io.Reader) io.Reader {
+	return r
+}
@@ -0,0 +1,90 @@
+//go:build chaos
+
+package chaos
+
+import (
+	"expvar"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Enabled is true if the notary was built with the chaos tag
+const Enabled = true
+
+var (
+	probabilities = make(map[string]float64)
+	delays        = make(map[string]time.Duration)
+	// injected counts the injected faults of each point
+	injected = expvar.NewMap("chaos_injected")
+)
+
+func init() {
+	config := os.Getenv("CHAOS")
+	if config == "" {
+		log.Println("chaos: built with fault injection but $CHAOS is empty")
+		return
+	}
+	for _, item := range strings.Split(config, ",") {
+		point, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			log.Fatalln("chaos: invalid item", item)
+		}
+		if d, err := time.ParseDuration(value); err == nil {
+			delays[point] = d
+			continue
+		}
+		p, err := strconv.ParseFloat(value, 64)
+		if err != nil || p < 0 || p > 1 {
+			log.Fatalln("chaos: invalid value for", point, value)
+		}
+		probabilities[point] = p
+	}
+	log.Println("chaos: fault injection enabled:", config)
+}
+
+// Fail reports whether a fault should be injected at point
+func Fail(point string) bool {
+	p, ok := probabilities[point]
+	if !ok || rand.Float64() >= p {
+		return false
+	}
+	log.Println("chaos: injecting", point)
+	injected.Add(point, 1)
+	return true
+}
+
+// Delay sleeps for the delay configured for point
+func Delay(point string) {
+	d, ok := delays[point]
+	if !ok {
+		return
+	}
+	injected.Add(point, 1)
+	time.Sleep(d)
+}
+
+// Reader returns r, with chunks corrupted at point
+func Reader(point string, r io.Reader) io.Reader {
+	if _, ok := probabilities[point]; !ok {
+		return r
+	}
+	return &corruptReader{point: point, r: r}
+}
+
+type corruptReader struct {
+	point string
+	r     io.Reader
+}
+
+func (c *corruptReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 && Fail(c.point) {
+		p[rand.Intn(n)] ^= 1 << rand.Intn(8)
+	}
+	return n, err
+}
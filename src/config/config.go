@@ -0,0 +1,169 @@
+// Package config loads the notary's settings from a config file and from
+// environment variables. The settings are the command line flags: the line
+// `step-rate-limit = 60` in the file or NOTARY_STEP_RATE_LIMIT=60 in the
+// environment have the same effect as --step-rate-limit 60. Flags given on
+// the command line win over the environment, which wins over the file.
+//
+// The file is a subset of TOML: one `name = value` per line, where value is
+// a quoted string, a number or a boolean, and # starts a comment. Tables and
+// arrays are not supported. Durations are strings such as "20m".
+package config
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ENV_PREFIX starts the names of the environment variables of the settings
+const ENV_PREFIX = "NOTARY_"
+
+// setting is a value from the config file
+type setting struct {
+	value string
+	line  int
+}
+
+// EnvName returns the environment variable of a flag, e.g.
+// NOTARY_STEP_RATE_LIMIT for step-rate-limit
+func EnvName(flagName string) string {
+	return ENV_PREFIX + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Load sets the flags of fs which were not given on the command line from
+// the environment and from the config file at path. path may be empty to
+// only use the environment. fs must already be parsed.
+func Load(fs *flag.FlagSet, path string) error {
+	var settings map[string]setting
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		settings, err = parse(file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for name, s := range settings {
+			if fs.Lookup(name) == nil {
+				return fmt.Errorf("%s:%d: unknown setting %q", path, s.line, name)
+			}
+		}
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] {
+			return
+		}
+		if value, ok := os.LookupEnv(EnvName(f.Name)); ok {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("%s: %w", EnvName(f.Name), setErr)
+			}
+			return
+		}
+		if s, ok := settings[f.Name]; ok {
+			if setErr := fs.Set(f.Name, s.value); setErr != nil {
+				err = fmt.Errorf("%s:%d: %s: %w", path, s.line, f.Name, setErr)
+			}
+		}
+	})
+	return err
+}
+
+// parse reads the settings of a config file
+func parse(r io.Reader) (map[string]setting, error) {
+	settings := make(map[string]setting)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		if text[0] == '[' {
+			return nil, fmt.Errorf("line %d: tables are not supported", line)
+		}
+		name, raw, ok := strings.Cut(text, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("line %d: expected name = value", line)
+		}
+		value, err := parseValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if _, exists := settings[name]; exists {
+			return nil, fmt.Errorf("line %d: %s is set twice", line, name)
+		}
+		settings[name] = setting{value, line}
+	}
+	return settings, scanner.Err()
+}
+
+// parseValue returns the value of a setting without quotes and comments
+func parseValue(raw string) (string, error) {
+	var value, rest string
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		quoted, err := strconv.QuotedPrefix(raw)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", raw)
+		}
+		value, _ = strconv.Unquote(quoted)
+		rest = raw[len(quoted):]
+	case strings.HasPrefix(raw, "'"):
+		// a literal string, without escapes
+		end := strings.IndexByte(raw[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("invalid string %s", raw)
+		}
+		value = raw[1 : end+1]
+		rest = raw[end+2:]
+	default:
+		value, _, _ = strings.Cut(raw, "#")
+		value = strings.TrimSpace(value)
+		// numbers and booleans
+		if value == "" || strings.TrimLeft(value, "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ.+-_") != "" {
+			return "", fmt.Errorf("invalid value %q, quote strings", raw)
+		}
+	}
+	rest = strings.TrimSpace(rest)
+	if rest != "" && rest[0] != '#' {
+		return "", fmt.Errorf("unexpected %q after the value", rest)
+	}
+	return value, nil
+}
+
+// Write writes the current values of the flags of fs in the config file
+// format, e.g. for --check-config. The flags named in skip are left out.
+func Write(fs *flag.FlagSet, w io.Writer, skip ...string) error {
+	var err error
+	// VisitAll visits the flags sorted by name
+	fs.VisitAll(func(f *flag.Flag) {
+		for _, skipped := range skip {
+			if f.Name == skipped {
+				return
+			}
+		}
+		value := strconv.Quote(f.Value.String())
+		if getter, ok := f.Value.(flag.Getter); ok {
+			switch getter.Get().(type) {
+			case bool, int, int64, uint, uint64, float64:
+				value = f.Value.String()
+			}
+		}
+		if err == nil {
+			_, err = fmt.Fprintf(w, "%s = %s\n", f.Name, value)
+		}
+	})
+	return err
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newFlagSet(args ...string) (*flag.FlagSet, *string, *int, *time.Duration, *bool) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	addr := fs.String("admin-addr", "127.0.0.1:10013", "")
+	limit := fs.Int("step-rate-limit", 0, "")
+	ttl := fs.Duration("ban-ttl", time.Hour, "")
+	halfGates := fs.Bool("half-gates", false, "")
+	fs.Parse(args)
+	return fs, addr, limit, ttl, halfGates
+}
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "notary.toml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+# the admin API
+admin-addr = "0.0.0.0:9000" # not public
+step-rate-limit = 60
+ban-ttl = '48h'
+half-gates = true
+`)
+	t.Setenv("NOTARY_STEP_RATE_LIMIT", "30")
+	fs, addr, limit, ttl, halfGates := newFlagSet("--half-gates=false")
+	if err := Load(fs, path); err != nil {
+		t.Fatal(err)
+	}
+	if *addr != "0.0.0.0:9000" || *ttl != 48*time.Hour {
+		t.Fatal("file settings not applied", *addr, *ttl)
+	}
+	if *limit != 30 {
+		t.Fatal("the environment must win over the file", *limit)
+	}
+	if *halfGates {
+		t.Fatal("the command line must win over the file")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for content, want := range map[string]string{
+		"unknown = 1\n":                              "unknown setting",
+		"step-rate-limit = many\n":                   ":1: step-rate-limit",
+		"admin-addr = 0.0.0.0:9000\n":                "quote strings",
+		"[server]\nadmin-addr = \"x\"\n":             "tables are not supported",
+		"step-rate-limit = 1\nstep-rate-limit = 2\n": "set twice",
+		"admin-addr = \"x\" y\n":                     "after the value",
+		"admin-addr\n":                               "expected name = value",
+	} {
+		fs, _, _, _, _ := newFlagSet()
+		err := Load(fs, writeConfig(t, content))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("%q: expected error with %q, got %v", content, want, err)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	fs, _, _, _, _ := newFlagSet("--step-rate-limit", "5")
+	var out bytes.Buffer
+	if err := Write(fs, &out, "half-gates"); err != nil {
+		t.Fatal(err)
+	}
+	want := "admin-addr = \"127.0.0.1:10013\"\nban-ttl = \"1h0m0s\"\nstep-rate-limit = 5\n"
+	if out.String() != want {
+		t.Fatal("unexpected output", out.String())
+	}
+	// the output can be loaded again
+	fs2, addr, limit, ttl, _ := newFlagSet()
+	if err := Load(fs2, writeConfig(t, out.String())); err != nil {
+		t.Fatal(err)
+	}
+	if *addr != "127.0.0.1:10013" || *limit != 5 || *ttl != time.Hour {
+		t.Fatal("round trip failed")
+	}
+}
+
+func TestValidator(t *testing.T) {
+	v := new(Validator)
+	v.Addr("listen-addr", "0.0.0.0:10011")
+	v.Ports("ot-pool-port", 12346, 4)
+	v.Ports("ot-port", 12345, 1)
+	v.Positive("session-idle-timeout", time.Minute)
+	if err := v.Err(); err != nil {
+		t.Fatal(err)
+	}
+	v.Ports("tag-verification-iv-port", 12348, 4)
+	v.Addr("admin-addr", "localhost")
+	v.NotNegative("ot-pool-size", -1)
+	v.Ports("ot-port", 65535, 2)
+	err := v.Err()
+	for _, want := range []string{"overlap with ot-pool-port", "admin-addr", "ot-pool-size", "out of range"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected %q in %v", want, err)
+		}
+	}
+}
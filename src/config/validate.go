@@ -0,0 +1,83 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// portRange is a range of ports used by one setting
+type portRange struct {
+	name  string
+	first int
+	count int
+}
+
+// Validator collects the problems of the settings, so that all of them are
+// reported at once
+type Validator struct {
+	errs  []error
+	ports []portRange
+}
+
+// Check records problem if ok is false
+func (v *Validator) Check(ok bool, name string, problem string) {
+	if !ok {
+		v.errs = append(v.errs, fmt.Errorf("%s: %s", name, problem))
+	}
+}
+
+// Positive checks that a duration is greater than 0
+func (v *Validator) Positive(name string, d time.Duration) {
+	v.Check(d > 0, name, "must be positive")
+}
+
+// NotNegative checks that a number is 0 or greater
+func (v *Validator) NotNegative(name string, n int) {
+	v.Check(n >= 0, name, "must not be negative")
+}
+
+// Ports checks that the count ports starting with first are valid and
+// don't overlap with the ports of other settings
+func (v *Validator) Ports(name string, first int, count int) {
+	if count <= 0 {
+		return
+	}
+	if first < 1 || first+count-1 > 65535 {
+		v.Check(false, name, fmt.Sprintf("ports %d-%d are out of range", first, first+count-1))
+		return
+	}
+	v.ports = append(v.ports, portRange{name, first, count})
+}
+
+// Addr checks that addr is host:port and registers the port like Ports. The
+// host is not taken into account for overlaps.
+func (v *Validator) Addr(name string, addr string) {
+	_, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		v.Check(false, name, err.Error())
+		return
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		v.Check(false, name, "invalid port "+portStr)
+		return
+	}
+	v.Ports(name, port, 1)
+}
+
+// Err returns all problems found, or nil
+func (v *Validator) Err() error {
+	errs := v.errs
+	for i, a := range v.ports {
+		for _, b := range v.ports[i+1:] {
+			if a.first < b.first+b.count && b.first < a.first+a.count {
+				errs = append(errs, fmt.Errorf("%s: ports %d-%d overlap with %s (ports %d-%d)",
+					b.name, b.first, b.first+b.count-1, a.name, a.first, a.first+a.count-1))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,133 @@
+// Package directory lets notaries publish themselves to a directory
+// service, from which clients pick a notary, e.g. the least busy one in
+// their region. Each notary periodically sends a record signed with its
+// master key. The record has the notary's signed policy document as its
+// capability manifest and a snapshot of its load, so a directory can check
+// both without trusting the connection they came over.
+package directory
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"notary/attestation"
+	u "notary/utils"
+	"time"
+)
+
+const (
+	// RECORD_VERSION is the version of the format of the record. It is
+	// increased when fields are removed or change their meaning.
+	RECORD_VERSION = 1
+	// LABEL precedes the record in the message which the master key signs,
+	// so that a record signature can't be taken for any other signature
+	LABEL = "tlsnotary directory record v1\x00"
+)
+
+// ErrInvalidRecord is returned (wrapped with details) for records which
+// are malformed, not signed by their master key or expired
+var ErrInvalidRecord = errors.New("invalid directory record")
+
+// Load is how busy the notary was when it signed the record
+type Load struct {
+	// Sessions is the amount of active sessions
+	Sessions int `json:"sessions"`
+	// OtSlots is the amount of sessions which can run OT at once, OtFree
+	// how many of them are free
+	OtSlots int `json:"otSlots"`
+	OtFree  int `json:"otFree"`
+	// Queued is the amount of clients waiting for OT
+	Queued int `json:"queued"`
+}
+
+// Record describes a notary to the directory
+type Record struct {
+	Version int `json:"version"`
+	// URL is the public base URL of the notary, e.g.
+	// https://notary.example.com
+	URL string `json:"url"`
+	// Region is where the notary runs in the operator's terms, e.g.
+	// "eu-west"
+	Region string `json:"region,omitempty"`
+	// MasterKey is the PEM master public key of the notary, which signs
+	// the record and the policy document
+	MasterKey string `json:"masterKey"`
+	// Policy is the policy document served at /policy, PolicySignature the
+	// master key's r | s over its SHA-256 in hex
+	Policy          json.RawMessage `json:"policy"`
+	PolicySignature string          `json:"policySignature"`
+	Load            Load            `json:"load"`
+	// Time is the unix time at which the record was signed. The directory
+	// drops the record at Expires unless a newer one arrives.
+	Time    int64 `json:"time"`
+	Expires int64 `json:"expires"`
+}
+
+// Signed is a record with the signature of its master key, the body which
+// a notary posts to the directory
+type Signed struct {
+	// Record is the JSON of the record
+	Record json.RawMessage `json:"record"`
+	// Signature is r | s of the master key over the SHA-256 of LABEL |
+	// Record, in hex
+	Signature string `json:"signature"`
+}
+
+// Sign returns the signed record in JSON format. sign signs the SHA-256 of
+// its argument with the master key, see key_manager.SignWithMasterKey.
+func Sign(r Record, sign func([]byte) []byte) ([]byte, error) {
+	r.Version = RECORD_VERSION
+	record, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	signature := sign(u.Concat([]byte(LABEL), record))
+	return json.Marshal(Signed{Record: record, Signature: hex.EncodeToString(signature)})
+}
+
+// Verify checks that signedJSON is a record signed by its master key, that
+// its policy document was signed by the same key and that it hasn't expired
+// at now. Whether the master key is trusted is up to the directory.
+func Verify(signedJSON []byte, now time.Time) (*Record, error) {
+	var signed Signed
+	if err := json.Unmarshal(signedJSON, &signed); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRecord, err)
+	}
+	r := new(Record)
+	if err := json.Unmarshal(signed.Record, r); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRecord, err)
+	}
+	if r.Version != RECORD_VERSION {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidRecord, r.Version)
+	}
+	key, err := attestation.ParsePublicKeyPEM([]byte(r.MasterKey))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRecord, err)
+	}
+	signature, err := hex.DecodeString(signed.Signature)
+	if err != nil || !verify(key, signature, []byte(LABEL), signed.Record) {
+		return nil, fmt.Errorf("%w: the record wasn't signed by its master key", ErrInvalidRecord)
+	}
+	policySignature, err := hex.DecodeString(r.PolicySignature)
+	if err != nil || !verify(key, policySignature, r.Policy) {
+		return nil, fmt.Errorf("%w: the policy wasn't signed by the master key", ErrInvalidRecord)
+	}
+	if now.Unix() >= r.Expires {
+		return nil, fmt.Errorf("%w: the record expired", ErrInvalidRecord)
+	}
+	return r, nil
+}
+
+// verify checks the r | s signature of key over the SHA-256 of the
+// concatenation of items
+func verify(key *ecdsa.PublicKey, signature []byte, items ...[]byte) bool {
+	if len(signature) != 64 {
+		return false
+	}
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	return ecdsa.Verify(key, u.Sha256(u.Concat(items...)), r, s)
+}
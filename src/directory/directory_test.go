@@ -0,0 +1,98 @@
+package directory
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	u "notary/utils"
+	"testing"
+	"time"
+)
+
+func newTestRecord(t *testing.T) (Record, func([]byte) []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(data []byte) []byte {
+		return u.ECDSASign(key, data)
+	}
+	policy := []byte(`{"version":1}`)
+	return Record{
+		URL:             "https://notary.example.com",
+		Region:          "eu-west",
+		MasterKey:       string(u.ECDSAPubkeyToPEM(&key.PublicKey)),
+		Policy:          policy,
+		PolicySignature: hex.EncodeToString(sign(policy)),
+		Load:            Load{Sessions: 2, OtSlots: 4, OtFree: 2},
+		Time:            1000,
+		Expires:         1180,
+	}, sign
+}
+
+func TestSignAndVerify(t *testing.T) {
+	r, sign := newTestRecord(t)
+	signed, err := Sign(r, sign)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verified, err := Verify(signed, time.Unix(1100, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified.Version != RECORD_VERSION || verified.Region != "eu-west" || verified.Load.OtFree != 2 {
+		t.Fatal("unexpected record", verified)
+	}
+	if _, err = Verify(signed, time.Unix(1180, 0)); !errors.Is(err, ErrInvalidRecord) {
+		t.Fatal("an expired record must be rejected", err)
+	}
+
+	// a record whose load was changed after signing
+	var s Signed
+	json.Unmarshal(signed, &s)
+	r.Version = RECORD_VERSION
+	r.Load.OtFree = 4
+	s.Record, _ = json.Marshal(r)
+	tampered, _ := json.Marshal(s)
+	if _, err = Verify(tampered, time.Unix(1100, 0)); !errors.Is(err, ErrInvalidRecord) {
+		t.Fatal("a tampered record must be rejected", err)
+	}
+
+	// a policy which the master key didn't sign
+	r, sign = newTestRecord(t)
+	r.Policy = []byte(`{"version":2}`)
+	signed, _ = Sign(r, sign)
+	if _, err = Verify(signed, time.Unix(1100, 0)); !errors.Is(err, ErrInvalidRecord) {
+		t.Fatal("a record with a foreign policy must be rejected", err)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	r, sign := newTestRecord(t)
+	status := http.StatusNoContent
+	var received *Record
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		received, _ = Verify(body, time.Unix(1000, 0))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	p := NewPublisher(server.URL, time.Minute, func() Record { return r }, sign)
+	if err := p.Publish(time.Unix(1000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if received == nil || received.Time != 1000 || received.Expires != 1180 {
+		t.Fatal("unexpected record", received)
+	}
+	status = http.StatusForbidden
+	if err := p.Publish(time.Unix(1000, 0)); err == nil {
+		t.Fatal("a rejected record must be an error")
+	}
+}
@@ -0,0 +1,85 @@
+package directory
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"notary/egress"
+	"time"
+)
+
+// REQUEST_TIMEOUT is how long the publisher waits for the directory
+const REQUEST_TIMEOUT = 10 * time.Second
+
+var (
+	// published counts the records accepted by the directory
+	published = expvar.NewInt("directory_published")
+	// publishFailures counts the records which couldn't be published
+	publishFailures = expvar.NewInt("directory_publish_failures")
+)
+
+// Publisher publishes the notary's record to a directory
+type Publisher struct {
+	url      string
+	interval time.Duration
+	// record returns the current record of the notary. Its Time and
+	// Expires are set by the publisher.
+	record func() Record
+	sign   func([]byte) []byte
+	http   *http.Client
+}
+
+// NewPublisher creates a publisher which posts a record signed with sign to
+// directoryURL every interval
+func NewPublisher(directoryURL string, interval time.Duration, record func() Record, sign func([]byte) []byte) *Publisher {
+	return &Publisher{
+		url:      directoryURL,
+		interval: interval,
+		record:   record,
+		sign:     sign,
+		http:     egress.HTTPClient(REQUEST_TIMEOUT),
+	}
+}
+
+// Run publishes the record right away and then every interval. It never
+// returns. A failure is logged and the record is published again at the
+// next interval.
+func (p *Publisher) Run() {
+	for {
+		if err := p.Publish(time.Now()); err != nil {
+			publishFailures.Add(1)
+			log.Println("directory:", err)
+		} else {
+			published.Add(1)
+		}
+		time.Sleep(p.interval)
+	}
+}
+
+// Publish posts the record signed at now. It expires after three intervals,
+// so that a directory keeps the notary when a single publication fails.
+func (p *Publisher) Publish(now time.Time) error {
+	r := p.record()
+	r.Time = now.Unix()
+	r.Expires = now.Add(3 * p.interval).Unix()
+	body, err := Sign(r, p.sign)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("the directory answered with status %d", resp.StatusCode)
+	}
+	return nil
+}
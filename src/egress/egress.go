@@ -0,0 +1,49 @@
+// Package egress configures outbound connections of the notary. In
+// restricted networks they can be routed through an HTTP CONNECT or SOCKS5
+// proxy.
+package egress
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// proxyURL is the proxy for all outbound connections, nil for direct
+// connections
+var proxyURL *url.URL
+
+// SetProxy routes all outbound connections made with HTTPClient through
+// the proxy at rawURL. Supported schemes are http and https (both use HTTP
+// CONNECT for https targets) and socks5. An empty rawURL disables the proxy.
+func SetProxy(rawURL string) error {
+	if rawURL == "" {
+		proxyURL = nil
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return errors.New("egress: unsupported proxy scheme " + u.Scheme)
+	}
+	if u.Host == "" {
+		return errors.New("egress: proxy URL has no host")
+	}
+	proxyURL = u
+	return nil
+}
+
+// HTTPClient returns an HTTP client which uses the configured proxy
+func HTTPClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	if proxyURL != nil {
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
@@ -6,16 +6,22 @@ import (
 )
 
 type Evaluator struct {
+	// HalfGates is set when the truth tables were garbled with half-gates
+	HalfGates bool
 	// the total amount of c6 circuit executions for this session
 	C6Count int
+	// the total amount of c7 circuit executions for this session
+	C7Count int
 	// all circuits, count starts with 1 to avoid confusion
 	// they are meant to be read-only for evaluator
 	meta    []*meta.Circuit
 	ttBlobs [][]byte // truth table blobs for each circuit
 }
 
-func (e *Evaluator) Init(circuits []*meta.Circuit, c6Count int) {
+func (e *Evaluator) Init(circuits []*meta.Circuit, c6Count int, c7Count int, halfGates bool) {
+	e.HalfGates = halfGates
 	e.C6Count = c6Count
+	e.C7Count = c7Count
 	e.meta = circuits
 	e.ttBlobs = make([][]byte, len(e.meta))
 }
@@ -34,10 +40,10 @@ func (e *Evaluator) Evaluate(cNo int, notaryLabels, clientLabels,
 	// split into a batch for multiple executions
 	nlBatch := u.SplitIntoChunks(notaryLabels, c.NotaryInputSize*16)
 	clBatch := u.SplitIntoChunks(clientLabels, c.ClientInputSize*16)
-	ttBatch := u.SplitIntoChunks(truthTables, c.AndGateCount*48)
+	ttBatch := u.SplitIntoChunks(truthTables, c.AndGateCount*meta.AndGateTableSize(e.HalfGates))
 
 	// exeCount is how many executions of this circuit we need
-	exeCount := []int{0, 1, 1, 1, 1, 1, e.C6Count, 1}[cNo]
+	exeCount := []int{0, 1, 1, 1, 1, 1, e.C6Count, e.C7Count}[cNo]
 	batch := make([]batch_t, exeCount)
 	for r := 0; r < exeCount; r++ {
 		// put all input labels into wire labels
@@ -48,18 +54,22 @@ func (e *Evaluator) Evaluate(cNo int, notaryLabels, clientLabels,
 
 	encodedOutput := make([][]byte, exeCount)
 	for r := 0; r < exeCount; r++ {
-		encodedOutput[r] = evaluate(c, batch[r].wl, batch[r].tt)
+		encodedOutput[r] = evaluate(c, batch[r].wl, batch[r].tt, e.HalfGates)
 	}
 	return u.Concat(encodedOutput...)
 }
 
-func evaluate(c *meta.Circuit, wireLabels *[][]byte, truthTables *[]byte) []byte {
+func evaluate(c *meta.Circuit, wireLabels *[][]byte, truthTables *[]byte, halfGates bool) []byte {
 	andGateIdx := 0
 	// gate type XOR==0 AND==1 INV==2
 	for i := 0; i < len(c.Gates); i++ {
 		g := c.Gates[i]
 		if g.Operation == 1 {
-			evaluateAnd(g, wireLabels, truthTables, andGateIdx)
+			if halfGates {
+				evaluateAndHalfGates(g, wireLabels, truthTables, andGateIdx)
+			} else {
+				evaluateAnd(g, wireLabels, truthTables, andGateIdx)
+			}
 			andGateIdx += 1
 		} else if g.Operation == 0 {
 			evaluateXor(g, wireLabels)
@@ -98,6 +108,24 @@ func evaluateAnd(g meta.Gate, wireLabels *[][]byte, truthTables *[]byte, andGate
 	(*wireLabels)[out] = u.Decrypt(label1, label2, g.Id, cipher)
 }
 
+// evaluateAndHalfGates evaluates an AND gate garbled with half-gates
+func evaluateAndHalfGates(g meta.Gate, wireLabels *[][]byte, truthTables *[]byte, andGateIdx int) {
+	labelA := (*wireLabels)[g.InputWires[0]]
+	labelB := (*wireLabels)[g.InputWires[1]]
+	tG := (*truthTables)[andGateIdx*32 : andGateIdx*32+16]
+	tE := (*truthTables)[andGateIdx*32+16 : andGateIdx*32+32]
+
+	wG := u.HalfGateHash(labelA, 2*g.Id)
+	if getPoint(labelA) == 1 {
+		wG = u.XorBytes(wG, tG)
+	}
+	wE := u.HalfGateHash(labelB, 2*g.Id+1)
+	if getPoint(labelB) == 1 {
+		wE = u.XorBytes(wE, u.XorBytes(tE, labelA))
+	}
+	(*wireLabels)[g.OutputWire] = u.XorBytes(wG, wE)
+}
+
 func evaluateXor(g meta.Gate, wireLabels *[][]byte) {
 	in1 := g.InputWires[0]
 	in2 := g.InputWires[1]
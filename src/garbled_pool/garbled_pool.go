@@ -1,8 +1,12 @@
 package garbled_pool
 
 import (
+	"encoding/json"
+	"expvar"
+	"hash"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"notary/garbler"
 	"notary/meta"
 	u "notary/utils"
@@ -17,13 +21,17 @@ import (
 // gc describes a garbled circuit file
 // id is the name of the file
 // keyIdx is the index of a key in g.keys used to encrypt this gc
+// mac authenticates the files, nil for garblings loaded from disk which
+// weren't verified yet, see SetVerify
 type gc struct {
 	id     string
 	keyIdx int
+	mac    []byte
 }
 
 // Blob is what is returned when gc is read from disk
 type Blob struct {
+	// Il are input labels in the compact format (see garbler.CompactLabels)
 	Il *[]byte
 	// we dont return bytes of tt because we gonna be streaming the file
 	// directly into the HTTP response to save memory
@@ -55,19 +63,47 @@ type GarbledPool struct {
 	// the amount of c5 circuits will be poolSize*100 because on average one
 	// session needs that many garbled c5 circuits
 	poolSize int
+	// schedule overrides poolSize by time of day
+	schedule Schedule
+	// remote is the store which garblings for remoteSize more sessions are
+	// spilled to, nil if there is none. See SetRemote.
+	remote     RemoteStore
+	remoteSize int
+	// remoteKey encrypts and authenticates the garblings in the remote store
+	remoteKey []byte
+	// remotePrefix is the prefix of the keys of this run's garblings
+	remotePrefix string
+	// remotePool contains metadata of the garblings in the remote store
+	remotePool map[string][]remoteGc
 	// Circuits contains metainfo for each circuit. Circuit count starts from 1
 	Circuits []*meta.Circuit
 	grb      garbler.Garbler
 	// noSandbox is set to true when not running in a sandboxed environment
 	noSandbox bool
+	// HalfGates is set when circuits are garbled with half-gates instead of
+	// GRR3. Only clients which negotiated PROTOCOL_HALF_GATES support it.
+	HalfGates bool
+	// macKey authenticates the garblings of the local pool
+	macKey []byte
+	// verifyInterval is the time between the checks of the garblings, 0 if
+	// they aren't checked. busy tells when to skip a check. See SetVerify.
+	verifyInterval time.Duration
+	busy           func() bool
+	// verifyCircuit selects the circuit of the next check
+	verifyCircuit int
 	sync.Mutex
 }
 
-func (g *GarbledPool) Init(noSandbox bool) {
+// Init loads the circuits and the garbled circuits which are stored in the
+// garbledPool subdir of dir. poolSize is how many sessions the pool is
+// filled for.
+func (g *GarbledPool) Init(noSandbox bool, halfGates bool, poolSize int, dir string) {
 	g.noSandbox = noSandbox
+	g.HalfGates = halfGates
+	g.grb.HalfGates = halfGates
 	g.encryptedSoFar = 0
 	g.rekeyAfter = 1024 * 1024 * 1024 * 64 // 64GB
-	g.poolSize = 1
+	g.poolSize = poolSize
 	g.pool = make(map[string][]gc, 7)
 	for _, v := range []string{"1", "2", "3", "4", "5", "6", "7"} {
 		g.pool[v] = []gc{}
@@ -77,18 +113,15 @@ func (g *GarbledPool) Init(noSandbox bool) {
 		g.Circuits[idx] = g.parseCircuit(idx)
 		g.Circuits[idx].OutputsSizes = meta.GetOutputSizes(idx)
 	}
-	curDir, err := filepath.Abs(filepath.Dir(os.Args[0]))
-	if err != nil {
-		panic(err)
-	}
-	g.gPDirPath = filepath.Join(filepath.Dir(curDir), "garbledPool")
+	g.gPDirPath = filepath.Join(dir, "garbledPool")
 	if !g.noSandbox {
 		// running in an enclave, need to encrypt input labels
 		g.key = u.GetRandom(16)
 	}
 	g.keys = append(g.keys, g.key)
+	g.macKey = u.GetRandom(32)
 
-	if _, err = os.Stat(g.gPDirPath); os.IsNotExist(err) {
+	if _, err := os.Stat(g.gPDirPath); os.IsNotExist(err) {
 		// the dir does not exist, create
 		err = os.Mkdir(g.gPDirPath, 0755)
 		if err != nil {
@@ -100,21 +133,113 @@ func (g *GarbledPool) Init(noSandbox bool) {
 				panic(err)
 			}
 		}
+		err = os.WriteFile(filepath.Join(g.gPDirPath, "scheme"), []byte(g.scheme()), 0644)
+		if err != nil {
+			panic(err)
+		}
 	} else {
 		// the dir already exists
 		if !g.noSandbox {
 			panic("Error. Garbled pool must not exist.")
 		} else {
+			g.checkSchemeOnDisk()
 			g.loadPoolFromDisk()
 		}
 	}
 	go g.monitor()
+	if g.verifyInterval > 0 {
+		go g.verifier()
+	}
 }
 
-// returns 1 garbling of each circuit and c5Count garblings for circuit 5
-func (g *GarbledPool) GetBlobs(c6Count int) [][]Blob {
-	if c6Count > 1026 {
-		panic("c6Count > 1026")
+// targetPoolSize is the pool size which the monitor currently garbles for
+var targetPoolSize = expvar.NewInt("garbled_pool_target_size")
+
+// SetSchedule replaces the schedule of pool sizes by time of day
+func (g *GarbledPool) SetSchedule(s Schedule) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	g.Lock()
+	g.schedule = s
+	g.Unlock()
+	log.Println("garbled pool schedule:", s)
+	return nil
+}
+
+// TargetPoolSize returns the amount of sessions for which the pool is
+// filled at time now
+func (g *GarbledPool) TargetPoolSize(now time.Time) int {
+	g.Lock()
+	defer g.Unlock()
+	return g.schedule.PoolSize(now, g.poolSize)
+}
+
+// ServeAdmin is the admin API endpoint of the pool:
+// GET returns the pool sizes, the schedule and the garblings in the pool
+// PUT replaces the schedule with the one in the body, until the notary
+// restarts
+func (g *GarbledPool) ServeAdmin(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var body struct {
+			Schedule Schedule `json:"schedule"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := g.SetSchedule(body.Schedule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	target := g.TargetPoolSize(time.Now())
+	g.Lock()
+	available := make(map[string]int, len(g.pool))
+	for k, v := range g.pool {
+		available[k] = len(v)
+	}
+	remote := make(map[string]int, len(g.remotePool))
+	for k, v := range g.remotePool {
+		remote[k] = len(v)
+	}
+	resp := struct {
+		PoolSize       int            `json:"poolSize"`
+		TargetPoolSize int            `json:"targetPoolSize"`
+		Schedule       Schedule       `json:"schedule"`
+		Available      map[string]int `json:"available"`
+		RemoteSize     int            `json:"remoteSize"`
+		Remote         map[string]int `json:"remote"`
+	}{g.poolSize, target, g.schedule, available, g.remoteSize, remote}
+	g.Unlock()
+	json.NewEncoder(w).Encode(resp)
+}
+
+// MAX_RECORD_C6_COUNT is the max amount of executions of circuit 6 for one
+// TLS record of the request, enough for a max size record (16 KiB)
+const MAX_RECORD_C6_COUNT = 1026
+
+// MAX_REQUEST_RECORDS is the max amount of TLS records of a request. Circuit
+// 7 is executed once per record.
+const MAX_REQUEST_RECORDS = 4
+
+// MAX_C6_COUNT is the max amount of executions of circuit 6 in a session
+const MAX_C6_COUNT = MAX_REQUEST_RECORDS * MAX_RECORD_C6_COUNT
+
+// returns 1 garbling of each circuit, c6Count garblings for circuit 6 and
+// c7Count garblings for circuit 7
+func (g *GarbledPool) GetBlobs(c6Count int, c7Count int) [][]Blob {
+	if c6Count > MAX_C6_COUNT {
+		panic("c6Count > MAX_C6_COUNT")
+	}
+	if c7Count > MAX_REQUEST_RECORDS {
+		panic("c7Count > MAX_REQUEST_RECORDS")
 	}
 
 	// we don't use index 0 for clarity, count starts from 1
@@ -125,10 +250,16 @@ func (g *GarbledPool) GetBlobs(c6Count int) [][]Blob {
 		var count int
 		if i == 6 {
 			count = c6Count
+		} else if i == 7 {
+			count = c7Count
 		} else {
 			count = 1
 		}
 		if len(g.pool[iStr]) < count {
+			if g.fetchRemote(iStr) {
+				i = i - 1
+				continue
+			}
 			// give monitorPool some time to fill up the pool, then repeat
 			log.Println("pool is not ready, sleeping", iStr)
 			time.Sleep(time.Second)
@@ -147,6 +278,46 @@ func (g *GarbledPool) GetBlobs(c6Count int) [][]Blob {
 	return allBlobs
 }
 
+// TruthTableSize returns the size in bytes of the truth tables of one
+// session with c6Count executions of circuit 6 and c7Count executions of
+// circuit 7, i.e. of the getBlob response
+func (g *GarbledPool) TruthTableSize(c6Count int, c7Count int) int64 {
+	var size int64
+	for i := 1; i < len(g.Circuits); i++ {
+		count := 1
+		if i == 6 {
+			count = c6Count
+		} else if i == 7 {
+			count = c7Count
+		}
+		size += int64(count) * int64(g.Circuits[i].AndGateCount) * int64(meta.AndGateTableSize(g.HalfGates))
+	}
+	return size
+}
+
+// scheme returns the name of the garbling scheme in use
+func (g *GarbledPool) scheme() string {
+	if g.HalfGates {
+		return "halfgates"
+	}
+	return "grr3"
+}
+
+// checkSchemeOnDisk makes sure that the pool on disk was garbled with the
+// scheme in use. Pools created before the scheme file was introduced are GRR3.
+func (g *GarbledPool) checkSchemeOnDisk() {
+	scheme := "grr3"
+	data, err := os.ReadFile(filepath.Join(g.gPDirPath, "scheme"))
+	if err == nil {
+		scheme = strings.TrimSpace(string(data))
+	} else if !os.IsNotExist(err) {
+		panic(err)
+	}
+	if scheme != g.scheme() {
+		panic("Error. Garbled pool on disk uses the " + scheme + " scheme. Delete the garbledPool dir to switch schemes.")
+	}
+}
+
 func (g *GarbledPool) loadPoolFromDisk() {
 	for _, idx := range []string{"1", "2", "3", "4", "5", "6", "7"} {
 		files, err := ioutil.ReadDir(filepath.Join(g.gPDirPath, "c"+idx))
@@ -171,6 +342,8 @@ func (g *GarbledPool) monitor() {
 	loopCount := 0
 	for {
 		loopCount += 1
+		poolSize := g.TargetPoolSize(time.Now())
+		targetPoolSize.Set(int64(poolSize))
 		// check every 60sec if stale keys are present and free memory
 		if loopCount%60 == 0 {
 			g.Lock()
@@ -203,23 +376,32 @@ func (g *GarbledPool) monitor() {
 		var k string
 		var v []gc
 		for k, v = range g.pool {
-			if k != "6" {
-				if len(v) >= g.poolSize {
+			if k == "6" {
+				// for circuit 6 we need at least MAX_C6_COUNT garblings for a
+				// request of MAX_REQUEST_RECORDS max size TLS records
+				max := u.Max(poolSize*100, MAX_C6_COUNT)
+				if len(v) >= max {
 					continue
 				} else {
-					diff = g.poolSize - len(v)
+					diff = max - len(v)
 					break
 				}
-			} else {
-				// for circuit 6 we need at least 1026 garblings for a max possible
-				// TLS record size of 16KB
-				max := u.Max(g.poolSize*100, 1026)
+			} else if k == "7" {
+				// and one garbling of circuit 7 per record
+				max := u.Max(poolSize, MAX_REQUEST_RECORDS)
 				if len(v) >= max {
 					continue
 				} else {
 					diff = max - len(v)
 					break
 				}
+			} else {
+				if len(v) >= poolSize {
+					continue
+				} else {
+					diff = poolSize - len(v)
+					break
+				}
 			}
 		}
 		// golang doesnt allow to modify map while iterating it
@@ -227,23 +409,62 @@ func (g *GarbledPool) monitor() {
 		if diff > 0 {
 			// need to replenish the pool
 			for i := 0; i < diff; i++ {
+				if g.fetchRemote(k) {
+					continue
+				}
 				kInt, _ := strconv.Atoi(k)
 				il, tt, dt := g.grb.Garble(g.Circuits[kInt])
 				randName := u.RandString()
-				g.saveBlob(filepath.Join(g.gPDirPath, "c"+k, randName), il, tt, dt)
+				mac := g.saveBlob(k, randName, il, tt, dt)
 				g.Lock()
-				g.pool[k] = append(g.pool[k], gc{id: randName, keyIdx: len(g.keys) - 1})
+				g.pool[k] = append(g.pool[k], gc{id: randName, keyIdx: len(g.keys) - 1, mac: mac})
 				g.Unlock()
 			}
 			// don't sleep because we may have other circuits which are waiting
 			// to be replenished
 			continue
 		}
+		// with the local pool full, spill to the remote store one garbling
+		// at a time, so that the local pool is checked in between
+		if g.remote != nil {
+			spilled := false
+			for _, k := range []string{"1", "2", "3", "4", "5", "6", "7"} {
+				g.Lock()
+				remoteCount := len(g.remotePool[k])
+				g.Unlock()
+				if remoteCount < g.remoteTarget(k) {
+					kInt, _ := strconv.Atoi(k)
+					il, tt, dt := g.grb.Garble(g.Circuits[kInt])
+					g.spill(k, il, tt, dt)
+					spilled = true
+					break
+				}
+			}
+			if spilled {
+				continue
+			}
+		}
 		time.Sleep(time.Second)
 	}
 }
 
-func (g *GarbledPool) saveBlob(path string, il *[]byte, tt *[]byte, dt *[]byte) {
+// saveBlob writes a garbling of circuit k to the local pool and returns its
+// MAC
+func (g *GarbledPool) saveBlob(k string, id string, il *[]byte, tt *[]byte, dt *[]byte) []byte {
+	path := g.blobPath(k, id)
+	err := os.WriteFile(path+"_tt", *tt, 0644)
+	if err != nil {
+		panic(err)
+	}
+	mac := g.newLocalMac(k, id)
+	mac.Write(*tt)
+	g.saveLabels(path, il, dt, mac)
+	return mac.Sum(nil)
+}
+
+// saveLabels writes the input labels and the decoding table of a garbling,
+// encrypted when in a sandbox, and feeds them to mac as written
+func (g *GarbledPool) saveLabels(path string, il *[]byte, dt *[]byte, mac hash.Hash) {
 	var ilToWrite *[]byte
 	var dtToWrite *[]byte
 	// we encrypt input labels and decoding table
@@ -260,20 +481,18 @@ func (g *GarbledPool) saveBlob(path string, il *[]byte, tt *[]byte, dt *[]byte)
 	if err != nil {
 		panic(err)
 	}
-	err = os.WriteFile(path+"_tt", *tt, 0644)
-	if err != nil {
-		panic(err)
-	}
 	err = os.WriteFile(path+"_dt", *dtToWrite, 0644)
 	if err != nil {
 		panic(err)
 	}
+	mac.Write(*ilToWrite)
+	mac.Write(*dtToWrite)
 }
 
 // fetches the blob from disk and deletes il and dt. tt will be deleted later
 // by the caller.
 func (g *GarbledPool) fetchBlob(circuitNo string, c gc) Blob {
-	fullPath := filepath.Join(g.gPDirPath, "c"+circuitNo, c.id)
+	fullPath := g.blobPath(circuitNo, c.id)
 	il, err := os.ReadFile(fullPath + "_il")
 	if err != nil {
 		panic(err)
@@ -299,20 +518,21 @@ func (g *GarbledPool) fetchBlob(circuitNo string, c gc) Blob {
 	if err3 != nil {
 		panic(err3)
 	}
-	var ilToReturn = &il
-	var dtToReturn = &dt
 	if !g.noSandbox {
 		// decrypt data from disk when in a sandbox
-		ilDec := u.AESGCMdecrypt(g.keys[c.keyIdx], il)
-		ilToReturn = &ilDec
-		dtDec := u.AESGCMdecrypt(g.keys[c.keyIdx], dt)
-		dtToReturn = &dtDec
+		il = u.AESGCMdecrypt(g.keys[c.keyIdx], il)
+		dt = u.AESGCMdecrypt(g.keys[c.keyIdx], dt)
 	}
-	return Blob{ilToReturn, ttFile, dtToReturn}
+	// pools saved to disk by older versions contain input labels in the full
+	// format
+	cNo, _ := strconv.Atoi(circuitNo)
+	meta := g.Circuits[cNo]
+	il = garbler.CompactLabels(il, meta.NotaryInputSize+meta.ClientInputSize)
+	return Blob{&il, ttFile, &dt}
 }
 
-// Convert the circuits from the "Bristol fashion" format into a compact
-// binary representation which can be loaded into RAM and processed gate-by-gate
+// parseCircuit reads circuit cNo_ in the "Bristol fashion" format from the
+// circuits directory, see meta.ParseCircuit
 func (g *GarbledPool) parseCircuit(cNo_ int) *meta.Circuit {
 	cNo := strconv.Itoa(cNo_)
 	curDir, err := filepath.Abs(filepath.Dir(os.Args[0]))
@@ -325,46 +545,5 @@ func (g *GarbledPool) parseCircuit(cNo_ int) *meta.Circuit {
 	if err != nil {
 		panic(err)
 	}
-	text := string(cBytes)
-	lines := strings.Split(text, "\n")
-	c := meta.Circuit{}
-	wireCount, _ := strconv.ParseInt(strings.Split(lines[0], " ")[1], 10, 32)
-	gi, _ := strconv.ParseInt(strings.Split(lines[1], " ")[1], 10, 32)
-	ei, _ := strconv.ParseInt(strings.Split(lines[1], " ")[2], 10, 32)
-	out, _ := strconv.ParseInt(strings.Split(lines[2], " ")[1], 10, 32)
-
-	c.WireCount = int(wireCount)
-	c.NotaryInputSize = int(gi)
-	c.ClientInputSize = int(ei)
-	c.OutputSize = int(out)
-
-	gates := make([]meta.Gate, len(lines)-3)
-	andGateCount := 0
-	opBytes := map[string]byte{"XOR": 0, "AND": 1, "INV": 2}
-
-	for i, line := range lines[3:] {
-		items := strings.Split(line, " ")
-		var g meta.Gate
-		g.Operation = opBytes[items[len(items)-1]]
-		g.Id = uint32(i)
-		if g.Operation == 0 || g.Operation == 1 {
-			inp1, _ := strconv.ParseInt(items[2], 10, 32)
-			inp2, _ := strconv.ParseInt(items[3], 10, 32)
-			out, _ := strconv.ParseInt(items[4], 10, 32)
-			g.InputWires = []uint32{uint32(inp1), uint32(inp2)}
-			g.OutputWire = uint32(out)
-			if g.Operation == 1 {
-				andGateCount += 1
-			}
-		} else { // INV gate
-			inp1, _ := strconv.ParseInt(items[2], 10, 32)
-			out, _ := strconv.ParseInt(items[3], 10, 32)
-			g.InputWires = []uint32{uint32(inp1)}
-			g.OutputWire = uint32(out)
-		}
-		gates[i] = g
-	}
-	c.Gates = gates
-	c.AndGateCount = int(andGateCount)
-	return &c
+	return meta.ParseCircuit(string(cBytes))
 }
@@ -0,0 +1,189 @@
+package garbled_pool
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"expvar"
+	"hash"
+	"io"
+	"log"
+	u "notary/utils"
+	"os"
+)
+
+var (
+	// remoteUploads counts garblings spilled to the remote store
+	remoteUploads = expvar.NewInt("garbled_pool_remote_uploads")
+	// remoteFetches counts garblings fetched back from the remote store
+	remoteFetches = expvar.NewInt("garbled_pool_remote_fetches")
+	// remoteFailures counts failed uploads and fetches, including garblings
+	// which failed the integrity check
+	remoteFailures = expvar.NewInt("garbled_pool_remote_failures")
+)
+
+// RemoteStore keeps garblings outside of the notary, see object_store.Store
+type RemoteStore interface {
+	Put(key string, body io.Reader, size int64) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// remoteGc describes a garbling in the remote store. Its input labels and
+// decoding table are encrypted with remoteKey. mac authenticates the
+// garbling, including its circuit and id, so the store can neither read
+// nor modify nor swap garblings.
+type remoteGc struct {
+	id  string
+	mac []byte
+}
+
+// SetRemote spills garblings for up to remoteSize more sessions than the
+// pool size to store. Local disk only holds the pool size and acts as a
+// cache: garblings are fetched from the store when the local pool runs low.
+// It must be called before Init.
+func (g *GarbledPool) SetRemote(store RemoteStore, remoteSize int) {
+	g.remote = store
+	g.remoteSize = remoteSize
+	g.remoteKey = u.GetRandom(16)
+	// the key is lost on restart, so the garblings of each run are kept
+	// under their own prefix
+	g.remotePrefix = u.RandString()
+	g.remotePool = make(map[string][]remoteGc, 7)
+}
+
+// remoteTarget returns how many garblings of circuit k the remote store
+// should hold
+func (g *GarbledPool) remoteTarget(k string) int {
+	if k == "6" {
+		return g.remoteSize * 100
+	}
+	return g.remoteSize
+}
+
+func (g *GarbledPool) remoteKeyOf(k string, id string, suffix string) string {
+	return g.scheme() + "/" + g.remotePrefix + "/c" + k + "/" + id + suffix
+}
+
+// newRemoteMac returns the MAC of a garbling of circuit k, which is fed the
+// encrypted input labels, the encrypted decoding table and the truth tables
+func (g *GarbledPool) newRemoteMac(k string, id string) hash.Hash {
+	mac := hmac.New(sha256.New, g.remoteKey)
+	mac.Write([]byte(g.remoteKeyOf(k, id, "")))
+	return mac
+}
+
+// spill uploads a fresh garbling of circuit k to the remote store
+func (g *GarbledPool) spill(k string, il, tt, dt *[]byte) {
+	id := u.RandString()
+	ilEnc := u.AESGCMencrypt(g.remoteKey, *il)
+	dtEnc := u.AESGCMencrypt(g.remoteKey, *dt)
+	mac := g.newRemoteMac(k, id)
+	for _, part := range [][]byte{ilEnc, dtEnc, *tt} {
+		mac.Write(part)
+	}
+	for suffix, data := range map[string][]byte{"_il": ilEnc, "_dt": dtEnc, "_tt": *tt} {
+		err := g.remote.Put(g.remoteKeyOf(k, id, suffix), bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			log.Println("could not spill a garbling of circuit", k, "to the remote store:", err)
+			remoteFailures.Add(1)
+			g.deleteRemote(k, id)
+			return
+		}
+	}
+	remoteUploads.Add(1)
+	g.Lock()
+	g.remotePool[k] = append(g.remotePool[k], remoteGc{id: id, mac: mac.Sum(nil)})
+	g.Unlock()
+}
+
+// fetchRemote moves a garbling of circuit k from the remote store into the
+// local pool. Returns false if the remote store has none or fetching failed.
+func (g *GarbledPool) fetchRemote(k string) bool {
+	if g.remote == nil {
+		return false
+	}
+	g.Lock()
+	if len(g.remotePool[k]) == 0 {
+		g.Unlock()
+		return false
+	}
+	rgc := g.remotePool[k][0]
+	g.remotePool[k] = g.remotePool[k][1:]
+	g.Unlock()
+	defer g.deleteRemote(k, rgc.id)
+
+	path := g.blobPath(k, rgc.id)
+	localMac, err := g.download(k, rgc, path)
+	if err != nil {
+		log.Println("could not fetch a garbling of circuit", k, "from the remote store:", err)
+		remoteFailures.Add(1)
+		os.Remove(path + "_tt")
+		return false
+	}
+	remoteFetches.Add(1)
+	g.Lock()
+	g.pool[k] = append(g.pool[k], gc{id: rgc.id, keyIdx: len(g.keys) - 1, mac: localMac})
+	g.Unlock()
+	return true
+}
+
+// download streams the truth tables of rgc to path and saves its input
+// labels and decoding table like saveBlob, after checking the MAC. It
+// returns the MAC of the local pool.
+func (g *GarbledPool) download(k string, rgc remoteGc, path string) ([]byte, error) {
+	mac := g.newRemoteMac(k, rgc.id)
+	ilEnc, err := g.getRemote(g.remoteKeyOf(k, rgc.id, "_il"))
+	if err != nil {
+		return nil, err
+	}
+	dtEnc, err := g.getRemote(g.remoteKeyOf(k, rgc.id, "_dt"))
+	if err != nil {
+		return nil, err
+	}
+	mac.Write(ilEnc)
+	mac.Write(dtEnc)
+
+	body, err := g.remote.Get(g.remoteKeyOf(k, rgc.id, "_tt"))
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	ttFile, err := os.Create(path + "_tt")
+	if err != nil {
+		return nil, err
+	}
+	localMac := g.newLocalMac(k, rgc.id)
+	_, err = io.Copy(io.MultiWriter(ttFile, localMac), io.TeeReader(body, mac))
+	if closeErr := ttFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac.Sum(nil), rgc.mac) {
+		return nil, ErrCorrupt
+	}
+	il := u.AESGCMdecrypt(g.remoteKey, ilEnc)
+	dt := u.AESGCMdecrypt(g.remoteKey, dtEnc)
+	g.saveLabels(path, &il, &dt, localMac)
+	return localMac.Sum(nil), nil
+}
+
+func (g *GarbledPool) getRemote(key string) ([]byte, error) {
+	body, err := g.remote.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// deleteRemote removes the objects of a garbling from the remote store
+func (g *GarbledPool) deleteRemote(k string, id string) {
+	for _, suffix := range []string{"_il", "_dt", "_tt"} {
+		if err := g.remote.Delete(g.remoteKeyOf(k, id, suffix)); err != nil {
+			log.Println("could not delete from the remote store:", err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package garbled_pool
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// memStore is a RemoteStore in memory
+type memStore map[string][]byte
+
+func (s memStore) Put(key string, body io.Reader, size int64) error {
+	data, err := io.ReadAll(body)
+	s[key] = data
+	return err
+}
+
+func (s memStore) Get(key string) (io.ReadCloser, error) {
+	data, ok := s[key]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s memStore) Delete(key string) error {
+	delete(s, key)
+	return nil
+}
+
+func newRemotePool(t *testing.T) (*GarbledPool, memStore) {
+	store := make(memStore)
+	g := &GarbledPool{noSandbox: true, pool: map[string][]gc{"1": nil}}
+	g.gPDirPath = t.TempDir()
+	if err := os.Mkdir(filepath.Join(g.gPDirPath, "c1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	g.SetRemote(store, 1)
+	return g, store
+}
+
+func TestRemoteSpillAndFetch(t *testing.T) {
+	g, store := newRemotePool(t)
+	il, tt, dt := []byte("input labels"), []byte("truth tables"), []byte("decoding table")
+	g.spill("1", &il, &tt, &dt)
+	if len(g.remotePool["1"]) != 1 || len(store) != 3 {
+		t.Fatal("the garbling was not spilled")
+	}
+	for key, data := range store {
+		if bytes.Contains(data, il) || bytes.Contains(data, dt) {
+			t.Error("labels stored in the clear", key)
+		}
+	}
+	if !g.fetchRemote("1") {
+		t.Fatal("the garbling was not fetched")
+	}
+	if len(store) != 0 || len(g.remotePool["1"]) != 0 || len(g.pool["1"]) != 1 {
+		t.Fatal("the garbling was not moved into the local pool")
+	}
+	path := filepath.Join(g.gPDirPath, "c1", g.pool["1"][0].id)
+	for suffix, want := range map[string][]byte{"_il": il, "_tt": tt, "_dt": dt} {
+		if got, _ := os.ReadFile(path + suffix); !bytes.Equal(got, want) {
+			t.Errorf("%s is %q, want %q", suffix, got, want)
+		}
+	}
+	if g.fetchRemote("1") {
+		t.Error("fetched from an empty remote pool")
+	}
+}
+
+func TestRemoteIntegrity(t *testing.T) {
+	for _, suffix := range []string{"_il", "_tt", "_dt"} {
+		g, store := newRemotePool(t)
+		for _, n := range []string{"1", "2"} {
+			il, tt, dt := []byte("input labels "+n), []byte("truth tables "+n), []byte("decoding table "+n)
+			g.spill("1", &il, &tt, &dt)
+		}
+		// swap the part of the two garblings
+		first := g.remoteKeyOf("1", g.remotePool["1"][0].id, suffix)
+		second := g.remoteKeyOf("1", g.remotePool["1"][1].id, suffix)
+		store[first], store[second] = store[second], store[first]
+		if g.fetchRemote("1") {
+			t.Errorf("a garbling with a swapped %s was accepted", suffix)
+		}
+		if len(g.pool["1"]) != 0 {
+			t.Error("the garbling was added to the local pool")
+		}
+	}
+}
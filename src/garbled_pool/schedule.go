@@ -0,0 +1,121 @@
+package garbled_pool
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClockTime is a time of day in minutes since midnight. It is written as
+// "HH:MM".
+type ClockTime int
+
+func ParseClockTime(s string) (ClockTime, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, errors.New("invalid time of day " + strconv.Quote(s) + ", expected HH:MM")
+	}
+	return ClockTime(t.Hour()*60 + t.Minute()), nil
+}
+
+func (c ClockTime) String() string {
+	return fmt.Sprintf("%02d:%02d", int(c)/60, int(c)%60)
+}
+
+func (c ClockTime) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *ClockTime) UnmarshalText(text []byte) error {
+	parsed, err := ParseClockTime(string(text))
+	*c = parsed
+	return err
+}
+
+// Window is a time of day range with its own pool size. A window whose End
+// is before its Start wraps around midnight.
+type Window struct {
+	Start    ClockTime `json:"start"`
+	End      ClockTime `json:"end"`
+	PoolSize int       `json:"poolSize"`
+}
+
+// contains tells whether the time of day t is in [Start, End)
+func (w Window) contains(t ClockTime) bool {
+	if w.Start < w.End {
+		return t >= w.Start && t < w.End
+	}
+	return t >= w.Start || t < w.End
+}
+
+// Schedule are the pool sizes by time of day, e.g. deep pools overnight and
+// minimal ones during peak CPU pricing hours. The first window which
+// contains the time wins. Outside of all windows the pool size of
+// --garbled-pool-size applies.
+type Schedule []Window
+
+// ParseSchedule parses a comma separated list of windows in the format
+// "HH:MM-HH:MM=poolSize", e.g. "22:00-06:00=8,09:00-17:00=1"
+func ParseSchedule(s string) (Schedule, error) {
+	var schedule Schedule
+	if strings.TrimSpace(s) == "" {
+		return schedule, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		times, size, ok := strings.Cut(strings.TrimSpace(item), "=")
+		start, end, ok2 := strings.Cut(times, "-")
+		if !ok || !ok2 {
+			return nil, errors.New("invalid window " + strconv.Quote(item) + ", expected HH:MM-HH:MM=poolSize")
+		}
+		var w Window
+		var err error
+		if w.Start, err = ParseClockTime(start); err != nil {
+			return nil, err
+		}
+		if w.End, err = ParseClockTime(end); err != nil {
+			return nil, err
+		}
+		if w.PoolSize, err = strconv.Atoi(size); err != nil {
+			return nil, errors.New("invalid pool size " + strconv.Quote(size))
+		}
+		schedule = append(schedule, w)
+	}
+	return schedule, schedule.Validate()
+}
+
+// Validate returns an error for empty windows and for pool sizes below 1.
+// Sessions wait for a garbling of every circuit, so a pool can't be empty.
+func (s Schedule) Validate() error {
+	for _, w := range s {
+		if w.Start == w.End {
+			return fmt.Errorf("window %s-%s is empty", w.Start, w.End)
+		}
+		if w.PoolSize < 1 {
+			return fmt.Errorf("pool size of window %s-%s must be at least 1", w.Start, w.End)
+		}
+	}
+	return nil
+}
+
+// String formats the schedule in the format of ParseSchedule
+func (s Schedule) String() string {
+	items := make([]string, len(s))
+	for i, w := range s {
+		items[i] = fmt.Sprintf("%s-%s=%d", w.Start, w.End, w.PoolSize)
+	}
+	return strings.Join(items, ",")
+}
+
+// PoolSize returns the pool size at time t in its location, or defaultSize
+// outside of all windows
+func (s Schedule) PoolSize(t time.Time, defaultSize int) int {
+	clock := ClockTime(t.Hour()*60 + t.Minute())
+	for _, w := range s {
+		if w.contains(clock) {
+			return w.PoolSize
+		}
+	}
+	return defaultSize
+}
@@ -0,0 +1,62 @@
+package garbled_pool
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func at(clock string) time.Time {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestSchedulePoolSize(t *testing.T) {
+	s, err := ParseSchedule("22:00-06:00=8, 09:00-17:00=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for clock, size := range map[string]int{
+		"22:00": 8, "23:59": 8, "00:00": 8, "05:59": 8,
+		"06:00": 4, "08:59": 4,
+		"09:00": 1, "16:59": 1, "17:00": 4,
+	} {
+		if got := s.PoolSize(at(clock), 4); got != size {
+			t.Errorf("%s: expected pool size %d, got %d", clock, size, got)
+		}
+	}
+	if s.String() != "22:00-06:00=8,09:00-17:00=1" {
+		t.Error("unexpected string", s.String())
+	}
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	for _, s := range []string{"22:00-06:00", "22:00=1", "25:00-06:00=1", "22:00-06:00=x", "10:00-10:00=1", "22:00-06:00=0"} {
+		if _, err := ParseSchedule(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+	if s, err := ParseSchedule(""); err != nil || len(s) != 0 {
+		t.Error("an empty schedule must be valid")
+	}
+}
+
+func TestScheduleJSON(t *testing.T) {
+	var s Schedule
+	if err := json.Unmarshal([]byte(`[{"start":"01:30","end":"05:00","poolSize":3}]`), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s) != 1 || s[0].Start != 90 || s[0].End != 300 || s[0].PoolSize != 3 {
+		t.Fatal("unexpected schedule", s)
+	}
+	out, _ := json.Marshal(s)
+	if string(out) != `[{"start":"01:30","end":"05:00","poolSize":3}]` {
+		t.Error("unexpected JSON", string(out))
+	}
+	if json.Unmarshal([]byte(`[{"start":"1:30pm","end":"05:00","poolSize":3}]`), &s) == nil {
+		t.Error("invalid time of day accepted")
+	}
+}
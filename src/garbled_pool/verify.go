@@ -0,0 +1,139 @@
+package garbled_pool
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"expvar"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+var (
+	// verified counts the garblings of the local pool which passed a
+	// background check
+	verified = expvar.NewInt("garbled_pool_verified")
+	// corrupt counts the garblings of the local pool which failed a
+	// background check and were replaced
+	corrupt = expvar.NewInt("garbled_pool_corrupt")
+)
+
+// ErrCorrupt is returned for a garbling whose files don't match its MAC
+var ErrCorrupt = errors.New("the garbling failed the integrity check")
+
+// SetVerify makes the pool check one garbling of the local pool against its
+// MAC every interval while busy returns false, e.g. while no session runs.
+// A garbling which fails the check is deleted, so that the monitor garbles
+// a new one before a session gets the corrupted one. It must be called
+// before Init.
+func (g *GarbledPool) SetVerify(interval time.Duration, busy func() bool) {
+	g.verifyInterval = interval
+	g.busy = busy
+}
+
+// blobPath returns the path of the files of a garbling of circuit k without
+// their suffix
+func (g *GarbledPool) blobPath(k string, id string) string {
+	return filepath.Join(g.gPDirPath, "c"+k, id)
+}
+
+// newLocalMac returns the MAC of a garbling of circuit k in the local pool,
+// which is fed the truth tables and the input labels and the decoding table
+// as stored on disk
+func (g *GarbledPool) newLocalMac(k string, id string) hash.Hash {
+	mac := hmac.New(sha256.New, g.macKey)
+	mac.Write([]byte("c" + k + "/" + id))
+	return mac
+}
+
+// localMac computes the MAC of the files of a garbling
+func (g *GarbledPool) localMac(k string, id string) ([]byte, error) {
+	mac := g.newLocalMac(k, id)
+	path := g.blobPath(k, id)
+	for _, suffix := range []string{"_tt", "_il", "_dt"} {
+		f, err := os.Open(path + suffix)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(mac, f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return mac.Sum(nil), nil
+}
+
+// verifier checks the garblings of the local pool one after another, see
+// SetVerify
+func (g *GarbledPool) verifier() {
+	next := make(map[string]int, 7)
+	for {
+		time.Sleep(g.verifyInterval)
+		if g.busy != nil && g.busy() {
+			continue
+		}
+		g.verifyNext(next)
+	}
+}
+
+// verifyNext checks the next garbling of the circuit which comes next in
+// round-robin order. next is the index of the next garbling by circuit.
+func (g *GarbledPool) verifyNext(next map[string]int) {
+	k := strconv.Itoa(g.verifyCircuit%7 + 1)
+	g.verifyCircuit++
+	g.Lock()
+	if next[k] >= len(g.pool[k]) {
+		next[k] = 0
+	}
+	if len(g.pool[k]) == 0 {
+		g.Unlock()
+		return
+	}
+	c := g.pool[k][next[k]]
+	next[k]++
+	g.Unlock()
+
+	mac, err := g.localMac(k, c.id)
+	if err == nil && c.mac != nil && !hmac.Equal(mac, c.mac) {
+		err = ErrCorrupt
+	}
+	g.Lock()
+	defer g.Unlock()
+	i := g.indexOf(k, c.id)
+	if i < 0 {
+		// a session got the garbling meanwhile
+		return
+	}
+	if err != nil {
+		log.Println("deleting a garbling of circuit", k, "from the pool:", err)
+		corrupt.Add(1)
+		g.pool[k] = append(g.pool[k][:i], g.pool[k][i+1:]...)
+		path := g.blobPath(k, c.id)
+		for _, suffix := range []string{"_il", "_dt", "_tt"} {
+			os.Remove(path + suffix)
+		}
+		return
+	}
+	if c.mac == nil {
+		// garblings loaded from disk on startup get their MAC when they are
+		// first checked
+		g.pool[k][i].mac = mac
+	}
+	verified.Add(1)
+}
+
+// indexOf returns the index of garbling id in the pool of circuit k, or -1
+func (g *GarbledPool) indexOf(k string, id string) int {
+	for i, c := range g.pool[k] {
+		if c.id == id {
+			return i
+		}
+	}
+	return -1
+}
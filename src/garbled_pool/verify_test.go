@@ -0,0 +1,64 @@
+package garbled_pool
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestVerifyNext(t *testing.T) {
+	g, _ := newRemotePool(t)
+	g.macKey = []byte("mac key")
+	for _, n := range []string{"a", "b", "c"} {
+		il, tt, dt := []byte("input labels "+n), []byte("truth tables "+n), []byte("decoding table "+n)
+		mac := g.saveBlob("1", n, &il, &tt, &dt)
+		g.pool["1"] = append(g.pool["1"], gc{id: n, mac: mac})
+	}
+	// b was loaded from disk and has no MAC yet
+	g.pool["1"][1].mac = nil
+	if err := os.WriteFile(g.blobPath("1", "c")+"_tt", []byte("truth tables x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	next := make(map[string]int)
+	for i := 0; i < 3*7; i++ {
+		g.verifyNext(next)
+	}
+	if len(g.pool["1"]) != 2 || g.pool["1"][0].id != "a" || g.pool["1"][1].id != "b" {
+		t.Fatal("the corrupted garbling must be removed from the pool", g.pool["1"])
+	}
+	if _, err := os.Stat(g.blobPath("1", "c") + "_il"); !os.IsNotExist(err) {
+		t.Error("the files of the corrupted garbling must be deleted")
+	}
+	if g.pool["1"][1].mac == nil {
+		t.Fatal("a garbling without MAC must get one when checked")
+	}
+
+	// a later change of b is detected with the MAC of the first check
+	if err := os.WriteFile(g.blobPath("1", "b")+"_dt", []byte("decoding table x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2*7; i++ {
+		g.verifyNext(next)
+	}
+	if len(g.pool["1"]) != 1 || g.pool["1"][0].id != "a" {
+		t.Fatal("unexpected pool", g.pool["1"])
+	}
+}
+
+func TestFetchRemoteMac(t *testing.T) {
+	g, _ := newRemotePool(t)
+	il, tt, dt := []byte("input labels"), []byte("truth tables"), []byte("decoding table")
+	g.spill("1", &il, &tt, &dt)
+	if !g.fetchRemote("1") {
+		t.Fatal("the garbling was not fetched")
+	}
+	c := g.pool["1"][0]
+	mac, err := g.localMac("1", c.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(mac, c.mac) {
+		t.Error("a fetched garbling must get the MAC of its files")
+	}
+}
@@ -10,8 +10,14 @@ import (
 //  https://eprint.iacr.org/2013/426.pdf
 
 type Garbler struct {
+	// HalfGates selects the half-gates garbling scheme (2 rows per AND gate)
+	// instead of GRR3 (3 rows per AND gate)
+	HalfGates bool
 	// the total amount of c6 circuit executions for this session
 	C6Count int
+	// the total amount of c7 circuit executions for this session, one per
+	// TLS record of the client's request
+	C7Count int
 	// all circuits, count starts with 1 to avoid confusion
 	Cs []CData
 }
@@ -19,7 +25,7 @@ type Garbler struct {
 // CData is data for one circuit
 type CData struct {
 	// Il contains a flat slice of all input labels for all executions of
-	// one circuit
+	// one circuit in the compact format (see CompactLabels)
 	Il []byte
 	// InputBits is notary's input for this circuit. Starts with the least
 	// input bit at index [0].
@@ -35,12 +41,16 @@ type CData struct {
 // Init puts input labels into correspondign circuits and creates masks for
 // notary's inputs to the circuits.
 // il contains input labels for each execution of each circuit
-func (g *Garbler) Init(il [][][]byte, circuits []*meta.Circuit, c6Count int) {
+func (g *Garbler) Init(il [][][]byte, circuits []*meta.Circuit, c6Count int, c7Count int) {
 	g.C6Count = c6Count
+	g.C7Count = c7Count
 	g.Cs = make([]CData, len(circuits))
 	for i := 1; i < len(g.Cs); i++ {
 		g.Cs[i].Il = u.Concat(il[i]...)
 		g.Cs[i].Meta = circuits[i]
+		// the size of notary's inputs is known, so that the session can
+		// set them without growing the slice
+		g.Cs[i].InputBits = make([]int, 0, circuits[i].NotaryInputSize*g.exeCount(i))
 
 		if i == 1 {
 			g.Cs[i].Masks = make([][]byte, 2)
@@ -68,8 +78,11 @@ func (g *Garbler) Init(il [][][]byte, circuits []*meta.Circuit, c6Count int) {
 			g.Cs[i].Masks[2] = u.GetRandom(16)
 		}
 		if i == 7 {
-			g.Cs[i].Masks = make([][]byte, 2)
-			g.Cs[i].Masks[1] = u.GetRandom(16)
+			// one mask for each execution
+			g.Cs[i].Masks = make([][]byte, c7Count+1)
+			for j := 1; j <= c7Count; j++ {
+				g.Cs[i].Masks[j] = u.GetRandom(16)
+			}
 		}
 	}
 }
@@ -87,17 +100,19 @@ func (g *Garbler) Garble(c *meta.Circuit) (*[]byte, *[]byte, *[]byte) {
 	// put input labels into wire labels
 	copy(wireLabels, *generateInputLabels(inputCount, R))
 
-	// a truth table contains 3 rows 16 bytes each
-	truthTables := make([]byte, c.AndGateCount*48)
-	garble(c, &wireLabels, &truthTables, &R)
+	// a truth table contains 3 rows (or 2 rows with half-gates) 16 bytes each
+	truthTables := make([]byte, c.AndGateCount*meta.AndGateTableSize(g.HalfGates))
+	garble(c, &wireLabels, &truthTables, &R, g.HalfGates)
 	if len(wireLabels) != c.WireCount {
 		panic("len(wireLabels) != c.WireCount")
 	}
 
-	inputLabels := make([]byte, inputCount*32)
+	// with free-XOR, label1 of every wire is label0 ^ R. That's why it is
+	// enough to store R followed by label0 of each input wire
+	inputLabels := make([]byte, (inputCount+1)*16)
+	copy(inputLabels[0:16], R)
 	for i := 0; i < inputCount; i++ {
-		copy(inputLabels[i*32:i*32+16], wireLabels[i][0])
-		copy(inputLabels[i*32+16:i*32+32], wireLabels[i][1])
+		copy(inputLabels[(i+1)*16:(i+2)*16], wireLabels[i][0])
 	}
 	// get decoding table: LSB of label0 for each output wire
 	outLSB := make([]int, c.OutputSize)
@@ -108,55 +123,101 @@ func (g *Garbler) Garble(c *meta.Circuit) (*[]byte, *[]byte, *[]byte) {
 	return &inputLabels, &truthTables, &decodingTable
 }
 
-// Client's inputs always come after the Notary's inputs in the circuit
+// CompactLabels converts input labels from the full format (label0|label1
+// for each input wire, 32 bytes per wire) into the compact format (R followed
+// by label0 for each input wire). Input labels which are already compact are
+// returned unchanged.
+func CompactLabels(il []byte, inputCount int) []byte {
+	if len(il) == (inputCount+1)*16 {
+		return il
+	}
+	if len(il) != inputCount*32 {
+		panic("len(il) != inputCount*32")
+	}
+	compact := make([]byte, (inputCount+1)*16)
+	copy(compact[0:16], u.XorBytes(il[0:16], il[16:32]))
+	for i := 0; i < inputCount; i++ {
+		copy(compact[(i+1)*16:(i+2)*16], il[i*32:i*32+16])
+	}
+	return compact
+}
+
+// Client's inputs always come after the Notary's inputs in the circuit.
+// The labels are returned in the full format label0|label1 because the OT
+// sender must input both messages for each choice bit, so the compact format
+// only saves storage and memory, not OT bandwidth. An OT extension sends one
+// ciphertext per message; one ciphertext per wire would need a correlated OT
+// whose correlation is the garbling's R. But the circuits are garbled ahead of
+// the session in the garbled pool, each with its own R, while the correlation
+// of the OT is fixed by the base OTs of the session. Neither the native OT
+// library nor the kos package has such an OT either.
 func (g *Garbler) GetClientLabels(cNo int) []byte {
-	// exeCount is how many executions of this circuit we need
-	exeCount := []int{0, 1, 1, 1, 1, 1, g.C6Count, 1}[cNo]
+	exeCount := g.exeCount(cNo)
 	c := g.Cs[cNo]
-	// chunkSize is the bytesize of input labels for one circuit execution
-	chunkSize := (c.Meta.NotaryInputSize + c.Meta.ClientInputSize) * 32
+	// chunkSize is the bytesize of compact input labels for one circuit execution
+	chunkSize := (c.Meta.NotaryInputSize + c.Meta.ClientInputSize + 1) * 16
 	if chunkSize*exeCount != len(c.Il) {
 		panic("(chunkSize * exeCount != len(c.Il))")
 	}
-	var allIl []byte
+	// the labels of all executions are written into one buffer
+	allIl := make([]byte, exeCount*c.Meta.ClientInputSize*32)
+	o := 0
 	for i := 0; i < exeCount; i++ {
-		allIl = append(allIl, c.Il[i*chunkSize+c.Meta.NotaryInputSize*32:(i+1)*chunkSize]...)
+		chunk := c.Il[i*chunkSize : (i+1)*chunkSize]
+		R := chunk[0:16]
+		for j := c.Meta.NotaryInputSize; j < c.Meta.NotaryInputSize+c.Meta.ClientInputSize; j++ {
+			label0 := chunk[(j+1)*16 : (j+2)*16]
+			copy(allIl[o:o+16], label0)
+			xorInto(allIl[o+16:o+32], label0, R)
+			o += 32
+		}
 	}
 	return allIl
 }
 
 // GetNotaryLabels returns notary's input labels for the circuit
 func (g *Garbler) GetNotaryLabels(cNo int) []byte {
-	// exeCount is how many executions of this circuit we need
-	exeCount := []int{0, 1, 1, 1, 1, 1, g.C6Count, 1}[cNo]
+	exeCount := g.exeCount(cNo)
 	c := g.Cs[cNo]
-	// chunkSize is the bytesize of input labels for one circuit execution
-	chunkSize := (c.Meta.NotaryInputSize + c.Meta.ClientInputSize) * 32
+	// chunkSize is the bytesize of compact input labels for one circuit execution
+	chunkSize := (c.Meta.NotaryInputSize + c.Meta.ClientInputSize + 1) * 16
 	if chunkSize*exeCount != len(c.Il) {
 		panic("(chunkSize * exeCount != len(c.Il))")
 	}
-	var inputLabelBlob []byte
-	for i := 0; i < exeCount; i++ {
-		inputLabelBlob = append(inputLabelBlob,
-			c.Il[i*chunkSize:i*chunkSize+c.Meta.NotaryInputSize*32]...)
-	}
-	if len(inputLabelBlob) != len(c.InputBits)*32 {
-		panic("len(inputLabelBlob) != len(c.InputBits)*32")
+	if c.Meta.NotaryInputSize*exeCount != len(c.InputBits) {
+		panic("c.Meta.NotaryInputSize*exeCount != len(c.InputBits)")
 	}
 	// pick either label0 or label1 depending on our input bit
-	var inputLabels []byte
-	for i := 0; i < len(c.InputBits); i++ {
-		var label []byte
-		if c.InputBits[i] == 0 {
-			label = inputLabelBlob[i*32 : i*32+16]
-		} else {
-			label = inputLabelBlob[i*32+16 : i*32+32]
+	inputLabels := make([]byte, len(c.InputBits)*16)
+	o := 0
+	for i := 0; i < exeCount; i++ {
+		chunk := c.Il[i*chunkSize : (i+1)*chunkSize]
+		R := chunk[0:16]
+		for j := 0; j < c.Meta.NotaryInputSize; j++ {
+			label := chunk[(j+1)*16 : (j+2)*16]
+			if c.InputBits[i*c.Meta.NotaryInputSize+j] == 1 {
+				xorInto(inputLabels[o:o+16], label, R)
+			} else {
+				copy(inputLabels[o:o+16], label)
+			}
+			o += 16
 		}
-		inputLabels = append(inputLabels, label...)
 	}
 	return inputLabels
 }
 
+// exeCount is how many executions of circuit cNo we need
+func (g *Garbler) exeCount(cNo int) int {
+	return []int{0, 1, 1, 1, 1, 1, g.C6Count, g.C7Count}[cNo]
+}
+
+// xorInto writes a xor b into dst without allocating
+func xorInto(dst, a, b []byte) {
+	for i := range dst {
+		dst[i] = a[i] ^ b[i]
+	}
+}
+
 func generateInputLabels(count int, R []byte) *[][][]byte {
 	newLabels := make([][][]byte, count)
 	for i := 0; i < count; i++ {
@@ -167,13 +228,19 @@ func generateInputLabels(count int, R []byte) *[][][]byte {
 	return &newLabels
 }
 
-func garble(c *meta.Circuit, wireLabels *[][][]byte, truthTables *[]byte, R *[]byte) {
+func garble(c *meta.Circuit, wireLabels *[][][]byte, truthTables *[]byte, R *[]byte, halfGates bool) {
 	var andGateIdx int = 0
+	ttSize := meta.AndGateTableSize(halfGates)
 	for i := 0; i < len(c.Gates); i++ {
 		gate := c.Gates[i]
 		if gate.Operation == 1 {
-			tt := garbleAnd(gate, wireLabels, R)
-			copy((*truthTables)[andGateIdx*48:(andGateIdx+1)*48], tt[0:48])
+			var tt []byte
+			if halfGates {
+				tt = garbleAndHalfGates(gate, wireLabels, R)
+			} else {
+				tt = garbleAnd(gate, wireLabels, R)
+			}
+			copy((*truthTables)[andGateIdx*ttSize:(andGateIdx+1)*ttSize], tt[0:ttSize])
 			andGateIdx += 1
 		} else if gate.Operation == 0 {
 			garbleXor(gate, wireLabels, R)
@@ -251,6 +318,43 @@ func garbleAnd(g meta.Gate, wireLabels *[][][]byte, R *[]byte) []byte {
 	return u.Flatten(truthTable)
 }
 
+// garbleAndHalfGates garbles an AND gate using half-gates
+// (https://eprint.iacr.org/2014/756.pdf Fig. 2). The gate is split into a
+// garbler half-gate and an evaluator half-gate, each needing one row.
+func garbleAndHalfGates(g meta.Gate, wireLabels *[][][]byte, R *[]byte) []byte {
+	a0 := (*wireLabels)[g.InputWires[0]][0]
+	a1 := (*wireLabels)[g.InputWires[0]][1]
+	b0 := (*wireLabels)[g.InputWires[1]][0]
+	b1 := (*wireLabels)[g.InputWires[1]][1]
+	pa := getPoint(a0)
+	pb := getPoint(b0)
+	j1 := 2 * g.Id
+	j2 := 2*g.Id + 1
+
+	// garbler half-gate
+	hA0 := u.HalfGateHash(a0, j1)
+	tG := u.XorBytes(hA0, u.HalfGateHash(a1, j1))
+	if pb == 1 {
+		tG = u.XorBytes(tG, *R)
+	}
+	wG := hA0
+	if pa == 1 {
+		wG = u.XorBytes(wG, tG)
+	}
+
+	// evaluator half-gate
+	hB0 := u.HalfGateHash(b0, j2)
+	tE := u.XorBytes(u.XorBytes(hB0, u.HalfGateHash(b1, j2)), a0)
+	wE := hB0
+	if pb == 1 {
+		wE = u.XorBytes(wE, u.XorBytes(tE, a0))
+	}
+
+	out0 := u.XorBytes(wG, wE)
+	(*wireLabels)[g.OutputWire] = [][]byte{out0, u.XorBytes(out0, *R)}
+	return u.Concat(tG, tE)
+}
+
 func garbleXor(g meta.Gate, wireLabels *[][][]byte, R *[]byte) {
 	in1 := g.InputWires[0]
 	in2 := g.InputWires[1]
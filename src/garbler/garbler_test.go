@@ -0,0 +1,136 @@
+package garbler
+
+import (
+	"math/rand"
+	"notary/evaluator"
+	"notary/meta"
+	u "notary/utils"
+	"os"
+	"testing"
+)
+
+// loadAdder returns a 16-bit adder in the "Bristol fashion" format: the
+// notary inputs a, the client b and the output is a+b mod 2^16, least
+// significant bit first. Its carries use AND, XOR and INV gates.
+func loadAdder(t *testing.T) *meta.Circuit {
+	text, err := os.ReadFile("testdata/adder16.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := meta.ParseCircuit(string(text))
+	if c.NotaryInputSize != 16 || c.ClientInputSize != 16 || c.OutputSize != 16 || c.AndGateCount != 43 {
+		t.Fatalf("unexpected circuit %+v", c)
+	}
+	return c
+}
+
+// evaluatePlain evaluates c on plaintext bits
+func evaluatePlain(c *meta.Circuit, inputs []int) []int {
+	wires := make([]int, c.WireCount)
+	copy(wires, inputs)
+	for _, g := range c.Gates {
+		switch g.Operation {
+		case 0:
+			wires[g.OutputWire] = wires[g.InputWires[0]] ^ wires[g.InputWires[1]]
+		case 1:
+			wires[g.OutputWire] = wires[g.InputWires[0]] & wires[g.InputWires[1]]
+		case 2:
+			wires[g.OutputWire] = 1 - wires[g.InputWires[0]]
+		}
+	}
+	return wires[c.WireCount-c.OutputSize:]
+}
+
+func bits16(x int) []int {
+	bits := make([]int, 16)
+	for i := range bits {
+		bits[i] = (x >> i) & 1
+	}
+	return bits
+}
+
+// TestGarbleEvaluate garbles the executions of a circuit, evaluates them
+// with the labels which the client gets with OT and decodes the output
+func TestGarbleEvaluate(t *testing.T) {
+	c := loadAdder(t)
+	rnd := rand.New(rand.NewSource(1))
+	const exeCount = 3
+	circuits := []*meta.Circuit{nil, c, c, c, c, c, c, c}
+	for _, halfGates := range []bool{false, true} {
+		ttSize := map[bool]int{false: 48, true: 32}[halfGates]
+		if meta.AndGateTableSize(halfGates) != ttSize {
+			t.Fatal("unexpected table size", halfGates, meta.AndGateTableSize(halfGates))
+		}
+
+		g := &Garbler{HalfGates: halfGates}
+		il := make([][][]byte, len(circuits))
+		var truthTables, decodingTables [][]byte
+		for i := 0; i < exeCount; i++ {
+			inputLabels, tt, dt := g.Garble(c)
+			if len(*tt) != c.AndGateCount*ttSize {
+				t.Fatal("unexpected size of the truth tables", halfGates, len(*tt))
+			}
+			il[6] = append(il[6], *inputLabels)
+			truthTables = append(truthTables, *tt)
+			decodingTables = append(decodingTables, *dt)
+		}
+		g.Init(il, circuits, exeCount, 1)
+
+		var a, b []int
+		var clientLabels []byte
+		for i := 0; i < exeCount; i++ {
+			a = append(a, rnd.Intn(1<<16))
+			b = append(b, rnd.Intn(1<<16))
+			g.Cs[6].InputBits = append(g.Cs[6].InputBits, bits16(a[i])...)
+		}
+		// the client gets one label of each pair with OT
+		allLabels := g.GetClientLabels(6)
+		for i := 0; i < exeCount; i++ {
+			for j, bit := range bits16(b[i]) {
+				pair := allLabels[(i*16+j)*32:]
+				clientLabels = append(clientLabels, pair[bit*16:bit*16+16]...)
+			}
+		}
+
+		e := new(evaluator.Evaluator)
+		e.Init(circuits, exeCount, 1, halfGates)
+		encoded := e.Evaluate(6, g.GetNotaryLabels(6), clientLabels, u.Concat(truthTables...))
+		for i := 0; i < exeCount; i++ {
+			outputBits := u.BytesToBits(encoded[i*2 : i*2+2])
+			decodingBits := u.BytesToBits(decodingTables[i])
+			plain := evaluatePlain(c, append(bits16(a[i]), bits16(b[i])...))
+			sum := 0
+			for j := range outputBits {
+				bit := outputBits[j] ^ decodingBits[j]
+				if bit != plain[j] {
+					t.Fatalf("halfGates=%v execution %d: output bit %d differs from the plaintext evaluation", halfGates, i, j)
+				}
+				sum |= bit << j
+			}
+			if sum != (a[i]+b[i])%(1<<16) {
+				t.Fatalf("halfGates=%v: %d + %d = %d", halfGates, a[i], b[i], sum)
+			}
+		}
+	}
+}
+
+// TestCompactLabels checks that input labels in the full format of older
+// pools give the same labels as the compact format
+func TestCompactLabels(t *testing.T) {
+	c := loadAdder(t)
+	g := new(Garbler)
+	compact, _, _ := g.Garble(c)
+	inputCount := c.NotaryInputSize + c.ClientInputSize
+	R := (*compact)[0:16]
+	full := make([]byte, 0, inputCount*32)
+	for i := 0; i < inputCount; i++ {
+		label0 := (*compact)[(i+1)*16 : (i+2)*16]
+		full = append(append(full, label0...), u.XorBytes(label0, R)...)
+	}
+	if string(CompactLabels(full, inputCount)) != string(*compact) {
+		t.Fatal("the compact labels differ")
+	}
+	if len(CompactLabels(*compact, inputCount)) != len(*compact) {
+		t.Fatal("compact labels must be returned unchanged")
+	}
+}
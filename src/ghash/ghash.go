@@ -22,25 +22,28 @@ type GHASH struct {
 	// maxOddPowerNeeded, we can start computing the MAC using the Block
 	// Aggregation method.
 	maxOddPowerNeeded int
-	// maxHTable and strategies are initialized in Init(). See comments there.
-	maxHTable []int
+	// strategies are initialized in Init(). See comments there.
 	strategy1 [][]int
 	strategy2 [][]int
 }
 
+// maxHTable's <value> shows how many GHASH blocks can be processed
+// with Block Aggregation if we have all the sequential shares
+// starting with 1 up to and including <key>.
+// e.g. {5:29} means that if we have shares of H^1,H^2,H^3,H^4,H^5,
+// then we can process 29 GHASH blocks.
+// max TLS record size of 16KB requires 1026 GHASH blocks
+var maxHTable = []int{
+	0: 0, 3: 19, 5: 29, 7: 71, 9: 89, 11: 107, 13: 125, 15: 271, 17: 305, 19: 339, 21: 373,
+	23: 407, 25: 441, 27: 475, 29: 509, 31: 1023, 33: 1025, 35: 1027}
+
+// MAX_ODD_POWER_STEP1 is the max odd power which Step1 computes. Larger
+// ones need the extra round of Step2.
+const MAX_ODD_POWER_STEP1 = 19
+
 func (g *GHASH) Init() {
 	g.P = make([][]byte, 1027) //starting with 1, 1026 is the max that we'll ever need
 
-	// maxHTable's <value> shows how many GHASH blocks can be processed
-	// with Block Aggregation if we have all the sequential shares
-	// starting with 1 up to and including <key>.
-	// e.g. {5:29} means that if we have shares of H^1,H^2,H^3,H^4,H^5,
-	// then we can process 29 GHASH blocks.
-	// max TLS record size of 16KB requires 1026 GHASH blocks
-	g.maxHTable = []int{
-		0: 0, 3: 19, 5: 29, 7: 71, 9: 89, 11: 107, 13: 125, 15: 271, 17: 305, 19: 339, 21: 373,
-		23: 407, 25: 441, 27: 475, 29: 509, 31: 1023, 33: 1025, 35: 1027}
-
 	// shows what shares of powers we will be multiplying to obtain other odd shares of powers
 	// max sequential odd power that we can obtain during the first round of communication is 19
 	// note that we multiply N_x*C_y and C_y*N_x to get cross-terms. These are not yet shares of powers
@@ -118,17 +121,19 @@ func (g *GHASH) Step2() []byte {
 
 // in Step3 we multiply GHASH block by those shares of powers which we have.
 // For those which we don't have, we perform Block Aggregation.
+// ghashInputs are the blocks of one TLS record, which may be fewer than
+// maxPowerNeeded when the request spans several records.
 // Returns 1) Notary's share of GHASH output 2) masked xTables 3) count of block
 // multiplications which we performed during Block Aggregation.
 func (g *GHASH) Step3(ghashInputs [][]byte) ([]byte, []byte, int) {
-	u.Assert(len(ghashInputs) == g.maxPowerNeeded)
+	u.Assert(len(ghashInputs) <= g.maxPowerNeeded)
 	res := make([]byte, 16)
 
 	// compute direct powers
 	// L is the total count of GHASH blocks. n is the index of the input block
 	// starting from 0. We multiply GHASH input block X[n] by power H^(L-n).
 	for i := 1; i < len(g.P); i++ {
-		if i > g.maxPowerNeeded {
+		if i > len(ghashInputs) {
 			break
 		}
 		if g.P[i] == nil {
@@ -144,7 +149,7 @@ func (g *GHASH) Step3(ghashInputs [][]byte) ([]byte, []byte, int) {
 	// aggregated <key> -> small power, <value> -> aggregated value for that small power
 	aggregated := make([][]byte, 36) //starting with 1, 35 is the max that we'll ever need
 	for i := 1; i < len(g.P); i++ {
-		if i > g.maxPowerNeeded {
+		if i > len(ghashInputs) {
 			break
 		}
 		if g.P[i] != nil {
@@ -199,17 +204,32 @@ func (g *GHASH) GetMaxOddPowerNeeded() int {
 }
 
 // set max power of H that is needed and calculate max odd power needed based
-// on g.maxHTable
+// on maxHTable
 func (g *GHASH) SetMaxPowerNeeded(max int) {
 	g.maxPowerNeeded = max
-	for k, v := range g.maxHTable {
-		if v >= g.maxPowerNeeded {
-			g.maxOddPowerNeeded = k
-			log.Println("maxPowerNeeded", g.maxPowerNeeded)
-			log.Println("maxOddPowerNeeded", g.maxOddPowerNeeded)
-			break
+	if odd, ok := maxOddPowerNeeded(max); ok {
+		g.maxOddPowerNeeded = odd
+		log.Println("maxPowerNeeded", g.maxPowerNeeded)
+		log.Println("maxOddPowerNeeded", g.maxOddPowerNeeded)
+	}
+}
+
+// maxOddPowerNeeded returns the smallest odd power in maxHTable with which
+// maxPowerNeeded GHASH blocks can be processed
+func maxOddPowerNeeded(maxPowerNeeded int) (int, bool) {
+	for k, v := range maxHTable {
+		if v >= maxPowerNeeded {
+			return k, true
 		}
 	}
+	return 0, false
+}
+
+// NeedsStep2 tells whether the GHASH of ghashBlocks blocks (the AAD, the
+// client's request and the lengths block) needs the extra round of Step2
+func NeedsStep2(ghashBlocks int) bool {
+	odd, _ := maxOddPowerNeeded(ghashBlocks)
+	return odd > MAX_ODD_POWER_STEP1
 }
 
 // FreeSquare locally squares all powers found in powersOfH up to and including
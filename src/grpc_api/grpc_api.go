@@ -0,0 +1,241 @@
+// Package grpc_api is a minimal server side of gRPC over HTTP/2 for the
+// unary calls of notary.proto: the length-prefixed messages, the status
+// trailers and the protobuf encoding of StepRequest and StepResponse.
+// Compression and streaming calls are not supported.
+package grpc_api
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// SERVICE is the full name of the service in notary.proto. The path of a
+// call is /SERVICE/<rpc>.
+const SERVICE = "notary.Notary"
+
+// The status codes of the calls
+const (
+	CODE_OK                  = 0
+	CODE_INVALID_ARGUMENT    = 3
+	CODE_NOT_FOUND           = 5
+	CODE_PERMISSION_DENIED   = 7
+	CODE_RESOURCE_EXHAUSTED  = 8
+	CODE_FAILED_PRECONDITION = 9
+	CODE_UNIMPLEMENTED       = 12
+	CODE_INTERNAL            = 13
+	CODE_UNAVAILABLE         = 14
+)
+
+// MAX_MESSAGE_SIZE is the max size of a message received from the client
+const MAX_MESSAGE_SIZE = 32 << 20
+
+var (
+	ErrCompressed = errors.New("grpc: compressed messages are not supported")
+	ErrTooLarge   = errors.New("grpc: message too large")
+	ErrNotUnary   = errors.New("grpc: expected exactly one request message")
+	ErrMalformed  = errors.New("grpc: malformed protobuf message")
+)
+
+// StepRequest is the request message of every rpc
+type StepRequest struct {
+	SessionId string
+	Body      []byte
+}
+
+// StepResponse is the response message of every rpc
+type StepResponse struct {
+	Body []byte
+}
+
+// IsRequest tells whether req is a gRPC call
+func IsRequest(req *http.Request) bool {
+	if req.ProtoMajor != 2 || req.Method != http.MethodPost {
+		return false
+	}
+	contentType := req.Header.Get("Content-Type")
+	return contentType == "application/grpc" || strings.HasPrefix(contentType, "application/grpc+") ||
+		strings.HasPrefix(contentType, "application/grpc;")
+}
+
+// MethodName returns the rpc name of a protocol command, e.g. C1Step1 for
+// c1_step1
+func MethodName(command string) string {
+	var name strings.Builder
+	for _, part := range strings.Split(command, "_") {
+		if part == "" {
+			continue
+		}
+		name.WriteRune(unicode.ToUpper(rune(part[0])))
+		name.WriteString(part[1:])
+	}
+	return name.String()
+}
+
+// ReadRequest reads the only message of a unary call
+func ReadRequest(r io.Reader) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			return nil, ErrNotUnary
+		}
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, ErrCompressed
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > MAX_MESSAGE_SIZE {
+		return nil, ErrTooLarge
+	}
+	message := make([]byte, size)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, err
+	}
+	var extra [1]byte
+	if n, _ := r.Read(extra[:]); n != 0 {
+		return nil, ErrNotUnary
+	}
+	return message, nil
+}
+
+// WriteResponse writes the response message of a successful call followed
+// by the status trailers
+func WriteResponse(w http.ResponseWriter, message []byte) error {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header[1:], uint32(len(message)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(CODE_OK))
+	return nil
+}
+
+// WriteError ends a failed call with a trailers-only response
+func WriteError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set("Grpc-Message", encodeMessage(message))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// encodeMessage percent-encodes the status message as the gRPC spec
+// requires for the grpc-message header
+func encodeMessage(message string) string {
+	var encoded strings.Builder
+	for i := 0; i < len(message); i++ {
+		b := message[i]
+		if b < 0x20 || b > 0x7e || b == '%' {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+			continue
+		}
+		encoded.WriteByte(b)
+	}
+	return encoded.String()
+}
+
+// CodeFromHTTPStatus returns the status code of a call whose step was
+// answered with the HTTP status
+func CodeFromHTTPStatus(status int) int {
+	switch status {
+	case http.StatusOK:
+		return CODE_OK
+	case http.StatusBadRequest:
+		return CODE_INVALID_ARGUMENT
+	case http.StatusForbidden:
+		return CODE_PERMISSION_DENIED
+	case http.StatusNotFound:
+		return CODE_NOT_FOUND
+	case http.StatusConflict:
+		return CODE_FAILED_PRECONDITION
+	case http.StatusTooManyRequests:
+		return CODE_RESOURCE_EXHAUSTED
+	case http.StatusServiceUnavailable:
+		return CODE_UNAVAILABLE
+	default:
+		return CODE_INTERNAL
+	}
+}
+
+// Unmarshal decodes a StepRequest. Unknown fields are skipped.
+func (r *StepRequest) Unmarshal(data []byte) error {
+	for len(data) > 0 {
+		field, wireType, value, rest, err := readField(data)
+		if err != nil {
+			return err
+		}
+		data = rest
+		switch {
+		case field == 1 && wireType == 2:
+			r.SessionId = string(value)
+		case field == 2 && wireType == 2:
+			r.Body = value
+		}
+	}
+	return nil
+}
+
+// Marshal encodes a StepResponse. An empty body is left out as in proto3.
+func (r *StepResponse) Marshal() []byte {
+	if len(r.Body) == 0 {
+		return nil
+	}
+	out := make([]byte, 0, 1+binary.MaxVarintLen64+len(r.Body))
+	out = append(out, 1<<3|2)
+	out = binary.AppendUvarint(out, uint64(len(r.Body)))
+	return append(out, r.Body...)
+}
+
+// readField reads the next field of a protobuf message. value is only set
+// for length-delimited fields.
+func readField(data []byte) (field uint64, wireType byte, value []byte, rest []byte, err error) {
+	key, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, 0, nil, nil, ErrMalformed
+	}
+	data = data[n:]
+	field, wireType = key>>3, byte(key&7)
+	if field == 0 {
+		return 0, 0, nil, nil, ErrMalformed
+	}
+	switch wireType {
+	case 0: // varint
+		_, n = binary.Uvarint(data)
+		if n <= 0 {
+			return 0, 0, nil, nil, ErrMalformed
+		}
+		return field, wireType, nil, data[n:], nil
+	case 1: // 64-bit
+		if len(data) < 8 {
+			return 0, 0, nil, nil, ErrMalformed
+		}
+		return field, wireType, nil, data[8:], nil
+	case 2: // length-delimited
+		size, n := binary.Uvarint(data)
+		if n <= 0 || size > uint64(len(data)-n) {
+			return 0, 0, nil, nil, ErrMalformed
+		}
+		data = data[n:]
+		return field, wireType, data[:size], data[size:], nil
+	case 5: // 32-bit
+		if len(data) < 4 {
+			return 0, 0, nil, nil, ErrMalformed
+		}
+		return field, wireType, nil, data[4:], nil
+	default:
+		return 0, 0, nil, nil, ErrMalformed
+	}
+}
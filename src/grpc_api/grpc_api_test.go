@@ -0,0 +1,90 @@
+package grpc_api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMethodName(t *testing.T) {
+	for command, name := range map[string]string{
+		"init":            "Init",
+		"c1_step1":        "C1Step1",
+		"ghash_step2":     "GhashStep2",
+		"tagVerification": "TagVerification",
+	} {
+		if got := MethodName(command); got != name {
+			t.Fatal("wrong method name", command, got)
+		}
+	}
+}
+
+// frame adds the gRPC message prefix
+func frame(message []byte) []byte {
+	out := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(out[1:], uint32(len(message)))
+	return append(out, message...)
+}
+
+func TestReadRequest(t *testing.T) {
+	message, err := ReadRequest(bytes.NewReader(frame([]byte("abc"))))
+	if err != nil || string(message) != "abc" {
+		t.Fatal("wrong message", message, err)
+	}
+	compressed := frame([]byte("abc"))
+	compressed[0] = 1
+	if _, err = ReadRequest(bytes.NewReader(compressed)); err != ErrCompressed {
+		t.Fatal("accepted compressed message", err)
+	}
+	if _, err = ReadRequest(bytes.NewReader(append(frame(nil), frame(nil)...))); err != ErrNotUnary {
+		t.Fatal("accepted two messages", err)
+	}
+	if _, err = ReadRequest(bytes.NewReader(nil)); err != ErrNotUnary {
+		t.Fatal("accepted no message", err)
+	}
+	tooLarge := []byte{0, 0xff, 0xff, 0xff, 0xff}
+	if _, err = ReadRequest(bytes.NewReader(tooLarge)); err != ErrTooLarge {
+		t.Fatal("accepted huge message", err)
+	}
+}
+
+func TestStepRequestUnmarshal(t *testing.T) {
+	// session_id "ab", an unknown varint field 3, body "xyz"
+	data := []byte{0x0a, 2, 'a', 'b', 0x18, 0x96, 0x01, 0x12, 3, 'x', 'y', 'z'}
+	var r StepRequest
+	if err := r.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if r.SessionId != "ab" || string(r.Body) != "xyz" {
+		t.Fatal("wrong fields", r)
+	}
+	for _, malformed := range [][]byte{{0x0a, 5, 'a'}, {0x0a}, {0x00, 1}, {0x0b}} {
+		if err := new(StepRequest).Unmarshal(malformed); err != ErrMalformed {
+			t.Fatal("accepted malformed message", malformed)
+		}
+	}
+}
+
+func TestStepResponseMarshal(t *testing.T) {
+	body := bytes.Repeat([]byte{7}, 300)
+	out := (&StepResponse{Body: body}).Marshal()
+	if !bytes.Equal(out[:3], []byte{0x0a, 0xac, 0x02}) || !bytes.Equal(out[3:], body) {
+		t.Fatal("wrong encoding", out[:3])
+	}
+	if len((&StepResponse{}).Marshal()) != 0 {
+		t.Fatal("empty body must be left out")
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	WriteError(rec, CODE_NOT_FOUND, "session 50% gone\n")
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Fatal("errors must be trailers-only")
+	}
+	if rec.Header().Get("Grpc-Status") != "5" || rec.Header().Get("Grpc-Message") != "session 50%25 gone%0A" {
+		t.Fatal("wrong status headers", rec.Header())
+	}
+}
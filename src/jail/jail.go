@@ -0,0 +1,109 @@
+// Package jail runs the helper programs of the notary (the Python tag
+// verifier, snarkjs and the node circuit assembler) under a restricted
+// sandbox, since they process attacker-influenced inputs. The sandbox
+// applies to all helpers of the process, like the proxy of egress.
+package jail
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// Mode names how helpers are sandboxed
+type Mode string
+
+const (
+	// MODE_NONE runs helpers like any child process
+	MODE_NONE Mode = "none"
+	// MODE_BASIC runs helpers in their working dir with a clean environment
+	// and, where user namespaces are available, without network
+	MODE_BASIC Mode = "basic"
+	// MODE_NSJAIL runs helpers under nsjail with the deployment's config
+	MODE_NSJAIL Mode = "nsjail"
+)
+
+// NSJAIL is the name of the nsjail binary, looked up in PATH
+const NSJAIL = "nsjail"
+
+var (
+	mutex sync.Mutex
+	mode  = MODE_NONE
+	// nsjailConfig is the config file of MODE_NSJAIL
+	nsjailConfig string
+	// noNetwork is set when MODE_BASIC can put helpers into their own
+	// network namespace
+	noNetwork bool
+)
+
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case MODE_NONE, MODE_BASIC, MODE_NSJAIL:
+		return m, nil
+	}
+	return "", errors.New("unknown sandbox mode " + s + ", expected none, basic or nsjail")
+}
+
+// SetMode sandboxes all helpers started afterwards with m. MODE_NSJAIL
+// needs the path of an nsjail config file. MODE_BASIC checks once whether
+// helpers can run without network and logs a warning if they can't.
+func SetMode(m Mode, config string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	switch m {
+	case MODE_NSJAIL:
+		if config == "" {
+			return errors.New("jail: the nsjail mode needs a config file")
+		}
+		if _, err := os.Stat(config); err != nil {
+			return err
+		}
+		if _, err := exec.LookPath(NSJAIL); err != nil {
+			return err
+		}
+	case MODE_BASIC:
+		noNetwork = canIsolateNetwork()
+		if !noNetwork {
+			log.Println("Warning: user namespaces are not available, helpers run with network access")
+		}
+	}
+	mode = m
+	nsjailConfig = config
+	return nil
+}
+
+// CommandContext returns the command which runs the helper name with args
+// in dir under the sandbox. dir should be a dedicated directory which holds
+// the helper's inputs.
+func CommandContext(ctx context.Context, dir string, name string, args ...string) *exec.Cmd {
+	mutex.Lock()
+	m, config, isolateNetwork := mode, nsjailConfig, noNetwork
+	mutex.Unlock()
+
+	var cmd *exec.Cmd
+	switch m {
+	case MODE_NSJAIL:
+		path, err := exec.LookPath(name)
+		if err != nil {
+			path = name
+		}
+		nsjailArgs := []string{"--config", config, "--cwd", dir, "--bindmount", dir, "--", path}
+		cmd = exec.CommandContext(ctx, NSJAIL, append(nsjailArgs, args...)...)
+	case MODE_BASIC:
+		cmd = exec.CommandContext(ctx, name, args...)
+		cmd.Env = cleanEnv(dir)
+		cmd.SysProcAttr = sysProcAttr(isolateNetwork)
+	default:
+		cmd = exec.CommandContext(ctx, name, args...)
+	}
+	cmd.Dir = dir
+	return cmd
+}
+
+// cleanEnv is the environment of helpers in MODE_BASIC
+func cleanEnv(dir string) []string {
+	return []string{"PATH=" + os.Getenv("PATH"), "HOME=" + dir, "TMPDIR=" + dir, "LANG=C.UTF-8"}
+}
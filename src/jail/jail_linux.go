@@ -0,0 +1,32 @@
+package jail
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// sysProcAttr kills helpers with the notary and, if isolateNetwork is set,
+// starts them in new user and network namespaces, which only have a
+// loopback interface
+func sysProcAttr(isolateNetwork bool) *syscall.SysProcAttr {
+	attr := &syscall.SysProcAttr{Setpgid: true, Pdeathsig: syscall.SIGKILL}
+	if isolateNetwork {
+		attr.Cloneflags = syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}}
+	}
+	return attr
+}
+
+// canIsolateNetwork tells whether helpers can be started in their own
+// network namespace
+func canIsolateNetwork() bool {
+	path, err := exec.LookPath("true")
+	if err != nil {
+		return false
+	}
+	cmd := exec.Command(path)
+	cmd.SysProcAttr = sysProcAttr(true)
+	return cmd.Run() == nil
+}
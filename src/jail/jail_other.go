@@ -0,0 +1,13 @@
+//go:build !linux
+
+package jail
+
+import "syscall"
+
+func sysProcAttr(isolateNetwork bool) *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+func canIsolateNetwork() bool {
+	return false
+}
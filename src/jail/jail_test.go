@@ -0,0 +1,49 @@
+package jail
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBasic(t *testing.T) {
+	os.Setenv("JAIL_TEST_SECRET", "secret")
+	defer os.Unsetenv("JAIL_TEST_SECRET")
+	if err := SetMode(MODE_BASIC, ""); err != nil {
+		t.Fatal(err)
+	}
+	defer SetMode(MODE_NONE, "")
+	dir := t.TempDir()
+	out, err := CommandContext(context.Background(), dir, "sh", "-c", "pwd; env; cat /proc/net/dev").CombinedOutput()
+	if err != nil {
+		t.Fatal(err, string(out))
+	}
+	if !strings.HasPrefix(string(out), dir+"\n") {
+		t.Error("the helper does not run in its dir")
+	}
+	if strings.Contains(string(out), "secret") || !strings.Contains(string(out), "HOME="+dir) {
+		t.Error("the environment was not cleaned")
+	}
+	if noNetwork && strings.Contains(string(out), "eth") {
+		t.Error("the helper has network interfaces")
+	}
+}
+
+func TestNsjailArgs(t *testing.T) {
+	mutex.Lock()
+	mode, nsjailConfig = MODE_NSJAIL, "/etc/notary/nsjail.cfg"
+	mutex.Unlock()
+	defer SetMode(MODE_NONE, "")
+	cmd := CommandContext(context.Background(), "/tmp/x", "sh", "-c", "true")
+	want := []string{NSJAIL, "--config", "/etc/notary/nsjail.cfg", "--cwd", "/tmp/x", "--bindmount", "/tmp/x", "--"}
+	if strings.Join(cmd.Args[:len(want)], " ") != strings.Join(want, " ") {
+		t.Error("unexpected args", cmd.Args)
+	}
+	if cmd.Args[len(want)+1] != "-c" || !strings.HasSuffix(cmd.Args[len(want)], "/sh") {
+		t.Error("unexpected helper", cmd.Args)
+	}
+	if SetMode(MODE_NSJAIL, "") == nil {
+		t.Error("nsjail without a config accepted")
+	}
+}
@@ -0,0 +1,138 @@
+// Package janitor deletes the files of removed sessions in the background so
+// that session teardown doesn't wait for hundreds of megabytes of truth
+// tables to be deleted.
+package janitor
+
+import (
+	"errors"
+	"expvar"
+	"log"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// queueSize is the amount of deletion jobs which can wait for a worker.
+	// When the queue is full, Delete blocks.
+	queueSize = 64
+	// workers is the amount of goroutines deleting files
+	workers = 4
+	// maxAttempts is how many times we try to delete a path before giving up
+	maxAttempts = 5
+	// retryDelay is multiplied by the attempt number to get the delay before
+	// the next attempt
+	retryDelay = time.Second
+)
+
+var (
+	// filesDeleted counts the deleted files and dirs
+	filesDeleted = expvar.NewInt("janitor_files_deleted")
+	// retries counts failed deletions which were scheduled to be retried
+	retries = expvar.NewInt("janitor_retries")
+	// failures counts paths which could not be deleted after maxAttempts
+	failures = expvar.NewInt("janitor_failures")
+	// publishOnce makes sure the metrics of only one Janitor are published,
+	// expvar panics on duplicate names
+	publishOnce sync.Once
+)
+
+type job struct {
+	paths   []string
+	attempt int
+}
+
+// Janitor deletes files and dirs in the background
+type Janitor struct {
+	// dir is a dir on the filesystem whose free space we report
+	dir     string
+	queue   chan job
+	pending sync.WaitGroup
+}
+
+// NewJanitor starts the workers. dir must be on the same filesystem as the
+// deleted files, its free space is exported as the disk_free_bytes metric.
+func NewJanitor(dir string) (*Janitor, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.New("janitor: " + dir + " is not a dir")
+	}
+	j := &Janitor{dir: dir, queue: make(chan job, queueSize)}
+	for i := 0; i < workers; i++ {
+		go j.work()
+	}
+	publishOnce.Do(func() {
+		expvar.Publish("janitor_queue", expvar.Func(func() interface{} {
+			return len(j.queue)
+		}))
+		expvar.Publish("disk_free_bytes", expvar.Func(func() interface{} {
+			free, err := j.FreeSpace()
+			if err != nil {
+				return -1
+			}
+			return free
+		}))
+	})
+	return j, nil
+}
+
+// Delete schedules paths for deletion. Dirs are deleted recursively. It
+// blocks only when the queue is full.
+func (j *Janitor) Delete(paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	j.pending.Add(1)
+	j.queue <- job{paths: paths, attempt: 1}
+}
+
+// Wait blocks until all scheduled paths are deleted or given up on
+func (j *Janitor) Wait() {
+	j.pending.Wait()
+}
+
+// FreeSpace returns the amount of bytes available to us on the filesystem
+// of the janitor's dir
+func (j *Janitor) FreeSpace() (uint64, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(j.dir, &stat)
+	if err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+func (j *Janitor) work() {
+	for jb := range j.queue {
+		var failed []string
+		for _, path := range jb.paths {
+			err := os.RemoveAll(path)
+			if err != nil {
+				log.Println("janitor: error while deleting", path, err)
+				failed = append(failed, path)
+				continue
+			}
+			filesDeleted.Add(1)
+		}
+		if len(failed) == 0 {
+			j.pending.Done()
+			continue
+		}
+		if jb.attempt >= maxAttempts {
+			failures.Add(int64(len(failed)))
+			free, _ := j.FreeSpace()
+			log.Println("janitor: giving up on", len(failed), "paths, free disk space:", free, "bytes")
+			j.pending.Done()
+			continue
+		}
+		retries.Add(int64(len(failed)))
+		next := job{paths: failed, attempt: jb.attempt + 1}
+		time.AfterFunc(retryDelay*time.Duration(jb.attempt), func() {
+			j.queue <- next
+		})
+	}
+}
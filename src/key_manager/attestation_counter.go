@@ -0,0 +1,72 @@
+package key_manager
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	u "notary/utils"
+	"sync/atomic"
+)
+
+// MAX_COUNTER_HISTORY is how many ephemeral keys' counters are kept. Keys
+// rotate at least every 20 minutes, so this covers at least 2 days.
+const MAX_COUNTER_HISTORY = 144
+
+// AttestationCounter counts the sessions signed by one ephemeral key. Each
+// signed session gets the next value, starting with 1, so verifiers who
+// collect the attestations of a key can spot gaps and duplicates.
+type AttestationCounter struct {
+	// Id is the hex-encoded first 8 bytes of the sha256 of the public key,
+	// as in the key bundle of /getPubKey
+	Id string `json:"id"`
+	// Pubkey is the hex-encoded uncompressed public key
+	Pubkey     string `json:"pubkey"`
+	ValidFrom  uint32 `json:"validFrom"`
+	ValidUntil uint32 `json:"validUntil"`
+	count      atomic.Uint64
+}
+
+// newAttestationCounter creates the counter of the key in keyData
+// (validFrom | validUntil | pubkey | signature)
+func newAttestationCounter(keyData []byte) *AttestationCounter {
+	pubkey := keyData[8:73]
+	return &AttestationCounter{
+		Id:         hex.EncodeToString(u.Sha256(pubkey)[:8]),
+		Pubkey:     hex.EncodeToString(pubkey),
+		ValidFrom:  binary.BigEndian.Uint32(keyData[0:4]),
+		ValidUntil: binary.BigEndian.Uint32(keyData[4:8]),
+	}
+}
+
+// Next returns the counter value for the next signed session
+func (c *AttestationCounter) Next() uint64 {
+	return c.count.Add(1)
+}
+
+// AttestationCount is the state of a counter at one point in time
+type AttestationCount struct {
+	*AttestationCounter
+	// Counter is the value given to the latest signed session, 0 if the key
+	// didn't sign any session yet
+	Counter uint64 `json:"counter"`
+}
+
+// AttestationCounts returns the counters of the active key and of the
+// latest expired keys, the active key first
+func (k *KeyManager) AttestationCounts() []AttestationCount {
+	k.Lock()
+	counters := append([]*AttestationCounter{}, k.counters...)
+	k.Unlock()
+	counts := make([]AttestationCount, len(counters))
+	for i, counter := range counters {
+		counts[len(counters)-1-i] = AttestationCount{counter, counter.count.Load()}
+	}
+	return counts
+}
+
+// addCounter starts counting for a new key. The lock must be held.
+func (k *KeyManager) addCounter(keyData []byte) {
+	k.counters = append(k.counters, newAttestationCounter(keyData))
+	if len(k.counters) > MAX_COUNTER_HISTORY {
+		k.counters = k.counters[len(k.counters)-MAX_COUNTER_HISTORY:]
+	}
+}
@@ -0,0 +1,47 @@
+package key_manager
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// keyData returns validFrom | validUntil | pubkey | signature for a fake key
+func keyData(validFrom uint32, keyByte byte) []byte {
+	data := binary.BigEndian.AppendUint32(nil, validFrom)
+	data = binary.BigEndian.AppendUint32(data, validFrom+1200)
+	for i := 0; i < 65; i++ {
+		data = append(data, keyByte)
+	}
+	return append(data, make([]byte, 64)...)
+}
+
+func TestAttestationCounts(t *testing.T) {
+	k := new(KeyManager)
+	k.addCounter(keyData(100, 1))
+	first := k.counters[0]
+	if first.Next() != 1 || first.Next() != 2 {
+		t.Fatal("counter must start with 1 and increase by 1")
+	}
+	k.addCounter(keyData(200, 2))
+	k.counters[1].Next()
+
+	counts := k.AttestationCounts()
+	if len(counts) != 2 || counts[0].ValidFrom != 200 || counts[0].Counter != 1 ||
+		counts[1].ValidFrom != 100 || counts[1].Counter != 2 {
+		t.Fatal("wrong counts", counts)
+	}
+	if counts[1].ValidUntil != 1300 || len(counts[1].Pubkey) != 130 || len(counts[1].Id) != 16 {
+		t.Fatal("wrong key fields", counts[1].AttestationCounter)
+	}
+}
+
+func TestCounterHistoryLimit(t *testing.T) {
+	k := new(KeyManager)
+	for i := 0; i < MAX_COUNTER_HISTORY+10; i++ {
+		k.addCounter(keyData(uint32(i), byte(i)))
+	}
+	counts := k.AttestationCounts()
+	if len(counts) != MAX_COUNTER_HISTORY || counts[0].ValidFrom != MAX_COUNTER_HISTORY+9 {
+		t.Fatal("expected only the latest keys", len(counts), counts[0].ValidFrom)
+	}
+}
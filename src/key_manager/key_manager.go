@@ -4,7 +4,11 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/x509"
 	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"expvar"
 	"log"
 	u "notary/utils"
 	"os"
@@ -13,6 +17,16 @@ import (
 	"time"
 )
 
+var (
+	// keysIssued counts the ephemeral keys issued since startup
+	keysIssued = expvar.NewInt("key_manager_keys_issued")
+	// activeEpoch is the number of the active key's epoch
+	activeEpoch = expvar.NewInt("key_manager_epoch")
+	// activeKeyRequests counts the calls of GetActiveKey, i.e. the sessions
+	// which got the active key
+	activeKeyRequests = expvar.NewInt("key_manager_active_key_requests")
+)
+
 // KeyManager generates an ephemeral used by notary to sign the session and also
 // to derive symmetric keys for client<->notary communication.
 // The client only accepts notarization sessions signed by an eph.key whose validity
@@ -21,36 +35,115 @@ import (
 
 type KeyManager struct {
 	sync.Mutex
-	// KeyData contains validFrom|validUntil|pubkey|signature
-	// the client will verify the signature (made with the masterKey)
-	KeyData []byte
-	// PrivKey is the ephemeral key used to sign a session. Also used
-	// in ECDH with the the client to derive symmetric keys to encrypt the communication
-	PrivKey *ecdsa.PrivateKey
+	// EpochDuration makes the ephemeral keys rotate at fixed epochs: the
+	// key of epoch n is valid from n*EpochDuration since the Unix epoch for
+	// two epochs, so that sessions which start late in an epoch can still
+	// sign. It must be whole seconds and be set before Init. When 0 the keys
+	// rotate after a random interval.
+	EpochDuration time.Duration
+	// active is the active ephemeral key. An Epoch is never modified, so
+	// that it can be used without the lock once it was taken.
+	active *Epoch
 	// masterKey is used to sign ephemeral keys
 	masterKey *ecdsa.PrivateKey
 	// MasterPubKeyPEM is masterKey public key in PEM format
 	MasterPubKeyPEM []byte
 	// validMins is how many minutes an ephemeral key is valid for signing
 	validMins int
+	// rootKey is an optional long-term key, kept offline except while the
+	// notary runs, which vouches for the master key
+	rootKey *ecdsa.PrivateKey
+	// counters are the attestation counters of the latest ephemeral keys,
+	// the active key's last
+	counters []*AttestationCounter
+}
+
+// Epoch is an ephemeral key with its certificate
+type Epoch struct {
+	// Number is the number of the epoch: the count of EpochDuration since
+	// the Unix epoch, or the count of keys since startup when the keys
+	// rotate after a random interval
+	Number uint64
+	// PrivKey is the ephemeral key used to sign a session. Also used
+	// in ECDH with the the client to derive symmetric keys to encrypt the communication
+	PrivKey *ecdsa.PrivateKey
+	// KeyData contains validFrom|validUntil|pubkey|signature
+	// the client will verify the signature (made with the masterKey)
+	KeyData []byte
+	// Counter counts the sessions signed with PrivKey
+	Counter *AttestationCounter
 }
 
 func (k *KeyManager) Init() {
+	k.validMins = 20
 	k.generateMasterKey()
+	// the first key is issued before any session can ask for it
+	k.Lock()
+	k.activeEpoch(time.Now())
+	k.Unlock()
 	go k.rotateEphemeralKeys()
 }
 
 // GetActiveKey returns the currently active signing key as well as KeyData
-// associated with it
-func (k *KeyManager) GetActiveKey() (ecdsa.PrivateKey, []byte) {
-	// copying data so that it doesn't change from under us if
-	// ephemeral key happens to change while this session is running
+// and the attestation counter associated with it. All calls within an epoch
+// get the same key.
+func (k *KeyManager) GetActiveKey() (ecdsa.PrivateKey, []byte, *AttestationCounter) {
+	activeKeyRequests.Add(1)
+	e := k.ActiveEpoch()
+	// copying data so that the session can't change the epoch's key
+	keyData := make([]byte, len(e.KeyData))
+	copy(keyData, e.KeyData)
+	return *e.PrivKey, keyData, e.Counter
+}
+
+// ActiveEpoch returns the active ephemeral key, which must not be modified
+func (k *KeyManager) ActiveEpoch() *Epoch {
 	k.Lock()
-	keyData := make([]byte, len(k.KeyData))
-	copy(keyData, k.KeyData)
-	key := *k.PrivKey
-	k.Unlock()
-	return key, keyData
+	defer k.Unlock()
+	return k.activeEpoch(time.Now())
+}
+
+// activeEpoch returns the active key at now. With EpochDuration the key of a
+// new epoch is issued by the first caller, so that concurrent sessions at
+// the start of an epoch get the same key. The lock must be held.
+func (k *KeyManager) activeEpoch(now time.Time) *Epoch {
+	if k.EpochDuration > 0 {
+		seconds := int64(k.EpochDuration / time.Second)
+		number := uint64(now.Unix() / seconds)
+		if k.active == nil || k.active.Number != number {
+			validFrom := time.Unix(int64(number)*seconds, 0)
+			k.issueKey(number, validFrom, validFrom.Add(2*k.EpochDuration))
+		}
+	} else if k.active == nil {
+		k.issueKey(1, now, now.Add(time.Duration(k.validMins)*time.Minute))
+	}
+	return k.active
+}
+
+// issueKey makes a new ephemeral key, valid from validFrom until validUntil,
+// the active key. The lock must be held.
+func (k *KeyManager) issueKey(number uint64, validFrom time.Time, validUntil time.Time) {
+	log.Println("changing ephemeral key, epoch", number)
+	from := make([]byte, 4)
+	binary.BigEndian.PutUint32(from, uint32(validFrom.Unix()))
+	until := make([]byte, 4)
+	binary.BigEndian.PutUint32(until, uint32(validUntil.Unix()))
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		log.Fatalln("Could not create keys:", err)
+	}
+	pubkey := u.Concat([]byte{0x04}, u.To32Bytes(newKey.PublicKey.X), u.To32Bytes(newKey.PublicKey.Y))
+	signature := u.ECDSASign(k.masterKey, from, until, pubkey)
+	blob := u.Concat(from, until, pubkey, signature)
+	k.addCounter(blob)
+	k.active = &Epoch{
+		Number:  number,
+		PrivKey: newKey,
+		KeyData: blob,
+		Counter: k.counters[len(k.counters)-1],
+	}
+	keysIssued.Add(1)
+	activeEpoch.Set(int64(number))
 }
 
 // generateMasterKey generates a P-256 master key. The corresponding public key
@@ -73,15 +166,54 @@ func (k *KeyManager) generateMasterKey() {
 	}
 }
 
+// LoadRootKey loads a PEM-encoded EC private key which will be used to sign
+// the master public key
+func (k *KeyManager) LoadRootKey(path string) error {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(file)
+	if block == nil {
+		return errors.New("root key is not in PEM format")
+	}
+	rootKey, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	k.rootKey = rootKey
+	return nil
+}
+
+// SignWithMasterKey returns a 64-byte r||s signature over the sha256 of data
+func (k *KeyManager) SignWithMasterKey(data []byte) []byte {
+	return u.ECDSASign(k.masterKey, data)
+}
+
+// SignWithRootKey is like SignWithMasterKey but uses the root key. It
+// returns nil if no root key was loaded.
+func (k *KeyManager) SignWithRootKey(data []byte) []byte {
+	if k.rootKey == nil {
+		return nil
+	}
+	return u.ECDSASign(k.rootKey, data)
+}
+
 // generate a new ephemeral key after a certain interval
 // sign it with the master key
 func (k *KeyManager) rotateEphemeralKeys() {
-	k.validMins = 20
-	// initially setting to zero to immediately trigger a key rotation
-	nextKeyRotationTime := time.Unix(0, 0)
+	// the first key was issued by Init
+	nextKeyRotationTime := time.Now().Add(time.Second * time.Duration(u.RandInt(k.validMins/2*60, k.validMins*60)))
 	for {
 		time.Sleep(time.Second * 1)
 		now := time.Now()
+		if k.EpochDuration > 0 {
+			// issue the key of a new epoch if no session did yet
+			k.Lock()
+			k.activeEpoch(now)
+			k.Unlock()
+			continue
+		}
 		// start key rotation no sooner than 2 mins before the current eph. key
 		// is set to expire
 		if nextKeyRotationTime.Sub(now) > time.Minute*2 {
@@ -93,22 +225,8 @@ func (k *KeyManager) rotateEphemeralKeys() {
 		nextKeyRotationTime = now.Add(time.Second * time.Duration(randInt))
 
 		// else change the ephemeral key
-		log.Println("changing ephemeral key")
-		validFrom := make([]byte, 4)
-		binary.BigEndian.PutUint32(validFrom, uint32(now.Unix()))
-		validUntil := make([]byte, 4)
-		untilTime := now.Add(time.Second * time.Duration(k.validMins*60))
-		binary.BigEndian.PutUint32(validUntil, uint32(untilTime.Unix()))
-		newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-		if err != nil {
-			log.Fatalln("Could not create keys:", err)
-		}
-		pubkey := u.Concat([]byte{0x04}, u.To32Bytes(newKey.PublicKey.X), u.To32Bytes(newKey.PublicKey.Y))
-		signature := u.ECDSASign(k.masterKey, validFrom, validUntil, pubkey)
-		blob := u.Concat(validFrom, validUntil, pubkey, signature)
 		k.Lock()
-		k.KeyData = blob
-		k.PrivKey = newKey
+		k.issueKey(k.active.Number+1, now, now.Add(time.Second*time.Duration(k.validMins*60)))
 		k.Unlock()
 	}
 }
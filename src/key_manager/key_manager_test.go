@@ -0,0 +1,73 @@
+package key_manager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestKeyManager(t *testing.T, epoch time.Duration) *KeyManager {
+	masterKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &KeyManager{EpochDuration: epoch, masterKey: masterKey, validMins: 20}
+}
+
+func TestEpochKeyIsStable(t *testing.T) {
+	k := newTestKeyManager(t, 10*time.Minute)
+	start := time.Unix(6000, 0)
+	issued := keysIssued.Value()
+
+	// concurrent sessions at the start of an epoch get the same key
+	epochs := make([]*Epoch, 16)
+	var wg sync.WaitGroup
+	for i := range epochs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			k.Lock()
+			epochs[i] = k.activeEpoch(start.Add(time.Duration(i) * time.Second))
+			k.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	for _, e := range epochs {
+		if e != epochs[0] {
+			t.Fatal("the sessions of an epoch must get the same key")
+		}
+	}
+	if keysIssued.Value() != issued+1 || len(k.counters) != 1 {
+		t.Fatal("one key must be issued per epoch", keysIssued.Value()-issued)
+	}
+	e := epochs[0]
+	if e.Number != 10 || binary.BigEndian.Uint32(e.KeyData[0:4]) != 6000 || binary.BigEndian.Uint32(e.KeyData[4:8]) != 7200 {
+		t.Fatal("unexpected epoch", e.Number, e.KeyData[:8])
+	}
+
+	k.Lock()
+	next := k.activeEpoch(start.Add(10 * time.Minute))
+	k.Unlock()
+	if next.Number != 11 || next.PrivKey.Equal(e.PrivKey) || next.Counter == e.Counter {
+		t.Fatal("a new epoch must get a new key")
+	}
+}
+
+func TestActiveEpochWithoutEpochDuration(t *testing.T) {
+	k := newTestKeyManager(t, 0)
+	now := time.Unix(6000, 0)
+	k.Lock()
+	first := k.activeEpoch(now)
+	later := k.activeEpoch(now.Add(time.Hour))
+	k.Unlock()
+	if first != later || first.Number != 1 {
+		t.Fatal("without epochs the key only changes when it is rotated")
+	}
+	if binary.BigEndian.Uint32(first.KeyData[4:8]) != 6000+20*60 {
+		t.Fatal("unexpected validity", first.KeyData[:8])
+	}
+}
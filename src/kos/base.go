@@ -0,0 +1,97 @@
+package kos
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+)
+
+// POINT_SIZE is the size of an uncompressed P-256 point
+const POINT_SIZE = 65
+
+var p256 = elliptic.P256()
+
+// The base OTs are the "simplest OT" of Chou and Orlandi on P-256. The party
+// which sends in the extension receives in the base OTs: its choice bits are
+// the bits of delta.
+
+// baseSend runs KAPPA base OTs as the sender and returns both keys of each
+func baseSend(conn io.ReadWriter) (keys0, keys1 [][]byte, err error) {
+	a, Ax, Ay, err := elliptic.GenerateKey(p256, rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	A := elliptic.Marshal(p256, Ax, Ay)
+	if _, err = conn.Write(A); err != nil {
+		return nil, nil, err
+	}
+	Bs := make([]byte, KAPPA*POINT_SIZE)
+	if _, err = io.ReadFull(conn, Bs); err != nil {
+		return nil, nil, err
+	}
+	// a(B - A) = aB - aA
+	aAx, aAy := p256.ScalarMult(Ax, Ay, a)
+	aAy.Sub(p256.Params().P, aAy)
+	keys0 = make([][]byte, KAPPA)
+	keys1 = make([][]byte, KAPPA)
+	for i := 0; i < KAPPA; i++ {
+		B := Bs[i*POINT_SIZE : (i+1)*POINT_SIZE]
+		Bx, By := elliptic.Unmarshal(p256, B)
+		if Bx == nil {
+			return nil, nil, errors.New("kos: invalid base OT point")
+		}
+		aBx, aBy := p256.ScalarMult(Bx, By, a)
+		keys0[i] = baseKey(A, B, i, marshal(aBx, aBy))
+		keys1[i] = baseKey(A, B, i, marshal(p256.Add(aBx, aBy, aAx, aAy)))
+	}
+	return keys0, keys1, nil
+}
+
+// baseReceive runs KAPPA base OTs as the receiver, choosing by the bits of
+// delta, and returns the chosen keys
+func baseReceive(conn io.ReadWriter, delta block) (keys [][]byte, err error) {
+	A := make([]byte, POINT_SIZE)
+	if _, err = io.ReadFull(conn, A); err != nil {
+		return nil, err
+	}
+	Ax, Ay := elliptic.Unmarshal(p256, A)
+	if Ax == nil {
+		return nil, errors.New("kos: invalid base OT point")
+	}
+	Bs := make([]byte, 0, KAPPA*POINT_SIZE)
+	keys = make([][]byte, KAPPA)
+	for i := 0; i < KAPPA; i++ {
+		b, Bx, By, err := elliptic.GenerateKey(p256, rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		if delta.bit(i) == 1 {
+			Bx, By = p256.Add(Bx, By, Ax, Ay)
+		}
+		B := elliptic.Marshal(p256, Bx, By)
+		Bs = append(Bs, B...)
+		keys[i] = baseKey(A, B, i, marshal(p256.ScalarMult(Ax, Ay, b)))
+	}
+	if _, err = conn.Write(Bs); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// baseKey hashes the shared point of the i-th base OT into a PRG seed
+func baseKey(A, B []byte, i int, shared []byte) []byte {
+	h := sha256.New()
+	h.Write(A)
+	h.Write(B)
+	binary.Write(h, binary.LittleEndian, uint32(i))
+	h.Write(shared)
+	return h.Sum(nil)[:BLOCK_SIZE]
+}
+
+func marshal(x, y *big.Int) []byte {
+	return elliptic.Marshal(p256, x, y)
+}
@@ -0,0 +1,119 @@
+package kos
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+)
+
+// block is an element of GF(2^128), bit i of the little-endian 128 bit
+// integer lo|hi<<64 being the coefficient of x^i
+type block struct {
+	lo, hi uint64
+}
+
+func blockFrom(b []byte) block {
+	return block{binary.LittleEndian.Uint64(b), binary.LittleEndian.Uint64(b[8:])}
+}
+
+func (a block) put(b []byte) {
+	binary.LittleEndian.PutUint64(b, a.lo)
+	binary.LittleEndian.PutUint64(b[8:], a.hi)
+}
+
+func (a block) xor(b block) block {
+	return block{a.lo ^ b.lo, a.hi ^ b.hi}
+}
+
+func (a block) bit(i int) uint64 {
+	if i < 64 {
+		return a.lo >> i & 1
+	}
+	return a.hi >> (i - 64) & 1
+}
+
+// mul multiplies in GF(2^128) modulo x^128 + x^7 + x^2 + x + 1
+func (a block) mul(b block) block {
+	var r block
+	for i := 0; i < 128; i++ {
+		mask := -b.bit(i)
+		r.lo ^= a.lo & mask
+		r.hi ^= a.hi & mask
+		carry := a.hi >> 63
+		a.hi = a.hi<<1 | a.lo>>63
+		a.lo = a.lo<<1 ^ 0x87&-carry
+	}
+	return r
+}
+
+// fixedKey is the public key of the permutation of the hash
+var fixedKey = []byte("tlsnotary kos 1\x00")
+
+var fixedCipher, _ = aes.NewCipher(fixedKey)
+
+// hash is the tweakable correlation robust hash
+// H(j, x) = π(π(x) ⊕ j) ⊕ π(x) of Guo et al. with a fixed-key AES π
+func hash(j uint64, x block) block {
+	var buf [BLOCK_SIZE]byte
+	x.put(buf[:])
+	fixedCipher.Encrypt(buf[:], buf[:])
+	px := blockFrom(buf[:])
+	px.xor(block{lo: j}).put(buf[:])
+	fixedCipher.Encrypt(buf[:], buf[:])
+	return blockFrom(buf[:]).xor(px)
+}
+
+// prg expands a seed with AES-128 in counter mode. The stream continues
+// across extensions, so that every extension uses fresh output.
+type prg struct {
+	cipher.Stream
+}
+
+func newPrg(seed []byte) prg {
+	c, err := aes.NewCipher(seed)
+	if err != nil {
+		panic(err)
+	}
+	return prg{cipher.NewCTR(c, make([]byte, aes.BlockSize))}
+}
+
+// next returns the next n bytes of the stream
+func (p prg) next(n int) []byte {
+	out := make([]byte, n)
+	p.XORKeyStream(out, out)
+	return out
+}
+
+// transpose turns the KAPPA columns of m bits into m rows of KAPPA bits. Bit
+// j of a column is bit j%8 of its byte j/8, bit i of a row likewise.
+func transpose(columns [][]byte, m int) []block {
+	rows := make([]byte, m*BLOCK_SIZE)
+	for i := 0; i < KAPPA; i += 8 {
+		for b := 0; b < m/8; b++ {
+			var x uint64
+			for k := 0; k < 8; k++ {
+				x |= uint64(columns[i+k][b]) << (8 * k)
+			}
+			x = transpose8(x)
+			for l := 0; l < 8; l++ {
+				rows[(8*b+l)*BLOCK_SIZE+i/8] = byte(x >> (8 * l))
+			}
+		}
+	}
+	blocks := make([]block, m)
+	for j := range blocks {
+		blocks[j] = blockFrom(rows[j*BLOCK_SIZE:])
+	}
+	return blocks
+}
+
+// transpose8 transposes the 8x8 bit matrix whose bit 8r+c is row r, column c
+func transpose8(x uint64) uint64 {
+	t := (x ^ x>>7) & 0x00AA00AA00AA00AA
+	x ^= t ^ t<<7
+	t = (x ^ x>>14) & 0x0000CCCC0000CCCC
+	x ^= t ^ t<<14
+	t = (x ^ x>>28) & 0x00000000F0F0F0F0
+	x ^= t ^ t<<28
+	return x
+}
@@ -0,0 +1,212 @@
+// Package kos implements the OT extension of Keller, Orsini and Scholl
+// (KOS15) in pure Go. It transfers 16 byte messages: the sender inputs a pair
+// of messages for each OT and the receiver learns the one of its choice.
+//
+// Once per direction of a connection, the parties run KAPPA base OTs. Every
+// extension of n OTs then exchanges:
+//
+//	receiver -> sender: n as uint64 LE, u (KAPPA columns of m/8 bytes)
+//	sender -> receiver: the seed of the check's challenges (16 bytes)
+//	receiver -> sender: x (16 bytes), t (16 bytes)
+//	sender -> receiver: n pairs of masked messages (32 bytes each)
+//
+// where m is n rounded up to a multiple of KAPPA plus KAPPA random OTs which
+// hide the choices from the consistency check.
+package kos
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// KAPPA is the computational security parameter and the amount of base OTs
+const KAPPA = 128
+
+// BLOCK_SIZE is the size of the messages
+const BLOCK_SIZE = 16
+
+// extensionSize returns m for n OTs
+func extensionSize(n int) int {
+	return (n+KAPPA-1)/KAPPA*KAPPA + KAPPA
+}
+
+// Sender sends pairs of messages over a connection
+type Sender struct {
+	conn  io.ReadWriter
+	delta block
+	// prgs expand the base OT keys chosen by delta
+	prgs []prg
+	// count is the amount of OTs done so far, which tweaks the hash
+	count uint64
+}
+
+// NewSender runs the base OTs with the receiver at the other end of conn
+func NewSender(conn io.ReadWriter) (*Sender, error) {
+	var d [BLOCK_SIZE]byte
+	if _, err := rand.Read(d[:]); err != nil {
+		return nil, err
+	}
+	s := &Sender{conn: conn, delta: blockFrom(d[:])}
+	keys, err := baseReceive(conn, s.delta)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		s.prgs = append(s.prgs, newPrg(key))
+	}
+	return s, nil
+}
+
+// Send transfers the pairs of messages m0|m1 in pairs. The receiver must
+// choose among the same amount of pairs.
+func (s *Sender) Send(pairs []byte) error {
+	if len(pairs)%(2*BLOCK_SIZE) != 0 {
+		return errors.New("kos: the size of the pairs is not a multiple of 32")
+	}
+	n := len(pairs) / (2 * BLOCK_SIZE)
+	m := extensionSize(n)
+	var header [8]byte
+	if _, err := io.ReadFull(s.conn, header[:]); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint64(header[:]) != uint64(n) {
+		return errors.New("kos: the receiver requested a different amount of OTs")
+	}
+	u := make([]byte, KAPPA*m/8)
+	if _, err := io.ReadFull(s.conn, u); err != nil {
+		return err
+	}
+	// q^i = G(k_i^delta_i) ⊕ delta_i·u^i, so that row q_j = t_j ⊕ r_j·delta
+	columns := make([][]byte, KAPPA)
+	for i := range columns {
+		columns[i] = s.prgs[i].next(m / 8)
+		if s.delta.bit(i) == 1 {
+			ui := u[i*m/8 : (i+1)*m/8]
+			for b := range columns[i] {
+				columns[i][b] ^= ui[b]
+			}
+		}
+	}
+	q := transpose(columns, m)
+
+	seed := make([]byte, BLOCK_SIZE)
+	if _, err := rand.Read(seed); err != nil {
+		return err
+	}
+	if _, err := s.conn.Write(seed); err != nil {
+		return err
+	}
+	var xt [2 * BLOCK_SIZE]byte
+	if _, err := io.ReadFull(s.conn, xt[:]); err != nil {
+		return err
+	}
+	chi := newPrg(seed)
+	var sum block
+	for j := 0; j < m; j++ {
+		sum = sum.xor(blockFrom(chi.next(BLOCK_SIZE)).mul(q[j]))
+	}
+	x, t := blockFrom(xt[:]), blockFrom(xt[BLOCK_SIZE:])
+	if sum != t.xor(x.mul(s.delta)) {
+		return errors.New("kos: the receiver failed the consistency check")
+	}
+
+	y := make([]byte, len(pairs))
+	for j := 0; j < n; j++ {
+		tweak := s.count + uint64(j)
+		pair := pairs[2*BLOCK_SIZE*j:]
+		blockFrom(pair).xor(hash(tweak, q[j])).put(y[2*BLOCK_SIZE*j:])
+		blockFrom(pair[BLOCK_SIZE:]).xor(hash(tweak, q[j].xor(s.delta))).put(y[2*BLOCK_SIZE*j+BLOCK_SIZE:])
+	}
+	s.count += uint64(n)
+	_, err := s.conn.Write(y)
+	return err
+}
+
+// Receiver chooses messages from the sender's pairs
+type Receiver struct {
+	conn io.ReadWriter
+	// prgs0 and prgs1 expand both keys of each base OT
+	prgs0, prgs1 []prg
+	count        uint64
+}
+
+// NewReceiver runs the base OTs with the sender at the other end of conn
+func NewReceiver(conn io.ReadWriter) (*Receiver, error) {
+	keys0, keys1, err := baseSend(conn)
+	if err != nil {
+		return nil, err
+	}
+	r := &Receiver{conn: conn}
+	for i := range keys0 {
+		r.prgs0 = append(r.prgs0, newPrg(keys0[i]))
+		r.prgs1 = append(r.prgs1, newPrg(keys1[i]))
+	}
+	return r, nil
+}
+
+// Receive returns the 16 byte messages chosen by the 0/1 choices
+func (r *Receiver) Receive(choices []int) ([]byte, error) {
+	n := len(choices)
+	m := extensionSize(n)
+	// the choices padded with random ones
+	bits := make([]byte, m/8)
+	if _, err := rand.Read(bits[n/8:]); err != nil {
+		return nil, err
+	}
+	for j, choice := range choices {
+		if choice != 0 && choice != 1 {
+			return nil, errors.New("kos: choices must be 0 or 1")
+		}
+		bits[j/8] &^= 1 << (j % 8)
+		bits[j/8] |= byte(choice) << (j % 8)
+	}
+	msg := make([]byte, 8, 8+KAPPA*m/8)
+	binary.LittleEndian.PutUint64(msg, uint64(n))
+	columns := make([][]byte, KAPPA)
+	for i := range columns {
+		columns[i] = r.prgs0[i].next(m / 8)
+		ui := r.prgs1[i].next(m / 8)
+		for b := range ui {
+			ui[b] ^= columns[i][b] ^ bits[b]
+		}
+		msg = append(msg, ui...)
+	}
+	if _, err := r.conn.Write(msg); err != nil {
+		return nil, err
+	}
+	t := transpose(columns, m)
+
+	seed := make([]byte, BLOCK_SIZE)
+	if _, err := io.ReadFull(r.conn, seed); err != nil {
+		return nil, err
+	}
+	chi := newPrg(seed)
+	var x, sum block
+	for j := 0; j < m; j++ {
+		c := blockFrom(chi.next(BLOCK_SIZE))
+		if bits[j/8]>>(j%8)&1 == 1 {
+			x = x.xor(c)
+		}
+		sum = sum.xor(c.mul(t[j]))
+	}
+	var xt [2 * BLOCK_SIZE]byte
+	x.put(xt[:])
+	sum.put(xt[BLOCK_SIZE:])
+	if _, err := r.conn.Write(xt[:]); err != nil {
+		return nil, err
+	}
+
+	y := make([]byte, 2*BLOCK_SIZE*n)
+	if _, err := io.ReadFull(r.conn, y); err != nil {
+		return nil, err
+	}
+	out := make([]byte, BLOCK_SIZE*n)
+	for j, choice := range choices {
+		chosen := y[2*BLOCK_SIZE*j+BLOCK_SIZE*choice:]
+		blockFrom(chosen).xor(hash(r.count+uint64(j), t[j])).put(out[BLOCK_SIZE*j:])
+	}
+	r.count += uint64(n)
+	return out, nil
+}
@@ -0,0 +1,135 @@
+package kos
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	mathrand "math/rand"
+	"net"
+	"testing"
+)
+
+func TestTranspose(t *testing.T) {
+	m := 3 * KAPPA
+	columns := make([][]byte, KAPPA)
+	for i := range columns {
+		columns[i] = make([]byte, m/8)
+		rand.Read(columns[i])
+	}
+	rows := transpose(columns, m)
+	for i := 0; i < KAPPA; i++ {
+		for j := 0; j < m; j++ {
+			if uint64(columns[i][j/8]>>(j%8)&1) != rows[j].bit(i) {
+				t.Fatalf("bit %d of row %d differs", i, j)
+			}
+		}
+	}
+}
+
+func TestMul(t *testing.T) {
+	one := block{lo: 1}
+	x := block{lo: 2}
+	// x^127 * x = x^128 = x^7 + x^2 + x + 1
+	if (block{hi: 1 << 63}).mul(x) != (block{lo: 0x87}) {
+		t.Error("wrong reduction")
+	}
+	a, b, c := block{mathrand.Uint64(), mathrand.Uint64()}, block{mathrand.Uint64(), mathrand.Uint64()}, block{mathrand.Uint64(), mathrand.Uint64()}
+	if a.mul(one) != a || a.mul(b) != b.mul(a) || a.mul(b).mul(c) != a.mul(b.mul(c)) || a.mul(b.xor(c)) != a.mul(b).xor(a.mul(c)) {
+		t.Error("not a field multiplication")
+	}
+}
+
+// pair runs the base OTs of both directions over a pipe
+func pair(t *testing.T) (*Sender, *Receiver, net.Conn, net.Conn) {
+	a, b := net.Pipe()
+	var r *Receiver
+	var err error
+	done := make(chan struct{})
+	go func() {
+		r, err = NewReceiver(b)
+		close(done)
+	}()
+	s, sErr := NewSender(a)
+	<-done
+	if sErr != nil || err != nil {
+		t.Fatal(sErr, err)
+	}
+	return s, r, a, b
+}
+
+func TestTransfer(t *testing.T) {
+	s, r, a, b := pair(t)
+	defer a.Close()
+	defer b.Close()
+	for _, n := range []int{1, 127, 128, 1000} {
+		pairs := make([]byte, 2*BLOCK_SIZE*n)
+		rand.Read(pairs)
+		choices := make([]int, n)
+		for j := range choices {
+			choices[j] = mathrand.Intn(2)
+		}
+		errs := make(chan error, 1)
+		go func() {
+			errs <- s.Send(pairs)
+		}()
+		out, err := r.Receive(choices)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = <-errs; err != nil {
+			t.Fatal(err)
+		}
+		for j, choice := range choices {
+			want := pairs[2*BLOCK_SIZE*j+BLOCK_SIZE*choice:][:BLOCK_SIZE]
+			if !bytes.Equal(out[BLOCK_SIZE*j:][:BLOCK_SIZE], want) {
+				t.Fatalf("n=%d: OT %d got the wrong message", n, j)
+			}
+		}
+	}
+}
+
+// tamper flips a bit of the receiver's check message
+type tamper struct {
+	net.Conn
+	writes int
+}
+
+func (c *tamper) Write(p []byte) (int, error) {
+	c.writes++
+	if c.writes == 2 {
+		p = append([]byte{}, p...)
+		p[0] ^= 1
+	}
+	return c.Conn.Write(p)
+}
+
+func TestConsistencyCheck(t *testing.T) {
+	s, r, a, b := pair(t)
+	defer a.Close()
+	defer b.Close()
+	r.conn = &tamper{Conn: b}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Send(make([]byte, 2*BLOCK_SIZE*10))
+		a.Close()
+	}()
+	_, err := r.Receive(make([]int, 10))
+	if err != io.EOF && err != io.ErrClosedPipe {
+		t.Error("unexpected receiver error", err)
+	}
+	if err = <-errs; err == nil {
+		t.Error("the consistency check passed")
+	}
+}
+
+func TestCountMismatch(t *testing.T) {
+	s, r, a, b := pair(t)
+	defer b.Close()
+	go func() {
+		r.Receive(make([]int, 5))
+	}()
+	if s.Send(make([]byte, 2*BLOCK_SIZE*6)) == nil {
+		t.Error("a different amount of OTs was accepted")
+	}
+	a.Close()
+}
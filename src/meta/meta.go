@@ -2,6 +2,11 @@
 
 package meta
 
+import (
+	"strconv"
+	"strings"
+)
+
 // Gate represents a circuit's gate
 type Gate struct {
 	// Id is gate number, Ids start with 0 and increment
@@ -51,3 +56,58 @@ func GetOutputSizes(idx int) []int {
 		[]int{128}}
 	return outputSizes[idx]
 }
+
+// AndGateTableSize returns the byte size of a garbled AND gate's truth table.
+// With GRR3 the table has 3 rows of 16 bytes. With half-gates it has 2 rows.
+func AndGateTableSize(halfGates bool) int {
+	if halfGates {
+		return 32
+	}
+	return 48
+}
+
+// ParseCircuit parses a circuit in the "Bristol fashion" format into a
+// compact representation which can be processed gate-by-gate
+func ParseCircuit(text string) *Circuit {
+	lines := strings.Split(text, "\n")
+	c := Circuit{}
+	wireCount, _ := strconv.ParseInt(strings.Split(lines[0], " ")[1], 10, 32)
+	gi, _ := strconv.ParseInt(strings.Split(lines[1], " ")[1], 10, 32)
+	ei, _ := strconv.ParseInt(strings.Split(lines[1], " ")[2], 10, 32)
+	out, _ := strconv.ParseInt(strings.Split(lines[2], " ")[1], 10, 32)
+
+	c.WireCount = int(wireCount)
+	c.NotaryInputSize = int(gi)
+	c.ClientInputSize = int(ei)
+	c.OutputSize = int(out)
+
+	gates := make([]Gate, len(lines)-3)
+	andGateCount := 0
+	opBytes := map[string]byte{"XOR": 0, "AND": 1, "INV": 2}
+
+	for i, line := range lines[3:] {
+		items := strings.Split(line, " ")
+		var g Gate
+		g.Operation = opBytes[items[len(items)-1]]
+		g.Id = uint32(i)
+		if g.Operation == 0 || g.Operation == 1 {
+			inp1, _ := strconv.ParseInt(items[2], 10, 32)
+			inp2, _ := strconv.ParseInt(items[3], 10, 32)
+			out, _ := strconv.ParseInt(items[4], 10, 32)
+			g.InputWires = []uint32{uint32(inp1), uint32(inp2)}
+			g.OutputWire = uint32(out)
+			if g.Operation == 1 {
+				andGateCount += 1
+			}
+		} else { // INV gate
+			inp1, _ := strconv.ParseInt(items[2], 10, 32)
+			out, _ := strconv.ParseInt(items[3], 10, 32)
+			g.InputWires = []uint32{uint32(inp1)}
+			g.OutputWire = uint32(out)
+		}
+		gates[i] = g
+	}
+	c.Gates = gates
+	c.AndGateCount = int(andGateCount)
+	return &c
+}
@@ -5,48 +5,110 @@ import "C"
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"runtime/debug"
+	"strconv"
+	"strings"
 	"syscall"
 
 	"net/http"
 	_ "net/http/pprof"
 	at "notary/aes_tag"
+	"notary/api_error"
+	"notary/attestation"
+	"notary/audit_log"
+	"notary/ban_list"
+	"notary/chaos"
+	"notary/config"
+	"notary/directory"
+	"notary/egress"
 	"notary/garbled_pool"
+	"notary/grpc_api"
+	"notary/jail"
+	"notary/janitor"
 	"notary/key_manager"
+	"notary/numeric_claim"
+	"notary/object_store"
+	"notary/ot_broker"
 	"notary/ote"
+	"notary/policy"
+	"notary/probe"
+	"notary/rng_health"
 	"notary/session"
 	"notary/session_manager"
+	"notary/step_chain"
+	"notary/tag_signature"
+	"notary/transparency_log"
+	u "notary/utils"
+	"notary/websocket"
 	"notary/zkey"
 
 	"time"
+
+	"golang.org/x/crypto/acme/autocert"
 )
 
 var sm *session_manager.SessionManager
 var gp *garbled_pool.GarbledPool
 var km *key_manager.KeyManager
+var bl *ban_list.BanList
+var al *audit_log.AuditLog
+
+// otBroker is set when the -ot-broker flag is given
+var otBroker *ot_broker.Client
+
+// deterministicSignatures is set with the -deterministic-signatures flag
+var deterministicSignatures bool
+
+// attestationMetrics is set with the -attestation-metrics flag
+var attestationMetrics bool
+
+// verifierOnly is set with the -verifier-only flag
+var verifierOnly bool
+
+// otImplementation is set with the -ot-implementation flag
+var otImplementation ote.Implementation
+
+// maxBlobSize is set with the -max-blob-size flag
+var maxBlobSize int64
+
+// discardConsumedBlobs is set with the -discard-consumed-blobs flag
+var discardConsumedBlobs bool
+
+// blobBudget is the memory for blobs set with the -memory-blob-budget flag,
+// nil if the blobs are always written to disk
+var blobBudget *session.BlobBudget
 
 // URLFetcherDoc is the document returned by the deterministic URLFetcher enclave
 // https://github.com/tlsnotary/URLFetcher
 // It contains AWS HTTP API requests with Amazon's attestation
 var URLFetcherDoc []byte
 
-// readBody extracts the HTTP request's body
-func readBody(req *http.Request) []byte {
+// readBody extracts the HTTP request's body. It fails e.g. when the client
+// disconnects while sending it.
+func readBody(req *http.Request) ([]byte, error) {
 	defer req.Body.Close()
-	body, err := io.ReadAll(req.Body)
-	if err != nil {
-		panic("can't read request body")
-	}
-	return body
+	return io.ReadAll(req.Body)
+}
+
+// writeBodyError answers a request whose body couldn't be read
+func writeBodyError(w http.ResponseWriter, req *http.Request, err error) {
+	log.Println("can't read request body:", err, req.RemoteAddr)
+	api_error.Write(w, http.StatusBadRequest, api_error.INVALID_REQUEST, "can't read the request body")
 }
 
 // writeResponse appends the CORS headers needed to keep the browser happy
@@ -63,101 +125,518 @@ func getURLFetcherDoc(w http.ResponseWriter, req *http.Request) {
 	writeResponse(URLFetcherDoc, w)
 }
 
-// destroyOnPanic will be called on panic(). It will destroy the session which
-// caused the panic
-func destroyOnPanic(s *session.Session) {
+// destroyOnPanic will be called on panic(). A panic is a bug of the notary:
+// the session which caused it is destroyed and the error is written to w
+// unless w is nil, e.g. because the response was already started.
+func destroyOnPanic(w http.ResponseWriter, s *session.Session, req *http.Request) {
 	r := recover()
 	if r == nil {
 		return // there was no panic
 	}
 	fmt.Println("caught a panic message: ", r)
 	debug.PrintStack()
-	s.DestroyChan <- s.Sid
-	s.OtReleaseChan <- s.Sid
+	s.Destroy()
+	if w != nil {
+		api_error.Write(w, http.StatusInternalServerError, api_error.SESSION_FAILED, "")
+	}
 }
 
-func httpHandler(w http.ResponseWriter, req *http.Request) {
-	// sessionId is the part of the URL after ?
-	sessionId := string(req.URL.RawQuery)
-	// command is URL path without the leading /
-	command := req.URL.Path[1:]
-	commandAllowed := false
-	for _, allowedCommand := range session_manager.CommandList {
-		if allowedCommand == command {
-			commandAllowed = true
-			break
-		}
+// stepErrorStatus returns the HTTP status and error code of an error
+// returned by a session method
+func stepErrorStatus(err error) (int, api_error.Code) {
+	switch {
+	case errors.Is(err, session.ErrInvalidMessage):
+		return http.StatusBadRequest, api_error.INVALID_REQUEST
+	case errors.Is(err, session.ErrOutOfOrder):
+		return http.StatusConflict, api_error.OUT_OF_ORDER
+	case errors.Is(err, session.ErrBlobTooLarge):
+		return http.StatusRequestEntityTooLarge, api_error.BLOB_TOO_LARGE
+	case errors.Is(err, session.ErrBlobIncomplete):
+		return http.StatusConflict, api_error.BLOB_INCOMPLETE
+	case errors.Is(err, session.ErrSLAExceeded):
+		return http.StatusRequestTimeout, api_error.SLA_EXCEEDED
+	case errors.Is(err, session.ErrCheatingDetected):
+		return http.StatusForbidden, api_error.CHEATING_DETECTED
 	}
+	return http.StatusInternalServerError, api_error.SESSION_FAILED
+}
 
-	if !commandAllowed {
-		w.WriteHeader(http.StatusNotFound)
+// failSession destroys the session after a session method returned err. If
+// the client cheated, it is also banned. The error is written to w as JSON
+// unless w is nil. The message of internal errors is not sent to the
+// client.
+func failSession(w http.ResponseWriter, s *session.Session, req *http.Request, err error) {
+	log.Println("session", s.Sid, "failed:", err)
+	status, code := stepErrorStatus(err)
+	if code == api_error.CHEATING_DETECTED {
+		var evidence *session.CheatEvidence
+		if errors.As(err, &evidence) {
+			auditErr := al.Record("cheat_detected", struct {
+				*session.CheatEvidence
+				RemoteAddr string `json:"remoteAddr"`
+			}{evidence, req.RemoteAddr})
+			if auditErr != nil {
+				log.Println("could not write audit log:", auditErr)
+			}
+		}
+		banClient(req, s.Sid, err.Error())
+	}
+	s.Destroy()
+	if w == nil {
 		return
 	}
+	message := err.Error()
+	if code == api_error.SESSION_FAILED {
+		message = ""
+	}
+	api_error.WriteJSON(w, status, code, message)
+}
 
-	if commandAllowed && sessionId == "" {
-		w.WriteHeader(http.StatusBadRequest)
+// banClient bans the IP address and the API key (if any) of the request
+func banClient(req *http.Request, sid string, reason string) {
+	ip := ban_list.RequestIP(req)
+	err := bl.Ban(ban_list.KIND_IP, ip, 0, reason)
+	if err != nil {
+		log.Println("could not ban IP:", err)
+	}
+	apiKey := req.Header.Get("X-Api-Key")
+	if apiKey != "" {
+		err = bl.Ban(ban_list.KIND_API_KEY, apiKey, 0, reason)
+		if err != nil {
+			log.Println("could not ban API key:", err)
+		}
+	}
+	err = al.Record("client_banned", map[string]string{"sid": sid, "ip": ip, "apiKey": apiKey, "reason": reason})
+	if err != nil {
+		log.Println("could not write audit log:", err)
+	}
+}
+
+// rejectBanned writes 403 Forbidden if the client is banned. Returns true if
+// the request was rejected.
+func rejectBanned(w http.ResponseWriter, req *http.Request) bool {
+	if !bl.IsRequestBanned(req) {
+		return false
+	}
+	log.Println("rejected request from banned client", req.RemoteAddr)
+	api_error.Write(w, http.StatusForbidden, api_error.CLIENT_BANNED, "")
+	return true
+}
+
+// unlessBanned wraps handler so that it rejects banned clients
+func unlessBanned(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if rejectBanned(w, req) {
+			return
+		}
+		handler(w, req)
+	}
+}
+
+// serveOtTunnel carries the OT connection of the session ?<sid> in the
+// binary messages of a WebSocket on the public port, for clients which can't
+// open a TCP connection to the OT port
+func serveOtTunnel(w http.ResponseWriter, req *http.Request) {
+	if rejectBanned(w, req) {
+		return
+	}
+	s := sm.GetSession(req.URL.RawQuery)
+	if s == nil || s.Ot == nil {
+		api_error.Write(w, http.StatusNotFound, api_error.SESSION_NOT_FOUND, "")
+		return
+	}
+	conn, err := websocket.Upgrade(w, req)
+	if err != nil {
+		log.Println("OT tunnel upgrade failed:", err, req.RemoteAddr)
 		return
 	}
+	if err = s.Ot.Attach(websocket.NetConn(conn)); err != nil {
+		log.Println("OT tunnel failed:", err, req.RemoteAddr)
+		conn.CloseWithStatus(websocket.CLOSE_TRY_AGAIN_LATER)
+		return
+	}
+	log.Println("OT tunnel connected", req.RemoteAddr)
+}
+
+// newStepChain returns the handler of the protocol steps. Cross-cutting
+// features hook into the chain, see step_chain.
+func newStepChain(rateLimit int, rateBurst int) *step_chain.Chain {
+	chain := step_chain.New(dispatchStep)
+	chain.Use("route", routeStep)
+	chain.Use("auth", authStep)
+	chain.Use("rate_limit", step_chain.RateLimit(rateLimit, rateBurst))
+	chain.Use("read_body", readBodyStep)
+	chain.Use("session", sessionStep)
+	chain.Use("response", responseStep)
+	return chain
+}
+
+// routeStep only lets through known commands with a session id
+func routeStep(next step_chain.Handler) step_chain.Handler {
+	return func(c *step_chain.Context) {
+		// sessionId is the part of the URL after ?
+		c.Sid = string(c.Req.URL.RawQuery)
+		// command is URL path without the leading /
+		c.Command = c.Req.URL.Path[1:]
+		commandAllowed := false
+		for _, allowedCommand := range session_manager.CommandList {
+			if allowedCommand == c.Command {
+				commandAllowed = true
+				break
+			}
+		}
+
+		if !commandAllowed {
+			api_error.Write(c.W, http.StatusNotFound, api_error.UNKNOWN_COMMAND, "")
+			return
+		}
+
+		if c.Sid == "" {
+			api_error.Write(c.W, http.StatusBadRequest, api_error.MISSING_SESSION_ID, "")
+			return
+		}
+		next(c)
+	}
+}
 
-	log.Println("got request ", command, " from ", req.RemoteAddr)
-	var out []byte
-	if command == "init" {
-		s := sm.AddSession(sessionId)
-		if s == nil {
-			w.WriteHeader(http.StatusConflict)
-			w.Write([]byte("OT busy"))
+// authStep rejects banned clients
+func authStep(next step_chain.Handler) step_chain.Handler {
+	return func(c *step_chain.Context) {
+		if rejectBanned(c.W, c.Req) {
 			return
 		}
-		s.Gp = gp
-		key, keyData := km.GetActiveKey()
-		s.SigningKey = key
-		// keyData is sent to Client unencrypted
-		out = append(out, keyData...)
+		next(c)
 	}
-	s := sm.GetSession(sessionId)
-	if s == nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("session %s not found", sessionId)))
+}
+
+func readBodyStep(next step_chain.Handler) step_chain.Handler {
+	return func(c *step_chain.Context) {
+		chaos.Delay(chaos.StepDelay)
+		log.Println("got request ", c.Command, " from ", c.Req.RemoteAddr)
+		body, err := readBody(c.Req)
+		if err != nil {
+			writeBodyError(c.W, c.Req, err)
+			return
+		}
+		c.Body = body
+		next(c)
+	}
+}
+
+// sessionStep creates the session on init and looks it up for the other
+// steps. An error or a panic in any later step destroys the session.
+func sessionStep(next step_chain.Handler) step_chain.Handler {
+	return func(c *step_chain.Context) {
+		if c.Command == "init" {
+			protocolVersion := session.InitProtocolVersion(c.Body)
+			if gp.HalfGates && protocolVersion < session.PROTOCOL_HALF_GATES {
+				api_error.Write(c.W, http.StatusConflict, api_error.PROTOCOL_VERSION_UNSUPPORTED, "protocol version not supported")
+				return
+			}
+			// older clients expect signatures in commitHash
+			if verifierOnly && protocolVersion < session.PROTOCOL_ATTESTATION_DOCUMENT {
+				api_error.Write(c.W, http.StatusConflict, api_error.PROTOCOL_VERSION_UNSUPPORTED, "protocol version not supported")
+				return
+			}
+			// the client can't run OT with another implementation, and
+			// clients before PROTOCOL_OT_IMPLEMENTATION only have the native
+			// one
+			if session.InitOTImplementation(c.Body) != otImplementation {
+				api_error.Write(c.W, http.StatusConflict, api_error.PROTOCOL_VERSION_UNSUPPORTED, "OT implementation not supported")
+				return
+			}
+			s, err := sm.AddSession(c.Sid, protocolVersion)
+			var queued *session_manager.Queued
+			if errors.As(err, &queued) {
+				writeQueued(c.W, queued)
+				return
+			}
+			if err != nil {
+				api_error.Write(c.W, http.StatusConflict, api_error.OT_BUSY, "OT busy")
+				return
+			}
+			s.Gp = gp
+			s.OtBroker = otBroker
+			s.DeterministicSignatures = deterministicSignatures
+			s.AttestationMetrics = attestationMetrics
+			s.MaxBlobSize = maxBlobSize
+			s.DiscardConsumedBlobs = discardConsumedBlobs
+			s.BlobBudget = blobBudget
+			key, keyData, counter := km.GetActiveKey()
+			s.SigningKey = key
+			s.KeyData = keyData
+			s.AttestationCounter = counter
+			// keyData is sent to Client unencrypted
+			c.Out = append(c.Out, keyData...)
+		}
+		c.Session = sm.GetSession(c.Sid)
+		if c.Session == nil && sm.TerminatedForSLA(c.Sid) {
+			api_error.Write(c.W, http.StatusRequestTimeout, api_error.SLA_EXCEEDED, "")
+			return
+		}
+		if c.Session == nil {
+			api_error.Write(c.W, http.StatusInternalServerError, api_error.SESSION_NOT_FOUND, fmt.Sprintf("session %s not found", c.Sid))
+			return
+		}
+		defer destroyOnPanic(c.W, c.Session, c.Req)
+		next(c)
+		if c.Err != nil {
+			failSession(c.W, c.Session, c.Req, c.Err)
+			return
+		}
+		if c.Command == "tagVerification" {
+			// this was the final message of the session. Destroying the session...
+			c.Session.Destroy()
+		}
+	}
+}
+
+// writeQueued tells a client which waits for OT its place in the queue and
+// when to poll init again
+func writeQueued(w http.ResponseWriter, queued *session_manager.Queued) {
+	// poll well within the poll timeout, but not before a slot may be free
+	retryAfter := min(queued.EstimatedWait, sm.QueuePollTimeout/2)
+	w.Header().Set("Retry-After", fmt.Sprint(max(int(retryAfter.Seconds()), 1)))
+	body, _ := json.Marshal(struct {
+		Position      int   `json:"position"`
+		EstimatedWait int64 `json:"estimatedWait"`
+	}{queued.Position, int64(queued.EstimatedWait.Seconds())})
+	api_error.Write(w, http.StatusServiceUnavailable, api_error.QUEUED, string(body))
+}
+
+// responseStep writes the response once the step was processed. Nothing is
+// written if the step failed.
+func responseStep(next step_chain.Handler) step_chain.Handler {
+	return func(c *step_chain.Context) {
+		next(c)
+		if c.Err != nil {
+			return
+		}
+		writeResponse(c.Out, c.W)
+	}
+}
+
+// dispatchStep calls the session method of the step. The method decrypts
+// the request and encrypts the response.
+func dispatchStep(c *step_chain.Context) {
+	method := sm.GetMethod(c.Command, c.Sid)
+	out, err := method(c.Body)
+	if err != nil {
+		c.Err = err
 		return
 	}
-	defer destroyOnPanic(s)
-	method := sm.GetMethod(command, sessionId)
-	body := readBody(req)
-	out = append(out, method(body)...)
-	writeResponse(out, w)
-	if command == "tagVerification" {
-		// this was the final message of the session. Destroying the session...
-		s.DestroyChan <- s.Sid
-		s.OtReleaseChan <- s.Sid
+	if len(c.Out) == 0 {
+		// don't copy big responses
+		c.Out = out
+		return
 	}
+	c.Out = append(c.Out, out...)
 }
 
-// getBlob is called when user wants to download garbled circuits
+// responseWriteTimeout is the write deadline of responses which are not
+// streamed. Streaming handlers replace it with a u.DeadlineWriter.
+const responseWriteTimeout = 5 * time.Minute
+
+// withWriteDeadline sets the write deadline of each request. Unlike
+// http.Server.WriteTimeout, handlers can extend it.
+func withWriteDeadline(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(responseWriteTimeout))
+		if err != nil {
+			log.Println("could not set write deadline:", err)
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// getBlob is called when user wants to download garbled circuits. A request
+// with a Range header, or to /getBlobRange, gets the requested ranges and
+// may be repeated until c1_step1, see session.GetBlobRange.
 func getBlob(w http.ResponseWriter, req *http.Request) {
 	log.Println("in getBlob", req.RemoteAddr)
+	if rejectBanned(w, req) {
+		return
+	}
 	s := sm.GetSession(string(req.URL.RawQuery))
-	defer destroyOnPanic(s)
-	body := readBody(req)
-	fileHandles := s.GetBlob(body)
-	writeResponse(nil, w)
-	// stream directly from file
-	for _, f := range fileHandles {
-		_, err := io.Copy(w, f)
-		if err != nil {
-			panic("err != nil")
-		}
+	if s == nil {
+		api_error.Write(w, http.StatusNotFound, api_error.SESSION_NOT_FOUND, "")
+		return
+	}
+	// the response is started before the blobs are read, so a panic or a
+	// failed copy can't be answered with an error
+	body, err := readBody(req)
+	if err != nil {
+		writeBodyError(w, req, err)
+		return
+	}
+	defer destroyOnPanic(nil, s, req)
+	rangeRequest := req.URL.Path == "/getBlobRange" || req.Header.Get("Range") != ""
+	var blob *session.Blob
+	if rangeRequest {
+		blob, err = s.GetBlobRange()
+	} else {
+		blob, err = s.GetBlob(body)
+	}
+	if err != nil {
+		failSession(w, s, req, err)
+		return
+	}
+	defer blob.Release()
+	offsets := make([]string, len(blob.CircuitOffsets))
+	for i, offset := range blob.CircuitOffsets {
+		offsets[i] = strconv.FormatInt(offset, 10)
+	}
+	w.Header().Set("X-Circuit-Offsets", strings.Join(offsets, ","))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Add("Access-Control-Expose-Headers", "X-Circuit-Offsets, Accept-Ranges, Content-Range")
+	// the blobs can take longer than responseWriteTimeout for slow clients
+	dw := u.NewDeadlineWriter(w, u.StreamIdleTimeout)
+	content := io.NewSectionReader(blob, 0, blob.Size())
+	if rangeRequest {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(dw, req, "", time.Time{}, content)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(blob.Size(), 10))
+	writeResponse(nil, dw)
+	// stream directly from file. An interrupted download doesn't fail the
+	// session, the client can resume it with a range request.
+	if _, err = io.Copy(dw, content); err != nil {
+		log.Println("getBlob of session", s.Sid, "interrupted:", err)
 	}
 }
 
 // setBlob is called when user wants to upload garbled circuits
 func setBlob(w http.ResponseWriter, req *http.Request) {
 	log.Println("in setBlob", req.RemoteAddr)
+	if rejectBanned(w, req) {
+		return
+	}
 	s := sm.GetSession(string(req.URL.RawQuery))
-	defer destroyOnPanic(s)
-	out := s.SetBlob(req.Body)
+	if s == nil {
+		api_error.Write(w, http.StatusNotFound, api_error.SESSION_NOT_FOUND, "")
+		return
+	}
+	// the truth tables are indistinguishable from random, so the transfer
+	// plan never recommends compression (see probe.Plan), but clients may
+	// send gzip members or zstd frames which they can retransmit
+	encoding := req.Header.Get("Content-Encoding")
+	if !session.BlobEncodingSupported(encoding) {
+		api_error.Write(w, http.StatusUnsupportedMediaType, api_error.INVALID_REQUEST, "the blob may only be compressed with gzip or zstd")
+		return
+	}
+	defer destroyOnPanic(w, s, req)
+	out, err := s.SetBlob(req.Body, encoding)
+	if err != nil {
+		failSession(w, s, req, err)
+		return
+	}
 	writeResponse(out, w)
 }
 
+// setBlobChunk is called when the user uploads garbled circuits in chunks
+// which it can resume. Both the response and a rejected chunk tell the
+// client the offset at which to continue.
+func setBlobChunk(w http.ResponseWriter, req *http.Request) {
+	log.Println("in setBlobChunk", req.RemoteAddr)
+	if rejectBanned(w, req) {
+		return
+	}
+	s := sm.GetSession(string(req.URL.RawQuery))
+	if s == nil {
+		api_error.Write(w, http.StatusNotFound, api_error.SESSION_NOT_FOUND, "")
+		return
+	}
+	encoding := req.Header.Get("Content-Encoding")
+	if !session.BlobEncodingSupported(encoding) {
+		api_error.Write(w, http.StatusUnsupportedMediaType, api_error.INVALID_REQUEST, "the blob may only be compressed with gzip or zstd")
+		return
+	}
+	offset, err := strconv.ParseInt(req.Header.Get("X-Blob-Offset"), 10, 64)
+	chunkHash, hashErr := hex.DecodeString(req.Header.Get("X-Chunk-SHA256"))
+	if err != nil || offset < 0 || hashErr != nil || len(chunkHash) != 32 {
+		api_error.Write(w, http.StatusBadRequest, api_error.INVALID_REQUEST,
+			"X-Blob-Offset must be the offset of the chunk and X-Chunk-SHA256 its hash in hex")
+		return
+	}
+	defer destroyOnPanic(w, s, req)
+	offset, err = s.SetBlobChunk(req.Body, encoding, offset, chunkHash)
+	if err != nil && !errors.Is(err, session.ErrChunkRejected) {
+		failSession(w, s, req, err)
+		return
+	}
+	w.Header().Set("X-Blob-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Add("Access-Control-Expose-Headers", "X-Blob-Offset")
+	if err != nil {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		api_error.Write(w, http.StatusConflict, api_error.BLOB_CHUNK_REJECTED, err.Error())
+		return
+	}
+	writeResponse(nil, w)
+}
+
+// exportSession returns a handoff token with which another client instance
+// can resume the session, e.g. after the browser tab crashed
+func exportSession(w http.ResponseWriter, req *http.Request) {
+	log.Println("in exportSession", req.RemoteAddr)
+	if rejectBanned(w, req) {
+		return
+	}
+	s := sm.GetSession(string(req.URL.RawQuery))
+	if s == nil {
+		api_error.Write(w, http.StatusNotFound, api_error.SESSION_NOT_FOUND, "")
+		return
+	}
+	body, err := readBody(req)
+	if err != nil {
+		writeBodyError(w, req, err)
+		return
+	}
+	defer destroyOnPanic(w, s, req)
+	token, err := sm.ExportSession(s.Sid, body)
+	if errors.Is(err, session.ErrInvalidMessage) || errors.Is(err, session.ErrOutOfOrder) {
+		failSession(w, s, req, err)
+		return
+	}
+	if err != nil {
+		api_error.Write(w, http.StatusConflict, api_error.HANDOFF_FAILED, err.Error())
+		return
+	}
+	writeResponse(token, w)
+}
+
+// resumeSession continues the session of a handoff token under the session
+// id of the request. The response tells the client how to connect for OT.
+func resumeSession(w http.ResponseWriter, req *http.Request) {
+	log.Println("in resumeSession", req.RemoteAddr)
+	if rejectBanned(w, req) {
+		return
+	}
+	sid := string(req.URL.RawQuery)
+	if sid == "" {
+		api_error.Write(w, http.StatusBadRequest, api_error.MISSING_SESSION_ID, "")
+		return
+	}
+	body, err := readBody(req)
+	if err != nil {
+		writeBodyError(w, req, err)
+		return
+	}
+	s, err := sm.ResumeSession(sid, body)
+	if err != nil {
+		api_error.Write(w, http.StatusConflict, api_error.HANDOFF_FAILED, err.Error())
+		return
+	}
+	defer destroyOnPanic(w, s, req)
+	resp, err := s.ResumeResponse()
+	if err != nil {
+		failSession(w, s, req, err)
+		return
+	}
+	writeResponse(resp, w)
+}
+
 // ping is sent to check if notary is available
 func ping(w http.ResponseWriter, req *http.Request) {
 	log.Println("in ping", req.RemoteAddr)
@@ -167,12 +646,17 @@ func ping(w http.ResponseWriter, req *http.Request) {
 // when notary starts we expect the admin to upload a URLFetcher document
 // it can be uploaded e.g. with:
 // curl --data-binary '@URLFetcherDoc' 127.0.0.1:10012/setURLFetcherDoc
-func awaitURLFetcherDoc() {
+func awaitURLFetcherDoc(addr string) {
 	serverMux := http.NewServeMux()
-	srv := &http.Server{Addr: ":10012", Handler: serverMux}
+	srv := &http.Server{Addr: addr, Handler: serverMux}
 	signal := make(chan struct{})
 	serverMux.HandleFunc("/setURLFetcherDoc", func(w http.ResponseWriter, req *http.Request) {
-		URLFetcherDoc = readBody(req)
+		doc, err := readBody(req)
+		if err != nil {
+			writeBodyError(w, req, err)
+			return
+		}
+		URLFetcherDoc = doc
 		log.Println("got URLFetcher doc", string(URLFetcherDoc[:100]))
 		close(signal)
 	})
@@ -185,11 +669,426 @@ func awaitURLFetcherDoc() {
 	srv.Shutdown(ctx)
 }
 
-// getPubKey sends notary's public key to the client
-// only useful when running as a regular non-sandboxed server
-func getPubKey(w http.ResponseWriter, req *http.Request) {
-	log.Println("in getPubKey", req.RemoteAddr)
-	writeResponse(km.MasterPubKeyPEM, w)
+// serveAdminAPI serves the admin endpoints. It must only be reachable by the
+// notary's operator, that's why by default it only listens on localhost.
+// e.g. to ban an IP for 48 hours:
+// curl -X POST --data '{"kind":"ip","value":"1.2.3.4","ttl":"48h"}' 127.0.0.1:10013/ban
+func serveAdminAPI(addr string) {
+	serverMux := http.NewServeMux()
+	serverMux.HandleFunc("/ban", bl.ServeAdmin)
+	serverMux.Handle("/debug/vars", expvar.Handler())
+	serverMux.HandleFunc("/sessions", adminSessions)
+	serverMux.HandleFunc("/garbledPool", func(w http.ResponseWriter, req *http.Request) {
+		// the admin API starts before the pool is loaded
+		if gp == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		gp.ServeAdmin(w, req)
+	})
+	log.Println("Admin API listening on", addr)
+	err := http.ListenAndServe(addr, serverMux)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// adminSessions is the admin API endpoint for sessions:
+// GET /sessions lists the active sessions with the memory they hold
+// DELETE /sessions?sid=<session id> purges a session, see purgeSession
+func adminSessions(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Sessions    []session_manager.SessionInfo `json:"sessions"`
+			MemoryTotal int64                         `json:"memoryTotal"`
+		}{sm.Sessions(), session.TotalMemory()})
+	case http.MethodDelete:
+		purgeSession(w, req)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// purgeSession destroys a session (if it is still active) and purges all its
+// records from the audit log
+func purgeSession(w http.ResponseWriter, req *http.Request) {
+	sid := req.URL.Query().Get("sid")
+	if sid == "" {
+		http.Error(w, "missing sid", http.StatusBadRequest)
+		return
+	}
+	// destroying the session also deletes its files
+	destroyed := sm.DestroySession(sid)
+	purged, err := al.PurgeSession(sid)
+	if err != nil {
+		log.Println("purgeSession:", err)
+		http.Error(w, "could not purge the audit log", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		SessionDestroyed   bool `json:"sessionDestroyed"`
+		AuditRecordsPurged int  `json:"auditRecordsPurged"`
+	}{destroyed, purged})
+}
+
+// bundleKey is a public key in the key bundle
+type bundleKey struct {
+	// Id is the first 8 bytes of the sha256 of the key (in PEM format or
+	// uncompressed for the ephemeral key), hex-encoded
+	Id  string `json:"id"`
+	PEM string `json:"pem,omitempty"`
+	// Pubkey is the hex-encoded uncompressed ephemeral pubkey
+	Pubkey string `json:"pubkey,omitempty"`
+	// ValidFrom and ValidUntil are unix timestamps
+	ValidFrom  uint32 `json:"validFrom,omitempty"`
+	ValidUntil uint32 `json:"validUntil,omitempty"`
+	// Signature is the hex-encoded signature of the master key (or of the
+	// root key for the master key itself)
+	Signature string `json:"signature,omitempty"`
+}
+
+// keyBundle has all trust anchors a client needs to bootstrap
+type keyBundle struct {
+	MasterKey bundleKey `json:"masterKey"`
+	// TagSigningKey is omitted with -verifier-only
+	TagSigningKey *bundleKey `json:"tagSigningKey,omitempty"`
+	EphemeralKey  bundleKey  `json:"ephemeralKey"`
+	// URLFetcherDoc is the path at which the enclave attestation document
+	// is served, empty when the notary is not sandboxed
+	URLFetcherDoc string `json:"urlFetcherDoc,omitempty"`
+	// URLFetcherDocHash is the hex-encoded sha256 of that document
+	URLFetcherDocHash string `json:"urlFetcherDocHash,omitempty"`
+}
+
+func keyId(key []byte) string {
+	return hex.EncodeToString(u.Sha256(key)[:8])
+}
+
+// buildKeyBundle returns the key bundle in JSON format
+func buildKeyBundle(tagSigner *at.TagSigningManager) ([]byte, error) {
+	bundle := keyBundle{}
+	bundle.MasterKey = bundleKey{
+		Id:  keyId(km.MasterPubKeyPEM),
+		PEM: string(km.MasterPubKeyPEM),
+	}
+	if signature := km.SignWithRootKey(km.MasterPubKeyPEM); signature != nil {
+		bundle.MasterKey.Signature = hex.EncodeToString(signature)
+	}
+
+	if tagSigner != nil {
+		tagSigningPEM, err := tagSigner.PublicKeyPEM()
+		if err != nil {
+			return nil, err
+		}
+		bundle.TagSigningKey = &bundleKey{
+			Id:        keyId(tagSigningPEM),
+			PEM:       string(tagSigningPEM),
+			Signature: hex.EncodeToString(km.SignWithMasterKey(tagSigningPEM)),
+		}
+	}
+
+	// keyData is validFrom(4) | validUntil(4) | pubkey(65) | signature(64)
+	keyData := km.ActiveEpoch().KeyData
+	if len(keyData) != 137 {
+		return nil, errors.New("no active ephemeral key")
+	}
+	bundle.EphemeralKey = bundleKey{
+		Id:         keyId(keyData[8:73]),
+		Pubkey:     hex.EncodeToString(keyData[8:73]),
+		ValidFrom:  binary.BigEndian.Uint32(keyData[0:4]),
+		ValidUntil: binary.BigEndian.Uint32(keyData[4:8]),
+		Signature:  hex.EncodeToString(keyData[73:137]),
+	}
+
+	if len(URLFetcherDoc) > 0 {
+		bundle.URLFetcherDoc = "/getURLFetcherDoc"
+		bundle.URLFetcherDocHash = hex.EncodeToString(u.Sha256(URLFetcherDoc))
+	}
+	return json.Marshal(bundle)
+}
+
+// getPubKey sends the key bundle (see keyBundle) to the client. With the
+// query param format=pem it sends only the master public key in PEM format,
+// as older clients expect.
+func getPubKey(tagSigner *at.TagSigningManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		log.Println("in getPubKey", req.RemoteAddr)
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		body := km.MasterPubKeyPEM
+		contentType := "application/octet-stream"
+		if req.URL.Query().Get("format") != "pem" {
+			var err error
+			body, err = buildKeyBundle(tagSigner)
+			if err != nil {
+				log.Println("getPubKey:", err)
+				api_error.Write(w, http.StatusServiceUnavailable, api_error.UNAVAILABLE, "")
+				return
+			}
+			contentType = "application/json"
+		}
+		if signature := km.SignWithRootKey(body); signature != nil {
+			setSignatureHeaders(w, signature, "root")
+		}
+		// clients must always revalidate the key they trust
+		if u.CheckNotModified(w, req, u.ETag(body), "no-cache") {
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write(body)
+	}
+}
+
+// getAttestationCounters sends the attestation counters of the active and
+// the latest expired ephemeral keys, signed by the master key, so that
+// auditors can check that no counter value was skipped or repeated
+func getAttestationCounters(w http.ResponseWriter, req *http.Request) {
+	log.Println("in getAttestationCounters", req.RemoteAddr)
+	body, err := json.Marshal(struct {
+		Counters []key_manager.AttestationCount `json:"counters"`
+		Time     int64                          `json:"time"`
+	}{km.AttestationCounts(), time.Now().Unix()})
+	if err != nil {
+		log.Println("getAttestationCounters:", err)
+		api_error.Write(w, http.StatusInternalServerError, api_error.INTERNAL, "")
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	setSignatureHeaders(w, km.SignWithMasterKey(body), "master")
+	w.Write(body)
+}
+
+// heartbeat sends the current time and load signed by the master key, so
+// that monitors can tell that the notary is alive and that the operator still
+// controls its key. The optional query param nonce (hex, up to 32 bytes) is
+// signed along, so that a response can't be replayed to a monitor.
+func heartbeat(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	nonce, err := hex.DecodeString(req.URL.Query().Get("nonce"))
+	if err != nil || len(nonce) > 32 {
+		api_error.Write(w, http.StatusBadRequest, api_error.INVALID_REQUEST, "nonce must be up to 32 bytes in hex")
+		return
+	}
+	body, err := json.Marshal(struct {
+		// Type keeps the signature from being taken for one of another
+		// signed response
+		Type  string               `json:"type"`
+		Time  int64                `json:"time"`
+		Nonce string               `json:"nonce,omitempty"`
+		Load  session_manager.Load `json:"load"`
+	}{"heartbeat", time.Now().Unix(), hex.EncodeToString(nonce), sm.Load()})
+	if err != nil {
+		log.Println("heartbeat:", err)
+		api_error.Write(w, http.StatusInternalServerError, api_error.INTERNAL, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	setSignatureHeaders(w, km.SignWithMasterKey(body), "master")
+	w.Write(body)
+}
+
+// setSignatureHeaders sets the headers with a detached signature over the
+// response body. keyName says which key made the signature: "master" for the
+// key served at /getPubKey, "root" for the key loaded with -root-key.
+func setSignatureHeaders(w http.ResponseWriter, signature []byte, keyName string) {
+	w.Header().Set("X-Signature", hex.EncodeToString(signature))
+	w.Header().Set("X-Signature-Key", keyName)
+	w.Header().Set("Access-Control-Expose-Headers", "X-Signature, X-Signature-Key")
+}
+
+// serveSigningKey serves the tag signing public key (or the key history)
+// signed by the master key
+func serveSigningKey(tagSigner *at.TagSigningManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		body, _, err := tagSigner.PublicKeyResponse(req)
+		if err == nil {
+			setSignatureHeaders(w, km.SignWithMasterKey(body), "master")
+		}
+		tagSigner.ServePublicKey(w, req)
+	}
+}
+
+// verifyRequest is the body of /verify: either an attestation of commitHash
+// or a tag signature of tagVerification
+type verifyRequest struct {
+	Attestation json.RawMessage `json:"attestation"`
+	// MasterKey is the PEM master key which certified the ephemeral key of
+	// Attestation. It defaults to the current master key, which changes on
+	// every restart of the notary.
+	MasterKey string `json:"masterKey"`
+	// DisclosedBlocks are revealed blocks of the request, which must be in
+	// the request commitment of Attestation
+	DisclosedBlocks []attestation.DisclosedBlock `json:"disclosedBlocks"`
+	// TagSignature is the hex signature of the tag verification response
+	// over Transcript, or over Ciphertext for clients before protocol
+	// version 13
+	TagSignature string `json:"tagSignature"`
+	Transcript   string `json:"transcript"`
+	Ciphertext   string `json:"ciphertext"`
+	// SigningKeyId is the kid of the tag signing key, the active key if
+	// empty
+	SigningKeyId string `json:"signingKeyId"`
+}
+
+type verifyResponse struct {
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+	// Document is the verified attestation document
+	Document *attestation.Document `json:"document,omitempty"`
+	// Records is the amount of records of the verified tag transcript
+	Records int `json:"records,omitempty"`
+}
+
+// verifyAttestation verifies an attestation or a tag signature for relying
+// parties which don't implement the checks of the attestation and
+// tag_signature packages themselves
+func verifyAttestation(tagSigner *at.TagSigningManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		if req.Method != http.MethodPost {
+			api_error.Write(w, http.StatusMethodNotAllowed, api_error.METHOD_NOT_ALLOWED, "")
+			return
+		}
+		r := new(verifyRequest)
+		if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 1<<20)).Decode(r); err != nil {
+			api_error.Write(w, http.StatusBadRequest, api_error.INVALID_REQUEST, "invalid body")
+			return
+		}
+		resp := new(verifyResponse)
+		var err error
+		switch {
+		case len(r.Attestation) > 0:
+			masterKeyPEM := km.MasterPubKeyPEM
+			if r.MasterKey != "" {
+				masterKeyPEM = []byte(r.MasterKey)
+			}
+			masterKey, keyErr := attestation.ParsePublicKeyPEM(masterKeyPEM)
+			if keyErr != nil {
+				api_error.Write(w, http.StatusBadRequest, api_error.INVALID_REQUEST, "invalid master key")
+				return
+			}
+			resp.Document, err = attestation.Verify(r.Attestation, masterKey)
+			if err == nil && len(r.DisclosedBlocks) > 0 {
+				err = resp.Document.VerifyDisclosure(r.DisclosedBlocks)
+			}
+		case r.TagSignature != "":
+			err = verifyTagSignature(tagSigner, r, resp)
+		default:
+			api_error.Write(w, http.StatusBadRequest, api_error.INVALID_REQUEST, "attestation or tagSignature is required")
+			return
+		}
+		resp.Valid = err == nil
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// verifyTagSignature verifies the tag signature of r with the tag signing
+// key of its kid
+func verifyTagSignature(tagSigner *at.TagSigningManager, r *verifyRequest, resp *verifyResponse) error {
+	if tagSigner == nil {
+		return errors.New("this notary doesn't sign tags")
+	}
+	kid := r.SigningKeyId
+	if kid == "" {
+		kid = tagSigner.KeyId()
+	}
+	var key *ecdsa.PublicKey
+	for _, info := range tagSigner.Keys() {
+		if info.Kid == kid {
+			key, _ = tag_signature.ParsePublicKeyPEM([]byte(info.PEM))
+		}
+	}
+	if key == nil {
+		return at.ErrUnknownKid
+	}
+	signature, err := hex.DecodeString(r.TagSignature)
+	if err != nil {
+		return errors.New("the tag signature is not hex")
+	}
+	if r.Transcript != "" {
+		transcript, err := hex.DecodeString(r.Transcript)
+		if err != nil {
+			return errors.New("the transcript is not hex")
+		}
+		parsed, err := tag_signature.VerifyTranscript(key, transcript, signature)
+		if err != nil {
+			return err
+		}
+		resp.Records = len(parsed.Records)
+		return nil
+	}
+	ciphertext, err := hex.DecodeString(r.Ciphertext)
+	if err != nil || len(ciphertext) == 0 {
+		return errors.New("a transcript or a ciphertext in hex is required")
+	}
+	return tag_signature.Verify(key, ciphertext, signature)
+}
+
+// newProbeHandler creates the handler of /probe, which estimates the network
+// time and the cost of sessions from the size of the garbled circuits
+func newProbeHandler(maxDuration time.Duration, sla session.SLA) *probe.Handler {
+	limits := probe.Limits{MaxDuration: maxDuration}
+	if sla.Enforce {
+		limits.HandshakeSLA = sla.Limits[session.PHASE_HANDSHAKE]
+	}
+	// the client downloads the notary's truth tables and uploads its own
+	// ones for the same circuits (dual execution)
+	blobSizes := func(c6Count int) (int64, int64) {
+		// a request in max size records has one c7 execution per 1024 blocks
+		records := (c6Count + probe.MAX_RECORD_SIZE/16 - 1) / (probe.MAX_RECORD_SIZE / 16)
+		size := gp.TruthTableSize(c6Count, records)
+		return size, size
+	}
+	roundTrips := 0
+	for _, step := range session.Protocol {
+		if step.Ordering == session.ORDERED && !step.Optional {
+			roundTrips++
+		}
+	}
+	// the notary receives the labels of its inputs of the client's circuits
+	// and sends both labels of the client's inputs of its own ones
+	otBytes := func(c6Count int) int64 {
+		records := (c6Count + probe.MAX_RECORD_SIZE/16 - 1) / (probe.MAX_RECORD_SIZE / 16)
+		var size int64
+		for i := 1; i < len(gp.Circuits); i++ {
+			count := 1
+			if i == 6 {
+				count = c6Count
+			} else if i == 7 {
+				count = records
+			}
+			c := gp.Circuits[i]
+			size += int64(count) * int64(c.NotaryInputSize*16+c.ClientInputSize*32)
+		}
+		return size
+	}
+	costs := probe.CostModel{OtBytes: otBytes, EvaluationRate: session.EvaluationRate}
+	maxC6Count := garbled_pool.MAX_REQUEST_RECORDS * probe.MAX_RECORD_SIZE / 16
+	return probe.NewHandler(limits, blobSizes, maxC6Count, roundTrips, costs)
+}
+
+// phaseSLAs returns the enforced phase SLAs in seconds by phase name for
+// the policy document
+func phaseSLAs(sla session.SLA) map[string]int64 {
+	if !sla.Enforce {
+		return nil
+	}
+	limits := make(map[string]int64)
+	for phase, limit := range sla.Limits {
+		if limit > 0 {
+			limits[session.Phase(phase).String()] = int64(limit.Seconds())
+		}
+	}
+	return limits
 }
 
 func getBaseDir() string {
@@ -204,8 +1103,7 @@ func assembleCircuits() {
 	circuitsDir := filepath.Join(baseDir, "circuits")
 	// if c1.out does not exist, proceed to assemble
 	if _, err := os.Stat(filepath.Join(circuitsDir, "c1.out")); os.IsNotExist(err) {
-		cmd := exec.Command("node", "assemble.js")
-		cmd.Dir = circuitsDir
+		cmd := jail.CommandContext(context.Background(), circuitsDir, "node", "assemble.js")
 		log.Println("Assembling circuits. This will take a few seconds...")
 		if err := cmd.Run(); err != nil {
 			log.Println("Error. Could not run: node assemble.js. Please make sure that node is installed on your system.")
@@ -231,6 +1129,41 @@ func checkTagVerificationCircuits() string {
 	return circuitsDir
 }
 
+// serverTLSConfig returns the TLS config of the public server, or nil to
+// serve plain HTTP. With autocertDomain the certificate is obtained from
+// Let's Encrypt, which checks that we control the domain with a request to
+// port 80. The certificates are cached in storageDir.
+func serverTLSConfig(certFile string, keyFile string, autocertDomain string, storageDir string) (*tls.Config, error) {
+	if autocertDomain != "" {
+		if certFile != "" || keyFile != "" {
+			return nil, errors.New("--autocert-domain can't be combined with --tls-cert and --tls-key")
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(autocertDomain),
+			Cache:      autocert.DirCache(filepath.Join(storageDir, "autocert")),
+		}
+		go func() {
+			err := http.ListenAndServe(":80", m.HTTPHandler(nil))
+			if err != nil {
+				log.Fatalln("could not serve ACME challenges:", err)
+			}
+		}()
+		return m.TLSConfig(), nil
+	}
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("--tls-cert and --tls-key must be given together")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
 func main() {
 	// uncomment the below to profile the process's RAM usage
 	// install with: go get github.com/pkg/profile
@@ -243,27 +1176,252 @@ func main() {
 	// }()
 
 	noSandbox := flag.Bool("no-sandbox", false, "Must be set when not running in a sandboxed environment.")
+	adminAddr := flag.String("admin-addr", "127.0.0.1:10013", "Address on which the admin API listens.")
+	auditLogPath := flag.String("audit-log", "", "File to which security relevant events are appended. Defaults to audit.log in --storage-dir.")
+	retention := flag.Duration("retention", 30*24*time.Hour, "How long audit log records are kept. 0 keeps them forever.")
+	banTTL := flag.Duration("ban-ttl", 24*time.Hour, "How long a client caught cheating stays banned.")
+	halfGates := flag.Bool("half-gates", false, "Garble circuits with half-gates (2 rows per AND gate) instead of GRR3. Requires clients with protocol version 3.")
+	flag.BoolVar(&deterministicSignatures, "deterministic-signatures", false, "Sign sessions and tags with deterministic ECDSA (RFC 6979) instead of random nonces.")
+	flag.BoolVar(&attestationMetrics, "attestation-metrics", false, "Include session metrics (protocol version, duration, block counts) in the signed attestation of clients with protocol version 5.")
+	flag.BoolVar(&verifierOnly, "verifier-only", false, "Run the MPC for clients which verify the results themselves, e.g. first-party audits: the attestation document and the verified tags are returned unsigned and no tag signing key (signing.key) is needed. Requires clients with protocol version 16.")
+	rootKeyPath := flag.String("root-key", "", "PEM file with an EC private key which signs the master public key served at /getPubKey.")
+	otBrokerURL := flag.String("ot-broker", "", "Registration URL of the OT broker, e.g. http://10.0.0.1:12301/register. Empty disables the broker.")
+	otBrokerAddr := flag.String("ot-broker-addr", "", "Public host:port of the OT broker which clients connect to.")
+	otBackendHost := flag.String("ot-backend-host", "127.0.0.1", "Host at which the OT broker reaches this notary's OT ports.")
+	egressProxy := flag.String("egress-proxy", "", "Proxy for outbound connections (e.g. to the OT broker): http://host:port or socks5://host:port.")
+	helperSandbox := flag.String("helper-sandbox", string(jail.MODE_NONE), "How the helper programs (the Python tag verifier, snarkjs and the node circuit assembler) are sandboxed: none, basic (own working dir, clean environment and no network where user namespaces are available) or nsjail.")
+	helperNsjailConfig := flag.String("helper-nsjail-config", "", "nsjail config file for --helper-sandbox nsjail. It must make the interpreters and src/verify_tag.py available to the helpers.")
+	otBindHost := flag.String("ot-bind-host", "0.0.0.0", "Host on which the OT ports listen.")
+	otAdvertiseHost := flag.String("ot-advertise-host", "", "Host which clients with protocol version 12 connect to for OT, e.g. the external address when --ot-bind-host is a VPN or private interface. Empty means the host of --listen-addr.")
+	otAdvertisePortOffset := flag.Int("ot-advertise-port-offset", 0, "Added to each OT port to get the port advertised to clients, for deployments behind port forwarding.")
+	stepRateLimit := flag.Int("step-rate-limit", 0, "Max protocol steps per minute from one IP address. 0 disables the limit.")
+	rngCheckInterval := flag.Duration("rng-check-interval", time.Minute, "How often the output of the random number generator is health tested. Signing stops after a failed test.")
+	stepRateBurst := flag.Int("step-rate-burst", 100, "Max protocol steps from one IP address in a burst when --step-rate-limit is set.")
+	otImplementationName := flag.String("ot-implementation", string(ote.IMPLEMENTATION_NATIVE), "OT implementation: native (SoftSpokenOT of the cgo ot-wrapper) or go (the pure Go KOS OT extension, for clients with protocol version 19). Clients must use the same one.")
+	otTunnel := flag.Bool("ot-tunnel", true, "Let clients tunnel their OT connection over a WebSocket at /otTunnel on --listen-addr.")
+	otPoolSize := flag.Int("ot-pool-size", 0, "Amount of pooled OT managers (on ports starting with --ot-pool-port) for clients using protocol version 2. 0 disables the pool.")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate chain for serving HTTPS on --listen-addr. Requires --tls-key.")
+	tlsKey := flag.String("tls-key", "", "PEM private key of --tls-cert.")
+	autocertDomain := flag.String("autocert-domain", "", "Serve HTTPS on --listen-addr with a certificate for this domain from Let's Encrypt. Port 80 must be reachable for the challenges.")
+	listenAddr := flag.String("listen-addr", "0.0.0.0:10011", "Address of the public API.")
+	urlFetcherDocAddr := flag.String("urlfetcher-doc-addr", ":10012", "Address on which a sandboxed notary waits for the URLFetcher document.")
+	otPort := flag.Int("ot-port", 12345, "Port of the global OT manager used by clients with protocol version 1.")
+	otPoolPort := flag.Int("ot-pool-port", 12346, "First port of the pooled OT managers, see --ot-pool-size.")
+	tagVerificationIvPort := flag.Int("tag-verification-iv-port", 10020, fmt.Sprintf("First of the %d ports of the encrypted IV MPC of tag verification.", at.MPC_PORT_COUNT))
+	tagVerificationPohPort := flag.Int("tag-verification-poh-port", 10030, fmt.Sprintf("First of the %d ports of the powers of H MPC of tag verification.", at.MPC_PORT_COUNT))
+	storageDir := flag.String("storage-dir", getBaseDir(), "Dir for the session files, the garbled circuits pool, the ban list, the tag signing key history and the certificate cache.")
+	sessionIdleTimeout := flag.Duration("session-idle-timeout", session_manager.DEFAULT_IDLE_TIMEOUT, "Sessions without a message from the client for this long are removed.")
+	sessionQueueLength := flag.Int("session-queue-length", 32, "Max clients waiting for each kind of OT (the global OT manager or the pool) when it is busy. 0 rejects clients when OT is busy.")
+	sessionQueuePollTimeout := flag.Duration("session-queue-poll-timeout", session_manager.DEFAULT_QUEUE_POLL_TIMEOUT, "How long a queued client may go without polling init before it loses its place.")
+	sessionMaxDuration := flag.Duration("session-max-duration", session_manager.DEFAULT_MAX_DURATION, "Sessions are removed after this long.")
+	transparencyLogPath := flag.String("transparency-log", "", "File of the Merkle tree log of all session and tag signatures, served at /log/*. Empty disables the log.")
+	sessionCheckpointDir := flag.String("session-checkpoint-dir", "", "Dir in which sessions past commitHash are checkpointed, so that clients can finish tag verification after a restart of the notary. Empty disables checkpoints.")
+	var sla session.SLA
+	flag.DurationVar(&sla.Limits[session.PHASE_HANDSHAKE], "sla-handshake", 0, "Target duration of the handshake phase (init thru c5_step3). 0 disables the SLA.")
+	flag.DurationVar(&sla.Limits[session.PHASE_REQUEST_MAC], "sla-request-mac", 0, "Target duration of the request MAC phase (c6_step1 thru commitHash). 0 disables the SLA.")
+	flag.DurationVar(&sla.Limits[session.PHASE_TAG_VERIFICATION], "sla-tag-verification", 0, "Target duration of the tag verification phase. 0 disables the SLA.")
+	flag.BoolVar(&sla.Enforce, "sla-enforce", false, "Terminate sessions which exceed a phase SLA. Otherwise they are only counted in sla_exceeded.")
+	garbledPoolSize := flag.Int("garbled-pool-size", 1, "Amount of sessions for which garbled circuits are prepared in advance.")
+	garbledPoolSchedule := flag.String("garbled-pool-schedule", "", "Pool sizes by local time of day which override --garbled-pool-size, e.g. \"22:00-06:00=8,09:00-17:00=1\".")
+	garbledPoolRemote := flag.String("garbled-pool-remote", "", "URL of an S3 compatible bucket (with an optional key prefix) to spill garbled circuits to, e.g. https://storage.googleapis.com/bucket/pool. The credentials are read from OBJECT_STORE_ACCESS_KEY and OBJECT_STORE_SECRET_KEY.")
+	garbledPoolRemoteRegion := flag.String("garbled-pool-remote-region", "us-east-1", "Region of --garbled-pool-remote, \"auto\" for GCS.")
+	garbledPoolVerifyInterval := flag.Duration("garbled-pool-verify-interval", 10*time.Second, "Time between the background integrity checks of the garbled circuits in the local pool, which run while no session is active. A garbling which fails is replaced. 0 disables the checks.")
+	keyEpoch := flag.Duration("key-epoch", 0, "Rotate the ephemeral key at fixed epochs of this length, each key being valid for two epochs. 0 rotates it after a random interval of 10 to 20 minutes.")
+	garbledPoolRemoteSize := flag.Int("garbled-pool-remote-size", 0, "Amount of sessions for which garbled circuits are kept in --garbled-pool-remote in addition to the local pool.")
+	flag.BoolVar(&discardConsumedBlobs, "discard-consumed-blobs", true, "Release the disk space of the client's truth tables as soon as their circuit was evaluated instead of at the end of the session.")
+	flag.Int64Var(&maxBlobSize, "max-blob-size", 0, "Max size in bytes of the garbled circuits uploaded with setBlob. 0 disables the limit.")
+	memoryBlobBudget := flag.Int64("memory-blob-budget", 0, "Memory in bytes which the garbled circuits uploaded with setBlob may take instead of being written to disk. Sessions whose circuits don't fit into what is left use the disk. 0 always uses the disk.")
+	directoryURL := flag.String("directory-url", "", "URL to which the notary posts its signed directory record (policy document and load) every --directory-interval, so that clients can find it. Requires --public-url. Empty disables publishing.")
+	directoryInterval := flag.Duration("directory-interval", time.Minute, "Time between the directory records posted to --directory-url. A record expires after three intervals.")
+	publicURL := flag.String("public-url", "", "Public base URL of this notary in its directory record, e.g. https://notary.example.com.")
+	region := flag.String("region", "", "Region of this notary in its directory record, e.g. eu-west.")
+	policyFile := flag.String("policy-file", "", "JSON file with the operator's terms (name, contact, termsUrl, auditLogDays, fees) for the policy document at /policy.")
+	configPath := flag.String("config", "", "Config file with settings in the format \"name = value\", where the names are those of the flags. Flags and NOTARY_* environment variables override it.")
+	checkConfig := flag.Bool("check-config", false, "Validate the settings, print them and exit.")
 	flag.Parse()
+
+	err := config.Load(flag.CommandLine, *configPath)
+	if err != nil {
+		log.Fatalln("could not load the settings:", err)
+	}
+	v := new(config.Validator)
+	v.Addr("listen-addr", *listenAddr)
+	v.Addr("admin-addr", *adminAddr)
+	if !*noSandbox {
+		v.Addr("urlfetcher-doc-addr", *urlFetcherDocAddr)
+	}
+	v.Ports("ot-port", *otPort, 1)
+	v.Ports("ot-pool-port", *otPoolPort, *otPoolSize)
+	v.Ports("tag-verification-iv-port", *tagVerificationIvPort, at.MPC_PORT_COUNT)
+	v.Ports("tag-verification-poh-port", *tagVerificationPohPort, at.MPC_PORT_COUNT)
+	v.NotNegative("ot-pool-size", *otPoolSize)
+	v.Check(len(*otAdvertiseHost) <= 255, "ot-advertise-host", "must be at most 255 bytes")
+	var otImplementationErr error
+	otImplementation, otImplementationErr = ote.ParseImplementation(*otImplementationName)
+	v.Check(otImplementationErr == nil, "ot-implementation", "must be native or go")
+	helperSandboxMode, helperSandboxErr := jail.ParseMode(*helperSandbox)
+	v.Check(helperSandboxErr == nil, "helper-sandbox", "must be none, basic or nsjail")
+	v.Check(helperSandboxMode != jail.MODE_NSJAIL || *helperNsjailConfig != "", "helper-nsjail-config", "must be set with --helper-sandbox nsjail")
+	v.Check(*garbledPoolSize >= 1, "garbled-pool-size", "must be at least 1")
+	schedule, scheduleErr := garbled_pool.ParseSchedule(*garbledPoolSchedule)
+	v.Check(scheduleErr == nil, "garbled-pool-schedule", fmt.Sprint(scheduleErr))
+	v.NotNegative("garbled-pool-remote-size", *garbledPoolRemoteSize)
+	v.Check(*garbledPoolVerifyInterval >= 0, "garbled-pool-verify-interval", "must not be negative")
+	v.Check(*garbledPoolRemote == "" || *garbledPoolRemoteSize > 0, "garbled-pool-remote-size", "must be set with --garbled-pool-remote")
+	v.Check(!verifierOnly || *transparencyLogPath == "", "transparency-log", "can't be used with --verifier-only")
+	v.Check(maxBlobSize >= 0, "max-blob-size", "must not be negative")
+	v.Check(*memoryBlobBudget >= 0, "memory-blob-budget", "must not be negative")
+	v.Check(*directoryURL == "" || *publicURL != "", "directory-url", "requires public-url")
+	v.Positive("directory-interval", *directoryInterval)
+	v.Check(*keyEpoch == 0 || (*keyEpoch >= time.Minute && *keyEpoch%time.Second == 0), "key-epoch", "must be whole seconds and at least a minute")
+	v.Positive("session-idle-timeout", *sessionIdleTimeout)
+	v.Positive("session-max-duration", *sessionMaxDuration)
+	v.NotNegative("session-queue-length", *sessionQueueLength)
+	v.Positive("session-queue-poll-timeout", *sessionQueuePollTimeout)
+	for phase, limit := range sla.Limits {
+		name := "sla-" + strings.ReplaceAll(session.Phase(phase).String(), "_", "-")
+		v.Check(limit >= 0, name, "must not be negative")
+	}
+	v.Check(*stepRateBurst >= 1, "step-rate-burst", "must be at least 1")
+	v.Check((*tlsCert == "") == (*tlsKey == ""), "tls-cert", "must be given together with tls-key")
+	v.Check(*autocertDomain == "" || *tlsCert == "", "autocert-domain", "can't be combined with tls-cert")
+	info, statErr := os.Stat(*storageDir)
+	v.Check(statErr == nil && info.IsDir(), "storage-dir", "must be an existing dir")
+	if err = v.Err(); err != nil {
+		log.Fatalln("invalid settings:\n" + err.Error())
+	}
+	if *auditLogPath == "" {
+		*auditLogPath = filepath.Join(*storageDir, "audit.log")
+	}
+	if *memoryBlobBudget > 0 {
+		blobBudget = &session.BlobBudget{Limit: *memoryBlobBudget}
+	}
+	if *checkConfig {
+		config.Write(flag.CommandLine, os.Stdout, "config", "check-config")
+		return
+	}
 	log.Println("noSandbox", *noSandbox)
 
+	// don't start if the random number generator is broken from the start
+	if err = rng_health.Check(); err != nil {
+		log.Fatalln(err)
+	}
+	if *rngCheckInterval > 0 {
+		go rng_health.Run(*rngCheckInterval)
+	}
+
+	al, err = audit_log.NewAuditLog(*auditLogPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer al.Close()
+	if *retention > 0 {
+		go al.EnforceRetention(*retention)
+	}
+
+	bl, err = ban_list.NewBanList(filepath.Join(*storageDir, "banlist.json"), *banTTL)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	go serveAdminAPI(*adminAddr)
+
 	tagVerificationCircuits := checkTagVerificationCircuits()
 
-	tagSigner, err := at.NewTagSigningManager("signing.key")
+	// a verifier-only notary signs no tags and doesn't need the key
+	var tagSigner *at.TagSigningManager
+	if !verifierOnly {
+		tagSigner, err = at.NewTagSigningManager("signing.key")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		tagSigner.Deterministic = deterministicSignatures
+		if err = tagSigner.LoadKeyHistory(filepath.Join(*storageDir, "signing-keys.json")); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	err = egress.SetProxy(*egressProxy)
 	if err != nil {
 		log.Fatalln(err)
 	}
+	err = jail.SetMode(helperSandboxMode, *helperNsjailConfig)
+	if err != nil {
+		log.Fatalln("could not sandbox the helpers:", err)
+	}
+	if *otBrokerURL != "" {
+		otBroker, err = ot_broker.NewClient(*otBrokerURL, os.Getenv("OT_BROKER_SECRET"), *otBrokerAddr, *otBackendHost)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
 
 	km = new(key_manager.KeyManager)
+	km.EpochDuration = *keyEpoch
 	km.Init()
-	otManager, err := ote.NewManager(12345)
+	if *rootKeyPath != "" {
+		err = km.LoadRootKey(*rootKeyPath)
+		if err != nil {
+			log.Fatalln("could not load root key:", err)
+		}
+	}
+	otManager, err := ote.NewManager(*otPort, otImplementation)
 	if err != nil {
 		log.Fatalln(err)
 	}
+	otManager.SetAddresses(*otBindHost, *otAdvertiseHost, *otAdvertisePortOffset)
+	var otPool *ote.Pool
+	if *otPoolSize > 0 {
+		otPool, err = ote.NewPool(*otPoolPort, *otPoolSize, otImplementation)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		otPool.SetAddresses(*otBindHost, *otAdvertiseHost, *otAdvertisePortOffset)
+	}
 	assembleCircuits()
 	sm = new(session_manager.SessionManager)
-	sm.Init(tagVerificationCircuits, 10020, 10030, tagSigner, otManager)
+	sm.IdleTimeout = *sessionIdleTimeout
+	sm.MaxDuration = *sessionMaxDuration
+	sm.QueueLength = *sessionQueueLength
+	sm.QueuePollTimeout = *sessionQueuePollTimeout
+	sm.StorageDir = *storageDir
+	sm.SLA = sla
+	sm.VerifierOnly = verifierOnly
+	if *sessionCheckpointDir != "" {
+		sm.Checkpoints, err = session_manager.NewCheckpointStore(*sessionCheckpointDir)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	var transparencyLog *transparency_log.Log
+	if *transparencyLogPath != "" {
+		transparencyLog, err = transparency_log.NewLog(*transparencyLogPath, tagSigner)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer transparencyLog.Close()
+		sm.TransparencyLog = transparencyLog
+	}
+	jan, err := janitor.NewJanitor(*storageDir)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	sm.Init(tagVerificationCircuits, *tagVerificationIvPort, *tagVerificationPohPort, tagSigner, otManager, otPool, jan)
 	gp = new(garbled_pool.GarbledPool)
-	gp.Init(*noSandbox)
+	if err = gp.SetSchedule(schedule); err != nil {
+		log.Fatalln(err)
+	}
+	if *garbledPoolRemote != "" {
+		store, err := object_store.NewStore(*garbledPoolRemote, *garbledPoolRemoteRegion,
+			os.Getenv("OBJECT_STORE_ACCESS_KEY"), os.Getenv("OBJECT_STORE_SECRET_KEY"))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		gp.SetRemote(store, *garbledPoolRemoteSize)
+	}
+	gp.SetVerify(*garbledPoolVerifyInterval, func() bool {
+		return len(sm.Sessions()) > 0
+	})
+	gp.Init(*noSandbox, *halfGates, *garbledPoolSize, *storageDir)
 
 	zkeyHandler, err := zkey.NewZkeyHandler("zkey-content")
 	if err != nil {
@@ -274,39 +1432,169 @@ func main() {
 
 	if !*noSandbox {
 		mux.HandleFunc("/getURLFetcherDoc", getURLFetcherDoc)
-		go awaitURLFetcherDoc()
+		go awaitURLFetcherDoc(*urlFetcherDocAddr)
 	}
 	// although getPubKey is only used in noSandbox cases, it still
 	// can be useful when debugging sandboxed notary
-	mux.HandleFunc("/getPubKey", getPubKey)
+	mux.HandleFunc("/getPubKey", getPubKey(tagSigner))
 
 	mux.HandleFunc("/getBlob", getBlob)
+	mux.HandleFunc("/getBlobRange", getBlob)
 	mux.HandleFunc("/setBlob", setBlob)
+	mux.HandleFunc("/setBlobChunk", setBlobChunk)
+	mux.HandleFunc("/exportSession", exportSession)
+	mux.HandleFunc("/resumeSession", resumeSession)
 	mux.HandleFunc("/ping", ping)
 
 	mux.HandleFunc("/zkey_sizes", zkeyHandler.GetSupportedBlockSizes)
 	mux.HandleFunc("/zkey", zkeyHandler.GetKeys)
-	mux.HandleFunc("/signing-key.pem", tagSigner.ServePublicKey)
+	mux.HandleFunc("/zkey_setup", zkey.NewZkeySetupHandler("zkey-content").GetSetup)
+	if !verifierOnly {
+		mux.Handle("/numeric_claim", numeric_claim.NewClaimHandler(zkeyHandler, tagSigner))
+		mux.HandleFunc("/signing-key.pem", serveSigningKey(tagSigner))
+	}
+	mux.HandleFunc("/attestationCounters", getAttestationCounters)
+	mux.HandleFunc("/heartbeat", heartbeat)
+	mux.HandleFunc("/verify", verifyAttestation(tagSigner))
+	if transparencyLog != nil {
+		mux.HandleFunc("/log/treeHead", transparencyLog.ServeTreeHead)
+		mux.HandleFunc("/log/proof", transparencyLog.ServeProof)
+		mux.HandleFunc("/log/consistency", transparencyLog.ServeConsistency)
+		mux.HandleFunc("/log/entries", transparencyLog.ServeEntries)
+	}
+	garblingScheme, minProtocol := "grr3", session.PROTOCOL_LEGACY
+	if *halfGates {
+		garblingScheme, minProtocol = "half-gates", session.PROTOCOL_HALF_GATES
+	}
+	if verifierOnly {
+		minProtocol = session.PROTOCOL_ATTESTATION_DOCUMENT
+	}
+	if otImplementation != ote.IMPLEMENTATION_NATIVE {
+		minProtocol = session.PROTOCOL_OT_IMPLEMENTATION
+	}
+	mux.HandleFunc("/errors", api_error.ServeCatalog)
+	mux.HandleFunc("/protocol", step_chain.ServeProtocol(minProtocol))
+	probeHandler := newProbeHandler(*sessionMaxDuration, sla)
+	mux.HandleFunc("/probe", unlessBanned(probeHandler.ServeHTTP))
+	mux.HandleFunc("/probe/estimate", unlessBanned(probeHandler.ServeEstimate))
+	mux.HandleFunc("/probe/cost", unlessBanned(probeHandler.ServeCost))
 
-	// all the other request will end up in the httpHandler
-	mux.HandleFunc("/", httpHandler)
+	// all the other request are protocol steps
+	steps := newStepChain(*stepRateLimit, *stepRateBurst)
+	mux.Handle("/", steps)
+	// the steps can also be sent over one WebSocket connection
+	mux.HandleFunc("/ws", steps.ServeWebSocket)
+	// or as unary gRPC calls, see grpc_api/notary.proto
+	mux.HandleFunc("/"+grpc_api.SERVICE+"/", steps.ServeGRPC)
+	if *otTunnel {
+		mux.HandleFunc("/otTunnel", serveOtTunnel)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
+	tlsConfig, err := serverTLSConfig(*tlsCert, *tlsKey, *autocertDomain, *storageDir)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	var operator policy.Operator
+	if *policyFile != "" {
+		operator, err = policy.LoadOperator(*policyFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	signatureScheme := "randomized"
+	if deterministicSignatures {
+		signatureScheme = "rfc6979"
+	}
+	if verifierOnly {
+		signatureScheme = "none"
+	}
+	policyHandler, err := policy.NewHandler(policy.Policy{
+		Limits: policy.Limits{
+			MaxBlobSize:        maxBlobSize,
+			SessionIdleTimeout: int64(sessionIdleTimeout.Seconds()),
+			SessionMaxDuration: int64(sessionMaxDuration.Seconds()),
+			StepRateLimit:      *stepRateLimit,
+			ZkeySizes:          zkeyHandler.Sizes(),
+			PhaseSLAs:          phaseSLAs(sla),
+		},
+		Retention: policy.Retention{
+			SessionData: int64(sessionMaxDuration.Seconds()),
+			Ban:         int64(banTTL.Seconds()),
+		},
+		Logging: policy.Logging{
+			AuditEvents:     []string{"cheat_detected", "client_banned"},
+			ClientAddresses: true,
+		},
+		Features: policy.Features{
+			ProtocolVersions:   [2]int{minProtocol, session.PROTOCOL_LATEST},
+			GarblingScheme:     garblingScheme,
+			SignatureScheme:    signatureScheme,
+			AttestationMetrics: attestationMetrics,
+			Transports:         []string{"http", "websocket", "grpc"},
+			TLS:                tlsConfig != nil,
+			OTPool:             otPool != nil,
+			OTBroker:           otBroker != nil,
+			OTTunnel:           *otTunnel,
+			OTImplementation:   string(otImplementation),
+			Sandboxed:          !*noSandbox,
+			TransparencyLog:    transparencyLog != nil,
+			VerifierOnly:       verifierOnly,
+		},
+		Operator: operator,
+	}, km.SignWithMasterKey)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	mux.HandleFunc("/policy", func(w http.ResponseWriter, req *http.Request) {
+		setSignatureHeaders(w, policyHandler.Signature(), "master")
+		policyHandler.ServeHTTP(w, req)
+	})
+	if *directoryURL != "" {
+		record := func() directory.Record {
+			// the directory format has its own copy of the load, so that
+			// directories don't depend on the session manager
+			load := sm.Load()
+			return directory.Record{
+				URL:             *publicURL,
+				Region:          *region,
+				MasterKey:       string(km.MasterPubKeyPEM),
+				Policy:          policyHandler.Body(),
+				PolicySignature: hex.EncodeToString(policyHandler.Signature()),
+				Load:            directory.Load(load),
+			}
+		}
+		go directory.NewPublisher(*directoryURL, *directoryInterval, record, km.SignWithMasterKey).Run()
+	}
+
+	// gRPC clients need HTTP/2, which is h2c when serving without TLS
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+
 	server := http.Server{
-		Addr:         "0.0.0.0:10011",
-		WriteTimeout: 5 * time.Minute,
-		ReadTimeout:  1 * time.Minute,
-		Handler:      mux,
-		BaseContext:  func(l net.Listener) context.Context { return ctx },
+		Addr:        *listenAddr,
+		ReadTimeout: 1 * time.Minute,
+		// there is no server-wide WriteTimeout, see withWriteDeadline
+		Handler:     withWriteDeadline(mux),
+		BaseContext: func(l net.Listener) context.Context { return ctx },
+		Protocols:   protocols,
+		TLSConfig:   tlsConfig,
 	}
-	log.Println("Listening on :10011")
+	log.Println("Listening on", *listenAddr, "TLS:", tlsConfig != nil)
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 
 	go func() {
-		err = server.ListenAndServe()
+		if tlsConfig != nil {
+			// the certificate is in TLSConfig
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
 		if err != nil && err != http.ErrServerClosed {
 			log.Fatalln(err)
 		}
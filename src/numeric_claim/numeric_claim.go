@@ -0,0 +1,242 @@
+// Package numeric_claim lets a client prove a numeric predicate (e.g.
+// "balance > 1000") over plaintext it committed to in a notarized session,
+// without revealing the plaintext. The client proves the predicate with a
+// Groth16 proof made with one of the keys served at /zkey. The notary
+// verifies the proof and signs a claim which references the original
+// attestation.
+package numeric_claim
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/big"
+	"net/http"
+	at "notary/aes_tag"
+	"notary/api_error"
+	"notary/jail"
+	"notary/utils"
+	"notary/zkey"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// opCodes maps the predicate operators to the value of the op public signal
+var opCodes = map[string]int64{
+	"gt":  1,
+	"gte": 2,
+	"lt":  3,
+	"lte": 4,
+	"eq":  5,
+}
+
+// maxConcurrentVerifications limits the amount of snarkjs processes
+const maxConcurrentVerifications = 4
+
+type claimRequest struct {
+	// Size is the size of the zkey the proof was made with
+	Size  int             `json:"size"`
+	Proof json.RawMessage `json:"proof"`
+	// PublicSignals are decimal strings: commitment, op code, threshold
+	PublicSignals []string `json:"publicSignals"`
+	// Attestation is the hex-encoded notary signature of the session
+	// whose plaintext the proof is about
+	Attestation string `json:"attestation"`
+	Op          string `json:"op"`
+	// Threshold is a decimal number
+	Threshold string `json:"threshold"`
+}
+
+// Claim is the statement signed by the notary
+type Claim struct {
+	// Attestation is the hex-encoded sha256 hash of the original
+	// attestation signature
+	Attestation string `json:"attestation"`
+	// Commitment is the commitment to the plaintext, as it appears in the
+	// public signals of the proof. Verifiers must check that it matches the
+	// commitment signed in the original attestation.
+	Commitment string `json:"commitment"`
+	Op         string `json:"op"`
+	Threshold  string `json:"threshold"`
+	Size       int    `json:"size"`
+	Time       int64  `json:"time"`
+}
+
+type claimResponse struct {
+	// Claim is the exact JSON which was signed
+	Claim     json.RawMessage `json:"claim,omitempty"`
+	Signature string          `json:"signature,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+type ClaimHandler struct {
+	zkeys     *zkey.ZkeyHttpHandler
+	tagSigner *at.TagSigningManager
+	// verifications is a semaphore for snarkjs processes
+	verifications chan struct{}
+}
+
+func NewClaimHandler(zkeys *zkey.ZkeyHttpHandler, tagSigner *at.TagSigningManager) *ClaimHandler {
+	return &ClaimHandler{
+		zkeys:         zkeys,
+		tagSigner:     tagSigner,
+		verifications: make(chan struct{}, maxConcurrentVerifications),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, response *claimResponse) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		api_error.Write(w, http.StatusInternalServerError, api_error.INTERNAL, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// writeError writes a failed claimResponse with the error code
+func writeError(w http.ResponseWriter, status int, code api_error.Code, message string) {
+	api_error.SetCode(w, code)
+	writeJSON(w, status, &claimResponse{Error: message})
+}
+
+func (h *ClaimHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		api_error.Write(w, http.StatusMethodNotAllowed, api_error.METHOD_NOT_ALLOWED, "")
+		return
+	}
+
+	claimReq := new(claimRequest)
+	err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 1<<20)).Decode(claimReq)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, api_error.INVALID_REQUEST, "invalid body")
+		return
+	}
+	claim, err := h.checkRequest(claimReq)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, api_error.INVALID_REQUEST, err.Error())
+		return
+	}
+
+	ok, err := h.verifyProof(claimReq)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, api_error.INTERNAL, err.Error())
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusUnprocessableEntity, api_error.INVALID_PROOF, "invalid proof")
+		return
+	}
+
+	claimJSON, err := json.Marshal(claim)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, api_error.INTERNAL, "internal error")
+		return
+	}
+	signature, err := h.tagSigner.SignData(claimJSON)
+	if err != nil {
+		log.Println("numeric claim:", err)
+		writeError(w, http.StatusInternalServerError, api_error.INTERNAL, "failed to sign claim")
+		return
+	}
+	writeJSON(w, http.StatusOK, &claimResponse{
+		Claim:     claimJSON,
+		Signature: hex.EncodeToString(signature),
+	})
+}
+
+// checkRequest makes sure that the public signals of the proof match the
+// claimed predicate and returns the claim to sign
+func (h *ClaimHandler) checkRequest(claimReq *claimRequest) (*Claim, error) {
+	if _, ok := h.zkeys.VerifyingKey(claimReq.Size); !ok {
+		return nil, errors.New("no keys of this size")
+	}
+	attestation, err := hex.DecodeString(claimReq.Attestation)
+	if err != nil || len(attestation) == 0 {
+		return nil, errors.New("invalid attestation")
+	}
+	opCode, ok := opCodes[claimReq.Op]
+	if !ok {
+		return nil, errors.New("unknown op")
+	}
+	threshold, ok := new(big.Int).SetString(claimReq.Threshold, 10)
+	if !ok || threshold.Sign() < 0 {
+		return nil, errors.New("invalid threshold")
+	}
+	if len(claimReq.PublicSignals) != 3 {
+		return nil, errors.New("expected 3 public signals")
+	}
+	signals := make([]*big.Int, len(claimReq.PublicSignals))
+	for i, s := range claimReq.PublicSignals {
+		signals[i], ok = new(big.Int).SetString(s, 10)
+		if !ok {
+			return nil, errors.New("invalid public signal")
+		}
+	}
+	if signals[1].Cmp(big.NewInt(opCode)) != 0 || signals[2].Cmp(threshold) != 0 {
+		return nil, errors.New("public signals don't match the claim")
+	}
+	return &Claim{
+		Attestation: hex.EncodeToString(utils.Sha256(attestation)),
+		Commitment:  signals[0].String(),
+		Op:          claimReq.Op,
+		Threshold:   threshold.String(),
+		Size:        claimReq.Size,
+		Time:        time.Now().Unix(),
+	}, nil
+}
+
+// verifyProof runs snarkjs to verify the Groth16 proof
+func (h *ClaimHandler) verifyProof(claimReq *claimRequest) (bool, error) {
+	h.verifications <- struct{}{}
+	defer func() { <-h.verifications }()
+
+	errInternal := errors.New("internal error in proof verification")
+
+	dir, err := os.MkdirTemp("", "numeric_claim")
+	if err != nil {
+		log.Println(err)
+		return false, errInternal
+	}
+	defer os.RemoveAll(dir)
+
+	vkey, _ := h.zkeys.VerifyingKey(claimReq.Size)
+	publicSignals, err := json.Marshal(claimReq.PublicSignals)
+	if err != nil {
+		return false, errInternal
+	}
+	files := map[string][]byte{
+		"vk.json":     vkey,
+		"public.json": publicSignals,
+		"proof.json":  claimReq.Proof,
+	}
+	for name, content := range files {
+		err = os.WriteFile(filepath.Join(dir, name), content, 0600)
+		if err != nil {
+			log.Println(err)
+			return false, errInternal
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	cmd := jail.CommandContext(ctx, dir, "snarkjs", "groth16", "verify", "vk.json", "public.json", "proof.json")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			log.Println("Numeric claim proof verification failed:", string(output))
+			return false, nil
+		}
+		log.Println("Could not run snarkjs:", err)
+		return false, errInternal
+	}
+	return strings.Contains(string(output), "OK"), nil
+}
@@ -0,0 +1,191 @@
+// Package object_store is a minimal client of S3 compatible object storage,
+// e.g. Amazon S3 or Google Cloud Storage with HMAC keys. Requests are signed
+// with AWS Signature Version 4 and their payloads are not hashed, so that
+// objects can be streamed.
+package object_store
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"notary/egress"
+	"sort"
+	"strings"
+	"time"
+)
+
+// UNSIGNED_PAYLOAD is the payload hash of requests whose body is not signed
+const UNSIGNED_PAYLOAD = "UNSIGNED-PAYLOAD"
+
+// REQUEST_TIMEOUT limits each request, including streaming its body
+const REQUEST_TIMEOUT = 10 * time.Minute
+
+// Store reads and writes the objects under a URL
+type Store struct {
+	// base is the URL of the bucket, with an optional prefix of the keys,
+	// e.g. https://storage.googleapis.com/bucket/prefix
+	base      *url.URL
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+	// now returns the time of the signatures
+	now func() time.Time
+}
+
+// NewStore returns a store of the objects under rawURL in region. GCS
+// accepts the region "auto".
+func NewStore(rawURL, region, accessKey, secretKey string) (*Store, error) {
+	base, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "https" && base.Scheme != "http" || base.Host == "" {
+		return nil, errors.New("object_store: expected an http(s) URL of the bucket")
+	}
+	if accessKey == "" || secretKey == "" {
+		return nil, errors.New("object_store: missing credentials")
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/")
+	return &Store{
+		base:      base,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    egress.HTTPClient(REQUEST_TIMEOUT),
+		now:       time.Now,
+	}, nil
+}
+
+// String returns the URL of the store
+func (s *Store) String() string {
+	return s.base.String()
+}
+
+// Put stores size bytes read from body as key
+func (s *Store) Put(key string, body io.Reader, size int64) error {
+	req, err := s.request(http.MethodPut, key, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get returns the object key. The caller must close it.
+func (s *Store) Get(key string) (io.ReadCloser, error) {
+	req, err := s.request(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete removes the object key
+func (s *Store) Delete(key string) error {
+	req, err := s.request(http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *Store) request(method, key string, body io.Reader) (*http.Request, error) {
+	u := *s.base
+	u.Path += "/" + key
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, s.now())
+	return req, nil
+}
+
+// do sends req and turns responses other than 2xx into errors
+func (s *Store) do(req *http.Request) (*http.Response, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("object_store: %s %s: %s %s", req.Method, req.URL.Path, resp.Status, msg)
+	}
+	return resp, nil
+}
+
+// sign adds the Signature Version 4 headers to req. The payload hash is
+// taken from the x-amz-content-sha256 header and set to UNSIGNED_PAYLOAD if
+// missing.
+func (s *Store) sign(req *http.Request, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := req.Header.Get("x-amz-content-sha256")
+	if payloadHash == "" {
+		payloadHash = UNSIGNED_PAYLOAD
+		req.Header.Set("x-amz-content-sha256", payloadHash)
+	}
+	req.Header.Set("x-amz-date", amzDate)
+
+	// every header which is set is signed, and so is the host
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{date, s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
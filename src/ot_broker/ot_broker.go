@@ -0,0 +1,219 @@
+// Package ot_broker lets several notary instances share one publicly
+// reachable OT address. A notary registers a one-time token together with
+// the internal address of the OT manager serving the session. The client
+// connects to the broker, sends the token and from then on the broker
+// forwards the connection to that OT manager.
+package ot_broker
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"notary/egress"
+	"sync"
+	"time"
+)
+
+// TokenSize is the size of a routing token in bytes
+const TokenSize = 16
+
+// handshakeTimeout is how long the broker waits for the client's token
+const handshakeTimeout = 10 * time.Second
+
+// route is a registered OT backend
+type route struct {
+	backend string
+	expires time.Time
+}
+
+type registerRequest struct {
+	// Token is hex-encoded
+	Token string `json:"token"`
+	// Backend is the host:port of the OT manager
+	Backend string `json:"backend"`
+}
+
+// Broker forwards OT connections of clients to the notary instance which
+// owns their session
+type Broker struct {
+	sync.Mutex
+	// routes are keyed by the hex-encoded token
+	routes map[string]route
+	// ttl is how long a registered token can be used
+	ttl time.Duration
+	// secret authenticates notary instances at the registration endpoint
+	secret string
+}
+
+func NewBroker(ttl time.Duration, secret string) (*Broker, error) {
+	if secret == "" {
+		return nil, errors.New("ot_broker: empty registration secret")
+	}
+	b := &Broker{
+		routes: make(map[string]route),
+		ttl:    ttl,
+		secret: secret,
+	}
+	go b.expireRoutes()
+	return b, nil
+}
+
+// ServeRegistration is the HTTP endpoint at which notaries register tokens
+func (b *Broker) ServeRegistration(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(req.Header.Get("X-Broker-Secret")), []byte(b.secret)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var r registerRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 4096)).Decode(&r)
+	if err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	token, err := hex.DecodeString(r.Token)
+	if err != nil || len(token) != TokenSize {
+		http.Error(w, "invalid token", http.StatusBadRequest)
+		return
+	}
+	if _, _, err = net.SplitHostPort(r.Backend); err != nil {
+		http.Error(w, "invalid backend", http.StatusBadRequest)
+		return
+	}
+	b.Lock()
+	b.routes[r.Token] = route{backend: r.Backend, expires: time.Now().Add(b.ttl)}
+	b.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Serve accepts client connections until the listener is closed
+func (b *Broker) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go b.forward(conn)
+	}
+}
+
+// forward reads the token from the client and pipes the connection to the
+// registered backend. A token can be used only once.
+func (b *Broker) forward(client net.Conn) {
+	defer client.Close()
+	token := make([]byte, TokenSize)
+	client.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	if _, err := io.ReadFull(client, token); err != nil {
+		return
+	}
+	client.SetReadDeadline(time.Time{})
+
+	key := hex.EncodeToString(token)
+	b.Lock()
+	r, ok := b.routes[key]
+	delete(b.routes, key)
+	b.Unlock()
+	if !ok || time.Now().After(r.expires) {
+		log.Println("ot_broker: unknown token from", client.RemoteAddr())
+		return
+	}
+
+	backend, err := net.DialTimeout("tcp", r.backend, handshakeTimeout)
+	if err != nil {
+		log.Println("ot_broker: could not connect to backend", r.backend, err)
+		return
+	}
+	defer backend.Close()
+
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		// unblock the other direction
+		dst.SetDeadline(time.Now())
+		src.SetDeadline(time.Now())
+		done <- struct{}{}
+	}
+	go pipe(backend, client)
+	go pipe(client, backend)
+	<-done
+	<-done
+}
+
+func (b *Broker) expireRoutes() {
+	for {
+		time.Sleep(time.Minute)
+		now := time.Now()
+		b.Lock()
+		for key, r := range b.routes {
+			if now.After(r.expires) {
+				delete(b.routes, key)
+			}
+		}
+		b.Unlock()
+	}
+}
+
+// Client is used by a notary to register tokens with a broker
+type Client struct {
+	registerURL string
+	secret      string
+	// PublicAddr is the host:port of the broker which clients connect to
+	PublicAddr string
+	// backendHost is the host at which the broker reaches this notary's OT
+	// managers
+	backendHost string
+	http        *http.Client
+}
+
+func NewClient(registerURL string, secret string, publicAddr string, backendHost string) (*Client, error) {
+	if _, _, err := net.SplitHostPort(publicAddr); err != nil {
+		return nil, fmt.Errorf("ot_broker: invalid public address: %w", err)
+	}
+	if len(publicAddr) > 255 {
+		return nil, errors.New("ot_broker: public address is too long")
+	}
+	return &Client{
+		registerURL: registerURL,
+		secret:      secret,
+		PublicAddr:  publicAddr,
+		backendHost: backendHost,
+		http:        egress.HTTPClient(10 * time.Second),
+	}, nil
+}
+
+// Register tells the broker to forward the client which sends token to the
+// OT manager listening on port
+func (c *Client) Register(token []byte, port int) error {
+	body, err := json.Marshal(registerRequest{
+		Token:   hex.EncodeToString(token),
+		Backend: net.JoinHostPort(c.backendHost, fmt.Sprint(port)),
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.registerURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Broker-Secret", c.secret)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("ot_broker: registration failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+// ot_broker_server is the OT rendezvous point for several notary instances.
+// Clients connect to -listen, notaries register their sessions at
+// -register-addr (which must only be reachable by the notaries).
+//
+// go run ./ot_broker_server -listen 0.0.0.0:12300 -register-addr 10.0.0.1:12301 -secret <secret>
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"notary/ot_broker"
+	"os"
+	"time"
+)
+
+func main() {
+	listen := flag.String("listen", "0.0.0.0:12300", "Address on which clients connect for OT.")
+	registerAddr := flag.String("register-addr", "127.0.0.1:12301", "Address on which notaries register sessions.")
+	ttl := flag.Duration("ttl", 5*time.Minute, "How long a registered session can be used.")
+	secret := flag.String("secret", os.Getenv("OT_BROKER_SECRET"), "Secret shared with the notaries. Defaults to $OT_BROKER_SECRET.")
+	flag.Parse()
+
+	broker, err := ot_broker.NewBroker(*ttl, *secret)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/register", broker.ServeRegistration)
+	go func() {
+		log.Fatalln(http.ListenAndServe(*registerAddr, mux))
+	}()
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Println("OT broker listening on", *listen)
+	log.Fatalln(broker.Serve(listener))
+}
@@ -0,0 +1,193 @@
+package ote
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// streamChunkSize is the size of chunks in which OT data is copied between
+// the caller and the backend
+const streamChunkSize = 64 * 1024
+
+// Implementation names an implementation of OT. The client must use the same
+// one.
+type Implementation string
+
+const (
+	// IMPLEMENTATION_NATIVE is SoftSpokenOT of the cgo ot-wrapper
+	IMPLEMENTATION_NATIVE Implementation = "native"
+	// IMPLEMENTATION_GO is the pure Go OT extension of package kos
+	IMPLEMENTATION_GO Implementation = "go"
+)
+
+func ParseImplementation(s string) (Implementation, error) {
+	switch i := Implementation(s); i {
+	case IMPLEMENTATION_NATIVE, IMPLEMENTATION_GO:
+		return i, nil
+	}
+	return "", errors.New("unknown OT implementation " + s + ", expected native or go")
+}
+
+// backend runs OT with the client over one connection at a time
+type backend interface {
+	// connect blocks until a client connected to addr or a connection was
+	// attached
+	connect(addr string) error
+	// attach hands conn to the pending connect
+	attach(conn net.Conn) error
+	disconnect()
+	isConnected() bool
+	// requestData writes the 16 byte messages chosen by choices to w
+	requestData(choices []int, w io.Writer) error
+	// respondWithData transfers size bytes read from r. sent is called
+	// with the size of each write to the client's connection during the
+	// transfer.
+	respondWithData(r io.Reader, size int, sent func(n int)) error
+	// free releases the resources of the backend
+	free()
+}
+
+func newBackend(i Implementation) (backend, error) {
+	if i == IMPLEMENTATION_GO {
+		return new(goBackend), nil
+	}
+	return newNativeBackend()
+}
+
+// ATTACH_TIMEOUT is how long Attach waits for the manager to listen
+const ATTACH_TIMEOUT = 5 * time.Second
+
+var errNotListening = errors.New("the OT manager is not listening")
+
+// connError is a failure of the client's connection
+type connError struct {
+	error
+}
+
+// pipe copies between a and b until either side is done, then closes both
+func pipe(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	copyTo := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go copyTo(a, b)
+	go copyTo(b, a)
+	<-done
+	a.Close()
+	b.Close()
+}
+
+// acceptor hands a backend the client's connection: either a client which
+// connected to the manager's port or a connection passed to attach
+type acceptor struct {
+	mutex sync.Mutex
+	// attached receives connections from attach while accept waits
+	attached chan net.Conn
+}
+
+// accept blocks until a client connected to addr or a connection was
+// attached
+func (a *acceptor) accept(addr string) (net.Conn, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	attached := make(chan net.Conn)
+	a.mutex.Lock()
+	a.attached = attached
+	a.mutex.Unlock()
+
+	accepted := make(chan net.Conn, 1)
+	errs := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			errs <- err
+			return
+		}
+		accepted <- conn
+	}()
+	var conn net.Conn
+	wasAttached := false
+	select {
+	case conn = <-accepted:
+	case conn = <-attached:
+		wasAttached = true
+	case err = <-errs:
+	}
+	a.mutex.Lock()
+	a.attached = nil
+	a.mutex.Unlock()
+	listener.Close()
+	if err != nil {
+		return nil, err
+	}
+	if wasAttached {
+		// refuse a client which connected to the port meanwhile
+		go func() {
+			select {
+			case c := <-accepted:
+				c.Close()
+			case <-errs:
+			}
+		}()
+	}
+	return conn, nil
+}
+
+func (a *acceptor) attach(conn net.Conn) error {
+	a.mutex.Lock()
+	attached := a.attached
+	a.mutex.Unlock()
+	if attached == nil {
+		return errNotListening
+	}
+	select {
+	case attached <- conn:
+		return nil
+	case <-time.After(ATTACH_TIMEOUT):
+		return errNotListening
+	}
+}
+
+// meteredConn is the client's connection. It counts what is written to it,
+// in writes of at most streamChunkSize, so that the progress of a large OT
+// response follows the bytes which actually leave the notary.
+type meteredConn struct {
+	net.Conn
+	mutex   sync.Mutex
+	onWrite func(n int)
+}
+
+func (c *meteredConn) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n, err := c.Conn.Write(p[written:min(written+streamChunkSize, len(p))])
+		written += n
+		c.wrote(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// setOnWrite sets the function which is called with the size of each write.
+// Pass nil to stop counting.
+func (c *meteredConn) setOnWrite(onWrite func(n int)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.onWrite = onWrite
+}
+
+func (c *meteredConn) wrote(n int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if n > 0 && c.onWrite != nil {
+		c.onWrite(n)
+	}
+}
@@ -0,0 +1,87 @@
+package ote
+
+import (
+	"expvar"
+	"log"
+	"sync"
+	"time"
+)
+
+// DISCONNECT_POLL_INTERVAL is how often a connected manager checks whether
+// the client's OT connection is still up
+const DISCONNECT_POLL_INTERVAL = time.Second
+
+// otDisconnects counts OT connections which broke before the notary
+// disconnected them
+var otDisconnects = expvar.NewInt("ot_disconnects")
+
+// DisconnectFunc is called once when the client's OT connection broke,
+// either because the native side is no longer connected or because reading
+// or writing OT data failed. It is not called after Disconnect.
+type DisconnectFunc func()
+
+// connWatcher is embedded into Manager
+type connWatcher struct {
+	mutex    sync.Mutex
+	callback DisconnectFunc
+	// connId identifies the current connection. It changes when the
+	// connection ends, which stops its watcher.
+	connId uint64
+}
+
+// SetDisconnectCallback sets the function which is told about broken OT
+// connections. Pass nil to stop receiving them.
+func (w *connWatcher) SetDisconnectCallback(callback DisconnectFunc) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.callback = callback
+}
+
+// currentConn returns the id of the current connection
+func (w *connWatcher) currentConn() uint64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.connId
+}
+
+// endConn ends the connection connId. Returns false if it already ended.
+func (w *connWatcher) endConn(connId uint64) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.connId != connId {
+		return false
+	}
+	w.connId++
+	return true
+}
+
+// lostConn ends the connection connId and calls the callback, unless the
+// connection already ended
+func (w *connWatcher) lostConn(connId uint64) {
+	if !w.endConn(connId) {
+		return
+	}
+	otDisconnects.Add(1)
+	w.mutex.Lock()
+	callback := w.callback
+	w.mutex.Unlock()
+	if callback != nil {
+		callback()
+	}
+}
+
+// watch polls isConnected until the connection connId ends
+func (w *connWatcher) watch(connId uint64, isConnected func() bool) {
+	ticker := time.NewTicker(DISCONNECT_POLL_INTERVAL)
+	defer ticker.Stop()
+	for range ticker.C {
+		if w.currentConn() != connId {
+			return
+		}
+		if !isConnected() {
+			log.Println("OT connection lost")
+			w.lostConn(connId)
+			return
+		}
+	}
+}
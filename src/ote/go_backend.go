@@ -0,0 +1,115 @@
+package ote
+
+import (
+	"errors"
+	"io"
+	"notary/kos"
+	"sync"
+)
+
+// goBackend runs the OT extension of package kos over a TCP connection. The
+// base OTs of each direction are done before its first transfer.
+type goBackend struct {
+	acceptor
+	mutex    sync.Mutex
+	conn     *meteredConn
+	sender   *kos.Sender
+	receiver *kos.Receiver
+}
+
+func (b *goBackend) connect(addr string) error {
+	conn, err := b.accept(addr)
+	if err != nil {
+		return err
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.conn = &meteredConn{Conn: conn}
+	b.sender = nil
+	b.receiver = nil
+	return nil
+}
+
+func (b *goBackend) disconnect() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.conn != nil {
+		b.conn.Close()
+		b.conn = nil
+	}
+}
+
+func (b *goBackend) isConnected() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.conn != nil
+}
+
+// fail closes conn after the transfer over it failed. A failed transfer
+// leaves the parties out of sync, so the connection can't be used anymore.
+func (b *goBackend) fail(conn *meteredConn, err error) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	conn.Close()
+	if b.conn == conn {
+		b.conn = nil
+	}
+	return connError{err}
+}
+
+func (b *goBackend) requestData(choices []int, w io.Writer) error {
+	b.mutex.Lock()
+	conn, receiver := b.conn, b.receiver
+	b.mutex.Unlock()
+	if conn == nil {
+		return errors.New("not connected")
+	}
+	var err error
+	if receiver == nil {
+		if receiver, err = kos.NewReceiver(conn); err != nil {
+			return b.fail(conn, err)
+		}
+		b.mutex.Lock()
+		b.receiver = receiver
+		b.mutex.Unlock()
+	}
+	result, err := receiver.Receive(choices)
+	if err != nil {
+		return b.fail(conn, err)
+	}
+	_, err = w.Write(result)
+	return err
+}
+
+func (b *goBackend) respondWithData(r io.Reader, size int, sent func(n int)) error {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+
+	b.mutex.Lock()
+	conn, sender := b.conn, b.sender
+	b.mutex.Unlock()
+	if conn == nil {
+		return errors.New("not connected")
+	}
+	var err error
+	if sender == nil {
+		if sender, err = kos.NewSender(conn); err != nil {
+			return b.fail(conn, err)
+		}
+		b.mutex.Lock()
+		b.sender = sender
+		b.mutex.Unlock()
+	}
+	conn.setOnWrite(sent)
+	defer conn.setOnWrite(nil)
+	if err = sender.Send(data); err != nil {
+		return b.fail(conn, err)
+	}
+	return nil
+}
+
+func (b *goBackend) free() {
+	b.disconnect()
+}
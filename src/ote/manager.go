@@ -1,135 +1,182 @@
 package ote
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"log"
-
-	ot "github.com/summitto/ot-wrapper/pkg"
+	"net"
+	"notary/chaos"
 )
 
 type Manager struct {
-	native ot.OTManagerGo
-	port   int
+	backend backend
+	port    int
+	// bindHost is the host on which the manager listens
+	bindHost string
+	// advertisedPort is the port which clients must connect to. It differs
+	// from port when the notary is behind port forwarding.
+	advertisedPort int
+	// advertisedHost is the host which clients must connect to. Empty
+	// means the host of the notary's public API.
+	advertisedHost string
+	progressReporter
+	connWatcher
 }
 
-func NewManager(port int) (*Manager, error) {
-	var err error = nil
-	defer func() {
-		recoveredErr := recover()
-		if recoveredErr != nil {
-			strError, ok := recoveredErr.(string)
-			if ok {
-				err = errors.New(strError)
-			} else {
-				err = errors.New("OT unknown error")
-			}
-		}
-	}()
-
-	nativeManager := ot.NewOTManagerGo(true, false)
-
+func NewManager(port int, impl Implementation) (*Manager, error) {
+	backend, err := newBackend(impl)
+	if err != nil {
+		return nil, err
+	}
 	return &Manager{
-		native: nativeManager,
-		port:   port,
-	}, err
+		backend:        backend,
+		port:           port,
+		bindHost:       "0.0.0.0",
+		advertisedPort: port,
+	}, nil
 }
 
 func (m *Manager) Listen() error {
-	if m.native.IsConnected() {
+	if m.backend.isConnected() {
 		return errors.New("busy")
 	}
 
-	var err error = nil
-	defer func() {
-		recoveredErr := recover()
-		if recoveredErr != nil {
-			strError, ok := recoveredErr.(string)
-			if ok {
-				err = errors.New(strError)
-			} else {
-				err = errors.New("OT unknown error")
-			}
-		}
-	}()
-
 	// this will block until the client is connected
-	m.native.Connect(fmt.Sprintf("0.0.0.0:%d", m.port))
+	err := m.backend.connect(fmt.Sprintf("%s:%d", m.bindHost, m.port))
+	if err != nil {
+		return err
+	}
+	go m.watch(m.currentConn(), m.IsConnected)
+
+	return nil
+}
+
+// Attach hands conn, e.g. an OT tunnel over the HTTP port, to the pending
+// Listen as if the client connected to the manager's port
+func (m *Manager) Attach(conn net.Conn) error {
+	if m.IsConnected() {
+		return errors.New("busy")
+	}
+	return m.backend.attach(conn)
+}
+
+// Port returns the port on which the manager listens for the client
+func (m *Manager) Port() int {
+	return m.port
+}
+
+// AdvertisedPort returns the port which clients must connect to
+func (m *Manager) AdvertisedPort() int {
+	return m.advertisedPort
+}
 
-	return err
+// AdvertisedHost returns the host which clients must connect to, or "" if
+// it is the host of the public API
+func (m *Manager) AdvertisedHost() string {
+	return m.advertisedHost
 }
 
+// SetAddresses sets the host to listen on, the host advertised to clients
+// and the offset added to the port to get the port advertised to clients.
+// It must be called before Listen.
+func (m *Manager) SetAddresses(bindHost string, advertisedHost string, advertisedPortOffset int) {
+	m.bindHost = bindHost
+	m.advertisedHost = advertisedHost
+	m.advertisedPort = m.port + advertisedPortOffset
+}
+
+// Disconnect closes the client's connection. The disconnect callback is not
+// called.
 func (m *Manager) Disconnect() {
-	m.native.Disconnect()
+	m.endConn(m.currentConn())
+	m.backend.disconnect()
 }
 
 func (m *Manager) IsConnected() bool {
-	return m.native.IsConnected()
+	return m.backend.isConnected()
 }
 
-func (m *Manager) RequestData(choices []int) (result []byte, err error) {
-	if !m.native.IsConnected() {
-		log.Println("OT request failed - not connected")
-		return nil, errors.New("not connected")
+// RequestData requests data for choices and returns the whole result
+func (m *Manager) RequestData(choices []int) ([]byte, error) {
+	result := new(bytes.Buffer)
+	err := m.RequestDataStream(choices, result)
+	if err != nil {
+		return nil, err
 	}
+	return result.Bytes(), nil
+}
 
-	defer func() {
-		recoveredErr := recover()
-		if recoveredErr != nil {
-			strError, ok := recoveredErr.(string)
-			if ok {
-				err = errors.New(strError)
-			} else {
-				err = errors.New("OT unknown error")
-			}
-		}
-	}()
-
-	// transform 0/1 ints to little-endian bytes, packed with those 0/1s
-	preparedChoices, clear := arrayBitsToLittleEndianBytes(choices)
-	defer clear()
+// RequestDataStream requests data for choices and writes the result to w
+// in chunks of streamChunkSize, without building a copy of the whole result
+func (m *Manager) RequestDataStream(choices []int, w io.Writer) error {
+	if !m.backend.isConnected() {
+		log.Println("OT request failed - not connected")
+		return errors.New("not connected")
+	}
+	if chaos.Fail(chaos.OtDrop) {
+		return errors.New("chaos: OT request dropped")
+	}
 
+	connId := m.currentConn()
 	log.Println("OT requesting", len(choices), "blocks")
-	resultBuf := m.native.RequestData(preparedChoices, int64(len(choices)))
-	defer ot.DeleteUInt8Vector(resultBuf)
-	log.Println("OT request done!")
-
-	for i := 0; i < int(resultBuf.Size()); i++ {
-		result = append(result, resultBuf.Get(i))
+	err := m.backend.requestData(choices, w)
+	m.checkConnError(connId, err)
+	if err != nil {
+		return err
 	}
+	log.Println("OT request done!")
+	return nil
+}
 
-	return
+// RespondWithData responds to the other party's request with data
+func (m *Manager) RespondWithData(data []byte) error {
+	return m.RespondWithStream(bytes.NewReader(data), len(data))
 }
 
-func (m *Manager) RespondWithData(data []byte) (err error) {
-	if !m.native.IsConnected() {
+// RespondWithStream responds to the other party's request with size bytes
+// read from r. The data is read in chunks of streamChunkSize, so callers can
+// pass e.g. an io.MultiReader instead of concatenating the payload first.
+// Progress is reported to the progress callback after every chunk written to
+// the client's connection.
+func (m *Manager) RespondWithStream(r io.Reader, size int) error {
+	if !m.backend.isConnected() {
 		log.Println("OT respond failed - not connected")
 		return errors.New("not connected")
 	}
-
-	defer func() {
-		recoveredErr := recover()
-		if recoveredErr != nil {
-			strError, ok := recoveredErr.(string)
-			if ok {
-				err = errors.New(strError)
-			} else {
-				err = errors.New("OT unknown error")
-			}
-		}
-	}()
-
-	input := ot.NewUInt8Vector()
-	defer ot.DeleteUInt8Vector(input)
-
-	for _, val := range data {
-		input.Add(val)
+	if chaos.Fail(chaos.OtDrop) {
+		return errors.New("chaos: OT response dropped")
 	}
 
-	log.Println("OT responding with", len(data), "bytes")
-	m.native.RespondWithData(input)
+	connId := m.currentConn()
+	otResponsesInProgress.Add(1)
+	defer otResponsesInProgress.Add(-1)
+
+	sent := 0
+	log.Println("OT responding with", size, "bytes")
+	err := m.backend.respondWithData(r, size, func(n int) {
+		sent += n
+		otBytesSent.Add(int64(n))
+		m.report(Progress{Total: size, Sent: min(sent, size)})
+	})
+	m.checkConnError(connId, err)
+	if err != nil {
+		return err
+	}
 	log.Println("OT responding done!")
-	return
+	otResponsesDone.Add(1)
+	m.report(Progress{Total: size, Sent: size, Done: true})
+	return nil
+}
+
+// checkConnError ends the connection connId if err is a failure of the
+// connection
+func (m *Manager) checkConnError(connId uint64, err error) {
+	var connErr connError
+	if errors.As(err, &connErr) {
+		m.lostConn(connId)
+	}
 }
 
 func (m *Manager) Finish() {
@@ -139,33 +186,14 @@ func (m *Manager) Finish() {
 		}
 	}()
 
+	if m.backend == nil {
+		return
+	}
 	if m.IsConnected() {
 		m.Disconnect()
 	}
-	if m.native != nil {
-		ot.DeleteOTManagerGo(m.native)
-	}
-	m.native = nil
-}
-
-func arrayBitsToLittleEndianBytes(bits []int) (result ot.UInt8Vector, cleanup func()) {
-	result = ot.NewUInt8Vector()
-
-	for i := 0; i < len(bits); i += 8 {
-		var val byte = 0
-		for j := 0; j < 8 && i+j < len(bits); j++ {
-			choice := bits[i+j]
-			if choice == 1 {
-				val |= 1 << j
-			}
-		}
-
-		result.Add(val)
-	}
-
-	cleanup = func() {
-		ot.DeleteUInt8Vector(result)
-	}
-
-	return
+	// stop the watcher before the backend is freed
+	m.endConn(m.currentConn())
+	m.backend.free()
+	m.backend = nil
 }
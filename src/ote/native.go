@@ -0,0 +1,207 @@
+//go:build cgo
+
+package ote
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	ot "github.com/summitto/ot-wrapper/pkg"
+)
+
+// nativeBackend wraps SoftSpokenOT of the ot-wrapper. The native side owns
+// its socket, so it listens on a loopback port and the client's connection
+// is relayed to it. That way the writes to the client can be counted.
+type nativeBackend struct {
+	acceptor
+	native ot.OTManagerGo
+	mutex  sync.Mutex
+	// client is the client's connection while the relay runs
+	client *meteredConn
+}
+
+func newNativeBackend() (b backend, err error) {
+	defer recoverError(&err)
+	return &nativeBackend{native: ot.NewOTManagerGo(true, false)}, err
+}
+
+// recoverError turns a panic of the native side into *err
+func recoverError(err *error) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+	if strError, ok := recovered.(string); ok {
+		*err = errors.New(strError)
+	} else {
+		*err = errors.New("OT unknown error")
+	}
+}
+
+// recoverConnError is recoverError for panics of the native side which
+// happen when reading from or writing to the connection fails
+func recoverConnError(err *error) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+	if strError, ok := recovered.(string); ok {
+		*err = connError{errors.New(strError)}
+	} else {
+		*err = connError{errors.New("OT unknown error")}
+	}
+}
+
+func (b *nativeBackend) connect(addr string) error {
+	client, err := b.accept(addr)
+	if err != nil {
+		return err
+	}
+	local, err := b.connectNative()
+	if err != nil {
+		client.Close()
+		return err
+	}
+	metered := &meteredConn{Conn: client}
+	b.mutex.Lock()
+	b.client = metered
+	b.mutex.Unlock()
+	go pipe(metered, local)
+	return nil
+}
+
+// connectNative lets the native side listen on a free loopback port and
+// connects to it
+func (b *nativeBackend) connectNative() (net.Conn, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	connected := make(chan error, 1)
+	go func() {
+		var err error
+		defer func() { connected <- err }()
+		defer recoverError(&err)
+		// this will block until the relay is connected
+		b.native.Connect(addr)
+	}()
+	deadline := time.Now().Add(ATTACH_TIMEOUT)
+	for {
+		local, dialErr := net.DialTimeout("tcp", addr, ATTACH_TIMEOUT)
+		if dialErr == nil {
+			return local, <-connected
+		}
+		select {
+		case err = <-connected:
+			if err == nil {
+				err = dialErr
+			}
+			return nil, err
+		case <-time.After(10 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			// the native side still waits for a connection, end it
+			b.native.Disconnect()
+			return nil, dialErr
+		}
+	}
+}
+
+func (b *nativeBackend) disconnect() {
+	b.native.Disconnect()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.client != nil {
+		b.client.Close()
+		b.client = nil
+	}
+}
+
+func (b *nativeBackend) isConnected() bool {
+	return b.native.IsConnected()
+}
+
+func (b *nativeBackend) requestData(choices []int, w io.Writer) (err error) {
+	defer recoverConnError(&err)
+
+	// transform 0/1 ints to little-endian bytes, packed with those 0/1s
+	preparedChoices, clear := arrayBitsToLittleEndianBytes(choices)
+	defer clear()
+
+	resultBuf := b.native.RequestData(preparedChoices, int64(len(choices)))
+	defer ot.DeleteUInt8Vector(resultBuf)
+
+	// write straight from the vector's memory, one chunk at a time
+	result := vectorBytes(resultBuf)
+	if buf, ok := w.(*bytes.Buffer); ok {
+		buf.Grow(len(result))
+	}
+	for done := 0; done < len(result); done += streamChunkSize {
+		if _, err = w.Write(result[done:min(done+streamChunkSize, len(result))]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (b *nativeBackend) respondWithData(r io.Reader, size int, sent func(n int)) (err error) {
+	defer recoverConnError(&err)
+
+	input := ot.NewUInt8Vector()
+	defer ot.DeleteUInt8Vector(input)
+	// reserve the whole size upfront to avoid reallocations on the C++ side
+	input.Reserve(int64(size))
+
+	chunk := make([]byte, streamChunkSize)
+	for done := 0; done < size; {
+		toRead := size - done
+		if toRead > streamChunkSize {
+			toRead = streamChunkSize
+		}
+		n, readErr := io.ReadFull(r, chunk[:toRead])
+		appendToVector(input, chunk[:n])
+		done += n
+		if readErr != nil {
+			return readErr
+		}
+	}
+
+	b.mutex.Lock()
+	client := b.client
+	b.mutex.Unlock()
+	if client != nil {
+		client.setOnWrite(sent)
+		defer client.setOnWrite(nil)
+	}
+	b.native.RespondWithData(input)
+	return
+}
+
+func (b *nativeBackend) free() {
+	ot.DeleteOTManagerGo(b.native)
+}
+
+func arrayBitsToLittleEndianBytes(bits []int) (result ot.UInt8Vector, cleanup func()) {
+	packed := make([]byte, (len(bits)+7)/8)
+	for i, choice := range bits {
+		if choice == 1 {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+	result = ot.NewUInt8Vector()
+	result.Reserve(int64(len(packed)))
+	appendToVector(result, packed)
+
+	cleanup = func() {
+		ot.DeleteUInt8Vector(result)
+	}
+
+	return
+}
@@ -0,0 +1,9 @@
+//go:build !cgo
+
+package ote
+
+import "errors"
+
+func newNativeBackend() (backend, error) {
+	return nil, errors.New("the notary was built without cgo, use the go OT implementation")
+}
@@ -0,0 +1,118 @@
+package ote
+
+import (
+	"errors"
+	"expvar"
+	"log"
+	"sync"
+)
+
+// otPoolInUse counts the managers of the pool owned by a session
+var otPoolInUse = expvar.NewInt("ot_pool_in_use")
+
+// Pool is a fixed set of OT managers, each listening on its own port. It
+// allows running OT for multiple sessions concurrently.
+type Pool struct {
+	sync.Mutex
+	all  []*Manager
+	free []*Manager
+	// owners are the ids of the sessions which own the managers not in free
+	owners map[*Manager]string
+}
+
+// NewPool creates size OT managers of implementation impl listening on consecutive
+// ports starting with portBegin
+func NewPool(portBegin int, size int, impl Implementation) (*Pool, error) {
+	p := &Pool{owners: make(map[*Manager]string)}
+	for i := 0; i < size; i++ {
+		m, err := NewManager(portBegin+i, impl)
+		if err != nil {
+			p.Finish()
+			return nil, err
+		}
+		p.all = append(p.all, m)
+		p.free = append(p.free, m)
+	}
+	log.Printf("Created OT pool of %d managers on ports %d-%d\n", size, portBegin, portBegin+size-1)
+	return p, nil
+}
+
+// SetAddresses calls Manager.SetAddresses for every manager of the pool
+func (p *Pool) SetAddresses(bindHost string, advertisedHost string, advertisedPortOffset int) {
+	p.Lock()
+	defer p.Unlock()
+	for _, m := range p.all {
+		m.SetAddresses(bindHost, advertisedHost, advertisedPortOffset)
+	}
+}
+
+// Acquire takes a free manager out of the pool for the session owner
+func (p *Pool) Acquire(owner string) (*Manager, error) {
+	p.Lock()
+	defer p.Unlock()
+	if len(p.free) == 0 {
+		return nil, errors.New("OT pool exhausted")
+	}
+	m := p.free[len(p.free)-1]
+	p.free = p.free[:len(p.free)-1]
+	p.owners[m] = owner
+	otPoolInUse.Add(1)
+	return m, nil
+}
+
+// Release disconnects the manager and puts it back into the pool. Managers
+// which are not owned, e.g. because they were already released, are left
+// alone, so that a manager is never handed out twice.
+func (p *Pool) Release(m *Manager) {
+	p.Lock()
+	owner, ok := p.owners[m]
+	delete(p.owners, m)
+	p.Unlock()
+	if !ok {
+		log.Println("Error: release of an OT manager which is not owned, port", m.port)
+		return
+	}
+	if m.IsConnected() {
+		m.Disconnect()
+	}
+	m.SetProgressCallback(nil)
+	m.SetDisconnectCallback(nil)
+	p.Lock()
+	defer p.Unlock()
+	p.free = append(p.free, m)
+	otPoolInUse.Add(-1)
+	log.Println("OT manager on port", m.port, "released by", owner)
+}
+
+// Owners returns the session ids which own managers by port
+func (p *Pool) Owners() map[int]string {
+	p.Lock()
+	defer p.Unlock()
+	owners := make(map[int]string, len(p.owners))
+	for m, owner := range p.owners {
+		owners[m.port] = owner
+	}
+	return owners
+}
+
+// Free returns the amount of managers which are not owned by a session
+func (p *Pool) Free() int {
+	p.Lock()
+	defer p.Unlock()
+	return len(p.free)
+}
+
+// Size returns the amount of managers in the pool
+func (p *Pool) Size() int {
+	return len(p.all)
+}
+
+// Finish shuts down all managers of the pool
+func (p *Pool) Finish() {
+	p.Lock()
+	defer p.Unlock()
+	for _, m := range p.all {
+		m.Finish()
+	}
+	p.free = nil
+}
@@ -0,0 +1,54 @@
+package ote
+
+import (
+	"expvar"
+	"sync"
+)
+
+var (
+	// otBytesSent counts all bytes of OT responses written to the clients'
+	// connections
+	otBytesSent = expvar.NewInt("ot_bytes_sent")
+	// otResponsesInProgress is the amount of OT responses currently running
+	otResponsesInProgress = expvar.NewInt("ot_responses_in_progress")
+	// otResponsesDone counts all finished OT responses
+	otResponsesDone = expvar.NewInt("ot_responses_done")
+)
+
+// Progress describes the state of an OT response
+type Progress struct {
+	// Total is the size of the payload in bytes
+	Total int `json:"total"`
+	// Sent is how many bytes were written to the client's connection so far,
+	// at most Total. It includes the messages of the OT extension.
+	Sent int `json:"sent"`
+	// Done is set once the OT implementation finished sending the payload
+	Done bool `json:"done"`
+}
+
+// ProgressFunc is called after every write of at most 64 KiB to the client's
+// connection and once more when the transfer is done
+type ProgressFunc func(Progress)
+
+// progressReporter is embedded into Manager
+type progressReporter struct {
+	mutex    sync.Mutex
+	callback ProgressFunc
+}
+
+// SetProgressCallback sets the function which receives progress of OT
+// responses. Pass nil to stop receiving progress.
+func (p *progressReporter) SetProgressCallback(callback ProgressFunc) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.callback = callback
+}
+
+func (p *progressReporter) report(progress Progress) {
+	p.mutex.Lock()
+	callback := p.callback
+	p.mutex.Unlock()
+	if callback != nil {
+		callback(progress)
+	}
+}
@@ -0,0 +1,107 @@
+package ote
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"notary/kos"
+	"sync"
+	"testing"
+	"time"
+)
+
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// TestResponseProgress checks that the progress of an OT response follows
+// the writes to the client's connection
+func TestResponseProgress(t *testing.T) {
+	port := freePort(t)
+	m, err := NewManager(port, IMPLEMENTATION_GO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Finish()
+	m.SetAddresses("127.0.0.1", "", 0)
+	var mutex sync.Mutex
+	var reports []Progress
+	m.SetProgressCallback(func(p Progress) {
+		mutex.Lock()
+		reports = append(reports, p)
+		mutex.Unlock()
+	})
+
+	// 3 writes of streamChunkSize and more
+	const count = 3*streamChunkSize/32 + 1
+	pairs := make([]byte, count*32)
+	for i := range pairs {
+		pairs[i] = byte(i * 13)
+	}
+	choices := make([]int, count)
+	for i := range choices {
+		choices[i] = i % 3 % 2
+	}
+	received := make(chan []byte, 1)
+	release := make(chan struct{})
+	go func() {
+		var conn net.Conn
+		for conn == nil {
+			conn, _ = net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+			time.Sleep(10 * time.Millisecond)
+		}
+		defer conn.Close()
+		receiver, err := kos.NewReceiver(conn)
+		if err != nil {
+			received <- nil
+			return
+		}
+		<-release
+		result, _ := receiver.Receive(choices)
+		received <- result
+	}()
+	if err = m.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	responded := make(chan error, 1)
+	go func() {
+		responded <- m.RespondWithData(pairs)
+	}()
+	// nothing can be sent before the client's request
+	time.Sleep(100 * time.Millisecond)
+	mutex.Lock()
+	early := len(reports)
+	mutex.Unlock()
+	if early != 0 {
+		t.Fatal("progress was reported before the client sent its request")
+	}
+	close(release)
+	if err = <-responded; err != nil {
+		t.Fatal(err)
+	}
+	result := <-received
+	for i, choice := range choices {
+		if !bytes.Equal(result[i*16:i*16+16], pairs[i*32+choice*16:i*32+choice*16+16]) {
+			t.Fatal("unexpected message", i)
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(reports) < 5 {
+		t.Fatal("expected a report per write", reports)
+	}
+	for i, p := range reports[:len(reports)-1] {
+		if p.Done || p.Total != len(pairs) || p.Sent <= 0 || (i > 0 && p.Sent < reports[i-1].Sent) {
+			t.Fatal("unexpected progress", reports)
+		}
+	}
+	if last := reports[len(reports)-1]; !last.Done || last.Sent != len(pairs) {
+		t.Fatal("unexpected final progress", last)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build cgo
+
+#include "vector.h"
+
+#include <vector>
+
+// ote_vector_append appends size bytes at data to the vector in one copy
+void ote_vector_append(uintptr_t vector, const uint8_t *data, size_t size) {
+    auto *v = reinterpret_cast<std::vector<uint8_t> *>(vector);
+    v->insert(v->end(), data, data + size);
+}
+
+// ote_vector_data returns the contents of the vector, which stay valid until
+// it is changed or deleted
+const uint8_t *ote_vector_data(uintptr_t vector) {
+    return reinterpret_cast<std::vector<uint8_t> *>(vector)->data();
+}
@@ -0,0 +1,31 @@
+//go:build cgo
+
+package ote
+
+// #include "vector.h"
+import "C"
+
+import (
+	"unsafe"
+
+	ot "github.com/summitto/ot-wrapper/pkg"
+)
+
+// appendToVector appends p to v in one copy. v.Add would cross cgo once per
+// byte, which dominates the OT of hundreds of MB of labels.
+func appendToVector(v ot.UInt8Vector, p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	C.ote_vector_append(C.uintptr_t(v.Swigcptr()), (*C.uint8_t)(unsafe.Pointer(&p[0])), C.size_t(len(p)))
+}
+
+// vectorBytes returns the contents of v without copying them. The slice is
+// only valid until v is changed or deleted.
+func vectorBytes(v ot.UInt8Vector) []byte {
+	size := int(v.Size())
+	if size == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(C.ote_vector_data(C.uintptr_t(v.Swigcptr())))), size)
+}
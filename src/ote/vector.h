@@ -0,0 +1,20 @@
+#ifndef OTE_VECTOR_H
+#define OTE_VECTOR_H
+
+#include <stddef.h>
+#include <stdint.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+// vector is the address of a std::vector<uint8_t>, e.g. the Swigcptr of an
+// ot.UInt8Vector
+void ote_vector_append(uintptr_t vector, const uint8_t *data, size_t size);
+const uint8_t *ote_vector_data(uintptr_t vector);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif
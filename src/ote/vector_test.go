@@ -0,0 +1,104 @@
+//go:build cgo
+
+package ote
+
+import (
+	"bytes"
+	"testing"
+
+	ot "github.com/summitto/ot-wrapper/pkg"
+)
+
+// benchmarkSize is the size of the labels of a large c6 OT
+const benchmarkSize = 16 << 20
+
+func TestVectorBulkCopy(t *testing.T) {
+	data := make([]byte, 3*streamChunkSize+5)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	v := ot.NewUInt8Vector()
+	defer ot.DeleteUInt8Vector(v)
+	appendToVector(v, nil)
+	if vectorBytes(v) != nil {
+		t.Fatal("an empty vector must have no bytes")
+	}
+	appendToVector(v, data[:streamChunkSize])
+	appendToVector(v, data[streamChunkSize:])
+	if int(v.Size()) != len(data) || v.Get(streamChunkSize) != data[streamChunkSize] {
+		t.Fatal("unexpected vector", v.Size())
+	}
+	if !bytes.Equal(vectorBytes(v), data) {
+		t.Fatal("the vector's bytes differ")
+	}
+
+	choices := []int{1, 0, 0, 1, 0, 0, 0, 0, 1, 1}
+	packed, cleanup := arrayBitsToLittleEndianBytes(choices)
+	defer cleanup()
+	if !bytes.Equal(vectorBytes(packed), []byte{0x09, 0x03}) {
+		t.Fatal("unexpected packed choices", vectorBytes(packed))
+	}
+}
+
+// BenchmarkVectorAdd is how respondWithData copied the data before
+// appendToVector: one cgo call per byte
+func BenchmarkVectorAdd(b *testing.B) {
+	data := make([]byte, benchmarkSize)
+	b.SetBytes(benchmarkSize)
+	for i := 0; i < b.N; i++ {
+		v := ot.NewUInt8Vector()
+		v.Reserve(benchmarkSize)
+		for _, val := range data {
+			v.Add(val)
+		}
+		ot.DeleteUInt8Vector(v)
+	}
+}
+
+func BenchmarkAppendToVector(b *testing.B) {
+	data := make([]byte, benchmarkSize)
+	b.SetBytes(benchmarkSize)
+	for i := 0; i < b.N; i++ {
+		v := ot.NewUInt8Vector()
+		v.Reserve(benchmarkSize)
+		for done := 0; done < benchmarkSize; done += streamChunkSize {
+			appendToVector(v, data[done:done+streamChunkSize])
+		}
+		ot.DeleteUInt8Vector(v)
+	}
+}
+
+// BenchmarkVectorGet is how requestData copied the result before
+// vectorBytes: one cgo call per byte
+func BenchmarkVectorGet(b *testing.B) {
+	v := ot.NewUInt8Vector()
+	defer ot.DeleteUInt8Vector(v)
+	appendToVector(v, make([]byte, benchmarkSize))
+	var out bytes.Buffer
+	b.SetBytes(benchmarkSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		out.Reset()
+		out.Grow(benchmarkSize)
+		for j := 0; j < int(v.Size()); j++ {
+			out.WriteByte(v.Get(j))
+		}
+	}
+}
+
+func BenchmarkVectorBytes(b *testing.B) {
+	v := ot.NewUInt8Vector()
+	defer ot.DeleteUInt8Vector(v)
+	appendToVector(v, make([]byte, benchmarkSize))
+	var out bytes.Buffer
+	b.SetBytes(benchmarkSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		out.Reset()
+		out.Grow(benchmarkSize)
+		result := vectorBytes(v)
+		for done := 0; done < len(result); done += streamChunkSize {
+			out.Write(result[done:min(done+streamChunkSize, len(result))])
+		}
+	}
+}
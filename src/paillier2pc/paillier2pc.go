@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"log"
 	"math/big"
 	u "notary/utils"
@@ -61,17 +62,33 @@ func (p *Paillier2PC) Init() {
 	}
 }
 
-func (p *Paillier2PC) Step1(payload []byte) ([]byte, []byte) {
+// Step1 returns the server pubkey and the response to the client. The
+// errors of Step1 thru Step4 are caused by malformed payloads.
+func (p *Paillier2PC) Step1(payload []byte) ([]byte, []byte, error) {
 	type Step1 struct {
 		Q_bx string
 		Q_by string
 	}
 	var step1 Step1
-	json.Unmarshal([]byte(string(payload)), &step1)
+	err := json.Unmarshal([]byte(string(payload)), &step1)
+	if err != nil {
+		return nil, nil, errors.New("step1: invalid json: " + err.Error())
+	}
 
 	// C passes Q_b to N
-	serverX := h2bi(step1.Q_bx)
-	serverY := h2bi(step1.Q_by)
+	serverX, err := h2bi(step1.Q_bx)
+	if err != nil {
+		return nil, nil, err
+	}
+	serverY, err := h2bi(step1.Q_by)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the server pubkey must be a valid point before we multiply our secret
+	// d_n by it
+	if err := u.ValidateP256Point(serverX, serverY); err != nil {
+		return nil, nil, errors.New("step1: invalid server pubkey: " + err.Error())
+	}
 	serverPubkey := u.Concat([]byte{0x04}, u.To32Bytes(serverX), u.To32Bytes(serverY))
 	// N computes an EC point (x_q, y_q) = d_n * Q_b
 	x_q, y_q := p.p256.ScalarMult(serverX, serverY, p.d_n.Bytes())
@@ -97,31 +114,44 @@ func (p *Paillier2PC) Step1(payload []byte) ([]byte, []byte) {
 				 "Q_nx":"` + hex.EncodeToString(p.Q_nx.Bytes()) + `",
 				 "Q_ny":"` + hex.EncodeToString(p.Q_ny.Bytes()) + `"}`
 
-	return serverPubkey, []byte(json)
+	return serverPubkey, []byte(json), nil
 }
 
-func (p *Paillier2PC) Step2(payload []byte) []byte {
+func (p *Paillier2PC) Step2(payload []byte) ([]byte, error) {
 	type Step2 struct {
 		E125    string
 		N_bmodp string
 	}
 	var step2 Step2
-	json.Unmarshal([]byte(string(payload)), &step2)
+	if err := json.Unmarshal(payload, &step2); err != nil {
+		return nil, errors.New("step2: invalid json: " + err.Error())
+	}
+	E125, err := h2bi(step2.E125)
+	if err != nil {
+		return nil, err
+	}
+	N_bmodp, err := h2bi(step2.N_bmodp)
+	if err != nil {
+		return nil, err
+	}
 	// 1.2.7
 	// E125 is the value which C computes in step 1.2.5
-	D125_bytes := p.decrypt(h2bi(step2.E125).Bytes())
+	D125_bytes, err := p.decrypt(E125.Bytes())
+	if err != nil {
+		return nil, err
+	}
 	D125 := new(big.Int).SetBytes(D125_bytes)
 	// 1.2.9
-	bM_b := mod(sub(D125, h2bi(step2.N_bmodp)), p.P)
+	bM_b := mod(sub(D125, N_bmodp), p.P)
 	// 1.2.10
 	powMinus3 := sub(p.P, p.Three)
 	bM_b_raised := exp(bM_b, powMinus3, p.P)
 	E1210 := p.encrypt(bM_b_raised.Bytes())
 	json := `{"E1210":"` + hex.EncodeToString(E1210) + `"}`
-	return []byte(json)
+	return []byte(json), nil
 }
 
-func (p *Paillier2PC) Step3(payload []byte) []byte {
+func (p *Paillier2PC) Step3(payload []byte) ([]byte, error) {
 	type Step3 struct {
 		E1213   string
 		N_Bmodp string
@@ -129,35 +159,69 @@ func (p *Paillier2PC) Step3(payload []byte) []byte {
 		N_Amodp string
 	}
 	var step3 Step3
-	json.Unmarshal([]byte(string(payload)), &step3)
+	if err := json.Unmarshal(payload, &step3); err != nil {
+		return nil, errors.New("step3: invalid json: " + err.Error())
+	}
 	// E114 is the value which C computed in Step 1.1.4
-	E114, _ := new(big.Int).SetString(step3.E114, 16)
-	N_Amodp, _ := new(big.Int).SetString(step3.N_Amodp, 16)
+	E114, err := h2bi(step3.E114)
+	if err != nil {
+		return nil, err
+	}
+	N_Amodp, err := h2bi(step3.N_Amodp)
+	if err != nil {
+		return nil, err
+	}
+	E1213, err := h2bi(step3.E1213)
+	if err != nil {
+		return nil, err
+	}
+	N_Bmodp, err := h2bi(step3.N_Bmodp)
+	if err != nil {
+		return nil, err
+	}
 	// 1.2.15
 	// E1213 is the value which C computed in Step 1.2.13
-	D1213 := new(big.Int).SetBytes(p.decrypt(h2bi(step3.E1213).Bytes()))
+	D1213_bytes, err := p.decrypt(E1213.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	D1213 := new(big.Int).SetBytes(D1213_bytes)
 	// 1.2.17
-	BM_B := mod(sub(D1213, h2bi(step3.N_Bmodp)), p.P)
+	BM_B := mod(sub(D1213, N_Bmodp), p.P)
 	// 1.1.6
-	D114 := new(big.Int).SetBytes(p.decrypt(E114.Bytes()))
+	D114_bytes, err := p.decrypt(E114.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	D114 := new(big.Int).SetBytes(D114_bytes)
 	// 1.1.8
 	AM_A := mod(sub(D114, N_Amodp), p.P)
 	// 1.3.1 (Nota that E(-x_q) has already been sent)
 	E131 := p.encrypt(mod(mul(BM_B, AM_A), p.P).Bytes())
 	json := `{"E131":"` + hex.EncodeToString(E131) + `"}`
-	return []byte(json)
+	return []byte(json), nil
 }
 
 // final step
-func (p *Paillier2PC) Step4(payload []byte) []byte {
+func (p *Paillier2PC) Step4(payload []byte) ([]byte, error) {
 	type Step4 struct {
 		E135 string
 	}
 	var step4 Step4
-	json.Unmarshal([]byte(string(payload)), &step4)
-	D135 := new(big.Int).SetBytes(p.decrypt(h2bi(step4.E135).Bytes()))
+	if err := json.Unmarshal(payload, &step4); err != nil {
+		return nil, errors.New("step4: invalid json: " + err.Error())
+	}
+	E135, err := h2bi(step4.E135)
+	if err != nil {
+		return nil, err
+	}
+	D135_bytes, err := p.decrypt(E135.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	D135 := new(big.Int).SetBytes(D135_bytes)
 	notaryPMSShare := u.To32Bytes(mod(D135, p.P))
-	return notaryPMSShare
+	return notaryPMSShare, nil
 }
 
 func (p *Paillier2PC) encrypt(payload []byte) []byte {
@@ -168,12 +232,12 @@ func (p *Paillier2PC) encrypt(payload []byte) []byte {
 	return res
 }
 
-func (p *Paillier2PC) decrypt(payload []byte) []byte {
+func (p *Paillier2PC) decrypt(payload []byte) ([]byte, error) {
 	res, err := paillier.Decrypt(p.paillierPrivKey, payload)
 	if err != nil {
-		panic(err)
+		return nil, errors.New("invalid paillier ciphertext: " + err.Error())
 	}
-	return res
+	return res, nil
 }
 
 // wrappers for big.Int methods which are less clunky than the stock ones
@@ -208,10 +272,10 @@ func exp(a, b, c *big.Int) *big.Int {
 }
 
 // h2bi converts a hex string into big.Int
-func h2bi(a string) *big.Int {
+func h2bi(a string) (*big.Int, error) {
 	res, ok := new(big.Int).SetString(a, 16)
 	if !ok {
-		panic("in h2bi")
+		return nil, errors.New("invalid hex number")
 	}
-	return res
+	return res, nil
 }
@@ -0,0 +1,206 @@
+// Package policy builds the notary's policy document: a machine-readable
+// description of its limits, data retention, logging and features, and of
+// the terms declared by the operator (e.g. fees). Clients fetch it from
+// /policy to decide whether the notary meets their requirements before
+// starting a session.
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"notary/api_error"
+	"notary/utils"
+	"os"
+	"time"
+)
+
+// POLICY_VERSION is the version of the format of the policy document. It is
+// increased when fields are removed or change their meaning.
+const POLICY_VERSION = 1
+
+// Limits are the limits a session must stay within
+type Limits struct {
+	// MaxBlobSize is the max size in bytes of the garbled circuits uploaded
+	// with setBlob, 0 if there is no limit
+	MaxBlobSize int64 `json:"maxBlobSize"`
+	// SessionIdleTimeout is the time in seconds after which a session
+	// without a message from the client is removed
+	SessionIdleTimeout int64 `json:"sessionIdleTimeout"`
+	// SessionMaxDuration is the time in seconds after which a session is
+	// removed
+	SessionMaxDuration int64 `json:"sessionMaxDuration"`
+	// StepRateLimit is the max amount of protocol steps per minute from one
+	// IP address, 0 if there is no limit
+	StepRateLimit int `json:"stepRateLimit"`
+	// ZkeySizes are the AES block counts for which numeric claims can be
+	// proven, as in /zkey_sizes
+	ZkeySizes []int `json:"zkeySizes"`
+	// PhaseSLAs are the max durations in seconds of the phases of a session
+	// by phase name, e.g. "handshake". Only enforced SLAs are listed.
+	PhaseSLAs map[string]int64 `json:"phaseSLAs,omitempty"`
+}
+
+// Retention says how long the notary keeps data about clients
+type Retention struct {
+	// SessionData is the max time in seconds the secrets and files of a
+	// session are kept. They are deleted when the session is removed.
+	SessionData int64 `json:"sessionData"`
+	// Ban is the time in seconds a client caught cheating stays banned. The
+	// ban list stores the client's IP address and API key.
+	Ban int64 `json:"ban"`
+	// AuditLogDays is how many days the audit log is kept, as declared by
+	// the operator. 0 if not declared.
+	AuditLogDays int `json:"auditLogDays"`
+}
+
+// Logging says what the notary records
+type Logging struct {
+	// AuditEvents are the events recorded in the audit log together with
+	// the session id, IP address or API key of the client
+	AuditEvents []string `json:"auditEvents"`
+	// ClientAddresses is true because the regular log has the IP addresses
+	// of the requests
+	ClientAddresses bool `json:"clientAddresses"`
+}
+
+// Features are the optional parts of the protocol the notary supports
+type Features struct {
+	// ProtocolVersions are the lowest and the highest protocol version
+	// accepted in init
+	ProtocolVersions [2]int `json:"protocolVersions"`
+	// GarblingScheme is "grr3" or "half-gates"
+	GarblingScheme string `json:"garblingScheme"`
+	// SignatureScheme is "randomized", "rfc6979" or "none"
+	SignatureScheme    string `json:"signatureScheme"`
+	AttestationMetrics bool   `json:"attestationMetrics"`
+	// Transports are the ways to send protocol steps, e.g. "http" or "grpc"
+	Transports []string `json:"transports"`
+	TLS        bool     `json:"tls"`
+	OTPool     bool     `json:"otPool"`
+	OTBroker   bool     `json:"otBroker"`
+	// OTTunnel is set when clients can tunnel OT over a WebSocket at
+	// /otTunnel
+	OTTunnel bool `json:"otTunnel"`
+	// OTImplementation is the OT implementation which clients must use,
+	// "native" or "go"
+	OTImplementation string `json:"otImplementation"`
+	Sandboxed        bool   `json:"sandboxed"`
+	// TransparencyLog is set when the signatures are logged, see /log/treeHead
+	TransparencyLog bool `json:"transparencyLog"`
+	// VerifierOnly is set when the notary doesn't sign the results of the
+	// sessions, see -verifier-only. SignatureScheme is "none" then.
+	VerifierOnly bool `json:"verifierOnly"`
+}
+
+// Fee is one entry of the fee schedule
+type Fee struct {
+	// Service is what the fee is charged for, e.g. "session" or
+	// "numeric_claim"
+	Service string `json:"service"`
+	// Amount is a decimal string, so that no precision is lost
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// Operator are the terms declared by the operator in the file given with
+// -policy-file. The notary can't enforce them.
+type Operator struct {
+	Name    string `json:"name,omitempty"`
+	Contact string `json:"contact,omitempty"`
+	// TermsURL links to the human-readable terms of service
+	TermsURL     string `json:"termsUrl,omitempty"`
+	AuditLogDays int    `json:"auditLogDays,omitempty"`
+	// Fees is the fee schedule, empty if the notary is free to use
+	Fees []Fee `json:"fees"`
+}
+
+// Policy is the policy document
+type Policy struct {
+	Version   int       `json:"version"`
+	Limits    Limits    `json:"limits"`
+	Retention Retention `json:"retention"`
+	Logging   Logging   `json:"logging"`
+	Features  Features  `json:"features"`
+	Operator  Operator  `json:"operator"`
+	// Time is the unix time at which the document was created
+	Time int64 `json:"time"`
+}
+
+// LoadOperator reads the operator's terms from a JSON file. Unknown fields
+// are errors, so that typos don't silently drop terms.
+func LoadOperator(path string) (Operator, error) {
+	var op Operator
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return op, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&op); err != nil {
+		return op, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, fee := range op.Fees {
+		if fee.Service == "" || fee.Currency == "" {
+			return op, fmt.Errorf("%s: fee %d needs a service and a currency", path, i)
+		}
+		if _, ok := new(big.Rat).SetString(fee.Amount); !ok {
+			return op, fmt.Errorf("%s: fee %d: invalid amount %q", path, i, fee.Amount)
+		}
+	}
+	if op.AuditLogDays < 0 {
+		return op, fmt.Errorf("%s: auditLogDays must not be negative", path)
+	}
+	return op, nil
+}
+
+// Handler serves the policy document. The document doesn't change while the
+// notary runs, so it is encoded and signed once.
+type Handler struct {
+	body      []byte
+	signature []byte
+	etag      string
+}
+
+// NewHandler creates the handler of p. sign signs the document, e.g. with
+// the master key.
+func NewHandler(p Policy, sign func([]byte) []byte) (*Handler, error) {
+	p.Version = POLICY_VERSION
+	p.Retention.AuditLogDays = p.Operator.AuditLogDays
+	if p.Time == 0 {
+		p.Time = time.Now().Unix()
+	}
+	if p.Operator.Fees == nil {
+		p.Operator.Fees = []Fee{}
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{body: body, signature: sign(body), etag: utils.ETag(body)}, nil
+}
+
+// Body returns the document in JSON format
+func (h *Handler) Body() []byte {
+	return h.body
+}
+
+// Signature returns the signature over the document
+func (h *Handler) Signature() []byte {
+	return h.signature
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		api_error.Write(w, http.StatusMethodNotAllowed, api_error.METHOD_NOT_ALLOWED, "")
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	if utils.CheckNotModified(w, req, h.etag, "public, max-age=300") {
+		return
+	}
+	w.Write(h.body)
+}
@@ -0,0 +1,78 @@
+package policy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadOperator(t *testing.T) {
+	op, err := LoadOperator(writeFile(t, `{"name": "Example", "auditLogDays": 30,
+		"fees": [{"service": "session", "amount": "0.50", "currency": "USD"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.Name != "Example" || op.AuditLogDays != 30 || len(op.Fees) != 1 || op.Fees[0].Amount != "0.50" {
+		t.Fatal("unexpected operator", op)
+	}
+	for content, want := range map[string]string{
+		`{"fee": []}`: "unknown field",
+		`{"fees": [{"service": "session", "amount": "cheap", "currency": "USD"}]}`: "invalid amount",
+		`{"fees": [{"amount": "1", "currency": "USD"}]}`:                           "needs a service",
+		`{"auditLogDays": -1}`: "must not be negative",
+	} {
+		_, err := LoadOperator(writeFile(t, content))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("%s: expected error with %q, got %v", content, want, err)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var signed []byte
+	h, err := NewHandler(Policy{
+		Limits:   Limits{MaxBlobSize: 1 << 30},
+		Operator: Operator{AuditLogDays: 7},
+	}, func(body []byte) []byte {
+		signed = body
+		return []byte{1, 2, 3}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/policy", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != string(signed) {
+		t.Fatal("the served document must be the signed one")
+	}
+	var p Policy
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Version != POLICY_VERSION || p.Limits.MaxBlobSize != 1<<30 || p.Retention.AuditLogDays != 7 || p.Time == 0 {
+		t.Fatal("unexpected policy", p)
+	}
+	if !strings.Contains(rec.Body.String(), `"fees":[]`) {
+		t.Fatal("an empty fee schedule must be explicit")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/policy", nil)
+	req.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Fatal("expected 304, got", rec.Code)
+	}
+}
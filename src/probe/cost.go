@@ -0,0 +1,106 @@
+package probe
+
+import (
+	"math"
+	"net/http"
+	"notary/aes_tag"
+	"notary/api_error"
+	"strconv"
+	"time"
+)
+
+// CostModel tells the cost estimate what a session takes on this notary
+type CostModel struct {
+	// OtBytes returns the bytes of the input labels which a session with
+	// c6Count executions of circuit 6 transfers with OT, both ways
+	OtBytes func(c6Count int) int64
+	// EvaluationRate returns the bytes of the client's truth tables which
+	// the notary evaluates per second, or 0 if it didn't measure it yet
+	EvaluationRate func() float64
+}
+
+// Cost is what a notarization of a request and a response of the given
+// sizes takes on this notary
+type Cost struct {
+	// C6Count and RecordCount are the values of init for the request, see
+	// Plan
+	C6Count     int `json:"c6Count"`
+	RecordCount int `json:"recordCount"`
+	// DownloadBytes and UploadBytes are the truth tables downloaded with
+	// getBlob and uploaded with setBlob
+	DownloadBytes int64 `json:"downloadBytes"`
+	UploadBytes   int64 `json:"uploadBytes"`
+	OtBytes       int64 `json:"otBytes"`
+	// ResponseRecords is the amount of TLS records of MAX_RECORD_SIZE which
+	// the response takes at least
+	ResponseRecords int `json:"responseRecords"`
+	// TagVerification is whether the tags of all records of the response
+	// can be verified in one session
+	TagVerification bool `json:"tagVerification"`
+	// EvaluationSeconds is how long the notary takes to evaluate the
+	// client's circuits at the rate measured on this notary, 0 until it
+	// evaluated the first circuit
+	EvaluationSeconds float64 `json:"evaluationSeconds"`
+	// NetworkSeconds is the network time of Estimate, if the client sent
+	// its measurements
+	NetworkSeconds float64 `json:"networkSeconds,omitempty"`
+	// Seconds is the sum of EvaluationSeconds and NetworkSeconds. It
+	// doesn't count the client's computation.
+	Seconds float64 `json:"seconds"`
+	// MaxSeconds is the max duration of a session
+	MaxSeconds float64 `json:"maxSeconds"`
+}
+
+// Cost estimates the cost of a session for a request of requestBytes (at
+// least 1) and a response of responseBytes
+func (h *Handler) Cost(requestBytes int, responseBytes int) Cost {
+	plan := PlanTransfer(requestBytes, 0)
+	c := Cost{
+		C6Count:         plan.C6Count,
+		RecordCount:     plan.RecordCount,
+		OtBytes:         h.costs.OtBytes(plan.C6Count),
+		ResponseRecords: (responseBytes + MAX_RECORD_SIZE - 1) / MAX_RECORD_SIZE,
+		MaxSeconds:      h.limits.MaxDuration.Seconds(),
+	}
+	c.DownloadBytes, c.UploadBytes = h.blobSizes(plan.C6Count)
+	c.TagVerification = c.ResponseRecords <= aes_tag.MAX_TAG_RECORDS
+	if rate := h.costs.EvaluationRate(); rate > 0 {
+		c.EvaluationSeconds = float64(c.UploadBytes) / rate
+	}
+	c.Seconds = c.EvaluationSeconds
+	return c
+}
+
+// ServeCost serves Cost for the query params requestBytes and
+// responseBytes. With the measurements of /probe/estimate (downloadBps,
+// uploadBps and rttMs) the network time is added.
+func (h *Handler) ServeCost(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if req.Method != http.MethodGet {
+		api_error.Write(w, http.StatusMethodNotAllowed, api_error.METHOD_NOT_ALLOWED, "")
+		return
+	}
+	query := req.URL.Query()
+	requestBytes, err1 := strconv.Atoi(query.Get("requestBytes"))
+	responseBytes, err2 := strconv.Atoi(query.Get("responseBytes"))
+	if err1 != nil || err2 != nil || requestBytes < 1 || requestBytes > h.maxC6Count*16 || responseBytes < 0 {
+		api_error.Write(w, http.StatusBadRequest, api_error.INVALID_REQUEST,
+			"requestBytes must be 1 to "+strconv.Itoa(h.maxC6Count*16)+" and responseBytes at least 0")
+		return
+	}
+	c := h.Cost(requestBytes, responseBytes)
+	if query.Has("downloadBps") {
+		downloadBps, err1 := strconv.ParseFloat(query.Get("downloadBps"), 64)
+		uploadBps, err2 := strconv.ParseFloat(query.Get("uploadBps"), 64)
+		rttMs, err3 := strconv.ParseFloat(query.Get("rttMs"), 64)
+		if err1 != nil || err2 != nil || err3 != nil || !(downloadBps > 0) || !(uploadBps > 0) ||
+			rttMs < 0 || math.IsInf(downloadBps+uploadBps+rttMs, 0) {
+			api_error.Write(w, http.StatusBadRequest, api_error.INVALID_REQUEST, "")
+			return
+		}
+		rtt := time.Duration(rttMs * float64(time.Millisecond))
+		c.NetworkSeconds = h.Estimate(c.C6Count, downloadBps, uploadBps, rtt).Seconds
+		c.Seconds += c.NetworkSeconds
+	}
+	writeJSON(w, c)
+}
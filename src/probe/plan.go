@@ -0,0 +1,72 @@
+package probe
+
+import (
+	"math/bits"
+	"notary/ghash"
+	"notary/utils"
+)
+
+// FRAME_INTERVAL_SECONDS is how often a frame of an encrypted response should
+// arrive at the client's download bandwidth
+const FRAME_INTERVAL_SECONDS = 0.1
+
+// MAX_RECORD_SIZE is the max plaintext size of a TLS record
+const MAX_RECORD_SIZE = 16384
+
+// Plan is the notary's recommendation how a client transfers the data of a
+// session. The notary enforces it: init rejects frame sizes outside of
+// [utils.AEAD_FRAME_MIN_SIZE, utils.AEAD_FRAME_MAX_SIZE] and the session
+// rejects ghash_step2 unless the request size needs it.
+type Plan struct {
+	// C6Count is the c6Count of init: one execution of circuit 6 per AES
+	// block of the request
+	C6Count int `json:"c6Count"`
+	// RecordCount is the record count of init with PROTOCOL_REQUEST_RECORDS:
+	// the request is sent in records of MAX_RECORD_SIZE and a last shorter
+	// one
+	RecordCount int `json:"recordCount"`
+	// FrameSize is the plaintext size of the frames of the encrypted
+	// responses, e.g. of the c6 labels in the response to c6_step1. Smaller
+	// frames let slow clients decrypt the labels as they arrive, larger
+	// ones cost fast clients fewer tags.
+	FrameSize int `json:"frameSize"`
+	// FrameSizeLog2 is the log2 of FrameSize which the client appends to
+	// init with PROTOCOL_TRANSFER_PLAN
+	FrameSizeLog2 int `json:"frameSizeLog2"`
+	// Compression is whether to compress the blobs. Truth tables and labels
+	// are indistinguishable from random, so compressing them only costs CPU
+	// time and it is never recommended. setBlob still accepts gzip and zstd.
+	Compression bool `json:"compression"`
+	// GhashStep2 is whether the MACs of the records of the request need the
+	// extra round of ghash_step2
+	GhashStep2 bool `json:"ghashStep2"`
+}
+
+// PlanTransfer plans a session for a request of requestBytes (at least 1)
+// and a connection with the given download bandwidth in bytes per second
+func PlanTransfer(requestBytes int, downloadBps float64) Plan {
+	// all records but the last one have whole AES blocks
+	c6Count := (requestBytes + 15) / 16
+	recordCount := (requestBytes + MAX_RECORD_SIZE - 1) / MAX_RECORD_SIZE
+	largestRecord := requestBytes
+	if largestRecord > MAX_RECORD_SIZE {
+		largestRecord = MAX_RECORD_SIZE
+	}
+	// the largest power of two which arrives within FRAME_INTERVAL_SECONDS
+	frameSize := utils.AEAD_FRAME_MIN_SIZE
+	if target := downloadBps * FRAME_INTERVAL_SECONDS; target >= utils.AEAD_FRAME_MAX_SIZE {
+		frameSize = utils.AEAD_FRAME_MAX_SIZE
+	} else if target > utils.AEAD_FRAME_MIN_SIZE {
+		frameSize = 1 << (bits.Len(uint(target)) - 1)
+	}
+	return Plan{
+		C6Count:       c6Count,
+		RecordCount:   recordCount,
+		FrameSize:     frameSize,
+		FrameSizeLog2: bits.Len(uint(frameSize)) - 1,
+		Compression:   false,
+		// the GHASH input of a record is the AAD block, the record and the
+		// lengths block
+		GhashStep2: ghash.NeedsStep2((largestRecord+15)/16 + 2),
+	}
+}
@@ -0,0 +1,235 @@
+// Package probe lets clients measure their connection to the notary before
+// init and estimate whether a notarization of the intended size completes
+// within the session limits.
+//
+// GET /probe?bytes=N downloads N bytes, POST /probe uploads a body and
+// returns how long the notary took to receive it, and GET /probe/estimate
+// turns the client's measurements into the notary's recommendation. GET
+// /probe/cost tells what a notarization of a request and a response takes.
+package probe
+
+import (
+	"encoding/json"
+	"expvar"
+	"io"
+	"math"
+	"net/http"
+	"notary/api_error"
+	"notary/utils"
+	"strconv"
+	"time"
+)
+
+const (
+	// MAX_PROBE_BYTES is the max size of a probe download or upload
+	MAX_PROBE_BYTES = 8 << 20
+	// MAX_CONCURRENT_PROBES limits the bandwidth which probes can take
+	// away from sessions
+	MAX_CONCURRENT_PROBES = 8
+	// SAFETY_FACTOR is how much of the time limit the estimate may use, to
+	// leave room for the computation and for a slower connection
+	SAFETY_FACTOR = 0.5
+	// patternSize is the size of the random pattern of the download. It is
+	// larger than the window of common compressions, so that compressing
+	// proxies don't distort the measurement.
+	patternSize = 64 << 10
+)
+
+var probesRejected = expvar.NewInt("probes_rejected")
+
+// Limits are the session limits the estimate is checked against
+type Limits struct {
+	// MaxDuration is the max duration of a session
+	MaxDuration time.Duration
+	// HandshakeSLA is the enforced limit of the handshake phase, during
+	// which the blobs are transferred, or 0
+	HandshakeSLA time.Duration
+}
+
+// Handler serves the probe endpoints
+type Handler struct {
+	limits Limits
+	// blobSizes returns the bytes downloaded with getBlob and uploaded with
+	// setBlob by a session with c6Count executions of circuit 6
+	blobSizes func(c6Count int) (download int64, upload int64)
+	// maxC6Count is the max c6Count of a session
+	maxC6Count int
+	// roundTrips is the amount of sequential requests of a session
+	roundTrips int
+	costs      CostModel
+	pattern    []byte
+	slots      chan struct{}
+}
+
+func NewHandler(limits Limits, blobSizes func(int) (int64, int64), maxC6Count int, roundTrips int, costs CostModel) *Handler {
+	return &Handler{
+		limits:     limits,
+		blobSizes:  blobSizes,
+		maxC6Count: maxC6Count,
+		roundTrips: roundTrips,
+		costs:      costs,
+		pattern:    utils.GetRandom(patternSize),
+		slots:      make(chan struct{}, MAX_CONCURRENT_PROBES),
+	}
+}
+
+// ServeHTTP serves the probe downloads and uploads
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "no-store")
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		api_error.Write(w, http.StatusMethodNotAllowed, api_error.METHOD_NOT_ALLOWED, "")
+		return
+	}
+	select {
+	case h.slots <- struct{}{}:
+		defer func() { <-h.slots }()
+	default:
+		probesRejected.Add(1)
+		api_error.Write(w, http.StatusServiceUnavailable, api_error.UNAVAILABLE, "")
+		return
+	}
+	if req.Method == http.MethodGet {
+		h.download(w, req)
+	} else {
+		h.upload(w, req)
+	}
+}
+
+// download writes the amount of bytes in the bytes query param
+func (h *Handler) download(w http.ResponseWriter, req *http.Request) {
+	size, err := strconv.Atoi(req.URL.Query().Get("bytes"))
+	if err != nil || size < 0 || size > MAX_PROBE_BYTES {
+		api_error.Write(w, http.StatusBadRequest, api_error.INVALID_REQUEST, "bytes must be 0 to "+strconv.Itoa(MAX_PROBE_BYTES))
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(size))
+	for size > 0 {
+		n := min(size, len(h.pattern))
+		if _, err := w.Write(h.pattern[:n]); err != nil {
+			return
+		}
+		size -= n
+	}
+}
+
+// upload reads the body and returns its size and the time it took to
+// receive it, measured from the first byte
+func (h *Handler) upload(w http.ResponseWriter, req *http.Request) {
+	body := http.MaxBytesReader(w, req.Body, MAX_PROBE_BYTES)
+	first := make([]byte, 1)
+	n, err := io.ReadFull(body, first)
+	start := time.Now()
+	if err == nil {
+		var rest int64
+		rest, err = io.Copy(io.Discard, body)
+		n += int(rest)
+	}
+	if err != nil && err != io.EOF {
+		api_error.Write(w, http.StatusBadRequest, api_error.INVALID_REQUEST, err.Error())
+		return
+	}
+	writeJSON(w, struct {
+		Bytes   int     `json:"bytes"`
+		Seconds float64 `json:"seconds"`
+	}{n, time.Since(start).Seconds()})
+}
+
+// Estimate is the notary's recommendation for a connection
+type Estimate struct {
+	DownloadBytes int64 `json:"downloadBytes"`
+	UploadBytes   int64 `json:"uploadBytes"`
+	RoundTrips    int   `json:"roundTrips"`
+	// Seconds is the estimated network time of the session
+	Seconds float64 `json:"seconds"`
+	// LimitSeconds is the time the session may use, already reduced by
+	// SAFETY_FACTOR
+	LimitSeconds float64 `json:"limitSeconds"`
+	Feasible     bool    `json:"feasible"`
+	// MaxC6Count is the largest c6Count (see init) which is feasible, 0 if
+	// none is
+	MaxC6Count int `json:"maxC6Count"`
+	// Plan is the transfer plan, if the client sent its request size
+	Plan *Plan `json:"plan,omitempty"`
+}
+
+// Estimate estimates the network time of a session with c6Count executions
+// of circuit 6 for a connection with the given bandwidths (bytes per second)
+// and round trip time
+func (h *Handler) Estimate(c6Count int, downloadBps float64, uploadBps float64, rtt time.Duration) Estimate {
+	limit := h.limits.MaxDuration
+	if h.limits.HandshakeSLA > 0 && h.limits.HandshakeSLA < limit {
+		limit = h.limits.HandshakeSLA
+	}
+	seconds := func(c int) float64 {
+		download, upload := h.blobSizes(c)
+		return float64(download)/downloadBps + float64(upload)/uploadBps +
+			float64(h.roundTrips)*rtt.Seconds()
+	}
+	e := Estimate{
+		RoundTrips:   h.roundTrips,
+		Seconds:      seconds(c6Count),
+		LimitSeconds: limit.Seconds() * SAFETY_FACTOR,
+	}
+	e.DownloadBytes, e.UploadBytes = h.blobSizes(c6Count)
+	e.Feasible = e.Seconds <= e.LimitSeconds
+	// the time grows with c6Count, so search for the largest feasible one
+	low, high := 0, h.maxC6Count
+	for low < high {
+		mid := (low + high + 1) / 2
+		if seconds(mid) <= e.LimitSeconds {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+	if low > 0 {
+		e.MaxC6Count = low
+	}
+	return e
+}
+
+// ServeEstimate serves Estimate for the query params c6Count, downloadBps,
+// uploadBps and rttMs measured by the client. Instead of c6Count the client
+// may send requestBytes, the size of its request, to also get the Plan.
+func (h *Handler) ServeEstimate(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if req.Method != http.MethodGet {
+		api_error.Write(w, http.StatusMethodNotAllowed, api_error.METHOD_NOT_ALLOWED, "")
+		return
+	}
+	query := req.URL.Query()
+	c6Count, err1 := strconv.Atoi(query.Get("c6Count"))
+	requestBytes := 0
+	if query.Has("requestBytes") {
+		requestBytes, err1 = strconv.Atoi(query.Get("requestBytes"))
+		c6Count = (requestBytes + 15) / 16
+	}
+	downloadBps, err2 := strconv.ParseFloat(query.Get("downloadBps"), 64)
+	uploadBps, err3 := strconv.ParseFloat(query.Get("uploadBps"), 64)
+	rttMs, err4 := strconv.ParseFloat(query.Get("rttMs"), 64)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil ||
+		c6Count < 1 || c6Count > h.maxC6Count || !(downloadBps > 0) || !(uploadBps > 0) ||
+		rttMs < 0 || math.IsInf(downloadBps+uploadBps+rttMs, 0) {
+		api_error.Write(w, http.StatusBadRequest, api_error.INVALID_REQUEST, "")
+		return
+	}
+	rtt := time.Duration(rttMs * float64(time.Millisecond))
+	e := h.Estimate(c6Count, downloadBps, uploadBps, rtt)
+	if requestBytes > 0 {
+		plan := PlanTransfer(requestBytes, downloadBps)
+		e.Plan = &plan
+	}
+	writeJSON(w, e)
+}
+
+func writeJSON(w http.ResponseWriter, response interface{}) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		api_error.Write(w, http.StatusInternalServerError, api_error.INTERNAL, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
@@ -0,0 +1,154 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestHandler() *Handler {
+	// 1 MB per session plus 10 KB per c6 execution, both ways
+	blobSizes := func(c6Count int) (int64, int64) {
+		size := int64(1000000 + 10000*c6Count)
+		return size, size
+	}
+	// 1 KB of OT per c6 execution, truth tables evaluated at 1 MB/s
+	costs := CostModel{
+		OtBytes:        func(c6Count int) int64 { return int64(1000 * c6Count) },
+		EvaluationRate: func() float64 { return 1000000 },
+	}
+	return NewHandler(Limits{MaxDuration: 40 * time.Minute, HandshakeSLA: 100 * time.Second}, blobSizes, 1026, 36, costs)
+}
+
+func TestDownloadAndUpload(t *testing.T) {
+	h := newTestHandler()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/probe?bytes=100000", nil))
+	if rec.Code != http.StatusOK || rec.Body.Len() != 100000 {
+		t.Fatal("unexpected download", rec.Code, rec.Body.Len())
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/probe?bytes=99999999", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatal("expected 400 for a too large download, got", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/probe", bytes.NewReader(make([]byte, 5000))))
+	var resp struct {
+		Bytes int `json:"bytes"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Bytes != 5000 {
+		t.Fatal("unexpected upload response", rec.Body.String(), err)
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	h := newTestHandler()
+	for i := 0; i < MAX_CONCURRENT_PROBES; i++ {
+		h.slots <- struct{}{}
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/probe?bytes=1", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatal("expected 503, got", rec.Code)
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	h := newTestHandler()
+	// 100 KB/s: 20 s for the fixed part both ways, 0.2 s per c6 execution
+	// and 3.6 s of round trips, within the 50 s left of the handshake SLA
+	e := h.Estimate(100, 100000, 100000, 100*time.Millisecond)
+	if !e.Feasible || e.LimitSeconds != 50 || e.RoundTrips != 36 {
+		t.Fatal("unexpected estimate", e)
+	}
+	if e.MaxC6Count != 132 {
+		t.Fatal("unexpected max c6Count", e.MaxC6Count)
+	}
+	if h.Estimate(1026, 100000, 100000, 100*time.Millisecond).Feasible {
+		t.Fatal("a max size request is not feasible on this connection")
+	}
+	if h.Estimate(1, 1000, 1000, 0).MaxC6Count != 0 {
+		t.Fatal("nothing is feasible on a very slow connection")
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeEstimate(rec, httptest.NewRequest(http.MethodGet, "/probe/estimate?c6Count=100&downloadBps=100000&uploadBps=100000&rttMs=100", nil))
+	if rec.Code != http.StatusOK || !bytes.Contains(rec.Body.Bytes(), []byte(`"feasible":true`)) {
+		t.Fatal("unexpected response", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	h.ServeEstimate(rec, httptest.NewRequest(http.MethodGet, "/probe/estimate?c6Count=100&downloadBps=0&uploadBps=1&rttMs=1", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatal("expected 400, got", rec.Code)
+	}
+}
+
+func TestPlanTransfer(t *testing.T) {
+	p := PlanTransfer(100, 100000)
+	if p.C6Count != 7 || p.RecordCount != 1 || p.FrameSize != 16384 || p.FrameSizeLog2 != 14 || p.GhashStep2 || p.Compression {
+		t.Fatal("unexpected plan", p)
+	}
+	if p = PlanTransfer(100, 1000000); p.FrameSize != 65536 || p.FrameSizeLog2 != 16 {
+		t.Fatal("unexpected frame size", p.FrameSize, p.FrameSizeLog2)
+	}
+	if p = PlanTransfer(100, 1e9); p.FrameSize != 1<<20 || p.FrameSizeLog2 != 20 {
+		t.Fatal("unexpected frame size", p.FrameSize, p.FrameSizeLog2)
+	}
+	// 337 blocks of request plus the AAD and lengths blocks are the most
+	// which ghash_step1 handles
+	if PlanTransfer(337*16, 100000).GhashStep2 || !PlanTransfer(337*16+1, 100000).GhashStep2 {
+		t.Fatal("unexpected ghash_step2 threshold")
+	}
+	// a request larger than a TLS record is sent in several records, the
+	// largest of which needs ghash_step2
+	if p = PlanTransfer(2*MAX_RECORD_SIZE+1, 100000); p.RecordCount != 3 || p.C6Count != 2049 || !p.GhashStep2 {
+		t.Fatal("unexpected plan of a request of several records", p)
+	}
+
+	h := newTestHandler()
+	rec := httptest.NewRecorder()
+	h.ServeEstimate(rec, httptest.NewRequest(http.MethodGet, "/probe/estimate?requestBytes=1600&downloadBps=100000&uploadBps=100000&rttMs=100", nil))
+	var e Estimate
+	if err := json.Unmarshal(rec.Body.Bytes(), &e); err != nil || e.Plan == nil || e.Plan.C6Count != 100 || e.DownloadBytes != 2000000 {
+		t.Fatal("unexpected response", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	h.ServeEstimate(rec, httptest.NewRequest(http.MethodGet, "/probe/estimate?requestBytes=0&downloadBps=1&uploadBps=1&rttMs=1", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatal("expected 400 for an empty request, got", rec.Code)
+	}
+}
+
+func TestCost(t *testing.T) {
+	h := newTestHandler()
+	c := h.Cost(1600, 20000)
+	if c.C6Count != 100 || c.RecordCount != 1 || c.UploadBytes != 2000000 || c.OtBytes != 100000 {
+		t.Fatal("unexpected cost", c)
+	}
+	if c.ResponseRecords != 2 || !c.TagVerification || c.EvaluationSeconds != 2 || c.Seconds != 2 || c.MaxSeconds != 2400 {
+		t.Fatal("unexpected cost", c)
+	}
+	if h.Cost(1600, 17*MAX_RECORD_SIZE).TagVerification {
+		t.Fatal("the tags of more than 16 records can't be verified")
+	}
+
+	// the network time of the estimate is added: 20 s each way and 3.6 s of
+	// round trips
+	rec := httptest.NewRecorder()
+	h.ServeCost(rec, httptest.NewRequest(http.MethodGet, "/probe/cost?requestBytes=1600&responseBytes=20000&downloadBps=100000&uploadBps=100000&rttMs=100", nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), &c); err != nil || c.NetworkSeconds != 43.6 || c.Seconds != 45.6 {
+		t.Fatal("unexpected response", rec.Code, rec.Body.String())
+	}
+	for _, query := range []string{"requestBytes=0&responseBytes=1", "requestBytes=16417&responseBytes=1", "requestBytes=1", "requestBytes=1&responseBytes=1&downloadBps=0&uploadBps=1&rttMs=1"} {
+		rec = httptest.NewRecorder()
+		h.ServeCost(rec, httptest.NewRequest(http.MethodGet, "/probe/cost?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatal("expected 400 for", query, "got", rec.Code)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+// protocol_fuzzer drives a running notary with valid-looking but randomly
+// ordered and duplicated protocol messages. It checks that the notary either
+// progresses or fails safely: a message which violates the sequence rules
+// must get 409 OUT_OF_ORDER and must destroy the session.
+//
+// Start a notary with --no-sandbox, then run e.g.:
+// go run ./protocol_fuzzer -iterations 200 -max-seq 8
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	mathrand "math/rand"
+	"net/http"
+	u "notary/utils"
+	"os"
+	"strings"
+	"time"
+)
+
+// step is a protocol message. seqNo numbers the ORDERED steps in the order
+// of session.Protocol, with gaps where the notary allows any order.
+type step struct {
+	command string
+	seqNo   int
+}
+
+// steps mirrors the ORDERED and REPEATABLE steps of session.Protocol. The
+// fuzzer doesn't import the session package so that it builds without the
+// MPC libraries.
+var steps = []step{
+	{"init", 1},
+	{"getBlob", 3},
+	{"setBlob", 4},
+	{"step1", 5},
+	{"step2", 6},
+	{"step3", 7},
+	{"step4", 8},
+	{"c1_step1", 9},
+	{"c1_step2", 10},
+	{"c1_step3", 11},
+	{"c1_step4", 12},
+	{"c1_step5", 13},
+	{"c2_step1", 14},
+	{"c2_step2", 15},
+	{"c2_step3", 16},
+	{"c2_step4", 17},
+	{"c3_step1", 18},
+	{"c3_step2", 19},
+	{"c4_step1", 20},
+	{"c4_step2", 21},
+	{"c4_step3", 22},
+	{"c5_pre1", 23},
+	{"c5_step1", 24},
+	{"c5_step2", 25},
+	{"c5_step3", 26},
+	{"c6_step1", 27},
+	{"c6_pre2", 28},
+	{"c6_step2", 29},
+	{"c7_step1", 30},
+	{"c7_step2", 31},
+	{"ghash_step1", 32},
+	{"ghash_step2", 33},
+	{"ghash_step3", 34},
+	{"commitHash", 35},
+	{"tagVerification", 36},
+	{"getUploadProgress", 100},
+}
+
+// sequenceModel predicts whether the notary accepts a message. It follows
+// the transitions of the session's protocolFSM.
+type sequenceModel struct {
+	seen []int
+}
+
+func (m *sequenceModel) accepts(seqNo int) bool {
+	if seqNo == 100 {
+		return u.Contains(4, m.seen) && !u.Contains(9, m.seen)
+	}
+	if u.Contains(seqNo, m.seen) {
+		return false
+	}
+	if u.Contains(seqNo-1, m.seen) {
+		return true
+	}
+	return u.Contains(seqNo, []int{1, 3, 4}) || (seqNo == 34 && u.Contains(32, m.seen))
+}
+
+func (m *sequenceModel) add(seqNo int) {
+	if seqNo != 100 {
+		m.seen = append(m.seen, seqNo)
+	}
+}
+
+type fuzzer struct {
+	notaryURL   string
+	client      *http.Client
+	rnd         *mathrand.Rand
+	maxSeq      int
+	messages    int
+	sid         string
+	clientKey   []byte
+	notaryKey   []byte
+	model       sequenceModel
+	failures    int
+	destroyWait time.Duration
+}
+
+func (f *fuzzer) fail(format string, args ...interface{}) {
+	f.failures += 1
+	log.Printf("FAIL [%s] "+format+"\n", append([]interface{}{f.sid}, args...)...)
+}
+
+// send sends body to the notary's command endpoint for the current session
+func (f *fuzzer) send(command string, body []byte) (int, []byte, error) {
+	url := fmt.Sprintf("%s/%s?%s", f.notaryURL, command, f.sid)
+	resp, err := f.client.Post(url, "application/octet-stream", bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, respBody, err
+}
+
+// sessionExists uses pollTagVerification (which has no side effects) to
+// check if the notary still knows the session
+func (f *fuzzer) sessionExists() bool {
+	status, body, err := f.send("pollTagVerification", nil)
+	if err != nil {
+		f.fail("pollTagVerification: %s", err)
+		return false
+	}
+	return !(status == http.StatusInternalServerError && strings.Contains(string(body), "not found"))
+}
+
+// initBody generates a client ECDH key and returns the body of init
+func (f *fuzzer) initBody() ([]byte, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	c6Count := make([]byte, 2)
+	binary.BigEndian.PutUint16(c6Count, 1)
+	return u.Concat(u.To32Bytes(key.X), u.To32Bytes(key.Y), c6Count), key
+}
+
+// deriveKeys derives the symmetric keys from the notary's ephemeral pubkey
+// found in the key data which precedes the init response
+func (f *fuzzer) deriveKeys(resp []byte, key *ecdsa.PrivateKey) bool {
+	// keyData is validFrom(4) | validUntil(4) | pubkey(65) | signature(64)
+	if len(resp) < 137 {
+		f.fail("init response too short: %d bytes", len(resp))
+		return false
+	}
+	x := new(big.Int).SetBytes(resp[9:41])
+	y := new(big.Int).SetBytes(resp[41:73])
+	secret, _ := elliptic.P256().ScalarMult(x, y, key.D.Bytes())
+	secretBytes := u.To32Bytes(secret)
+	f.clientKey = secretBytes[0:16]
+	f.notaryKey = secretBytes[16:32]
+	return true
+}
+
+// randomBody returns an encrypted random payload of a random size
+func (f *fuzzer) randomBody() []byte {
+	sizes := []int{0, 1, 2, 16, 32, 64, 160, 1024}
+	payload := make([]byte, sizes[f.rnd.Intn(len(sizes))])
+	f.rnd.Read(payload)
+	if f.clientKey == nil {
+		return payload
+	}
+	return u.AESGCMencrypt(f.clientKey, payload)
+}
+
+// isEncrypted checks if the response can be decrypted with the notary key,
+// i.e. that no plaintext leaves the notary
+func (f *fuzzer) isEncrypted(resp []byte) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	if f.notaryKey == nil || len(resp) < 12 {
+		return false
+	}
+	u.AESGCMdecrypt(f.notaryKey, append([]byte{}, resp...))
+	return true
+}
+
+// runSession sends a random sequence of messages for a fresh session
+func (f *fuzzer) runSession() {
+	f.sid = u.RandString()
+	f.clientKey = nil
+	f.notaryKey = nil
+	f.model = sequenceModel{}
+
+	var candidates []step
+	for _, s := range steps {
+		if s.seqNo <= f.maxSeq || s.seqNo == 100 {
+			candidates = append(candidates, s)
+		}
+	}
+
+	for i := 0; i < f.messages; i++ {
+		s := candidates[f.rnd.Intn(len(candidates))]
+		if i == 0 && f.rnd.Intn(4) != 0 {
+			// most of the time start properly to get deeper into the protocol
+			s = steps[0]
+		}
+
+		var body []byte
+		var key *ecdsa.PrivateKey
+		if s.command == "init" {
+			body, key = f.initBody()
+		} else {
+			body = f.randomBody()
+		}
+		accepted := f.model.accepts(s.seqNo)
+		initialized := len(f.model.seen) > 0
+
+		status, resp, err := f.send(s.command, body)
+		if err != nil {
+			f.fail("%s: %s", s.command, err)
+			return
+		}
+
+		if !initialized && s.command != "init" {
+			// the session doesn't exist yet
+			if status == http.StatusOK && len(resp) != 0 {
+				f.fail("%s before init returned %d bytes", s.command, len(resp))
+			}
+			continue
+		}
+
+		if s.command == "init" && initialized {
+			// a second init for the same session must not reveal anything
+			if status == http.StatusOK && len(resp) > 137 {
+				f.fail("repeated init returned %d bytes", len(resp))
+			}
+			return
+		}
+
+		if !accepted {
+			if status != http.StatusConflict || !strings.Contains(string(resp), "OUT_OF_ORDER") {
+				f.fail("out of order %s (seqNo %d) returned status %d, %d bytes", s.command, s.seqNo, status, len(resp))
+			}
+			time.Sleep(f.destroyWait)
+			if f.sessionExists() {
+				f.fail("session survived out of order %s (seqNo %d)", s.command, s.seqNo)
+			}
+			return
+		}
+
+		if s.command == "init" {
+			if status != http.StatusOK {
+				// e.g. OT is busy, nothing to fuzz
+				log.Println("init rejected with status", status)
+				return
+			}
+			if !f.deriveKeys(resp, key) {
+				return
+			}
+			f.model.add(s.seqNo)
+			continue
+		}
+
+		if status != http.StatusOK {
+			// the random payload was rejected, the session must be gone
+			time.Sleep(f.destroyWait)
+			if !f.sessionExists() {
+				return
+			}
+		} else if s.command != "getBlob" && !f.isEncrypted(resp) {
+			f.fail("%s returned %d bytes which are not encrypted to the client", s.command, len(resp))
+		}
+		f.model.add(s.seqNo)
+	}
+}
+
+func main() {
+	notaryURL := flag.String("notary", "http://127.0.0.1:10011", "URL of the notary under test.")
+	iterations := flag.Int("iterations", 100, "Amount of sessions to fuzz.")
+	messages := flag.Int("messages", 20, "Max amount of messages per session.")
+	maxSeq := flag.Int("max-seq", 8, "Only send messages up to this sequence number. Messages past step4 need a working OT client.")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "Random seed, to reproduce a run.")
+	timeout := flag.Duration("timeout", 30*time.Second, "Timeout of each request. A timeout counts as a failure.")
+	flag.Parse()
+
+	log.Println("seed", *seed)
+	f := &fuzzer{
+		notaryURL:   strings.TrimSuffix(*notaryURL, "/"),
+		client:      &http.Client{Timeout: *timeout},
+		rnd:         mathrand.New(mathrand.NewSource(*seed)),
+		maxSeq:      *maxSeq,
+		messages:    *messages,
+		destroyWait: 200 * time.Millisecond,
+	}
+
+	for i := 0; i < *iterations; i++ {
+		f.runSession()
+	}
+
+	log.Printf("%d sessions fuzzed, %d failures\n", *iterations, f.failures)
+	if f.failures > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,169 @@
+// Package rng_health runs health tests on the output of crypto/rand. The
+// notary's signatures and the masks of the 2PC protocols are only as secure
+// as the random numbers they are made of, so once a test fails the notary
+// refuses to sign until it is restarted.
+//
+// The tests are the continuous health tests of NIST SP 800-90B section 4.4
+// (repetition count and adaptive proportion) on byte samples, with a false
+// positive rate of about 2^-40 per sample, plus a stuck output test which
+// fails if two consecutive blocks are equal.
+package rng_health
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// SampleSize is the amount of random bytes tested by each Check
+	SampleSize = 64 * 1024
+	// repetitionCutoff is the amount of identical consecutive bytes which
+	// fails the repetition count test: 1 + ceil(40 / 8) for 8 bits of
+	// entropy per byte
+	repetitionCutoff = 6
+	// proportionWindow is the window size of the adaptive proportion test
+	proportionWindow = 512
+	// proportionCutoff is how often the first byte of a window may occur in
+	// the window, the critical value of the binomial distribution with
+	// n = 512, p = 1/256 and alpha = 2^-40
+	proportionCutoff = 19
+	// blockSize is the size of the blocks compared by the stuck output test
+	blockSize = 16
+)
+
+// ErrUnhealthy is returned instead of a signature after a failed test
+var ErrUnhealthy = errors.New("random number generator failed a health test")
+
+var (
+	mu sync.Mutex
+	// failure is the first failed test, nil while the RNG is healthy
+	failure error
+	// lastBlock is the last block of the previous sample, so that the stuck
+	// output test also spans samples
+	lastBlock []byte
+	// checks counts the samples which were tested
+	checks   = expvar.NewInt("rng_health_checks")
+	failures = expvar.NewInt("rng_health_failures")
+)
+
+func init() {
+	expvar.Publish("rng_healthy", expvar.Func(func() interface{} {
+		return Healthy()
+	}))
+}
+
+// Healthy is false once a test failed
+func Healthy() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return failure == nil
+}
+
+// Err returns ErrUnhealthy wrapped with the reason once a test failed, and
+// nil otherwise
+func Err() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if failure == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %v", ErrUnhealthy, failure)
+}
+
+// Check tests a sample of SampleSize bytes from crypto/rand. A failure is
+// permanent.
+func Check() error {
+	sample := make([]byte, SampleSize)
+	if _, err := rand.Read(sample); err != nil {
+		return fail(err)
+	}
+	mu.Lock()
+	prev := lastBlock
+	lastBlock = sample[len(sample)-blockSize:]
+	mu.Unlock()
+	checks.Add(1)
+	if prev != nil && bytes.Equal(prev, sample[:blockSize]) {
+		return fail(errors.New("stuck output across samples"))
+	}
+	if err := Test(sample); err != nil {
+		return fail(err)
+	}
+	return Err()
+}
+
+func fail(err error) error {
+	mu.Lock()
+	if failure == nil {
+		failure = err
+		failures.Add(1)
+		log.Println("RNG health test failed, signing is disabled:", err)
+	}
+	mu.Unlock()
+	return Err()
+}
+
+// Run checks the RNG every interval, forever
+func Run(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		Check()
+	}
+}
+
+// Test runs the health tests on sample
+func Test(sample []byte) error {
+	if err := repetitionCount(sample); err != nil {
+		return err
+	}
+	if err := adaptiveProportion(sample); err != nil {
+		return err
+	}
+	return stuckOutput(sample)
+}
+
+// repetitionCount fails if a byte is repeated repetitionCutoff times in a
+// row
+func repetitionCount(sample []byte) error {
+	run := 1
+	for i := 1; i < len(sample); i++ {
+		if sample[i] != sample[i-1] {
+			run = 1
+			continue
+		}
+		run++
+		if run >= repetitionCutoff {
+			return fmt.Errorf("repetition count test: byte %#x repeated %d times", sample[i], run)
+		}
+	}
+	return nil
+}
+
+// adaptiveProportion fails if the first byte of a window occurs
+// proportionCutoff times in the window
+func adaptiveProportion(sample []byte) error {
+	for o := 0; o+proportionWindow <= len(sample); o += proportionWindow {
+		window := sample[o : o+proportionWindow]
+		count := bytes.Count(window, window[:1])
+		if count >= proportionCutoff {
+			return fmt.Errorf("adaptive proportion test: byte %#x occurs %d times in %d bytes",
+				window[0], count, proportionWindow)
+		}
+	}
+	return nil
+}
+
+// stuckOutput fails if two consecutive blocks are equal
+func stuckOutput(sample []byte) error {
+	for o := blockSize; o+blockSize <= len(sample); o += blockSize {
+		if bytes.Equal(sample[o-blockSize:o], sample[o:o+blockSize]) {
+			return errors.New("stuck output test: repeated block")
+		}
+	}
+	return nil
+}
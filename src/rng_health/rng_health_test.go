@@ -0,0 +1,62 @@
+package rng_health
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func randomSample(t *testing.T) []byte {
+	sample := make([]byte, SampleSize)
+	if _, err := rand.Read(sample); err != nil {
+		t.Fatal(err)
+	}
+	return sample
+}
+
+func TestRandomOutputPasses(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		if err := Test(randomSample(t)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRepetitionCount(t *testing.T) {
+	sample := randomSample(t)
+	copy(sample[1000:], bytes.Repeat([]byte{0xab}, repetitionCutoff))
+	if err := repetitionCount(sample); err == nil {
+		t.Error("repeated byte not detected")
+	}
+}
+
+func TestAdaptiveProportion(t *testing.T) {
+	sample := randomSample(t)
+	// spread the first byte of the second window over the window
+	window := sample[proportionWindow : 2*proportionWindow]
+	for i := 0; i < proportionCutoff; i++ {
+		window[i*20] = window[0]
+	}
+	if err := adaptiveProportion(sample); err == nil {
+		t.Error("biased window not detected")
+	}
+}
+
+func TestStuckOutput(t *testing.T) {
+	sample := randomSample(t)
+	copy(sample[5*blockSize:], sample[4*blockSize:5*blockSize])
+	if err := stuckOutput(sample); err == nil {
+		t.Error("repeated block not detected")
+	}
+}
+
+func TestFailureIsPermanent(t *testing.T) {
+	if err := Check(); err != nil {
+		t.Fatal(err)
+	}
+	fail(errors.New("test failure"))
+	if Healthy() || !errors.Is(Check(), ErrUnhealthy) {
+		t.Error("expected the RNG to stay unhealthy")
+	}
+}
@@ -0,0 +1,116 @@
+package session
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"notary/transparency_log"
+	u "notary/utils"
+)
+
+// ATTESTATION_VERSION is the version of AttestationDocument
+const ATTESTATION_VERSION = 1
+
+// AttestationDocument is what the notary signs in commitHash for clients
+// with PROTOCOL_ATTESTATION_DOCUMENT: the same facts as the concatenation
+// signed for older clients, but labelled. Binary values are hex-encoded.
+type AttestationDocument struct {
+	Version         int `json:"version"`
+	ProtocolVersion int `json:"protocolVersion"`
+	// NotaryKeyId is the id of the ephemeral key which signed the document,
+	// as in the key bundle of /getPubKey
+	NotaryKeyId string `json:"notaryKeyId"`
+	// Timestamp is the unix time of the signature
+	Timestamp int64 `json:"timestamp"`
+	// ServerPubkey is the server's ECDHE public key
+	ServerPubkey string `json:"serverPubkey"`
+	// CommitHash is the client's commitment to the server's response
+	CommitHash string `json:"commitHash"`
+	// KeyShareHashes are the client's hashes of its key shares
+	KeyShareHashes KeyShareHashes `json:"keyShareHashes"`
+	// GhashInputsHash is the SHA-256 of the GHASH inputs of the request
+	GhashInputsHash string `json:"ghashInputsHash"`
+	// OtStatsDigest is the OtStats.Digest of the session's OT traffic
+	OtStatsDigest string `json:"otStatsDigest"`
+	// SignatureScheme is "rfc6979" or "randomized", or "none" for the
+	// unsigned documents of Session.VerifierOnly
+	SignatureScheme    string               `json:"signatureScheme"`
+	AttestationCounter uint64               `json:"attestationCounter"`
+	Metrics            *SessionMetrics      `json:"metrics,omitempty"`
+	Commitments        []AttestedCommitment `json:"commitments,omitempty"`
+}
+
+// KeyShareHashes are the hashes of the client's shares of the TLS keys
+type KeyShareHashes struct {
+	ClientWriteKey string `json:"clientWriteKey"`
+	ClientWriteIv  string `json:"clientWriteIv"`
+	ServerWriteKey string `json:"serverWriteKey"`
+	ServerWriteIv  string `json:"serverWriteIv"`
+}
+
+// AttestedCommitment is a typed commitment (see Commitment) in an
+// AttestationDocument
+type AttestedCommitment struct {
+	Purpose   byte   `json:"purpose"`
+	Algorithm byte   `json:"algorithm"`
+	Value     string `json:"value"`
+}
+
+// SignedAttestation is the attestation in the commitHash response. The
+// signature is over Document as is, so verifiers check it before parsing
+// and never need to re-encode the document.
+type SignedAttestation struct {
+	// Document is the JSON of an AttestationDocument
+	Document json.RawMessage `json:"document"`
+	// Signature is r | s of ECDSA over the SHA-256 of Document with the
+	// ephemeral key, in hex. It is empty for Session.VerifierOnly.
+	Signature string `json:"signature,omitempty"`
+	// NotaryKeyData is the ephemeral key certified by the master key as
+	// sent before the init response: validFrom(4) | validUntil(4) |
+	// pubkey(65) | signature(64), in hex
+	NotaryKeyData string `json:"notaryKeyData,omitempty"`
+}
+
+// attestedCommitments converts commitments for an AttestationDocument
+func attestedCommitments(commitments []Commitment) []AttestedCommitment {
+	var attested []AttestedCommitment
+	for _, c := range commitments {
+		attested = append(attested, AttestedCommitment{c.Purpose, c.Algorithm, hex.EncodeToString(c.Value)})
+	}
+	return attested
+}
+
+// signAttestation signs doc with the session's ephemeral key. Documents of VerifierOnly sessions are
+// sent unsigned.
+func (s *Session) signAttestation(doc *AttestationDocument) ([]byte, error) {
+	document, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	if s.VerifierOnly {
+		return json.Marshal(SignedAttestation{Document: document})
+	}
+	var signature []byte
+	if s.DeterministicSignatures {
+		signature = u.ECDSASignDeterministic(&s.SigningKey, document)
+	} else {
+		signature = u.ECDSASign(&s.SigningKey, document)
+	}
+	if err = s.logSignature(transparency_log.KIND_SESSION, signature); err != nil {
+		return nil, err
+	}
+	return json.Marshal(SignedAttestation{
+		Document:      document,
+		Signature:     hex.EncodeToString(signature),
+		NotaryKeyData: hex.EncodeToString(s.KeyData),
+	})
+}
+
+// logSignature appends a signature of the session to the transparency log,
+// if there is one. The signature must not be sent if this fails.
+func (s *Session) logSignature(kind byte, signature []byte) error {
+	if s.TransparencyLog == nil {
+		return nil
+	}
+	_, err := s.TransparencyLog.Append(kind, signature)
+	return err
+}
@@ -0,0 +1,179 @@
+package session
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"notary/attestation"
+	u "notary/utils"
+	"testing"
+	"time"
+)
+
+func TestSignAttestation(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Session{SigningKey: *key, KeyData: []byte{1, 2}}
+	doc := &AttestationDocument{
+		Version:     ATTESTATION_VERSION,
+		CommitHash:  "00",
+		Commitments: attestedCommitments([]Commitment{{COMMITMENT_HEADERS, COMMITMENT_ALG_SHA256, []byte{0xab}}}),
+	}
+	out, err := s.signAttestation(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var signed SignedAttestation
+	if err = json.Unmarshal(out, &signed); err != nil {
+		t.Fatal(err)
+	}
+	signature, err := hex.DecodeString(signed.Signature)
+	if err != nil || len(signature) != 64 {
+		t.Fatal("unexpected signature", signed.Signature)
+	}
+	r := new(big.Int).SetBytes(signature[:32])
+	sig := new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(&key.PublicKey, u.Sha256(signed.Document), r, sig) {
+		t.Fatal("the signature must be over the document as sent")
+	}
+	if signed.NotaryKeyData != "0102" {
+		t.Fatal("unexpected key data", signed.NotaryKeyData)
+	}
+
+	var parsed AttestationDocument
+	if err = json.Unmarshal(signed.Document, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Version != ATTESTATION_VERSION || len(parsed.Commitments) != 1 || parsed.Commitments[0].Value != "ab" {
+		t.Fatalf("unexpected document %+v", parsed)
+	}
+}
+
+func TestVerifierOnlyAttestation(t *testing.T) {
+	// the session has no signing key
+	s := &Session{VerifierOnly: true}
+	out, err := s.signAttestation(&AttestationDocument{Version: ATTESTATION_VERSION, SignatureScheme: "none"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(out, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 1 || fields["document"] == nil {
+		t.Fatal("only the document must be sent", string(out))
+	}
+	var parsed AttestationDocument
+	if err = json.Unmarshal(fields["document"], &parsed); err != nil || parsed.SignatureScheme != "none" {
+		t.Fatalf("unexpected document %s", fields["document"])
+	}
+}
+
+// TestAttestationVerifies checks that the attestation package verifies what
+// the session signs
+func TestAttestationVerifies(t *testing.T) {
+	master, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	validity := make([]byte, 8)
+	binary.BigEndian.PutUint32(validity[:4], uint32(now.Unix()))
+	binary.BigEndian.PutUint32(validity[4:], uint32(now.Add(time.Minute).Unix()))
+	pubkey := u.Concat([]byte{0x04}, u.To32Bytes(key.X), u.To32Bytes(key.Y))
+	keyData := u.Concat(validity, pubkey, u.ECDSASign(master, validity, pubkey))
+
+	// a request of 3 blocks, the last one shorter
+	request := []byte("GET / HTTP/1.1\r\nAuthorization: xyz")
+	salts := [][]byte{u.GetRandom(16), u.GetRandom(16), u.GetRandom(16)}
+	tree, err := attestation.NewBlockTree(request, salts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitments, err := ParseCommitments(EncodeCommitments([]Commitment{
+		{COMMITMENT_REQUEST_BLOCKS, COMMITMENT_ALG_BLOCK_MERKLE_SHA256, tree.Commitment()}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = checkRequestCommitment(commitments, 3, 1); err != nil {
+		t.Fatal(err)
+	}
+	if COMMITMENT_REQUEST_BLOCKS != attestation.COMMITMENT_REQUEST_BLOCKS || COMMITMENT_ALG_BLOCK_MERKLE_SHA256 != attestation.COMMITMENT_ALG_BLOCK_MERKLE_SHA256 {
+		t.Fatal("the request commitment must be the one of the attestation package")
+	}
+
+	s := &Session{SigningKey: *key, KeyData: keyData}
+	hash := hex.EncodeToString(make([]byte, 32))
+	out, err := s.signAttestation(&AttestationDocument{
+		Version:            ATTESTATION_VERSION,
+		ProtocolVersion:    PROTOCOL_ATTESTATION_DOCUMENT,
+		NotaryKeyId:        hex.EncodeToString(u.Sha256(pubkey)[:8]),
+		Timestamp:          now.Unix(),
+		ServerPubkey:       hex.EncodeToString(pubkey),
+		CommitHash:         hash,
+		KeyShareHashes:     KeyShareHashes{hash, hash, hash, hash},
+		GhashInputsHash:    hash,
+		OtStatsDigest:      OtStats{}.Digest(),
+		SignatureScheme:    "randomized",
+		AttestationCounter: 1,
+		Commitments:        attestedCommitments(commitments),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := attestation.Verify(out, &master.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.AttestationCounter != 1 {
+		t.Fatalf("unexpected document %+v", doc)
+	}
+	// the first block is disclosed, the Authorization header stays hidden
+	err = doc.VerifyDisclosure([]attestation.DisclosedBlock{{
+		Index: 0,
+		Block: hex.EncodeToString(request[:16]),
+		Salt:  hex.EncodeToString(salts[0]),
+		Proof: []string{hex.EncodeToString(tree.Proof(0)[0]), hex.EncodeToString(tree.Proof(0)[1])},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckRequestCommitment(t *testing.T) {
+	value := append([]byte{0, 0, 0, 3}, make([]byte, 32)...)
+	for _, tc := range []struct {
+		commitment Commitment
+		valid      bool
+	}{
+		{Commitment{COMMITMENT_REQUEST_BLOCKS, COMMITMENT_ALG_BLOCK_MERKLE_SHA256, value}, true},
+		{Commitment{COMMITMENT_REQUEST_BLOCKS, COMMITMENT_ALG_BLOCK_MERKLE_SHA256, append([]byte{0, 0, 0, 4}, value[4:]...)}, false},
+		{Commitment{COMMITMENT_REQUEST_BLOCKS, COMMITMENT_ALG_SHA256, make([]byte, 32)}, false},
+		{Commitment{COMMITMENT_HEADERS, COMMITMENT_ALG_BLOCK_MERKLE_SHA256, value}, false},
+		{Commitment{COMMITMENT_HEADERS, COMMITMENT_ALG_SHA256, make([]byte, 32)}, true},
+	} {
+		if err := checkRequestCommitment([]Commitment{tc.commitment}, 3, 1); (err == nil) != tc.valid {
+			t.Errorf("purpose %d, algorithm %d: expected valid=%v, got %v", tc.commitment.Purpose, tc.commitment.Algorithm, tc.valid, err)
+		}
+	}
+	// the last block of each record may be shorter, e.g. a request of 40
+	// bytes in records of 20 and 20 bytes has 3 blocks but 4 AES blocks
+	request := []Commitment{{COMMITMENT_REQUEST_BLOCKS, COMMITMENT_ALG_BLOCK_MERKLE_SHA256, value}}
+	if err := checkRequestCommitment(request, 4, 2); err != nil {
+		t.Error(err)
+	}
+	if checkRequestCommitment(request, 4, 1) == nil || checkRequestCommitment(request, 5, 2) == nil {
+		t.Error("a request commitment with too few blocks must be rejected")
+	}
+}
@@ -0,0 +1,135 @@
+package session
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"notary/chaos"
+	u "notary/utils"
+	"os"
+	"path/filepath"
+)
+
+// MAX_BLOB_CHUNK_SIZE is the max size of a decompressed setBlobChunk body.
+// A chunk is hashed before it is appended to the blob, so it is kept in
+// memory until then.
+const MAX_BLOB_CHUNK_SIZE = 16 << 20
+
+// ChunkError is returned for a chunk which wasn't appended to the blob, e.g.
+// because its hash didn't match or because the connection dropped. The
+// session survives it: the client resumes at Offset. It wraps
+// ErrChunkRejected.
+type ChunkError struct {
+	// Offset is the amount of bytes of the blob received so far
+	Offset int64
+	Reason string
+}
+
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("%s: %s, resume at offset %d", ErrChunkRejected, e.Reason, e.Offset)
+}
+
+func (e *ChunkError) Unwrap() error {
+	return ErrChunkRejected
+}
+
+// SetBlobChunk appends a chunk of the blob at offset, the amount of bytes
+// which the client knows to be received. chunkHash is the SHA-256 of the
+// decompressed chunk. It returns the new offset. Chunks are appended one at
+// a time; the upload is finished with an empty setBlob, see SetBlob.
+func (s *Session) SetBlobChunk(body io.ReadCloser, encoding string, offset int64, chunkHash []byte) (int64, error) {
+	if _, _, err := s.beginStep(stepOf("setBlobChunk"), nil); err != nil {
+		return 0, err
+	}
+	s.blobChunkMutex.Lock()
+	defer s.blobChunkMutex.Unlock()
+	if s.blobCommitted {
+		return 0, outOfOrder("setBlobChunk was sent after setBlob")
+	}
+	if !s.blobChunked {
+		s.blobChunked = true
+		s.streamCounter.Store(&StreamCounter{total: 0, limit: s.blobSize})
+		if m := s.newMemoryBlob(); m != nil {
+			s.memoryBlobMutex.Lock()
+			s.memoryBlob = m
+			s.memoryBlobMutex.Unlock()
+		}
+	}
+	counter := s.streamCounter.Load()
+	received := int64(counter.Total())
+	if offset != received {
+		return received, &ChunkError{received, fmt.Sprintf("the chunk is at offset %d", offset)}
+	}
+	chunk, err := readChunk(body, encoding)
+	if err != nil {
+		return received, &ChunkError{received, err.Error()}
+	}
+	if !bytes.Equal(u.Sha256(chunk), chunkHash) {
+		return received, &ChunkError{received, "the hash of the chunk doesn't match"}
+	}
+	if err = counter.check(len(chunk)); err != nil {
+		return received, err
+	}
+	// the counter only advances once the chunk is stored, so that a chunk
+	// which failed to be stored can be sent again
+	if err = s.storeChunk(chunk, received); err != nil {
+		log.Println("Error: cannot store a blob chunk:", err)
+		return received, &ChunkError{received, "the chunk could not be stored"}
+	}
+	counter.Write(chunk)
+	return int64(counter.Total()), nil
+}
+
+// storeChunk appends chunk to the blob of received bytes, in memory or on
+// disk
+func (s *Session) storeChunk(chunk []byte, received int64) error {
+	if m := s.getMemoryBlob(); m != nil {
+		_, err := m.Write(chunk)
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(s.StorageDir, "blobForNotary"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err = file.Write(chunk); err != nil {
+		// drop what was written of the chunk
+		file.Truncate(received)
+		return err
+	}
+	return nil
+}
+
+// readChunk returns the decompressed chunk, or an error if the body was cut
+// off, corrupted or is larger than MAX_BLOB_CHUNK_SIZE
+func readChunk(body io.Reader, encoding string) ([]byte, error) {
+	reader, err := blobDecoder(body, encoding)
+	if err != nil {
+		return nil, err
+	}
+	chunk, err := io.ReadAll(io.LimitReader(chaos.Reader(chaos.BlobCorrupt, reader), MAX_BLOB_CHUNK_SIZE+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(chunk) > MAX_BLOB_CHUNK_SIZE {
+		return nil, fmt.Errorf("the chunk is larger than %d bytes", MAX_BLOB_CHUNK_SIZE)
+	}
+	return chunk, nil
+}
+
+// commitChunks is called by SetBlob. It returns true if the blob was
+// uploaded in chunks, in which case setBlob must have an empty body and the
+// blob must be complete. No chunks are accepted afterwards.
+func (s *Session) commitChunks(body io.Reader) (bool, error) {
+	s.blobChunkMutex.Lock()
+	defer s.blobChunkMutex.Unlock()
+	s.blobCommitted = true
+	if !s.blobChunked {
+		return false, nil
+	}
+	if n, _ := io.ReadFull(body, make([]byte, 1)); n > 0 {
+		return true, invalidMessage("setBlob must be empty after setBlobChunk")
+	}
+	return true, s.checkBlobComplete()
+}
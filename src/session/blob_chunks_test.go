@@ -0,0 +1,108 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	u "notary/utils"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func sendChunk(s *Session, chunk []byte, encoding string, offset int64, chunkHash []byte) (int64, error) {
+	return s.SetBlobChunk(io.NopCloser(bytes.NewReader(chunk)), encoding, offset, chunkHash)
+}
+
+func TestSetBlobChunk(t *testing.T) {
+	for _, budget := range []*BlobBudget{nil, {Limit: 16}} {
+		s := &Session{StorageDir: t.TempDir(), blobSize: 16, BlobBudget: budget}
+		s.fsm.advance(stepOf("init"))
+		blob := bytes.Repeat([]byte{1, 2, 3, 4}, 4)
+
+		offset, err := sendChunk(s, blob[:6], "", 0, u.Sha256(blob[:6]))
+		if err != nil || offset != 6 {
+			t.Fatal("unexpected result", offset, err)
+		}
+		// the response of the next chunk was lost, so the client sends the
+		// chunk again at the wrong offset
+		var chunkErr *ChunkError
+		_, err = sendChunk(s, blob[:6], "", 0, u.Sha256(blob[:6]))
+		if !errors.As(err, &chunkErr) || chunkErr.Offset != 6 {
+			t.Fatal("a chunk at the wrong offset must be rejected", err)
+		}
+		corrupt := append([]byte{}, blob[6:12]...)
+		corrupt[0] ^= 1
+		if _, err = sendChunk(s, corrupt, "", 6, u.Sha256(blob[6:12])); !errors.Is(err, ErrChunkRejected) {
+			t.Fatal("a chunk with the wrong hash must be rejected", err)
+		}
+		offset, err = sendChunk(s, gzipped(t, blob[6:]), "gzip", 6, u.Sha256(blob[6:]))
+		if err != nil || offset != 16 {
+			t.Fatal("unexpected result", offset, err)
+		}
+		if _, err = s.SetBlob(io.NopCloser(bytes.NewReader(nil)), ""); err != nil {
+			t.Fatal(err)
+		}
+		var out []byte
+		if m := s.getMemoryBlob(); m != nil {
+			out, _ = m.slice(0, 16)
+		} else {
+			out, _ = os.ReadFile(filepath.Join(s.StorageDir, "blobForNotary"))
+		}
+		if !bytes.Equal(out, blob) || (budget != nil) != (s.getMemoryBlob() != nil) {
+			t.Fatal("unexpected blob", out)
+		}
+		if _, err = sendChunk(s, nil, "", 16, u.Sha256(nil)); !errors.Is(err, ErrOutOfOrder) {
+			t.Fatal("chunks must be rejected after setBlob", err)
+		}
+	}
+}
+
+func TestSetBlobChunkErrors(t *testing.T) {
+	s := &Session{StorageDir: t.TempDir(), blobSize: 16}
+	if _, err := sendChunk(s, nil, "", 0, u.Sha256(nil)); !errors.Is(err, ErrOutOfOrder) {
+		t.Fatal("chunks must be rejected before init", err)
+	}
+	s.fsm.advance(stepOf("init"))
+	if _, err := sendChunk(s, make([]byte, 17), "", 0, u.Sha256(make([]byte, 17))); !errors.Is(err, ErrBlobTooLarge) {
+		t.Fatal("unexpected error", err)
+	}
+
+	// setBlob must be empty and the blob complete after chunks
+	s = &Session{StorageDir: t.TempDir(), blobSize: 16}
+	s.fsm.advance(stepOf("init"))
+	if _, err := sendChunk(s, make([]byte, 8), "", 0, u.Sha256(make([]byte, 8))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetBlob(io.NopCloser(bytes.NewReader(nil)), ""); !errors.Is(err, ErrBlobIncomplete) {
+		t.Fatal("unexpected error", err)
+	}
+	s = &Session{StorageDir: t.TempDir(), blobSize: 16}
+	s.fsm.advance(stepOf("init"))
+	sendChunk(s, make([]byte, 8), "", 0, u.Sha256(make([]byte, 8)))
+	if _, err := s.SetBlob(io.NopCloser(bytes.NewReader(make([]byte, 8))), ""); !errors.Is(err, ErrInvalidMessage) {
+		t.Fatal("unexpected error", err)
+	}
+}
+
+// TestSetBlobChunkStoreFails checks that a chunk which couldn't be stored
+// can be sent again
+func TestSetBlobChunkStoreFails(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "session")
+	s := &Session{StorageDir: dir, blobSize: 16}
+	s.fsm.advance(stepOf("init"))
+	chunk := bytes.Repeat([]byte{5}, 16)
+	var chunkErr *ChunkError
+	if _, err := sendChunk(s, chunk, "", 0, u.Sha256(chunk)); !errors.As(err, &chunkErr) || chunkErr.Offset != 0 {
+		t.Fatal("a chunk which wasn't stored must be rejected", err)
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if offset, err := sendChunk(s, chunk, "", 0, u.Sha256(chunk)); err != nil || offset != 16 {
+		t.Fatal("unexpected result", offset, err)
+	}
+	if _, err := s.SetBlob(io.NopCloser(bytes.NewReader(nil)), ""); err != nil {
+		t.Fatal(err)
+	}
+}
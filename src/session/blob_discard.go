@@ -0,0 +1,78 @@
+package session
+
+import (
+	"errors"
+	"expvar"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// blobBytesDiscarded counts the bytes of blobForNotary released after their
+// circuit was evaluated
+var blobBytesDiscarded = expvar.NewInt("blob_bytes_discarded")
+
+// errPunchHoleUnsupported is returned by punchHole where the OS or the file
+// system can't deallocate a range of a file
+var errPunchHoleUnsupported = errors.New("punching holes is not supported")
+
+// discardBlobForNotary releases the disk space of the truth tables of
+// circuit cNo in blobForNotary once they were evaluated, so that long
+// sessions with huge c6 blobs don't hold it until teardown. The range is
+// deallocated where punching holes is supported. Otherwise the file is
+// truncated once the truth tables of all circuits were evaluated. A blob
+// in memory is released once all circuits were evaluated.
+func (s *Session) discardBlobForNotary(cNo int) {
+	if s.getMemoryBlob() != nil {
+		s.blobConsumed[cNo] = true
+		if s.allBlobConsumed() {
+			s.ReleaseMemoryBlob()
+		}
+		return
+	}
+	if !s.DiscardConsumedBlobs {
+		return
+	}
+	s.blobConsumed[cNo] = true
+	path := filepath.Join(s.StorageDir, "blobForNotary")
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		log.Println("could not discard the truth tables of circuit", cNo, err)
+		return
+	}
+	defer file.Close()
+
+	if s.allBlobConsumed() {
+		for i := 1; i < len(s.blobConsumed); i++ {
+			if !s.blobDiscarded[i] {
+				_, size := s.getCircuitBlobOffset(i)
+				blobBytesDiscarded.Add(int64(size))
+				s.blobDiscarded[i] = true
+			}
+		}
+		if err = file.Truncate(0); err != nil {
+			log.Println("could not truncate blobForNotary", err)
+		}
+		return
+	}
+
+	off, size := s.getCircuitBlobOffset(cNo)
+	err = punchHole(file, int64(off), int64(size))
+	if err == nil {
+		blobBytesDiscarded.Add(int64(size))
+		s.blobDiscarded[cNo] = true
+	} else if err != errPunchHoleUnsupported {
+		log.Println("could not discard the truth tables of circuit", cNo, err)
+	}
+}
+
+// allBlobConsumed is true once the truth tables of all circuits were
+// evaluated
+func (s *Session) allBlobConsumed() bool {
+	for i := 1; i < len(s.blobConsumed); i++ {
+		if !s.blobConsumed[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package session
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ZSTD_MAX_WINDOW is the largest zstd window which setBlob accepts. The
+// decoder keeps the window in memory; 8 MiB is the size which RFC 8878 asks
+// every decoder to support.
+const ZSTD_MAX_WINDOW = 8 << 20
+
+// BlobEncodingSupported tells whether setBlob accepts a body with the
+// Content-Encoding encoding
+func BlobEncodingSupported(encoding string) bool {
+	switch encoding {
+	case "", "identity", "gzip", "x-gzip", "zstd":
+		return true
+	}
+	return false
+}
+
+// blobDecoder returns the decompressed stream of a setBlob body with the
+// Content-Encoding encoding, which must be supported. A gzip body may have
+// several members and a zstd body several frames, e.g. one per chunk which a
+// client retransmits on its own.
+func blobDecoder(body io.Reader, encoding string) (io.Reader, error) {
+	if encoding == "" || encoding == "identity" {
+		return body, nil
+	}
+	b := &bodyReader{r: body}
+	if encoding == "zstd" {
+		// one decoder decodes synchronously, without goroutines
+		zr, err := zstd.NewReader(b, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(ZSTD_MAX_WINDOW))
+		if err != nil {
+			return nil, err
+		}
+		return &decodingReader{zr, b, encoding, zr.Close}, nil
+	}
+	zr, err := gzip.NewReader(b)
+	if err != nil {
+		return nil, b.decodeError(encoding, err)
+	}
+	return &decodingReader{zr, b, encoding, func() {}}, nil
+}
+
+// bodyReader remembers whether reading the body failed, so that a broken
+// connection can be told from a corrupted stream
+type bodyReader struct {
+	r   io.Reader
+	err error
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// decodeError turns an error of the decompressor into an invalid message,
+// unless reading the body failed. A body which was cut off is an invalid
+// message too.
+func (b *bodyReader) decodeError(encoding string, err error) error {
+	if b.err != nil && !errors.Is(b.err, io.ErrUnexpectedEOF) {
+		return b.err
+	}
+	return invalidMessage("invalid %s stream: %s", encoding, err)
+}
+
+// decodingReader turns the errors of a corrupted or truncated stream into
+// invalid messages. Read errors of the body are returned as they are.
+type decodingReader struct {
+	r        io.Reader
+	body     *bodyReader
+	encoding string
+	// close releases the decompressor once the stream ended
+	close func()
+}
+
+func (r *decodingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == nil {
+		return n, nil
+	}
+	r.close()
+	if err != io.EOF {
+		err = r.body.decodeError(r.encoding, err)
+	}
+	return n, err
+}
@@ -0,0 +1,183 @@
+package session
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	u "notary/utils"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func gzipped(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBlobDecoder(t *testing.T) {
+	for encoding, supported := range map[string]bool{"": true, "identity": true, "gzip": true, "x-gzip": true, "zstd": true, "br": false} {
+		if BlobEncodingSupported(encoding) != supported {
+			t.Errorf("%q: expected supported=%v", encoding, supported)
+		}
+	}
+
+	// a client may send one gzip member per chunk
+	body := append(gzipped(t, []byte("first chunk ")), gzipped(t, []byte("second chunk"))...)
+	r, err := blobDecoder(bytes.NewReader(body), "gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil || string(out) != "first chunk second chunk" {
+		t.Fatal("unexpected blob", string(out), err)
+	}
+
+	corrupt := gzipped(t, bytes.Repeat([]byte("truth tables"), 100))
+	corrupt[len(corrupt)-5] ^= 1
+	r, err = blobDecoder(bytes.NewReader(corrupt), "gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadAll(r); !errors.Is(err, ErrInvalidMessage) {
+		t.Error("a corrupted stream must be an invalid message", err)
+	}
+	if _, err = blobDecoder(bytes.NewReader([]byte("this is not a gzip stream")), "gzip"); !errors.Is(err, ErrInvalidMessage) {
+		t.Error("a stream without gzip header must be an invalid message", err)
+	}
+
+	truncated := gzipped(t, bytes.Repeat([]byte("truth tables"), 100))
+	for _, encoding := range []string{"gzip", "zstd"} {
+		if encoding == "zstd" {
+			truncated = zstdCompressed(t, bytes.Repeat([]byte("truth tables"), 100))
+		}
+		r, err = blobDecoder(bytes.NewReader(truncated[:len(truncated)/2]), encoding)
+		if err == nil {
+			_, err = io.ReadAll(r)
+		}
+		if !errors.Is(err, ErrInvalidMessage) {
+			t.Error("a truncated stream must be an invalid message", encoding, err)
+		}
+	}
+
+	// a failed read of the body is not the client's fault
+	broken := io.MultiReader(bytes.NewReader(corrupt[:20]), iotest.ErrReader(errors.New("connection reset")))
+	if r, err = blobDecoder(broken, "gzip"); err == nil {
+		_, err = io.ReadAll(r)
+	}
+	if err == nil || errors.Is(err, ErrInvalidMessage) {
+		t.Error("a read error must be returned as it is", err)
+	}
+}
+
+func zstdCompressed(t *testing.T, data []byte) []byte {
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zw.Close()
+	return zw.EncodeAll(data, nil)
+}
+
+func TestZstdBlobDecoder(t *testing.T) {
+	// a client may send one frame per chunk
+	body := append(zstdCompressed(t, []byte("first chunk ")), zstdCompressed(t, []byte("second chunk"))...)
+	r, err := blobDecoder(bytes.NewReader(body), "zstd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil || string(out) != "first chunk second chunk" {
+		t.Fatal("unexpected blob", string(out), err)
+	}
+
+	r, err = blobDecoder(bytes.NewReader([]byte("this is not a zstd stream")), "zstd")
+	if err == nil {
+		_, err = io.ReadAll(r)
+	}
+	if !errors.Is(err, ErrInvalidMessage) {
+		t.Error("a stream without zstd header must be an invalid message", err)
+	}
+
+	// the limit applies to the decompressed blob
+	s := &Session{StorageDir: t.TempDir(), blobSize: 16}
+	s.fsm.advance(stepOf("init"))
+	if _, err = s.SetBlob(io.NopCloser(bytes.NewReader(zstdCompressed(t, make([]byte, 1000)))), "zstd"); !errors.Is(err, ErrBlobTooLarge) {
+		t.Fatal("unexpected error", err)
+	}
+	s = &Session{StorageDir: t.TempDir(), blobSize: 16}
+	s.fsm.advance(stepOf("init"))
+	if _, err = s.SetBlob(io.NopCloser(bytes.NewReader(zstdCompressed(t, make([]byte, 16)))), "zstd"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.checkBlobComplete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetBlobTooLarge(t *testing.T) {
+	s := &Session{StorageDir: t.TempDir(), blobSize: 16}
+	s.fsm.advance(stepOf("init"))
+	_, err := s.SetBlob(io.NopCloser(bytes.NewReader(make([]byte, 17))), "")
+	if !errors.Is(err, ErrBlobTooLarge) {
+		t.Fatal("unexpected error", err)
+	}
+	if _, err = os.Stat(filepath.Join(s.StorageDir, "blobForNotary")); !os.IsNotExist(err) {
+		t.Error("the partial blob must be deleted")
+	}
+
+	// the limit applies to the decompressed blob
+	s = &Session{StorageDir: t.TempDir(), blobSize: 16}
+	s.fsm.advance(stepOf("init"))
+	if _, err = s.SetBlob(io.NopCloser(bytes.NewReader(gzipped(t, make([]byte, 1000)))), "gzip"); !errors.Is(err, ErrBlobTooLarge) {
+		t.Fatal("unexpected error", err)
+	}
+}
+
+func TestCheckBlobComplete(t *testing.T) {
+	s := &Session{StorageDir: t.TempDir(), blobSize: 16}
+	if err := s.checkBlobComplete(); !errors.Is(err, ErrBlobIncomplete) {
+		t.Fatal("a missing blob must be incomplete", err)
+	}
+	s.fsm.advance(stepOf("init"))
+	if _, err := s.SetBlob(io.NopCloser(bytes.NewReader(make([]byte, 15))), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.checkBlobComplete(); !errors.Is(err, ErrBlobIncomplete) {
+		t.Fatal("a short blob must be incomplete", err)
+	}
+	f, err := os.OpenFile(filepath.Join(s.StorageDir, "blobForNotary"), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0})
+	f.Close()
+	if err := s.checkBlobComplete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestUploadProgressBeforeBlob checks getUploadProgress after a setBlob
+// which failed before it read the body
+func TestUploadProgressBeforeBlob(t *testing.T) {
+	s := &Session{StorageDir: t.TempDir(), blobSize: 16, notaryKey: make([]byte, 16)}
+	s.fsm.advance(stepOf("init"))
+	if _, err := s.SetBlob(io.NopCloser(bytes.NewReader([]byte("this is not a gzip stream"))), "gzip"); !errors.Is(err, ErrInvalidMessage) {
+		t.Fatal("unexpected error", err)
+	}
+	progress, err := s.GetUploadProgress(nil)
+	if err != nil || !bytes.Equal(u.AESGCMdecrypt(s.notaryKey, progress), make([]byte, 4)) {
+		t.Fatal("unexpected progress", err)
+	}
+}
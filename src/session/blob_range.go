@@ -0,0 +1,89 @@
+package session
+
+import (
+	"errors"
+	"io"
+	"sort"
+)
+
+// Blob is the notary's truth tables of the session as getBlob sends them:
+// the files of circuits 1 to 7 concatenated. It holds a reference to each
+// file until Release, see TtFile.
+type Blob struct {
+	files []*TtFile
+	// starts are the offsets of the files in the blob
+	starts []int64
+	size   int64
+	// CircuitOffsets are the offsets of the truth tables of circuits 1 to 7
+	// in the blob, so that a client can download circuits in parallel
+	CircuitOffsets []int64
+}
+
+// acquireBlob takes a reference to each truth table file of the session
+func (s *Session) acquireBlob() (*Blob, error) {
+	b := new(Blob)
+	for i := 1; i < len(s.Tt); i++ {
+		b.CircuitOffsets = append(b.CircuitOffsets, b.size)
+		for _, f := range s.Tt[i] {
+			if !f.Acquire() {
+				b.Release()
+				return nil, errors.New("truth tables requested after the session was removed")
+			}
+			b.files = append(b.files, f)
+			info, err := f.f.Stat()
+			if err != nil {
+				b.Release()
+				return nil, err
+			}
+			b.starts = append(b.starts, b.size)
+			b.size += info.Size()
+		}
+	}
+	return b, nil
+}
+
+// GetBlobRange returns the blob for a getBlob with a Range header. Unlike
+// getBlob it may be sent any number of times until c1_step1, so that a
+// client can resume an interrupted download or download parts of the blob
+// in parallel.
+func (s *Session) GetBlobRange() (*Blob, error) {
+	if _, _, err := s.beginStep(stepOf("getBlobRange"), nil); err != nil {
+		return nil, err
+	}
+	return s.acquireBlob()
+}
+
+// Size returns the size of the blob in bytes
+func (b *Blob) Size() int64 {
+	return b.size
+}
+
+// ReadAt reads the blob at off across the files. The blob must not be
+// released yet.
+func (b *Blob) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	// the last file which starts at or before off
+	i := sort.Search(len(b.starts), func(i int) bool { return b.starts[i] > off }) - 1
+	n := 0
+	for ; i >= 0 && i < len(b.files) && n < len(p); i++ {
+		m, err := b.files[i].f.ReadAt(p[n:], off+int64(n)-b.starts[i])
+		n += m
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Release drops the references to the files
+func (b *Blob) Release() {
+	for _, f := range b.files {
+		f.Release()
+	}
+	b.files = nil
+}
@@ -0,0 +1,87 @@
+package session
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestBlobSession(t *testing.T, contents ...[]string) *Session {
+	s := &Session{Tt: make([][]*TtFile, len(contents)+1)}
+	for i, files := range contents {
+		for _, content := range files {
+			path := filepath.Join(t.TempDir(), "tt")
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.Tt[i+1] = append(s.Tt[i+1], newTtFile(f))
+		}
+	}
+	return s
+}
+
+func TestBlobReadAt(t *testing.T) {
+	s := newTestBlobSession(t, []string{"abc"}, []string{"de", "", "fgh"}, []string{"ij"})
+	s.fsm.advance(stepOf("init"))
+	blob, err := s.GetBlob(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blob.Release()
+	if blob.Size() != 10 || len(blob.CircuitOffsets) != 3 || blob.CircuitOffsets[1] != 3 || blob.CircuitOffsets[2] != 8 {
+		t.Fatal("unexpected blob", blob.Size(), blob.CircuitOffsets)
+	}
+	all, err := io.ReadAll(io.NewSectionReader(blob, 0, blob.Size()))
+	if err != nil || string(all) != "abcdefghij" {
+		t.Fatalf("read %q, %v", all, err)
+	}
+	// a range across the files of several circuits
+	p := make([]byte, 6)
+	if n, err := blob.ReadAt(p, 2); n != 6 || err != nil || string(p) != "cdefgh" {
+		t.Fatalf("read %q, %v", p[:n], err)
+	}
+	if n, err := blob.ReadAt(p, 7); n != 3 || err != io.EOF || string(p[:n]) != "hij" {
+		t.Fatalf("read %q, %v", p[:n], err)
+	}
+	if n, err := blob.ReadAt(p, 10); n != 0 || err != io.EOF {
+		t.Fatal("unexpected result past the end", n, err)
+	}
+}
+
+func TestGetBlobRange(t *testing.T) {
+	s := newTestBlobSession(t, []string{"abc"})
+	if _, err := s.GetBlobRange(); !errors.Is(err, ErrOutOfOrder) {
+		t.Fatal("a range request must be rejected before init", err)
+	}
+	s.fsm.advance(stepOf("init"))
+	// a range request may be repeated, also after getBlob
+	for i := 0; i < 2; i++ {
+		blob, err := s.GetBlobRange()
+		if err != nil {
+			t.Fatal(err)
+		}
+		blob.Release()
+	}
+	s.fsm.advance(stepOf("getBlob"))
+	blob, err := s.GetBlobRange()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the files stay open while the blob is read after the session released
+	// them
+	s.ReleaseTt(nil)
+	if _, err = s.GetBlobRange(); err == nil {
+		t.Fatal("the blob must not be acquired after the session released it")
+	}
+	p := make([]byte, 3)
+	if _, err = blob.ReadAt(p, 0); err != nil || string(p) != "abc" {
+		t.Fatalf("read %q, %v", p, err)
+	}
+	blob.Release()
+}
@@ -0,0 +1,104 @@
+package session
+
+import (
+	"errors"
+	"time"
+)
+
+// CHECKPOINT_AFTER is the step after which a session can be checkpointed.
+// The state of the earlier steps lives in the garbler, the evaluator, the
+// Paillier 2PC and the OT manager, which can't be persisted. From here on
+// only the key shares and the tag verification state are needed.
+const CHECKPOINT_AFTER = "commitHash"
+
+// ErrNotResumable is returned when a checkpoint can't be restored
+var ErrNotResumable = errors.New("the session can't be resumed")
+
+// Checkpoint is the state of a session which survives a restart of the
+// notary, see Session.Checkpoint. It holds secrets: the notary's shares of
+// the server write key and IV must never reach the client.
+type Checkpoint struct {
+	Sid             string    `json:"sid"`
+	ProtocolVersion int       `json:"protocolVersion"`
+	StartTime       time.Time `json:"startTime"`
+	ClientKey       []byte    `json:"clientKey"`
+	NotaryKey       []byte    `json:"notaryKey"`
+	ToClientSeq     uint64    `json:"toClientSeq"`
+	FrameSize       int       `json:"frameSize"`
+	SwkShare        []byte    `json:"swkShare"`
+	SivShare        []byte    `json:"sivShare"`
+	// Received are the ORDERED steps received so far, in the order of
+	// Protocol
+	Received []string `json:"received"`
+	// TagMasks, PohMask and RecordIvs are set once the tag verification
+	// MPCs completed. Otherwise the client repeats prepTagVerification.
+	TagMasks  []string `json:"tagMasks,omitempty"`
+	PohMask   string   `json:"pohMask,omitempty"`
+	RecordIvs [][]byte `json:"recordIvs,omitempty"`
+}
+
+// Checkpoint returns the state of the session if it can be restored after a
+// restart, i.e. once CHECKPOINT_AFTER was received
+func (s *Session) Checkpoint() (*Checkpoint, bool) {
+	if !s.fsm.hasReceived(CHECKPOINT_AFTER) {
+		return nil, false
+	}
+	c := &Checkpoint{
+		Sid:             s.Sid,
+		ProtocolVersion: s.ProtocolVersion,
+		StartTime:       s.startTime,
+		ClientKey:       s.clientKey,
+		NotaryKey:       s.notaryKey,
+		ToClientSeq:     s.toClientSeq,
+		FrameSize:       s.frameSize,
+		SwkShare:        s.swkShare,
+		SivShare:        s.sivShare,
+	}
+	for _, step := range Protocol {
+		if s.fsm.hasReceived(step.Command) {
+			c.Received = append(c.Received, step.Command)
+		}
+	}
+	if len(s.tagMasks) != 0 {
+		c.TagMasks = s.tagMasks
+		c.PohMask = s.pohMask
+		c.RecordIvs = s.recordIvs
+	}
+	return c, true
+}
+
+// Restore sets the state of a new session from c. The session manager sets
+// the remaining fields as for a new session. The phase SLA starts again with
+// the next phase.
+func (s *Session) Restore(c *Checkpoint) error {
+	if c.ProtocolVersion > PROTOCOL_LATEST || len(c.SwkShare) == 0 || len(c.SivShare) == 0 {
+		return ErrNotResumable
+	}
+	received := make(map[string]bool)
+	for _, command := range c.Received {
+		if _, ok := stepsByCommand[command]; !ok {
+			return ErrNotResumable
+		}
+		received[command] = true
+	}
+	if !received[CHECKPOINT_AFTER] {
+		return ErrNotResumable
+	}
+	if len(c.TagMasks) != len(c.RecordIvs) {
+		return ErrNotResumable
+	}
+	s.Sid = c.Sid
+	s.ProtocolVersion = c.ProtocolVersion
+	s.startTime = c.StartTime
+	s.clientKey = c.ClientKey
+	s.notaryKey = c.NotaryKey
+	s.toClientSeq = c.ToClientSeq
+	s.frameSize = c.FrameSize
+	s.swkShare = c.SwkShare
+	s.sivShare = c.SivShare
+	s.fsm = protocolFSM{received: received}
+	s.tagMasks = c.TagMasks
+	s.pohMask = c.PohMask
+	s.recordIvs = c.RecordIvs
+	return nil
+}
@@ -0,0 +1,41 @@
+package session
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCheckpointRestore(t *testing.T) {
+	s := &Session{Sid: "sid", ProtocolVersion: PROTOCOL_LATEST, swkShare: []byte{1}, sivShare: []byte{2}, clientKey: []byte{3}}
+	if _, ok := s.Checkpoint(); ok {
+		t.Fatal("a session before commitHash can't be checkpointed")
+	}
+	s.fsm = protocolFSM{received: map[string]bool{CHECKPOINT_AFTER: true, "init": true, "ghash_step3": true}}
+	c, ok := s.Checkpoint()
+	if !ok {
+		t.Fatal("a session after commitHash must be checkpointed")
+	}
+	if strings.Join(c.Received, ",") != "init,ghash_step3,"+CHECKPOINT_AFTER {
+		t.Fatal("the steps must be in protocol order", c.Received)
+	}
+
+	restored := new(Session)
+	if err := restored.Restore(c); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Sid != "sid" || !bytes.Equal(restored.swkShare, s.swkShare) || !bytes.Equal(restored.clientKey, s.clientKey) {
+		t.Fatal("unexpected restored session")
+	}
+	if err := restored.fsm.advance(stepOf(CHECKPOINT_AFTER)); err == nil {
+		t.Fatal("the restored session must reject a second commitHash")
+	}
+	if err := restored.fsm.advance(stepOf("tagVerification")); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Received = []string{"ghash_step3"}
+	if new(Session).Restore(c) != ErrNotResumable {
+		t.Fatal("a checkpoint before commitHash must be rejected")
+	}
+}
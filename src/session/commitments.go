@@ -0,0 +1,125 @@
+package session
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Purposes of typed commitments. Purposes unknown to the notary are still
+// signed, the notary doesn't interpret the committed values.
+const (
+	COMMITMENT_RESPONSE_MERKLE_ROOT = 1
+	COMMITMENT_HEADERS              = 2
+	COMMITMENT_TIMESTAMP            = 3
+	// COMMITMENT_REQUEST_BLOCKS is the Merkle root of the salted 16-byte
+	// blocks of the request, which lets the client disclose parts of the
+	// request later, see package attestation
+	COMMITMENT_REQUEST_BLOCKS = 4
+)
+
+// Algorithms of typed commitments
+const (
+	COMMITMENT_ALG_SHA256 = 1
+	// COMMITMENT_ALG_BLOCK_MERKLE_SHA256 is blockCount(4) | root(32) of
+	// the Merkle tree of COMMITMENT_REQUEST_BLOCKS
+	COMMITMENT_ALG_BLOCK_MERKLE_SHA256 = 2
+)
+
+// maxCommitments is the max amount of typed commitments in commitHash
+const maxCommitments = 16
+
+// commitmentSizes maps each known algorithm to the size of its values. A
+// commitment with an unknown algorithm is rejected.
+var commitmentSizes = map[byte]int{
+	COMMITMENT_ALG_SHA256:              32,
+	COMMITMENT_ALG_BLOCK_MERKLE_SHA256: 36,
+}
+
+// Commitment is a value the client commits to, tagged with what it commits
+// to (purpose) and how it was computed (algorithm)
+type Commitment struct {
+	Purpose   byte
+	Algorithm byte
+	Value     []byte
+}
+
+// ParseCommitments parses a list of typed commitments. The format is:
+// count(1) followed by count * (purpose(1) | algorithm(1) | length(2) | value).
+// Each purpose may occur only once.
+func ParseCommitments(b []byte) ([]Commitment, error) {
+	if len(b) < 1 {
+		return nil, errors.New("commitment list is empty")
+	}
+	count := int(b[0])
+	if count > maxCommitments {
+		return nil, fmt.Errorf("too many commitments: %d", count)
+	}
+	o := 1
+	seen := make(map[byte]bool)
+	list := make([]Commitment, 0, count)
+	for i := 0; i < count; i++ {
+		if len(b) < o+4 {
+			return nil, errors.New("commitment list is truncated")
+		}
+		c := Commitment{Purpose: b[o], Algorithm: b[o+1]}
+		size := int(binary.BigEndian.Uint16(b[o+2 : o+4]))
+		o += 4
+		expected, ok := commitmentSizes[c.Algorithm]
+		if !ok {
+			return nil, fmt.Errorf("unknown commitment algorithm %d", c.Algorithm)
+		}
+		if size != expected {
+			return nil, fmt.Errorf("commitment with algorithm %d must be %d bytes", c.Algorithm, expected)
+		}
+		if seen[c.Purpose] {
+			return nil, fmt.Errorf("duplicate commitment purpose %d", c.Purpose)
+		}
+		seen[c.Purpose] = true
+		if len(b) < o+size {
+			return nil, errors.New("commitment list is truncated")
+		}
+		c.Value = b[o : o+size]
+		o += size
+		list = append(list, c)
+	}
+	if o != len(b) {
+		return nil, errors.New("trailing bytes after commitment list")
+	}
+	return list, nil
+}
+
+// checkRequestCommitment checks that a request commitment in list uses its
+// algorithm and commits to as many blocks as the request of recordCount TLS
+// records has, whose c6Count AES blocks the notary encrypted. The last block
+// of each record may be shorter, so the blocks of the whole request may be
+// up to recordCount-1 fewer. The notary can't check the root.
+func checkRequestCommitment(list []Commitment, c6Count int, recordCount int) error {
+	for _, c := range list {
+		isRequest := c.Purpose == COMMITMENT_REQUEST_BLOCKS
+		if isRequest != (c.Algorithm == COMMITMENT_ALG_BLOCK_MERKLE_SHA256) {
+			return fmt.Errorf("commitment purpose %d can't use algorithm %d", c.Purpose, c.Algorithm)
+		}
+		if !isRequest {
+			continue
+		}
+		blocks := int(binary.BigEndian.Uint32(c.Value[:4]))
+		if blocks > c6Count || blocks < c6Count-recordCount+1 {
+			return fmt.Errorf("the request commitment has %d blocks, the request has %d in %d records", blocks, c6Count, recordCount)
+		}
+	}
+	return nil
+}
+
+// EncodeCommitments is the inverse of ParseCommitments
+func EncodeCommitments(list []Commitment) []byte {
+	out := []byte{byte(len(list))}
+	for _, c := range list {
+		size := make([]byte, 2)
+		binary.BigEndian.PutUint16(size, uint16(len(c.Value)))
+		out = append(out, c.Purpose, c.Algorithm)
+		out = append(out, size...)
+		out = append(out, c.Value...)
+	}
+	return out
+}
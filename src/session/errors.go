@@ -0,0 +1,64 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The session methods return errors for messages which an honest client
+// never sends, e.g. ErrInvalidMessage wrapped with details. They panic only
+// on bugs of the notary. Either way the session is destroyed.
+var (
+	// ErrInvalidMessage is returned for malformed messages, e.g. with a
+	// wrong size
+	ErrInvalidMessage = errors.New("invalid message")
+	// ErrOutOfOrder is returned for messages which were sent twice or
+	// before the messages they depend on
+	ErrOutOfOrder = errors.New("message out of order")
+	// ErrBlobTooLarge is returned for a session whose blob would be, or an
+	// upload which is, larger than the limit
+	ErrBlobTooLarge = errors.New("blob too large")
+	// ErrBlobIncomplete is returned when the session needs the blob but
+	// fewer bytes than the session's circuits have were uploaded
+	ErrBlobIncomplete = errors.New("blob incomplete")
+	// ErrChunkRejected is returned for a chunk of the blob which wasn't
+	// appended, see ChunkError. Unlike the other errors it doesn't destroy
+	// the session.
+	ErrChunkRejected = errors.New("blob chunk rejected")
+)
+
+// invalidMessage returns ErrInvalidMessage with the problem
+func invalidMessage(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidMessage, fmt.Sprintf(format, args...))
+}
+
+// blobTooLarge returns ErrBlobTooLarge with the problem
+func blobTooLarge(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrBlobTooLarge, fmt.Sprintf(format, args...))
+}
+
+// blobIncomplete returns ErrBlobIncomplete with the problem
+func blobIncomplete(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrBlobIncomplete, fmt.Sprintf(format, args...))
+}
+
+// outOfOrder returns ErrOutOfOrder with the problem
+func outOfOrder(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrOutOfOrder, fmt.Sprintf(format, args...))
+}
+
+// checkSize returns ErrInvalidMessage unless body has size bytes
+func checkSize(body []byte, size int) error {
+	if len(body) != size {
+		return invalidMessage("expected %d bytes, got %d", size, len(body))
+	}
+	return nil
+}
+
+// checkMinSize returns ErrInvalidMessage if body has less than size bytes
+func checkMinSize(body []byte, size int) error {
+	if len(body) < size {
+		return invalidMessage("expected at least %d bytes, got %d", size, len(body))
+	}
+	return nil
+}
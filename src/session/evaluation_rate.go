@@ -0,0 +1,32 @@
+package session
+
+import (
+	"expvar"
+	"time"
+)
+
+var (
+	// evaluatedBytes counts the bytes of the clients' truth tables which the
+	// notary evaluated, evaluationNanoseconds the time it took
+	evaluatedBytes        = expvar.NewInt("evaluated_bytes")
+	evaluationNanoseconds = expvar.NewInt("evaluation_nanoseconds")
+)
+
+// countEvaluation adds the evaluation of size bytes of truth tables which
+// started at start
+func countEvaluation(size int, start time.Time) {
+	evaluationNanoseconds.Add(int64(time.Since(start)))
+	evaluatedBytes.Add(int64(size))
+}
+
+// EvaluationRate returns the bytes of truth tables per second which the
+// notary evaluated since it started, or 0 before the first evaluation. It
+// lets clients estimate the duration of a session on this notary's
+// hardware.
+func EvaluationRate() float64 {
+	nanoseconds := evaluationNanoseconds.Value()
+	if nanoseconds == 0 {
+		return 0
+	}
+	return float64(evaluatedBytes.Value()) / time.Duration(nanoseconds).Seconds()
+}
@@ -0,0 +1,137 @@
+package session
+
+import "fmt"
+
+// Ordering says how the state machine of the session checks a step
+type Ordering int
+
+const (
+	// ORDERED steps are received once, after the ORDERED step preceding
+	// them in Protocol
+	ORDERED Ordering = iota
+	// UNCHECKED steps may be sent any time and any number of times
+	UNCHECKED
+	// REPEATABLE steps may be sent any number of times once their After
+	// step was received and until their Until step was received
+	REPEATABLE
+)
+
+// Violation is the sequence rule a message broke
+type Violation int
+
+const (
+	// VIOLATION_REPEATED is an ORDERED step which was already received
+	VIOLATION_REPEATED Violation = iota
+	// VIOLATION_MISSING is a step whose preceding step wasn't received
+	VIOLATION_MISSING
+	// VIOLATION_CLOSED is a REPEATABLE step after its window closed
+	VIOLATION_CLOSED
+)
+
+// SequenceError is returned for a message which the protocol doesn't allow
+// in the current state of the session. It wraps ErrOutOfOrder.
+type SequenceError struct {
+	Command   string
+	Violation Violation
+	// Other is the step the violated rule refers to: the missing preceding
+	// step or the step which closed the window
+	Other string
+}
+
+func (e *SequenceError) Error() string {
+	switch e.Violation {
+	case VIOLATION_REPEATED:
+		return fmt.Sprintf("%s: %s was sent twice", ErrOutOfOrder, e.Command)
+	case VIOLATION_MISSING:
+		return fmt.Sprintf("%s: %s was sent before %s", ErrOutOfOrder, e.Command, e.Other)
+	}
+	return fmt.Sprintf("%s: %s was sent after %s", ErrOutOfOrder, e.Command, e.Other)
+}
+
+func (e *SequenceError) Unwrap() error {
+	return ErrOutOfOrder
+}
+
+// transitions are the steps after which an ORDERED step is allowed, by
+// command. Entry steps have none. The steps are derived from the order of
+// Protocol: the preceding ORDERED step and, if that one is Optional, also
+// the steps which the optional step may follow. A merged step has those of
+// the first step it merges.
+var transitions = deriveTransitions(Protocol)
+
+func deriveTransitions(protocol []Step) map[string][]string {
+	allowed := make(map[string][]string)
+	var prev []string
+	for _, step := range protocol {
+		if step.Ordering != ORDERED {
+			continue
+		}
+		if len(step.Merges) > 0 {
+			// merged steps are listed after the steps they merge and are
+			// no predecessor of the steps after them
+			allowed[step.Command] = allowed[step.Merges[0]]
+			continue
+		}
+		if !step.Entry {
+			allowed[step.Command] = prev
+		}
+		if step.Optional {
+			prev = append([]string{step.Command}, prev...)
+		} else {
+			prev = []string{step.Command}
+		}
+	}
+	return allowed
+}
+
+// protocolFSM is the state of a session's protocol: the ORDERED steps
+// received so far. The zero value is the state before init.
+type protocolFSM struct {
+	received map[string]bool
+}
+
+// advance checks that step is allowed in the current state and records it
+func (f *protocolFSM) advance(step Step) error {
+	switch step.Ordering {
+	case UNCHECKED:
+		return nil
+	case REPEATABLE:
+		if !f.received[step.After] {
+			return &SequenceError{step.Command, VIOLATION_MISSING, step.After}
+		}
+		if f.received[step.Until] {
+			return &SequenceError{step.Command, VIOLATION_CLOSED, step.Until}
+		}
+		// repeatable steps don't change the state
+		return nil
+	}
+	if f.received[step.Command] || f.receivedAny(step.Merges) {
+		return &SequenceError{step.Command, VIOLATION_REPEATED, ""}
+	}
+	if prev, ok := transitions[step.Command]; ok && !f.receivedAny(prev) {
+		return &SequenceError{step.Command, VIOLATION_MISSING, prev[0]}
+	}
+	if f.received == nil {
+		f.received = make(map[string]bool)
+	}
+	f.received[step.Command] = true
+	// the steps after a merged step follow the steps it merges
+	for _, command := range step.Merges {
+		f.received[command] = true
+	}
+	return nil
+}
+
+// hasReceived is true if the ORDERED step command was received
+func (f *protocolFSM) hasReceived(command string) bool {
+	return f.received[command]
+}
+
+func (f *protocolFSM) receivedAny(commands []string) bool {
+	for _, command := range commands {
+		if f.received[command] {
+			return true
+		}
+	}
+	return false
+}
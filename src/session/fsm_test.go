@@ -0,0 +1,104 @@
+package session
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTransitions(t *testing.T) {
+	if _, ok := transitions["init"]; ok {
+		t.Error("init is an entry step")
+	}
+	if prev := transitions["step1"]; len(prev) != 1 || prev[0] != "setBlob" {
+		t.Error("unexpected transitions of step1", prev)
+	}
+	// ghash_step2 is optional
+	if prev := transitions["ghash_step3"]; len(prev) != 2 || prev[0] != "ghash_step2" || prev[1] != "ghash_step1" {
+		t.Error("unexpected transitions of ghash_step3", prev)
+	}
+	if prev := transitions["commitHash"]; len(prev) != 1 || prev[0] != "ghash_step3" {
+		t.Error("unexpected transitions of commitHash", prev)
+	}
+	// merged steps follow the step before the first one they merge and
+	// don't precede any step
+	if prev := transitions["c1_step5_c2_step1"]; len(prev) != 1 || prev[0] != "c1_step4" {
+		t.Error("unexpected transitions of c1_step5_c2_step1", prev)
+	}
+	if prev := transitions["c3_step1"]; len(prev) != 1 || prev[0] != "c2_step4" {
+		t.Error("unexpected transitions of c3_step1", prev)
+	}
+}
+
+func TestProtocolFSM(t *testing.T) {
+	accepts := func(received []string, command string) (bool, Violation) {
+		f := protocolFSM{received: make(map[string]bool)}
+		for _, c := range received {
+			f.received[c] = true
+		}
+		err := f.advance(stepOf(command))
+		if err == nil {
+			return true, 0
+		}
+		var sequenceErr *SequenceError
+		if !errors.As(err, &sequenceErr) || !errors.Is(err, ErrOutOfOrder) {
+			t.Fatal("unexpected error", err)
+		}
+		return false, sequenceErr.Violation
+	}
+	for _, tc := range []struct {
+		received  []string
+		command   string
+		accepted  bool
+		violation Violation
+	}{
+		{nil, "init", true, 0},
+		{nil, "setBlob", true, 0},
+		{[]string{"init"}, "init", false, VIOLATION_REPEATED},
+		{[]string{"init", "setBlob"}, "step1", true, 0},
+		{[]string{"init"}, "step2", false, VIOLATION_MISSING},
+		{[]string{"init", "setBlob"}, "getUploadProgress", true, 0},
+		{[]string{"init"}, "getUploadProgress", false, VIOLATION_MISSING},
+		{[]string{"init", "setBlob", "step1", "step2", "step3", "step4", "c1_step1"}, "getUploadProgress", false, VIOLATION_CLOSED},
+		{nil, "getOtProgress", true, 0},
+		{[]string{"c7_step2", "ghash_step1"}, "ghash_step3", true, 0},
+		{[]string{"c7_step2"}, "ghash_step3", false, VIOLATION_MISSING},
+		{[]string{"c7_step2", "ghash_step1"}, "commitHash", false, VIOLATION_MISSING},
+		{[]string{"step3"}, "step4_c1_step1", true, 0},
+		{[]string{"step3", "step4"}, "step4_c1_step1", false, VIOLATION_REPEATED},
+		{[]string{"c1_step4"}, "c1_step5_c2_step1", true, 0},
+		{[]string{"c1_step3"}, "c1_step5_c2_step1", false, VIOLATION_MISSING},
+		{[]string{"c1_step4", "c1_step5", "c2_step1"}, "c1_step5_c2_step1", false, VIOLATION_REPEATED},
+	} {
+		accepted, violation := accepts(tc.received, tc.command)
+		if accepted != tc.accepted || violation != tc.violation {
+			t.Errorf("received %v, %s: expected accepted=%v violation=%d", tc.received, tc.command, tc.accepted, tc.violation)
+		}
+	}
+}
+
+func TestProtocolFSMRepeatable(t *testing.T) {
+	var f protocolFSM
+	for _, command := range []string{"init", "setBlob", "getUploadProgress", "getUploadProgress", "step1"} {
+		if err := f.advance(stepOf(command)); err != nil {
+			t.Fatal(command, err)
+		}
+	}
+	if f.hasReceived("getUploadProgress") {
+		t.Error("repeatable steps must not change the state")
+	}
+}
+
+func TestProtocolFSMMerged(t *testing.T) {
+	var f protocolFSM
+	for _, command := range []string{"init", "setBlob", "step1", "step2", "step3", "step4_c1_step1", "c1_step2"} {
+		if err := f.advance(stepOf(command)); err != nil {
+			t.Fatal(command, err)
+		}
+	}
+	if !f.hasReceived("step4") || !f.hasReceived("c1_step1") {
+		t.Error("a merged step must count as the steps it merges")
+	}
+	if err := f.advance(stepOf("c1_step1")); !errors.Is(err, ErrOutOfOrder) {
+		t.Error("a merged step must not be repeated by its parts", err)
+	}
+}
@@ -0,0 +1,51 @@
+package session
+
+import (
+	u "notary/utils"
+)
+
+// HANDOFF_NONCE_SIZE is the size of the nonce which binds a handoff token
+// to the session
+const HANDOFF_NONCE_SIZE = 16
+
+// handoffAllowed is true as long as no OT-dependent step was received. The
+// OT connection can't be moved to another client instance once the circuits
+// started using it.
+func (s *Session) handoffAllowed() bool {
+	return s.clientKey != nil && !s.fsm.hasReceived("c1_step1")
+}
+
+// PrepareHandoff is called when the client exports a handoff token. The
+// body must be encrypted with the session's client key, which proves that
+// the request comes from the client. It returns the nonce which the session
+// manager seals into the token. Exporting again invalidates older tokens.
+func (s *Session) PrepareHandoff(encrypted []byte) ([]byte, error) {
+	if _, err := s.decryptFromClient(encrypted); err != nil {
+		return nil, err
+	}
+	if !s.handoffAllowed() {
+		return nil, outOfOrder("handoff requested after OT-dependent steps")
+	}
+	s.handoffNonce = u.GetRandom(HANDOFF_NONCE_SIZE)
+	return s.handoffNonce, nil
+}
+
+// ConsumeHandoff checks that nonce belongs to the latest exported token and
+// that the session can still be handed off. A token can be used only once.
+func (s *Session) ConsumeHandoff(nonce []byte) bool {
+	if s.handoffNonce == nil || !u.ConstantTimeEqual(nonce, s.handoffNonce) {
+		return false
+	}
+	s.handoffNonce = nil
+	return s.handoffAllowed()
+}
+
+// ResumeResponse tells the resuming client how to connect for OT, in the
+// same format as the response to init
+func (s *Session) ResumeResponse() ([]byte, error) {
+	route, err := s.otRoute()
+	if err != nil {
+		return nil, err
+	}
+	return s.encryptToClient(route), nil
+}
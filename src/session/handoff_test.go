@@ -0,0 +1,46 @@
+package session
+
+import (
+	"errors"
+	u "notary/utils"
+	"testing"
+)
+
+func TestHandoff(t *testing.T) {
+	s := &Session{clientKey: u.GetRandom(16)}
+	s.fsm.received = map[string]bool{"init": true, "setBlob": true, "step1": true}
+	nonce, err := s.PrepareHandoff(u.AESGCMencrypt(s.clientKey, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ConsumeHandoff(u.GetRandom(HANDOFF_NONCE_SIZE)) {
+		t.Error("wrong nonce accepted")
+	}
+	if !s.ConsumeHandoff(nonce) {
+		t.Fatal("handoff rejected")
+	}
+	if s.ConsumeHandoff(nonce) {
+		t.Error("token used twice")
+	}
+
+	// exporting again invalidates the older token
+	old, _ := s.PrepareHandoff(u.AESGCMencrypt(s.clientKey, nil))
+	latest, _ := s.PrepareHandoff(u.AESGCMencrypt(s.clientKey, nil))
+	if s.ConsumeHandoff(old) {
+		t.Error("old token accepted")
+	}
+
+	// once OT-dependent steps started, the session can't be handed off
+	for _, command := range []string{"step2", "step3", "step4", "c1_step1"} {
+		s.fsm.received[command] = true
+	}
+	if s.ConsumeHandoff(latest) {
+		t.Error("handoff accepted after c1_step1")
+	}
+	if _, err = s.PrepareHandoff(u.AESGCMencrypt(s.clientKey, nil)); !errors.Is(err, ErrOutOfOrder) {
+		t.Error("export after c1_step1 must fail", err)
+	}
+	if _, err = s.PrepareHandoff(u.GetRandom(40)); !errors.Is(err, ErrInvalidMessage) {
+		t.Error("export with a body not from the client must fail", err)
+	}
+}
@@ -0,0 +1,91 @@
+package session
+
+import (
+	"expvar"
+	"sync"
+	"sync/atomic"
+)
+
+// The categories of memory held by a session
+const (
+	// MEM_LABELS are the garbler's input labels
+	MEM_LABELS = "labels"
+	// MEM_ENCODED_OUTPUTS are the evaluator's encoded outputs and the check
+	// values derived from them
+	MEM_ENCODED_OUTPUTS = "encodedOutputs"
+	// MEM_DECODING_TABLES are the decoding tables of the garbled circuits
+	MEM_DECODING_TABLES = "decodingTables"
+	// MEM_BLOB_BUFFERS are truth tables read from the client's blob while a
+	// circuit is evaluated
+	MEM_BLOB_BUFFERS = "blobBuffers"
+	// MEM_MEMORY_BLOB is the client's blob if it is kept in memory, see
+	// BlobBudget
+	MEM_MEMORY_BLOB = "memoryBlob"
+	// MEM_OT_RESPONSES are the OT responses received by the notary
+	MEM_OT_RESPONSES = "otResponses"
+)
+
+// totalMemory is the sum of the memory held by all sessions
+var totalMemory int64
+
+func init() {
+	expvar.Publish("session_memory_bytes", expvar.Func(func() interface{} {
+		return TotalMemory()
+	}))
+}
+
+// TotalMemory returns the approximate amount of bytes held by all sessions
+func TotalMemory() int64 {
+	return atomic.LoadInt64(&totalMemory)
+}
+
+// MemAccountant tracks the approximate amount of bytes held by a session.
+// Only the big buffers are counted, not the bookkeeping around them. The
+// zero value is ready to use.
+type MemAccountant struct {
+	sync.Mutex
+	bytes map[string]int64
+	// released is set when the session is removed. Buffers which are
+	// accounted for later (e.g. by a finishing OT) are not counted.
+	released bool
+}
+
+// Add accounts for n more bytes (or less if n is negative) in category
+func (m *MemAccountant) Add(category string, n int) {
+	m.Lock()
+	defer m.Unlock()
+	if m.released {
+		return
+	}
+	if m.bytes == nil {
+		m.bytes = make(map[string]int64)
+	}
+	m.bytes[category] += int64(n)
+	atomic.AddInt64(&totalMemory, int64(n))
+}
+
+// Snapshot returns the bytes by category and their total
+func (m *MemAccountant) Snapshot() (map[string]int64, int64) {
+	m.Lock()
+	defer m.Unlock()
+	snapshot := make(map[string]int64, len(m.bytes))
+	var total int64
+	for category, n := range m.bytes {
+		snapshot[category] = n
+		total += n
+	}
+	return snapshot, total
+}
+
+// Release removes the session's bytes from the total of all sessions
+func (m *MemAccountant) Release() {
+	m.Lock()
+	defer m.Unlock()
+	if m.released {
+		return
+	}
+	m.released = true
+	for _, n := range m.bytes {
+		atomic.AddInt64(&totalMemory, -n)
+	}
+}
@@ -0,0 +1,25 @@
+package session
+
+import "testing"
+
+func TestMemAccountant(t *testing.T) {
+	before := TotalMemory()
+	var m MemAccountant
+	m.Add(MEM_LABELS, 1000)
+	m.Add(MEM_BLOB_BUFFERS, 500)
+	m.Add(MEM_BLOB_BUFFERS, -500)
+	m.Add(MEM_OT_RESPONSES, 24)
+	snapshot, total := m.Snapshot()
+	if total != 1024 || snapshot[MEM_LABELS] != 1000 || snapshot[MEM_BLOB_BUFFERS] != 0 {
+		t.Errorf("unexpected snapshot %v (total %d)", snapshot, total)
+	}
+	if TotalMemory()-before != 1024 {
+		t.Errorf("expected the total to grow by 1024, got %d", TotalMemory()-before)
+	}
+	m.Release()
+	m.Add(MEM_OT_RESPONSES, 100)
+	m.Release()
+	if TotalMemory() != before {
+		t.Errorf("released memory is still counted: %d", TotalMemory()-before)
+	}
+}
@@ -0,0 +1,119 @@
+package session
+
+import (
+	"expvar"
+	"sync"
+)
+
+var (
+	// memoryBlobBytes is the memory taken by the blobs which are kept in
+	// memory instead of on disk
+	memoryBlobBytes = expvar.NewInt("memory_blob_bytes")
+	// memoryBlobSessions counts the sessions whose blob was kept in memory
+	memoryBlobSessions = expvar.NewInt("memory_blob_sessions")
+)
+
+// BlobBudget is the memory which the blobs of all sessions may take. A
+// session keeps its blob in memory if it fits into what is left of the
+// budget, otherwise on disk. The zero value has no budget.
+type BlobBudget struct {
+	sync.Mutex
+	Limit int64
+	used  int64
+}
+
+// reserve takes size bytes of the budget if they are left
+func (b *BlobBudget) reserve(size int64) bool {
+	b.Lock()
+	defer b.Unlock()
+	if b.used+size > b.Limit {
+		return false
+	}
+	b.used += size
+	memoryBlobBytes.Add(size)
+	return true
+}
+
+// release gives size bytes back to the budget
+func (b *BlobBudget) release(size int64) {
+	b.Lock()
+	defer b.Unlock()
+	b.used -= size
+	memoryBlobBytes.Add(-size)
+}
+
+// memoryBlob is the blob of a session which is kept in memory
+type memoryBlob struct {
+	sync.Mutex
+	data []byte
+	// reserved is the part of the BlobBudget taken by the blob, 0 once it
+	// was given back
+	reserved int64
+}
+
+// Write appends to the blob. The StreamCounter in front of it makes sure
+// that the blob doesn't grow past the reserved size.
+func (m *memoryBlob) Write(p []byte) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+	m.data = append(m.data, p...)
+	return len(p), nil
+}
+
+// size returns the amount of bytes uploaded so far
+func (m *memoryBlob) size() int64 {
+	m.Lock()
+	defer m.Unlock()
+	return int64(len(m.data))
+}
+
+// slice returns size bytes of the blob at off, or false if the blob is too
+// short
+func (m *memoryBlob) slice(off int, size int) ([]byte, bool) {
+	m.Lock()
+	defer m.Unlock()
+	if off+size > len(m.data) {
+		return nil, false
+	}
+	return m.data[off : off+size], true
+}
+
+// newMemoryBlob returns a memoryBlob for the session if the session's blob
+// fits into the budget, nil otherwise
+func (s *Session) newMemoryBlob() *memoryBlob {
+	if s.BlobBudget == nil || !s.BlobBudget.reserve(s.blobSize) {
+		return nil
+	}
+	memoryBlobSessions.Add(1)
+	s.Mem.Add(MEM_MEMORY_BLOB, int(s.blobSize))
+	return &memoryBlob{data: make([]byte, 0, s.blobSize), reserved: s.blobSize}
+}
+
+// ReleaseMemoryBlob drops the blob which is kept in memory and gives its
+// memory back to the budget. It is called when all circuits were evaluated
+// and when the session is removed.
+func (s *Session) ReleaseMemoryBlob() {
+	s.memoryBlobMutex.Lock()
+	m := s.memoryBlob
+	s.memoryBlob = nil
+	s.memoryBlobMutex.Unlock()
+	if m == nil {
+		return
+	}
+	m.Lock()
+	reserved := m.reserved
+	m.data, m.reserved = nil, 0
+	m.Unlock()
+	if reserved > 0 {
+		s.BlobBudget.release(reserved)
+		s.Mem.Add(MEM_MEMORY_BLOB, -int(reserved))
+	}
+}
+
+// getMemoryBlob returns the blob which is kept in memory, or nil if the blob
+// is on disk
+func (s *Session) getMemoryBlob() *memoryBlob {
+	s.memoryBlobMutex.Lock()
+	defer s.memoryBlobMutex.Unlock()
+	return s.memoryBlob
+}
@@ -0,0 +1,55 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemoryBlob(t *testing.T) {
+	budget := &BlobBudget{Limit: 24}
+	s := &Session{StorageDir: t.TempDir(), blobSize: 16, BlobBudget: budget}
+	s.fsm.advance(stepOf("init"))
+	blob := bytes.Repeat([]byte{1, 2, 3, 4}, 4)
+	if _, err := s.SetBlob(io.NopCloser(bytes.NewReader(blob)), ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(s.StorageDir, "blobForNotary")); !os.IsNotExist(err) {
+		t.Fatal("a blob within the budget must not be written to disk")
+	}
+	if err := s.checkBlobComplete(); err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := s.getMemoryBlob().slice(4, 8); !ok || !bytes.Equal(m, blob[4:12]) {
+		t.Fatal("unexpected slice", m)
+	}
+
+	// the budget has 8 bytes left, so the next session uses the disk
+	other := &Session{StorageDir: t.TempDir(), blobSize: 16, BlobBudget: budget}
+	other.fsm.advance(stepOf("init"))
+	if _, err := other.SetBlob(io.NopCloser(bytes.NewReader(blob)), ""); err != nil {
+		t.Fatal(err)
+	}
+	if other.getMemoryBlob() != nil || other.checkBlobComplete() != nil {
+		t.Fatal("a blob over the budget must be written to disk")
+	}
+
+	s.ReleaseMemoryBlob()
+	s.ReleaseMemoryBlob()
+	if budget.used != 0 || s.getMemoryBlob() != nil {
+		t.Fatal("the memory must be given back once", budget.used)
+	}
+}
+
+func TestMemoryBlobTooLarge(t *testing.T) {
+	budget := &BlobBudget{Limit: 100}
+	s := &Session{StorageDir: t.TempDir(), blobSize: 16, BlobBudget: budget}
+	s.fsm.advance(stepOf("init"))
+	_, err := s.SetBlob(io.NopCloser(bytes.NewReader(make([]byte, 17))), "")
+	if !errors.Is(err, ErrBlobTooLarge) || budget.used != 0 {
+		t.Fatal("unexpected result", err, budget.used)
+	}
+}
@@ -0,0 +1,108 @@
+package session
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	u "notary/utils"
+	"time"
+)
+
+// OtStats counts the OT traffic of a session from the notary's side. The
+// client's side is the mirror image: its requests are the notary's responses
+// and vice versa, so it can compute the same stats and compare the digest.
+type OtStats struct {
+	// Rounds is the amount of OT exchanges, one per step with OT
+	Rounds int `json:"rounds"`
+	// Requests is how often the notary was the OT receiver, RequestBytes
+	// the amount of bytes it received
+	Requests     int   `json:"requests"`
+	RequestBytes int64 `json:"requestBytes"`
+	// Responses is how often the notary was the OT sender, ResponseBytes
+	// the amount of bytes it offered (the size of the data of
+	// RespondWithData or RespondWithStream)
+	Responses     int   `json:"responses"`
+	ResponseBytes int64 `json:"responseBytes"`
+}
+
+// Digest returns the hex-encoded SHA-256 of rounds(4) | requests(4) |
+// requestBytes(8) | responses(4) | responseBytes(8), big-endian
+func (o OtStats) Digest() string {
+	b := make([]byte, 28)
+	binary.BigEndian.PutUint32(b[0:4], uint32(o.Rounds))
+	binary.BigEndian.PutUint32(b[4:8], uint32(o.Requests))
+	binary.BigEndian.PutUint64(b[8:16], uint64(o.RequestBytes))
+	binary.BigEndian.PutUint32(b[16:20], uint32(o.Responses))
+	binary.BigEndian.PutUint64(b[20:28], uint64(o.ResponseBytes))
+	return hex.EncodeToString(u.Sha256(b))
+}
+
+// OtStats returns the OT stats of the session so far
+func (s *Session) OtStats() OtStats {
+	s.otTasksMutex.Lock()
+	defer s.otTasksMutex.Unlock()
+	return s.otStats
+}
+
+// finalOtStats waits for the last OT exchange and returns the OT stats of
+// the session. The client only sends commitHash after its side of all
+// exchanges, but the notary's side may still be counting.
+func (s *Session) finalOtStats() (OtStats, error) {
+	s.otTasksMutex.Lock()
+	last := s.otLast
+	s.otTasksMutex.Unlock()
+	if last != nil {
+		select {
+		case <-last.done:
+		case <-time.After(otAwaitTimeout):
+			return OtStats{}, errors.New("timeout waiting for the last OT exchange")
+		}
+	}
+	return s.OtStats(), nil
+}
+
+// countOt adds to the OT stats
+func (s *Session) countOt(add func(o *OtStats)) {
+	s.otTasksMutex.Lock()
+	add(&s.otStats)
+	s.otTasksMutex.Unlock()
+}
+
+// otRequest requests the data of choices with OT and counts it
+func (s *Session) otRequest(choices []int) ([]byte, error) {
+	resp, err := s.Ot.RequestData(choices)
+	if err == nil {
+		s.countOt(func(o *OtStats) {
+			o.Requests++
+			o.RequestBytes += int64(len(resp))
+		})
+	}
+	return resp, err
+}
+
+// otRespond responds to an OT request with data and counts it
+func (s *Session) otRespond(data []byte) error {
+	err := s.Ot.RespondWithData(data)
+	if err == nil {
+		s.countResponse(len(data))
+	}
+	return err
+}
+
+// otRespondStream responds to an OT request with size bytes from r and
+// counts it
+func (s *Session) otRespondStream(r io.Reader, size int) error {
+	err := s.Ot.RespondWithStream(r, size)
+	if err == nil {
+		s.countResponse(size)
+	}
+	return err
+}
+
+func (s *Session) countResponse(size int) {
+	s.countOt(func(o *OtStats) {
+		o.Responses++
+		o.ResponseBytes += int64(size)
+	})
+}
@@ -0,0 +1,44 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestOtStatsDigest(t *testing.T) {
+	stats := OtStats{Rounds: 1, Requests: 2, RequestBytes: 3, Responses: 4, ResponseBytes: 5}
+	encoded, _ := hex.DecodeString("00000001" + "00000002" + "0000000000000003" + "00000004" + "0000000000000005")
+	digest := sha256.Sum256(encoded)
+	if stats.Digest() != hex.EncodeToString(digest[:]) {
+		t.Fatal("unexpected digest", stats.Digest())
+	}
+}
+
+func TestFinalOtStats(t *testing.T) {
+	s := new(Session)
+	release := make(chan struct{})
+	s.startOt("c1_step1", func() ([]byte, error) {
+		s.countResponse(100)
+		return nil, nil
+	})
+	s.startOt("ghash_step1", func() ([]byte, error) {
+		<-release
+		s.countResponse(20)
+		return nil, nil
+	})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	stats, err := s.finalOtStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the stats must include the exchange which was still running
+	expected := OtStats{Rounds: 2, Responses: 2, ResponseBytes: 120}
+	if stats != expected {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,106 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// otAwaitTimeout is how long a step waits for the OT exchange of an earlier
+// step
+const otAwaitTimeout = 5 * time.Minute
+
+// otTask is the OT exchange of a step. It runs in the background because the
+// client only starts its side of the OT after it got the HTTP response of
+// the step.
+type otTask struct {
+	// done is closed when the exchange finished
+	done chan struct{}
+	// resp is the OT response received by the notary, if any
+	resp []byte
+	err  error
+}
+
+// startOt runs the OT exchange of step in the background. Exchanges run one
+// after another in the order of the steps, so that steps never use the OT
+// connection at the same time. A failed exchange destroys the session.
+func (s *Session) startOt(step string, run func() ([]byte, error)) {
+	task := &otTask{done: make(chan struct{})}
+	s.otTasksMutex.Lock()
+	if s.otTasks == nil {
+		s.otTasks = make(map[string]*otTask)
+	}
+	if _, ok := s.otTasks[step]; ok {
+		s.otTasksMutex.Unlock()
+		panic("OT started twice for " + step)
+	}
+	prev := s.otLast
+	s.otTasks[step] = task
+	s.otLast = task
+	s.otTasksMutex.Unlock()
+
+	go func() {
+		defer close(task.done)
+		if prev != nil {
+			<-prev.done
+			if prev.err != nil {
+				task.err = prev.err
+				return
+			}
+		}
+		task.resp, task.err = run()
+		s.Mem.Add(MEM_OT_RESPONSES, len(task.resp))
+		if task.err == nil {
+			s.countOt(func(o *OtStats) { o.Rounds++ })
+		}
+		if task.err != nil {
+			log.Println("OT of", step, "failed:", task.err)
+			s.Destroy()
+		}
+	}()
+}
+
+// awaitOt blocks until the OT exchange of step finished and returns the OT
+// response received by the notary
+func (s *Session) awaitOt(step string) ([]byte, error) {
+	s.otTasksMutex.Lock()
+	task, ok := s.otTasks[step]
+	s.otTasksMutex.Unlock()
+	if !ok {
+		return nil, outOfOrder("no OT was started for %s", step)
+	}
+	select {
+	case <-task.done:
+	case <-time.After(otAwaitTimeout):
+		return nil, errors.New("timeout waiting for the OT of " + step)
+	}
+	if task.err != nil {
+		return nil, fmt.Errorf("OT of %s failed: %w", step, task.err)
+	}
+	return task.resp, nil
+}
+
+// OtComplete is the OT-complete acknowledgement. The body is the name of a
+// step. The response is only sent once the notary's side of the step's OT
+// finished, so a client which waits for it before sending the next step
+// never races the OT. It is a single encrypted byte: 1 when the OT
+// finished, 0 when the step had no OT (e.g. ghash_step3 without block
+// aggregation).
+func (s *Session) OtComplete(encrypted []byte) ([]byte, error) {
+	body, err := s.decryptFromClient(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	step := string(body)
+	s.otTasksMutex.Lock()
+	_, ok := s.otTasks[step]
+	s.otTasksMutex.Unlock()
+	if !ok {
+		return s.encryptToClient([]byte{0}), nil
+	}
+	if _, err = s.awaitOt(step); err != nil {
+		return nil, err
+	}
+	return s.encryptToClient([]byte{1}), nil
+}
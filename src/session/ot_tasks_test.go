@@ -0,0 +1,52 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOtTasksRunInOrder(t *testing.T) {
+	s := new(Session)
+	release := make(chan struct{})
+	var order []string
+	s.startOt("c1_step1", func() ([]byte, error) {
+		<-release
+		order = append(order, "c1_step1")
+		return []byte("labels"), nil
+	})
+	s.startOt("ghash_step1", func() ([]byte, error) {
+		order = append(order, "ghash_step1")
+		return nil, nil
+	})
+	// the second exchange must wait for the first
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	if resp, err := s.awaitOt("c1_step1"); err != nil || !bytes.Equal(resp, []byte("labels")) {
+		t.Errorf("unexpected OT response %q, %v", resp, err)
+	}
+	if _, err := s.awaitOt("ghash_step1"); err != nil {
+		t.Error(err)
+	}
+	if len(order) != 2 || order[0] != "c1_step1" {
+		t.Errorf("exchanges ran in the wrong order: %v", order)
+	}
+}
+
+func TestOtTaskFailure(t *testing.T) {
+	s := &Session{DestroyChan: make(chan string, 1), Sid: "sid"}
+	s.startOt("c1_step1", func() ([]byte, error) {
+		return nil, errors.New("not connected")
+	})
+	s.startOt("c2_step1", func() ([]byte, error) {
+		t.Error("an exchange after a failed one must not run")
+		return nil, nil
+	})
+	if _, err := s.awaitOt("c2_step1"); err == nil {
+		t.Error("awaiting a failed exchange must fail")
+	}
+	if len(s.DestroyChan) != 1 {
+		t.Error("the session was not destroyed")
+	}
+}
@@ -0,0 +1,91 @@
+package session
+
+import (
+	u "notary/utils"
+)
+
+// PRECOMPUTED_CIRCUITS are the circuits whose client-independent inputs are
+// precomputed
+const PRECOMPUTED_CIRCUITS = 5
+
+// precomputed are the parts of the inputs of circuits 1 to 5 which don't
+// depend on the client: the bits of the notary's masks, which follow the
+// other inputs of the notary, and the client's input labels, which the
+// notary sends via OT. They are computed while the client downloads and
+// uploads the blobs, so that c1_step1 and the following steps only add the
+// values of the handshake.
+type precomputed struct {
+	// done is closed once the fields are set
+	done chan struct{}
+	// maskBits are the bits of the masks of each circuit, by circuit number
+	maskBits [][]int
+	// clientLabels are the client's input labels of each circuit (those
+	// of c4 followed by the key labels of c6, see c4_step1A), by circuit
+	// number. They are dropped once sent.
+	clientLabels [][]byte
+	// panicked is the panic of the precomputation, which is raised again
+	// in the step which needs the inputs
+	panicked interface{}
+}
+
+// startPrecompute starts computing the client-independent circuit inputs,
+// unless that already started. Init starts it once the garbler has the
+// circuits; the client sends getBlob and setBlob next.
+func (s *Session) startPrecompute() {
+	s.precomputeOnce.Do(func() {
+		s.pre = &precomputed{done: make(chan struct{})}
+		go s.precompute(s.pre)
+	})
+}
+
+func (s *Session) precompute(pre *precomputed) {
+	defer close(pre.done)
+	defer func() {
+		// a panic must destroy the session, not the notary
+		pre.panicked = recover()
+	}()
+	pre.maskBits = make([][]int, PRECOMPUTED_CIRCUITS+1)
+	pre.clientLabels = make([][]byte, PRECOMPUTED_CIRCUITS+1)
+	for cNo := 1; cNo <= PRECOMPUTED_CIRCUITS; cNo++ {
+		for _, mask := range s.g.Cs[cNo].Masks[1:] {
+			pre.maskBits[cNo] = u.AppendBits(pre.maskBits[cNo], mask)
+		}
+		if cNo == 4 {
+			pre.clientLabels[cNo] = s.c4ClientLabels()
+		} else {
+			pre.clientLabels[cNo] = s.g.GetClientLabels(cNo)
+		}
+		s.Mem.Add(MEM_LABELS, len(pre.clientLabels[cNo]))
+	}
+}
+
+// precomputedInputs returns the precomputed inputs, waiting for them if
+// needed. The inputs are computed now if Init didn't start that, e.g. in
+// tests.
+func (s *Session) precomputedInputs() *precomputed {
+	s.startPrecompute()
+	<-s.pre.done
+	if s.pre.panicked != nil {
+		panic(s.pre.panicked)
+	}
+	return s.pre
+}
+
+// setNotaryInputs sets the inputs of circuit cNo: inputs followed by the
+// masks of the circuit
+func (s *Session) setNotaryInputs(cNo int, inputs ...[]byte) {
+	// wait first, the precomputation reads the circuit data
+	maskBits := s.precomputedInputs().maskBits[cNo]
+	s.setCircuitInputs(cNo, inputs...)
+	s.g.Cs[cNo].InputBits = append(s.g.Cs[cNo].InputBits, maskBits...)
+}
+
+// takeClientLabels returns the precomputed client's input labels of circuit
+// cNo. The session doesn't keep them.
+func (s *Session) takeClientLabels(cNo int) []byte {
+	pre := s.precomputedInputs()
+	labels := pre.clientLabels[cNo]
+	pre.clientLabels[cNo] = nil
+	s.Mem.Add(MEM_LABELS, -len(labels))
+	return labels
+}
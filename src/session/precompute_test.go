@@ -0,0 +1,67 @@
+package session
+
+import (
+	"bytes"
+	"notary/garbler"
+	"notary/meta"
+	u "notary/utils"
+	"reflect"
+	"testing"
+)
+
+// newTestGarbler returns a garbler of small circuits with random labels
+func newTestGarbler(c6Count int) *garbler.Garbler {
+	circuits := make([]*meta.Circuit, 8)
+	il := make([][][]byte, 8)
+	for i := 1; i < 8; i++ {
+		circuits[i] = &meta.Circuit{NotaryInputSize: 8 * i, ClientInputSize: 160 + i}
+		exeCount := 1
+		if i == 6 {
+			exeCount = c6Count
+		}
+		for j := 0; j < exeCount; j++ {
+			il[i] = append(il[i], u.GetRandom((circuits[i].NotaryInputSize+circuits[i].ClientInputSize+1)*16))
+		}
+	}
+	g := new(garbler.Garbler)
+	g.Init(il, circuits, c6Count, 1)
+	return g
+}
+
+func TestPrecomputedInputs(t *testing.T) {
+	s := &Session{g: newTestGarbler(2)}
+	s.startPrecompute()
+	input := u.GetRandom(32)
+	s.setNotaryInputs(1, input)
+	expected := u.AppendBits(u.AppendBits(nil, input), s.g.Cs[1].Masks[1])
+	if !reflect.DeepEqual(s.g.Cs[1].InputBits, expected) {
+		t.Error("the inputs must be followed by the masks")
+	}
+
+	if !bytes.Equal(s.takeClientLabels(2), s.g.GetClientLabels(2)) {
+		t.Error("unexpected client labels of c2")
+	}
+	if s.pre.clientLabels[2] != nil {
+		t.Error("the labels must not be kept once taken")
+	}
+	// c4 sends the labels of the key shares of c6 too
+	c6Labels := s.g.GetClientLabels(6)
+	expected4 := u.Concat(s.g.GetClientLabels(4), c6Labels[:160*32], c6Labels[len(c6Labels)/2:][:160*32])
+	if !bytes.Equal(s.takeClientLabels(4), expected4) {
+		t.Error("unexpected client labels of c4")
+	}
+	if snapshot, _ := s.Mem.Snapshot(); snapshot[MEM_LABELS] != int64(len(s.pre.clientLabels[1])+len(s.pre.clientLabels[3])+len(s.pre.clientLabels[5])) {
+		t.Error("unexpected memory of the labels", snapshot[MEM_LABELS])
+	}
+}
+
+func TestPrecomputePanic(t *testing.T) {
+	// c6Count 0 can't be split into executions
+	s := &Session{g: newTestGarbler(0)}
+	defer func() {
+		if recover() == nil {
+			t.Error("the step must panic")
+		}
+	}()
+	s.setNotaryInputs(1, u.GetRandom(32))
+}
@@ -0,0 +1,188 @@
+package session
+
+import (
+	"fmt"
+	"time"
+)
+
+// Step is a message of the protocol
+type Step struct {
+	// Command is the URL path of the message without the leading /
+	Command string
+	// Ordering says how the step is checked by the session's state
+	// machine, see fsm.go
+	Ordering Ordering
+	// After and Until are the ORDERED steps which open and close the window
+	// of a REPEATABLE step
+	After, Until string
+	// Entry steps may be received without their preceding step
+	Entry bool
+	// Optional steps may be skipped by the client
+	Optional bool
+	// Merges are the consecutive ORDERED steps which a merged step replaces
+	// in one round trip. It is accepted instead of the first of them and
+	// counts as all of them.
+	Merges []string
+	// MinVersion is the protocol version which the client needs for the step
+	MinVersion int
+	// Phase is the phase of the session the step belongs to, see sla.go
+	Phase Phase
+	// Method handles the message. It is nil for the messages which have
+	// their own HTTP handlers, e.g. because they are streamed.
+	Method func(s *Session, body []byte) ([]byte, error)
+}
+
+// Protocol is the single source of the protocol's messages, their order and
+// their handlers. ORDERED steps are listed in the order in which the client
+// sends them. The session manager's command list and method table are
+// generated from it.
+var Protocol = []Step{
+	{Command: "init", Entry: true, Phase: PHASE_HANDSHAKE, Method: (*Session).Init},
+	// the blobs are uploaded and downloaded while the client runs init, so
+	// they don't need a preceding message
+	{Command: "getBlob", Entry: true},
+	{Command: "setBlob", Entry: true},
+	// getBlobRange is a getBlob with a Range header, see GetBlobRange
+	{Command: "getBlobRange", Ordering: REPEATABLE, After: "init", Until: "c1_step1"},
+	// the blob may also be uploaded in chunks which the client can resume,
+	// see SetBlobChunk
+	{Command: "setBlobChunk", Ordering: REPEATABLE, After: "init", Until: "setBlob"},
+
+	{Command: "getUploadProgress", Ordering: REPEATABLE, After: "setBlob", Until: "c1_step1", Method: (*Session).GetUploadProgress},
+	{Command: "getOtProgress", Ordering: UNCHECKED, Method: (*Session).GetOtProgress},
+	// otComplete acknowledges that the OT of a step finished, see OtComplete
+	{Command: "otComplete", Ordering: UNCHECKED, Method: (*Session).OtComplete},
+
+	// a client instance can hand the session off to another instance until
+	// c1_step1, see handoff.go
+	{Command: "exportSession", Ordering: UNCHECKED},
+	{Command: "resumeSession", Ordering: UNCHECKED},
+
+	// step1 thru step4 deal with Paillier 2PC
+	{Command: "step1", Phase: PHASE_HANDSHAKE, Method: (*Session).Step1},
+	{Command: "step2", Phase: PHASE_HANDSHAKE, Method: (*Session).Step2},
+	{Command: "step3", Phase: PHASE_HANDSHAKE, Method: (*Session).Step3},
+	{Command: "step4", Phase: PHASE_HANDSHAKE, Method: (*Session).Step4},
+
+	// c1_step1 thru c2_step4 deal with TLS Handshake. The merged steps
+	// (listed after the steps they merge) save the round trips of the steps
+	// which need no input of the client. The other steps need the response
+	// of the step before them, e.g. the client computes the inner hashes of
+	// a2 and p2 from a1 and a2.
+	{Command: "c1_step1", Phase: PHASE_HANDSHAKE, Method: (*Session).C1_step1},
+	{Command: "step4_c1_step1", Merges: []string{"step4", "c1_step1"}, MinVersion: PROTOCOL_MERGED_STEPS, Phase: PHASE_HANDSHAKE, Method: (*Session).Step4_c1_step1},
+	{Command: "c1_step2", Phase: PHASE_HANDSHAKE, Method: (*Session).C1_step2},
+	{Command: "c1_step3", Phase: PHASE_HANDSHAKE, Method: (*Session).C1_step3},
+	{Command: "c1_step4", Phase: PHASE_HANDSHAKE, Method: (*Session).C1_step4},
+	{Command: "c1_step5", Phase: PHASE_HANDSHAKE, Method: (*Session).C1_step5},
+	{Command: "c2_step1", Phase: PHASE_HANDSHAKE, Method: (*Session).C2_step1},
+	{Command: "c1_step5_c2_step1", Merges: []string{"c1_step5", "c2_step1"}, MinVersion: PROTOCOL_MERGED_STEPS, Phase: PHASE_HANDSHAKE, Method: (*Session).C1_step5_c2_step1},
+	{Command: "c2_step2", Phase: PHASE_HANDSHAKE, Method: (*Session).C2_step2},
+	{Command: "c2_step3", Phase: PHASE_HANDSHAKE, Method: (*Session).C2_step3},
+	{Command: "c2_step4", Phase: PHASE_HANDSHAKE, Method: (*Session).C2_step4},
+
+	// c3_step1 thru c4_step3 deal with TLS Handshake and also prepare data
+	// needed to send Client Finished
+	{Command: "c3_step1", Phase: PHASE_HANDSHAKE, Method: (*Session).C3_step1},
+	{Command: "c2_step4_c3_step1", Merges: []string{"c2_step4", "c3_step1"}, MinVersion: PROTOCOL_MERGED_STEPS, Phase: PHASE_HANDSHAKE, Method: (*Session).C2_step4_c3_step1},
+	{Command: "c3_step2", Phase: PHASE_HANDSHAKE, Method: (*Session).C3_step2},
+	{Command: "c4_step1", Phase: PHASE_HANDSHAKE, Method: (*Session).C4_step1},
+	{Command: "c4_step2", Phase: PHASE_HANDSHAKE, Method: (*Session).C4_step2},
+	{Command: "c4_step3", Phase: PHASE_HANDSHAKE, Method: (*Session).C4_step3},
+
+	// c5_pre1 thru c5_step3 check Server Finished
+	{Command: "c5_pre1", Phase: PHASE_HANDSHAKE, Method: (*Session).C5_pre1},
+	{Command: "c5_step1", Phase: PHASE_HANDSHAKE, Method: (*Session).C5_step1},
+	{Command: "c5_step2", Phase: PHASE_HANDSHAKE, Method: (*Session).C5_step2},
+	{Command: "c5_step3", Phase: PHASE_HANDSHAKE, Method: (*Session).C5_step3},
+
+	// c6_step1 thru c6_step2 prepare encrypted counter blocks for the
+	// client's request to the webserver
+	{Command: "c6_step1", Phase: PHASE_REQUEST_MAC, Method: (*Session).C6_step1},
+	{Command: "c6_pre2", Phase: PHASE_REQUEST_MAC, Method: (*Session).C6_pre2},
+	{Command: "c6_step2", Phase: PHASE_REQUEST_MAC, Method: (*Session).C6_step2},
+
+	// c7_step1 thru c7_step2 prepare the GCTR block needed to compute the MAC
+	// for the client's request
+	{Command: "c7_step1", Phase: PHASE_REQUEST_MAC, Method: (*Session).C7_step1},
+	{Command: "c7_step2", Phase: PHASE_REQUEST_MAC, Method: (*Session).C7_step2},
+
+	// ghash_step1 thru ghash_step3 compute the GHASH output needed to compute
+	// the MAC for the client's request
+	{Command: "ghash_step1", Phase: PHASE_REQUEST_MAC, Method: (*Session).Ghash_step1},
+	{Command: "ghash_step2", Optional: true, Phase: PHASE_REQUEST_MAC, Method: (*Session).Ghash_step2},
+	{Command: "ghash_step3", Phase: PHASE_REQUEST_MAC, Method: (*Session).Ghash_step3},
+
+	{Command: "commitHash", Phase: PHASE_REQUEST_MAC, Method: (*Session).CommitHash},
+
+	{Command: "prepTagVerification", Ordering: UNCHECKED, Phase: PHASE_TAG_VERIFICATION, Method: (*Session).PrepTagVerification},
+	{Command: "pollTagVerification", Ordering: UNCHECKED, Phase: PHASE_TAG_VERIFICATION, Method: (*Session).PollTagVerification},
+	{Command: "tagVerification", Phase: PHASE_TAG_VERIFICATION, Method: (*Session).TagVerification},
+}
+
+// stepsByCommand indexes Protocol
+var stepsByCommand = indexProtocol()
+
+func indexProtocol() map[string]Step {
+	byCommand := make(map[string]Step, len(Protocol))
+	for _, step := range Protocol {
+		byCommand[step.Command] = step
+	}
+	return byCommand
+}
+
+// stepOf returns the step of a command
+func stepOf(command string) Step {
+	step, ok := stepsByCommand[command]
+	if !ok {
+		panic("unknown command " + command)
+	}
+	return step
+}
+
+// Methods returns the handlers of the session's messages by command. The
+// handlers check the message order before calling the session method. The
+// errors they return name the command. A retry of the last ORDERED step with
+// the same body gets the response of the first attempt, see beginStep.
+func (s *Session) Methods() map[string]func([]byte) ([]byte, error) {
+	methods := make(map[string]func([]byte) ([]byte, error), len(Protocol))
+	for _, step := range Protocol {
+		if step.Method == nil {
+			continue
+		}
+		step := step
+		methods[step.Command] = func(body []byte) (resp []byte, err error) {
+			if s.ProtocolVersion < step.MinVersion {
+				return nil, fmt.Errorf("%s: %w", step.Command, invalidMessage("needs protocol version %d", step.MinVersion))
+			}
+			cached, retry, err := s.beginStep(step, body)
+			if err != nil {
+				return nil, err
+			}
+			if retry {
+				<-cached.done
+				return cached.response, cached.err
+			}
+			if cached != nil {
+				defer func() {
+					if r := recover(); r != nil {
+						// the session is destroyed, retries must not succeed
+						s.finishStep(cached, nil, fmt.Errorf("%s: the step failed", step.Command))
+						panic(r)
+					}
+					s.finishStep(cached, resp, err)
+				}()
+			}
+			s.enterPhase(step.Phase)
+			if err := s.CheckSLA(time.Now()); err != nil && s.SLA.Enforce {
+				return nil, fmt.Errorf("%s: %w", step.Command, err)
+			}
+			resp, err = step.Method(s, body)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", step.Command, err)
+			}
+			return resp, nil
+		}
+	}
+	return methods
+}
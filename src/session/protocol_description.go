@@ -0,0 +1,153 @@
+package session
+
+// Encodings of the bodies of the steps
+const (
+	// ENCODING_NONE bodies are empty or ignored
+	ENCODING_NONE = "none"
+	// ENCODING_ENCRYPTED bodies are encrypted with the session's keys, see
+	// encryptToClient. Layout and sizes are those of the plaintext.
+	ENCODING_ENCRYPTED = "encrypted"
+	// ENCODING_BINARY bodies are sent as is
+	ENCODING_BINARY = "binary"
+	// ENCODING_JSON bodies are JSON objects
+	ENCODING_JSON = "json"
+)
+
+// Payload describes the body of a step's request or response
+type Payload struct {
+	Encoding string `json:"encoding"`
+	// Layout are the fields of the body in order, written name(size) with
+	// the size in bytes. A size like (c1) depends on the circuit of the
+	// garbled pool and a field without size takes the rest of the body.
+	// n * (fields) repeats the fields n times.
+	Layout string `json:"layout,omitempty"`
+	// MinSize and MaxSize bound the size of the body, 0 if it has no bound
+	MinSize int `json:"minSize,omitempty"`
+	MaxSize int `json:"maxSize,omitempty"`
+}
+
+// StepDescription is a Step as served to client implementations
+type StepDescription struct {
+	Command  string `json:"command"`
+	Ordering string `json:"ordering"`
+	// After are the steps one of which must precede an ordered step, or
+	// the step which opens the window of a repeatable step
+	After      []string `json:"after,omitempty"`
+	Until      string   `json:"until,omitempty"`
+	Entry      bool     `json:"entry,omitempty"`
+	Optional   bool     `json:"optional,omitempty"`
+	Merges     []string `json:"merges,omitempty"`
+	MinVersion int      `json:"minVersion,omitempty"`
+	Phase      string   `json:"phase,omitempty"`
+	// SessionMethod steps are handled by the session and are also served
+	// over gRPC, the others have their own HTTP handlers
+	SessionMethod bool    `json:"sessionMethod"`
+	Request       Payload `json:"request"`
+	Response      Payload `json:"response"`
+}
+
+// ProtocolDescription is the machine-readable description of Protocol
+type ProtocolDescription struct {
+	ProtocolVersions [2]int            `json:"protocolVersions"`
+	Steps            []StepDescription `json:"steps"`
+}
+
+var orderingNames = map[Ordering]string{ORDERED: "ordered", UNCHECKED: "unchecked", REPEATABLE: "repeatable"}
+
+// stepPayloads are the bodies of the steps of Protocol by command. They
+// describe the latest protocol version.
+var stepPayloads = map[string][2]Payload{
+	"init": {
+		{ENCODING_BINARY, "clientPubkey(64) | c6Count(2) | protocolVersion(1) | log2FrameSize(1) | recordCount(1) | otImplementation(1)", initBodySize, initMaxBodySize},
+		{ENCODING_ENCRYPTED, "otPort(2) | garblingScheme(1) | brokerAddrLen(1) | brokerAddr | brokerToken(16) | otHostLen(1) | otHost", 0, 0},
+	},
+	"getBlob":           {{ENCODING_NONE, "", 0, 0}, {ENCODING_BINARY, "truthTables", 0, 0}},
+	"getBlobRange":      {{ENCODING_NONE, "", 0, 0}, {ENCODING_BINARY, "truthTables", 0, 0}},
+	"setBlob":           {{ENCODING_BINARY, "truthTables", 0, 0}, {ENCODING_NONE, "", 0, 0}},
+	"setBlobChunk":      {{ENCODING_BINARY, "truthTables", 0, 0}, {ENCODING_NONE, "", 0, 0}},
+	"getUploadProgress": {{ENCODING_NONE, "", 0, 0}, {ENCODING_ENCRYPTED, "uploadedBytes(4)", 4, 4}},
+	"getOtProgress":     {{ENCODING_NONE, "", 0, 0}, {ENCODING_JSON, "", 0, 0}},
+	"otComplete":        {{ENCODING_ENCRYPTED, "command", 0, 0}, {ENCODING_ENCRYPTED, "finished(1)", 1, 1}},
+	"exportSession":     {{ENCODING_ENCRYPTED, "", 0, 0}, {ENCODING_BINARY, "handoffToken", 0, 0}},
+	"resumeSession":     {{ENCODING_BINARY, "handoffToken", 0, 0}, {ENCODING_ENCRYPTED, "otRoute", 0, 0}},
+
+	"step1": {{ENCODING_ENCRYPTED, "paillier2pc step1", 0, 0}, {ENCODING_ENCRYPTED, "paillier2pc step1", 0, 0}},
+	"step2": {{ENCODING_ENCRYPTED, "paillier2pc step2", 0, 0}, {ENCODING_ENCRYPTED, "paillier2pc step2", 0, 0}},
+	"step3": {{ENCODING_ENCRYPTED, "paillier2pc step3", 0, 0}, {ENCODING_ENCRYPTED, "paillier2pc step3", 0, 0}},
+	"step4": {{ENCODING_ENCRYPTED, "paillier2pc step4", 0, 0}, {ENCODING_NONE, "", 0, 0}},
+
+	"c1_step1":          {{ENCODING_NONE, "", 0, 0}, {ENCODING_ENCRYPTED, "notaryLabels(c1)", 0, 0}},
+	"step4_c1_step1":    {{ENCODING_ENCRYPTED, "paillier2pc step4", 0, 0}, {ENCODING_ENCRYPTED, "notaryLabels(c1)", 0, 0}},
+	"c1_step2":          {{ENCODING_ENCRYPTED, "clientLabels(c1) | commitment(32)", 32, 0}, {ENCODING_ENCRYPTED, "encodedOutput(c1) | decodingTable(c1)", 0, 0}},
+	"c1_step3":          {{ENCODING_ENCRYPTED, "decommitment(c1) | innerHash(32)", 32, 0}, {ENCODING_ENCRYPTED, "a1(32)", 32, 32}},
+	"c1_step4":          {{ENCODING_ENCRYPTED, "innerHash(32)", 32, 32}, {ENCODING_ENCRYPTED, "a2(32)", 32, 32}},
+	"c1_step5":          {{ENCODING_ENCRYPTED, "innerHash(32)", 32, 32}, {ENCODING_ENCRYPTED, "p2(32)", 32, 32}},
+	"c2_step1":          {{ENCODING_NONE, "", 0, 0}, {ENCODING_ENCRYPTED, "notaryLabels(c2)", 0, 0}},
+	"c1_step5_c2_step1": {{ENCODING_ENCRYPTED, "innerHash(32)", 32, 32}, {ENCODING_ENCRYPTED, "p2(32) | notaryLabels(c2)", 32, 0}},
+	"c2_step2":          {{ENCODING_ENCRYPTED, "clientLabels(c2) | commitment(32)", 32, 0}, {ENCODING_ENCRYPTED, "encodedOutput(c2) | decodingTable(c2)", 0, 0}},
+	"c2_step3":          {{ENCODING_ENCRYPTED, "decommitment(c2) | a1InnerHash(32) | a1VdInnerHash(32)", 64, 0}, {ENCODING_ENCRYPTED, "a1(32) | a1Vd(32)", 64, 64}},
+	"c2_step4":          {{ENCODING_ENCRYPTED, "a2InnerHash(32) | p1VdInnerHash(32)", 64, 64}, {ENCODING_ENCRYPTED, "a2(32) | verifyData(12)", 44, 44}},
+
+	"c3_step1":          {{ENCODING_NONE, "", 0, 0}, {ENCODING_ENCRYPTED, "notaryLabels(c3)", 0, 0}},
+	"c2_step4_c3_step1": {{ENCODING_ENCRYPTED, "a2InnerHash(32) | p1VdInnerHash(32)", 64, 64}, {ENCODING_ENCRYPTED, "a2(32) | verifyData(12) | notaryLabels(c3)", 44, 0}},
+	"c3_step2":          {{ENCODING_ENCRYPTED, "clientLabels(c3) | commitment(32)", 32, 0}, {ENCODING_ENCRYPTED, "encodedOutput(c3) | decodingTable(c3)", 0, 0}},
+	"c4_step1":          {{ENCODING_ENCRYPTED, "decommitment(c3)", 0, 0}, {ENCODING_ENCRYPTED, "notaryLabels(c4)", 0, 0}},
+	"c4_step2":          {{ENCODING_ENCRYPTED, "clientLabels(c4) | commitment(32)", 32, 0}, {ENCODING_ENCRYPTED, "encodedOutput(c4) | decodingTable(c4)", 0, 0}},
+	"c4_step3":          {{ENCODING_ENCRYPTED, "decommitment(c4) | encClientFinished(16)", 16, 0}, {ENCODING_ENCRYPTED, "tagShare(16)", 16, 16}},
+
+	"c5_pre1":  {{ENCODING_ENCRYPTED, "a1InnerHash(32)", 32, 32}, {ENCODING_ENCRYPTED, "a1(32)", 32, 32}},
+	"c5_step1": {{ENCODING_NONE, "", 0, 0}, {ENCODING_ENCRYPTED, "notaryLabels(c5)", 0, 0}},
+	"c5_step2": {{ENCODING_ENCRYPTED, "clientLabels(c5) | commitment(32)", 32, 0}, {ENCODING_ENCRYPTED, "encodedOutput(c5) | decodingTable(c5)", 0, 0}},
+	"c5_step3": {{ENCODING_ENCRYPTED, "decommitment(c5) | encServerFinished(16)", 16, 0}, {ENCODING_ENCRYPTED, "tagShare(16)", 16, 16}},
+
+	"c6_step1": {{ENCODING_NONE, "", 0, 0}, {ENCODING_ENCRYPTED, "notaryLabels(c6)", 0, 0}},
+	"c6_pre2":  {{ENCODING_ENCRYPTED, "clientLabels(c6)", 0, 0}, {ENCODING_NONE, "", 0, 0}},
+	"c6_step2": {{ENCODING_ENCRYPTED, "commitment(32)", 32, 32}, {ENCODING_ENCRYPTED, "encodedOutput(c6) | decodingTable(c6)", 0, 0}},
+	"c7_step1": {{ENCODING_ENCRYPTED, "decommitment(c6)", 0, 0}, {ENCODING_ENCRYPTED, "notaryLabels(c7)", 0, 0}},
+	"c7_step2": {{ENCODING_ENCRYPTED, "clientLabels(c7) | commitment(32)", 32, 0}, {ENCODING_ENCRYPTED, "encodedOutput(c7) | decodingTable(c7)", 0, 0}},
+
+	"ghash_step1": {{ENCODING_ENCRYPTED, "decommitment(c7) | maxPowerNeeded(2)", 2, 0}, {ENCODING_NONE, "", 0, 0}},
+	"ghash_step2": {{ENCODING_NONE, "", 0, 0}, {ENCODING_NONE, "", 0, 0}},
+	"ghash_step3": {{ENCODING_ENCRYPTED, "recordCount * (blockCount(2) | ghashInputs(16*blockCount)) | aggregationBits", 2 + 3*16, 0}, {ENCODING_ENCRYPTED, "recordCount * tagShare(16)", 16, 0}},
+
+	"commitHash": {
+		{ENCODING_ENCRYPTED, "commitHash(32) | cwkShareHash(32) | civShareHash(32) | swkShareHash(32) | sivShareHash(32) | commitments", 160, 0},
+		{ENCODING_ENCRYPTED, "notaryPMSShare(32) | cwkShare(16) | civShare(4) | swkShare(16) | sivShare(4) | attestation", 72, 0},
+	},
+
+	"prepTagVerification": {{ENCODING_JSON, "", 0, 0}, {ENCODING_JSON, "", 0, 0}},
+	"pollTagVerification": {{ENCODING_NONE, "", 0, 0}, {ENCODING_JSON, "", 0, 0}},
+	"tagVerification":     {{ENCODING_JSON, "", 0, 0}, {ENCODING_JSON, "", 0, 0}},
+}
+
+// DescribeProtocol returns the description of Protocol for the clients
+// which support protocol versions minVersion thru PROTOCOL_LATEST
+func DescribeProtocol(minVersion int) ProtocolDescription {
+	description := ProtocolDescription{ProtocolVersions: [2]int{minVersion, PROTOCOL_LATEST}}
+	for _, step := range Protocol {
+		payloads := stepPayloads[step.Command]
+		d := StepDescription{
+			Command:       step.Command,
+			Ordering:      orderingNames[step.Ordering],
+			Until:         step.Until,
+			Entry:         step.Entry,
+			Optional:      step.Optional,
+			Merges:        step.Merges,
+			MinVersion:    step.MinVersion,
+			SessionMethod: step.Method != nil,
+			Request:       payloads[0],
+			Response:      payloads[1],
+		}
+		switch step.Ordering {
+		case ORDERED:
+			d.After = transitions[step.Command]
+		case REPEATABLE:
+			d.After = []string{step.After}
+		}
+		if step.Phase != PHASE_NONE {
+			d.Phase = step.Phase.String()
+		}
+		description.Steps = append(description.Steps, d)
+	}
+	return description
+}
@@ -0,0 +1,269 @@
+package session
+
+import (
+	"errors"
+	"notary/ghash"
+	"notary/ote"
+	u "notary/utils"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestProtocolConsistent(t *testing.T) {
+	commands := make(map[string]Step)
+	firstOrdered := true
+	for _, step := range Protocol {
+		if step.Command == "" {
+			t.Fatal("step without command")
+		}
+		if _, ok := commands[step.Command]; ok {
+			t.Errorf("%s: duplicate command", step.Command)
+		}
+		commands[step.Command] = step
+		if step.Ordering != ORDERED && (step.Entry || step.Optional) {
+			t.Errorf("%s: only ordered steps can be entry or optional steps", step.Command)
+		}
+		if step.Ordering == ORDERED {
+			if firstOrdered && !step.Entry {
+				t.Errorf("%s: the first ordered step must be an entry step", step.Command)
+			}
+			firstOrdered = false
+		}
+	}
+
+	// the window of a repeatable step is opened and closed by ordered steps,
+	// in that order
+	position := make(map[string]int)
+	for i, step := range Protocol {
+		position[step.Command] = i
+	}
+	for _, step := range Protocol {
+		if step.Ordering != REPEATABLE {
+			if step.After != "" || step.Until != "" {
+				t.Errorf("%s: only repeatable steps have a window", step.Command)
+			}
+			continue
+		}
+		after, okAfter := commands[step.After]
+		until, okUntil := commands[step.Until]
+		if !okAfter || !okUntil || after.Ordering != ORDERED || until.Ordering != ORDERED {
+			t.Errorf("%s: the window must be set by ordered steps", step.Command)
+			continue
+		}
+		if position[step.After] >= position[step.Until] {
+			t.Errorf("%s: the window is empty", step.Command)
+		}
+	}
+
+	// a merged step replaces consecutive ordered steps listed before it
+	var ordered []string
+	for _, step := range Protocol {
+		if step.Ordering != ORDERED {
+			continue
+		}
+		if len(step.Merges) == 0 {
+			ordered = append(ordered, step.Command)
+			continue
+		}
+		if len(step.Merges) < 2 || step.MinVersion == 0 {
+			t.Errorf("%s: a merged step needs a protocol version and two steps", step.Command)
+			continue
+		}
+		first := -1
+		for i, command := range ordered {
+			if command == step.Merges[0] {
+				first = i
+			}
+		}
+		if first < 0 || first+len(step.Merges) > len(ordered) {
+			t.Errorf("%s: the merged steps must be listed before it", step.Command)
+			continue
+		}
+		for i, command := range step.Merges {
+			if ordered[first+i] != command || commands[command].Optional || commands[command].Entry {
+				t.Errorf("%s: %s is not the next required step", step.Command, command)
+			}
+		}
+	}
+
+	// the steps referenced by the session exist
+	for _, command := range []string{"setBlob", "c1_step1", "getBlob"} {
+		if _, ok := stepsByCommand[command]; !ok {
+			t.Errorf("%s: missing", command)
+		}
+	}
+}
+
+func TestMethodsMatchProtocol(t *testing.T) {
+	var expected []string
+	for _, step := range Protocol {
+		if step.Method != nil {
+			expected = append(expected, step.Command)
+		}
+	}
+	var actual []string
+	for command := range new(Session).Methods() {
+		actual = append(actual, command)
+	}
+	sort.Strings(expected)
+	sort.Strings(actual)
+	if len(expected) != len(actual) {
+		t.Fatalf("expected %d methods, got %d", len(expected), len(actual))
+	}
+	for i := range expected {
+		if expected[i] != actual[i] {
+			t.Errorf("expected method %s, got %s", expected[i], actual[i])
+		}
+	}
+}
+
+func TestMethodErrors(t *testing.T) {
+	s := new(Session)
+	s.fsm.advance(stepOf("init"))
+	s.fsm.advance(stepOf("setBlob"))
+	_, err := s.Methods()["step2"](nil)
+	var sequenceErr *SequenceError
+	if !errors.As(err, &sequenceErr) || sequenceErr.Command != "step2" || sequenceErr.Other != "step1" {
+		t.Error("unexpected error", err)
+	}
+	if !errors.Is(err, ErrOutOfOrder) || !strings.Contains(err.Error(), "step2") {
+		t.Error("unexpected error", err)
+	}
+	if s.fsm.hasReceived("step2") {
+		t.Error("a rejected message must not be recorded")
+	}
+}
+
+func TestMergedStepVersion(t *testing.T) {
+	s := &Session{ProtocolVersion: PROTOCOL_ATTESTATION_DOCUMENT}
+	s.fsm.advance(stepOf("init"))
+	s.fsm.advance(stepOf("setBlob"))
+	_, err := s.Methods()["step4_c1_step1"](nil)
+	if !errors.Is(err, ErrInvalidMessage) {
+		t.Error("unexpected error", err)
+	}
+	if s.fsm.hasReceived("step4") {
+		t.Error("a rejected message must not be recorded")
+	}
+}
+
+func TestInitFrameSize(t *testing.T) {
+	body := make([]byte, initBodySize+2)
+	body[initBodySize] = PROTOCOL_TRANSFER_PLAN
+	if v := InitProtocolVersion(body); v != PROTOCOL_TRANSFER_PLAN {
+		t.Fatal("unexpected protocol version", v)
+	}
+	accepts := func(log2 byte) bool {
+		_, err := parseFrameSize(log2)
+		if err != nil && !errors.Is(err, ErrInvalidMessage) {
+			t.Error("unexpected error", err)
+		}
+		return err == nil
+	}
+	for log2, accepted := range map[byte]bool{13: false, 14: true, 16: true, 20: true, 21: false, 255: false} {
+		if accepts(log2) != accepted {
+			t.Errorf("frame size 2^%d: expected accepted=%v", log2, accepted)
+		}
+	}
+	// the record count follows the frame size
+	body = make([]byte, initBodySize+3)
+	body[initBodySize] = PROTOCOL_REQUEST_RECORDS
+	if v := InitProtocolVersion(body); v != PROTOCOL_REQUEST_RECORDS {
+		t.Fatal("unexpected protocol version", v)
+	}
+}
+
+func TestInitOTImplementation(t *testing.T) {
+	body := make([]byte, initBodySize+3)
+	body[initBodySize] = PROTOCOL_REQUEST_RECORDS
+	if impl := InitOTImplementation(body); impl != ote.IMPLEMENTATION_NATIVE {
+		t.Error("older clients use the native OT, got", impl)
+	}
+	body = make([]byte, initMaxBodySize)
+	body[initBodySize] = PROTOCOL_OT_IMPLEMENTATION
+	for b, expected := range map[byte]ote.Implementation{0: ote.IMPLEMENTATION_NATIVE, 1: ote.IMPLEMENTATION_GO, 2: ""} {
+		body[initMaxBodySize-1] = b
+		if impl := InitOTImplementation(body); impl != expected {
+			t.Errorf("byte %d: expected %q, got %q", b, expected, impl)
+		}
+	}
+	if impl := InitOTImplementation(body[:initMaxBodySize-1]); impl != "" {
+		t.Error("a missing OT implementation must not be accepted, got", impl)
+	}
+}
+
+func TestParseGhashInputs(t *testing.T) {
+	s := &Session{ProtocolVersion: PROTOCOL_REQUEST_RECORDS, recordCount: 2, ghash: new(ghash.GHASH)}
+	s.ghash.Init()
+	s.ghash.SetMaxPowerNeeded(5)
+	body := u.Concat([]byte{0, 5}, make([]byte, 5*16), []byte{0, 3}, make([]byte, 3*16), []byte{1})
+	records, aggregation, err := s.parseGhashInputs(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || len(records[0]) != 5 || len(records[1]) != 3 || len(aggregation) != 1 || len(s.ghashInputsBlob) != 8*16 {
+		t.Fatal("unexpected records", len(records), len(aggregation), len(s.ghashInputsBlob))
+	}
+	// a record can't have more blocks than the powers of H
+	body = u.Concat([]byte{0, 6}, make([]byte, 6*16), []byte{0, 3}, make([]byte, 3*16))
+	if _, _, err = s.parseGhashInputs(body); !errors.Is(err, ErrInvalidMessage) {
+		t.Error("unexpected error", err)
+	}
+	// older clients send the blocks of their only record without a count
+	s = &Session{ProtocolVersion: PROTOCOL_MERGED_STEPS, recordCount: 1, ghash: s.ghash}
+	if records, _, err = s.parseGhashInputs(make([]byte, 5*16)); err != nil || len(records) != 1 || len(records[0]) != 5 {
+		t.Fatal("unexpected records", err)
+	}
+}
+
+func TestStepPayloads(t *testing.T) {
+	if len(stepPayloads) != len(Protocol) {
+		t.Errorf("%d payloads for %d steps", len(stepPayloads), len(Protocol))
+	}
+	for _, step := range Protocol {
+		payloads, ok := stepPayloads[step.Command]
+		if !ok {
+			t.Errorf("%s: no payloads", step.Command)
+			continue
+		}
+		for _, p := range payloads {
+			if p.Encoding != ENCODING_NONE && p.Encoding != ENCODING_ENCRYPTED && p.Encoding != ENCODING_BINARY && p.Encoding != ENCODING_JSON {
+				t.Errorf("%s: unknown encoding %q", step.Command, p.Encoding)
+			}
+			if p.MaxSize != 0 && p.MinSize > p.MaxSize {
+				t.Errorf("%s: min size above max size", step.Command)
+			}
+		}
+	}
+	// a merged step takes the request of its first step
+	for _, step := range Protocol {
+		if len(step.Merges) > 0 && stepPayloads[step.Command][0] != stepPayloads[step.Merges[0]][0] {
+			t.Errorf("%s: the request differs from that of %s", step.Command, step.Merges[0])
+		}
+	}
+}
+
+func TestDescribeProtocol(t *testing.T) {
+	description := DescribeProtocol(PROTOCOL_LEGACY)
+	if description.ProtocolVersions != [2]int{PROTOCOL_LEGACY, PROTOCOL_LATEST} || len(description.Steps) != len(Protocol) {
+		t.Fatalf("unexpected description %+v", description)
+	}
+	byCommand := make(map[string]StepDescription)
+	for _, d := range description.Steps {
+		byCommand[d.Command] = d
+	}
+	// ghash_step2 is optional
+	if d := byCommand["ghash_step3"]; d.Ordering != "ordered" || len(d.After) != 2 || d.Phase != "request_mac" || !d.SessionMethod {
+		t.Errorf("unexpected ghash_step3 %+v", d)
+	}
+	if d := byCommand["getUploadProgress"]; d.Ordering != "repeatable" || d.After[0] != "setBlob" || d.Until != "c1_step1" || d.Phase != "" {
+		t.Errorf("unexpected getUploadProgress %+v", d)
+	}
+	if d := byCommand["setBlob"]; !d.Entry || d.SessionMethod {
+		t.Errorf("unexpected setBlob %+v", d)
+	}
+	if d := byCommand["step4_c1_step1"]; d.MinVersion != PROTOCOL_MERGED_STEPS || len(d.Merges) != 2 {
+		t.Errorf("unexpected step4_c1_step1 %+v", d)
+	}
+}
@@ -0,0 +1,23 @@
+package session
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	FALLOC_FL_KEEP_SIZE  = 0x1
+	FALLOC_FL_PUNCH_HOLE = 0x2
+)
+
+// punchHole deallocates size bytes of f at off. Reading them returns zeros.
+func punchHole(f *os.File, off int64, size int64) error {
+	if size == 0 {
+		return nil
+	}
+	err := syscall.Fallocate(int(f.Fd()), FALLOC_FL_PUNCH_HOLE|FALLOC_FL_KEEP_SIZE, off, size)
+	if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+		return errPunchHoleUnsupported
+	}
+	return err
+}
@@ -0,0 +1,46 @@
+package session
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestPunchHole(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blobForNotary")
+	data := bytes.Repeat([]byte{0xab}, 1<<20)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = punchHole(f, 1<<18, 1<<19)
+	f.Close()
+	if err == errPunchHoleUnsupported {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(data) {
+		t.Fatal("the size of the file changed")
+	}
+	if !bytes.Equal(got[:1<<18], data[:1<<18]) || !bytes.Equal(got[3<<18:], data[3<<18:]) {
+		t.Error("data outside of the hole changed")
+	}
+	if !bytes.Equal(got[1<<18:3<<18], make([]byte, 1<<19)) {
+		t.Error("the hole is not zeroed")
+	}
+	var st syscall.Stat_t
+	if err = syscall.Stat(path, &st); err == nil && st.Blocks*512 >= int64(len(data)) {
+		t.Error("no disk space was released")
+	}
+}
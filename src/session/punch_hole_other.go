@@ -0,0 +1,10 @@
+//go:build !linux
+
+package session
+
+import "os"
+
+// punchHole deallocates size bytes of f at off where supported
+func punchHole(f *os.File, off int64, size int64) error {
+	return errPunchHoleUnsupported
+}
@@ -0,0 +1,71 @@
+package session
+
+import (
+	"bytes"
+	u "notary/utils"
+)
+
+// MEM_RETRY_RESPONSE is the response of the last ORDERED step, kept for a
+// retry of the step, see beginStep
+const MEM_RETRY_RESPONSE = "retryResponse"
+
+// cachedStep is the last ORDERED step of a session and its response. A
+// client whose request timed out after the notary received it can send the
+// same body again and gets the same response instead of failing the session
+// with a repeated step.
+type cachedStep struct {
+	command  string
+	bodyHash []byte
+	// done is closed once response and err are set
+	done     chan struct{}
+	response []byte
+	err      error
+	// accounted are the bytes of response counted in MEM_RETRY_RESPONSE
+	accounted int
+}
+
+// beginStep advances the state machine of the session for step, unless
+// body is a retry of the last ORDERED step: then it returns that step and
+// true. The caller waits on done for its response. Otherwise the returned
+// step (nil unless step is ORDERED) must be finished with finishStep. The
+// streamed steps getBlob and setBlob (which have no Method) can't be retried
+// and don't replace the last step.
+func (s *Session) beginStep(step Step, body []byte) (*cachedStep, bool, error) {
+	s.lastStepMutex.Lock()
+	defer s.lastStepMutex.Unlock()
+	if step.Method == nil {
+		return nil, false, s.fsm.advance(step)
+	}
+	var bodyHash []byte
+	if step.Ordering == ORDERED {
+		bodyHash = u.Sha256(body)
+		last := s.lastStep
+		if last != nil && last.command == step.Command && bytes.Equal(last.bodyHash, bodyHash) {
+			return last, true, nil
+		}
+	}
+	if err := s.fsm.advance(step); err != nil {
+		return nil, false, err
+	}
+	if step.Ordering != ORDERED {
+		return nil, false, nil
+	}
+	if s.lastStep != nil {
+		s.Mem.Add(MEM_RETRY_RESPONSE, -s.lastStep.accounted)
+	}
+	s.lastStep = &cachedStep{command: step.Command, bodyHash: bodyHash, done: make(chan struct{})}
+	return s.lastStep, false, nil
+}
+
+// finishStep sets the response of c and releases the retries waiting for
+// it. The response is only kept until the next ORDERED step.
+func (s *Session) finishStep(c *cachedStep, response []byte, err error) {
+	s.lastStepMutex.Lock()
+	c.response, c.err = response, err
+	if s.lastStep == c {
+		c.accounted = len(response)
+		s.Mem.Add(MEM_RETRY_RESPONSE, c.accounted)
+	}
+	s.lastStepMutex.Unlock()
+	close(c.done)
+}
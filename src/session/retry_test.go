@@ -0,0 +1,48 @@
+package session
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRetryLastStep(t *testing.T) {
+	s := &Session{fsm: protocolFSM{received: map[string]bool{"setBlob": true}}}
+	c, retry, err := s.beginStep(stepOf("step1"), []byte("body"))
+	if err != nil || retry || c == nil {
+		t.Fatal("unexpected first attempt", retry, err)
+	}
+
+	// a retry during the first attempt waits for its response
+	waited := make(chan []byte)
+	go func() {
+		c, retry, err := s.beginStep(stepOf("step1"), []byte("body"))
+		if err != nil || !retry {
+			t.Error("unexpected retry", retry, err)
+		}
+		<-c.done
+		waited <- c.response
+	}()
+	s.finishStep(c, []byte("response"), nil)
+	if string(<-waited) != "response" {
+		t.Fatal("the retry must get the response of the first attempt")
+	}
+	if memory, _ := s.Mem.Snapshot(); memory[MEM_RETRY_RESPONSE] != int64(len("response")) {
+		t.Fatal("unexpected memory", memory)
+	}
+
+	if _, _, err = s.beginStep(stepOf("step1"), []byte("other body")); !errors.Is(err, ErrOutOfOrder) {
+		t.Fatal("a repeated step with another body must be rejected", err)
+	}
+
+	c, _, err = s.beginStep(stepOf("step2"), []byte("body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if memory, _ := s.Mem.Snapshot(); memory[MEM_RETRY_RESPONSE] != 0 {
+		t.Fatal("the response of step1 must be released", memory)
+	}
+	s.finishStep(c, nil, nil)
+	if _, _, err = s.beginStep(stepOf("step1"), []byte("body")); !errors.Is(err, ErrOutOfOrder) {
+		t.Fatal("only the last step can be retried", err)
+	}
+}
@@ -7,39 +7,235 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
 	"io"
 	"log"
 	"math/big"
 	at "notary/aes_tag"
+	"notary/chaos"
 	"notary/evaluator"
 	"notary/garbled_pool"
 	"notary/garbler"
 	"notary/ghash"
+	"notary/key_manager"
 	"notary/meta"
+	"notary/ot_broker"
 	"notary/ote"
 	"notary/paillier2pc"
+	"notary/transparency_log"
 	u "notary/utils"
 
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// stream counter counts how many bytes passed through it
+// stream counter counts how many bytes passed through it and fails when
+// more than limit bytes do
 type StreamCounter struct {
+	// total is accessed atomically: getUploadProgress reads it while the
+	// blob is written
 	total uint32
+	limit int64
 }
 
 func (sc *StreamCounter) Write(p []byte) (int, error) {
 	n := len(p)
-	sc.total += uint32(n)
-	if sc.total > 1024*1024*300 {
-		panic("can't process blob more than 300MB")
+	if err := sc.check(n); err != nil {
+		return 0, err
 	}
+	atomic.AddUint32(&sc.total, uint32(n))
 	return n, nil
 }
 
+// check fails if n more bytes would exceed the limit
+func (sc *StreamCounter) check(n int) error {
+	if int64(sc.Total())+int64(n) > sc.limit {
+		return blobTooLarge("the blob is larger than %d bytes", sc.limit)
+	}
+	return nil
+}
+
+// Total returns the amount of bytes which passed through the counter
+func (sc *StreamCounter) Total() uint32 {
+	return atomic.LoadUint32(&sc.total)
+}
+
+// ErrCheatingDetected is returned (wrapped with details) when the client
+// fails a cryptographic check which an honest client never fails
+var ErrCheatingDetected = errors.New("cheating detected")
+
+// CheatEvidence is the error returned when a check in processDecommit
+// fails. It is written to the audit log so that operators can tell bugs from
+// attacks. It never contains secrets, only hashes of the compared values.
+type CheatEvidence struct {
+	Sid string `json:"sid"`
+	// Circuit is the number of the circuit whose check failed
+	Circuit int `json:"circuit"`
+	// Check is either "commitment" or "output"
+	Check string `json:"check"`
+	// NotarySide and ClientSide are hex-encoded sha256 hashes of the values
+	// which were expected to be equal
+	NotarySide string `json:"notarySide"`
+	ClientSide string `json:"clientSide"`
+}
+
+func (e *CheatEvidence) Error() string {
+	return fmt.Sprintf("%s: %s mismatch in circuit %d", ErrCheatingDetected, e.Check, e.Circuit)
+}
+
+func (e *CheatEvidence) Unwrap() error {
+	return ErrCheatingDetected
+}
+
+const (
+	// PROTOCOL_LEGACY clients use the global OT manager on its fixed port
+	PROTOCOL_LEGACY = 1
+	// PROTOCOL_POOLED_OT clients get an OT manager from the pool. The port of
+	// that manager is sent to the client in response to init.
+	PROTOCOL_POOLED_OT = 2
+	// PROTOCOL_HALF_GATES clients support circuits garbled with half-gates.
+	// The garbling scheme in use is sent to the client in response to init.
+	PROTOCOL_HALF_GATES = 3
+	// PROTOCOL_SIGNATURE_SCHEME clients get the signature scheme byte
+	// appended to the response to commitHash. The byte is also signed.
+	PROTOCOL_SIGNATURE_SCHEME = 4
+	// PROTOCOL_SESSION_METRICS clients get the session metrics (see
+	// SessionMetrics) appended to the response to commitHash. The metrics
+	// are also signed.
+	PROTOCOL_SESSION_METRICS = 5
+	// PROTOCOL_TYPED_COMMITMENTS clients append a list of typed commitments
+	// (see ParseCommitments) to the body of commitHash. The list is signed.
+	PROTOCOL_TYPED_COMMITMENTS = 6
+	// PROTOCOL_OT_BROKER clients may be told to connect for OT to a broker
+	// (see ot_broker) instead of to the notary. The response to init then
+	// also has the broker's address and a token to send to the broker.
+	PROTOCOL_OT_BROKER = 7
+	// PROTOCOL_CHUNKED_AEAD clients get all encrypted responses in the
+	// chunked format of u.ChunkedAEADWriter instead of as a single AES-GCM
+	// ciphertext
+	PROTOCOL_CHUNKED_AEAD = 8
+	// PROTOCOL_AEAD_FRAMES clients get all encrypted responses as
+	// length-prefixed frames (see u.AEADFrameWriter) which can be decrypted
+	// as they arrive. The frames are numbered across the whole session.
+	PROTOCOL_AEAD_FRAMES = 9
+	// PROTOCOL_ATTESTATION_COUNTER clients get the attestation counter of
+	// the signing key (see key_manager.AttestationCounter) appended to the
+	// response to commitHash. The counter is also signed.
+	PROTOCOL_ATTESTATION_COUNTER = 10
+	// PROTOCOL_TRANSFER_PLAN clients append the log2 of the frame size of
+	// PROTOCOL_AEAD_FRAMES to init, as recommended by the transfer plan of
+	// /probe/estimate (see probe.Plan)
+	PROTOCOL_TRANSFER_PLAN = 11
+	// PROTOCOL_OT_HOST clients get the host to connect to for OT appended
+	// to the response to init, for notaries whose OT ports are reachable at
+	// another address than the public API
+	PROTOCOL_OT_HOST = 12
+	// PROTOCOL_TAG_TRANSCRIPT clients get the tag verification signed as a
+	// transcript (see at.TagTranscript) which includes the AAD, the record
+	// IV and the hash of the MPC outputs instead of only the ciphertext
+	PROTOCOL_TAG_TRANSCRIPT = 13
+	// PROTOCOL_TAG_RECORDS clients may verify the tags of several records,
+	// each with its own AAD, and get a transcript of version
+	// at.TRANSCRIPT_VERSION_RECORDS
+	PROTOCOL_TAG_RECORDS = 14
+	// PROTOCOL_BINARY_CIPHERTEXT clients send the ciphertexts of
+	// tagVerification as base64 or hex strings instead of arrays of decimal
+	// strings, which are deprecated
+	PROTOCOL_BINARY_CIPHERTEXT = 15
+	// PROTOCOL_ATTESTATION_DOCUMENT clients get a signed AttestationDocument
+	// in the commitHash response instead of the signature over a
+	// concatenation of the session's values
+	PROTOCOL_ATTESTATION_DOCUMENT = 16
+	// PROTOCOL_MERGED_STEPS clients may send the merged handshake steps of
+	// Protocol, which save the round trips of the steps without input
+	PROTOCOL_MERGED_STEPS = 17
+	// PROTOCOL_REQUEST_RECORDS clients send the count of the TLS records of
+	// their request in init, which lets the request span several records
+	PROTOCOL_REQUEST_RECORDS = 18
+	// PROTOCOL_OT_IMPLEMENTATION clients append the OT implementation which
+	// they use to init (see InitOTImplementation). Older clients only use
+	// the native OT.
+	PROTOCOL_OT_IMPLEMENTATION = 19
+	// PROTOCOL_LATEST is the highest protocol version the notary supports
+	PROTOCOL_LATEST = PROTOCOL_OT_IMPLEMENTATION
+)
+
+const (
+	// SIGNATURE_SCHEME_RANDOMIZED is ECDSA with a random nonce
+	SIGNATURE_SCHEME_RANDOMIZED = 0
+	// SIGNATURE_SCHEME_RFC6979 is deterministic ECDSA as per RFC 6979
+	SIGNATURE_SCHEME_RFC6979 = 1
+)
+
+// initBodySize is the size of the init message body without the optional
+// protocol version byte, the frame size byte of PROTOCOL_TRANSFER_PLAN, the
+// record count byte of PROTOCOL_REQUEST_RECORDS and the OT implementation
+// byte of PROTOCOL_OT_IMPLEMENTATION
+const initBodySize = 66
+
+// initMaxBodySize is the size of the init message body with all optional
+// bytes
+const initMaxBodySize = initBodySize + 4
+
+// InitProtocolVersion returns the protocol version requested by the client
+// in the init message. Legacy clients don't send a version.
+func InitProtocolVersion(body []byte) int {
+	if len(body) > initBodySize && len(body) <= initMaxBodySize {
+		return int(body[initBodySize])
+	}
+	return PROTOCOL_LEGACY
+}
+
+// otImplementations are the OT implementations by their byte in init
+var otImplementations = []ote.Implementation{ote.IMPLEMENTATION_NATIVE, ote.IMPLEMENTATION_GO}
+
+// InitOTImplementation returns the OT implementation which the client uses
+// according to the init message: the native OT for clients before
+// PROTOCOL_OT_IMPLEMENTATION, "" if the byte is missing or unknown
+func InitOTImplementation(body []byte) ote.Implementation {
+	if InitProtocolVersion(body) < PROTOCOL_OT_IMPLEMENTATION {
+		return ote.IMPLEMENTATION_NATIVE
+	}
+	if len(body) != initMaxBodySize || int(body[initMaxBodySize-1]) >= len(otImplementations) {
+		return ""
+	}
+	return otImplementations[body[initMaxBodySize-1]]
+}
+
+// parseFrameSize returns the frame size whose log2 the client sent in init.
+// It must be within the bounds of the transfer plan.
+func parseFrameSize(log2 byte) (int, error) {
+	if log2 >= 32 {
+		return 0, invalidMessage("invalid frame size")
+	}
+	frameSize := 1 << log2
+	if frameSize < u.AEAD_FRAME_MIN_SIZE || frameSize > u.AEAD_FRAME_MAX_SIZE {
+		return 0, invalidMessage("frame size is outside the bounds of the transfer plan")
+	}
+	return frameSize, nil
+}
+
+// SessionMetrics are non-sensitive facts about a session which the notary
+// may include in the signed attestation
+type SessionMetrics struct {
+	ProtocolVersion int    `json:"protocolVersion"`
+	GarblingScheme  string `json:"garblingScheme"`
+	// DurationSeconds is the time from init to commitHash
+	DurationSeconds int64 `json:"durationSeconds"`
+	// RequestBlocks is the amount of AES blocks in the client's request
+	RequestBlocks int `json:"requestBlocks"`
+	// GhashBlocks is the amount of GHASH input blocks (AAD, request and
+	// lengths)
+	GhashBlocks int `json:"ghashBlocks"`
+	// Ot is the OT traffic of the session, only in an AttestationDocument
+	Ot *OtStats `json:"ot,omitempty"`
+}
+
 // The description of each step of the TLS PRF computation, both inside the
 // garbled circuit and outside of it:
 // [REF 1] https://github.com/tlsnotary/circuits/blob/master/README
@@ -50,9 +246,9 @@ type Session struct {
 	g     *garbler.Garbler
 	p2pc  *paillier2pc.Paillier2PC
 	ghash *ghash.GHASH
-	// gctrBlockShare is notary's share of the AES-GCM's GCTR block
-	// for the client's request
-	gctrBlockShare []byte
+	// gctrBlockShares are notary's shares of the AES-GCM's GCTR blocks
+	// for the client's request, one for each TLS record
+	gctrBlockShares [][]byte
 	// serverPubkey is EC pubkey used during 3-party ECDH secret negotiation.
 	// This pubkey will be included in notary's signature
 	serverPubkey []byte
@@ -62,6 +258,8 @@ type Session struct {
 	// ghashInputsBlob contains a blob of inputs for the ghash function. It will
 	// be included into the notary's final signature.
 	ghashInputsBlob []byte
+	// ghashStep2Done is set once Ghash_step2 ran
+	ghashStep2Done bool
 	// cwkShare is notary's xor share of client_write_key
 	cwkShare []byte
 	// civShare is notary's xor share of client_write_iv
@@ -74,16 +272,71 @@ type Session struct {
 	notaryKey []byte
 	// clientKey is a symmetric key used to decrypt messages FROM the client
 	clientKey []byte
+	// toClientSeq is the sequence number of the next frame encrypted with
+	// notaryKey, see PROTOCOL_AEAD_FRAMES
+	toClientSeq uint64
+	// frameSize is the plaintext size of the frames of PROTOCOL_AEAD_FRAMES
+	frameSize int
 	// SigningKey is an ephemeral key used to sign the notarization session
 	SigningKey ecdsa.PrivateKey
+	// KeyData is SigningKey certified by the master key, see
+	// SignedAttestation
+	KeyData []byte
+	// AttestationCounter counts the sessions signed with SigningKey
+	AttestationCounter *key_manager.AttestationCounter
 	// StorageDir is where the blobs from the client are stored
 	StorageDir string
-	// msgsSeen contains a list of all messages seen from the client
-	msgsSeen []int
-
-	Ot               *ote.Manager
-	lastOtResponse   []byte
-	lastResponseFrom string
+	// StorageRoot is the dir in which StorageDir is created
+	StorageRoot string
+	// blobSize is the size of the client's truth tables of the session's
+	// circuits, which depends on c6Count
+	blobSize int64
+	// MaxBlobSize is the max size of the blob uploaded with setBlob. 0 means
+	// no limit.
+	MaxBlobSize int64
+	// DiscardConsumedBlobs releases the disk space of truth tables in
+	// blobForNotary once their circuit was evaluated, see
+	// discardBlobForNotary
+	DiscardConsumedBlobs bool
+	// blobConsumed and blobDiscarded are set by circuit number when its truth
+	// tables were evaluated and when their disk space was released
+	blobConsumed  [8]bool
+	blobDiscarded [8]bool
+	// BlobBudget is the memory which the blobs of all sessions may take
+	// instead of being written to disk, or nil
+	BlobBudget *BlobBudget
+	// memoryBlob is the blob if it is kept in memory, guarded by
+	// memoryBlobMutex
+	memoryBlob      *memoryBlob
+	memoryBlobMutex sync.Mutex
+	// blobChunked is set when the first setBlobChunk arrives and
+	// blobCommitted by setBlob, both guarded by blobChunkMutex, which also
+	// lets one chunk be appended at a time
+	blobChunked    bool
+	blobCommitted  bool
+	blobChunkMutex sync.Mutex
+	// fsm checks that messages are received in the correct order and
+	// (where applicable) received only once. This is crucial for the
+	// security of the TLSNotary protocol.
+	fsm protocolFSM
+	// lastStep is the last ORDERED step, kept for retries. lastStepMutex
+	// guards it and fsm.
+	lastStep      *cachedStep
+	lastStepMutex sync.Mutex
+
+	Ot *ote.Manager
+	// otTasks are the OT exchanges of the steps, see startOt
+	otTasks      map[string]*otTask
+	otLast       *otTask
+	otTasksMutex sync.Mutex
+	// otStats counts the OT traffic, guarded by otTasksMutex
+	otStats OtStats
+	// pre are the client-independent circuit inputs, see startPrecompute
+	pre            *precomputed
+	precomputeOnce sync.Once
+	// otProgress is the progress of the latest OT response
+	otProgress      ote.Progress
+	otProgressMutex sync.Mutex
 
 	// PmsOuterHashState is the state of the outer hash of HMAC needed to compute the PMS
 	PmsOuterHashState []byte
@@ -95,26 +348,37 @@ type Session struct {
 	encodedOutput [][]byte
 	// c6CheckValue is encoded outputs and decoding table which must the sent to
 	// Client as part of dual execution garbling. We store it here until Client
-	// sends her commitment. Then we send it out.
-	c6CheckValue []byte
+	// sends her commitment. Then we send it out. The parts are not
+	// concatenated to avoid another copy of them.
+	c6CheckValue [][]byte
 	// meta contains information about circuits
 	meta []*meta.Circuit
-	// Tt are file handles for truth tables which are used
-	// to stream directly to the HTTP response (saving memory)
-	Tt [][]*os.File
-	// dt are decoding tables for each execution of each garbled circuit
-	dt [][][]byte
-	// streamCounter is used when client uploads his blob to the notary
-	streamCounter *StreamCounter
+	// Tt are the truth table files which are streamed directly to the HTTP
+	// response (saving memory)
+	Tt [][]*TtFile
+	// dt are the decoding tables of each garbled circuit, those of all its
+	// executions concatenated in one buffer
+	dt [][]byte
+	// streamCounter is used when client uploads his blob to the notary. It
+	// is set by setBlob or the first setBlobChunk while getUploadProgress
+	// may read it.
+	streamCounter atomic.Pointer[StreamCounter]
 	// Gp is used to access the garbled pool
 	Gp *garbled_pool.GarbledPool
 	// Tv is used to access tag verification manager
 	Tv *at.TagVerificationManager
 	// Ts is used to access tag signing manager
 	Ts *at.TagSigningManager
-	// tag verification masks obtained from prepTagVerification step
-	tagMask string
-	pohMask string
+	// TransparencyLog logs every signature of the session before it is
+	// sent, nil if the log is disabled
+	TransparencyLog *transparency_log.Log
+	// tag verification masks obtained from prepTagVerification step, with
+	// a tag mask per record
+	tagMasks []string
+	pohMask  string
+	// recordIvs are the explicit nonces of the records whose tags are
+	// verified
+	recordIvs [][]byte
 	// Sid is the id of this session, used to signal to session manager when the
 	// session can be destroyed
 	Sid string
@@ -123,267 +387,598 @@ type Session struct {
 	DestroyChan chan string
 	// notify manager that the session releases OT ownership
 	OtReleaseChan chan string
+	// destroyOnce and releaseOtOnce make sure that each signal is sent to
+	// the session manager only once even if many goroutines fail at the same
+	// time
+	destroyOnce   sync.Once
+	releaseOtOnce sync.Once
+	// ProtocolVersion is the protocol version negotiated in init
+	ProtocolVersion int
+	// OtBroker registers the session's OT with the broker. Nil when no
+	// broker is configured.
+	OtBroker *ot_broker.Client
+	// DeterministicSignatures makes the session sign with RFC 6979 nonces
+	DeterministicSignatures bool
+	// AttestationMetrics makes the session include SessionMetrics in the
+	// signed attestation of clients which support it
+	AttestationMetrics bool
+	// VerifierOnly makes the session return the attestation document and
+	// the verified tags without signing them, for clients which verify the
+	// results themselves. The client needs PROTOCOL_ATTESTATION_DOCUMENT.
+	VerifierOnly bool
+	// startTime is when init was received
+	startTime time.Time
+	// c6Count is the amount of c6 executions requested in init
+	c6Count int
+	// recordCount is the amount of TLS records of the client's request, 1
+	// before PROTOCOL_REQUEST_RECORDS
+	recordCount int
+	// handoffNonce identifies the latest exported handoff token, see
+	// PrepareHandoff
+	handoffNonce []byte
+	// Mem tracks the memory held by the session
+	Mem MemAccountant
+	// SLA limits the durations of the phases of the session
+	SLA SLA
+	phaseTracker
+}
+
+// ReleaseOt signals to the session manager that this session doesn't need
+// OT anymore. Only the first call has an effect.
+func (s *Session) ReleaseOt() {
+	s.releaseOtOnce.Do(func() {
+		signal(s.OtReleaseChan, s.Sid, "OT release")
+	})
+}
+
+// Destroy releases OT and signals to the session manager that this session
+// must be destroyed. It can be called many times from any goroutine, only
+// the first call has an effect.
+func (s *Session) Destroy() {
+	s.ReleaseOt()
+	s.destroyOnce.Do(func() {
+		signal(s.DestroyChan, s.Sid, "destroy")
+	})
+}
+
+// signalsDropped counts destroy and OT release signals which were dropped
+// because the session manager's chans were full
+var signalsDropped = expvar.NewInt("session_signals_dropped")
+
+// signal sends sid to ch without blocking. If ch is full, the signal is
+// dropped and the session will be removed later as a stale session.
+func signal(ch chan string, sid string, name string) {
+	select {
+	case ch <- sid:
+	default:
+		signalsDropped.Add(1)
+		log.Println("Error: dropped", name, "signal for sid", sid, "because the channel is full")
+	}
 }
 
 // Init is the first message from the client. It starts Oblivious Transfer
 // setup and we also initialize all of Session's structures.
-func (s *Session) Init(body []byte) []byte {
-	s.sequenceCheck(1)
+func (s *Session) Init(body []byte) ([]byte, error) {
+	if len(body) < initBodySize || len(body) > initMaxBodySize {
+		return nil, invalidMessage("invalid body size %d", len(body))
+	}
 	s.g = new(garbler.Garbler)
 	s.e = new(evaluator.Evaluator)
 	s.p2pc = new(paillier2pc.Paillier2PC)
 	s.ghash = new(ghash.GHASH)
 	// the first 64 bytes are client pubkey for ECDH
 	o := 0
-	s.clientKey, s.notaryKey = s.getSymmetricKeys(body[o:o+64], &s.SigningKey)
+	var err error
+	s.clientKey, s.notaryKey, err = s.getSymmetricKeys(body[o:o+64], &s.SigningKey)
+	if err != nil {
+		return nil, err
+	}
 	o += 64
 	c6Count := int(new(big.Int).SetBytes(body[o : o+2]).Uint64())
 	o += 2
-
-	u.Assert(len(body) == o)
+	s.c6Count = c6Count
+	s.startTime = time.Now()
+	if len(body) > o {
+		// the optional protocol version was already parsed by the session manager
+		o += 1
+	}
+	s.frameSize = u.CHUNKED_AEAD_CHUNK_SIZE
+	if s.ProtocolVersion >= PROTOCOL_TRANSFER_PLAN {
+		if len(body) <= o {
+			return nil, invalidMessage("missing frame size")
+		}
+		s.frameSize, err = parseFrameSize(body[o])
+		if err != nil {
+			return nil, err
+		}
+		o += 1
+	}
+	s.recordCount = 1
+	if s.ProtocolVersion >= PROTOCOL_REQUEST_RECORDS {
+		if len(body) <= o {
+			return nil, invalidMessage("missing record count")
+		}
+		s.recordCount = int(body[o])
+		o += 1
+	}
+	if s.ProtocolVersion >= PROTOCOL_OT_IMPLEMENTATION {
+		// the OT implementation was already checked by the notary
+		if len(body) <= o {
+			return nil, invalidMessage("missing OT implementation")
+		}
+		o += 1
+	}
+	if err = checkSize(body, o); err != nil {
+		return nil, err
+	}
+	if s.recordCount < 1 || s.recordCount > garbled_pool.MAX_REQUEST_RECORDS {
+		return nil, invalidMessage("invalid record count %d", s.recordCount)
+	}
+	// every record has at least one AES block
+	if c6Count < s.recordCount || c6Count > s.recordCount*garbled_pool.MAX_RECORD_C6_COUNT {
+		return nil, invalidMessage("invalid c6 count %d for %d records", c6Count, s.recordCount)
+	}
+	// the client uploads its truth tables of the session's circuits, reject
+	// the session before it takes garblings from the pool if they are too
+	// large
+	s.blobSize = s.Gp.TruthTableSize(c6Count, s.recordCount)
+	if s.MaxBlobSize > 0 && s.blobSize > s.MaxBlobSize {
+		return nil, blobTooLarge("the blob of %d c6 executions is %d bytes, the limit is %d", c6Count, s.blobSize, s.MaxBlobSize)
+	}
 
 	s.ghash.Init()
+	s.Ot.SetProgressCallback(s.setOtProgress)
+	// don't keep OT busy until the session times out when the client is gone
+	s.Ot.SetDisconnectCallback(s.Destroy)
 
-	curDir, err := filepath.Abs(filepath.Dir(os.Args[0]))
-	if err != nil {
-		panic(err)
-	}
-	s.StorageDir = filepath.Join(filepath.Dir(curDir), u.RandString())
+	s.StorageDir = filepath.Join(s.StorageRoot, u.RandString())
 	err = os.Mkdir(s.StorageDir, 0755)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// get already garbled circuits ...
-	blobs := s.Gp.GetBlobs(c6Count)
+	blobs := s.Gp.GetBlobs(c6Count, s.recordCount)
 	// and separate into input labels, truth tables, decoding table
 	il := make([][][]byte, len(s.Gp.Circuits))
-	s.Tt = make([][]*os.File, len(s.Gp.Circuits))
-	s.dt = make([][][]byte, len(s.Gp.Circuits))
+	s.Tt = make([][]*TtFile, len(s.Gp.Circuits))
+	s.dt = make([][]byte, len(s.Gp.Circuits))
 	// depending on the number of circuit executions, there may be more than
 	// one Blob for every circuit
 	for i := 1; i < len(s.Gp.Circuits); i++ {
 		il[i] = make([][]byte, len(blobs[i]))
-		s.Tt[i] = make([]*os.File, len(blobs[i]))
-		s.dt[i] = make([][]byte, len(blobs[i]))
+		s.Tt[i] = make([]*TtFile, len(blobs[i]))
+		dtSize := 0
+		for _, blob := range blobs[i] {
+			dtSize += len(*blob.Dt)
+		}
+		// allocate the decoding tables of all executions at once
+		s.dt[i] = make([]byte, 0, dtSize)
 		for j, blob := range blobs[i] {
 			il[i][j] = *blob.Il
-			s.Tt[i][j] = blob.TtFile
-			s.dt[i][j] = *blob.Dt
+			s.Tt[i][j] = newTtFile(blob.TtFile)
+			s.dt[i] = append(s.dt[i], *blob.Dt...)
+			s.Mem.Add(MEM_LABELS, len(il[i][j]))
 		}
+		s.Mem.Add(MEM_DECODING_TABLES, dtSize)
 	}
 
 	s.meta = s.Gp.Circuits
-	s.g.Init(il, s.meta, c6Count)
-	s.e.Init(s.meta, c6Count)
+	s.g.Init(il, s.meta, c6Count, s.recordCount)
+	s.e.Init(s.meta, c6Count, s.recordCount, s.Gp.HalfGates)
 	s.hisCommitment = make([][]byte, len(s.g.Cs))
 	s.encodedOutput = make([][]byte, len(s.g.Cs))
 
+	// the circuit inputs which don't depend on the client are prepared
+	// while the client downloads and uploads the blobs
+	s.startPrecompute()
+
 	s.p2pc.Init()
-	return nil
+	if s.ProtocolVersion >= PROTOCOL_POOLED_OT {
+		route, err := s.otRoute()
+		if err != nil {
+			return nil, err
+		}
+		return s.encryptToClient(route), nil
+	}
+	return nil, nil
+}
+
+// otRoute tells the client how to connect for OT
+func (s *Session) otRoute() ([]byte, error) {
+	// the port to which to connect for OT
+	resp := make([]byte, 2)
+	binary.BigEndian.PutUint16(resp, uint16(s.Ot.AdvertisedPort()))
+	if s.ProtocolVersion >= PROTOCOL_HALF_GATES {
+		// and which garbling scheme to use
+		scheme := byte(0)
+		if s.Gp.HalfGates {
+			scheme = 1
+		}
+		resp = append(resp, scheme)
+	}
+	if s.ProtocolVersion >= PROTOCOL_OT_BROKER {
+		// addrLen(1) | broker address | token(16), or only addrLen 0
+		// if the client must connect to the port above directly
+		brokerRoute, err := s.brokerRoute()
+		if err != nil {
+			return nil, err
+		}
+		resp = append(resp, brokerRoute...)
+	}
+	if s.ProtocolVersion >= PROTOCOL_OT_HOST {
+		// hostLen(1) | host, or only hostLen 0 if the client must connect
+		// to the host of the public API
+		host := s.Ot.AdvertisedHost()
+		resp = u.Concat(resp, []byte{byte(len(host))}, []byte(host))
+	}
+	return resp, nil
 }
 
-// GetBlob returns file handles to truth tables
-func (s *Session) GetBlob(encrypted []byte) []*os.File {
-	s.sequenceCheck(3)
-	// flatten into one slice
-	var flat []*os.File
+// brokerRoute registers this session's OT with the broker and returns the
+// broker's address and the token as sent in response to init
+func (s *Session) brokerRoute() ([]byte, error) {
+	if s.OtBroker == nil {
+		return []byte{0}, nil
+	}
+	token := u.GetRandom(ot_broker.TokenSize)
+	err := s.OtBroker.Register(token, s.Ot.Port())
+	if err != nil {
+		return nil, err
+	}
+	return u.Concat([]byte{byte(len(s.OtBroker.PublicAddr))}, []byte(s.OtBroker.PublicAddr), token), nil
+}
+
+// GetBlob returns the truth tables. The caller must Release the blob when
+// done reading it.
+func (s *Session) GetBlob(encrypted []byte) (*Blob, error) {
+	if _, _, err := s.beginStep(stepOf("getBlob"), nil); err != nil {
+		return nil, err
+	}
+	return s.acquireBlob()
+}
+
+// ReleaseTt drops the session's references to its truth table files.
+// onClosed is called with the path of each file once no getBlob stream
+// reads it anymore.
+func (s *Session) ReleaseTt(onClosed func(path string)) {
 	for _, sliceOfFiles := range s.Tt {
-		if len(sliceOfFiles) == 0 {
-			continue
+		for _, f := range sliceOfFiles {
+			f.SafeRelease(onClosed)
 		}
-		flat = append(flat, sliceOfFiles...)
 	}
-	return flat
 }
 
-// SetBlobChunk stores a blob from the client.
-func (s *Session) SetBlob(respBody io.ReadCloser) []byte {
-	s.sequenceCheck(4)
+// SetBlob stores a blob from the client. The blob may be compressed with a
+// supported Content-Encoding, see BlobEncodingSupported. The size is counted
+// and limited after decompression. The blob is kept in memory if it fits
+// into the BlobBudget, otherwise it is written to disk. After setBlobChunk
+// the body is empty and only completes the upload.
+func (s *Session) SetBlob(respBody io.ReadCloser, encoding string) ([]byte, error) {
+	if _, _, err := s.beginStep(stepOf("setBlob"), nil); err != nil {
+		return nil, err
+	}
+	// a blob uploaded with setBlobChunk is finished with an empty setBlob
+	if chunked, err := s.commitChunks(respBody); chunked || err != nil {
+		return nil, err
+	}
+	reader, err := blobDecoder(respBody, encoding)
+	if err != nil {
+		return nil, err
+	}
+	// the blob can't be larger than the truth tables of the session, nor
+	// than the deployment's limit, which Init already checked
+	counter := &StreamCounter{total: 0, limit: s.blobSize}
+	s.streamCounter.Store(counter)
+	body := io.TeeReader(chaos.Reader(chaos.BlobCorrupt, reader), counter)
+	if m := s.newMemoryBlob(); m != nil {
+		s.memoryBlobMutex.Lock()
+		s.memoryBlob = m
+		s.memoryBlobMutex.Unlock()
+		if _, err = io.Copy(m, body); err != nil {
+			s.ReleaseMemoryBlob()
+			return nil, err
+		}
+		return nil, nil
+	}
 	path := filepath.Join(s.StorageDir, "blobForNotary")
 	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	s.streamCounter = &StreamCounter{total: 0}
-	body := io.TeeReader(respBody, s.streamCounter)
-	_, err2 := io.Copy(file, body)
-	if err2 != nil {
-		panic("err2 != nil")
+	defer file.Close()
+	_, err = io.Copy(file, body)
+	if errors.Is(err, ErrBlobTooLarge) {
+		// don't leave the partial blob on disk until the session is removed
+		file.Close()
+		os.Remove(path)
 	}
-	return nil
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
 }
 
-func (s *Session) GetUploadProgress(dummy []byte) []byte {
-	// special case. This message may be repeated many times
-	s.sequenceCheck(100)
+func (s *Session) GetUploadProgress(dummy []byte) ([]byte, error) {
+	// special case. This message may be repeated many times, see
+	// REPEATABLE
 	bytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(bytes, s.streamCounter.total)
-	return s.encryptToClient(bytes)
+	// there is no counter yet if setBlob failed before it read the body
+	if counter := s.streamCounter.Load(); counter != nil {
+		binary.BigEndian.PutUint32(bytes, counter.Total())
+	}
+	return s.encryptToClient(bytes), nil
+}
+
+// GetOtProgress returns the progress of the latest OT response. Like
+// getUploadProgress, it may be sent at any time and any number of times.
+// It lets the client tell a slow OT transfer from a stuck one.
+func (s *Session) GetOtProgress(dummy []byte) ([]byte, error) {
+	s.otProgressMutex.Lock()
+	progress := s.otProgress
+	s.otProgressMutex.Unlock()
+
+	return json.Marshal(progress)
+}
+
+func (s *Session) setOtProgress(progress ote.Progress) {
+	s.otProgressMutex.Lock()
+	s.otProgress = progress
+	s.otProgressMutex.Unlock()
 }
 
 // Step1 starts a Paillier 2PC of EC point addition
-func (s *Session) Step1(encrypted []byte) []byte {
-	s.sequenceCheck(5)
-	body := s.decryptFromClient(encrypted)
+func (s *Session) Step1(encrypted []byte) ([]byte, error) {
+	body, err := s.decryptFromClient(encrypted)
+	if err != nil {
+		return nil, err
+	}
 	var resp []byte
-	s.serverPubkey, resp = s.p2pc.Step1(body)
-	return s.encryptToClient(resp)
+	s.serverPubkey, resp, err = s.p2pc.Step1(body)
+	if err != nil {
+		return nil, invalidMessage("%s", err)
+	}
+	return s.encryptToClient(resp), nil
 }
 
-func (s *Session) Step2(encrypted []byte) []byte {
-	s.sequenceCheck(6)
-	body := s.decryptFromClient(encrypted)
-	return s.encryptToClient(s.p2pc.Step2(body))
+func (s *Session) Step2(encrypted []byte) ([]byte, error) {
+	body, err := s.decryptFromClient(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.p2pc.Step2(body)
+	if err != nil {
+		return nil, invalidMessage("%s", err)
+	}
+	return s.encryptToClient(resp), nil
 }
 
-func (s *Session) Step3(encrypted []byte) []byte {
-	s.sequenceCheck(7)
-	body := s.decryptFromClient(encrypted)
-	return s.encryptToClient(s.p2pc.Step3(body))
+func (s *Session) Step3(encrypted []byte) ([]byte, error) {
+	body, err := s.decryptFromClient(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.p2pc.Step3(body)
+	if err != nil {
+		return nil, invalidMessage("%s", err)
+	}
+	return s.encryptToClient(resp), nil
 }
 
-func (s *Session) Step4(encrypted []byte) []byte {
-	s.sequenceCheck(8)
-	body := s.decryptFromClient(encrypted)
-	s.notaryPMSShare = s.p2pc.Step4(body)
-	return nil
+func (s *Session) Step4(encrypted []byte) ([]byte, error) {
+	body, err := s.decryptFromClient(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	s.notaryPMSShare, err = s.p2pc.Step4(body)
+	if err != nil {
+		return nil, invalidMessage("%s", err)
+	}
+	return nil, nil
 }
 
 // [REF 1] Step 2
-func (s *Session) C1_step1(encrypted []byte) []byte {
-	s.sequenceCheck(9)
-	s.setCircuitInputs(1, s.notaryPMSShare, s.g.Cs[1].Masks[1])
+func (s *Session) C1_step1(encrypted []byte) ([]byte, error) {
+	// the circuits are evaluated from here on, so the whole blob must be
+	// there
+	if err := s.checkBlobComplete(); err != nil {
+		return nil, err
+	}
+	s.setNotaryInputs(1, s.notaryPMSShare)
 	out := s.c_step1(1)
-	return s.encryptToClient(out)
+	return s.encryptToClient(out), nil
+}
+
+// Step4_c1_step1 is Step4 followed by C1_step1, which has no input, for
+// PROTOCOL_MERGED_STEPS clients
+func (s *Session) Step4_c1_step1(encrypted []byte) ([]byte, error) {
+	if _, err := s.Step4(encrypted); err != nil {
+		return nil, err
+	}
+	return s.C1_step1(nil)
 }
 
 // [REF 1] Step 2
-func (s *Session) C1_step2(encrypted []byte) []byte {
-	s.sequenceCheck(10)
-	body := s.decryptFromClient(encrypted)
-	return s.encryptToClient(s.common_step2(1, body))
+func (s *Session) C1_step2(encrypted []byte) ([]byte, error) {
+	return s.step2(1, encrypted)
 }
 
 // [REF 1] Step 4. N computes a1 and passes it to C.
-func (s *Session) C1_step3(encrypted []byte) []byte {
-	s.sequenceCheck(11)
-	body := s.decryptFromClient(encrypted)
-	output := s.processDecommit(1, body[:len(body)-32])
+func (s *Session) C1_step3(encrypted []byte) ([]byte, error) {
+	body, err := s.decryptFromClient(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkMinSize(body, 32); err != nil {
+		return nil, err
+	}
+	output, err := s.processDecommit(1, body[:len(body)-32])
+	if err != nil {
+		return nil, err
+	}
 	hisInnerHash := body[len(body)-32:]
 	// unmask the output
 	s.PmsOuterHashState = u.XorBytes(output[0:32], s.g.Cs[1].Masks[1])
 	a1 := u.FinishHash(s.PmsOuterHashState, hisInnerHash)
-	return s.encryptToClient(a1)
+	return s.encryptToClient(a1), nil
 }
 
 // [REF 1] Step 6. N computes a2 and passes it to C.
-func (s *Session) C1_step4(encrypted []byte) []byte {
-	s.sequenceCheck(12)
-	body := s.decryptFromClient(encrypted)
+func (s *Session) C1_step4(encrypted []byte) ([]byte, error) {
+	body, err := s.decryptFromClient(encrypted)
+	if err != nil {
+		return nil, err
+	}
 	a2 := u.FinishHash(s.PmsOuterHashState, body)
-	return s.encryptToClient(a2)
+	return s.encryptToClient(a2), nil
 }
 
 // [REF 1] Step 8. N computes p2 and passes it to C.
-func (s *Session) C1_step5(encrypted []byte) []byte {
-	s.sequenceCheck(13)
-	body := s.decryptFromClient(encrypted)
+func (s *Session) C1_step5(encrypted []byte) ([]byte, error) {
+	body, err := s.decryptFromClient(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	p2 := u.FinishHash(s.PmsOuterHashState, body)
+	return s.encryptToClient(p2), nil
+}
+
+// C1_step5_c2_step1 is C1_step5 followed by C2_step1, which has no input,
+// for PROTOCOL_MERGED_STEPS clients. The response is p2 | the output of
+// C2_step1.
+func (s *Session) C1_step5_c2_step1(encrypted []byte) ([]byte, error) {
+	body, err := s.decryptFromClient(encrypted)
+	if err != nil {
+		return nil, err
+	}
 	p2 := u.FinishHash(s.PmsOuterHashState, body)
-	return s.encryptToClient(p2)
+	return s.encryptToClient(p2, s.c2_step1()), nil
 }
 
 // [REF 1] Step 10.
-func (s *Session) C2_step1(encrypted []byte) []byte {
-	s.sequenceCheck(14)
-	s.setCircuitInputs(2, s.PmsOuterHashState, s.g.Cs[2].Masks[1])
-	out := s.c_step1(2)
-	return s.encryptToClient(out)
+func (s *Session) C2_step1(encrypted []byte) ([]byte, error) {
+	return s.encryptToClient(s.c2_step1()), nil
 }
 
-// [REF 1] Step 12.
-func (s *Session) C2_step2(encrypted []byte) []byte {
-	s.sequenceCheck(15)
-	body := s.decryptFromClient(encrypted)
-	return s.encryptToClient(s.common_step2(2, body))
+func (s *Session) c2_step1() []byte {
+	s.setNotaryInputs(2, s.PmsOuterHashState)
+	return s.c_step1(2)
+}
 
+// [REF 1] Step 12.
+func (s *Session) C2_step2(encrypted []byte) ([]byte, error) {
+	return s.step2(2, encrypted)
 }
 
 // [REF 1] Step 14 and Step 21. N computes a1 and a1 and sends it to C.
-func (s *Session) C2_step3(encrypted []byte) []byte {
-	s.sequenceCheck(16)
-	body := s.decryptFromClient(encrypted)
-	output := s.processDecommit(2, body[:len(body)-64])
+func (s *Session) C2_step3(encrypted []byte) ([]byte, error) {
+	body, err := s.decryptFromClient(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkMinSize(body, 64); err != nil {
+		return nil, err
+	}
+	output, err := s.processDecommit(2, body[:len(body)-64])
+	if err != nil {
+		return nil, err
+	}
 	a1inner := body[len(body)-64 : len(body)-32]
 	a1inner_vd := body[len(body)-32:]
 	// unmask the output
 	s.MsOuterHashState = u.XorBytes(output[0:32], s.g.Cs[2].Masks[1])
 	a1 := u.FinishHash(s.MsOuterHashState, a1inner)
 	a1_vd := u.FinishHash(s.MsOuterHashState, a1inner_vd)
-	return s.encryptToClient(u.Concat(a1, a1_vd))
+	return s.encryptToClient(a1, a1_vd), nil
 }
 
 // [REF 1] Step 16 and Step 23. N computes a2 and verify_data and sends it to C.
-func (s *Session) C2_step4(encrypted []byte) []byte {
-	s.sequenceCheck(17)
-	body := s.decryptFromClient(encrypted)
+func (s *Session) C2_step4(encrypted []byte) ([]byte, error) {
+	body, err := s.decryptFromClient(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	a2, verifyData, err := s.c2_step4(body)
+	if err != nil {
+		return nil, err
+	}
+	return s.encryptToClient(a2, verifyData), nil
+}
+
+func (s *Session) c2_step4(body []byte) ([]byte, []byte, error) {
+	if err := checkMinSize(body, 64); err != nil {
+		return nil, nil, err
+	}
 	a2inner := body[:32]
 	p1inner_vd := body[32:64]
 	a2 := u.FinishHash(s.MsOuterHashState, a2inner)
 	verifyData := u.FinishHash(s.MsOuterHashState, p1inner_vd)[:12]
-	return s.encryptToClient(u.Concat(a2, verifyData))
+	return a2, verifyData, nil
+}
+
+// C2_step4_c3_step1 is C2_step4 followed by C3_step1, which has no input,
+// for PROTOCOL_MERGED_STEPS clients. The response is a2 | verify_data | the
+// output of C3_step1.
+func (s *Session) C2_step4_c3_step1(encrypted []byte) ([]byte, error) {
+	body, err := s.decryptFromClient(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	a2, verifyData, err := s.c2_step4(body)
+	if err != nil {
+		return nil, err
+	}
+	return s.encryptToClient(a2, verifyData, s.c3_step1()), nil
 }
 
 // [REF 1] Step 18.
-func (s *Session) C3_step1(encrypted []byte) []byte {
-	s.sequenceCheck(18)
-	g := s.g
-	s.setCircuitInputs(3,
-		s.MsOuterHashState,
-		g.Cs[3].Masks[1],
-		g.Cs[3].Masks[2],
-		g.Cs[3].Masks[3],
-		g.Cs[3].Masks[4])
+func (s *Session) C3_step1(encrypted []byte) ([]byte, error) {
+	return s.encryptToClient(s.c3_step1()), nil
+}
+
+func (s *Session) c3_step1() []byte {
+	s.setNotaryInputs(3, s.MsOuterHashState)
 	// the masks become notary's TLS key shares
 	s.swkShare = s.g.Cs[3].Masks[1]
 	s.cwkShare = s.g.Cs[3].Masks[2]
 	s.sivShare = s.g.Cs[3].Masks[3]
 	s.civShare = s.g.Cs[3].Masks[4]
 
-	out := s.c_step1(3)
-	return s.encryptToClient(out)
+	return s.c_step1(3)
 }
 
 // [REF 1] Step 18. Notary doesn't need to parse the circuit's output because
 // the masks that he inputted become his TLS keys' shares.
-func (s *Session) C3_step2(encrypted []byte) []byte {
-	s.sequenceCheck(19)
-	body := s.decryptFromClient(encrypted)
-	return s.encryptToClient(s.common_step2(3, body))
+func (s *Session) C3_step2(encrypted []byte) ([]byte, error) {
+	return s.step2(3, encrypted)
 }
 
 // [REF 1] Step 18.
-func (s *Session) C4_step1(encrypted []byte) []byte {
-	s.sequenceCheck(20)
-	body := s.decryptFromClient(encrypted)
+func (s *Session) C4_step1(encrypted []byte) ([]byte, error) {
+	body, err := s.decryptFromClient(encrypted)
+	if err != nil {
+		return nil, err
+	}
 	// to save a round-trip, circuit 3 piggy-backs on this message to parse the
 	// decommitment. Notary doesn't need to parse the output of the circuit,
 	// since we already know what out TLS key shares are
-	decommitSize := len(s.encodedOutput[3]) + len(u.Concat(s.dt[3]...)) + 32
-	s.processDecommit(3, body[:decommitSize])
+	decommitSize := len(s.encodedOutput[3]) + len(s.dt[3]) + 32
+	if err = checkMinSize(body, decommitSize); err != nil {
+		return nil, err
+	}
+	if _, err = s.processDecommit(3, body[:decommitSize]); err != nil {
+		return nil, err
+	}
 
-	g := s.g
-	s.setCircuitInputs(4,
+	s.setNotaryInputs(4,
 		s.swkShare,
 		s.cwkShare,
 		s.sivShare,
-		s.civShare,
-		g.Cs[4].Masks[1],
-		g.Cs[4].Masks[2])
+		s.civShare)
 
 	s.c4_step1A()
 	inputLabels := s.g.GetNotaryLabels(4)
-	return s.encryptToClient(inputLabels)
+	return s.encryptToClient(inputLabels), nil
 }
 
 func (s *Session) c4_step1A() {
@@ -396,55 +991,54 @@ func (s *Session) c4_step1A() {
 	// because of the dual execution, both client and notary need to
 	// receive their input labels via OT.
 	// we process client's OT request and create a notary's OT request.
-	cl4 := s.g.GetClientLabels(4)
-
-	allC6Labels := s.g.GetClientLabels(6)
-	var c6KeyLabels []byte
-	labelsForEachExecution := u.SplitIntoChunks(allC6Labels, len(allC6Labels)/s.g.C6Count)
-	for i := 0; i < s.g.C6Count; i++ {
-		// take labels for input bits 0-160
-		c6KeyLabels = append(c6KeyLabels, labelsForEachExecution[i][:160*32]...)
-	}
+	labels := s.takeClientLabels(4)
 
-	go func() {
+	s.startOt("c4_step1", func() ([]byte, error) {
 		// send the labels as is without any encryption
-		err := s.Ot.RespondWithData(append(cl4, c6KeyLabels...))
+		err := s.otRespondStream(bytes.NewReader(labels), len(labels))
 		if err != nil {
-			log.Println(err)
-			s.OtReleaseChan <- s.Sid
-			s.DestroyChan <- s.Sid // destroy self
-			return
+			return nil, err
 		}
+		return s.otRequest(s.g.Cs[4].InputBits)
+	})
+}
 
-		step2OtResp, err := s.Ot.RequestData(s.g.Cs[4].InputBits)
-		if err != nil {
-			log.Println(err)
-			s.OtReleaseChan <- s.Sid
-			s.DestroyChan <- s.Sid // destroy self
-			return
-		}
+// c4ClientLabels returns the client's input labels of c4 followed by those
+// of the key shares of all c6 executions, which the notary sends via OT in
+// c4_step1
+func (s *Session) c4ClientLabels() []byte {
+	labels := s.g.GetClientLabels(4)
 
-		s.lastOtResponse = step2OtResp
-		s.lastResponseFrom = "c4_step1"
-	}()
+	allC6Labels := s.g.GetClientLabels(6)
+	labelsForEachExecution := u.SplitIntoChunks(allC6Labels, len(allC6Labels)/s.g.C6Count)
+	for i := 0; i < s.g.C6Count; i++ {
+		// take labels for input bits 0-160
+		labels = append(labels, labelsForEachExecution[i][:160*32]...)
+	}
+	return labels
 }
 
 // [REF 1] Step 18.
-func (s *Session) C4_step2(encrypted []byte) []byte {
-	s.sequenceCheck(21)
-	body := s.decryptFromClient(encrypted)
-	return s.encryptToClient(s.common_step2(4, body))
+func (s *Session) C4_step2(encrypted []byte) ([]byte, error) {
+	return s.step2(4, encrypted)
 }
 
 // compute MAC for Client_Finished using Oblivious Transfer
 // see https://tlsnotary.org/how_it_works#section4
 // (4. Computing MAC of the request using Oblivious Transfer. )
-func (s *Session) C4_step3(encrypted []byte) []byte {
-	s.sequenceCheck(22)
-	body := s.decryptFromClient(encrypted)
+func (s *Session) C4_step3(encrypted []byte) ([]byte, error) {
+	body, err := s.decryptFromClient(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkMinSize(body, 16); err != nil {
+		return nil, err
+	}
 	// Notary doesn't need to parse circuit's 4 output because
 	// the masks that he inputted become his TLS keys' shares.
-	s.processDecommit(4, body[:len(body)-16])
+	if _, err = s.processDecommit(4, body[:len(body)-16]); err != nil {
+		return nil, err
+	}
 	body = body[len(body)-16:]
 	g := s.g
 	o := 0
@@ -468,15 +1062,11 @@ func (s *Session) C4_step3(encrypted []byte) []byte {
 	// otReq contains a concatenation of client's H1 bits and H2 bits.
 	// Client's H1 is multiplied with notary's H2 and client's
 	// H2 is multiplied with notary's H1.
-	go func() {
-		err := s.Ot.RespondWithData(u.Concat(allMessages2, allMessages1))
-		if err != nil {
-			log.Println(err)
-			s.OtReleaseChan <- s.Sid
-			s.DestroyChan <- s.Sid // destroy self
-			return
-		}
-	}()
+	s.startOt("c4_step3", func() ([]byte, error) {
+		return nil, s.otRespondStream(
+			io.MultiReader(bytes.NewReader(allMessages2), bytes.NewReader(allMessages1)),
+			len(allMessages2)+len(allMessages1))
+	})
 
 	s.ghash.P[3] = u.XorBytes(u.XorBytes(maskSum1, maskSum2), H1H2)
 
@@ -497,46 +1087,50 @@ func (s *Session) C4_step3(encrypted []byte) []byte {
 	s3 := ghash.BlockMult(lenAlenC, s.ghash.P[1])
 	tagShare := u.XorBytes(u.XorBytes(u.XorBytes(s1, s2), s3), gctrShare)
 
-	return s.encryptToClient(tagShare)
+	return s.encryptToClient(tagShare), nil
 }
 
 // [REF 1] Step 26.
-func (s *Session) C5_pre1(encrypted []byte) []byte {
-	s.sequenceCheck(23)
-	body := s.decryptFromClient(encrypted)
+func (s *Session) C5_pre1(encrypted []byte) ([]byte, error) {
+	body, err := s.decryptFromClient(encrypted)
+	if err != nil {
+		return nil, err
+	}
 	a1inner := body[:]
 	a1 := u.FinishHash(s.MsOuterHashState, a1inner)
 
-	return s.encryptToClient(a1)
+	return s.encryptToClient(a1), nil
 }
 
 // [REF 1] Step 28.
-func (s *Session) C5_step1(encrypted []byte) []byte {
-	s.sequenceCheck(24)
-	s.setCircuitInputs(5,
+func (s *Session) C5_step1(encrypted []byte) ([]byte, error) {
+	s.setNotaryInputs(5,
 		s.MsOuterHashState,
 		s.swkShare,
-		s.sivShare,
-		s.g.Cs[5].Masks[1],
-		s.g.Cs[5].Masks[2])
+		s.sivShare)
 	u.Assert(len(s.g.Cs[5].InputBits)/8 == 84)
 	out := s.c_step1(5)
-	return s.encryptToClient(out)
+	return s.encryptToClient(out), nil
 }
 
 // [REF 1] Step 28.
-func (s *Session) C5_step2(encrypted []byte) []byte {
-	s.sequenceCheck(25)
-	body := s.decryptFromClient(encrypted)
-	return s.encryptToClient(s.common_step2(5, body))
+func (s *Session) C5_step2(encrypted []byte) ([]byte, error) {
+	return s.step2(5, encrypted)
 }
 
 // compute MAC for Server_Finished using Oblivious Transfer
 // see also coments in C3_step3
-func (s *Session) C5_step3(encrypted []byte) []byte {
-	s.sequenceCheck(26)
-	body := s.decryptFromClient(encrypted)
-	s.processDecommit(5, body[:len(body)-16])
+func (s *Session) C5_step3(encrypted []byte) ([]byte, error) {
+	body, err := s.decryptFromClient(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkMinSize(body, 16); err != nil {
+		return nil, err
+	}
+	if _, err = s.processDecommit(5, body[:len(body)-16]); err != nil {
+		return nil, err
+	}
 	body = body[len(body)-16:]
 	g := s.g
 	o := 0
@@ -553,15 +1147,11 @@ func (s *Session) C5_step3(encrypted []byte) []byte {
 	// otReq is a concatenation of client's H1 bits and H2 bits.
 	// Client's H1 is multiplied with to notary's H2 and client's
 	// H2 is multiplied with notary's H1.
-	go func() {
-		err := s.Ot.RespondWithData(u.Concat(allMessages2, allMessages1))
-		if err != nil {
-			log.Println(err)
-			s.OtReleaseChan <- s.Sid
-			s.DestroyChan <- s.Sid // destroy self
-			return
-		}
-	}()
+	s.startOt("c5_step3", func() ([]byte, error) {
+		return nil, s.otRespondStream(
+			io.MultiReader(bytes.NewReader(allMessages2), bytes.NewReader(allMessages1)),
+			len(allMessages2)+len(allMessages1))
+	})
 
 	H3share := u.XorBytes(u.XorBytes(maskSum1, maskSum2), H1H2)
 
@@ -576,11 +1166,10 @@ func (s *Session) C5_step3(encrypted []byte) []byte {
 	s3 := ghash.BlockMult(lenAlenC, h1share)
 	tagShare := u.XorBytes(u.XorBytes(u.XorBytes(s1, s2), s3), gctrShare)
 
-	return s.encryptToClient(tagShare)
+	return s.encryptToClient(tagShare), nil
 }
 
-func (s *Session) C6_step1(encrypted []byte) []byte {
-	s.sequenceCheck(27)
+func (s *Session) C6_step1(encrypted []byte) ([]byte, error) {
 	var allInputs [][]byte
 	for i := 0; i < s.g.C6Count; i++ {
 		allInputs = append(allInputs, s.cwkShare)
@@ -605,77 +1194,86 @@ func (s *Session) C6_step1(encrypted []byte) []byte {
 	// ---------------------------------------
 
 	inputLabels := s.g.GetNotaryLabels(6)
-	go func() {
-		err := s.Ot.RespondWithData(labels)
-		if err != nil {
-			log.Println(err)
-			s.OtReleaseChan <- s.Sid
-			s.DestroyChan <- s.Sid // destroy self
-			return
-		}
-
-		step2OtResp, err := s.Ot.RequestData(s.g.Cs[6].InputBits)
+	s.startOt("c6_step1", func() ([]byte, error) {
+		err := s.otRespond(labels)
 		if err != nil {
-			log.Println(err)
-			s.OtReleaseChan <- s.Sid
-			s.DestroyChan <- s.Sid // destroy self
-			return
+			return nil, err
 		}
+		return s.otRequest(s.g.Cs[6].InputBits)
+	})
 
-		s.lastOtResponse = step2OtResp
-		s.lastResponseFrom = "c6_step1"
-	}()
-
-	return s.encryptToClient(inputLabels)
+	return s.encryptToClient(inputLabels), nil
 }
 
-func (s *Session) C6_pre2(encrypted []byte) []byte {
-	s.sequenceCheck(28)
-	body := s.decryptFromClient(encrypted)
+func (s *Session) C6_pre2(encrypted []byte) ([]byte, error) {
+	body, err := s.decryptFromClient(encrypted)
+	if err != nil {
+		return nil, err
+	}
 	// add a dummy 32-byte commitment to keep common_step2() happy
 	body = append(body, make([]byte, 32)...)
-	s.c6CheckValue = s.common_step2(6, body)
+	s.c6CheckValue, err = s.common_step2(6, body)
 	// do not send c6CheckValue until Client sends his commitment
-	return nil
+	return nil, err
 }
 
-func (s *Session) C6_step2(encrypted []byte) []byte {
-	s.sequenceCheck(29)
-	body := s.decryptFromClient(encrypted)
-	u.Assert(len(body) == 32)
+func (s *Session) C6_step2(encrypted []byte) ([]byte, error) {
+	body, err := s.decryptFromClient(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkSize(body, 32); err != nil {
+		return nil, err
+	}
 	s.hisCommitment[6] = body
-	return s.encryptToClient(s.c6CheckValue)
+	return s.encryptToClient(s.c6CheckValue...), nil
 }
 
-func (s *Session) C7_step1(encrypted []byte) []byte {
-	s.sequenceCheck(30)
-	body := s.decryptFromClient(encrypted)
-	decommitSize := len(s.encodedOutput[6]) + len(u.Concat(s.dt[6]...)) + 32
-	s.processDecommit(6, body[:decommitSize])
+func (s *Session) C7_step1(encrypted []byte) ([]byte, error) {
+	body, err := s.decryptFromClient(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	decommitSize := len(s.encodedOutput[6]) + len(s.dt[6]) + 32
+	if err = checkMinSize(body, decommitSize); err != nil {
+		return nil, err
+	}
+	if _, err = s.processDecommit(6, body[:decommitSize]); err != nil {
+		return nil, err
+	}
 	g := s.g
+	// one execution for each TLS record, the client inputs the record's
+	// nonce
 	var allInputs [][]byte
-	allInputs = append(allInputs, s.cwkShare)
-	allInputs = append(allInputs, s.civShare)
-	allInputs = append(allInputs, g.Cs[7].Masks[1])
-
-	s.gctrBlockShare = g.Cs[7].Masks[1]
+	s.gctrBlockShares = make([][]byte, g.C7Count)
+	for i := 0; i < g.C7Count; i++ {
+		allInputs = append(allInputs, s.cwkShare)
+		allInputs = append(allInputs, s.civShare)
+		allInputs = append(allInputs, g.Cs[7].Masks[i+1])
+		s.gctrBlockShares[i] = g.Cs[7].Masks[i+1]
+	}
 	s.setCircuitInputs(7, allInputs...)
 	out := s.c_step1(7)
-	return s.encryptToClient(out)
+	return s.encryptToClient(out), nil
 }
 
-func (s *Session) C7_step2(encrypted []byte) []byte {
-	s.sequenceCheck(31)
-	body := s.decryptFromClient(encrypted)
-	return s.encryptToClient(s.common_step2(7, body))
+func (s *Session) C7_step2(encrypted []byte) ([]byte, error) {
+	return s.step2(7, encrypted)
 }
 
 // compute MAC for client's request using Oblivious Transfer
-func (s *Session) Ghash_step1(encrypted []byte) []byte {
-	s.sequenceCheck(32)
-	body := s.decryptFromClient(encrypted)
-	decommitSize := len(s.encodedOutput[7]) + len(u.Concat(s.dt[7]...)) + 32
-	s.processDecommit(7, body[:decommitSize])
+func (s *Session) Ghash_step1(encrypted []byte) ([]byte, error) {
+	body, err := s.decryptFromClient(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	decommitSize := len(s.encodedOutput[7]) + len(s.dt[7]) + 32
+	if err = checkMinSize(body, decommitSize+2); err != nil {
+		return nil, err
+	}
+	if _, err = s.processDecommit(7, body[:decommitSize]); err != nil {
+		return nil, err
+	}
 	body = body[decommitSize:]
 	o := 0
 	mpnBytes := body[o : o+2]
@@ -684,96 +1282,141 @@ func (s *Session) Ghash_step1(encrypted []byte) []byte {
 	s.ghash.SetMaxPowerNeeded(maxPowerNeeded)
 	if s.ghash.GetMaxOddPowerNeeded() == 3 {
 		// The Client must not have request any OT
-		u.Assert(len(body) == 2)
+		if err = checkSize(body, 2); err != nil {
+			return nil, err
+		}
 		//perform free squaring on powers 2,3 which we have from client finished
 		ghash.FreeSquare(&s.ghash.P, maxPowerNeeded)
-		return nil
+		return nil, nil
+	}
+	if err = checkSize(body, o); err != nil {
+		return nil, err
 	}
-
-	u.Assert(len(body) == o)
 
 	allEntries := s.ghash.Step1()
-	go func() {
-		err := s.Ot.RespondWithData(allEntries)
-		if err != nil {
-			log.Println(err)
-			s.OtReleaseChan <- s.Sid
-			s.DestroyChan <- s.Sid // destroy self
-			return
-		}
-	}()
-	return nil
+	s.startOt("ghash_step1", func() ([]byte, error) {
+		return nil, s.otRespond(allEntries)
+	})
+	return nil, nil
 }
 
 // This step is optional and is only used when the client's request is larger
 // than 339*16=5424 bytes (see maxHTable in Ghash_step1)
 // The reason why this step is separated from Ghash_step1 is because it requires
 // a second round of communication.
-func (s *Session) Ghash_step2(encrypted []byte) []byte {
-	s.sequenceCheck(33)
+func (s *Session) Ghash_step2(encrypted []byte) ([]byte, error) {
+	if s.ghash.GetMaxOddPowerNeeded() <= ghash.MAX_ODD_POWER_STEP1 {
+		return nil, outOfOrder("ghash_step2 is not needed for this request size")
+	}
+	s.ghashStep2Done = true
 	allEntries := s.ghash.Step2()
-	go func() {
-		err := s.Ot.RespondWithData(allEntries)
-		if err != nil {
-			log.Println(err)
-			s.OtReleaseChan <- s.Sid
-			s.DestroyChan <- s.Sid // destroy self
-			return
-		}
-	}()
-	return nil
+	s.startOt("ghash_step2", func() ([]byte, error) {
+		return nil, s.otRespond(allEntries)
+	})
+	return nil, nil
 }
 
 // compute MAC for client's request using Oblivious Transfer. Stage 2: Block
 // Aggregation.
-func (s *Session) Ghash_step3(encrypted []byte) []byte {
-	s.sequenceCheck(34)
-	body := s.decryptFromClient(encrypted)
-	o := 0
-	maxPowerNeeded := s.ghash.GetMaxPowerNeeded()
-	s.ghashInputsBlob = body[o : o+maxPowerNeeded*16]
-	o += maxPowerNeeded * 16
-	needsAggregation := body[o:]
+func (s *Session) Ghash_step3(encrypted []byte) ([]byte, error) {
+	if s.ghash.GetMaxOddPowerNeeded() > ghash.MAX_ODD_POWER_STEP1 && !s.ghashStep2Done {
+		return nil, outOfOrder("ghash_step2 is needed for this request size")
+	}
+	body, err := s.decryptFromClient(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	records, needsAggregation, err := s.parseGhashInputs(body)
+	if err != nil {
+		return nil, err
+	}
 
-	// ghashInputs = aad + client_request + lenAlenC
-	ghashInputs := u.SplitIntoChunks(s.ghashInputsBlob, 16)
-	ghashOutputShare, allEntries, blockMultCount := s.ghash.Step3(ghashInputs)
+	// each record has its own tag, the OT entries of all records are sent
+	// in the order of the records
+	var allEntries, tagShares []byte
+	totalBlockMultCount := 0
+	for i, ghashInputs := range records {
+		ghashOutputShare, entries, blockMultCount := s.ghash.Step3(ghashInputs)
+		allEntries = append(allEntries, entries...)
+		totalBlockMultCount += blockMultCount
+		tagShares = append(tagShares, u.XorBytes(s.gctrBlockShares[i], ghashOutputShare)...)
+	}
 
 	if len(needsAggregation) > 0 {
 		// client sent us bits for every small power and for every corresponding
 		// aggregated value
-		go func() {
-			err := s.Ot.RespondWithData(allEntries)
-			if err != nil {
-				log.Println(err)
-				s.OtReleaseChan <- s.Sid
-				s.DestroyChan <- s.Sid // destroy self
-				return
-			}
-		}()
-	} else {
-		// no block aggregation was needed
-		u.Assert(blockMultCount == 0)
+		s.startOt("ghash_step3", func() ([]byte, error) {
+			return nil, s.otRespond(allEntries)
+		})
+	} else if totalBlockMultCount != 0 {
+		return nil, invalidMessage("block aggregation is needed for this request size")
 	}
 
-	return s.encryptToClient(u.XorBytes(s.gctrBlockShare, ghashOutputShare))
+	return s.encryptToClient(tagShares), nil
+}
+
+// parseGhashInputs parses the GHASH inputs of each TLS record from the body
+// of ghash_step3 and returns them with the rest of the body, which are the
+// bits for block aggregation. Before PROTOCOL_REQUEST_RECORDS the body has
+// the inputs of the only record, which are maxPowerNeeded blocks. Later each
+// record's inputs are prefixed with their count of blocks, which is at most
+// maxPowerNeeded.
+func (s *Session) parseGhashInputs(body []byte) ([][][]byte, []byte, error) {
+	maxPowerNeeded := s.ghash.GetMaxPowerNeeded()
+	o := 0
+	s.ghashInputsBlob = nil
+	records := make([][][]byte, s.recordCount)
+	for i := range records {
+		blocks := maxPowerNeeded
+		if s.ProtocolVersion >= PROTOCOL_REQUEST_RECORDS {
+			if err := checkMinSize(body, o+2); err != nil {
+				return nil, nil, err
+			}
+			blocks = int(binary.BigEndian.Uint16(body[o : o+2]))
+			o += 2
+			// ghashInputs = aad + client_request + lenAlenC
+			if blocks < 3 || blocks > maxPowerNeeded {
+				return nil, nil, invalidMessage("invalid GHASH block count %d of record %d", blocks, i)
+			}
+		}
+		if err := checkMinSize(body, o+blocks*16); err != nil {
+			return nil, nil, err
+		}
+		blob := body[o : o+blocks*16]
+		o += blocks * 16
+		s.ghashInputsBlob = append(s.ghashInputsBlob, blob...)
+		records[i] = u.SplitIntoChunks(blob, 16)
+	}
+	return records, body[o:], nil
 }
 
 // Client commit to the server's response (with MACs).
 // Notary signs the session.
-func (s *Session) CommitHash(encrypted []byte) []byte {
-	s.sequenceCheck(35)
-
+func (s *Session) CommitHash(encrypted []byte) ([]byte, error) {
 	defer func() {
 		// this is the last step with Softspoken OT so it can be disconnected
 		s.Ot.Disconnect()
-		s.OtReleaseChan <- s.Sid
+		s.ReleaseOt()
 	}()
 
-	body := s.decryptFromClient(encrypted)
-
-	if len(body) != 160 {
-		panic("commitHash invalid body size")
+	body, err := s.decryptFromClient(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkMinSize(body, 160); err != nil {
+		return nil, err
+	}
+	var commitments []Commitment
+	if s.ProtocolVersion >= PROTOCOL_TYPED_COMMITMENTS {
+		commitments, err = ParseCommitments(body[160:])
+		if err != nil {
+			return nil, invalidMessage("%s", err)
+		}
+		if err = checkRequestCommitment(commitments, s.c6Count, s.recordCount); err != nil {
+			return nil, invalidMessage("%s", err)
+		}
+	} else if err = checkSize(body, 160); err != nil {
+		return nil, err
 	}
 
 	hisCommitHash := body[0:32]
@@ -782,9 +1425,60 @@ func (s *Session) CommitHash(encrypted []byte) []byte {
 	hisSwkShareHash := body[96:128]
 	hisSivShareHash := body[128:160]
 
+	now := time.Now()
+	if s.ProtocolVersion >= PROTOCOL_ATTESTATION_DOCUMENT {
+		otStats, err := s.finalOtStats()
+		if err != nil {
+			return nil, err
+		}
+		doc := &AttestationDocument{
+			Version:         ATTESTATION_VERSION,
+			ProtocolVersion: s.ProtocolVersion,
+			NotaryKeyId:     s.AttestationCounter.Id,
+			Timestamp:       now.Unix(),
+			ServerPubkey:    hex.EncodeToString(s.serverPubkey),
+			CommitHash:      hex.EncodeToString(hisCommitHash),
+			KeyShareHashes: KeyShareHashes{
+				ClientWriteKey: hex.EncodeToString(hisCwkShareHash),
+				ClientWriteIv:  hex.EncodeToString(hisCivShareHash),
+				ServerWriteKey: hex.EncodeToString(hisSwkShareHash),
+				ServerWriteIv:  hex.EncodeToString(hisSivShareHash),
+			},
+			GhashInputsHash: hex.EncodeToString(u.Sha256(s.ghashInputsBlob)),
+			OtStatsDigest:   otStats.Digest(),
+			SignatureScheme: "randomized",
+			Commitments:     attestedCommitments(commitments),
+		}
+		if s.DeterministicSignatures {
+			doc.SignatureScheme = "rfc6979"
+		}
+		if s.VerifierOnly {
+			// an unsigned document uses no attestation counter value
+			doc.SignatureScheme = "none"
+		} else {
+			doc.AttestationCounter = s.AttestationCounter.Next()
+		}
+		if s.AttestationMetrics {
+			metrics := s.sessionMetrics()
+			metrics.Ot = &otStats
+			doc.Metrics = &metrics
+		}
+		attestation, err := s.signAttestation(doc)
+		if err != nil {
+			return nil, err
+		}
+		return s.encryptToClient(
+			s.notaryPMSShare,
+			s.cwkShare,
+			s.civShare,
+			s.swkShare,
+			s.sivShare,
+			attestation), nil
+	}
+
 	timeBytes := make([]byte, 8)
-	binary.BigEndian.PutUint64(timeBytes, uint64(time.Now().Unix()))
-	signature := u.ECDSASign(&s.SigningKey,
+	binary.BigEndian.PutUint64(timeBytes, uint64(now.Unix()))
+	signed := [][]byte{
 		hisCommitHash,
 		hisCwkShareHash,
 		hisCivShareHash,
@@ -792,24 +1486,95 @@ func (s *Session) CommitHash(encrypted []byte) []byte {
 		hisSivShareHash,
 		s.ghashInputsBlob,
 		s.serverPubkey,
-		timeBytes)
+		timeBytes}
+	// older clients don't know about signature schemes
+	var schemeBytes []byte
+	if s.ProtocolVersion >= PROTOCOL_SIGNATURE_SCHEME {
+		schemeBytes = []byte{SIGNATURE_SCHEME_RANDOMIZED}
+		if s.DeterministicSignatures {
+			schemeBytes[0] = SIGNATURE_SCHEME_RFC6979
+		}
+		signed = append(signed, schemeBytes)
+	}
+	// metricsBytes is a 2-byte length followed by JSON, the length is 0
+	// when metrics are disabled
+	var metricsBytes []byte
+	if s.ProtocolVersion >= PROTOCOL_SESSION_METRICS {
+		var metricsJson []byte
+		if s.AttestationMetrics {
+			metricsJson = s.metrics()
+		}
+		metricsBytes = make([]byte, 2)
+		binary.BigEndian.PutUint16(metricsBytes, uint16(len(metricsJson)))
+		metricsBytes = append(metricsBytes, metricsJson...)
+		signed = append(signed, metricsBytes)
+	}
+	if s.ProtocolVersion >= PROTOCOL_TYPED_COMMITMENTS {
+		signed = append(signed, EncodeCommitments(commitments))
+	}
+	var counterBytes []byte
+	if s.ProtocolVersion >= PROTOCOL_ATTESTATION_COUNTER {
+		counterBytes = make([]byte, 8)
+		binary.BigEndian.PutUint64(counterBytes, s.AttestationCounter.Next())
+		signed = append(signed, counterBytes)
+	}
+	var signature []byte
+	if s.DeterministicSignatures {
+		signature = u.ECDSASignDeterministic(&s.SigningKey, signed...)
+	} else {
+		signature = u.ECDSASign(&s.SigningKey, signed...)
+	}
+	if err = s.logSignature(transparency_log.KIND_SESSION, signature); err != nil {
+		return nil, err
+	}
 
-	return s.encryptToClient(u.Concat(
+	return s.encryptToClient(
 		signature,
 		s.notaryPMSShare,
 		s.cwkShare,
 		s.civShare,
 		s.swkShare,
 		s.sivShare,
-		timeBytes))
+		timeBytes,
+		schemeBytes,
+		metricsBytes,
+		counterBytes), nil
+}
+
+// sessionMetrics returns the SessionMetrics of the session
+func (s *Session) sessionMetrics() SessionMetrics {
+	scheme := "grr3"
+	if s.Gp.HalfGates {
+		scheme = "halfgates"
+	}
+	return SessionMetrics{
+		ProtocolVersion: s.ProtocolVersion,
+		GarblingScheme:  scheme,
+		DurationSeconds: int64(time.Since(s.startTime).Seconds()),
+		// each c6 execution encrypts one AES block of the request
+		RequestBlocks: s.c6Count,
+		GhashBlocks:   len(s.ghashInputsBlob) / 16,
+	}
+}
+
+// metrics returns SessionMetrics in JSON format
+func (s *Session) metrics() []byte {
+	metrics, err := json.Marshal(s.sessionMetrics())
+	if err != nil {
+		panic(err)
+	}
+	return metrics
 }
 
 type prepTagVerificationRequest struct {
 	ClientIvShare []byte `json:"clientIvShare"`
 	RecordIv      []byte `json:"recordIv"`
+	// RecordIvs replaces RecordIv for PROTOCOL_TAG_RECORDS clients which
+	// verify several records
+	RecordIvs [][]byte `json:"recordIvs"`
 }
 
-func (s *Session) PrepTagVerification(body []byte) []byte {
+func (s *Session) PrepTagVerification(body []byte) ([]byte, error) {
 	req := new(prepTagVerificationRequest)
 	err := json.Unmarshal(body, req)
 	if err != nil {
@@ -817,7 +1582,7 @@ func (s *Session) PrepTagVerification(body []byte) []byte {
 			Error string `json:"error"`
 		}{Error: "invalid body"})
 
-		return resp
+		return resp, nil
 	}
 
 	if len(req.ClientIvShare) != len(s.sivShare) {
@@ -825,27 +1590,41 @@ func (s *Session) PrepTagVerification(body []byte) []byte {
 			Error string `json:"error"`
 		}{Error: "invalid client IV share"})
 
-		return resp
+		return resp, nil
 	}
 
-	if len(req.RecordIv) != 8 {
+	recordIvs := req.RecordIvs
+	if len(recordIvs) == 0 || s.ProtocolVersion < PROTOCOL_TAG_RECORDS {
+		recordIvs = [][]byte{req.RecordIv}
+	}
+	if len(recordIvs) > at.MAX_TAG_RECORDS {
 		resp, _ := json.Marshal(struct {
 			Error string `json:"error"`
-		}{Error: "invalid record IV"})
+		}{Error: "too many records"})
 
-		return resp
+		return resp, nil
 	}
+	for _, recordIv := range recordIvs {
+		if len(recordIv) != 8 {
+			resp, _ := json.Marshal(struct {
+				Error string `json:"error"`
+			}{Error: "invalid record IV"})
 
-	err = s.Tv.HandlePrepTagVerification(s.Sid, s.sivShare, s.swkShare, req.ClientIvShare, req.RecordIv)
+			return resp, nil
+		}
+	}
+
+	err = s.Tv.HandlePrepTagVerification(s.Sid, s.sivShare, s.swkShare, req.ClientIvShare, recordIvs)
 	if err != nil {
 		resp, _ := json.Marshal(struct {
 			Error string `json:"error"`
 		}{Error: err.Error()})
 
-		return resp
+		return resp, nil
 	}
+	s.recordIvs = recordIvs
 
-	return nil
+	return nil, nil
 }
 
 type pollTagVerificationResponse struct {
@@ -854,50 +1633,110 @@ type pollTagVerificationResponse struct {
 	Error    string `json:"error,omitempty"`
 }
 
-func (s *Session) PollTagVerification(body []byte) []byte {
-	busy, tagMask, pohMask, err := s.Tv.HandlePollTagVerificationStatus(s.Sid)
+func (s *Session) PollTagVerification(body []byte) ([]byte, error) {
+	busy, tagMasks, pohMask, err := s.Tv.HandlePollTagVerificationStatus(s.Sid)
 
 	response := new(pollTagVerificationResponse)
 	response.Busy = busy
-	response.Complete = len(tagMask) != 0 && len(pohMask) != 0
+	response.Complete = len(tagMasks) != 0 && len(pohMask) != 0
 	if err != nil {
 		response.Error = err.Error()
 	}
 
-	s.tagMask = tagMask
+	s.tagMasks = tagMasks
 	s.pohMask = pohMask
 
-	resp, err := json.Marshal(response)
-	if err != nil {
-		log.Println(err)
-		return []byte("{\"error\":\"internal error\"}")
-	}
-
-	return resp
+	return json.Marshal(response)
 }
 
 type tagVerificationRequest struct {
-	Ciphertext []string `json:"ciphertext"`
-	AAD        string   `json:"aad"`
-	TagShare   string   `json:"tagShare"`
+	// Ciphertext is encoded as CiphertextEncoding, see at.DecodeCiphertext
+	Ciphertext json.RawMessage `json:"ciphertext"`
+	AAD        string          `json:"aad"`
+	TagShare   string          `json:"tagShare"`
+	// CiphertextEncoding is at.ENCODING_BASE64 (the default) or
+	// at.ENCODING_HEX for PROTOCOL_BINARY_CIPHERTEXT clients and applies to
+	// all records. Older clients always use at.ENCODING_DECIMAL.
+	CiphertextEncoding string `json:"ciphertextEncoding"`
+	// Records replaces the fields above for PROTOCOL_TAG_RECORDS clients
+	// which verify several records, in the order of the record IVs of
+	// prepTagVerification
+	Records []tagVerificationRecord `json:"records"`
+}
+
+type tagVerificationRecord struct {
+	Ciphertext json.RawMessage `json:"ciphertext"`
+	AAD        string          `json:"aad"`
+	TagShare   string          `json:"tagShare"`
+}
+
+// ciphertextEncoding returns the encoding of the ciphertexts of req
+func (s *Session) ciphertextEncoding(req *tagVerificationRequest) (string, error) {
+	if s.ProtocolVersion < PROTOCOL_BINARY_CIPHERTEXT {
+		return at.ENCODING_DECIMAL, nil
+	}
+	switch req.CiphertextEncoding {
+	case "":
+		return at.ENCODING_BASE64, nil
+	case at.ENCODING_BASE64, at.ENCODING_HEX:
+		return req.CiphertextEncoding, nil
+	}
+	return "", fmt.Errorf("unsupported cipher text encoding %q, use %s or %s",
+		req.CiphertextEncoding, at.ENCODING_BASE64, at.ENCODING_HEX)
+}
+
+// tagRecords returns the records of req with their tag masks
+func (s *Session) tagRecords(req *tagVerificationRequest) ([]at.TagRecord, error) {
+	records := req.Records
+	if s.ProtocolVersion < PROTOCOL_TAG_RECORDS || len(records) == 0 {
+		records = []tagVerificationRecord{{req.Ciphertext, req.AAD, req.TagShare}}
+	}
+	if len(records) != len(s.tagMasks) {
+		return nil, fmt.Errorf("expected %d records", len(s.tagMasks))
+	}
+	encoding, err := s.ciphertextEncoding(req)
+	if err != nil {
+		return nil, err
+	}
+	tagRecords := make([]at.TagRecord, len(records))
+	for i, r := range records {
+		ciphertext, err := at.DecodeCiphertext(r.Ciphertext, encoding)
+		if err != nil {
+			return nil, err
+		}
+		tagRecords[i] = at.TagRecord{
+			Ciphertext:       ciphertext,
+			AAD:              r.AAD,
+			TagShare:         r.TagShare,
+			EncryptedIvShare: s.tagMasks[i],
+		}
+	}
+	return tagRecords, nil
 }
 
 type tagVerificationResponse struct {
+	// Ciphertext echoes the ciphertext of a single record in the deprecated
+	// at.ENCODING_DECIMAL for clients before PROTOCOL_BINARY_CIPHERTEXT
 	Ciphertext []string `json:"ciphertext,omitempty"`
 	Signature  string   `json:"signature,omitempty"`
-	Status     string   `json:"status"`
-	Error      string   `json:"error,omitempty"`
+	// SignatureScheme is "rfc6979" or "randomized"
+	SignatureScheme string `json:"signatureScheme,omitempty"`
+	// SigningKeyId is the kid of the key which made Signature, see
+	// /signing-key.pem
+	SigningKeyId string `json:"signingKeyId,omitempty"`
+	// Transcript is the signed at.TagTranscript in hex for clients with
+	// PROTOCOL_TAG_TRANSCRIPT
+	Transcript string `json:"transcript,omitempty"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
 }
 
-func (s *Session) TagVerification(body []byte) []byte {
-	s.sequenceCheck(36)
-
+func (s *Session) TagVerification(body []byte) ([]byte, error) {
 	response := new(tagVerificationResponse)
-	if len(s.tagMask) == 0 || len(s.pohMask) == 0 {
+	if len(s.tagMasks) == 0 || len(s.pohMask) == 0 {
 		response.Error = "tag verification is not ready"
 		response.Status = "failed"
-		resp, _ := json.Marshal(response)
-		return resp
+		return json.Marshal(response)
 	}
 
 	req := new(tagVerificationRequest)
@@ -905,21 +1744,51 @@ func (s *Session) TagVerification(body []byte) []byte {
 	if err != nil {
 		response.Error = "invalid body"
 		response.Status = "failed"
-		resp, _ := json.Marshal(response)
-		return resp
+		return json.Marshal(response)
+	}
+
+	records, err := s.tagRecords(req)
+	if err != nil {
+		response.Error = err.Error()
+		response.Status = "failed"
+		return json.Marshal(response)
 	}
 
-	success, err := at.VerifyTag(s.Sid, s.pohMask, s.tagMask, req.Ciphertext, req.AAD, req.TagShare)
+	success, err := at.VerifyTag(s.pohMask, records)
 	if err != nil {
 		response.Error = err.Error()
 		response.Status = "failed"
-		resp, _ := json.Marshal(response)
-		return resp
+		return json.Marshal(response)
 	}
 
-	response.Ciphertext = req.Ciphertext
-	if success {
-		signature, err := s.Ts.Sign(response.Ciphertext)
+	if s.ProtocolVersion < PROTOCOL_BINARY_CIPHERTEXT && len(records) == 1 {
+		response.Ciphertext = at.DecimalCiphertext(records[0].Ciphertext)
+	}
+	if success && s.VerifierOnly {
+		transcript, err := s.tagTranscript(records)
+		if err != nil {
+			log.Println("TagVerification:", err)
+			response.Status = "failed"
+			response.Error = "failed to build the transcript"
+		} else {
+			response.Status = "verified"
+			response.Transcript = hex.EncodeToString(transcript)
+		}
+	} else if success {
+		var signature []byte
+		if s.ProtocolVersion >= PROTOCOL_TAG_TRANSCRIPT {
+			var transcript []byte
+			transcript, err = s.tagTranscript(records)
+			if err == nil {
+				response.Transcript = hex.EncodeToString(transcript)
+				signature, err = s.Ts.SignData(transcript)
+			}
+		} else {
+			signature, err = s.Ts.Sign(response.Ciphertext)
+		}
+		if err == nil {
+			err = s.logSignature(transparency_log.KIND_TAG, signature)
+		}
 		if err != nil {
 			log.Println("TagVerification:", err)
 			response.Status = "failed"
@@ -927,89 +1796,165 @@ func (s *Session) TagVerification(body []byte) []byte {
 		} else {
 			response.Status = "verified"
 			response.Signature = hex.EncodeToString(signature)
+			response.SignatureScheme = "randomized"
+			response.SigningKeyId = s.Ts.KeyId()
+			if s.Ts.Deterministic {
+				response.SignatureScheme = "rfc6979"
+			}
 		}
 	} else {
 		response.Status = "failed"
 	}
 
-	resp, _ := json.Marshal(response)
-	return resp
+	return json.Marshal(response)
+}
+
+// tagTranscript returns the signed form of the transcript of the verified
+// tags of records
+func (s *Session) tagTranscript(records []at.TagRecord) ([]byte, error) {
+	transcript := &at.TagTranscript{Version: at.TRANSCRIPT_VERSION_RECORDS}
+	for i, r := range records {
+		aad, err := hex.DecodeString(r.AAD)
+		if err != nil {
+			return nil, err
+		}
+		transcript.Records = append(transcript.Records, at.TranscriptRecord{
+			Ciphertext: r.Ciphertext,
+			AAD:        aad,
+			RecordIv:   s.recordIvs[i],
+		})
+	}
+	if s.ProtocolVersion < PROTOCOL_TAG_RECORDS {
+		transcript.Version = at.TRANSCRIPT_VERSION
+		transcript.MpcOutputsHash = at.HashMpcOutputs(s.pohMask, s.tagMasks[0])
+	} else {
+		transcript.MpcOutputsHash = at.HashMpcOutputsRecords(s.pohMask, s.tagMasks)
+	}
+	return transcript.Marshal(), nil
 }
 
 // getSymmetricKeys computes a shared ECDH secret between the other party's
 // pubkey and my privkey. Outputs 2 16-byte secrets.
-func (s *Session) getSymmetricKeys(pk []byte, myPrivKey *ecdsa.PrivateKey) (ck, nk []byte) {
+func (s *Session) getSymmetricKeys(pk []byte, myPrivKey *ecdsa.PrivateKey) (ck, nk []byte, err error) {
 	hisPubKey := ecdsa.PublicKey{
 		elliptic.P256(),
 		new(big.Int).SetBytes(pk[0:32]),
 		new(big.Int).SetBytes(pk[32:64]),
 	}
+	// never multiply our private key by an unchecked point
+	if err := u.ValidateP256Point(hisPubKey.X, hisPubKey.Y); err != nil {
+		return nil, nil, invalidMessage("invalid client ECDH pubkey: %s", err)
+	}
 	secret, _ := hisPubKey.Curve.ScalarMult(hisPubKey.X, hisPubKey.Y, myPrivKey.D.Bytes())
 	secretBytes := u.To32Bytes(secret)
-	return secretBytes[0:16], secretBytes[16:32]
+	return secretBytes[0:16], secretBytes[16:32], nil
 }
 
-func (s *Session) decryptFromClient(ctWithNonce []byte) []byte {
-	return u.AESGCMdecrypt(s.clientKey, ctWithNonce)
+// decryptFromClient returns ErrInvalidMessage if the message can't be
+// decrypted with the client's key
+func (s *Session) decryptFromClient(ctWithNonce []byte) ([]byte, error) {
+	pt, err := u.AESGCMopen(s.clientKey, ctWithNonce)
+	if err != nil {
+		return nil, invalidMessage("%s", err)
+	}
+	return pt, nil
 }
 
-func (s *Session) encryptToClient(plaintext []byte) []byte {
-	return u.AESGCMencrypt(s.notaryKey, plaintext)
+// encryptToClient encrypts the concatenation of parts. The parts are not
+// concatenated beforehand, so that big responses are held in memory only
+// once in plaintext and once encrypted.
+func (s *Session) encryptToClient(parts ...[]byte) []byte {
+	if s.ProtocolVersion < PROTOCOL_CHUNKED_AEAD {
+		return u.AESGCMencryptParts(s.notaryKey, parts...)
+	}
+	size := 0
+	for _, part := range parts {
+		size += len(part)
+	}
+	if s.ProtocolVersion >= PROTOCOL_AEAD_FRAMES {
+		size = u.AEADFramesSizeN(size, s.frameSize)
+	} else {
+		size = u.ChunkedAEADSize(size)
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	if err := s.writeToClient(buf, parts...); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
 }
 
-// sequenceCheck makes sure messages are received in the correct order and
-// (where applicable) received only once. This is crucial for the security
-// of the TLSNotary protocol.
-func (s *Session) sequenceCheck(seqNo int) {
-	if seqNo == 100 {
-		// This is the GetUploadProgress message. It is an optional message.
-		// It may be repeated many times. It must come after SetBlob (msg no 4).
-		// Due to async nature of client's JS, it may be sent asyncly even
-		// after client finished uploading (but not later than msg 9).
-		if u.Contains(4, s.msgsSeen) && !u.Contains(9, s.msgsSeen) {
-			// if clause contains the permitted conditions
-		} else {
-			panic("msg No 5 received out of order")
+// writeToClient encrypts the concatenation of parts in the chunked format
+// of the client's protocol version and writes it to w chunk by chunk
+func (s *Session) writeToClient(w io.Writer, parts ...[]byte) error {
+	var cw io.WriteCloser
+	var err error
+	if s.ProtocolVersion >= PROTOCOL_AEAD_FRAMES {
+		cw, err = u.NewAEADFrameWriterSize(w, s.notaryKey, func() uint64 {
+			return atomic.AddUint64(&s.toClientSeq, 1) - 1
+		}, s.frameSize)
+	} else {
+		cw, err = u.NewChunkedAEADWriter(w, s.notaryKey)
+	}
+	if err != nil {
+		return err
+	}
+	for _, part := range parts {
+		if _, err = cw.Write(part); err != nil {
+			return err
 		}
-		// we dont store this messages
-		return
-	}
-	if u.Contains(seqNo, s.msgsSeen) {
-		panic("message sent twice")
-	}
-	if !u.Contains(seqNo-1, s.msgsSeen) {
-		// it is acceptable if the preceding message was not found if:
-		// 1) the msg is the very first msg "init"
-		// 2) the msg is getBlob/setBlob (no 3/4) and the client hasn't yet
-		// sent "init2" (no 2). Happens if client's connection speed is very
-		// fast.
-		// 3) the msg is no 34, and no 33 (Ghash_step2) which is optional, was
-		// skipped
-		if u.Contains(seqNo, []int{1, 3, 4}) || (seqNo == 34 && u.Contains(32, s.msgsSeen)) {
-			// if clause contains the permitted conditions
-		} else {
-			panic("previous message not seen")
+	}
+	return cw.Close()
+}
+
+// checkBlobComplete returns ErrBlobIncomplete unless the uploaded blob has
+// the size which Init expected. Otherwise a short blob would only fail
+// when a circuit past its end is evaluated.
+func (s *Session) checkBlobComplete() error {
+	if m := s.getMemoryBlob(); m != nil {
+		if m.size() != s.blobSize {
+			return blobIncomplete("%d of %d bytes of the blob were uploaded, upload it again in a new session", m.size(), s.blobSize)
 		}
+		return nil
+	}
+	info, err := os.Stat(filepath.Join(s.StorageDir, "blobForNotary"))
+	if errors.Is(err, os.ErrNotExist) {
+		return blobIncomplete("the blob was not uploaded, upload it with setBlob in a new session")
 	}
-	s.msgsSeen = append(s.msgsSeen, seqNo)
+	if err != nil {
+		return err
+	}
+	if info.Size() != s.blobSize {
+		return blobIncomplete("%d of %d bytes of the blob were uploaded, upload it again in a new session", info.Size(), s.blobSize)
+	}
+	return nil
 }
 
 // returns truth tables for the circuit number cNo from the
 // blob which we received earlier from the client
-func (s *Session) RetrieveBlobsForNotary(cNo int) []byte {
+func (s *Session) RetrieveBlobsForNotary(cNo int) ([]byte, error) {
 	off, ttSize := s.getCircuitBlobOffset(cNo)
+	if m := s.getMemoryBlob(); m != nil {
+		buffer, ok := m.slice(off, ttSize)
+		if !ok {
+			return nil, invalidMessage("the blob is too short for circuit %d", cNo)
+		}
+		return buffer, nil
+	}
 	path := filepath.Join(s.StorageDir, "blobForNotary")
 	file, err := os.Open(path)
 	if err != nil {
-		panic(err)
+		return nil, outOfOrder("the blob was not uploaded")
 	}
+	defer file.Close()
 	buffer := make([]byte, ttSize)
 	_, err = file.ReadAt(buffer, int64(off))
-	if err != nil && err != io.EOF {
-		panic(err)
+	if err == io.EOF {
+		return nil, invalidMessage("the blob is too short for circuit %d", cNo)
+	}
+	if err != nil {
+		return nil, err
 	}
-	tt := buffer[:ttSize]
-	return tt
+	return buffer, nil
 }
 
 // GetCircuitBlobOffset finds the offset and size of the tt+dt blob for circuit cNo
@@ -1019,9 +1964,11 @@ func (s *Session) getCircuitBlobOffset(cNo int) (int, int) {
 	ttLen := 0
 	for i := 1; i < len(s.g.Cs); i++ {
 		offset += ttLen
-		ttLen = s.g.Cs[i].Meta.AndGateCount * 48
+		ttLen = s.g.Cs[i].Meta.AndGateCount * meta.AndGateTableSize(s.e.HalfGates)
 		if i == 6 {
 			ttLen = s.g.C6Count * ttLen
+		} else if i == 7 {
+			ttLen = s.g.C7Count * ttLen
 		}
 		if i == cNo {
 			break
@@ -1034,28 +1981,21 @@ func (s *Session) getCircuitBlobOffset(cNo int) (int, int) {
 func (s *Session) c_step1(cNo int) []byte {
 	inputLabels := s.g.GetNotaryLabels(cNo)
 
-	go func() {
+	s.startOt(fmt.Sprintf("c%d_step1", cNo), func() ([]byte, error) {
 		// respond to a request
-		err := s.Ot.RespondWithData(s.g.GetClientLabels(cNo))
-		if err != nil {
-			log.Println(err)
-			s.OtReleaseChan <- s.Sid
-			s.DestroyChan <- s.Sid // destroy self
-			return
+		var clientLabels []byte
+		if cNo <= PRECOMPUTED_CIRCUITS {
+			clientLabels = s.takeClientLabels(cNo)
+		} else {
+			clientLabels = s.g.GetClientLabels(cNo)
 		}
-
-		// request the same thing from the other party
-		step2OtResp, err := s.Ot.RequestData(s.g.Cs[cNo].InputBits)
+		err := s.otRespond(clientLabels)
 		if err != nil {
-			log.Println(err)
-			s.OtReleaseChan <- s.Sid
-			s.DestroyChan <- s.Sid // destroy self
-			return
+			return nil, err
 		}
-
-		s.lastOtResponse = step2OtResp
-		s.lastResponseFrom = fmt.Sprintf("c%d_step1", cNo)
-	}()
+		// request the same thing from the other party
+		return s.otRequest(s.g.Cs[cNo].InputBits)
+	})
 
 	return inputLabels
 }
@@ -1064,68 +2004,117 @@ func (s *Session) c_step1(cNo int) []byte {
 // convert each input into a bit array with the least bit of each input at index[0]
 func (s *Session) setCircuitInputs(cNo int, inputs ...[]byte) {
 	for _, v := range inputs {
-		s.g.Cs[cNo].InputBits = append(s.g.Cs[cNo].InputBits, u.BytesToBits(v)...)
+		// InputBits were preallocated by the garbler
+		s.g.Cs[cNo].InputBits = u.AppendBits(s.g.Cs[cNo].InputBits, v)
 	}
 }
 
-// common_step2 is Step2 which is the same for all circuits. Returns a value
-// which must be sent to the Client as part of dual execution garbling.
-func (s *Session) common_step2(cNo int, body []byte) []byte {
-	ttBlob := s.RetrieveBlobsForNotary(cNo)
-	notaryLabels, clientLabels, clientCommitment := s.parse_step2(cNo, body)
+// common_step2 is Step2 which is the same for all circuits. Returns the parts
+// of a value which must be sent to the Client as part of dual execution
+// garbling: the encoded outputs and the decoding table.
+func (s *Session) common_step2(cNo int, body []byte) ([][]byte, error) {
+	ttBlob, err := s.RetrieveBlobsForNotary(cNo)
+	if err != nil {
+		return nil, err
+	}
+	// the truth tables read from disk are only held while the circuit is
+	// evaluated. Those of a blob in memory are counted as MEM_MEMORY_BLOB.
+	if s.getMemoryBlob() == nil {
+		s.Mem.Add(MEM_BLOB_BUFFERS, len(ttBlob))
+		defer s.Mem.Add(MEM_BLOB_BUFFERS, -len(ttBlob))
+	}
+	notaryLabels, clientLabels, clientCommitment, err := s.parse_step2(cNo, body)
+	if err != nil {
+		return nil, err
+	}
 	s.hisCommitment[cNo] = clientCommitment
+	start := time.Now()
 	s.encodedOutput[cNo] = s.e.Evaluate(cNo, notaryLabels, clientLabels, ttBlob)
-	return u.Concat(s.encodedOutput[cNo], u.Concat(s.dt[cNo]...))
+	countEvaluation(len(ttBlob), start)
+	s.discardBlobForNotary(cNo)
+	s.Mem.Add(MEM_ENCODED_OUTPUTS, len(s.encodedOutput[cNo]))
+	return [][]byte{s.encodedOutput[cNo], s.dt[cNo]}, nil
+}
+
+// step2 decrypts the message of a circuit's step2 and returns the encrypted
+// response of common_step2
+func (s *Session) step2(cNo int, encrypted []byte) ([]byte, error) {
+	body, err := s.decryptFromClient(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	parts, err := s.common_step2(cNo, body)
+	if err != nil {
+		return nil, err
+	}
+	return s.encryptToClient(parts...), nil
 }
 
 // parse_step2 is common for all circuits. Returns notary's and client's input
 // labels for the circuit number cNo.
 // Notary is acting as the evaluator. Client sent his input labels in the clear
 // and he also sent notary's input labels via OT.
-func (s *Session) parse_step2(cNo int, body []byte) ([]byte, []byte, []byte) {
+func (s *Session) parse_step2(cNo int, body []byte) ([]byte, []byte, []byte, error) {
 	o := 0
 	// exeCount is how many executions of this circuit we need
-	exeCount := []int{0, 1, 1, 1, 1, 1, s.g.C6Count, 1}[cNo]
+	exeCount := []int{0, 1, 1, 1, 1, 1, s.g.C6Count, s.g.C7Count}[cNo]
 	allClientLabelsSize := s.g.Cs[cNo].Meta.ClientInputSize * 16 * exeCount
+	if err := checkSize(body, allClientLabelsSize+32); err != nil {
+		return nil, nil, nil, err
+	}
 	clientLabels := body[o : o+allClientLabelsSize]
 	o += allClientLabelsSize
 	clientCommitment := body[o : o+32]
-	o += 32
-	u.Assert(o == len(body))
 
-	// stupid fix for a data race where this is called by the client before this side received an OT response
-	// for the previous step
-	expectedLastResponseSource := fmt.Sprintf("c%d_step1", cNo)
-	for s.lastResponseFrom != expectedLastResponseSource {
-		time.Sleep(5 * time.Millisecond)
+	// the client may send step2 before this side received the OT response
+	// of step1
+	notaryLabels, err := s.awaitOt(fmt.Sprintf("c%d_step1", cNo))
+	if err != nil {
+		return nil, nil, nil, err
 	}
-	notaryLabels := s.lastOtResponse
 
-	return notaryLabels, clientLabels, clientCommitment
+	return notaryLabels, clientLabels, clientCommitment, nil
 }
 
 // processDecommit processes Client's decommitment, makes sure it matches the
 // commitment, decodes and parses the Notary's circuit output.
 // Client committed first, then Notary revealed his encoded outputs and
 // decoding table and now the Client decommits.
-func (s *Session) processDecommit(cNo int, decommit []byte) []byte {
+func (s *Session) processDecommit(cNo int, decommit []byte) ([]byte, error) {
 	o := 0
+	myDecodingTable := s.dt[cNo]
+	if err := checkSize(decommit, len(s.encodedOutput[cNo])+len(myDecodingTable)+32); err != nil {
+		return nil, err
+	}
 	hisEncodedOutput := decommit[o : o+len(s.encodedOutput[cNo])]
 	o += len(s.encodedOutput[cNo])
-	myDecodingTable := u.Concat(s.dt[cNo]...)
 	hisDecodingTable := decommit[o : o+len(myDecodingTable)]
 	o += len(myDecodingTable)
 	hisSalt := decommit[o : o+32]
-	o += 32
-	u.Assert(o == len(decommit))
-	u.Assert(bytes.Equal(s.hisCommitment[cNo], u.Sha256(u.Concat(
-		hisEncodedOutput, hisDecodingTable, hisSalt))))
+	decommitHash := u.Sha256(u.Concat(hisEncodedOutput, hisDecodingTable, hisSalt))
+	if !u.ConstantTimeEqual(s.hisCommitment[cNo], decommitHash) {
+		return nil, &CheatEvidence{
+			Sid:        s.Sid,
+			Circuit:    cNo,
+			Check:      "commitment",
+			NotarySide: hex.EncodeToString(decommitHash),
+			ClientSide: hex.EncodeToString(s.hisCommitment[cNo]),
+		}
+	}
 	// decode his output, my output and compare them
 	hisPlaintext := u.XorBytes(myDecodingTable, hisEncodedOutput)
 	myPlaintext := u.XorBytes(hisDecodingTable, s.encodedOutput[cNo])
-	u.Assert(bytes.Equal(hisPlaintext, myPlaintext))
+	if !u.ConstantTimeEqual(hisPlaintext, myPlaintext) {
+		return nil, &CheatEvidence{
+			Sid:        s.Sid,
+			Circuit:    cNo,
+			Check:      "output",
+			NotarySide: hex.EncodeToString(u.Sha256(myPlaintext)),
+			ClientSide: hex.EncodeToString(u.Sha256(hisPlaintext)),
+		}
+	}
 	output := s.parsePlaintextOutput(cNo, myPlaintext)
-	return output
+	return output, nil
 }
 
 // parsePlaintextOutput parses the plaintext of each circuit execution into
@@ -1133,7 +2122,7 @@ func (s *Session) processDecommit(cNo int, decommit []byte) []byte {
 // output values are in the same order as they appear in the *.casm files
 func (s *Session) parsePlaintextOutput(cNo int, ptBytes []byte) []byte {
 	c := (s.meta)[cNo]
-	exeCount := []int{0, 1, 1, 1, 1, 1, s.g.C6Count, 1}[cNo]
+	exeCount := []int{0, 1, 1, 1, 1, 1, s.g.C6Count, s.g.C7Count}[cNo]
 	chunks := u.SplitIntoChunks(ptBytes, len(ptBytes)/exeCount)
 	var output []byte
 	for i := 0; i < exeCount; i++ {
@@ -0,0 +1,108 @@
+package session
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Phase is a part of the session whose duration can be limited by an SLA
+type Phase int
+
+const (
+	// PHASE_NONE steps don't change the phase, e.g. progress polls
+	PHASE_NONE Phase = iota
+	// PHASE_HANDSHAKE is the TLS handshake in 2PC, from init thru c5_step3
+	PHASE_HANDSHAKE
+	// PHASE_REQUEST_MAC computes the encrypted request and its MAC, from
+	// c6_step1 thru commitHash
+	PHASE_REQUEST_MAC
+	// PHASE_TAG_VERIFICATION checks the tags of the server's response
+	PHASE_TAG_VERIFICATION
+	// PHASE_COUNT is the amount of phases including PHASE_NONE
+	PHASE_COUNT
+)
+
+var phaseNames = [PHASE_COUNT]string{"none", "handshake", "request_mac", "tag_verification"}
+
+func (p Phase) String() string {
+	return phaseNames[p]
+}
+
+// slaExceeded counts the sessions which exceeded the SLA of a phase, by
+// phase name
+var slaExceeded = expvar.NewMap("sla_exceeded")
+
+// ErrSLAExceeded is wrapped by SLAError
+var ErrSLAExceeded = errors.New("phase SLA exceeded")
+
+// SLAError says which phase took too long. Steps return it when the SLA is
+// enforced.
+type SLAError struct {
+	Phase   Phase
+	Elapsed time.Duration
+	Limit   time.Duration
+}
+
+func (e *SLAError) Error() string {
+	return fmt.Sprintf("%s: %s phase took %s, limit %s", ErrSLAExceeded, e.Phase,
+		e.Elapsed.Round(time.Second), e.Limit)
+}
+
+func (e *SLAError) Unwrap() error {
+	return ErrSLAExceeded
+}
+
+// SLA are the target durations of the phases. A phase without a limit (0)
+// may take as long as the session's max duration.
+type SLA struct {
+	Limits [PHASE_COUNT]time.Duration
+	// Enforce terminates the sessions which exceed a limit. Otherwise they
+	// are only counted in sla_exceeded.
+	Enforce bool
+}
+
+// phaseTracker is embedded into Session
+type phaseTracker struct {
+	phaseMutex sync.Mutex
+	phase      Phase
+	phaseStart time.Time
+	// phaseFlagged is set once the current phase was counted in sla_exceeded
+	phaseFlagged bool
+}
+
+// enterPhase moves the session to phase p if p comes after the current
+// phase. The phase starts when its first step is received.
+func (t *phaseTracker) enterPhase(p Phase) {
+	t.phaseMutex.Lock()
+	defer t.phaseMutex.Unlock()
+	if p <= t.phase {
+		return
+	}
+	t.phase = p
+	t.phaseStart = time.Now()
+	t.phaseFlagged = false
+}
+
+// CheckSLA returns an *SLAError if the current phase exceeded its limit at
+// time now. The first time a phase exceeds its limit it is counted in
+// sla_exceeded.
+func (s *Session) CheckSLA(now time.Time) error {
+	s.phaseMutex.Lock()
+	defer s.phaseMutex.Unlock()
+	limit := s.SLA.Limits[s.phase]
+	if s.phase == PHASE_NONE || limit == 0 {
+		return nil
+	}
+	elapsed := now.Sub(s.phaseStart)
+	if elapsed <= limit {
+		return nil
+	}
+	if !s.phaseFlagged {
+		s.phaseFlagged = true
+		slaExceeded.Add(s.phase.String(), 1)
+	}
+	return &SLAError{s.phase, elapsed, limit}
+}
@@ -0,0 +1,45 @@
+package session
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckSLA(t *testing.T) {
+	s := new(Session)
+	s.SLA.Limits[PHASE_HANDSHAKE] = time.Minute
+	start := time.Now()
+	if s.CheckSLA(start.Add(time.Hour)) != nil {
+		t.Fatal("a session without a phase has no SLA")
+	}
+	s.enterPhase(PHASE_HANDSHAKE)
+	if s.CheckSLA(start.Add(30*time.Second)) != nil {
+		t.Fatal("the handshake is within its SLA")
+	}
+	before := slaExceeded.Get("handshake")
+	err := s.CheckSLA(start.Add(2 * time.Minute))
+	var slaErr *SLAError
+	if !errors.As(err, &slaErr) || slaErr.Phase != PHASE_HANDSHAKE || !errors.Is(err, ErrSLAExceeded) {
+		t.Fatal("expected an SLAError, got", err)
+	}
+	s.CheckSLA(start.Add(3 * time.Minute))
+	if before != nil || slaExceeded.Get("handshake").String() != "1" {
+		t.Fatal("the phase must be counted once")
+	}
+	// going back doesn't restart the phase, moving on does
+	s.enterPhase(PHASE_NONE)
+	s.enterPhase(PHASE_REQUEST_MAC)
+	if s.CheckSLA(start.Add(time.Hour)) != nil {
+		t.Fatal("the request MAC phase has no SLA")
+	}
+}
+
+func TestEveryMethodStepHasAPhase(t *testing.T) {
+	unphased := map[string]bool{"getUploadProgress": true, "getOtProgress": true, "otComplete": true}
+	for _, step := range Protocol {
+		if step.Method != nil && step.Phase == PHASE_NONE && !unphased[step.Command] {
+			t.Error(step.Command, "has no phase")
+		}
+	}
+}
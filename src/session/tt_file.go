@@ -0,0 +1,88 @@
+package session
+
+import (
+	"expvar"
+	"io"
+	"os"
+	"sync"
+)
+
+// ttFilesOpen counts the truth table files which are not closed yet
+var ttFilesOpen = expvar.NewInt("tt_files_open")
+
+// TtFile is a truth table file of the session. It is reference counted:
+// the session holds one reference until it is removed and each getBlob
+// stream holds one while it reads the file. The file is closed and deleted
+// only after the last reference was dropped, so a stream never reads from a
+// closed or half-deleted file.
+type TtFile struct {
+	sync.Mutex
+	f    *os.File
+	refs int
+	// released is set when the session dropped its reference. No new
+	// references can be acquired then.
+	released bool
+	// onClosed is called with the path of the file once it is closed
+	onClosed func(path string)
+}
+
+func newTtFile(f *os.File) *TtFile {
+	ttFilesOpen.Add(1)
+	return &TtFile{f: f, refs: 1}
+}
+
+// Name returns the path of the file
+func (t *TtFile) Name() string {
+	return t.f.Name()
+}
+
+// Acquire takes a reference for reading. It returns false if the session
+// already released the file.
+func (t *TtFile) Acquire() bool {
+	t.Lock()
+	defer t.Unlock()
+	if t.released {
+		return false
+	}
+	t.refs++
+	return true
+}
+
+// Release drops a reference taken with Acquire
+func (t *TtFile) Release() {
+	t.Lock()
+	t.refs--
+	last := t.refs == 0
+	t.Unlock()
+	if last {
+		t.close()
+	}
+}
+
+// SafeRelease drops the session's reference. onClosed is called with the
+// path of the file once the last reader released it, possibly right away.
+func (t *TtFile) SafeRelease(onClosed func(path string)) {
+	t.Lock()
+	if t.released {
+		t.Unlock()
+		return
+	}
+	t.released = true
+	t.onClosed = onClosed
+	t.Unlock()
+	t.Release()
+}
+
+func (t *TtFile) close() {
+	t.f.Close()
+	ttFilesOpen.Add(-1)
+	if t.onClosed != nil {
+		t.onClosed(t.f.Name())
+	}
+}
+
+// Reader returns a reader of the whole file. Readers don't share the file
+// offset. The reference must be held while reading.
+func (t *TtFile) Reader() io.Reader {
+	return io.NewSectionReader(t.f, 0, 1<<62)
+}
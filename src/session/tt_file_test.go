@@ -0,0 +1,55 @@
+package session
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestTtFile(t *testing.T) *TtFile {
+	path := filepath.Join(t.TempDir(), "tt")
+	if err := os.WriteFile(path, []byte("truth tables"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTtFile(f)
+}
+
+func TestTtFileClosedAfterLastReader(t *testing.T) {
+	tt := newTestTtFile(t)
+	if !tt.Acquire() {
+		t.Fatal("could not acquire")
+	}
+	var closed []string
+	tt.SafeRelease(func(path string) { closed = append(closed, path) })
+	if len(closed) != 0 {
+		t.Fatal("closed while a reader holds a reference")
+	}
+	if tt.Acquire() {
+		t.Error("acquired after the session released the file")
+	}
+	// the reader can still read the whole file
+	data, err := io.ReadAll(tt.Reader())
+	if err != nil || string(data) != "truth tables" {
+		t.Errorf("read %q, %v", data, err)
+	}
+	tt.Release()
+	if len(closed) != 1 || closed[0] != tt.Name() {
+		t.Errorf("expected one onClosed call, got %v", closed)
+	}
+	// releasing twice is harmless
+	tt.SafeRelease(func(path string) { t.Error("onClosed called twice") })
+}
+
+func TestTtFileClosedRightAway(t *testing.T) {
+	tt := newTestTtFile(t)
+	closed := false
+	tt.SafeRelease(func(string) { closed = true })
+	if !closed {
+		t.Error("expected the file to be closed without readers")
+	}
+}
@@ -0,0 +1,122 @@
+package session_manager
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"time"
+)
+
+const (
+	// DEFAULT_QUEUE_POLL_TIMEOUT is how long a queued client may go without
+	// polling init when QueuePollTimeout is not set
+	DEFAULT_QUEUE_POLL_TIMEOUT = 30 * time.Second
+	// defaultOtHoldTime is the estimated time a session holds OT before the
+	// first session released it
+	defaultOtHoldTime = time.Minute
+)
+
+// ErrQueueFull is returned by AddSession when OT is busy and the admission
+// queue has no room for the client
+var ErrQueueFull = errors.New("OT is busy and the admission queue is full")
+
+// Queued is returned by AddSession when the client must wait for OT. The
+// client polls init with the same session id until it is admitted.
+type Queued struct {
+	// Position is the amount of clients ahead in the queue
+	Position int
+	// EstimatedWait is the expected time until the client is admitted
+	EstimatedWait time.Duration
+}
+
+func (q *Queued) Error() string {
+	return fmt.Sprintf("queued at position %d, estimated wait %s", q.Position, q.EstimatedWait)
+}
+
+var (
+	// queuedClients is the current length of the admission queues
+	queuedClients = expvar.NewInt("admission_queue_length")
+	// queueTimeouts counts the clients dropped from a queue because they
+	// stopped polling
+	queueTimeouts = expvar.NewInt("admission_queue_timeouts")
+)
+
+// queueEntry is a client waiting in an admissionQueue
+type queueEntry struct {
+	sid      string
+	lastPoll time.Time
+}
+
+// admissionQueue is a FIFO of the clients waiting for one kind of OT: the
+// legacy global OT manager or the pool. The session manager's mutex guards
+// it.
+type admissionQueue struct {
+	entries []queueEntry
+	// holdTime is the moving average of how long sessions held the OT
+	holdTime time.Duration
+}
+
+// poll returns the position of sid, appending it if it isn't queued yet. It
+// returns -1 if sid is not queued and the queue holds maxLength clients.
+func (q *admissionQueue) poll(sid string, maxLength int, now time.Time) int {
+	for i := range q.entries {
+		if q.entries[i].sid == sid {
+			q.entries[i].lastPoll = now
+			return i
+		}
+	}
+	if len(q.entries) >= maxLength {
+		return -1
+	}
+	q.entries = append(q.entries, queueEntry{sid, now})
+	queuedClients.Add(1)
+	return len(q.entries) - 1
+}
+
+// remove takes sid out of the queue
+func (q *admissionQueue) remove(sid string) {
+	for i := range q.entries {
+		if q.entries[i].sid == sid {
+			q.entries = append(q.entries[:i], q.entries[i+1:]...)
+			queuedClients.Add(-1)
+			return
+		}
+	}
+}
+
+// expire drops the clients which didn't poll within timeout
+func (q *admissionQueue) expire(timeout time.Duration, now time.Time) {
+	kept := q.entries[:0]
+	for _, e := range q.entries {
+		if now.Sub(e.lastPoll) > timeout {
+			queuedClients.Add(-1)
+			queueTimeouts.Add(1)
+			continue
+		}
+		kept = append(kept, e)
+	}
+	q.entries = kept
+}
+
+// released records that a session held the OT for d
+func (q *admissionQueue) released(d time.Duration) {
+	if q.holdTime == 0 {
+		q.holdTime = d
+		return
+	}
+	// weigh the last 8 sessions or so
+	q.holdTime = (7*q.holdTime + d) / 8
+}
+
+// estimateWait returns the expected wait of the client at position when
+// slots OT managers serve the queue
+func (q *admissionQueue) estimateWait(position int, slots int) time.Duration {
+	holdTime := q.holdTime
+	if holdTime == 0 {
+		holdTime = defaultOtHoldTime
+	}
+	if slots < 1 {
+		slots = 1
+	}
+	return holdTime * time.Duration(position/slots+1)
+}
@@ -0,0 +1,46 @@
+package session_manager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdmissionQueueOrder(t *testing.T) {
+	var q admissionQueue
+	now := time.Now()
+	if q.poll("a", 2, now) != 0 || q.poll("b", 2, now) != 1 {
+		t.Fatal("clients must be queued in order")
+	}
+	if q.poll("c", 2, now) != -1 {
+		t.Fatal("a full queue must reject new clients")
+	}
+	if q.poll("b", 2, now) != 1 {
+		t.Fatal("polling again must keep the position")
+	}
+	q.remove("a")
+	if q.poll("b", 2, now) != 0 {
+		t.Fatal("b must move up")
+	}
+}
+
+func TestAdmissionQueueExpire(t *testing.T) {
+	var q admissionQueue
+	now := time.Now()
+	q.poll("a", 2, now)
+	q.poll("b", 2, now.Add(20*time.Second))
+	q.expire(30*time.Second, now.Add(40*time.Second))
+	if len(q.entries) != 1 || q.entries[0].sid != "b" {
+		t.Fatal("only the client which stopped polling must be dropped", q.entries)
+	}
+}
+
+func TestAdmissionQueueEstimate(t *testing.T) {
+	var q admissionQueue
+	if q.estimateWait(0, 1) != defaultOtHoldTime {
+		t.Fatal("unexpected estimate without samples")
+	}
+	q.released(2 * time.Minute)
+	if q.estimateWait(3, 2) != 4*time.Minute {
+		t.Fatal("unexpected estimate", q.estimateWait(3, 2))
+	}
+}
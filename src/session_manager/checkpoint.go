@@ -0,0 +1,140 @@
+package session_manager
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"notary/session"
+	u "notary/utils"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// checkpointKeyFile holds the key which seals the checkpoints. It is
+// created in the checkpoint dir on first use so that the checkpoints can be
+// opened after a restart.
+const checkpointKeyFile = "checkpoint.key"
+
+// CheckpointStore keeps sealed session checkpoints in a dir, one file per
+// session. A file is nonce(12) | ciphertext | tag(16) of the JSON of a
+// session.Checkpoint (see u.AESGCMencrypt). The checkpoint includes the
+// sid, which binds the file to its session.
+type CheckpointStore struct {
+	dir string
+	key []byte
+}
+
+// NewCheckpointStore opens the checkpoint dir, creating it and its key if
+// needed
+func NewCheckpointStore(dir string) (*CheckpointStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	keyPath := filepath.Join(dir, checkpointKeyFile)
+	key, err := os.ReadFile(keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		key = u.GetRandom(32)
+		err = os.WriteFile(keyPath, key, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, errors.New("unexpected size of " + keyPath)
+	}
+	return &CheckpointStore{dir: dir, key: key}, nil
+}
+
+// path names the file after the hash of the sid, which is chosen by the
+// client
+func (cs *CheckpointStore) path(sid string) string {
+	return filepath.Join(cs.dir, hex.EncodeToString(u.Sha256([]byte(sid))))
+}
+
+// Save replaces the checkpoint of its session
+func (cs *CheckpointStore) Save(c *session.Checkpoint) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	path := cs.path(c.Sid)
+	// write to a temp file first so that a crash doesn't leave a truncated
+	// checkpoint
+	tmpPath := path + ".tmp"
+	if err = os.WriteFile(tmpPath, u.AESGCMencrypt(cs.key, data), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Load returns the checkpoint of sid if it exists, was sealed by this store
+// and is younger than maxAge
+func (cs *CheckpointStore) Load(sid string, maxAge time.Duration) (*session.Checkpoint, error) {
+	sealed, err := os.ReadFile(cs.path(sid))
+	if err != nil {
+		return nil, err
+	}
+	data, err := u.AESGCMopen(cs.key, sealed)
+	if err != nil {
+		return nil, session.ErrNotResumable
+	}
+	c := new(session.Checkpoint)
+	if err = json.Unmarshal(data, c); err != nil || c.Sid != sid {
+		return nil, session.ErrNotResumable
+	}
+	if time.Since(c.StartTime) > maxAge {
+		return nil, session.ErrNotResumable
+	}
+	return c, nil
+}
+
+// Delete removes the checkpoint of sid if there is one
+func (cs *CheckpointStore) Delete(sid string) {
+	err := os.Remove(cs.path(sid))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Println("cannot delete checkpoint:", err)
+	}
+}
+
+// checkpoint saves the state of the session after a step, once it can be
+// restored
+func (sm *SessionManager) checkpoint(s *session.Session) {
+	c, ok := s.Checkpoint()
+	if !ok {
+		return
+	}
+	if err := sm.Checkpoints.Save(c); err != nil {
+		log.Println("cannot save checkpoint:", err)
+	}
+}
+
+// restoreSession recreates the session key from its checkpoint after a
+// restart of the notary. The restored session has no OT manager: it is past
+// the steps which need one.
+func (sm *SessionManager) restoreSession(key string) *session.Session {
+	c, err := sm.Checkpoints.Load(key, sm.MaxDuration)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err == nil {
+		s := sm.newSession(key, c.ProtocolVersion, nil)
+		s.Ot = nil
+		if err = s.Restore(c); err == nil {
+			sm.Lock()
+			defer sm.Unlock()
+			if item, ok := sm.sessions[key]; ok {
+				// restored concurrently by another request
+				return item.session
+			}
+			now := time.Now().Unix()
+			sm.sessions[key] = &smItem{s, sm.methodLookup(s), now, c.StartTime.Unix(), nil}
+			log.Println("session", key, "restored from its checkpoint")
+			return s
+		}
+	}
+	log.Println("cannot restore session", key, ":", err)
+	sm.Checkpoints.Delete(key)
+	return nil
+}
@@ -0,0 +1,51 @@
+package session_manager
+
+import (
+	"errors"
+	"notary/session"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCheckpointStore(t *testing.T) {
+	dir := t.TempDir()
+	cs, err := NewCheckpointStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &session.Checkpoint{Sid: "a", StartTime: time.Now(), SwkShare: []byte{1}}
+	if err = cs.Save(c); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new store in the same dir, as after a restart, opens the checkpoint
+	cs, err = NewCheckpointStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := cs.Load("a", time.Minute)
+	if err != nil || loaded.Sid != "a" || loaded.SwkShare[0] != 1 {
+		t.Fatal("unexpected checkpoint", loaded, err)
+	}
+	if _, err = cs.Load("a", 0); err != session.ErrNotResumable {
+		t.Fatal("an expired checkpoint must be rejected")
+	}
+
+	// the checkpoint of a must not be usable for b
+	data, _ := os.ReadFile(cs.path("a"))
+	os.WriteFile(cs.path("b"), data, 0600)
+	if _, err = cs.Load("b", time.Minute); err != session.ErrNotResumable {
+		t.Fatal("a checkpoint of another session must be rejected")
+	}
+	data[len(data)-1] ^= 1
+	os.WriteFile(cs.path("a"), data, 0600)
+	if _, err = cs.Load("a", time.Minute); err != session.ErrNotResumable {
+		t.Fatal("a tampered checkpoint must be rejected")
+	}
+
+	cs.Delete("a")
+	if _, err = cs.Load("a", time.Minute); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("the checkpoint must be deleted")
+	}
+}
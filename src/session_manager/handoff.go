@@ -0,0 +1,133 @@
+package session_manager
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"log"
+	"notary/session"
+	u "notary/utils"
+	"time"
+)
+
+// handoffTTL is how long a handoff token can be used
+const handoffTTL = 10 * time.Minute
+
+var (
+	ErrInvalidHandoffToken = errors.New("invalid handoff token")
+	ErrHandoffNotPossible  = errors.New("the session can't be handed off")
+)
+
+// handoffToken is sealed with the session manager's handoff key, so that
+// the client can't read or forge it:
+// expiry(8) | nonce(HANDOFF_NONCE_SIZE) | sid
+type handoffToken struct {
+	expiry time.Time
+	nonce  []byte
+	sid    string
+}
+
+func (sm *SessionManager) sealHandoffToken(t *handoffToken) []byte {
+	plaintext := make([]byte, 8)
+	binary.BigEndian.PutUint64(plaintext, uint64(t.expiry.Unix()))
+	plaintext = u.Concat(plaintext, t.nonce, []byte(t.sid))
+	return u.AESGCMencrypt(sm.handoffKey, plaintext)
+}
+
+func (sm *SessionManager) openHandoffToken(sealed []byte) (*handoffToken, error) {
+	// the token is nonce(12) | ciphertext | tag(16), see u.AESGCMencrypt
+	if len(sealed) < 12+8+session.HANDOFF_NONCE_SIZE+1+16 {
+		return nil, ErrInvalidHandoffToken
+	}
+	block, err := aes.NewCipher(sm.handoffKey)
+	if err != nil {
+		return nil, err
+	}
+	aesgcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aesgcm.Open(nil, sealed[:12], sealed[12:], nil)
+	if err != nil {
+		return nil, ErrInvalidHandoffToken
+	}
+	t := &handoffToken{
+		expiry: time.Unix(int64(binary.BigEndian.Uint64(plaintext[:8])), 0),
+		nonce:  plaintext[8 : 8+session.HANDOFF_NONCE_SIZE],
+		sid:    string(plaintext[8+session.HANDOFF_NONCE_SIZE:]),
+	}
+	if time.Now().After(t.expiry) {
+		return nil, ErrInvalidHandoffToken
+	}
+	return t, nil
+}
+
+// ExportSession returns a token with which another client instance can
+// resume the session. body must be encrypted with the session's client
+// key. Only sessions with a pooled OT manager can be handed off: the legacy
+// global OT manager is shared by all sessions.
+func (sm *SessionManager) ExportSession(key string, body []byte) ([]byte, error) {
+	sm.Lock()
+	item, ok := sm.sessions[key]
+	pooled := ok && item.ot != nil
+	sm.Unlock()
+	if !pooled {
+		return nil, ErrHandoffNotPossible
+	}
+	// fails if the body is not from the client or if it is too late
+	nonce, err := item.session.PrepareHandoff(body)
+	if err != nil {
+		return nil, err
+	}
+	return sm.sealHandoffToken(&handoffToken{
+		expiry: time.Now().Add(handoffTTL),
+		nonce:  nonce,
+		sid:    key,
+	}), nil
+}
+
+// ResumeSession moves the session of the token to the new key. The OT
+// manager of the session accepts a new connection, the old client instance
+// can't send any more messages.
+func (sm *SessionManager) ResumeSession(newKey string, token []byte) (*session.Session, error) {
+	t, err := sm.openHandoffToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	sm.Lock()
+	item, ok := sm.sessions[t.sid]
+	if !ok || item.ot == nil {
+		sm.Unlock()
+		return nil, ErrHandoffNotPossible
+	}
+	if _, exists := sm.sessions[newKey]; exists && newKey != t.sid {
+		sm.Unlock()
+		return nil, ErrHandoffNotPossible
+	}
+	if !item.session.ConsumeHandoff(t.nonce) {
+		sm.Unlock()
+		return nil, ErrInvalidHandoffToken
+	}
+	delete(sm.sessions, t.sid)
+	sm.sessions[newKey] = item
+	item.session.Sid = newKey
+	item.lastSeen = int64(time.Now().UnixNano() / 1e9)
+	ot := item.ot
+	sm.Unlock()
+
+	// if the old instance already connected for OT, drop its connection.
+	// Otherwise the manager is still waiting for the first connection.
+	if ot.IsConnected() {
+		ot.Disconnect()
+		go func() {
+			err := ot.Listen()
+			if err != nil {
+				log.Println("pooled OT listen error:", err)
+			}
+		}()
+	}
+	log.Println("session", t.sid, "resumed as", newKey)
+	return item.session, nil
+}
@@ -0,0 +1,34 @@
+package session_manager
+
+// Load is a snapshot of how busy the notary is. A session needs OT, so the
+// free OT slots are the sessions which can start without waiting.
+type Load struct {
+	// Sessions is the amount of active sessions
+	Sessions int `json:"sessions"`
+	// OtSlots is the amount of sessions which can run OT at once: the
+	// global OT manager and the managers of the pool. OtFree of them are
+	// not owned by a session.
+	OtSlots int `json:"otSlots"`
+	OtFree  int `json:"otFree"`
+	// Queued is the amount of clients waiting for OT
+	Queued int `json:"queued"`
+}
+
+// Load returns the current load of the notary
+func (sm *SessionManager) Load() Load {
+	sm.Lock()
+	defer sm.Unlock()
+	l := Load{
+		Sessions: len(sm.sessions),
+		OtSlots:  1,
+		Queued:   len(sm.otQueue.entries) + len(sm.poolQueue.entries),
+	}
+	if sm.otOwner == "" {
+		l.OtFree = 1
+	}
+	if sm.otPool != nil {
+		l.OtSlots += sm.otPool.Size()
+		l.OtFree += sm.otPool.Free()
+	}
+	return l
+}
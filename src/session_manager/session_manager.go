@@ -1,56 +1,48 @@
 package session_manager
 
 import (
+	"expvar"
 	"log"
 	at "notary/aes_tag"
+	"notary/janitor"
 	"notary/session"
-	"os"
+	"notary/transparency_log"
+	u "notary/utils"
+	"sort"
 	"sync"
 	"time"
 
 	"notary/ote"
 )
 
-var CommandList = []string{
-	"init",
-	"getUploadProgress",
-	"step1",
-	"step2",
-	"step3",
-	"step4",
-	"c1_step1",
-	"c1_step2",
-	"c1_step3",
-	"c1_step4",
-	"c1_step5",
-	"c2_step1",
-	"c2_step2",
-	"c2_step3",
-	"c2_step4",
-	"c3_step1",
-	"c3_step2",
-	"c4_step1",
-	"c4_step2",
-	"c4_step3",
-	"c5_pre1",
-	"c5_step1",
-	"c5_step2",
-	"c5_step3",
-	"c6_step1",
-	"c6_pre2",
-	"c6_step2",
-	"c7_step1",
-	"c7_step2",
-	"ghash_step1",
-	"ghash_step2",
-	"ghash_step3",
-	"commitHash",
-	"prepTagVerification",
-	"pollTagVerification",
-	"tagVerification",
+// CommandList are the commands handled by the session's methods, generated
+// from session.Protocol
+var CommandList = commandList()
+
+func commandList() []string {
+	var commands []string
+	for _, step := range session.Protocol {
+		if step.Method != nil {
+			commands = append(commands, step.Command)
+		}
+	}
+	return commands
 }
 
-type method func([]byte) []byte
+const (
+	// DEFAULT_IDLE_TIMEOUT is how long a session may wait for the client's
+	// next message when IdleTimeout is not set
+	DEFAULT_IDLE_TIMEOUT = 20 * time.Minute
+	// DEFAULT_MAX_DURATION is how long a session may last when MaxDuration
+	// is not set
+	DEFAULT_MAX_DURATION = 40 * time.Minute
+)
+
+// signalChanSize is the buffer size of the destroy and OT release chans.
+// Sessions never block when sending to them, see Session.Destroy
+const signalChanSize = 256
+
+type method func([]byte) ([]byte, error)
 
 // smItem is stored internally by SessionManager
 type smItem struct {
@@ -59,6 +51,9 @@ type smItem struct {
 	methodLookup map[string]method
 	lastSeen     int64 // timestamp of last activity
 	creationTime int64 // timestamp
+	// ot is the OT manager acquired from the pool. It is nil for sessions
+	// which use the legacy global OT manager.
+	ot *ote.Manager
 }
 
 // SessionManager manages TLSNotary sessions from multiple users. When a user
@@ -69,108 +64,119 @@ type SessionManager struct {
 	sessions      map[string]*smItem
 	destroyChan   chan string
 	otReleaseChan chan string
+	// janitor deletes the files of removed sessions in the background
+	janitor *janitor.Janitor
 	sync.Mutex
 	tagVerification *at.TagVerificationManager
 	tagSigner       *at.TagSigningManager
-	ot              *ote.Manager
-	otOwner         string
+	// ot is the legacy global OT manager. Only one session at a time can own it.
+	ot      *ote.Manager
+	otOwner string
+	// otPool provides OT managers to clients which negotiated
+	// PROTOCOL_POOLED_OT. May be nil when the pool is disabled.
+	otPool *ote.Pool
+	// handoffKey seals the handoff tokens, see ExportSession
+	handoffKey []byte
+	// IdleTimeout and MaxDuration limit the lifetime of the sessions, see
+	// monitorSessions. They must be set before Init.
+	IdleTimeout time.Duration
+	MaxDuration time.Duration
+	// StorageDir is the dir in which the sessions store their files
+	StorageDir string
+	// Checkpoints persists the sessions which can be resumed after a restart
+	// of the notary, see session.Checkpoint. Nil disables checkpoints. It
+	// must be set before Init.
+	Checkpoints *CheckpointStore
+	// TransparencyLog logs the signatures of the sessions, see
+	// session.Session.TransparencyLog. Nil disables the log.
+	TransparencyLog *transparency_log.Log
+	// SLA limits the phases of all sessions. It must be set before Init.
+	SLA session.SLA
+	// VerifierOnly sessions don't sign their results, see
+	// session.Session.VerifierOnly
+	VerifierOnly bool
+	// QueueLength is the max amount of clients waiting for each kind of OT
+	// (the global OT manager or the pool). 0 rejects clients when OT is
+	// busy. QueuePollTimeout is how long a queued client may go without
+	// polling before it loses its place. They must be set before Init.
+	QueueLength      int
+	QueuePollTimeout time.Duration
+	otQueue          admissionQueue
+	poolQueue        admissionQueue
+	// slaTerminated are the sessions terminated by monitorSessions because
+	// they exceeded the SLA, with the time of termination. They are
+	// remembered for IdleTimeout so that the client learns why.
+	slaTerminated map[string]time.Time
 }
 
-func (sm *SessionManager) Init(tagVerificationCircuitDir string, portIvBegin int, portPoHBegin int, ts *at.TagSigningManager, ot *ote.Manager) {
+// slaTerminations counts the sessions terminated for exceeding the SLA
+var slaTerminations = expvar.NewInt("sla_terminated")
+
+func (sm *SessionManager) Init(tagVerificationCircuitDir string, portIvBegin int, portPoHBegin int, ts *at.TagSigningManager, ot *ote.Manager, otPool *ote.Pool, jan *janitor.Janitor) {
 	sm.sessions = make(map[string]*smItem)
+	sm.slaTerminated = make(map[string]time.Time)
+	if sm.IdleTimeout == 0 {
+		sm.IdleTimeout = DEFAULT_IDLE_TIMEOUT
+	}
+	if sm.MaxDuration == 0 {
+		sm.MaxDuration = DEFAULT_MAX_DURATION
+	}
+	if sm.QueuePollTimeout == 0 {
+		sm.QueuePollTimeout = DEFAULT_QUEUE_POLL_TIMEOUT
+	}
 	go sm.monitorSessions()
-	sm.destroyChan = make(chan string)
-	sm.otReleaseChan = make(chan string)
+	sm.destroyChan = make(chan string, signalChanSize)
+	sm.otReleaseChan = make(chan string, signalChanSize)
 	go sm.monitorDestroyChan()
 	go sm.monitorOtReleaseChan()
+	sm.publishMetrics()
 	sm.tagVerification = at.NewTagVerificationManager(tagVerificationCircuitDir, portIvBegin, portPoHBegin)
 	sm.tagSigner = ts
 	sm.ot = ot
+	sm.otPool = otPool
+	sm.janitor = jan
+	sm.handoffKey = u.GetRandom(32)
 }
 
-// addSession creates a new session and sets its creation time
-func (sm *SessionManager) AddSession(key string) *session.Session {
+// AddSession creates a new session and sets its creation time.
+// protocolVersion decides whether the session uses the legacy global OT
+// manager or gets its own OT manager from the pool. If no OT manager is
+// free, the client is queued and AddSession returns a *Queued error, or
+// ErrQueueFull.
+func (sm *SessionManager) AddSession(key string, protocolVersion int) (*session.Session, error) {
 	if _, ok := sm.sessions[key]; ok {
 		log.Println("Error: session already exists ", key)
 	}
 
-	if sm.otOwner != "" {
-		log.Println("Error: cannot create session: OT is busy")
-		return nil
+	pooled := protocolVersion >= session.PROTOCOL_POOLED_OT && sm.otPool != nil
+	if err := sm.admit(key, pooled); err != nil {
+		return nil, err
+	}
+	var pooledOt *ote.Manager
+	if pooled {
+		var err error
+		pooledOt, err = sm.otPool.Acquire(key)
+		if err != nil {
+			log.Println("Error: cannot create session:", err)
+			return nil, err
+		}
 	}
 
-	s := new(session.Session)
-	s.Ot = sm.ot
-	s.Tv = sm.tagVerification
-	s.Ts = sm.tagSigner
-	s.Sid = key
-	s.DestroyChan = sm.destroyChan
-	s.OtReleaseChan = sm.otReleaseChan
+	s := sm.newSession(key, protocolVersion, pooledOt)
 	now := int64(time.Now().UnixNano() / 1e9)
-	methodLookup := map[string]method{
-		"init": s.Init,
-
-		"getUploadProgress": s.GetUploadProgress,
-
-		//  step1 thru step4 deal with Paillier 2PC
-		"step1": s.Step1,
-		"step2": s.Step2,
-		"step3": s.Step3,
-		"step4": s.Step4,
-
-		// // c1_step1 thru c1_step1 deal with TLS Handshake
-		"c1_step1": s.C1_step1,
-		"c1_step2": s.C1_step2,
-		"c1_step3": s.C1_step3,
-		"c1_step4": s.C1_step4,
-		"c1_step5": s.C1_step5,
-
-		// // c2_step1 thru c2_step4 deal with TLS Handshake
-		"c2_step1": s.C2_step1,
-		"c2_step2": s.C2_step2,
-		"c2_step3": s.C2_step3,
-		"c2_step4": s.C2_step4,
-
-		// // c3_step1 thru c4_step3 deal with TLS Handshake and also prepare data
-		// // needed to send Client Finished
-		"c3_step1": s.C3_step1,
-		"c3_step2": s.C3_step2,
-		"c4_step1": s.C4_step1,
-		"c4_step2": s.C4_step2,
-		"c4_step3": s.C4_step3,
-
-		// // c5_pre1 thru c5_step3 check Server Finished
-		"c5_pre1":  s.C5_pre1,
-		"c5_step1": s.C5_step1,
-		"c5_step2": s.C5_step2,
-		"c5_step3": s.C5_step3,
-
-		// // c6_step1 thru c6_step2 prepare encrypted counter blocks for the
-		// // client's request to the webserver
-		"c6_step1": s.C6_step1,
-		"c6_pre2":  s.C6_pre2,
-		"c6_step2": s.C6_step2,
-
-		// // c7_step1 thru c7_step2 prepare the GCTR block needed to compute the MAC
-		// // for the client's request
-		"c7_step1": s.C7_step1,
-		"c7_step2": s.C7_step2,
-
-		// // steps ghash_step1 thru ghash_step3 compute the GHASH output needed to
-		// // compute the MAC for the client's request
-		"ghash_step1": s.Ghash_step1,
-		"ghash_step2": s.Ghash_step2,
-		"ghash_step3": s.Ghash_step3,
-
-		"commitHash": s.CommitHash,
-
-		"prepTagVerification": s.PrepTagVerification,
-		"pollTagVerification": s.PollTagVerification,
-		"tagVerification":     s.TagVerification,
-	}
 	sm.Lock()
 	defer sm.Unlock()
-	sm.sessions[key] = &smItem{s, methodLookup, now, now}
+	sm.sessions[key] = &smItem{s, sm.methodLookup(s), now, now, pooledOt}
+
+	if pooledOt != nil {
+		go func() {
+			err := pooledOt.Listen()
+			if err != nil {
+				log.Println("pooled OT listen error:", err)
+			}
+		}()
+		return s, nil
+	}
 
 	go func() {
 		err := sm.ot.Listen()
@@ -182,13 +188,101 @@ func (sm *SessionManager) AddSession(key string) *session.Session {
 		log.Println("new OT owner:", sm.otOwner)
 	}()
 
+	return s, nil
+}
+
+// newSession creates a session with the settings of the session manager.
+// ot is the session's pooled OT manager, nil for the global one.
+func (sm *SessionManager) newSession(key string, protocolVersion int, ot *ote.Manager) *session.Session {
+	s := new(session.Session)
+	s.Ot = sm.ot
+	if ot != nil {
+		s.Ot = ot
+	}
+	s.ProtocolVersion = protocolVersion
+	s.Tv = sm.tagVerification
+	s.Ts = sm.tagSigner
+	s.TransparencyLog = sm.TransparencyLog
+	s.Sid = key
+	s.StorageRoot = sm.StorageDir
+	s.SLA = sm.SLA
+	s.VerifierOnly = sm.VerifierOnly
+	s.DestroyChan = sm.destroyChan
+	s.OtReleaseChan = sm.otReleaseChan
 	return s
 }
 
+// methodLookup maps the commands to the methods of s. With checkpoints
+// enabled each successful step updates the session's checkpoint.
+func (sm *SessionManager) methodLookup(s *session.Session) map[string]method {
+	methodLookup := make(map[string]method)
+	for command, m := range s.Methods() {
+		if sm.Checkpoints == nil {
+			methodLookup[command] = m
+			continue
+		}
+		m := m
+		methodLookup[command] = func(body []byte) ([]byte, error) {
+			out, err := m(body)
+			if err == nil {
+				sm.checkpoint(s)
+			}
+			return out, err
+		}
+	}
+	return methodLookup
+}
+
+// admit decides whether the client key may create its session now. Clients
+// which find no free OT manager, or clients queued before them, wait in the
+// queue of the OT they need and are admitted in the order in which they
+// first asked.
+func (sm *SessionManager) admit(key string, pooled bool) error {
+	sm.Lock()
+	defer sm.Unlock()
+	q, free, slots := &sm.otQueue, 0, 1
+	if pooled {
+		q, free, slots = &sm.poolQueue, sm.otPool.Free(), sm.otPool.Size()
+	} else if sm.otOwner == "" {
+		free = 1
+	}
+	if len(q.entries) == 0 && free > 0 {
+		return nil
+	}
+	position := q.poll(key, sm.QueueLength, time.Now())
+	if position < 0 {
+		log.Println("Error: cannot create session: OT is busy and the queue is full")
+		return ErrQueueFull
+	}
+	if position < free {
+		q.remove(key)
+		return nil
+	}
+	return &Queued{Position: position, EstimatedWait: q.estimateWait(position-free, slots)}
+}
+
+// otReleased records how long the session key held the OT of q
+func (sm *SessionManager) otReleased(q *admissionQueue, key string) {
+	s, ok := sm.sessions[key]
+	if !ok {
+		return
+	}
+	held := time.Since(time.Unix(s.creationTime, 0))
+	sm.Lock()
+	q.released(held)
+	sm.Unlock()
+}
+
 // get an already-existing session associated with the key
-// and update the last-seen time
+// and update the last-seen time. A session which is not in memory is
+// restored from its checkpoint if there is one.
 func (sm *SessionManager) GetSession(key string) *session.Session {
 	val, ok := sm.sessions[key]
+	if !ok && sm.Checkpoints != nil {
+		if s := sm.restoreSession(key); s != nil {
+			return s
+		}
+	}
 	if !ok {
 		log.Println("Error: the requested session does not exist ", key)
 		return nil
@@ -213,10 +307,33 @@ func (sm *SessionManager) GetMethod(methodStr string, key string) method {
 	return f
 }
 
-// removeSession removes the session and associated storage data
+// DestroySession destroys the session key if it exists. It returns false if
+// there is no such session.
+func (sm *SessionManager) DestroySession(key string) bool {
+	s := sm.GetSession(key)
+	if s == nil {
+		return false
+	}
+	s.Destroy()
+	return true
+}
+
+// removeSession removes the session, its checkpoint and associated
+// storage data
 func (sm *SessionManager) removeSession(key string) {
+	if sm.Checkpoints != nil {
+		sm.Checkpoints.Delete(key)
+	}
+	sm.closeSession(key)
+}
+
+// closeSession removes the session and associated storage data but keeps
+// its checkpoint
+func (sm *SessionManager) closeSession(key string) {
 	if sm.otOwner == key {
 		sm.ot.Disconnect()
+		detachOt(sm.ot)
+		sm.otReleased(&sm.otQueue, key)
 		sm.otOwner = ""
 	}
 	s, ok := sm.sessions[key]
@@ -224,23 +341,74 @@ func (sm *SessionManager) removeSession(key string) {
 		log.Println("Cannot remove: session does not exist ", key)
 		return
 	}
-	err := os.RemoveAll(s.session.StorageDir)
-	if err != nil {
-		log.Println("Error while removing session ", key)
-		log.Println(err)
-	}
-	for _, sliceOfFiles := range s.session.Tt {
-		for _, f := range sliceOfFiles {
-			err = os.Remove(f.Name())
-			if err != nil {
-				log.Println("Error while removing session ", key)
-				log.Println(err)
-			}
-		}
+	sm.releasePooledOt(s)
+	s.session.ReleaseMemoryBlob()
+	s.session.Mem.Release()
+	paths := []string{}
+	if s.session.StorageDir != "" {
+		paths = append(paths, s.session.StorageDir)
 	}
 	sm.Lock()
-	defer sm.Unlock()
 	delete(sm.sessions, key)
+	sm.Unlock()
+	// deleting the files may take a while, leave it to the janitor. Truth
+	// tables which a getBlob stream still reads are deleted when it's done.
+	sm.janitor.Delete(paths)
+	s.session.ReleaseTt(func(path string) {
+		sm.janitor.Delete([]string{path})
+	})
+}
+
+// SessionInfo describes an active session for the admin API
+type SessionInfo struct {
+	Sid string `json:"sid"`
+	// CreationTime and LastSeen are unix timestamps
+	CreationTime int64 `json:"creationTime"`
+	LastSeen     int64 `json:"lastSeen"`
+	// Memory is the approximate amount of bytes held by category
+	Memory      map[string]int64 `json:"memory"`
+	MemoryTotal int64            `json:"memoryTotal"`
+	// OtPort is the port of the pooled OT manager owned by the session
+	OtPort int `json:"otPort,omitempty"`
+	// Ot is the OT traffic of the session so far
+	Ot session.OtStats `json:"ot"`
+}
+
+// Sessions describes all active sessions, the ones which hold the most
+// memory first
+func (sm *SessionManager) Sessions() []SessionInfo {
+	sm.Lock()
+	infos := make([]SessionInfo, 0, len(sm.sessions))
+	for sid, item := range sm.sessions {
+		memory, total := item.session.Mem.Snapshot()
+		info := SessionInfo{
+			Sid:          sid,
+			CreationTime: item.creationTime,
+			LastSeen:     item.lastSeen,
+			Memory:       memory,
+			MemoryTotal:  total,
+			Ot:           item.session.OtStats(),
+		}
+		if item.ot != nil {
+			info.OtPort = item.ot.Port()
+		}
+		infos = append(infos, info)
+	}
+	sm.Unlock()
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].MemoryTotal > infos[j].MemoryTotal
+	})
+	return infos
+}
+
+// publishMetrics exports the depths of the session manager's queues
+func (sm *SessionManager) publishMetrics() {
+	expvar.Publish("session_destroy_queue", expvar.Func(func() interface{} {
+		return len(sm.destroyChan)
+	}))
+	expvar.Publish("session_ot_release_queue", expvar.Func(func() interface{} {
+		return len(sm.otReleaseChan)
+	}))
 }
 
 // monitorSessions removes sessions which have been inactive or which have
@@ -249,15 +417,44 @@ func (sm *SessionManager) monitorSessions() {
 	for {
 		time.Sleep(time.Second)
 		now := int64(time.Now().UnixNano() / 1e9)
+		idle := int64(sm.IdleTimeout.Seconds())
+		maxDuration := int64(sm.MaxDuration.Seconds())
 		for k, v := range sm.sessions {
-			if now-v.lastSeen > 1200 || now-v.creationTime > 2400 {
+			if now-v.lastSeen > idle || now-v.creationTime > maxDuration {
 				log.Println("will remove stale session ", k)
 				sm.removeSession(k)
+				continue
+			}
+			if err := v.session.CheckSLA(time.Now()); err != nil && sm.SLA.Enforce {
+				log.Println("will remove session ", k, ": ", err)
+				slaTerminations.Add(1)
+				sm.Lock()
+				sm.slaTerminated[k] = time.Now()
+				sm.Unlock()
+				sm.removeSession(k)
+			}
+		}
+		sm.Lock()
+		sm.otQueue.expire(sm.QueuePollTimeout, time.Now())
+		sm.poolQueue.expire(sm.QueuePollTimeout, time.Now())
+		for k, t := range sm.slaTerminated {
+			if time.Since(t) > sm.IdleTimeout {
+				delete(sm.slaTerminated, k)
 			}
 		}
+		sm.Unlock()
 	}
 }
 
+// TerminatedForSLA returns true if the session key was recently terminated
+// because it exceeded the SLA
+func (sm *SessionManager) TerminatedForSLA(key string) bool {
+	sm.Lock()
+	defer sm.Unlock()
+	_, ok := sm.slaTerminated[key]
+	return ok
+}
+
 // monitorDestroyChan waits on a chan for a signal from a session to destroy it
 func (sm *SessionManager) monitorDestroyChan() {
 	for {
@@ -271,15 +468,47 @@ func (sm *SessionManager) monitorOtReleaseChan() {
 	for {
 		sid := <-sm.otReleaseChan
 		if sm.otOwner == sid {
+			detachOt(sm.ot)
+			sm.otReleased(&sm.otQueue, sid)
 			sm.otOwner = ""
 			log.Println("OT released by sid:", sid)
 		}
+		if s, ok := sm.sessions[sid]; ok {
+			sm.releasePooledOt(s)
+		}
+	}
+}
+
+// releasePooledOt returns the session's OT manager (if any) to the pool
+func (sm *SessionManager) releasePooledOt(s *smItem) {
+	sm.Lock()
+	ot := s.ot
+	s.ot = nil
+	sm.Unlock()
+	if ot != nil {
+		detachOt(ot)
+		sm.otReleased(&sm.poolQueue, s.session.Sid)
+		sm.otPool.Release(ot)
+		log.Println("pooled OT released by sid:", s.session.Sid)
 	}
 }
 
+// detachOt stops an OT manager from calling back into the session which
+// released it. Otherwise a broken connection of the next owner would destroy
+// the released session, or a session which was already removed.
+func detachOt(ot *ote.Manager) {
+	ot.SetProgressCallback(nil)
+	ot.SetDisconnectCallback(nil)
+}
+
 func (sm *SessionManager) Cleanup() {
 	defer sm.ot.Finish()
+	if sm.otPool != nil {
+		defer sm.otPool.Finish()
+	}
+	// the checkpoints are kept for the restart
 	for id := range sm.sessions {
-		sm.removeSession(id)
+		sm.closeSession(id)
 	}
+	sm.janitor.Wait()
 }
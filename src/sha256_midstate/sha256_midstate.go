@@ -0,0 +1,117 @@
+// Package sha256_midstate implements SHA-256 (FIPS 180-4) with an explicit,
+// exportable midstate. The TLS PRF is computed in 2PC: the garbled circuits
+// output the state of the outer HMAC hash after its first block and the
+// notary finishes the hash from that state. crypto/sha256 can only be resumed
+// from its unexported marshaling format, which may change between Go
+// releases, so we don't rely on it. The compression function is vendored from
+// the Go distribution, see sha256block.go.
+package sha256_midstate
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	// BlockSize is the SHA-256 block size in bytes
+	BlockSize = 64
+	// StateSize is the size of the midstate in bytes
+	StateSize = 32
+
+	// chunk is the name of the block size in sha256block.go
+	chunk = BlockSize
+)
+
+var iv = [8]uint32{
+	0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a,
+	0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19,
+}
+
+// Digest is a SHA-256 computation which can be started from a midstate
+type Digest struct {
+	h [8]uint32
+	// buf holds the bytes of an incomplete block
+	buf []byte
+	// length is the total amount of bytes hashed so far, including the
+	// bytes which led to the midstate
+	length uint64
+}
+
+// New returns a Digest starting from the SHA-256 initial state
+func New() *Digest {
+	return &Digest{h: iv}
+}
+
+// FromState returns a Digest resumed from a 32-byte big-endian midstate
+// which was reached after hashing length bytes. length must be a multiple
+// of the block size.
+func FromState(state []byte, length uint64) (*Digest, error) {
+	if len(state) != StateSize {
+		return nil, errors.New("midstate must be 32 bytes")
+	}
+	if length%BlockSize != 0 {
+		return nil, errors.New("midstate length must be a multiple of the block size")
+	}
+	d := &Digest{length: length}
+	for i := range d.h {
+		d.h[i] = binary.BigEndian.Uint32(state[i*4:])
+	}
+	return d, nil
+}
+
+// Write adds data to the hash. It never returns an error.
+func (d *Digest) Write(data []byte) (int, error) {
+	n := len(data)
+	d.length += uint64(n)
+	if len(d.buf) > 0 {
+		fill := BlockSize - len(d.buf)
+		if fill > len(data) {
+			fill = len(data)
+		}
+		d.buf = append(d.buf, data[:fill]...)
+		data = data[fill:]
+		if len(d.buf) == BlockSize {
+			blockGeneric(d, d.buf)
+			d.buf = d.buf[:0]
+		}
+	}
+	if full := len(data) - len(data)%BlockSize; full > 0 {
+		blockGeneric(d, data[:full])
+		data = data[full:]
+	}
+	d.buf = append(d.buf, data...)
+	return n, nil
+}
+
+// State returns the 32-byte big-endian midstate. It fails if the data
+// written so far doesn't end on a block boundary.
+func (d *Digest) State() ([]byte, error) {
+	if len(d.buf) != 0 {
+		return nil, errors.New("midstate is only defined on a block boundary")
+	}
+	state := make([]byte, StateSize)
+	for i, v := range d.h {
+		binary.BigEndian.PutUint32(state[i*4:], v)
+	}
+	return state, nil
+}
+
+// Sum returns the final hash. The Digest itself is not modified.
+func (d *Digest) Sum() []byte {
+	final := Digest{h: d.h, length: d.length}
+	final.buf = append(final.buf, d.buf...)
+
+	// padding: 0x80, zeroes, then the bit length as a 64-bit big-endian integer
+	bitLength := d.length * 8
+	padding := make([]byte, BlockSize+8)
+	padding[0] = 0x80
+	padLen := BlockSize - int((d.length+8)%BlockSize)
+	binary.BigEndian.PutUint64(padding[padLen:], bitLength)
+	final.Write(padding[:padLen+8])
+
+	out := make([]byte, StateSize)
+	for i, v := range final.h {
+		binary.BigEndian.PutUint32(out[i*4:], v)
+	}
+	return out
+}
@@ -0,0 +1,85 @@
+package sha256_midstate
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"testing/quick"
+)
+
+func TestKnownAnswer(t *testing.T) {
+	for _, tc := range []struct {
+		input    string
+		expected string
+	}{
+		{"", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{"abc", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{"abcdbcdecdefdefgefghfghighijhijkijkljklmklmnlmnomnopnopq", "248d6a61d20638b8e5c026930c3e6039a33ce45964ff2167f6ecedd419db06c1"},
+	} {
+		d := New()
+		d.Write([]byte(tc.input))
+		if hex.EncodeToString(d.Sum()) != tc.expected {
+			t.Errorf("wrong hash of %q", tc.input)
+		}
+	}
+}
+
+func TestMatchesStdlib(t *testing.T) {
+	f := func(data []byte, split uint16) bool {
+		// write in two pieces to exercise the block buffering
+		at := int(split) % (len(data) + 1)
+		d := New()
+		d.Write(data[:at])
+		d.Write(data[at:])
+		expected := sha256.Sum256(data)
+		return bytes.Equal(d.Sum(), expected[:])
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestResumeFromState(t *testing.T) {
+	f := func(prefix [128]byte, suffix []byte) bool {
+		d := New()
+		d.Write(prefix[:])
+		state, err := d.State()
+		if err != nil {
+			return false
+		}
+		resumed, err := FromState(state, 128)
+		if err != nil {
+			return false
+		}
+		resumed.Write(suffix)
+		expected := sha256.Sum256(append(prefix[:], suffix...))
+		return bytes.Equal(resumed.Sum(), expected[:])
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSumDoesNotModifyDigest(t *testing.T) {
+	d := New()
+	d.Write([]byte("abc"))
+	first := d.Sum()
+	if !bytes.Equal(first, d.Sum()) {
+		t.Error("Sum modified the digest")
+	}
+}
+
+func TestInvalidState(t *testing.T) {
+	if _, err := FromState(make([]byte, 31), 64); err == nil {
+		t.Error("expected an error for a short state")
+	}
+	if _, err := FromState(make([]byte, 32), 63); err == nil {
+		t.Error("expected an error for a length which is not a multiple of 64")
+	}
+	d := New()
+	d.Write([]byte{1})
+	if _, err := d.State(); err == nil {
+		t.Error("expected an error for a state off a block boundary")
+	}
+}
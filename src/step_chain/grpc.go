@@ -0,0 +1,74 @@
+package step_chain
+
+import (
+	"log"
+	"net/http"
+	"notary/api_error"
+	"notary/grpc_api"
+	"notary/session"
+	"strings"
+)
+
+// grpcCommands maps the rpc names of grpc_api/notary.proto to the commands
+// of the steps
+var grpcCommands = grpcCommandTable()
+
+func grpcCommandTable() map[string]string {
+	commands := make(map[string]string)
+	for _, step := range session.Protocol {
+		if step.Method != nil {
+			commands[grpc_api.MethodName(step.Command)] = step.Command
+		}
+	}
+	return commands
+}
+
+// ServeGRPC runs one step sent as a unary call of the Notary service in
+// grpc_api/notary.proto, e.g. /notary.Notary/C1Step1 for c1_step1. The
+// session id and the body of the step are the fields of the StepRequest.
+//
+// The step passes through the same middlewares as when sent as an HTTP
+// request. A step answered with an HTTP error status fails with the matching
+// gRPC status and the response body as the status message.
+func (c *Chain) ServeGRPC(w http.ResponseWriter, req *http.Request) {
+	if !grpc_api.IsRequest(req) {
+		http.Error(w, "not a gRPC request", http.StatusUnsupportedMediaType)
+		return
+	}
+	rpc := strings.TrimPrefix(req.URL.Path, "/"+grpc_api.SERVICE+"/")
+	command, ok := grpcCommands[rpc]
+	if !ok {
+		grpc_api.WriteError(w, grpc_api.CODE_UNIMPLEMENTED, "unknown method "+rpc)
+		return
+	}
+	message, err := grpc_api.ReadRequest(req.Body)
+	if err != nil {
+		log.Println("gRPC read failed:", err, req.RemoteAddr)
+		grpc_api.WriteError(w, grpc_api.CODE_INVALID_ARGUMENT, err.Error())
+		return
+	}
+	var stepReq grpc_api.StepRequest
+	if err = stepReq.Unmarshal(message); err != nil {
+		grpc_api.WriteError(w, grpc_api.CODE_INVALID_ARGUMENT, err.Error())
+		return
+	}
+	rec, ok := c.serveStep(req, stepReq.SessionId, command, stepReq.Body)
+	if !ok {
+		grpc_api.WriteError(w, grpc_api.CODE_INTERNAL, "step failed")
+		return
+	}
+	// a no-op if the step set its status
+	rec.WriteHeader(http.StatusOK)
+	if rec.status != http.StatusOK {
+		// the error code is sent as custom metadata
+		if code := rec.header.Get(api_error.HEADER); code != "" {
+			w.Header().Set(api_error.HEADER, code)
+		}
+		grpc_api.WriteError(w, grpc_api.CodeFromHTTPStatus(rec.status), string(rec.body))
+		return
+	}
+	err = grpc_api.WriteResponse(w, (&grpc_api.StepResponse{Body: rec.body}).Marshal())
+	if err != nil {
+		log.Println("gRPC write failed:", err, req.RemoteAddr)
+	}
+}
@@ -0,0 +1,97 @@
+package step_chain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"notary/grpc_api"
+	"os"
+	"regexp"
+	"testing"
+)
+
+// grpcCall sends a unary call and returns the response message and the
+// status code
+func grpcCall(t *testing.T, srv *httptest.Server, rpc string, sid string, body []byte) ([]byte, string) {
+	message := []byte{0x0a, byte(len(sid))}
+	message = append(message, sid...)
+	message = append(message, 0x12, byte(len(body)))
+	message = append(message, body...)
+	framed := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(framed[1:], uint32(len(message)))
+	framed = append(framed, message...)
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/"+grpc_api.SERVICE+"/"+rpc, bytes.NewReader(framed))
+	req.Header.Set("Content-Type", "application/grpc")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatal("expected HTTP/2")
+	}
+	out, _ := io.ReadAll(resp.Body)
+	status := resp.Header.Get("Grpc-Status")
+	if status == "" {
+		status = resp.Trailer.Get("Grpc-Status")
+	}
+	if len(out) >= 5 {
+		out = out[5:]
+	}
+	return out, status
+}
+
+func TestServeGRPC(t *testing.T) {
+	chain := New(func(c *Context) {
+		body, _ := io.ReadAll(c.Req.Body)
+		c.W.Write([]byte(c.Command + " " + c.Req.URL.RawQuery + " " + string(body)))
+	})
+	chain.Use("route", func(next Handler) Handler {
+		return func(c *Context) {
+			c.Command = c.Req.URL.Path[1:]
+			if c.Req.URL.RawQuery == "" {
+				c.W.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			next(c)
+		}
+	})
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(chain.ServeGRPC))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	out, status := grpcCall(t, srv, "C1Step1", "abc", []byte("payload"))
+	want := []byte("c1_step1 abc payload")
+	if status != "0" || !bytes.Equal(out, append([]byte{0x0a, byte(len(want))}, want...)) {
+		t.Fatal("unexpected response", status, out)
+	}
+	if _, status = grpcCall(t, srv, "C1Step1", "", nil); status != "3" {
+		t.Fatal("expected INVALID_ARGUMENT", status)
+	}
+	if _, status = grpcCall(t, srv, "Unknown", "abc", nil); status != "12" {
+		t.Fatal("expected UNIMPLEMENTED", status)
+	}
+}
+
+// notary.proto must have an rpc for each step handled by a session method
+func TestProtoMatchesProtocol(t *testing.T) {
+	proto, err := os.ReadFile("../grpc_api/notary.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpcs := make(map[string]bool)
+	for _, match := range regexp.MustCompile(`rpc (\w+)\(StepRequest\)`).FindAllSubmatch(proto, -1) {
+		rpcs[string(match[1])] = true
+	}
+	if len(rpcs) != len(grpcCommands) {
+		t.Fatal("notary.proto has", len(rpcs), "rpcs, expected", len(grpcCommands))
+	}
+	for rpc := range grpcCommands {
+		if !rpcs[rpc] {
+			t.Fatal("notary.proto is missing", rpc)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package step_chain
+
+import (
+	"encoding/json"
+	"net/http"
+	"notary/api_error"
+	"notary/grpc_api"
+	"notary/session"
+	u "notary/utils"
+)
+
+// stepDescription is a step of /protocol with the rpc which serves it
+type stepDescription struct {
+	session.StepDescription
+	GrpcMethod string `json:"grpcMethod,omitempty"`
+}
+
+// ServeProtocol serves the description of session.Protocol as JSON, so that
+// clients in other languages can be generated from or checked against the
+// running notary. minVersion is the lowest protocol version it accepts.
+func ServeProtocol(minVersion int) http.HandlerFunc {
+	description := session.DescribeProtocol(minVersion)
+	steps := make([]stepDescription, len(description.Steps))
+	for i, step := range description.Steps {
+		steps[i].StepDescription = step
+		if step.SessionMethod {
+			steps[i].GrpcMethod = grpc_api.MethodName(step.Command)
+		}
+	}
+	body, err := json.Marshal(struct {
+		ProtocolVersions [2]int            `json:"protocolVersions"`
+		GrpcService      string            `json:"grpcService"`
+		Steps            []stepDescription `json:"steps"`
+	}{description.ProtocolVersions, grpc_api.SERVICE, steps})
+	if err != nil {
+		panic(err)
+	}
+	etag := u.ETag(body)
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			api_error.Write(w, http.StatusMethodNotAllowed, api_error.METHOD_NOT_ALLOWED, "")
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/json")
+		if u.CheckNotModified(w, req, etag, "public, max-age=3600") {
+			return
+		}
+		w.Write(body)
+	}
+}
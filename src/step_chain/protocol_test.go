@@ -0,0 +1,55 @@
+package step_chain
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeProtocol(t *testing.T) {
+	handler := ServeProtocol(3)
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/protocol", nil))
+	var description struct {
+		ProtocolVersions [2]int `json:"protocolVersions"`
+		GrpcService      string `json:"grpcService"`
+		Steps            []struct {
+			Command    string `json:"command"`
+			GrpcMethod string `json:"grpcMethod"`
+			Request    struct {
+				Encoding string `json:"encoding"`
+				MinSize  int    `json:"minSize"`
+			} `json:"request"`
+		} `json:"steps"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &description); err != nil {
+		t.Fatal(err)
+	}
+	if description.ProtocolVersions[0] != 3 || description.GrpcService != "notary.Notary" {
+		t.Errorf("unexpected description %+v", description)
+	}
+	grpcMethods := make(map[string]string)
+	for _, step := range description.Steps {
+		grpcMethods[step.Command] = step.GrpcMethod
+		if step.Command == "init" && (step.Request.Encoding != "binary" || step.Request.MinSize != 66) {
+			t.Errorf("unexpected init %+v", step)
+		}
+	}
+	if grpcMethods["c1_step1"] != "C1Step1" || grpcMethods["setBlob"] != "" {
+		t.Error("unexpected grpc methods", grpcMethods)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/protocol", nil)
+	req.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+	rec = httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Error("expected 304, got", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/protocol", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Error("expected 405, got", rec.Code)
+	}
+}
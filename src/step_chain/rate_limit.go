@@ -0,0 +1,82 @@
+package step_chain
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+	"notary/api_error"
+	"notary/ban_list"
+	"sync"
+	"time"
+)
+
+var rateLimited = expvar.NewInt("steps_rate_limited")
+
+// bucket is a token bucket of one client IP
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimit limits the steps of each client IP to perMinute, with bursts of
+// up to burst steps. Requests over the limit get 429 Too Many Requests.
+// perMinute <= 0 disables the limit.
+func RateLimit(perMinute int, burst int) Middleware {
+	if perMinute <= 0 {
+		return func(next Handler) Handler { return next }
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	var mutex sync.Mutex
+	buckets := make(map[string]*bucket)
+	rate := float64(perMinute) / float64(time.Minute)
+
+	// forget clients which have been idle long enough to have a full bucket
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			mutex.Lock()
+			for ip, b := range buckets {
+				if float64(time.Since(b.last))*rate >= float64(burst) {
+					delete(buckets, ip)
+				}
+			}
+			mutex.Unlock()
+		}
+	}()
+
+	allow := func(ip string) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		now := time.Now()
+		b, ok := buckets[ip]
+		if !ok {
+			b = &bucket{tokens: float64(burst), last: now}
+			buckets[ip] = b
+		}
+		b.tokens += float64(now.Sub(b.last)) * rate
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+		b.last = now
+		if b.tokens < 1 {
+			return false
+		}
+		b.tokens--
+		return true
+	}
+
+	return func(next Handler) Handler {
+		return func(c *Context) {
+			ip := ban_list.RequestIP(c.Req)
+			if !allow(ip) {
+				log.Println("rate limited request from", c.Req.RemoteAddr)
+				rateLimited.Add(1)
+				api_error.Write(c.W, http.StatusTooManyRequests, api_error.RATE_LIMITED, "")
+				return
+			}
+			next(c)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Package step_chain processes the protocol steps sent by clients as a chain
+// of middlewares, e.g.
+//
+//	route → auth → rate limit → session lookup → response → step dispatch
+//
+// Each middleware gets the request's Context and either handles the request
+// itself (e.g. by rejecting it) or calls the next middleware. Cross-cutting
+// features are added as a new middleware with Use or InsertBefore instead of
+// growing the step handler.
+//
+// The decryption and encryption of the step payloads stay in the session
+// methods: each step has its own message format and some are unencrypted.
+package step_chain
+
+import (
+	"fmt"
+	"net/http"
+	"notary/session"
+	"sync"
+)
+
+// Context is the state of one step request as it passes the chain
+type Context struct {
+	W   http.ResponseWriter
+	Req *http.Request
+	// Command is the step, the URL path without the leading /
+	Command string
+	// Sid is the session id, the part of the URL after ?
+	Sid  string
+	Body []byte
+	// Session is set by the session lookup middleware
+	Session *session.Session
+	// Out is the response body
+	Out []byte
+	// Err is set by the step dispatch if the session method failed
+	Err error
+}
+
+// Handler processes a step
+type Handler func(c *Context)
+
+// Middleware wraps the next handler of the chain
+type Middleware func(next Handler) Handler
+
+type namedMiddleware struct {
+	name string
+	m    Middleware
+}
+
+// Chain is a http.Handler which passes every request through its
+// middlewares in the order in which they were added, and finally to the
+// dispatch handler
+type Chain struct {
+	middlewares []namedMiddleware
+	dispatch    Handler
+	// handler is the composed chain
+	handler Handler
+	sync.RWMutex
+}
+
+func New(dispatch Handler) *Chain {
+	c := &Chain{dispatch: dispatch}
+	c.compose()
+	return c
+}
+
+// Use appends a middleware to the chain. The name identifies it for
+// InsertBefore.
+func (c *Chain) Use(name string, m Middleware) {
+	c.Lock()
+	defer c.Unlock()
+	c.middlewares = append(c.middlewares, namedMiddleware{name, m})
+	c.compose()
+}
+
+// InsertBefore adds a middleware in front of the middleware called before
+func (c *Chain) InsertBefore(before string, name string, m Middleware) error {
+	c.Lock()
+	defer c.Unlock()
+	for i, existing := range c.middlewares {
+		if existing.name == before {
+			c.middlewares = append(c.middlewares[:i], append([]namedMiddleware{{name, m}}, c.middlewares[i:]...)...)
+			c.compose()
+			return nil
+		}
+	}
+	return fmt.Errorf("step_chain: no middleware %q", before)
+}
+
+// Names returns the names of the middlewares in order
+func (c *Chain) Names() []string {
+	c.RLock()
+	defer c.RUnlock()
+	names := make([]string, len(c.middlewares))
+	for i, m := range c.middlewares {
+		names[i] = m.name
+	}
+	return names
+}
+
+// compose must be called with the lock held
+func (c *Chain) compose() {
+	h := c.dispatch
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		h = c.middlewares[i].m(h)
+	}
+	c.handler = h
+}
+
+func (c *Chain) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	c.RLock()
+	h := c.handler
+	c.RUnlock()
+	h(&Context{W: w, Req: req})
+}
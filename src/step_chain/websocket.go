@@ -0,0 +1,143 @@
+package step_chain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"notary/websocket"
+	"time"
+)
+
+// WS_IDLE_TIMEOUT is how long a WebSocket connection may wait for the next
+// step. It matches the idle timeout of the sessions.
+const WS_IDLE_TIMEOUT = 20 * time.Minute
+
+// WS_WRITE_TIMEOUT is the time to send the response of a step, the same as
+// for HTTP responses
+const WS_WRITE_TIMEOUT = 5 * time.Minute
+
+// CLOSE_INTERNAL_ERROR is sent when a step could not be processed
+const CLOSE_INTERNAL_ERROR = 1011
+
+// ServeWebSocket runs the steps of one session over a WebSocket connection.
+// The session id is the query of the upgrade request, e.g. /ws?<sid>.
+//
+// Each binary message is one step:
+//
+//	command length (1 byte) | command | request body
+//
+// and is answered with one binary message:
+//
+//	HTTP status (2 bytes, big-endian) | response body
+//
+// The steps pass through the same middlewares as when sent as separate HTTP
+// requests.
+func (c *Chain) ServeWebSocket(w http.ResponseWriter, req *http.Request) {
+	conn, err := websocket.Upgrade(w, req)
+	if err != nil {
+		log.Println("websocket upgrade failed:", err, req.RemoteAddr)
+		return
+	}
+	defer conn.Close()
+	log.Println("websocket connected", req.RemoteAddr)
+	for {
+		conn.SetReadDeadline(time.Now().Add(WS_IDLE_TIMEOUT))
+		opcode, message, err := conn.ReadMessage()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Println("websocket read failed:", err, req.RemoteAddr)
+			}
+			return
+		}
+		if opcode != websocket.OP_BINARY {
+			conn.CloseWithStatus(websocket.CLOSE_UNSUPPORTED_DATA)
+			return
+		}
+		command, body, ok := parseStepMessage(message)
+		if !ok {
+			conn.CloseWithStatus(websocket.CLOSE_PROTOCOL_ERROR)
+			return
+		}
+		rec, ok := c.serveStep(req, req.URL.RawQuery, command, body)
+		if !ok {
+			conn.CloseWithStatus(CLOSE_INTERNAL_ERROR)
+			return
+		}
+		conn.SetWriteDeadline(time.Now().Add(WS_WRITE_TIMEOUT))
+		err = conn.WriteMessage(websocket.OP_BINARY, rec.message())
+		if err != nil {
+			log.Println("websocket write failed:", err, req.RemoteAddr)
+			return
+		}
+	}
+}
+
+// parseStepMessage splits a step message into the command and the body
+func parseStepMessage(message []byte) (command string, body []byte, ok bool) {
+	if len(message) < 1 || len(message) < 1+int(message[0]) || message[0] == 0 {
+		return "", nil, false
+	}
+	end := 1 + int(message[0])
+	return string(message[1:end]), message[end:], true
+}
+
+// serveStep passes a step received over WebSocket or gRPC through the chain
+// as if it was sent as a POST to /command?sid. ok is false if the step
+// panicked outside of the session methods.
+func (c *Chain) serveStep(req *http.Request, sid string, command string, body []byte) (rec *stepRecorder, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("step panicked:", r, req.RemoteAddr)
+			ok = false
+		}
+	}()
+	stepReq := req.Clone(req.Context())
+	stepReq.Method = http.MethodPost
+	stepReq.URL = &url.URL{Path: "/" + command, RawQuery: sid}
+	stepReq.RequestURI = stepReq.URL.RequestURI()
+	stepReq.Body = io.NopCloser(bytes.NewReader(body))
+	stepReq.ContentLength = int64(len(body))
+	rec = &stepRecorder{header: make(http.Header)}
+	c.ServeHTTP(rec, stepReq)
+	return rec, true
+}
+
+// stepRecorder is the http.ResponseWriter of a step sent over WebSocket
+type stepRecorder struct {
+	header http.Header
+	status int
+	body   []byte
+}
+
+func (r *stepRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *stepRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *stepRecorder) Write(data []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	if len(r.body) == 0 {
+		// don't copy big responses
+		r.body = data
+		return len(data), nil
+	}
+	r.body = append(r.body, data...)
+	return len(data), nil
+}
+
+// message returns the response message of the step
+func (r *stepRecorder) message() []byte {
+	r.WriteHeader(http.StatusOK)
+	out := make([]byte, 2, 2+len(r.body))
+	binary.BigEndian.PutUint16(out, uint16(r.status))
+	return append(out, r.body...)
+}
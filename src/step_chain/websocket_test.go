@@ -0,0 +1,95 @@
+package step_chain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// writeStep sends a masked binary frame with a step
+func writeStep(conn net.Conn, command string, body []byte) {
+	payload := append([]byte{byte(len(command))}, command...)
+	payload = append(payload, body...)
+	frame := []byte{0x82, 0x80 | 126}
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	frame = append(frame, 0, 0, 0, 0) // a zero mask leaves the payload as is
+	conn.Write(append(frame, payload...))
+}
+
+// readStepResponse reads the status and body of a step response
+func readStepResponse(t *testing.T, br *bufio.Reader) (int, []byte) {
+	var header [2]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	if header[0] != 0x82 || header[1] > 125 {
+		t.Fatal("unexpected frame header", header)
+	}
+	payload := make([]byte, header[1])
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatal(err)
+	}
+	return int(binary.BigEndian.Uint16(payload)), payload[2:]
+}
+
+func TestServeWebSocket(t *testing.T) {
+	chain := New(func(c *Context) {
+		body, _ := io.ReadAll(c.Req.Body)
+		c.W.Write([]byte(c.Command + " " + c.Req.URL.RawQuery + " " + string(body)))
+	})
+	chain.Use("route", func(next Handler) Handler {
+		return func(c *Context) {
+			c.Command = c.Req.URL.Path[1:]
+			if c.Command == "unknown" {
+				c.W.WriteHeader(http.StatusNotFound)
+				return
+			}
+			next(c)
+		}
+	})
+	srv := httptest.NewServer(http.HandlerFunc(chain.ServeWebSocket))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /ws?abc HTTP/1.1\r\nHost: x\r\nConnection: Upgrade\r\n" +
+		"Upgrade: websocket\r\nSec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatal("handshake failed", err)
+	}
+
+	writeStep(conn, "c1_step1", []byte("payload"))
+	status, body := readStepResponse(t, br)
+	if status != http.StatusOK || !bytes.Equal(body, []byte("c1_step1 abc payload")) {
+		t.Fatal("unexpected response", status, string(body))
+	}
+	writeStep(conn, "unknown", nil)
+	status, body = readStepResponse(t, br)
+	if status != http.StatusNotFound || len(body) != 0 {
+		t.Fatal("unexpected response", status, string(body))
+	}
+}
+
+func TestParseStepMessage(t *testing.T) {
+	for _, message := range [][]byte{nil, {0}, {5, 'a', 'b'}} {
+		if _, _, ok := parseStepMessage(message); ok {
+			t.Fatal("accepted malformed message", message)
+		}
+	}
+	command, body, ok := parseStepMessage([]byte{2, 'c', '1', 'x'})
+	if !ok || command != "c1" || string(body) != "x" {
+		t.Fatal("wrong split", command, body)
+	}
+}
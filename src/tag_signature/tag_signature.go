@@ -0,0 +1,184 @@
+// Package tag_signature verifies the tag signatures of the notary for
+// relying parties. It only uses the standard library so that it can be
+// imported as github.com/summitto/tlsnotaryserver/tag_signature or copied.
+//
+// A tag signature is an ASN.1 DER encoded ECDSA signature over the SHA-256
+// of a payload, made with the key served at /signing-key.pem. The payload
+// is the ciphertext of the verified record for clients before protocol
+// version 13 and the tag transcript (see ParseTranscript) for later ones.
+package tag_signature
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+const (
+	// TRANSCRIPT_VERSION is the version of a transcript of a single record
+	TRANSCRIPT_VERSION = 1
+	// TRANSCRIPT_VERSION_RECORDS is the version of a transcript of any
+	// amount of records
+	TRANSCRIPT_VERSION_RECORDS = 2
+)
+
+var (
+	// ErrInvalidSignature is returned when the signature doesn't match the
+	// payload and the key
+	ErrInvalidSignature = errors.New("invalid tag signature")
+	// ErrInvalidTranscript is returned for malformed transcripts
+	ErrInvalidTranscript = errors.New("invalid tag transcript")
+)
+
+// Transcript is a parsed tag transcript: the records whose tags the notary
+// verified and the hash of its MPC outputs
+type Transcript struct {
+	Version byte
+	Records []Record
+	// MpcOutputsHash is the SHA-256 of the notary's outputs of the powers of
+	// H and the IV MPCs
+	MpcOutputsHash []byte
+}
+
+// Record is a TLS record of a Transcript
+type Record struct {
+	// Ciphertext is the record's ciphertext followed by its 16 byte tag
+	Ciphertext []byte
+	// AAD is the additional data of the record, e.g. the 13 byte
+	// pseudo-header of TLS 1.2 or the 5 byte record header of TLS 1.3
+	AAD []byte
+	// RecordIv is the explicit nonce of the record
+	RecordIv []byte
+}
+
+// ParsePublicKeyPEM parses the response of /signing-key.pem
+func ParsePublicKeyPEM(data []byte) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("no PEM public key found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ecdsaKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("the signing key is not an ECDSA key")
+	}
+	return ecdsaKey, nil
+}
+
+// Digest returns the digest which a tag signature signs: the SHA-256 of
+// payload
+func Digest(payload []byte) []byte {
+	digest := sha256.Sum256(payload)
+	return digest[:]
+}
+
+// Verify checks that signature is a tag signature of key over payload
+func Verify(key *ecdsa.PublicKey, payload []byte, signature []byte) error {
+	if !ecdsa.VerifyASN1(key, Digest(payload), signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// VerifyTranscript checks the signature over a transcript, given as the
+// bytes of the hex `transcript` of the tag verification response, and
+// returns the parsed transcript
+func VerifyTranscript(key *ecdsa.PublicKey, transcript []byte, signature []byte) (*Transcript, error) {
+	if err := Verify(key, transcript, signature); err != nil {
+		return nil, err
+	}
+	return ParseTranscript(transcript)
+}
+
+// ParseTranscript parses a signed tag transcript. Version 1 has one record:
+//
+//	version(1) | ciphertextLen(4) | ciphertext | aadLen(4) | aad |
+//	recordIv(8) | mpcOutputsHash(32)
+//
+// version 2 any amount of records, each with the length block of its GHASH
+// input:
+//
+//	version(1) | recordCount(2) | (ciphertextLen(4) | ciphertext |
+//	aadLen(4) | aad | recordIv(8) | lengthBlock(16))... | mpcOutputsHash(32)
+//
+// with big-endian lengths. lengthBlock is len(aad) | len(ciphertext without
+// the tag) in bits as 64-bit big-endian numbers; it is checked against the
+// lengths of the record.
+func ParseTranscript(b []byte) (*Transcript, error) {
+	r := &reader{b: b}
+	t := &Transcript{Version: r.byte()}
+	recordCount := 1
+	switch t.Version {
+	case TRANSCRIPT_VERSION:
+	case TRANSCRIPT_VERSION_RECORDS:
+		recordCount = int(binary.BigEndian.Uint16(r.next(2)))
+	default:
+		return nil, fmt.Errorf("%w: unknown version %d", ErrInvalidTranscript, t.Version)
+	}
+	for i := 0; i < recordCount && r.err == nil; i++ {
+		record := Record{
+			Ciphertext: r.next(int(binary.BigEndian.Uint32(r.next(4)))),
+			AAD:        r.next(int(binary.BigEndian.Uint32(r.next(4)))),
+			RecordIv:   r.next(8),
+		}
+		if r.err == nil && len(record.Ciphertext) < 16 {
+			return nil, fmt.Errorf("%w: record %d has no tag", ErrInvalidTranscript, i)
+		}
+		if t.Version == TRANSCRIPT_VERSION_RECORDS {
+			lengthBlock := r.next(16)
+			if r.err == nil && !bytes.Equal(lengthBlock, LengthBlock(len(record.AAD), len(record.Ciphertext)-16)) {
+				return nil, fmt.Errorf("%w: unexpected length block of record %d", ErrInvalidTranscript, i)
+			}
+		}
+		t.Records = append(t.Records, record)
+	}
+	t.MpcOutputsHash = r.next(sha256.Size)
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.b) != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrInvalidTranscript, len(r.b))
+	}
+	return t, nil
+}
+
+// LengthBlock returns the last block of the GHASH input of a record:
+// len(AAD) | len(ciphertext) in bits as big-endian 64-bit numbers. The
+// ciphertext length excludes the tag.
+func LengthBlock(aadLen int, ciphertextLen int) []byte {
+	block := make([]byte, 16)
+	binary.BigEndian.PutUint64(block[:8], uint64(aadLen)*8)
+	binary.BigEndian.PutUint64(block[8:], uint64(ciphertextLen)*8)
+	return block
+}
+
+// reader consumes a transcript. After the first error next returns up to
+// 16 zero bytes, enough for the lengths which are read next.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err == nil && (n < 0 || n > len(r.b)) {
+		r.err = fmt.Errorf("%w: truncated", ErrInvalidTranscript)
+	}
+	if r.err != nil {
+		return make([]byte, min(max(n, 0), 16))
+	}
+	part := r.b[:n]
+	r.b = r.b[n:]
+	return part
+}
+
+func (r *reader) byte() byte {
+	return r.next(1)[0]
+}
@@ -0,0 +1,112 @@
+package tag_signature
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	at "notary/aes_tag"
+)
+
+// newSigner returns a tag signing manager with a fresh key and the PEM of
+// its public key as served at /signing-key.pem
+func newSigner(t *testing.T) (*at.TagSigningManager, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "signing.key")
+	os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	ts, err := at.NewTagSigningManager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ts.PublicKeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts, pub
+}
+
+func TestVerifyTranscript(t *testing.T) {
+	ts, pubPEM := newSigner(t)
+	key, err := ParsePublicKeyPEM(pubPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := at.TranscriptRecord{Ciphertext: make([]byte, 40), AAD: make([]byte, 13), RecordIv: make([]byte, 8)}
+	record.Ciphertext[0] = 7
+	for _, version := range []byte{at.TRANSCRIPT_VERSION, at.TRANSCRIPT_VERSION_RECORDS} {
+		transcript := (&at.TagTranscript{
+			Version:        version,
+			Records:        []at.TranscriptRecord{record},
+			MpcOutputsHash: at.HashMpcOutputs("0\n1", "1"),
+		}).Marshal()
+		signature, err := ts.SignData(transcript)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := VerifyTranscript(key, transcript, signature)
+		if err != nil {
+			t.Fatalf("version %d: %s", version, err)
+		}
+		if parsed.Version != version || len(parsed.Records) != 1 || parsed.Records[0].Ciphertext[0] != 7 || len(parsed.Records[0].AAD) != 13 {
+			t.Fatalf("version %d: unexpected transcript %+v", version, parsed)
+		}
+
+		transcript[len(transcript)-1] ^= 1
+		if _, err = VerifyTranscript(key, transcript, signature); !errors.Is(err, ErrInvalidSignature) {
+			t.Fatalf("version %d: a modified transcript must be rejected", version)
+		}
+	}
+}
+
+func TestVerifyCiphertext(t *testing.T) {
+	ts, pubPEM := newSigner(t)
+	key, err := ParsePublicKeyPEM(pubPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := ts.Sign([]string{"1", "2", "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Verify(key, []byte{1, 2, 3}, signature); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseTranscriptRejects(t *testing.T) {
+	record := at.TranscriptRecord{Ciphertext: make([]byte, 20), AAD: make([]byte, 5), RecordIv: make([]byte, 8)}
+	valid := (&at.TagTranscript{Version: at.TRANSCRIPT_VERSION_RECORDS, Records: []at.TranscriptRecord{record}, MpcOutputsHash: make([]byte, 32)}).Marshal()
+	if _, err := ParseTranscript(valid); err != nil {
+		t.Fatal(err)
+	}
+	badLengthBlock := append([]byte{}, valid...)
+	badLengthBlock[3+4+20+4+5+8] ^= 1
+	huge := append([]byte{}, valid...)
+	huge[3] = 0xff
+	for name, transcript := range map[string][]byte{
+		"empty":        {},
+		"version":      append([]byte{3}, valid[1:]...),
+		"truncated":    valid[:len(valid)-1],
+		"trailing":     append(append([]byte{}, valid...), 0),
+		"length block": badLengthBlock,
+		"huge length":  huge,
+	} {
+		if _, err := ParseTranscript(transcript); !errors.Is(err, ErrInvalidTranscript) {
+			t.Errorf("%s: must be rejected, got %v", name, err)
+		}
+	}
+}
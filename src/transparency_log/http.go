@@ -0,0 +1,140 @@
+package transparency_log
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"notary/api_error"
+	"strconv"
+)
+
+// MAX_ENTRIES is the max amount of leaves of one /log/entries response
+const MAX_ENTRIES = 1000
+
+// proofResponse is the response of /log/proof
+type proofResponse struct {
+	Index    uint64 `json:"index"`
+	TreeSize uint64 `json:"treeSize"`
+	// Leaf is the encoded leaf in hex, see Leaf.Encode
+	Leaf string `json:"leaf"`
+	// Proof is the audit path from the leaf up, in hex
+	Proof []string `json:"proof"`
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(v)
+}
+
+func hexList(hashes [][]byte) []string {
+	list := make([]string, len(hashes))
+	for i, h := range hashes {
+		list[i] = hex.EncodeToString(h)
+	}
+	return list
+}
+
+// uintParam returns the query parameter name, or def if it is missing
+func uintParam(req *http.Request, name string, def uint64) (uint64, bool) {
+	value := req.URL.Query().Get(name)
+	if value == "" {
+		return def, true
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	return n, err == nil
+}
+
+// ServeTreeHead serves a tree head of the current tree, signed on request
+func (l *Log) ServeTreeHead(w http.ResponseWriter, req *http.Request) {
+	treeHead, err := l.TreeHead()
+	if err != nil {
+		log.Println("transparency log:", err)
+		api_error.Write(w, http.StatusServiceUnavailable, api_error.UNAVAILABLE, "")
+		return
+	}
+	writeJSON(w, treeHead)
+}
+
+// ServeProof serves the inclusion proof of the leaf of ?signature= (hex) or
+// of ?index= in the tree of ?treeSize= leaves, which defaults to the
+// current tree
+func (l *Log) ServeProof(w http.ResponseWriter, req *http.Request) {
+	treeSize, ok := uintParam(req, "treeSize", l.Size())
+	if !ok {
+		api_error.Write(w, http.StatusBadRequest, api_error.INVALID_REQUEST, "invalid treeSize")
+		return
+	}
+	index, ok := uintParam(req, "index", 0)
+	if !ok {
+		api_error.Write(w, http.StatusBadRequest, api_error.INVALID_REQUEST, "invalid index")
+		return
+	}
+	if signatureHex := req.URL.Query().Get("signature"); signatureHex != "" {
+		signature, err := hex.DecodeString(signatureHex)
+		if err != nil {
+			api_error.Write(w, http.StatusBadRequest, api_error.INVALID_REQUEST, "invalid signature")
+			return
+		}
+		indexes := l.Find(signature)
+		if len(indexes) == 0 {
+			api_error.Write(w, http.StatusNotFound, api_error.NOT_FOUND, "the signature is not in the log")
+			return
+		}
+		index = indexes[0]
+	}
+	proof, err := l.InclusionProof(index, treeSize)
+	if err != nil {
+		api_error.Write(w, http.StatusBadRequest, api_error.INVALID_REQUEST, err.Error())
+		return
+	}
+	entries, err := l.Entries(index, index+1)
+	if err != nil {
+		api_error.Write(w, http.StatusBadRequest, api_error.INVALID_REQUEST, err.Error())
+		return
+	}
+	writeJSON(w, proofResponse{index, treeSize, hex.EncodeToString(entries[0]), hexList(proof)})
+}
+
+// ServeConsistency serves the proof that the tree of ?first= leaves is a
+// prefix of the tree of ?second= leaves
+func (l *Log) ServeConsistency(w http.ResponseWriter, req *http.Request) {
+	first, ok1 := uintParam(req, "first", 0)
+	second, ok2 := uintParam(req, "second", l.Size())
+	if !ok1 || !ok2 {
+		api_error.Write(w, http.StatusBadRequest, api_error.INVALID_REQUEST, "invalid tree size")
+		return
+	}
+	proof, err := l.ConsistencyProof(first, second)
+	if err != nil {
+		api_error.Write(w, http.StatusBadRequest, api_error.INVALID_REQUEST, err.Error())
+		return
+	}
+	writeJSON(w, struct {
+		Proof []string `json:"proof"`
+	}{hexList(proof)})
+}
+
+// ServeEntries serves the encoded leaves ?start= to ?end=-1 in hex, at most
+// MAX_ENTRIES
+func (l *Log) ServeEntries(w http.ResponseWriter, req *http.Request) {
+	start, ok1 := uintParam(req, "start", 0)
+	end, ok2 := uintParam(req, "end", l.Size())
+	if !ok1 || !ok2 {
+		api_error.Write(w, http.StatusBadRequest, api_error.INVALID_REQUEST, "invalid range")
+		return
+	}
+	if end > start && end-start > MAX_ENTRIES {
+		end = start + MAX_ENTRIES
+	}
+	entries, err := l.Entries(start, end)
+	if err != nil {
+		api_error.Write(w, http.StatusBadRequest, api_error.INVALID_REQUEST, err.Error())
+		return
+	}
+	writeJSON(w, struct {
+		Entries []string `json:"entries"`
+	}{hexList(entries)})
+}
@@ -0,0 +1,216 @@
+package transparency_log
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/bits"
+)
+
+// The hashes of the Merkle tree are those of RFC 6962: a leaf is hashed as
+// SHA-256(0x00 | leaf) and an inner node as SHA-256(0x01 | left | right).
+// A tree of n leaves has the first k leaves (k the largest power of two
+// below n) on the left and the rest on the right.
+
+// ErrInvalidProof is returned when a proof doesn't lead to the expected root
+var ErrInvalidProof = errors.New("invalid Merkle proof")
+
+// LeafHash returns the hash of a leaf
+func LeafHash(leaf []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(leaf)
+	return h.Sum(nil)
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// emptyRoot is the root of the empty tree, the SHA-256 of the empty string
+var emptyRoot = sha256.New().Sum(nil)
+
+// tree keeps the hashes of all complete subtrees: levels[h][i] is the hash
+// of leaves i*2^h to (i+1)*2^h-1. Every subtree on the left of a split is
+// complete, so roots and proofs take O(log n) hashes.
+type tree struct {
+	levels [][][]byte
+}
+
+func (t *tree) size() uint64 {
+	if len(t.levels) == 0 {
+		return 0
+	}
+	return uint64(len(t.levels[0]))
+}
+
+func (t *tree) append(leafHash []byte) {
+	if len(t.levels) == 0 {
+		t.levels = append(t.levels, nil)
+	}
+	t.levels[0] = append(t.levels[0], leafHash)
+	for h := 0; len(t.levels[h])%2 == 0; h++ {
+		if len(t.levels) == h+1 {
+			t.levels = append(t.levels, nil)
+		}
+		n := len(t.levels[h])
+		t.levels[h+1] = append(t.levels[h+1], nodeHash(t.levels[h][n-2], t.levels[h][n-1]))
+	}
+}
+
+// split returns the largest power of two below n, for n > 1
+func split(n uint64) uint64 {
+	return 1 << (bits.Len64(n-1) - 1)
+}
+
+// hash returns the root of the leaves start to start+n-1, n > 0
+func (t *tree) hash(start, n uint64) []byte {
+	if n&(n-1) == 0 && start%n == 0 {
+		return t.levels[bits.TrailingZeros64(n)][start/n]
+	}
+	k := split(n)
+	return nodeHash(t.hash(start, k), t.hash(start+k, n-k))
+}
+
+// root returns the root of the first n leaves
+func (t *tree) root(n uint64) []byte {
+	if n == 0 {
+		return emptyRoot
+	}
+	return t.hash(0, n)
+}
+
+// inclusionProof returns the audit path of leaf m in the tree of the first
+// n leaves, m < n
+func (t *tree) inclusionProof(m, n uint64) [][]byte {
+	var proof [][]byte
+	start := uint64(0)
+	var siblings [][]byte
+	for n > 1 {
+		k := split(n)
+		if m < k {
+			siblings = append(siblings, t.hash(start+k, n-k))
+			n = k
+		} else {
+			siblings = append(siblings, t.hash(start, k))
+			start += k
+			m -= k
+			n -= k
+		}
+	}
+	// the path lists the siblings from the leaf up
+	for i := len(siblings) - 1; i >= 0; i-- {
+		proof = append(proof, siblings[i])
+	}
+	return proof
+}
+
+// consistencyProof returns the proof that the tree of the first m leaves is
+// a prefix of the one of the first n, 0 < m < n
+func (t *tree) consistencyProof(m, n uint64) [][]byte {
+	return t.subproof(m, 0, n, true)
+}
+
+func (t *tree) subproof(m, start, n uint64, complete bool) [][]byte {
+	if m == n {
+		if complete {
+			return nil
+		}
+		return [][]byte{t.hash(start, n)}
+	}
+	k := split(n)
+	if m <= k {
+		return append(t.subproof(m, start, k, complete), t.hash(start+k, n-k))
+	}
+	return append(t.subproof(m-k, start+k, n-k, false), t.hash(start, k))
+}
+
+// VerifyInclusion checks that the leaf with leafHash is leaf index of the
+// tree of size leaves with root, given the audit path proof (RFC 9162,
+// 2.1.3.2)
+func VerifyInclusion(leafHash []byte, index, size uint64, proof [][]byte, root []byte) error {
+	if index >= size {
+		return ErrInvalidProof
+	}
+	fn, sn := index, size-1
+	r := leafHash
+	for _, p := range proof {
+		if sn == 0 {
+			return ErrInvalidProof
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 || !bytes.Equal(r, root) {
+		return ErrInvalidProof
+	}
+	return nil
+}
+
+// VerifyConsistency checks that the tree of size1 leaves with root1 is a
+// prefix of the tree of size2 leaves with root2, given the consistency
+// proof (RFC 9162, 2.1.4.2)
+func VerifyConsistency(size1, size2 uint64, proof [][]byte, root1, root2 []byte) error {
+	if size1 > size2 {
+		return ErrInvalidProof
+	}
+	if size1 == size2 {
+		if len(proof) != 0 || !bytes.Equal(root1, root2) {
+			return ErrInvalidProof
+		}
+		return nil
+	}
+	if size1 == 0 {
+		if len(proof) != 0 {
+			return ErrInvalidProof
+		}
+		return nil
+	}
+	if len(proof) == 0 {
+		return ErrInvalidProof
+	}
+	// a first tree of a power of two size is a node of the second one
+	if size1&(size1-1) == 0 {
+		proof = append([][]byte{root1}, proof...)
+	}
+	fn, sn := size1-1, size2-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return ErrInvalidProof
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 || !bytes.Equal(fr, root1) || !bytes.Equal(sr, root2) {
+		return ErrInvalidProof
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package transparency_log
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// naiveRoot is MTH of RFC 6962 as written there
+func naiveRoot(leafHashes [][]byte) []byte {
+	switch len(leafHashes) {
+	case 0:
+		return emptyRoot
+	case 1:
+		return leafHashes[0]
+	}
+	k := split(uint64(len(leafHashes)))
+	return nodeHash(naiveRoot(leafHashes[:k]), naiveRoot(leafHashes[k:]))
+}
+
+func TestProofs(t *testing.T) {
+	var tr tree
+	var leafHashes [][]byte
+	for n := uint64(1); n <= 33; n++ {
+		leafHash := LeafHash([]byte(fmt.Sprint(n)))
+		tr.append(leafHash)
+		leafHashes = append(leafHashes, leafHash)
+		root := tr.root(n)
+		if !bytes.Equal(root, naiveRoot(leafHashes)) {
+			t.Fatalf("unexpected root of %d leaves", n)
+		}
+		for m := uint64(0); m < n; m++ {
+			proof := tr.inclusionProof(m, n)
+			if err := VerifyInclusion(leafHashes[m], m, n, proof, root); err != nil {
+				t.Fatalf("inclusion of %d in %d: %v", m, n, err)
+			}
+			if m != n-1 && VerifyInclusion(leafHashes[m], m+1, n, proof, root) == nil {
+				t.Fatalf("the proof of %d in %d must not prove another index", m, n)
+			}
+			if m == 0 {
+				continue
+			}
+			proof = tr.consistencyProof(m, n)
+			if err := VerifyConsistency(m, n, proof, tr.root(m), root); err != nil {
+				t.Fatalf("consistency of %d and %d: %v", m, n, err)
+			}
+			if err := VerifyConsistency(m, n, proof, tr.root(m), tr.root(n-1)); !errors.Is(err, ErrInvalidProof) {
+				t.Fatalf("consistency of %d and %d must fail for another root", m, n)
+			}
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// Package transparency_log is an append-only Merkle tree log of the
+// signatures which the notary issues. Every session signature and every
+// tag signature is logged before it is sent. The notary signs tree heads
+// with the tag signing key, and serves inclusion proofs, so that a client
+// can later prove that its attestation was logged by this notary before a
+// given time, and consistency proofs, so that monitors can check that the
+// log was never rewritten. A signature of the notary's keys which is not in
+// the log points to misuse of the keys.
+package transparency_log
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	u "notary/utils"
+	"os"
+	"sync"
+	"time"
+)
+
+// The kinds of logged signatures
+const (
+	// KIND_SESSION is the signature of the ephemeral key in commitHash
+	KIND_SESSION byte = 1
+	// KIND_TAG is the tag signature of tagVerification
+	KIND_TAG byte = 2
+)
+
+// LEAF_VERSION is the version of the leaf format
+const LEAF_VERSION = 0
+
+// LEAF_SIZE is the size of a leaf: version(1) | kind(1) | timestamp(8) |
+// signatureHash(32)
+const LEAF_SIZE = 42
+
+// TREE_HEAD_LABEL precedes the signed tree head in the message which the
+// tag signing key signs
+const TREE_HEAD_LABEL = "tlsnotary tree head v1\x00"
+
+// Leaf is a logged signature
+type Leaf struct {
+	Kind byte
+	// Timestamp is the unix time at which the signature was logged
+	Timestamp int64
+	// SignatureHash is the SHA-256 of the signature as sent to the client
+	SignatureHash []byte
+}
+
+// Encode returns the leaf as version(1) | kind(1) | timestamp(8) |
+// signatureHash(32), big-endian
+func (l *Leaf) Encode() []byte {
+	b := make([]byte, LEAF_SIZE)
+	b[0] = LEAF_VERSION
+	b[1] = l.Kind
+	binary.BigEndian.PutUint64(b[2:10], uint64(l.Timestamp))
+	copy(b[10:], l.SignatureHash)
+	return b
+}
+
+// DecodeLeaf parses an encoded leaf
+func DecodeLeaf(b []byte) (*Leaf, error) {
+	if len(b) != LEAF_SIZE || b[0] != LEAF_VERSION {
+		return nil, errors.New("transparency_log: invalid leaf")
+	}
+	return &Leaf{
+		Kind:          b[1],
+		Timestamp:     int64(binary.BigEndian.Uint64(b[2:10])),
+		SignatureHash: b[10:],
+	}, nil
+}
+
+// TreeHead is a signed tree head
+type TreeHead struct {
+	TreeSize  uint64 `json:"treeSize"`
+	Timestamp int64  `json:"timestamp"`
+	// RootHash is the hex-encoded root of the tree of the first TreeSize
+	// leaves
+	RootHash string `json:"rootHash"`
+	// Signature is the hex-encoded ASN.1 signature of the tag signing key
+	// over SignedData
+	Signature string `json:"signature"`
+	// SigningKeyId is the kid of the tag signing key, see /signing-key.pem
+	SigningKeyId string `json:"signingKeyId"`
+}
+
+// SignedData returns TREE_HEAD_LABEL | treeSize(8) | timestamp(8) |
+// rootHash(32), the message which the signature of a tree head signs
+func SignedData(treeSize uint64, timestamp int64, rootHash []byte) []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b[:8], treeSize)
+	binary.BigEndian.PutUint64(b[8:], uint64(timestamp))
+	return u.Concat([]byte(TREE_HEAD_LABEL), b, rootHash)
+}
+
+// Signer signs tree heads, e.g. the tag signing manager
+type Signer interface {
+	SignData(data []byte) ([]byte, error)
+	KeyId() string
+}
+
+// Log is the transparency log. The leaves are stored one after another in
+// a file, from which the tree is rebuilt on startup.
+type Log struct {
+	sync.Mutex
+	file   *os.File
+	tree   tree
+	leaves [][]byte
+	// index finds the leaves of a signature hash
+	index  map[string][]uint64
+	signer Signer
+}
+
+// NewLog opens the log file at path, creating it if needed. A partial leaf
+// at the end, left by a crash during an append, is removed.
+func NewLog(path string, signer Signer) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	l := &Log{file: file, index: make(map[string][]uint64), signer: signer}
+	complete := len(data) - len(data)%LEAF_SIZE
+	for offset := 0; offset < complete; offset += LEAF_SIZE {
+		leaf := data[offset : offset+LEAF_SIZE]
+		if _, err = DecodeLeaf(leaf); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%w at index %d", err, offset/LEAF_SIZE)
+		}
+		l.add(leaf)
+	}
+	if complete != len(data) {
+		if err = file.Truncate(int64(complete)); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if _, err = file.Seek(int64(complete), io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// add adds an encoded leaf to the tree and the index
+func (l *Log) add(leaf []byte) uint64 {
+	index := l.tree.size()
+	l.tree.append(LeafHash(leaf))
+	l.leaves = append(l.leaves, leaf)
+	key := hex.EncodeToString(leaf[10:])
+	l.index[key] = append(l.index[key], index)
+	return index
+}
+
+// Append logs a signature of kind and returns its index. The signature
+// must not be sent to the client if logging it failed.
+func (l *Log) Append(kind byte, signature []byte) (uint64, error) {
+	leaf := (&Leaf{Kind: kind, Timestamp: time.Now().Unix(), SignatureHash: u.Sha256(signature)}).Encode()
+	l.Lock()
+	defer l.Unlock()
+	_, err := l.file.Write(leaf)
+	if err == nil {
+		err = l.file.Sync()
+	}
+	if err != nil {
+		// drop a partial leaf so that the next ones stay aligned
+		size := int64(l.tree.size()) * LEAF_SIZE
+		if truncErr := l.file.Truncate(size); truncErr == nil {
+			l.file.Seek(size, io.SeekStart)
+		}
+		return 0, err
+	}
+	return l.add(leaf), nil
+}
+
+// Size returns the amount of leaves
+func (l *Log) Size() uint64 {
+	l.Lock()
+	defer l.Unlock()
+	return l.tree.size()
+}
+
+// Root returns the root of the tree of the first size leaves
+func (l *Log) Root(size uint64) ([]byte, error) {
+	l.Lock()
+	defer l.Unlock()
+	if size > l.tree.size() {
+		return nil, errors.New("transparency_log: the tree is smaller")
+	}
+	return l.tree.root(size), nil
+}
+
+// TreeHead signs the root of the current tree
+func (l *Log) TreeHead() (*TreeHead, error) {
+	l.Lock()
+	size := l.tree.size()
+	root := l.tree.root(size)
+	l.Unlock()
+	timestamp := time.Now().Unix()
+	signature, err := l.signer.SignData(SignedData(size, timestamp, root))
+	if err != nil {
+		return nil, err
+	}
+	return &TreeHead{
+		TreeSize:     size,
+		Timestamp:    timestamp,
+		RootHash:     hex.EncodeToString(root),
+		Signature:    hex.EncodeToString(signature),
+		SigningKeyId: l.signer.KeyId(),
+	}, nil
+}
+
+// InclusionProof returns the audit path of leaf index in the tree of the
+// first size leaves
+func (l *Log) InclusionProof(index, size uint64) ([][]byte, error) {
+	l.Lock()
+	defer l.Unlock()
+	if index >= size || size > l.tree.size() {
+		return nil, errors.New("transparency_log: index or tree size out of range")
+	}
+	return l.tree.inclusionProof(index, size), nil
+}
+
+// ConsistencyProof returns the proof that the tree of size1 leaves is a
+// prefix of the tree of size2 leaves
+func (l *Log) ConsistencyProof(size1, size2 uint64) ([][]byte, error) {
+	l.Lock()
+	defer l.Unlock()
+	if size1 > size2 || size2 > l.tree.size() {
+		return nil, errors.New("transparency_log: tree sizes out of range")
+	}
+	if size1 == 0 || size1 == size2 {
+		return nil, nil
+	}
+	return l.tree.consistencyProof(size1, size2), nil
+}
+
+// Find returns the indexes of the leaves of a signature
+func (l *Log) Find(signature []byte) []uint64 {
+	l.Lock()
+	defer l.Unlock()
+	return append([]uint64{}, l.index[hex.EncodeToString(u.Sha256(signature))]...)
+}
+
+// Entries returns the encoded leaves start to end-1
+func (l *Log) Entries(start, end uint64) ([][]byte, error) {
+	l.Lock()
+	defer l.Unlock()
+	if start > end || end > l.tree.size() {
+		return nil, errors.New("transparency_log: entries out of range")
+	}
+	return append([][]byte{}, l.leaves[start:end]...), nil
+}
+
+func (l *Log) Close() error {
+	l.Lock()
+	defer l.Unlock()
+	return l.file.Close()
+}
@@ -0,0 +1,100 @@
+package transparency_log
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	u "notary/utils"
+)
+
+type testSigner struct {
+	key *ecdsa.PrivateKey
+}
+
+func (s *testSigner) SignData(data []byte) ([]byte, error) {
+	return ecdsa.SignASN1(rand.Reader, s.key, u.Sha256(data))
+}
+
+func (s *testSigner) KeyId() string {
+	return "kid"
+}
+
+func TestLog(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &testSigner{key}
+	path := filepath.Join(t.TempDir(), "transparency.log")
+	l, err := NewLog(path, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err = l.Append(KIND_TAG, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	index, err := l.Append(KIND_SESSION, []byte("session signature"))
+	if err != nil || index != 5 {
+		t.Fatal("unexpected index", index, err)
+	}
+	head, err := l.TreeHead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, _ := hex.DecodeString(head.RootHash)
+	signature, _ := hex.DecodeString(head.Signature)
+	if head.TreeSize != 6 || !ecdsa.VerifyASN1(&key.PublicKey, u.Sha256(SignedData(6, head.Timestamp, root)), signature) {
+		t.Fatalf("unexpected tree head %+v", head)
+	}
+	l.Close()
+
+	// a crash in the middle of an append leaves a partial leaf
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte{0, 1, 2})
+	file.Close()
+
+	l, err = NewLog(path, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	indexes := l.Find([]byte("session signature"))
+	if l.Size() != 6 || len(indexes) != 1 || indexes[0] != 5 {
+		t.Fatal("the log was not restored", l.Size(), indexes)
+	}
+	reopenedRoot, _ := l.Root(6)
+	if hex.EncodeToString(reopenedRoot) != head.RootHash {
+		t.Fatal("the restored tree has another root")
+	}
+	if _, err = l.Append(KIND_TAG, []byte("next")); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := l.Entries(6, 7)
+	leaf, err := DecodeLeaf(entries[0])
+	if err != nil || leaf.Kind != KIND_TAG || hex.EncodeToString(leaf.SignatureHash) != hex.EncodeToString(u.Sha256([]byte("next"))) {
+		t.Fatal("unexpected leaf after the partial one", leaf, err)
+	}
+	proof, err := l.InclusionProof(5, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root7, _ := l.Root(7)
+	sessionLeaf, _ := l.Entries(5, 6)
+	if err = VerifyInclusion(LeafHash(sessionLeaf[0]), 5, 7, proof, root7); err != nil {
+		t.Fatal(err)
+	}
+	proof, _ = l.ConsistencyProof(6, 7)
+	if err = VerifyConsistency(6, 7, proof, root, root7); err != nil {
+		t.Fatal(err)
+	}
+}
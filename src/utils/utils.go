@@ -1,26 +1,42 @@
 package utils
 
 import (
+	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"crypto/x509"
-	"encoding"
+	"encoding/asn1"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"math"
 	"math/big"
 	mathrand "math/rand"
+	"net/http"
+	"notary/rng_health"
+	"notary/sha256_midstate"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/blake2b"
 	"golang.org/x/crypto/salsa20/salsa"
 )
 
+// ConstantTimeEqual compares two slices in time which depends only on their
+// lengths. It must be used for all comparisons of secret-derived values like
+// shares, commitments and tags.
+func ConstantTimeEqual(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
 func Sha256(data []byte) []byte {
 	ret := sha256.Sum256(data)
 	return ret[:]
@@ -28,6 +44,9 @@ func Sha256(data []byte) []byte {
 
 // split a slice into smaller slices of size "chunkSize" each
 func SplitIntoChunks(data []byte, chunkSize int) [][]byte {
+	if chunkSize <= 0 {
+		panic("chunkSize <= 0")
+	}
 	if len(data)%chunkSize != 0 {
 		panic("len(data) % chunkSize != 0")
 	}
@@ -119,13 +138,7 @@ func Decrypt(a, b []byte, t uint32, m []byte) []byte {
 // Note that the paper doesn't prescribe a specific method to break the symmerty between A and B,
 // so we choose a circular byte shift instead of a circular bitshift as in Fig6.
 func Encrypt(a, b []byte, t uint32, m []byte) []byte {
-	// double a
-	a2 := make([]byte, 16)
-	copy(a2[:], a[:])
-	leastbyte := make([]byte, 1)
-	copy(leastbyte, a2[0:1])
-	copy(a2[:], a2[1:15])      // Logical left shift by 1 byte
-	copy(a2[14:15], leastbyte) // Restore old least byte as new greatest (non-pointer) byte
+	a2 := double(a)
 	// quadruple b
 	b4 := make([]byte, 16)
 	copy(b4[:], b[:])
@@ -140,6 +153,25 @@ func Encrypt(a, b []byte, t uint32, m []byte) []byte {
 	return XorBytes(mXorK, ro)
 }
 
+// double a label using a circular byte shift (see Encrypt)
+func double(a []byte) []byte {
+	a2 := make([]byte, 16)
+	copy(a2[:], a[:])
+	leastbyte := make([]byte, 1)
+	copy(leastbyte, a2[0:1])
+	copy(a2[:], a2[1:15])      // Logical left shift by 1 byte
+	copy(a2[14:15], leastbyte) // Restore old least byte as new greatest (non-pointer) byte
+	return a2
+}
+
+// HalfGateHash is the tweakable hash H(a, t) of the half-gates garbling scheme
+// (https://eprint.iacr.org/2014/756.pdf). Like Encrypt, it is built from the
+// fixed-key random permutation: H(a, t) = π(2a, t) ^ 2a
+func HalfGateHash(a []byte, t uint32) []byte {
+	a2 := double(a)
+	return XorBytes(randomOracle(a2, t), a2)
+}
+
 // convert bytes into a 0/1 array with least bit at index 0
 func BytesToBits(b []byte) []int {
 	bytes := new(big.Int).SetBytes(b)
@@ -150,10 +182,24 @@ func BytesToBits(b []byte) []int {
 	return bits
 }
 
-// convert an array of 0/1 with least bit at index 0 into bytes
+// AppendBits appends the bits of b to dst in the same order as BytesToBits,
+// without allocating when dst has enough capacity
+func AppendBits(dst []int, b []byte) []int {
+	for i := 0; i < len(b)*8; i++ {
+		dst = append(dst, int(b[len(b)-1-i/8]>>(i%8))&1)
+	}
+	return dst
+}
+
+// convert an array of 0/1 with least bit at index 0 into bytes. If the
+// length of the array is not a multiple of 8, the most significant byte is
+// padded with zero bits.
 func BitsToBytes(b []int) []byte {
 	bigint := new(big.Int)
 	for i := 0; i < len(b); i++ {
+		if b[i] != 0 && b[i] != 1 {
+			panic("bit value is neither 0 nor 1")
+		}
 		bigint.SetBit(bigint, i, uint(b[i]))
 	}
 	// we want to preserver any leading zeroes in the bytes
@@ -204,28 +250,14 @@ func ConcatP(pointers ...*[]byte) []byte {
 	return newSlice
 }
 
-// finishes sha256 hash from a previous mid-state
+// finishes sha256 hash from a previous mid-state reached after one 64-byte block
 func FinishHash(outerState []byte, data []byte) []byte {
-	digest := sha256.New()
-	digestUnmarshaler, ok := digest.(encoding.BinaryUnmarshaler)
-	if !ok {
-		panic("d does not implement UnmarshalBinary")
-	}
-	// sha256.go expects the state to be formatted in a certain way
-	var state []byte
-	magic256 := "sha\x03"
-	state = append(state, magic256...)
-	state = append(state, outerState...)
-	// expects the previous chunk, can be set to zeroes
-	state = append(state, make([]byte, 64)...)
-	var a [8]byte
-	binary.BigEndian.PutUint64(a[:], 64) // 64 bytes processed so far
-	state = append(state, a[:]...)
-	if err := digestUnmarshaler.UnmarshalBinary(state); err != nil {
-		panic("error in UnmarshalBinary")
+	digest, err := sha256_midstate.FromState(outerState, sha256_midstate.BlockSize)
+	if err != nil {
+		panic(err)
 	}
 	digest.Write(data)
-	return digest.Sum(nil)
+	return digest.Sum()
 }
 
 // GetRandom returns a random slice of specified size
@@ -495,40 +527,446 @@ func ExpandRange(min int, max int) []int {
 }
 
 func AESGCMencrypt(key []byte, plaintext []byte) []byte {
+	return AESGCMencryptParts(key, plaintext)
+}
+
+// AESGCMencryptParts encrypts the concatenation of parts into
+// nonce | ciphertext | tag like AESGCMencrypt. The parts are copied only
+// once, into the output buffer where they are encrypted in place.
+func AESGCMencryptParts(key []byte, parts ...[]byte) []byte {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		panic(err.Error())
 	}
-	nonce := make([]byte, 12)
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		panic(err.Error())
-	}
 	aesgcm, err := cipher.NewGCM(block)
 	if err != nil {
 		panic(err.Error())
 	}
-	// we don't reuse plaintext slice when encrypting
-	ciphertext := aesgcm.Seal(nil, nonce, plaintext, nil)
-	return Concat(nonce, ciphertext)
+	nonce := GetRandom(aesgcm.NonceSize())
+	size := 0
+	for _, part := range parts {
+		size += len(part)
+	}
+	out := make([]byte, len(nonce), len(nonce)+size+aesgcm.Overhead())
+	copy(out, nonce)
+	for _, part := range parts {
+		out = append(out, part...)
+	}
+	plaintext := out[len(nonce):]
+	aesgcm.Seal(plaintext[:0], nonce, plaintext, nil)
+	return out[:cap(out)]
+}
+
+// CHUNKED_AEAD_CHUNK_SIZE is the plaintext size of all but the last chunk of
+// ChunkedAEADWriter
+const CHUNKED_AEAD_CHUNK_SIZE = 64 * 1024
+
+// chunkedAEADPrefixSize is the size of the random nonce prefix. The rest of
+// the 12-byte nonce is the chunk counter (4 bytes) and the last chunk flag.
+const chunkedAEADPrefixSize = 7
+
+// ChunkedAEADWriter encrypts a stream in chunks with AES-GCM, so that
+// neither side needs the whole plaintext in memory. The output is
+// noncePrefix(7) followed by the sealed chunks. Each chunk is
+// CHUNKED_AEAD_CHUNK_SIZE bytes of plaintext plus a 16-byte tag, only the
+// last one may be shorter. The last chunk is sealed with a different nonce,
+// which makes truncation detectable.
+type ChunkedAEADWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	nonce   []byte
+	counter uint32
+	// buf holds the plaintext of the current chunk and room for its tag
+	buf    []byte
+	closed bool
+}
+
+func NewChunkedAEADWriter(w io.Writer, key []byte) (*ChunkedAEADWriter, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c := &ChunkedAEADWriter{
+		w:     w,
+		aead:  aead,
+		nonce: make([]byte, 12),
+		buf:   make([]byte, 0, CHUNKED_AEAD_CHUNK_SIZE+aead.Overhead()),
+	}
+	copy(c.nonce, GetRandom(chunkedAEADPrefixSize))
+	if _, err = w.Write(c.nonce[:chunkedAEADPrefixSize]); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ChunkedAEADSize is the size of the output of ChunkedAEADWriter for a
+// plaintext of the given size
+func ChunkedAEADSize(plaintextSize int) int {
+	chunks := (plaintextSize + CHUNKED_AEAD_CHUNK_SIZE - 1) / CHUNKED_AEAD_CHUNK_SIZE
+	if chunks == 0 {
+		chunks = 1
+	}
+	return chunkedAEADPrefixSize + plaintextSize + chunks*16
+}
+
+func (c *ChunkedAEADWriter) Write(p []byte) (int, error) {
+	if c.closed {
+		return 0, errors.New("write to closed ChunkedAEADWriter")
+	}
+	written := 0
+	for len(p) > 0 {
+		// a full chunk is only sealed once more data arrives, because the
+		// last chunk must be sealed as such
+		if len(c.buf) == CHUNKED_AEAD_CHUNK_SIZE {
+			if err := c.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := CHUNKED_AEAD_CHUNK_SIZE - len(c.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		c.buf = append(c.buf, p[:n]...)
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals the last chunk. It doesn't close the underlying writer.
+func (c *ChunkedAEADWriter) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.seal(true)
+}
+
+func (c *ChunkedAEADWriter) seal(last bool) error {
+	binary.BigEndian.PutUint32(c.nonce[chunkedAEADPrefixSize:], c.counter)
+	c.nonce[11] = 0
+	if last {
+		c.nonce[11] = 1
+	}
+	sealed := c.aead.Seal(c.buf[:0], c.nonce, c.buf, nil)
+	c.counter++
+	if c.counter == 0 {
+		return errors.New("ChunkedAEADWriter: too many chunks")
+	}
+	c.buf = c.buf[:0]
+	_, err := c.w.Write(sealed)
+	return err
+}
+
+// ChunkedAEADDecrypt decrypts the output of ChunkedAEADWriter
+func ChunkedAEADDecrypt(key []byte, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < chunkedAEADPrefixSize+aead.Overhead() {
+		return nil, errors.New("chunked AEAD: too short")
+	}
+	nonce := make([]byte, 12)
+	copy(nonce, data[:chunkedAEADPrefixSize])
+	data = data[chunkedAEADPrefixSize:]
+	var plaintext []byte
+	for counter := uint32(0); ; counter++ {
+		size := CHUNKED_AEAD_CHUNK_SIZE + aead.Overhead()
+		last := len(data) <= size
+		if last {
+			size = len(data)
+		}
+		binary.BigEndian.PutUint32(nonce[chunkedAEADPrefixSize:], counter)
+		nonce[11] = 0
+		if last {
+			nonce[11] = 1
+		}
+		plaintext, err = aead.Open(plaintext, nonce, data[:size], nil)
+		if err != nil {
+			return nil, errors.New("chunked AEAD: authentication failed")
+		}
+		data = data[size:]
+		if last {
+			return plaintext, nil
+		}
+	}
+}
+
+// AEAD_FRAME_HEADER_SIZE is the size of the header of each frame of
+// AEADFrameWriter: length(4) | seq(8)
+const AEAD_FRAME_HEADER_SIZE = 12
+
+// AEAD_FRAME_MIN_SIZE and AEAD_FRAME_MAX_SIZE bound the plaintext size of
+// the frames of AEADFrameWriter which a client may choose in init
+const (
+	AEAD_FRAME_MIN_SIZE = 16 * 1024
+	AEAD_FRAME_MAX_SIZE = 1024 * 1024
+)
+
+// aeadFrameFirst and aeadFrameLast are the bits of the frame length which
+// mark the first and the last frame of a stream
+const (
+	aeadFrameFirst = 1 << 30
+	aeadFrameLast  = 1 << 31
+)
+
+// AEADFrameWriter encrypts a stream into length-prefixed frames, so that
+// the receiver can decrypt each frame as soon as it arrived. A frame is
+// length(4) | seq(8) | ciphertext | tag(16), where length is the size of
+// ciphertext | tag with the highest bit set for the last frame and the
+// second highest bit set for the first frame. seq is the sequence number of
+// the frame within the session. The nonce of a frame is
+// 0(3) | flags(1) | seq(8) with the same flags in the lowest bits: the key
+// must not be used with another nonce scheme and seq must never repeat for
+// the key.
+type AEADFrameWriter struct {
+	w    io.Writer
+	aead cipher.AEAD
+	// nextSeq returns the sequence number of the next frame
+	nextSeq func() uint64
+	// frameSize is the plaintext size of all but the last frame
+	frameSize int
+	// buf holds the header and the plaintext of the current frame and room
+	// for its tag
+	buf     []byte
+	started bool
+	closed  bool
+}
+
+// NewAEADFrameWriter returns an AEADFrameWriter with frames of
+// CHUNKED_AEAD_CHUNK_SIZE
+func NewAEADFrameWriter(w io.Writer, key []byte, nextSeq func() uint64) (*AEADFrameWriter, error) {
+	return NewAEADFrameWriterSize(w, key, nextSeq, CHUNKED_AEAD_CHUNK_SIZE)
+}
+
+// NewAEADFrameWriterSize returns an AEADFrameWriter with frames of
+// frameSize bytes of plaintext
+func NewAEADFrameWriterSize(w io.Writer, key []byte, nextSeq func() uint64, frameSize int) (*AEADFrameWriter, error) {
+	if frameSize <= 0 || frameSize > AEAD_FRAME_MAX_SIZE {
+		return nil, errors.New("AEAD frame: invalid frame size")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, AEAD_FRAME_HEADER_SIZE,
+		AEAD_FRAME_HEADER_SIZE+frameSize+aead.Overhead())
+	return &AEADFrameWriter{w: w, aead: aead, nextSeq: nextSeq, frameSize: frameSize, buf: buf}, nil
+}
+
+// AEADFramesSize is the size of the output of AEADFrameWriter for a
+// plaintext of the given size
+func AEADFramesSize(plaintextSize int) int {
+	return AEADFramesSizeN(plaintextSize, CHUNKED_AEAD_CHUNK_SIZE)
+}
+
+// AEADFramesSizeN is AEADFramesSize for frames of frameSize bytes
+func AEADFramesSizeN(plaintextSize int, frameSize int) int {
+	frames := (plaintextSize + frameSize - 1) / frameSize
+	if frames == 0 {
+		frames = 1
+	}
+	return plaintextSize + frames*(AEAD_FRAME_HEADER_SIZE+16)
+}
+
+func (f *AEADFrameWriter) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, errors.New("write to closed AEADFrameWriter")
+	}
+	written := 0
+	for len(p) > 0 {
+		// a full frame is only sealed once more data arrives, because the
+		// last frame must be marked as such
+		if len(f.buf) == AEAD_FRAME_HEADER_SIZE+f.frameSize {
+			if err := f.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := AEAD_FRAME_HEADER_SIZE + f.frameSize - len(f.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		f.buf = append(f.buf, p[:n]...)
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals the last frame. It doesn't close the underlying writer.
+func (f *AEADFrameWriter) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	return f.seal(true)
+}
+
+func (f *AEADFrameWriter) seal(last bool) error {
+	seq := f.nextSeq()
+	var flags uint32
+	if !f.started {
+		flags |= aeadFrameFirst
+		f.started = true
+	}
+	if last {
+		flags |= aeadFrameLast
+	}
+	plaintext := f.buf[AEAD_FRAME_HEADER_SIZE:]
+	sealed := f.aead.Seal(plaintext[:0], aeadFrameNonce(seq, flags), plaintext, nil)
+	binary.BigEndian.PutUint32(f.buf[0:4], uint32(len(sealed))|flags)
+	binary.BigEndian.PutUint64(f.buf[4:12], seq)
+	_, err := f.w.Write(f.buf[:AEAD_FRAME_HEADER_SIZE+len(sealed)])
+	f.buf = f.buf[:AEAD_FRAME_HEADER_SIZE]
+	return err
+}
+
+func aeadFrameNonce(seq uint64, flags uint32) []byte {
+	nonce := make([]byte, 12)
+	nonce[3] = byte(flags >> 30)
+	binary.BigEndian.PutUint64(nonce[4:], seq)
+	return nonce
+}
+
+// AEADFrameReader decrypts the output of AEADFrameWriter frame by frame.
+// Sequence numbers must increase from frame to frame.
+type AEADFrameReader struct {
+	r    io.Reader
+	aead cipher.AEAD
+	// maxFrameSize is the max plaintext size of a frame
+	maxFrameSize int
+	// plaintext is the decrypted rest of the current frame
+	plaintext []byte
+	buf       []byte
+	seq       uint64
+	started   bool
+	done      bool
+}
+
+// NewAEADFrameReader returns an AEADFrameReader for frames of at most
+// CHUNKED_AEAD_CHUNK_SIZE
+func NewAEADFrameReader(r io.Reader, key []byte) (*AEADFrameReader, error) {
+	return NewAEADFrameReaderSize(r, key, CHUNKED_AEAD_CHUNK_SIZE)
+}
+
+// NewAEADFrameReaderSize returns an AEADFrameReader for frames of at most
+// maxFrameSize bytes of plaintext
+func NewAEADFrameReaderSize(r io.Reader, key []byte, maxFrameSize int) (*AEADFrameReader, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &AEADFrameReader{r: r, aead: aead, maxFrameSize: maxFrameSize}, nil
+}
+
+// LastSeq returns the sequence number of the last decrypted frame. The
+// receiver must reject frames with sequence numbers it already saw in
+// earlier streams.
+func (f *AEADFrameReader) LastSeq() uint64 {
+	return f.seq
+}
+
+func (f *AEADFrameReader) Read(p []byte) (int, error) {
+	for len(f.plaintext) == 0 {
+		if f.done {
+			return 0, io.EOF
+		}
+		if err := f.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, f.plaintext)
+	f.plaintext = f.plaintext[n:]
+	return n, nil
+}
+
+// next reads and decrypts the next frame
+func (f *AEADFrameReader) next() error {
+	header := make([]byte, AEAD_FRAME_HEADER_SIZE)
+	if _, err := io.ReadFull(f.r, header); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	flags := length & (aeadFrameFirst | aeadFrameLast)
+	length &^= flags
+	seq := binary.BigEndian.Uint64(header[4:12])
+	if (flags&aeadFrameFirst != 0) == f.started {
+		return errors.New("AEAD frame: first frame expected only at the start")
+	}
+	if length < uint32(f.aead.Overhead()) ||
+		length > uint32(f.maxFrameSize+f.aead.Overhead()) {
+		return errors.New("AEAD frame: invalid length")
+	}
+	if f.started && seq <= f.seq {
+		return errors.New("AEAD frame: sequence number did not increase")
+	}
+	if cap(f.buf) < int(length) {
+		f.buf = make([]byte, f.maxFrameSize+f.aead.Overhead())
+	}
+	sealed := f.buf[:length]
+	if _, err := io.ReadFull(f.r, sealed); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	plaintext, err := f.aead.Open(sealed[:0], aeadFrameNonce(seq, flags), sealed, nil)
+	if err != nil {
+		return errors.New("AEAD frame: authentication failed")
+	}
+	f.plaintext = plaintext
+	f.seq = seq
+	f.started = true
+	f.done = flags&aeadFrameLast != 0
+	return nil
 }
 
 // decrypt and reuse the ciphertext slice to put plaintext into it
 func AESGCMdecrypt(key []byte, ctWithNonce []byte) []byte {
+	pt, err := AESGCMopen(key, ctWithNonce)
+	if err != nil {
+		panic(err.Error())
+	}
+	return pt
+}
+
+// AESGCMopen is AESGCMdecrypt for untrusted input: it returns an error
+// instead of panicking when ctWithNonce is too short or not authentic
+func AESGCMopen(key []byte, ctWithNonce []byte) ([]byte, error) {
+	if len(ctWithNonce) < 12+16 {
+		return nil, errors.New("ciphertext too short")
+	}
 	nonce := ctWithNonce[0:12]
 	ct := ctWithNonce[12:]
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
 	aesgcm, err := cipher.NewGCM(block)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
-	pt, err := aesgcm.Open(ct[:0], nonce, ct, nil)
-	if err != nil {
-		panic(err.Error())
-	}
-	return pt
+	return aesgcm.Open(ct[:0], nonce, ct, nil)
 }
 
 // AEC-CTR encrypt data, setting initial counter to 0
@@ -580,7 +1018,12 @@ func RandInt(min, max int) int {
 	return mathrand.Intn(max-min) + min
 }
 
+// ECDSASign signs the concatenation of items. It panics if the random number
+// generator failed a health test.
 func ECDSASign(key *ecdsa.PrivateKey, items ...[]byte) []byte {
+	if err := rng_health.Err(); err != nil {
+		panic(err)
+	}
 	var concatAll []byte
 	for _, item := range items {
 		concatAll = append(concatAll, item...)
@@ -594,6 +1037,130 @@ func ECDSASign(key *ecdsa.PrivateKey, items ...[]byte) []byte {
 	return signature
 }
 
+// ECDSASignDeterministic is like ECDSASign but derives the nonce from the key
+// and the message as described in RFC 6979. The nonce needs no randomness
+// but the signed session data was derived from random masks, so it also
+// panics if the random number generator failed a health test.
+func ECDSASignDeterministic(key *ecdsa.PrivateKey, items ...[]byte) []byte {
+	if err := rng_health.Err(); err != nil {
+		panic(err)
+	}
+	var concatAll []byte
+	for _, item := range items {
+		concatAll = append(concatAll, item...)
+	}
+	// with a nil rand, crypto/ecdsa signs deterministically and in constant
+	// time
+	der, err := key.Sign(nil, Sha256(concatAll), crypto.SHA256)
+	if err != nil {
+		panic("ecdsa deterministic signature")
+	}
+	var sig struct {
+		R, S *big.Int
+	}
+	if _, err = asn1.Unmarshal(der, &sig); err != nil {
+		panic("ecdsa deterministic signature")
+	}
+	return append(To32Bytes(sig.R), To32Bytes(sig.S)...)
+}
+
+// ValidateP256Point returns an error if (x, y) is not a valid P-256 public
+// key, i.e. if it is the point at infinity, if a coordinate is not reduced
+// modulo the field prime or if the point is not on the curve
+func ValidateP256Point(x, y *big.Int) error {
+	curve := elliptic.P256()
+	p := curve.Params().P
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return errors.New("point is the identity")
+	}
+	if x.Sign() < 0 || y.Sign() < 0 || x.Cmp(p) >= 0 || y.Cmp(p) >= 0 {
+		return errors.New("point coordinates are out of range")
+	}
+	if !curve.IsOnCurve(x, y) {
+		return errors.New("point is not on the curve")
+	}
+	return nil
+}
+
+// ETag returns a strong HTTP entity tag for the concatenation of items
+func ETag(items ...[]byte) string {
+	return `"` + hex.EncodeToString(Sha256(Concat(items...))[:16]) + `"`
+}
+
+// CheckNotModified sets the ETag and Cache-Control headers of a response.
+// If the request's If-None-Match header matches etag, it writes 304 Not
+// Modified and returns true, the caller must not write a body then.
+func CheckNotModified(w http.ResponseWriter, req *http.Request, etag string, cacheControl string) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", cacheControl)
+	for _, header := range req.Header.Values("If-None-Match") {
+		for _, candidate := range strings.Split(header, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				w.WriteHeader(http.StatusNotModified)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// StreamIdleTimeout is how long a streamed response may make no progress
+// before the connection is closed
+const StreamIdleTimeout = time.Minute
+
+// deadlineChunkSize is the max amount of bytes written per deadline extension
+const deadlineChunkSize = 64 * 1024
+
+// DeadlineWriter is a http.ResponseWriter which extends the write deadline
+// of the connection while bytes are flowing. Slow clients can download
+// responses of any size, as long as they don't stall for longer than idle.
+type DeadlineWriter struct {
+	http.ResponseWriter
+	rc   *http.ResponseController
+	idle time.Duration
+}
+
+func NewDeadlineWriter(w http.ResponseWriter, idle time.Duration) *DeadlineWriter {
+	d := &DeadlineWriter{ResponseWriter: w, rc: http.NewResponseController(w), idle: idle}
+	d.extend()
+	return d
+}
+
+func (d *DeadlineWriter) extend() {
+	// fails with http.ErrNotSupported if w is not backed by a connection,
+	// e.g. a httptest.ResponseRecorder. There is no deadline to extend then.
+	d.rc.SetWriteDeadline(time.Now().Add(d.idle))
+}
+
+// Write writes p in chunks, extending the deadline before each chunk
+func (d *DeadlineWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		end := written + deadlineChunkSize
+		if end > len(p) {
+			end = len(p)
+		}
+		d.extend()
+		n, err := d.ResponseWriter.Write(p[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (d *DeadlineWriter) Flush() {
+	d.extend()
+	d.rc.Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter
+func (d *DeadlineWriter) Unwrap() http.ResponseWriter {
+	return d.ResponseWriter
+}
+
 func ECDSAPubkeyToPEM(key *ecdsa.PublicKey) []byte {
 	derBytes, err := x509.MarshalPKIXPublicKey(key)
 	if err != nil {
@@ -0,0 +1,503 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"notary/sha256_midstate"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+func TestConstantTimeEqual(t *testing.T) {
+	f := func(a, b []byte) bool {
+		return ConstantTimeEqual(a, b) == bytes.Equal(a, b) && ConstantTimeEqual(a, a)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+	if ConstantTimeEqual([]byte{1, 2}, []byte{1, 2, 3}) {
+		t.Error("slices of different lengths must not be equal")
+	}
+}
+
+func TestBytesToBitsRoundTrip(t *testing.T) {
+	f := func(b []byte) bool {
+		bits := BytesToBits(b)
+		return len(bits) == len(b)*8 && bytes.Equal(BitsToBytes(bits), b)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAppendBitsMatchesBytesToBits(t *testing.T) {
+	f := func(prefix []byte, b []byte) bool {
+		dst := BytesToBits(prefix)
+		appended := AppendBits(dst, b)
+		expected := append(BytesToBits(prefix), BytesToBits(b)...)
+		if len(appended) != len(expected) {
+			return false
+		}
+		for i := range expected {
+			if appended[i] != expected[i] {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBitsToBytesRoundTrip(t *testing.T) {
+	// bit arrays of any length, not only multiples of 8
+	f := func(raw []bool) bool {
+		bits := make([]int, len(raw))
+		for i, v := range raw {
+			if v {
+				bits[i] = 1
+			}
+		}
+		b := BitsToBytes(bits)
+		if len(b) != (len(bits)+7)/8 {
+			return false
+		}
+		back := BytesToBits(b)
+		// the padding bits must be zero
+		for _, v := range back[len(bits):] {
+			if v != 0 {
+				return false
+			}
+		}
+		for i := range bits {
+			if back[i] != bits[i] {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBitsToBytesLeastBitFirst(t *testing.T) {
+	if !bytes.Equal(BitsToBytes([]int{1, 0, 0, 0, 0, 0, 0, 0, 0, 1}), []byte{2, 1}) {
+		t.Error("unexpected bit order")
+	}
+	if len(BitsToBytes(nil)) != 0 || len(BytesToBits(nil)) != 0 {
+		t.Error("empty input must give empty output")
+	}
+}
+
+func TestBitsToBytesInvalidBit(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+	BitsToBytes([]int{0, 2})
+}
+
+func TestReverse(t *testing.T) {
+	f := func(s []int) bool {
+		r := Reverse(s)
+		if len(r) != len(s) {
+			return false
+		}
+		for i := range s {
+			if r[i] != s[len(s)-1-i] {
+				return false
+			}
+		}
+		// reversing twice gives the original and the input is not modified
+		rr := Reverse(r)
+		for i := range s {
+			if rr[i] != s[i] {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSplitIntoChunks(t *testing.T) {
+	f := func(data []byte, size uint8) bool {
+		chunkSize := int(size%32) + 1
+		data = data[:len(data)-len(data)%chunkSize]
+		chunks := SplitIntoChunks(data, chunkSize)
+		if len(chunks) != len(data)/chunkSize {
+			return false
+		}
+		for _, c := range chunks {
+			if len(c) != chunkSize {
+				return false
+			}
+		}
+		return bytes.Equal(Concat(chunks...), data)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSplitIntoChunksInvalidSize(t *testing.T) {
+	for _, tc := range []struct {
+		data      []byte
+		chunkSize int
+	}{
+		{[]byte{1, 2, 3}, 2},
+		{[]byte{1, 2}, 0},
+		{[]byte{1, 2}, -1},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected a panic for len %d chunk size %d", len(tc.data), tc.chunkSize)
+				}
+			}()
+			SplitIntoChunks(tc.data, tc.chunkSize)
+		}()
+	}
+}
+
+func TestValidateP256Point(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateP256Point(key.X, key.Y); err != nil {
+		t.Error("valid point rejected:", err)
+	}
+	p := elliptic.P256().Params().P
+	for name, point := range map[string][2]*big.Int{
+		"identity":     {big.NewInt(0), big.NewInt(0)},
+		"not on curve": {key.X, new(big.Int).Add(key.Y, big.NewInt(1))},
+		"unreduced x":  {new(big.Int).Add(key.X, p), key.Y},
+	} {
+		if ValidateP256Point(point[0], point[1]) == nil {
+			t.Errorf("%s: invalid point accepted", name)
+		}
+	}
+}
+
+func TestCheckNotModified(t *testing.T) {
+	etag := ETag([]byte("key"))
+	if etag == ETag([]byte("other key")) {
+		t.Error("different data must have different ETags")
+	}
+	for _, tc := range []struct {
+		ifNoneMatch string
+		notModified bool
+	}{
+		{"", false},
+		{`"stale"`, false},
+		{etag, true},
+		{`"stale", W/` + etag, true},
+		{"*", true},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", tc.ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		if CheckNotModified(w, req, etag, "no-cache") != tc.notModified {
+			t.Errorf("If-None-Match %q: expected %v", tc.ifNoneMatch, tc.notModified)
+		}
+		if tc.notModified && w.Code != http.StatusNotModified {
+			t.Errorf("If-None-Match %q: expected 304, got %d", tc.ifNoneMatch, w.Code)
+		}
+		if w.Header().Get("ETag") != etag || w.Header().Get("Cache-Control") != "no-cache" {
+			t.Error("missing cache headers")
+		}
+	}
+}
+
+func TestDeadlineWriter(t *testing.T) {
+	chunk := bytes.Repeat([]byte{1}, 64*1024)
+	const chunks = 5
+	errs := make(chan error, chunks)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// without the extensions, this deadline expires mid-response
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+		dw := NewDeadlineWriter(w, 200*time.Millisecond)
+		for i := 0; i < chunks; i++ {
+			_, err := dw.Write(chunk)
+			errs <- err
+			dw.Flush()
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body bytes.Buffer
+	if _, err = body.ReadFrom(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < chunks; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if body.Len() != chunks*len(chunk) {
+		t.Errorf("expected %d bytes, got %d", chunks*len(chunk), body.Len())
+	}
+}
+
+func TestAESGCMencryptParts(t *testing.T) {
+	key := GetRandom(16)
+	parts := [][]byte{GetRandom(100), nil, GetRandom(33)}
+	ct := AESGCMencryptParts(key, parts...)
+	if len(ct) != 12+133+16 {
+		t.Fatalf("unexpected ciphertext size %d", len(ct))
+	}
+	if !bytes.Equal(AESGCMdecrypt(key, ct), Concat(parts...)) {
+		t.Error("round trip mismatch")
+	}
+}
+
+func TestChunkedAEAD(t *testing.T) {
+	key := GetRandom(16)
+	for _, size := range []int{0, 1, CHUNKED_AEAD_CHUNK_SIZE, CHUNKED_AEAD_CHUNK_SIZE + 1, 3*CHUNKED_AEAD_CHUNK_SIZE - 7} {
+		plaintext := GetRandom(size)
+		var out bytes.Buffer
+		w, err := NewChunkedAEADWriter(&out, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// write in pieces which don't align with the chunks
+		for o := 0; o < size; o += 1000 {
+			end := o + 1000
+			if end > size {
+				end = size
+			}
+			if _, err = w.Write(plaintext[o:end]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if out.Len() != ChunkedAEADSize(size) {
+			t.Errorf("size %d: expected %d bytes, got %d", size, ChunkedAEADSize(size), out.Len())
+		}
+		decrypted, err := ChunkedAEADDecrypt(key, out.Bytes())
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("size %d: round trip mismatch", size)
+		}
+	}
+}
+
+func TestChunkedAEADTampering(t *testing.T) {
+	key := GetRandom(16)
+	var out bytes.Buffer
+	w, _ := NewChunkedAEADWriter(&out, key)
+	w.Write(GetRandom(3 * CHUNKED_AEAD_CHUNK_SIZE))
+	w.Close()
+	data := out.Bytes()
+	sealedChunk := CHUNKED_AEAD_CHUNK_SIZE + 16
+
+	// dropping the last chunk makes the previous one the last
+	if _, err := ChunkedAEADDecrypt(key, data[:len(data)-sealedChunk]); err == nil {
+		t.Error("truncation not detected")
+	}
+	// swapping two chunks
+	swapped := Concat(data[:chunkedAEADPrefixSize],
+		data[chunkedAEADPrefixSize+sealedChunk:chunkedAEADPrefixSize+2*sealedChunk],
+		data[chunkedAEADPrefixSize:chunkedAEADPrefixSize+sealedChunk],
+		data[chunkedAEADPrefixSize+2*sealedChunk:])
+	if _, err := ChunkedAEADDecrypt(key, swapped); err == nil {
+		t.Error("reordering not detected")
+	}
+	flipped := Concat(data)
+	flipped[len(flipped)-1] ^= 1
+	if _, err := ChunkedAEADDecrypt(key, flipped); err == nil {
+		t.Error("modification not detected")
+	}
+}
+
+// sequence returns a nextSeq function for AEADFrameWriter
+func sequence(start uint64) func() uint64 {
+	return func() uint64 {
+		start++
+		return start - 1
+	}
+}
+
+func TestAEADFrames(t *testing.T) {
+	key := GetRandom(16)
+	for _, size := range []int{0, 1, CHUNKED_AEAD_CHUNK_SIZE, 2*CHUNKED_AEAD_CHUNK_SIZE + 5} {
+		plaintext := GetRandom(size)
+		var out bytes.Buffer
+		w, err := NewAEADFrameWriter(&out, key, sequence(7))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(plaintext)
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if out.Len() != AEADFramesSize(size) {
+			t.Errorf("size %d: expected %d bytes, got %d", size, AEADFramesSize(size), out.Len())
+		}
+		r, _ := NewAEADFrameReader(&out, key)
+		// read in small pieces to exercise incremental decryption
+		var decrypted bytes.Buffer
+		if _, err = io.CopyBuffer(&decrypted, r, make([]byte, 1000)); err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(decrypted.Bytes(), plaintext) {
+			t.Errorf("size %d: round trip mismatch", size)
+		}
+		frames := uint64(AEADFramesSize(size)-size) / (AEAD_FRAME_HEADER_SIZE + 16)
+		if r.LastSeq() != 7+frames-1 {
+			t.Errorf("size %d: unexpected last sequence number %d", size, r.LastSeq())
+		}
+	}
+}
+
+func TestAEADFramesTampering(t *testing.T) {
+	key := GetRandom(16)
+	var out bytes.Buffer
+	w, _ := NewAEADFrameWriter(&out, key, sequence(0))
+	w.Write(GetRandom(2 * CHUNKED_AEAD_CHUNK_SIZE))
+	w.Close()
+	data := out.Bytes()
+	frame := AEAD_FRAME_HEADER_SIZE + CHUNKED_AEAD_CHUNK_SIZE + 16
+
+	decrypt := func(data []byte) error {
+		r, _ := NewAEADFrameReader(bytes.NewReader(data), key)
+		_, err := io.ReadAll(r)
+		return err
+	}
+	if err := decrypt(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := decrypt(data[:frame]); err == nil {
+		t.Error("truncation not detected")
+	}
+	if err := decrypt(Concat(data[frame:], data[:frame])); err == nil {
+		t.Error("reordering not detected")
+	}
+	// claiming that the first frame is the last one
+	marked := Concat(data[:frame])
+	marked[0] |= 0x80
+	if err := decrypt(marked); err == nil {
+		t.Error("forged last frame not detected")
+	}
+	replayed := Concat(data[:frame], data[:frame])
+	if err := decrypt(replayed); err == nil {
+		t.Error("replayed frame not detected")
+	}
+}
+
+func TestAEADFramesSize(t *testing.T) {
+	key := GetRandom(16)
+	plaintext := GetRandom(3*AEAD_FRAME_MIN_SIZE + 1)
+	var out bytes.Buffer
+	w, err := NewAEADFrameWriterSize(&out, key, sequence(0), AEAD_FRAME_MIN_SIZE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(plaintext)
+	w.Close()
+	if out.Len() != AEADFramesSizeN(len(plaintext), AEAD_FRAME_MIN_SIZE) {
+		t.Errorf("expected %d bytes, got %d", AEADFramesSizeN(len(plaintext), AEAD_FRAME_MIN_SIZE), out.Len())
+	}
+	r, _ := NewAEADFrameReaderSize(bytes.NewReader(out.Bytes()), key, AEAD_FRAME_MIN_SIZE)
+	decrypted, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("round trip failed: %v", err)
+	}
+	if r.LastSeq() != 3 {
+		t.Errorf("expected 4 frames, last sequence number %d", r.LastSeq())
+	}
+
+	// frames larger than the reader's max are rejected
+	out.Reset()
+	w, _ = NewAEADFrameWriterSize(&out, key, sequence(0), 2*AEAD_FRAME_MIN_SIZE)
+	w.Write(plaintext)
+	w.Close()
+	r, _ = NewAEADFrameReaderSize(&out, key, AEAD_FRAME_MIN_SIZE)
+	if _, err = io.ReadAll(r); err == nil {
+		t.Error("oversized frame accepted")
+	}
+	if _, err = NewAEADFrameWriterSize(&out, key, sequence(0), AEAD_FRAME_MAX_SIZE+1); err == nil {
+		t.Error("frame size above AEAD_FRAME_MAX_SIZE accepted")
+	}
+}
+
+// midstate returns the sha256 state after processing one 64-byte block
+func midstate(block []byte) []byte {
+	d := sha256_midstate.New()
+	d.Write(block)
+	state, _ := d.State()
+	return state
+}
+
+func FuzzFinishHash(f *testing.F) {
+	f.Add(make([]byte, 64), []byte{})
+	f.Add(bytes.Repeat([]byte{0x5c}, 64), make([]byte, 32))
+	f.Fuzz(func(t *testing.T, block []byte, data []byte) {
+		if len(block) < 64 {
+			block = append(block, make([]byte, 64-len(block))...)
+		}
+		block = block[:64]
+		expected := sha256.Sum256(Concat(block, data))
+		if !bytes.Equal(FinishHash(midstate(block), data), expected[:]) {
+			t.Error("FinishHash mismatch")
+		}
+	})
+}
+
+// TestECDSASignDeterministic uses the P-256 SHA-256 vectors from RFC 6979
+// appendix A.2.5
+func TestECDSASignDeterministic(t *testing.T) {
+	d, _ := new(big.Int).SetString("C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721", 16)
+	priv := &ecdsa.PrivateKey{D: d}
+	priv.Curve = elliptic.P256()
+	priv.X, priv.Y = priv.Curve.ScalarBaseMult(d.Bytes())
+
+	for _, tc := range []struct {
+		message   string
+		signature string
+	}{
+		{
+			"sample",
+			"EFD48B2AACB6A8FD1140DD9CD45E81D69D2C877B56AAF991C34D0EA84EAF3716" +
+				"F7CB1C942D657C41D436C7A1B6E29F65F3E900DBB9AFF4064DC4AB2F843ACDA8",
+		},
+		{
+			"test",
+			"F1ABB023518351CD71D881567B1EA663ED3EFCF6C5132B354F28D3B0B7D38367" +
+				"019F4113742A2B14BD25926B49C649155F267E60D3814B4C0CC84250E46F0083",
+		},
+	} {
+		signature := ECDSASignDeterministic(priv, []byte(tc.message))
+		if !strings.EqualFold(hex.EncodeToString(signature), tc.signature) {
+			t.Errorf("wrong signature for %q", tc.message)
+		}
+	}
+}
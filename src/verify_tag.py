@@ -9,7 +9,9 @@ sys.path.insert(0, libLocation)
 
 from tlslite import AESGCM_2PC, Rijndael
 from tlslite.constants import *
+import hmac
 import json
+import struct
 
 def intToHex(intarray):
     hexString = ""
@@ -42,42 +44,51 @@ def mpcHexToTlsliteHex(input):
     intArray.reverse()
     return reverseEndianness(intToHex(intArray))
 
-help = "Usage: python3 verify_tag.py <powersofh_share_2file> <encrypted_iv_share2file> <ciphertextFile> <aad> <tagshare>"
-if (len(sys.argv)) <= 5:
-    print(help)
+# the inputs are read as JSON from stdin:
+# {"powersOfHShare": "<lines of bits>", "records": [{"encryptedIvShare":
+#  "<bits>", "ciphertext": "<hex>", "aad": "<hex>", "lengthBlock": "<hex>",
+#  "tagShare": "<decimal>"}, ...]}
+# and the verdict is written as JSON to stdout: {"verified": true|false},
+# true only if the tag of every record is valid
+if len(sys.argv) > 1:
+    print("Usage: python3 verify_tag.py < inputs.json", file=sys.stderr)
     exit(-1)
 
-powersofh_share_2file = sys.argv[1]
-encrypted_iv_share2file = sys.argv[2]
-ciphertextFile = sys.argv[3]
-aad = hexToBytes(sys.argv[4])
-tagshare = int(sys.argv[5])
+inputs = json.load(sys.stdin)
 
 powersofh_share_2 = []
-encrypted_iv_share_2 = ""
-aes_key_share_2 = ""
+for line in inputs["powersOfHShare"].splitlines():
+    powersofh_share_2.append(reverseEndianness(hex(int(line, 2))[2:].zfill(32)))
 
-with open(powersofh_share_2file, 'r') as f:
-    lines = f.readlines()
-    for line in lines:
-        powersofh_share_2.append(reverseEndianness(hex(int(line, 2))[2:].zfill(32)))
+# NOTE: I am just instantiating these objects to get access to the _ghash function. What we should do, is just extract the logic of _ghash and discard the dead code
+dummy_key = intToBytes(["0","0","0","0","0","0","0","0","0","0","0","0","0","0","0","0"]) # this is not used given our 2PC tag verification process
+aesGCM_2PC_2 = AESGCM_2PC(dummy_key, "python", Rijndael(dummy_key, 16).encrypt, powersofh_share_2)
 
-with open(encrypted_iv_share2file, 'r') as f:
-    lines = f.readlines()
-    encrypted_iv_share_2 = hexToBytes(mpcHexToTlsliteHex(hex(int(lines[0],2))[2:]))
+def verifyRecord(record):
+    aad = hexToBytes(record["aad"])
+    tagshare = int(record["tagShare"])
+    encrypted_iv_share_2 = hexToBytes(mpcHexToTlsliteHex(hex(int(record["encryptedIvShare"].splitlines()[0], 2))[2:]))
 
-with open(ciphertextFile, 'r') as f:
-    ciphertext = intToBytes(json.load(f))
+    ciphertext = hexToBytes(record["ciphertext"])
+    tag = ciphertext[-16:]
+    ciphertextTrimmed = ciphertext[:-16]
 
+    # _ghash appends the length block of the lengths it is given, which must
+    # be the length block the notary signs
+    lengthBlock = struct.pack(">QQ", len(aad) * 8, len(ciphertextTrimmed) * 8)
+    if lengthBlock != bytes(hexToBytes(record["lengthBlock"])):
+        print("length block mismatch", file=sys.stderr)
+        exit(-1)
 
-tag = ciphertext[-16:]
-ciphertextTrimmed = ciphertext[:-16]
+    partial_ghash_output_2 = aesGCM_2PC_2._ghash(ciphertextTrimmed, aad)
 
-# NOTE: I am just instantiating these objects to get access to the _ghash function. What we should do, is just extract the logic of _ghash and discard the dead code
-dummy_key = intToBytes(["0","0","0","0","0","0","0","0","0","0","0","0","0","0","0","0"]) # this is not used given our 2PC tag verification process
-aesGCM_2PC_2 = AESGCM_2PC(dummy_key, "python", Rijndael(dummy_key, 16).encrypt, powersofh_share_2)
-partial_ghash_output_2 = aesGCM_2PC_2._ghash(ciphertextTrimmed, aad)
+    expected_tag = tagshare ^ int.from_bytes(encrypted_iv_share_2, "big") ^ int.from_bytes(partial_ghash_output_2, "big")
+    # compare in constant time to avoid leaking how much of the tag matched
+    return hmac.compare_digest(bytes(tag), expected_tag.to_bytes(16, "big"))
 
-verification_result = int.from_bytes(tag,"big") == tagshare ^ int.from_bytes(encrypted_iv_share_2, "big") ^ int.from_bytes(partial_ghash_output_2, "big")
+verification_result = True
+for record in inputs["records"]:
+    # every record is checked, so the time doesn't tell which one failed
+    verification_result = verifyRecord(record) and verification_result
 
-assert(verification_result)
+json.dump({"verified": verification_result}, sys.stdout)
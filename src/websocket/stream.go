@@ -0,0 +1,60 @@
+package websocket
+
+import (
+	"net"
+	"time"
+)
+
+// STREAM_MESSAGE_SIZE is the max size of the messages sent by a stream
+const STREAM_MESSAGE_SIZE = 1 << 20
+
+// streamConn carries a byte stream in binary messages, see NetConn
+type streamConn struct {
+	*Conn
+	// pending is the unread rest of the latest message
+	pending []byte
+}
+
+// NetConn returns a net.Conn whose bytes are carried in the binary messages
+// of c. Message boundaries carry no meaning. Other messages are protocol
+// errors.
+func NetConn(c *Conn) net.Conn {
+	return &streamConn{Conn: c}
+}
+
+func (s *streamConn) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		opcode, data, err := s.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		if opcode != OP_BINARY {
+			s.CloseWithStatus(CLOSE_UNSUPPORTED_DATA)
+			return 0, ErrProtocol
+		}
+		s.pending = data
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+func (s *streamConn) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n := min(len(p)-written, STREAM_MESSAGE_SIZE)
+		if err := s.WriteMessage(OP_BINARY, p[written:written+n]); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+func (s *streamConn) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *streamConn) SetDeadline(t time.Time) error {
+	return s.conn.SetDeadline(t)
+}
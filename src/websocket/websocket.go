@@ -0,0 +1,292 @@
+// Package websocket is a minimal server side of the WebSocket protocol
+// (RFC 6455): the opening handshake, fragmented messages, ping/pong and the
+// closing handshake. Extensions and subprotocols are not supported.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The opcodes of the frames
+const (
+	OP_CONTINUATION = 0x0
+	OP_TEXT         = 0x1
+	OP_BINARY       = 0x2
+	OP_CLOSE        = 0x8
+	OP_PING         = 0x9
+	OP_PONG         = 0xa
+)
+
+// The status codes of close frames
+const (
+	CLOSE_NORMAL           = 1000
+	CLOSE_PROTOCOL_ERROR   = 1002
+	CLOSE_UNSUPPORTED_DATA = 1003
+	CLOSE_TOO_BIG          = 1009
+	CLOSE_TRY_AGAIN_LATER  = 1013
+)
+
+// MAX_MESSAGE_SIZE is the max size of a message received from the client
+const MAX_MESSAGE_SIZE = 32 << 20
+
+// acceptGUID is appended to the client's key in the handshake
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// controlWriteTimeout is the write deadline of pongs and close frames
+const controlWriteTimeout = 10 * time.Second
+
+var (
+	ErrHandshake = errors.New("websocket: invalid handshake")
+	ErrProtocol  = errors.New("websocket: protocol error")
+	ErrTooLarge  = errors.New("websocket: message too large")
+)
+
+// Conn is a WebSocket connection. One goroutine may read while another one
+// writes.
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	// writeMutex serializes the frames written by WriteMessage and those
+	// written while reading (pongs and close frames)
+	writeMutex sync.Mutex
+}
+
+// Upgrade performs the opening handshake of a WebSocket request and takes
+// over its connection. If the request is not a valid WebSocket request, the
+// error response is written and ErrHandshake is returned.
+func Upgrade(w http.ResponseWriter, req *http.Request) (*Conn, error) {
+	key := req.Header.Get("Sec-WebSocket-Key")
+	decodedKey, err := base64.StdEncoding.DecodeString(key)
+	if req.Method != http.MethodGet || !hasToken(req.Header, "Connection", "upgrade") ||
+		!hasToken(req.Header, "Upgrade", "websocket") || err != nil || len(decodedKey) != 16 {
+		http.Error(w, "not a websocket handshake", http.StatusBadRequest)
+		return nil, ErrHandshake
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, ErrHandshake
+	}
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// the server's deadlines were meant for the HTTP request
+	conn.SetDeadline(time.Time{})
+	if rw.Reader.Buffered() > 0 {
+		// the client must wait for the handshake before it sends frames
+		conn.Close()
+		return nil, ErrHandshake
+	}
+	_, err = conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n\r\n"))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{conn: conn, br: bufio.NewReader(conn)}, nil
+}
+
+// AcceptKey returns the Sec-WebSocket-Accept value for the client's
+// Sec-WebSocket-Key
+func AcceptKey(key string) string {
+	digest := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
+
+// hasToken tells whether the comma separated header contains token, case
+// insensitive
+func hasToken(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, candidate := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(candidate), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RemoteAddr returns the address of the client
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetReadDeadline sets the deadline for receiving the next message
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for sending messages
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// ReadMessage returns the next text or binary message. Pings are answered
+// while reading. When the client closes the connection, the close frame is
+// answered and io.EOF returned. After protocol errors the connection is
+// closed with the matching status.
+func (c *Conn) ReadMessage() (opcode byte, data []byte, err error) {
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			switch err {
+			case ErrProtocol:
+				c.CloseWithStatus(CLOSE_PROTOCOL_ERROR)
+			case ErrTooLarge:
+				c.CloseWithStatus(CLOSE_TOO_BIG)
+			}
+			return 0, nil, err
+		}
+		switch op {
+		case OP_PING:
+			c.writeControl(OP_PONG, payload)
+			continue
+		case OP_PONG:
+			continue
+		case OP_CLOSE:
+			c.writeControl(OP_CLOSE, closeStatus(payload))
+			c.conn.Close()
+			return 0, nil, io.EOF
+		case OP_CONTINUATION:
+			if opcode == 0 {
+				c.CloseWithStatus(CLOSE_PROTOCOL_ERROR)
+				return 0, nil, ErrProtocol
+			}
+		case OP_TEXT, OP_BINARY:
+			if opcode != 0 {
+				// a new message before the last one ended
+				c.CloseWithStatus(CLOSE_PROTOCOL_ERROR)
+				return 0, nil, ErrProtocol
+			}
+			opcode = op
+		default:
+			c.CloseWithStatus(CLOSE_PROTOCOL_ERROR)
+			return 0, nil, ErrProtocol
+		}
+		if len(data)+len(payload) > MAX_MESSAGE_SIZE {
+			c.CloseWithStatus(CLOSE_TOO_BIG)
+			return 0, nil, ErrTooLarge
+		}
+		data = append(data, payload...)
+		if fin {
+			return opcode, data, nil
+		}
+	}
+}
+
+// readFrame reads one frame and unmasks its payload
+func (c *Conn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0f
+	// no extensions were negotiated, so the reserved bits must be 0, and
+	// clients must mask their frames
+	if header[0]&0x70 != 0 || header[1]&0x80 == 0 {
+		return false, 0, nil, ErrProtocol
+	}
+	size := uint64(header[1] & 0x7f)
+	isControl := opcode&0x8 != 0
+	if isControl && (size > 125 || !fin) {
+		return false, 0, nil, ErrProtocol
+	}
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if size > MAX_MESSAGE_SIZE {
+		return false, 0, nil, ErrTooLarge
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, size)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// WriteMessage sends a message in one frame
+func (c *Conn) WriteMessage(opcode byte, data []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.writeFrame(opcode, data)
+}
+
+// writeFrame writes an unmasked frame. The write mutex must be held.
+func (c *Conn) writeFrame(opcode byte, data []byte) error {
+	header := []byte{0x80 | opcode, 0}
+	switch {
+	case len(data) <= 125:
+		header[1] = byte(len(data))
+	case len(data) <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(len(data)))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(len(data)))
+	}
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// writeControl writes a control frame with a short deadline
+func (c *Conn) writeControl(opcode byte, data []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(controlWriteTimeout))
+	return c.writeFrame(opcode, data)
+}
+
+// CloseWithStatus sends a close frame with the status and closes the
+// connection without waiting for the client's close frame
+func (c *Conn) CloseWithStatus(status uint16) error {
+	c.writeControl(OP_CLOSE, binary.BigEndian.AppendUint16(nil, status))
+	return c.conn.Close()
+}
+
+// Close closes the connection normally
+func (c *Conn) Close() error {
+	return c.CloseWithStatus(CLOSE_NORMAL)
+}
+
+// closeStatus returns the status code of a close frame's payload
+func closeStatus(payload []byte) []byte {
+	if len(payload) > 2 {
+		return payload[:2]
+	}
+	return payload
+}
@@ -0,0 +1,206 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// RFC 6455 section 1.3
+func TestAcceptKey(t *testing.T) {
+	if got := AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatal("wrong accept key", got)
+	}
+}
+
+// echoServer echoes every message back
+func echoServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := Upgrade(w, req)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			op, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(op, data)
+		}
+	}))
+}
+
+// dial performs the client side of the handshake
+func dial(t *testing.T, srv *httptest.Server) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\nConnection: Upgrade\r\n" +
+		"Upgrade: websocket\r\nSec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n\r\n"))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatal("unexpected status", resp.StatusCode)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != AcceptKey(key) {
+		t.Fatal("wrong accept header")
+	}
+	return conn, br
+}
+
+// writeClientFrame writes a masked frame
+func writeClientFrame(conn net.Conn, fin bool, opcode byte, payload []byte) {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, 0x80|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	conn.Write(frame)
+}
+
+// readServerFrame reads an unmasked frame
+func readServerFrame(t *testing.T, br *bufio.Reader) (byte, []byte) {
+	var header [2]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	if header[1]&0x80 != 0 {
+		t.Fatal("server frames must not be masked")
+	}
+	size := uint64(header[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(br, ext[:])
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(br, ext[:])
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatal(err)
+	}
+	return header[0] & 0x0f, payload
+}
+
+func TestEcho(t *testing.T) {
+	srv := echoServer(t)
+	defer srv.Close()
+	conn, br := dial(t, srv)
+	defer conn.Close()
+
+	for _, size := range []int{0, 10, 300, 70000} {
+		payload := bytes.Repeat([]byte{byte(size)}, size)
+		writeClientFrame(conn, true, OP_BINARY, payload)
+		op, got := readServerFrame(t, br)
+		if op != OP_BINARY || !bytes.Equal(got, payload) {
+			t.Fatal("echo mismatch for size", size)
+		}
+	}
+
+	// a fragmented message with a ping in between
+	writeClientFrame(conn, false, OP_TEXT, []byte("hello "))
+	writeClientFrame(conn, true, OP_PING, []byte("p"))
+	writeClientFrame(conn, true, OP_CONTINUATION, []byte("world"))
+	op, got := readServerFrame(t, br)
+	if op != OP_PONG || string(got) != "p" {
+		t.Fatal("expected pong")
+	}
+	op, got = readServerFrame(t, br)
+	if op != OP_TEXT || string(got) != "hello world" {
+		t.Fatal("fragmented message mismatch", string(got))
+	}
+
+	writeClientFrame(conn, true, OP_CLOSE, []byte{0x03, 0xe8})
+	op, got = readServerFrame(t, br)
+	if op != OP_CLOSE || !bytes.Equal(got, []byte{0x03, 0xe8}) {
+		t.Fatal("expected close echo")
+	}
+}
+
+func TestUnmaskedFrameIsRejected(t *testing.T) {
+	srv := echoServer(t)
+	defer srv.Close()
+	conn, br := dial(t, srv)
+	defer conn.Close()
+
+	conn.Write([]byte{0x80 | OP_BINARY, 1, 'x'})
+	op, got := readServerFrame(t, br)
+	if op != OP_CLOSE || binary.BigEndian.Uint16(got) != CLOSE_PROTOCOL_ERROR {
+		t.Fatal("expected a protocol error close")
+	}
+}
+
+func TestUpgradeRejectsPlainRequests(t *testing.T) {
+	srv := echoServer(t)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatal("unexpected status", resp.StatusCode)
+	}
+}
+
+func TestNetConn(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := Upgrade(w, req)
+		if err != nil {
+			return
+		}
+		stream := NetConn(conn)
+		defer stream.Close()
+		// read 8 bytes spread over messages, then answer with a large write
+		var buf [8]byte
+		if _, err = io.ReadFull(stream, buf[:]); err != nil {
+			return
+		}
+		stream.Write(bytes.Repeat(buf[:], STREAM_MESSAGE_SIZE/8+1))
+	}))
+	defer srv.Close()
+	conn, br := dial(t, srv)
+	defer conn.Close()
+
+	writeClientFrame(conn, true, OP_BINARY, []byte("abc"))
+	writeClientFrame(conn, true, OP_BINARY, []byte("defgh"))
+	want := bytes.Repeat([]byte("abcdefgh"), STREAM_MESSAGE_SIZE/8+1)
+	op, first := readServerFrame(t, br)
+	if op != OP_BINARY || len(first) != STREAM_MESSAGE_SIZE {
+		t.Fatal("the write was not split into messages")
+	}
+	_, second := readServerFrame(t, br)
+	if !bytes.Equal(append(first, second...), want) {
+		t.Fatal("stream mismatch")
+	}
+}
@@ -0,0 +1,191 @@
+package zkey
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"notary/api_error"
+	"notary/utils"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// contributionsFile is the name of the file with the contribution hashes of
+// the setup, e.g. as printed by `snarkjs zkey verify`. It is returned as is
+// if it contains valid JSON.
+const contributionsFile = "contributions.json"
+
+// ZkeySetupHandler serves the trusted setup artifacts (Powers of Tau files,
+// transcripts) of each zkey. The artifacts of zkey N are in the dir
+// <zkeyDir>/setup/N. Nothing is loaded into memory: files are streamed from
+// disk and hashed on the first request.
+type ZkeySetupHandler struct {
+	setupDir string
+	// hashes caches the sha256 of artifacts
+	hashes map[string]artifactHash
+	sync.Mutex
+}
+
+type artifactHash struct {
+	modTime time.Time
+	size    int64
+	sha256  string
+}
+
+type setupArtifact struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+type setupResponse struct {
+	Size          int             `json:"size,omitempty"`
+	Artifacts     []setupArtifact `json:"artifacts,omitempty"`
+	Contributions json.RawMessage `json:"contributions,omitempty"`
+	Error         string          `json:"error,omitempty"`
+}
+
+func NewZkeySetupHandler(zkeyDir string) *ZkeySetupHandler {
+	return &ZkeySetupHandler{
+		setupDir: filepath.Join(zkeyDir, "setup"),
+		hashes:   make(map[string]artifactHash),
+	}
+}
+
+// GetSetup lists the setup artifacts of a zkey with their hashes. With the
+// file query param it returns the artifact itself.
+func (h *ZkeySetupHandler) GetSetup(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		api_error.Write(w, http.StatusMethodNotAllowed, api_error.METHOD_NOT_ALLOWED, "")
+		return
+	}
+
+	size, err := strconv.Atoi(req.URL.Query().Get("size"))
+	if err != nil || size < 1 {
+		api_error.Write(w, http.StatusBadRequest, api_error.INVALID_REQUEST, "")
+		return
+	}
+	dir := filepath.Join(h.setupDir, strconv.Itoa(size))
+
+	if name := req.URL.Query().Get("file"); name != "" {
+		h.serveArtifact(w, req, dir, name)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := new(setupResponse)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		response.Error = fmt.Sprintf("no setup artifacts for size %d", size)
+		body, _ := json.Marshal(response)
+		api_error.SetCode(w, api_error.NOT_FOUND)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(body)
+		return
+	}
+
+	response.Size = size
+	response.Artifacts = []setupArtifact{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		artifact, err := h.describe(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		response.Artifacts = append(response.Artifacts, *artifact)
+		if entry.Name() == contributionsFile {
+			contributions, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+			if err == nil && json.Valid(contributions) {
+				response.Contributions = contributions
+			}
+		}
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		log.Println(err)
+		api_error.Write(w, http.StatusInternalServerError, api_error.INTERNAL, "")
+		return
+	}
+	w.Write(body)
+}
+
+// serveArtifact streams a single artifact from disk
+func (h *ZkeySetupHandler) serveArtifact(w http.ResponseWriter, req *http.Request, dir string, name string) {
+	// only plain file names, no paths
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		api_error.Write(w, http.StatusBadRequest, api_error.INVALID_REQUEST, "")
+		return
+	}
+	file, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		api_error.Write(w, http.StatusNotFound, api_error.NOT_FOUND, "")
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		api_error.Write(w, http.StatusNotFound, api_error.NOT_FOUND, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", name))
+	artifact, err := h.describe(filepath.Join(dir, name))
+	if err != nil {
+		api_error.Write(w, http.StatusNotFound, api_error.NOT_FOUND, "")
+		return
+	}
+	// setup artifacts never change, the ETag is their hash
+	w.Header().Set("ETag", `"`+artifact.Sha256+`"`)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	// Powers of Tau files can be GBs
+	http.ServeContent(utils.NewDeadlineWriter(w, utils.StreamIdleTimeout), req, name, info.ModTime(), file)
+}
+
+// describe returns the size and sha256 of an artifact. The hash is computed
+// by streaming the file and cached until the file changes.
+func (h *ZkeySetupHandler) describe(path string) (*setupArtifact, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	h.Lock()
+	cached, ok := h.hashes[path]
+	h.Unlock()
+	if !ok || cached.size != info.Size() || !cached.modTime.Equal(info.ModTime()) {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		hash := sha256.New()
+		if _, err = io.Copy(hash, file); err != nil {
+			return nil, err
+		}
+		cached = artifactHash{
+			modTime: info.ModTime(),
+			size:    info.Size(),
+			sha256:  hex.EncodeToString(hash.Sum(nil)),
+		}
+		h.Lock()
+		h.hashes[path] = cached
+		h.Unlock()
+	}
+	return &setupArtifact{
+		Name:   filepath.Base(path),
+		Size:   cached.size,
+		Sha256: cached.sha256,
+	}, nil
+}
@@ -1,21 +1,37 @@
 package zkey
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"notary/api_error"
+	"notary/utils"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// zkeyCacheControl lets caches keep keys for a day. The keys only change
+// when the notary is restarted with new keys, which changes their ETags.
+const zkeyCacheControl = "public, max-age=86400"
+
 type ZkeyHttpHandler struct {
 	provingKeys   map[int][]byte
 	verifyingKeys map[int][]byte
+	// etags are the ETags of each key pair
+	etags map[int]string
 
 	lastModified time.Time
 }
@@ -56,6 +72,7 @@ func NewZkeyHandler(zkeyDir string) (*ZkeyHttpHandler, error) {
 	handler := new(ZkeyHttpHandler)
 	handler.provingKeys = make(map[int][]byte)
 	handler.verifyingKeys = make(map[int][]byte)
+	handler.etags = make(map[int]string)
 	handler.lastModified = time.Now()
 
 	for keyName, keyCount := range keyCounter {
@@ -77,38 +94,55 @@ func NewZkeyHandler(zkeyDir string) (*ZkeyHttpHandler, error) {
 
 		handler.provingKeys[keyName] = pkey
 		handler.verifyingKeys[keyName] = vkey
+		handler.etags[keyName] = utils.ETag(pkey, vkey)
 	}
 
 	log.Printf("Loaded %d ZK key pairs\n", len(handler.provingKeys))
 	return handler, nil
 }
 
+// VerifyingKey returns the verifying key of the given size in snarkjs JSON
+// format
+func (h *ZkeyHttpHandler) VerifyingKey(size int) ([]byte, bool) {
+	vkey, ok := h.verifyingKeys[size]
+	return vkey, ok
+}
+
+// Sizes returns the supported AES block counts in ascending order
+func (h *ZkeyHttpHandler) Sizes() []int {
+	keys := make([]int, 0, len(h.provingKeys))
+	for k := range h.provingKeys {
+		keys = append(keys, k)
+	}
+	// sorted, so that the ETag doesn't depend on the map order
+	sort.Ints(keys)
+	return keys
+}
+
 type supportedBlockSizeResponse struct {
 	Sizes []int `json:"sizes"`
 }
 
 func (h *ZkeyHttpHandler) GetSupportedBlockSizes(w http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		api_error.Write(w, http.StatusMethodNotAllowed, api_error.METHOD_NOT_ALLOWED, "")
 		return
 	}
 
-	keys := make([]int, 0, len(h.provingKeys))
-	for k := range h.provingKeys {
-		keys = append(keys, k)
-	}
-
 	response := new(supportedBlockSizeResponse)
-	response.Sizes = keys
+	response.Sizes = h.Sizes()
 
 	body, err := json.Marshal(response)
 	if err != nil {
 		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		api_error.Write(w, http.StatusInternalServerError, api_error.INTERNAL, "")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	if utils.CheckNotModified(w, req, utils.ETag(body), "public, max-age=300") {
+		return
+	}
 	w.Write(body)
 }
 
@@ -119,39 +153,73 @@ type getKeysResponse struct {
 	Error string `json:"error,omitempty"`
 }
 
-func splitBytesIntoChunks(data []byte, chunkSize int) [][]byte {
-	dataLen := len(data)
-	numChunks := (dataLen + chunkSize - 1) / chunkSize // Calculate the number of chunks needed
+// flushWriter flushes after every write so that the response is sent in
+// chunks as it is produced
+type flushWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+}
 
-	chunks := make([][]byte, numChunks)
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if f.flusher != nil {
+		f.flusher.Flush()
+	}
+	return n, err
+}
 
-	for i := 0; i < numChunks; i++ {
-		start := i * chunkSize
-		end := (i + 1) * chunkSize
-		if end > dataLen {
-			end = dataLen
+// writeKeysJSON streams the same JSON as json.Marshal(getKeysResponse) would
+// produce, without building the (tens of MB) body in memory
+func writeKeysJSON(w io.Writer, size int, pkey []byte, vkey []byte) error {
+	// encode in chunks of 8KB, with a flush after each chunk
+	buffered := bufio.NewWriterSize(w, 8192)
+	writeBase64 := func(data []byte) error {
+		encoder := base64.NewEncoder(base64.StdEncoding, buffered)
+		if _, err := encoder.Write(data); err != nil {
+			return err
 		}
-		chunks[i] = data[start:end]
+		return encoder.Close()
+	}
+	if _, err := buffered.WriteString(`{"pk":"`); err != nil {
+		return err
+	}
+	if err := writeBase64(pkey); err != nil {
+		return err
 	}
+	if _, err := buffered.WriteString(`","vk":"`); err != nil {
+		return err
+	}
+	if err := writeBase64(vkey); err != nil {
+		return err
+	}
+	if _, err := buffered.WriteString(`","size":` + strconv.Itoa(size) + `}`); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
 
-	return chunks
+// keysJSONLength is the length of the output of writeKeysJSON
+func keysJSONLength(size int, pkey []byte, vkey []byte) int {
+	return len(`{"pk":"`) + base64.StdEncoding.EncodedLen(len(pkey)) +
+		len(`","vk":"`) + base64.StdEncoding.EncodedLen(len(vkey)) +
+		len(`","size":`) + len(strconv.Itoa(size)) + len(`}`)
 }
 
 func (h *ZkeyHttpHandler) GetKeys(w http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		api_error.Write(w, http.StatusMethodNotAllowed, api_error.METHOD_NOT_ALLOWED, "")
 		return
 	}
 
 	sizeStr := req.URL.Query().Get("size")
 	if sizeStr == "" {
-		w.WriteHeader(http.StatusBadRequest)
+		api_error.Write(w, http.StatusBadRequest, api_error.INVALID_REQUEST, "")
 		return
 	}
 
 	desiredSize, err := strconv.Atoi(sizeStr)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		api_error.Write(w, http.StatusBadRequest, api_error.INVALID_REQUEST, "")
 		return
 	}
 
@@ -163,10 +231,11 @@ func (h *ZkeyHttpHandler) GetKeys(w http.ResponseWriter, req *http.Request) {
 		response.Error = fmt.Sprintf("no keys of size %d", desiredSize)
 		body, err := json.Marshal(response)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			api_error.Write(w, http.StatusInternalServerError, api_error.INTERNAL, "")
 			return
 		}
 
+		api_error.SetCode(w, api_error.NOT_FOUND)
 		w.WriteHeader(http.StatusNotFound)
 		w.Write(body)
 		return
@@ -178,44 +247,126 @@ func (h *ZkeyHttpHandler) GetKeys(w http.ResponseWriter, req *http.Request) {
 		response.Error = fmt.Sprintf("no keys of size %d", desiredSize)
 		body, err := json.Marshal(response)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			api_error.Write(w, http.StatusInternalServerError, api_error.INTERNAL, "")
 			return
 		}
 
+		api_error.SetCode(w, api_error.NOT_FOUND)
 		w.WriteHeader(http.StatusNotFound)
 		w.Write(body)
 		return
 	}
 
-	response.Pk = pkey
-	response.Vk = vkey
-	response.Size = desiredSize
+	format := negotiateFormat(req)
+	// each representation of the key pair has its own ETag
+	etag := strings.TrimSuffix(h.etags[desiredSize], `"`) + fmt.Sprintf("-%d-%s\"", format, req.URL.Query().Get("part"))
+	if utils.CheckNotModified(w, req, etag, zkeyCacheControl) {
+		return
+	}
+	// the keys are tens of MB, keep the connection open while they download
+	w = utils.NewDeadlineWriter(w, utils.StreamIdleTimeout)
+
+	switch format {
+	case formatMultipart:
+		h.writeMultipart(w, desiredSize, pkey, vkey)
+		return
+	case formatRaw:
+		h.writeRaw(w, req, desiredSize, pkey, vkey)
+		return
+	}
 
 	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Vary", "Accept")
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("Last-Modified", h.lastModified.UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT"))
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"zkey-%d.json\"", desiredSize))
+	w.Header().Set("Content-Length", strconv.Itoa(keysJSONLength(desiredSize, pkey, vkey)))
 
-	body, err := json.Marshal(response)
+	flusher, _ := w.(http.Flusher)
+	err = writeKeysJSON(&flushWriter{w: w, flusher: flusher}, desiredSize, pkey, vkey)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		log.Println("GetKeys:", err)
 	}
+}
 
-	w.Header().Set("x-content-length", fmt.Sprintf("%d", len(body)))
+const (
+	// formatJSON is the JSON envelope with base64-encoded keys used by the
+	// browser extension
+	formatJSON = iota
+	// formatMultipart returns both keys as parts of a multipart/mixed body
+	formatMultipart
+	// formatRaw returns one key as application/octet-stream, the proving
+	// key unless the query param part=vk is set
+	formatRaw
+)
 
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		// response writer doesn't support flushing, write the whole response in one go
-		w.Write(body)
-		return
+// negotiateFormat picks the response format of GetKeys from the Accept
+// header. JSON is the default so that existing clients keep working.
+func negotiateFormat(req *http.Request) int {
+	for _, accepted := range req.Header.Values("Accept") {
+		for _, item := range strings.Split(accepted, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(item))
+			if err != nil || params["q"] == "0" {
+				continue
+			}
+			switch mediaType {
+			case "multipart/mixed":
+				return formatMultipart
+			case "application/octet-stream":
+				return formatRaw
+			}
+		}
 	}
+	return formatJSON
+}
 
-	chunks := splitBytesIntoChunks(body, 8192)
+func (h *ZkeyHttpHandler) setCommonHeaders(w http.ResponseWriter) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Vary", "Accept")
+}
+
+func (h *ZkeyHttpHandler) writeMultipart(w http.ResponseWriter, size int, pkey []byte, vkey []byte) {
+	h.setCommonHeaders(w)
+	w.Header().Set("Last-Modified", h.lastModified.UTC().Format(http.TimeFormat))
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+
+	parts := []struct {
+		contentType string
+		filename    string
+		data        []byte
+	}{
+		{"application/octet-stream", fmt.Sprintf("%d.zkey", size), pkey},
+		{"application/json", fmt.Sprintf("%d.json", size), vkey},
+	}
+	for _, p := range parts {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Type", p.contentType)
+		header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", p.filename))
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if _, err = part.Write(p.data); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+	mw.Close()
+}
 
-	for _, chunk := range chunks {
-		w.Write(chunk)
-		flusher.Flush() // flushing will trigger chunked encoding
+func (h *ZkeyHttpHandler) writeRaw(w http.ResponseWriter, req *http.Request, size int, pkey []byte, vkey []byte) {
+	h.setCommonHeaders(w)
+	name := fmt.Sprintf("%d.zkey", size)
+	data := pkey
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if req.URL.Query().Get("part") == "vk" {
+		name = fmt.Sprintf("%d.json", size)
+		data = vkey
+		w.Header().Set("Content-Type", "application/json")
 	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", name))
+	// ServeContent also supports range requests for resuming downloads
+	http.ServeContent(w, req, name, h.lastModified, bytes.NewReader(data))
 }